@@ -0,0 +1,339 @@
+package foxi
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// Codepage is the code page mark stored in byte 29 of the table header,
+// the language driver of the table.
+type Codepage uint8
+
+// Code page marks written by Visual FoxPro
+const (
+	CodepageNone  Codepage = 0x00 // No mark, strings are not translated
+	Codepage437   Codepage = 0x01 // U.S. MS-DOS
+	Codepage620   Codepage = 0x69 // Mazovia (Polish) MS-DOS
+	Codepage737   Codepage = 0x6A // Greek MS-DOS (437G)
+	Codepage850   Codepage = 0x02 // International MS-DOS
+	Codepage852   Codepage = 0x64 // Eastern European MS-DOS
+	Codepage857   Codepage = 0x6B // Turkish MS-DOS
+	Codepage861   Codepage = 0x67 // Icelandic MS-DOS
+	Codepage865   Codepage = 0x66 // Nordic MS-DOS
+	Codepage866   Codepage = 0x65 // Russian MS-DOS
+	Codepage874   Codepage = 0x7C // Thai Windows
+	Codepage895   Codepage = 0x68 // Kamenicky (Czech) MS-DOS
+	Codepage932   Codepage = 0x7B // Japanese Windows
+	Codepage936   Codepage = 0x7A // Chinese Simplified Windows
+	Codepage949   Codepage = 0x79 // Korean Windows
+	Codepage950   Codepage = 0x78 // Chinese Traditional Windows
+	Codepage1250  Codepage = 0xC8 // Eastern European Windows
+	Codepage1251  Codepage = 0xC9 // Russian Windows
+	Codepage1252  Codepage = 0x03 // Windows ANSI
+	Codepage1253  Codepage = 0xCB // Greek Windows
+	Codepage1254  Codepage = 0xCA // Turkish Windows
+	Codepage1255  Codepage = 0x7D // Hebrew Windows
+	Codepage1256  Codepage = 0x7E // Arabic Windows
+	Codepage1257  Codepage = 0xCC // Baltic Windows
+	Codepage10000 Codepage = 0x04 // Standard Macintosh
+	Codepage10006 Codepage = 0x98 // Greek Macintosh
+	Codepage10007 Codepage = 0x96 // Russian Macintosh
+	Codepage10029 Codepage = 0x97 // Macintosh EE
+)
+
+// codepages describes the known code page marks
+var codepages = map[Codepage]struct {
+	number int
+	name   string
+}{
+	Codepage437:   {437, "U.S. MS-DOS"},
+	Codepage620:   {620, "Mazovia (Polish) MS-DOS"},
+	Codepage737:   {737, "Greek MS-DOS"},
+	Codepage850:   {850, "International MS-DOS"},
+	Codepage852:   {852, "Eastern European MS-DOS"},
+	Codepage857:   {857, "Turkish MS-DOS"},
+	Codepage861:   {861, "Icelandic MS-DOS"},
+	Codepage865:   {865, "Nordic MS-DOS"},
+	Codepage866:   {866, "Russian MS-DOS"},
+	Codepage874:   {874, "Thai Windows"},
+	Codepage895:   {895, "Kamenicky (Czech) MS-DOS"},
+	Codepage932:   {932, "Japanese Windows"},
+	Codepage936:   {936, "Chinese Simplified Windows"},
+	Codepage949:   {949, "Korean Windows"},
+	Codepage950:   {950, "Chinese Traditional Windows"},
+	Codepage1250:  {1250, "Eastern European Windows"},
+	Codepage1251:  {1251, "Russian Windows"},
+	Codepage1252:  {1252, "Windows ANSI"},
+	Codepage1253:  {1253, "Greek Windows"},
+	Codepage1254:  {1254, "Turkish Windows"},
+	Codepage1255:  {1255, "Hebrew Windows"},
+	Codepage1256:  {1256, "Arabic Windows"},
+	Codepage1257:  {1257, "Baltic Windows"},
+	Codepage10000: {10000, "Standard Macintosh"},
+	Codepage10006: {10006, "Greek Macintosh"},
+	Codepage10007: {10007, "Russian Macintosh"},
+	Codepage10029: {10029, "Macintosh EE"},
+}
+
+// Number returns the code page number, 0 for CodepageNone and unknown marks.
+func (c Codepage) Number() int {
+	return codepages[c].number
+}
+
+// String returns the codepage description
+func (c Codepage) String() string {
+	if c == CodepageNone {
+		return "No Codepage"
+	}
+	info, ok := codepages[c]
+	if !ok {
+		return "Unknown Codepage"
+	}
+	return fmt.Sprintf("%s (%d)", info.name, info.number)
+}
+
+// Strings in character and memo fields are stored in the code page of the
+// table. They are decoded to UTF-8 when read and encoded back when written,
+// as are the string values tags are searched for, so index keys compare the
+// way they do in FoxPro. A table without a code page mark, or a field with
+// the NOCPTRANS (binary) flag, passes its bytes through unchanged.
+
+// doubleByteTable holds the characters of a double byte code page, in the
+// layout of codepage_tables.go
+type doubleByteTable struct {
+	single string          // Characters of bytes 0x80 to 0xFF
+	rows   map[byte]string // Characters of trail bytes 0x40 to 0xFE by lead byte
+}
+
+// charset translates between a code page and UTF-8
+type charset struct {
+	number int
+	high   []rune          // Characters of bytes 0x80 to 0xFF
+	rows   map[byte][]rune // Characters of double byte codes by lead byte
+	codes  map[rune]uint16 // Code of each non-ASCII character
+}
+
+// charsets caches the charset of each code page number
+var charsets sync.Map
+
+// charsetFor returns the charset of a code page, nil for CodepageNone and
+// unknown marks
+func charsetFor(cp Codepage) *charset {
+	number := cp.Number()
+	if number == 0 {
+		return nil
+	}
+	if cs, ok := charsets.Load(number); ok {
+		return cs.(*charset)
+	}
+	cs := newCharset(number)
+	if cs == nil {
+		return nil
+	}
+	actual, _ := charsets.LoadOrStore(number, cs)
+	return actual.(*charset)
+}
+
+// newCharset builds the charset of a code page from its table
+func newCharset(number int) *charset {
+	cs := &charset{number: number, codes: make(map[rune]uint16)}
+	if table, ok := doubleByteTables[number]; ok {
+		cs.high = []rune(table.single)
+		cs.rows = make(map[byte][]rune, len(table.rows))
+		for lead, row := range table.rows {
+			cs.rows[lead] = []rune(row)
+		}
+	} else if table, ok := singleByteTables[number]; ok {
+		cs.high = []rune(table)
+	} else {
+		return nil
+	}
+
+	// The first code of a character wins when a code page has duplicates
+	for i, r := range cs.high {
+		if _, dup := cs.codes[r]; r != utf8.RuneError && !dup {
+			cs.codes[r] = uint16(0x80 + i)
+		}
+	}
+	for lead := 0x81; lead <= 0xFE; lead++ {
+		for i, r := range cs.rows[byte(lead)] {
+			if _, dup := cs.codes[r]; r != utf8.RuneError && !dup {
+				cs.codes[r] = uint16(lead<<8 | (0x40 + i))
+			}
+		}
+	}
+	return cs
+}
+
+// isASCII reports whether s holds only 7-bit characters, which every code
+// page shares with UTF-8
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= 0x80 {
+			return false
+		}
+	}
+	return true
+}
+
+// decode converts text in the code page to UTF-8
+func (cs *charset) decode(s string) string {
+	if isASCII(s) {
+		return s
+	}
+
+	var b strings.Builder
+	b.Grow(len(s) * 2)
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c < 0x80 {
+			b.WriteByte(c)
+			continue
+		}
+		if row := cs.rows[c]; row != nil && i+1 < len(s) && s[i+1] >= 0x40 && s[i+1] <= 0xFE {
+			b.WriteRune(row[s[i+1]-0x40])
+			i++
+			continue
+		}
+		b.WriteRune(cs.high[c-0x80])
+	}
+	return b.String()
+}
+
+// encode converts UTF-8 text to the code page, failing on characters the
+// code page does not have
+func (cs *charset) encode(s string) (string, error) {
+	if isASCII(s) {
+		return s, nil
+	}
+
+	b := make([]byte, 0, len(s))
+	for _, r := range s {
+		if r < 0x80 {
+			b = append(b, byte(r))
+			continue
+		}
+		code, ok := cs.codes[r]
+		if !ok {
+			return "", fmt.Errorf("character %q is not in code page %d", r, cs.number)
+		}
+		if code > 0xFF {
+			b = append(b, byte(code>>8))
+		}
+		b = append(b, byte(code))
+	}
+	return string(b), nil
+}
+
+// textCodec holds the code page the strings of a table are translated with
+type textCodec struct {
+	override   Codepage // Set with SetCodepage, kept across Open and Close
+	overridden bool
+	codepage   Codepage // In effect for the open table
+	charset    *charset // nil when strings are passed through
+}
+
+// open selects the code page for a table with the given header mark
+func (t *textCodec) open(mark Codepage) {
+	if t.overridden {
+		mark = t.override
+	}
+	t.codepage, t.charset = mark, charsetFor(mark)
+}
+
+// close forgets the code page of the closed table
+func (t *textCodec) close() {
+	t.codepage, t.charset = CodepageNone, nil
+}
+
+// set overrides the header mark of the open table and of tables opened later
+func (t *textCodec) set(cp Codepage, active bool) error {
+	if cp != CodepageNone && charsetFor(cp) == nil {
+		return fmt.Errorf("unsupported codepage: 0x%02X", byte(cp))
+	}
+	t.override, t.overridden = cp, true
+	if active {
+		t.codepage, t.charset = cp, charsetFor(cp)
+	}
+	return nil
+}
+
+// decode converts a stored string to UTF-8
+func (t *textCodec) decode(s string) string {
+	if t.charset == nil {
+		return s
+	}
+	return t.charset.decode(s)
+}
+
+// encode converts a UTF-8 string to the stored form
+func (t *textCodec) encode(s string) (string, error) {
+	if t.charset == nil {
+		return s, nil
+	}
+	return t.charset.encode(s)
+}
+
+// encodeField converts a UTF-8 string to the stored form of a field:
+// translated for character, varchar and memo fields, and checked against
+// the width of character, varchar and varbinary fields. Text longer than
+// the field fails rather than being cut, save for the trailing blanks of a
+// character field.
+func (t *textCodec) encodeField(field Field, s string) (string, error) {
+	stored := s
+	if translated(field) && t.charset != nil {
+		var err error
+		if stored, err = t.charset.encode(s); err != nil {
+			return "", fmt.Errorf("field %s: %w", field.Name(), err)
+		}
+	}
+
+	size := int(field.Size())
+	switch field.Type() {
+	case FTCharacter:
+		if len(stored) > size && strings.TrimRight(stored[size:], " ") == "" {
+			stored = stored[:size]
+		}
+	case FTVarchar, FTVarBinary:
+	default:
+		return stored, nil
+	}
+	if len(stored) > size {
+		return "", fmt.Errorf("value too long for field %s: %d bytes, the field holds %d", field.Name(), len(stored), size)
+	}
+	return stored, nil
+}
+
+// translated reports whether a field's strings are translated: character,
+// varchar and memo fields without the NOCPTRANS flag
+func translated(field Field) bool {
+	switch field.Type() {
+	case FTCharacter, FTVarchar, FTMemo:
+		return !field.IsBinary()
+	}
+	return false
+}
+
+// Codepage returns the code page the table's strings are translated with:
+// the mark in the header unless overridden with SetCodepage.
+func (f *Foxi) Codepage() Codepage {
+	return f.impl.textCodec().codepage
+}
+
+// SetCodepage overrides the code page mark in the header, for tables
+// written in one code page and marked with another. CodepageNone turns
+// translation off, so strings are read and written as raw bytes. The
+// override stays in effect for tables opened later by this Foxi.
+func (f *Foxi) SetCodepage(cp Codepage) error {
+	return f.impl.textCodec().set(cp, f.impl.Active())
+}
+
+// MustSetCodepage overrides the code page mark in the header, panicking on
+// error.
+func (f *Foxi) MustSetCodepage(cp Codepage) {
+	if err := f.SetCodepage(cp); err != nil {
+		panic(err)
+	}
+}
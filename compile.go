@@ -0,0 +1,152 @@
+package foxi
+
+import (
+	"fmt"
+
+	"github.com/mkfoss/foxi/expr"
+)
+
+// Compile compiles an xBase expression against the fields of an open
+// table (see package expr for the syntax). The program reads the current
+// record of f each time it is evaluated, so one compiled expression serves
+// as a filter, a computed column or an index key while the table moves:
+//
+//	prog, err := foxi.Compile(f, `STATE = "CA" .AND. BALANCE > 1000`)
+//	for rec, err := range f.Records() {
+//		if ok, _ := prog.EvalBool(); ok { ... }
+//	}
+//
+// Fields may be qualified with the table's Alias, and the fields of the
+// tables related to it (see SetRelation) and of the other tables of its
+// Session are read with theirs, as null while that table is at EOF.
+// Character keys built with Key are encoded in the table's code page, and
+// dates are shown as text in the session's date format.
+func Compile(f *Foxi, source string) (*expr.Program, error) {
+	if !f.Active() {
+		return nil, fmt.Errorf("database not open")
+	}
+	return expr.Compile(source, &tableEnv{f: f})
+}
+
+// MustCompile compiles an expression against an open table, panicking on
+// error.
+func MustCompile(f *Foxi, source string) *expr.Program {
+	prog, err := Compile(f, source)
+	if err != nil {
+		panic(err)
+	}
+	return prog
+}
+
+// tableEnv is the expression environment of an open table
+type tableEnv struct {
+	f *Foxi
+}
+
+// Column resolves a field of the table, qualified by its alias or not
+func (e *tableEnv) Column(alias, name string) (*expr.Column, bool) {
+	if alias != "" && alias != e.f.Alias() {
+		// A field of a table related to this one or of the session, null
+		// while that table is at EOF
+		child := relatedTable(e.f.impl, alias)
+		if child == nil && e.f.session != nil {
+			child = e.f.session.Table(alias)
+		}
+		if child == nil {
+			return nil, false
+		}
+		column, ok := (&tableEnv{f: child}).Column("", name)
+		if ok {
+			get := column.Get
+			column.Get = func() (any, error) {
+				if child.EOF() || child.BOF() {
+					return nil, nil
+				}
+				return get()
+			}
+		}
+		return column, ok
+	}
+	field := e.f.FieldByName(name)
+	if field == nil || field.IsSystem() {
+		return nil, false
+	}
+
+	column := exprColumn(field.Name(), field.Type(), int(field.Size()), int(field.Decimals()))
+
+	// The field is looked up again when the table has been reopened
+	fields := e.f.Fields()
+	column.Get = func() (any, error) {
+		if current := e.f.Fields(); current != fields {
+			fields, field = current, nil
+			if current != nil {
+				field = current.ByName(name)
+			}
+		}
+		if field == nil {
+			return nil, fmt.Errorf("no field %s in table", name)
+		}
+		return field.Value()
+	}
+	return column, true
+}
+
+// RecNo returns the current record number
+func (e *tableEnv) RecNo() int {
+	return e.f.Position()
+}
+
+// Deleted reports whether the current record is marked for deletion
+func (e *tableEnv) Deleted() bool {
+	return e.f.Deleted()
+}
+
+// EncodeKey encodes character keys in the code page of the table
+func (e *tableEnv) EncodeKey(s string) (string, error) {
+	return e.f.impl.textCodec().encode(s)
+}
+
+// DecodeKey decodes bytes in the code page of the table
+func (e *tableEnv) DecodeKey(raw string) string {
+	return e.f.impl.textCodec().decode(raw)
+}
+
+// DateFormat returns the date format of the table's session
+func (e *tableEnv) DateFormat() string {
+	if e.f.session != nil {
+		return e.f.session.DateFormat()
+	}
+	return expr.DefaultDateFormat
+}
+
+// exprColumn describes a field to the expressions referring to it
+func exprColumn(name string, ft FieldType, size, dec int) *expr.Column {
+	column := &expr.Column{
+		Name: name,
+		Type: exprType(ft),
+		Len:  size,
+		Dec:  dec,
+	}
+	switch ft {
+	case FTMemo, FTGeneral, FTPicture, FTTimestamp:
+		column.Len = 0 // The length of memo contents varies
+	}
+	return column
+}
+
+// exprType maps a field type onto the expression type of its values
+func exprType(ft FieldType) expr.Type {
+	switch ft {
+	case FTNumeric, FTFloat, FTCurrency, FTBlob, FTDouble:
+		return expr.Numeric
+	case FTInteger:
+		return expr.Integer
+	case FTDate:
+		return expr.Date
+	case FTDateTime:
+		return expr.DateTime
+	case FTLogical:
+		return expr.Logical
+	}
+	return expr.Character
+}
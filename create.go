@@ -0,0 +1,351 @@
+package foxi
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/mkfoss/foxi/expr"
+	pkg "github.com/mkfoss/foxi/pkg/gocore"
+)
+
+// FieldSpec describes a field of a table created with Create.
+//
+// Size is only used by the variable size types (character, varchar,
+// varbinary, numeric and float); every other type has a fixed size in
+// Visual FoxPro and Size is ignored. Decimals applies to numeric, float
+// and double fields.
+type FieldSpec struct {
+	Name     string    // Field name, at most 10 characters
+	Type     FieldType // Field type
+	Size     uint8     // Field width for C, V, Q, N and F fields
+	Decimals uint8     // Decimal places for N, F and B fields
+	Nullable bool      // Field accepts .NULL. values
+	Binary   bool      // Field is stored without code page translation (NOCPTRANS)
+}
+
+// TagSpec describes a tag of the production index created with Create.
+type TagSpec struct {
+	Name       string // Tag name, at most 10 characters
+	Expression string // Key expression, e.g. "UPPER(LAST_NAME)"
+	Filter     string // Optional FOR expression
+	Unique     bool   // Only the first record of each key is indexed
+	Descending bool   // Keys are ordered high to low
+}
+
+// Schema describes the structure of a new table.
+type Schema struct {
+	Fields []FieldSpec // Fields in record order
+	Tags   []TagSpec   // Tags of the production CDX, if any
+}
+
+// CreateOptions controls how Create writes a new table.
+type CreateOptions struct {
+	Overwrite bool     // Replace an existing table instead of failing
+	Codepage  Codepage // Code page mark written to the header (default 0x03, Windows ANSI)
+	BlockSize int      // Memo block size in bytes (default 64)
+}
+
+// Create writes a new Visual FoxPro table described by schema and opens it.
+//
+// The DBF is written together with an FPT memo file when the schema has
+// memo, general or blob fields, and with a production CDX holding the
+// schema's tags. A .dbf extension is added to path when it has none.
+// opts may be nil for the defaults.
+//
+// The files are produced by the pure Go engine regardless of the build
+// tags, so the returned table is opened with whichever backend is
+// compiled in.
+func Create(path string, schema Schema, opts *CreateOptions) (*Foxi, error) {
+	path, err := createTable(path, schema, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	f := NewFoxi()
+	if err := f.Open(path); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// createTable writes the files of a new table, returning the path of the
+// DBF
+func createTable(path string, schema Schema, opts *CreateOptions) (string, error) {
+	if opts == nil {
+		opts = &CreateOptions{}
+	}
+	if len(schema.Fields) == 0 {
+		return "", fmt.Errorf("schema has no fields")
+	}
+	if filepath.Ext(path) == "" {
+		path += ".dbf"
+	}
+
+	// The schema is checked up front, so a mistake is reported by what is
+	// wrong rather than by the error code of the engine
+	fieldInfo := make([]pkg.Field4Info, 0, len(schema.Fields))
+	env := fieldEnv{}
+	recordLen, nullBits := 1, 0 // Delete flag
+	for _, spec := range schema.Fields {
+		info, err := spec.field4Info()
+		if err != nil {
+			return "", err
+		}
+		name := strings.ToUpper(info.Name)
+		if env[name] != nil {
+			return "", fmt.Errorf("duplicate field name: %s", name)
+		}
+		env[name] = exprColumn(name, spec.Type, spec.width(), int(spec.Decimals))
+		fieldInfo = append(fieldInfo, info)
+
+		recordLen += spec.width()
+		if spec.Nullable {
+			nullBits++
+		}
+		if spec.Type == FTVarchar || spec.Type == FTVarBinary {
+			nullBits++
+		}
+	}
+	if recordLen += (nullBits + 7) / 8; recordLen > maxRecordLen {
+		return "", fmt.Errorf("records of %d bytes are longer than the limit of %d", recordLen, maxRecordLen)
+	}
+
+	tagInfo := make([]pkg.Tag4Info, 0, len(schema.Tags))
+	tagNames := make(map[string]bool, len(schema.Tags))
+	for _, spec := range schema.Tags {
+		if err := checkTag(env, spec); err != nil {
+			return "", err
+		}
+		name := strings.ToUpper(strings.TrimSpace(spec.Name))
+		if tagNames[name] {
+			return "", fmt.Errorf("duplicate tag name: %s", name)
+		}
+		tagNames[name] = true
+
+		info := pkg.Tag4Info{
+			Name:       spec.Name,
+			Expression: spec.Expression,
+			Filter:     spec.Filter,
+		}
+		if spec.Unique {
+			info.Unique = 1
+		}
+		if spec.Descending {
+			info.Descending = 1
+		}
+		tagInfo = append(tagInfo, info)
+	}
+
+	if !opts.Overwrite {
+		if _, err := os.Stat(path); err == nil {
+			return "", fmt.Errorf("table already exists: %s", path)
+		}
+	}
+	if opts.BlockSize < 0 || opts.BlockSize > 0xFFFF {
+		return "", fmt.Errorf("invalid memo block size: %d", opts.BlockSize)
+	}
+
+	codeBase := &pkg.Code4{}
+	pkg.Code4Init(codeBase)
+	defer pkg.Code4InitUndo(codeBase)
+	codeBase.Safety = 0
+	if opts.Codepage != 0 {
+		codePage := pkg.Code4CodePageNumber(byte(opts.Codepage))
+		if codePage == 0 {
+			return "", fmt.Errorf("unsupported codepage: 0x%02X", byte(opts.Codepage))
+		}
+		codeBase.CodePage = codePage
+	}
+	if opts.BlockSize > 0 {
+		codeBase.MemSizeMemo = uint16(opts.BlockSize)
+	}
+
+	data := pkg.D4CreateTags(codeBase, path, fieldInfo, tagInfo)
+	if data == nil {
+		return "", fmt.Errorf("failed to create table %s (error %d)", path, codeBase.ErrorCode)
+	}
+	pkg.D4Close(data)
+	return path, nil
+}
+
+// maxRecordLen is the longest record a Visual FoxPro table can hold
+const maxRecordLen = 65500
+
+// maxKeyLen is the longest key a compact CDX tag can hold
+const maxKeyLen = 240
+
+// field4Info converts a field spec into its creation info, checking its
+// name, size and decimals against the limits of Visual FoxPro
+func (spec FieldSpec) field4Info() (pkg.Field4Info, error) {
+	info := pkg.Field4Info{
+		Name:   strings.TrimSpace(spec.Name),
+		Length: uint16(spec.Size),
+		Dec:    uint16(spec.Decimals),
+	}
+	if !isName(info.Name) {
+		return info, fmt.Errorf("invalid field name %q: up to 10 letters, digits and underscores, starting with a letter", spec.Name)
+	}
+	if spec.Nullable {
+		info.Nulls = 1
+	}
+
+	switch spec.Type {
+	case FTCharacter:
+		info.Type = pkg.FieldTypeChar
+		if spec.Binary {
+			info.Type = pkg.FieldTypeCharBin
+		}
+	case FTVarchar:
+		info.Type = pkg.FieldTypeVarChar
+		if spec.Binary {
+			info.Type = pkg.FieldTypeVarBin
+		}
+	case FTVarBinary:
+		info.Type = pkg.FieldTypeVarBin
+	case FTNumeric:
+		info.Type = pkg.FieldTypeNumeric
+	case FTFloat:
+		info.Type = pkg.FieldTypeFloat
+	case FTDate:
+		info.Type = pkg.FieldTypeDate
+	case FTDateTime:
+		info.Type = pkg.FieldTypeDateTime
+	case FTLogical:
+		info.Type = pkg.FieldTypeLogical
+	case FTInteger:
+		info.Type = pkg.FieldTypeInteger
+	case FTCurrency:
+		info.Type = pkg.FieldTypeCurrency
+	case FTBlob, FTDouble:
+		info.Type = pkg.FieldTypeDouble
+	case FTMemo:
+		info.Type = pkg.FieldTypeMemo
+		if spec.Binary {
+			info.Type = pkg.FieldTypeMemoBin
+		}
+	case FTGeneral:
+		info.Type = pkg.FieldTypeGeneral
+	case FTTimestamp:
+		info.Type = pkg.FieldTypeBlob
+	default:
+		return info, fmt.Errorf("field %s: cannot create %s fields", info.Name, spec.Type.Name())
+	}
+
+	switch spec.Type {
+	case FTCharacter, FTVarchar, FTVarBinary:
+		if spec.Size < 1 || spec.Size > 254 {
+			return info, fmt.Errorf("field %s: %s fields are 1 to 254 bytes wide, not %d", info.Name, spec.Type.Name(), spec.Size)
+		}
+	case FTNumeric, FTFloat:
+		if spec.Size < 1 || spec.Size > 20 {
+			return info, fmt.Errorf("field %s: %s fields are 1 to 20 digits wide, not %d", info.Name, spec.Type.Name(), spec.Size)
+		}
+		// The decimals need the point and a digit before it
+		if spec.Decimals > 0 && int(spec.Decimals) > int(spec.Size)-2 {
+			return info, fmt.Errorf("field %s: %d decimals do not fit a width of %d", info.Name, spec.Decimals, spec.Size)
+		}
+	case FTBlob, FTDouble:
+		if spec.Decimals > 18 {
+			return info, fmt.Errorf("field %s: %s fields have at most 18 decimals, not %d", info.Name, spec.Type.Name(), spec.Decimals)
+		}
+	}
+	return info, nil
+}
+
+// width returns the bytes a field takes in the record
+func (spec FieldSpec) width() int {
+	switch spec.Type {
+	case FTCharacter, FTVarchar, FTVarBinary, FTNumeric, FTFloat:
+		return int(spec.Size)
+	case FTLogical:
+		return 1
+	case FTInteger, FTMemo, FTGeneral, FTTimestamp:
+		return 4
+	}
+	return 8 // Dates, datetimes, currency and doubles
+}
+
+// isName reports whether name is a valid field or tag name: up to 10
+// letters, digits and underscores, starting with a letter
+func isName(name string) bool {
+	if name == "" || len(name) > 10 {
+		return false
+	}
+	for i, r := range name {
+		switch {
+		case r >= 'A' && r <= 'Z', r >= 'a' && r <= 'z':
+		case (r == '_' || r >= '0' && r <= '9') && i > 0:
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// checkTag checks the name and expressions of a tag against the fields of
+// its table, so a tag that cannot be built is reported by its cause: a key
+// expression that does not compile, has no fixed length or is too long,
+// or a FOR expression that is not logical
+func checkTag(env expr.Env, spec TagSpec) error {
+	name := strings.TrimSpace(spec.Name)
+	if !isName(name) {
+		return fmt.Errorf("invalid tag name %q: up to 10 letters, digits and underscores, starting with a letter", spec.Name)
+	}
+	key, err := expr.Compile(spec.Expression, env)
+	if err != nil {
+		return fmt.Errorf("tag %s: key expression %s: %w", name, spec.Expression, err)
+	}
+	if key.Type() == expr.Character {
+		switch n := key.KeyLen(); {
+		case n < 1:
+			return fmt.Errorf("tag %s: key expression %s has no fixed length; wrap it in PADR(%s, n)", name, spec.Expression, spec.Expression)
+		case n > maxKeyLen:
+			return fmt.Errorf("tag %s: key expression %s is %d bytes long, longer than the limit of %d", name, spec.Expression, n, maxKeyLen)
+		}
+	}
+	if spec.Filter != "" {
+		filter, err := expr.Compile(spec.Filter, env)
+		if err != nil {
+			return fmt.Errorf("tag %s: FOR expression %s: %w", name, spec.Filter, err)
+		}
+		if filter.Type() != expr.Logical {
+			return fmt.Errorf("tag %s: FOR expression %s is not logical", name, spec.Filter)
+		}
+	}
+	return nil
+}
+
+// fieldEnv resolves the fields of a table by name for checking
+// expressions, without a record to evaluate them on
+type fieldEnv map[string]*expr.Column
+
+// Column returns the field with the given name, whatever the alias
+func (e fieldEnv) Column(_, name string) (*expr.Column, bool) {
+	column, ok := e[strings.ToUpper(name)]
+	return column, ok
+}
+
+// tableFieldEnv resolves the fields of an open table for checkTag
+func tableFieldEnv(impl foxiImpl) fieldEnv {
+	env := fieldEnv{}
+	for i := 0; i < impl.FieldCount(); i++ {
+		field := impl.Field(i)
+		if field != nil && !field.IsSystem() {
+			name := strings.ToUpper(field.Name())
+			env[name] = exprColumn(name, field.Type(), int(field.Size()), int(field.Decimals()))
+		}
+	}
+	return env
+}
+
+// RecNo returns 0, there being no current record
+func (e fieldEnv) RecNo() int {
+	return 0
+}
+
+// Deleted returns false, there being no current record
+func (e fieldEnv) Deleted() bool {
+	return false
+}
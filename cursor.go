@@ -0,0 +1,70 @@
+package foxi
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrReadOnly is returned when a cursor is asked to change its table.
+var ErrReadOnly = errors.New("cursor is read-only")
+
+// Cursor returns a read-only cursor over the open table for reading it
+// from another goroutine. The cursor has a record buffer, a record pointer
+// and a tag position of its own, so cursors move and read independently:
+//
+//	for range workers {
+//		cur := f.MustCursor()
+//		go func() {
+//			defer cur.Close()
+//			for rec, err := range cur.Records() { ... }
+//		}()
+//	}
+//
+// The pure Go backend shares the table's open files, field descriptions
+// and indexes with its cursors, and the index nodes they read are kept in
+// a block cache shared by them. CodeBase C is not safe for concurrent use
+// of one CODE4, so the CGO backend opens the table again for each cursor.
+//
+// A cursor starts unpositioned, in the order of the tag selected for the
+// table and under the table's alias. The table's filter, relations and
+// Session are not carried over, but a filter and relations can be set on
+// the cursor itself. The methods that change the table fail with
+// ErrReadOnly: Append, Write, Delete, Recall, the Field setters,
+// transactions, locks, Pack, Zap and tag maintenance.
+//
+// Each cursor must be used by one goroutine at a time, and the table must
+// not be written to while cursors read it. Close the cursors before the
+// table.
+func (f *Foxi) Cursor() (*Foxi, error) {
+	if !f.Active() {
+		return nil, fmt.Errorf("database not open")
+	}
+	impl, err := f.impl.cursor()
+	if err != nil {
+		return nil, err
+	}
+	cursor := &Foxi{impl: impl, alias: f.Alias()}
+
+	if tag := f.Indexes().SelectedTag(); tag != nil {
+		same := cursor.Indexes().TagByName(tag.Name())
+		if same == nil {
+			cursor.Close()
+			return nil, fmt.Errorf("tag not found in cursor: %s", tag.Name())
+		}
+		if err := cursor.Indexes().SelectTag(same); err != nil {
+			cursor.Close()
+			return nil, err
+		}
+	}
+	return cursor, nil
+}
+
+// MustCursor returns a read-only cursor over the open table.
+// Panics if the operation fails.
+func (f *Foxi) MustCursor() *Foxi {
+	cursor, err := f.Cursor()
+	if err != nil {
+		panic(err)
+	}
+	return cursor
+}
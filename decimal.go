@@ -0,0 +1,299 @@
+package foxi
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+
+	"github.com/mkfoss/foxi/internal/hook"
+)
+
+// Decimal is an exact decimal number: an unscaled integer and the number
+// of digits after the decimal point, so 12.50 is 1250 with scale 2. It
+// holds the digits of numeric fields and the int64 of currency fields
+// without the rounding of float64. The zero Decimal is 0 with scale 0.
+//
+// Decimal has no arithmetic; convert with Rat to compute and back with
+// DecimalFromRat.
+type Decimal struct {
+	unscaled *big.Int // nil for zero, never changed once set
+	scale    int
+}
+
+// NewDecimal returns unscaled / 10^scale. A negative scale multiplies by
+// 10^-scale and gives scale 0.
+func NewDecimal(unscaled int64, scale int) Decimal {
+	return newDecimal(big.NewInt(unscaled), scale)
+}
+
+// newDecimal returns unscaled / 10^scale, taking ownership of unscaled
+func newDecimal(unscaled *big.Int, scale int) Decimal {
+	if scale < 0 {
+		unscaled.Mul(unscaled, pow10(-scale))
+		scale = 0
+	}
+	return Decimal{unscaled: unscaled, scale: scale}
+}
+
+// pow10 returns 10^n
+func pow10(n int) *big.Int {
+	return new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(n)), nil)
+}
+
+// ParseDecimal parses a number written with an optional sign, digits and
+// an optional decimal point, such as "-1234.50" or ".5". Surrounding blanks
+// are ignored. The scale is the number of digits after the point.
+func ParseDecimal(s string) (Decimal, error) {
+	text := strings.TrimSpace(s)
+	digits := strings.TrimLeft(text, "+-")
+	if len(text)-len(digits) > 1 {
+		return Decimal{}, fmt.Errorf("invalid decimal %q", s)
+	}
+	whole, frac, _ := strings.Cut(digits, ".")
+	if whole+frac == "" || strings.Trim(whole+frac, "0123456789") != "" {
+		return Decimal{}, fmt.Errorf("invalid decimal %q", s)
+	}
+	unscaled, _ := new(big.Int).SetString(whole+frac, 10)
+	if text[0] == '-' {
+		unscaled.Neg(unscaled)
+	}
+	return Decimal{unscaled: unscaled, scale: len(frac)}, nil
+}
+
+// MustParseDecimal parses a number, panicking on error.
+func MustParseDecimal(s string) Decimal {
+	d, err := ParseDecimal(s)
+	if err != nil {
+		panic(err)
+	}
+	return d
+}
+
+// DecimalFromRat returns r with the given number of digits after the
+// decimal point. It fails when r needs more digits than that.
+func DecimalFromRat(r *big.Rat, scale int) (Decimal, error) {
+	if scale < 0 {
+		return Decimal{}, fmt.Errorf("negative decimal scale %d", scale)
+	}
+	num := new(big.Int).Mul(r.Num(), pow10(scale))
+	unscaled, rem := new(big.Int).QuoRem(num, r.Denom(), new(big.Int))
+	if rem.Sign() != 0 {
+		return Decimal{}, fmt.Errorf("%s has more than %d decimal places", r.RatString(), scale)
+	}
+	return Decimal{unscaled: unscaled, scale: scale}, nil
+}
+
+// Scale returns the number of digits after the decimal point.
+func (d Decimal) Scale() int {
+	return d.scale
+}
+
+// Unscaled returns the decimal times 10^Scale.
+func (d Decimal) Unscaled() *big.Int {
+	if d.unscaled == nil {
+		return new(big.Int)
+	}
+	return new(big.Int).Set(d.unscaled)
+}
+
+// Sign returns -1, 0 or +1 as the decimal is negative, zero or positive.
+func (d Decimal) Sign() int {
+	if d.unscaled == nil {
+		return 0
+	}
+	return d.unscaled.Sign()
+}
+
+// Rat returns the decimal as a rational number.
+func (d Decimal) Rat() *big.Rat {
+	return new(big.Rat).SetFrac(d.Unscaled(), pow10(d.scale))
+}
+
+// String returns the decimal with Scale digits after the point, such as
+// "-1234.50".
+func (d Decimal) String() string {
+	digits := d.Unscaled().String()
+	sign := ""
+	if digits[0] == '-' {
+		sign, digits = "-", digits[1:]
+	}
+	if d.scale == 0 {
+		return sign + digits
+	}
+	if len(digits) <= d.scale {
+		digits = strings.Repeat("0", d.scale-len(digits)+1) + digits
+	}
+	cut := len(digits) - d.scale
+	return sign + digits[:cut] + "." + digits[cut:]
+}
+
+// rescale returns the decimal with the given scale, rounding half away
+// from zero when digits are dropped
+func (d Decimal) rescale(scale int) Decimal {
+	unscaled := d.Unscaled()
+	switch {
+	case scale > d.scale:
+		unscaled.Mul(unscaled, pow10(scale-d.scale))
+	case scale < d.scale:
+		divisor := pow10(d.scale - scale)
+		quo, rem := unscaled.QuoRem(unscaled, divisor, new(big.Int))
+		if rem.Abs(rem).Lsh(rem, 1).Cmp(divisor) >= 0 {
+			quo.Add(quo, big.NewInt(int64(d.Sign())))
+		}
+		unscaled = quo
+	}
+	return Decimal{unscaled: unscaled, scale: scale}
+}
+
+// Both backends read and write decimals through the stored form of the
+// field, so numbers are converted the same way whichever backend is
+// compiled in.
+
+// storedDecimal decodes a numeric field to a Decimal. Numeric and float
+// fields keep the digits of their text and currency fields their four
+// decimal places; a blank or null number is zero with the field's scale.
+func storedDecimal(f storedField) (Decimal, error) {
+	if f.IsNullable() {
+		if null, err := f.IsNull(); err != nil || null {
+			return Decimal{scale: int(f.Decimals())}, err
+		}
+	}
+
+	raw := f.stored()
+	switch f.Type() {
+	case FTNumeric, FTFloat:
+		return decimalText(f, raw, int(f.Decimals()))
+	case FTCurrency:
+		if len(raw) != 8 {
+			return decimalText(f, raw, 4)
+		}
+		return NewDecimal(int64(binary.LittleEndian.Uint64(raw)), 4), nil
+	case FTInteger:
+		if len(raw) != 4 {
+			return decimalText(f, raw, 0)
+		}
+		return NewDecimal(int64(int32(binary.LittleEndian.Uint32(raw))), 0), nil
+	case FTBlob, FTDouble:
+		if len(raw) != 8 {
+			return decimalText(f, raw, int(f.Decimals()))
+		}
+		x := math.Float64frombits(binary.LittleEndian.Uint64(raw))
+		if math.IsInf(x, 0) || math.IsNaN(x) {
+			return Decimal{}, fmt.Errorf("field %s: %v is not a decimal", f.Name(), x)
+		}
+		return ParseDecimal(strconv.FormatFloat(x, 'f', -1, 64))
+	}
+	return Decimal{}, fmt.Errorf("cannot read %s field %s as a decimal", f.Type().Name(), f.Name())
+}
+
+// decimalText parses a number stored as text; a blank number is zero with
+// the given scale
+func decimalText(f Field, raw []byte, scale int) (Decimal, error) {
+	text := strings.TrimSpace(string(raw))
+	if text == "" {
+		return Decimal{scale: scale}, nil
+	}
+	d, err := ParseDecimal(text)
+	if err != nil {
+		return Decimal{}, fmt.Errorf("field %s: invalid number %q", f.Name(), text)
+	}
+	return d, nil
+}
+
+// floatDecimal returns the shortest decimal that reads back as x. NaN and
+// the infinities have no decimal and cannot be stored in a field.
+func floatDecimal(f Field, x float64) (Decimal, error) {
+	if math.IsNaN(x) || math.IsInf(x, 0) {
+		return Decimal{}, fmt.Errorf("cannot assign %v to field %s", x, f.Name())
+	}
+	return ParseDecimal(strconv.FormatFloat(x, 'f', -1, 64))
+}
+
+// textDecimal parses text assigned to a numeric field, accepting exponents
+// such as "1e5" besides the forms of ParseDecimal. It returns false for
+// blank text, which blanks the field.
+func textDecimal(f Field, text string) (Decimal, bool, error) {
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return Decimal{}, false, nil
+	}
+	if d, err := ParseDecimal(text); err == nil {
+		return d, true, nil
+	}
+	x, err := strconv.ParseFloat(text, 64)
+	if err != nil {
+		return Decimal{}, false, fmt.Errorf("invalid value %q for field %s", text, f.Name())
+	}
+	d, err := floatDecimal(f, x)
+	return d, err == nil, err
+}
+
+// decimalBytes encodes a Decimal in the stored form of a numeric field.
+// Digits beyond the field's decimals are rounded half away from zero; a
+// value too large for the field is an error, where CodeBase would fill
+// the field with asterisks.
+func decimalBytes(f Field, d Decimal) ([]byte, error) {
+	if err := checkSettable(f, "number"); err != nil {
+		return nil, err
+	}
+	size := int(f.Size())
+	overflow := func() error {
+		return fmt.Errorf("value %s overflows %s field %s", d, f.Type().Name(), f.Name())
+	}
+
+	switch f.Type() {
+	case FTCurrency:
+		if size != 8 {
+			return decimalField(d.rescale(4), size, overflow)
+		}
+		unscaled := d.rescale(4).unscaled
+		if !unscaled.IsInt64() {
+			return nil, overflow()
+		}
+		return binary.LittleEndian.AppendUint64(nil, uint64(unscaled.Int64())), nil
+	case FTInteger:
+		if size != 4 {
+			return decimalField(d.rescale(0), size, overflow)
+		}
+		unscaled := d.rescale(0).unscaled
+		if !unscaled.IsInt64() || unscaled.Int64() > math.MaxInt32 || unscaled.Int64() < math.MinInt32 {
+			return nil, overflow()
+		}
+		return binary.LittleEndian.AppendUint32(nil, uint32(int32(unscaled.Int64()))), nil
+	case FTBlob, FTDouble:
+		if size != 8 {
+			return decimalField(d.rescale(int(f.Decimals())), size, overflow)
+		}
+		x, _ := d.Rat().Float64()
+		return binary.LittleEndian.AppendUint64(nil, math.Float64bits(x)), nil
+	}
+	return decimalField(d.rescale(int(f.Decimals())), size, overflow)
+}
+
+// init lets sqldriver check values against numeric fields before it
+// changes a record
+func init() {
+	hook.CheckDecimal = func(field, d any) error {
+		_, err := decimalBytes(field.(Field), d.(Decimal))
+		return err
+	}
+}
+
+// decimalField right-aligns the text of a decimal in a field of the given
+// width, dropping the zero before the point of a fraction when that makes
+// it fit
+func decimalField(d Decimal, size int, overflow func() error) ([]byte, error) {
+	text := d.String()
+	if len(text) > size {
+		if short := strings.Replace(text, "0.", ".", 1); short != text && strings.HasPrefix(strings.TrimPrefix(text, "-"), "0.") {
+			text = short
+		}
+	}
+	if len(text) > size {
+		return nil, overflow()
+	}
+	return []byte(strings.Repeat(" ", size-len(text)) + text), nil
+}
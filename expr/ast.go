@@ -0,0 +1,237 @@
+package expr
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Node is a node of a parsed expression. The concrete types are *Literal,
+// *FieldRef, *Unary, *Binary and *Call; their result types are filled in
+// when the expression is compiled.
+type Node interface {
+	// Type returns the result type of the node.
+	Type() Type
+
+	// Len returns the length of a character result, the width of a
+	// numeric one.
+	Len() int
+
+	// Dec returns the decimal places of a numeric result.
+	Dec() int
+
+	// String returns the node in canonical expression syntax.
+	String() string
+
+	// Pos returns the offset of the node in the source text.
+	Pos() int
+
+	eval(ctx *context) (value, error)
+}
+
+// info holds the position and the result type of a node
+type info struct {
+	pos int
+	typ Type
+	len int
+	dec int
+}
+
+func (i *info) Type() Type { return i.typ }
+func (i *info) Len() int   { return i.len }
+func (i *info) Dec() int   { return i.dec }
+func (i *info) Pos() int   { return i.pos }
+
+// Literal is a constant: a string, float64, bool, time.Time or nil for
+// .NULL., as the literal's type.
+type Literal struct {
+	info
+	Value any
+	value value
+}
+
+// FieldRef refers to a field of the current record.
+type FieldRef struct {
+	info
+	Alias  string  // Upper case alias, empty when not qualified
+	Name   string  // Upper case field name
+	Column *Column // The resolved field, set when compiled
+}
+
+// Unary applies "-", "+" or ".NOT." to an operand.
+type Unary struct {
+	info
+	Op string
+	X  Node
+}
+
+// Binary applies an operator to two operands. Op is one of + - * / % ^
+// = == <> < > <= >= $ .AND. .OR., with != and # normalised to <> and **
+// to ^.
+type Binary struct {
+	info
+	Op   string
+	X, Y Node
+}
+
+// Call calls a function.
+type Call struct {
+	info
+	Name  string // Upper case name, abbreviations expanded
+	Args  []Node
+	fn    *function
+	dates string // Time layout of dates shown as text, see DateFormatter
+}
+
+// precedence returns the binding strength of a binary operator
+func precedence(op string) int {
+	switch op {
+	case ".OR.":
+		return 1
+	case ".AND.":
+		return 2
+	case "=", "==", "<>", "<", ">", "<=", ">=", "$":
+		return 4
+	case "+", "-":
+		return 5
+	case "*", "/", "%":
+		return 6
+	case "^":
+		return 7
+	}
+	return 0
+}
+
+// notPrecedence sits between .AND. and the comparisons
+const notPrecedence = 3
+
+// unaryPrecedence binds tighter than every binary operator
+const unaryPrecedence = 8
+
+// nodePrecedence returns the binding strength of a node in printed form
+func nodePrecedence(n Node) int {
+	switch n := n.(type) {
+	case *Binary:
+		return precedence(n.Op)
+	case *Unary:
+		if n.Op == ".NOT." {
+			return notPrecedence
+		}
+		return unaryPrecedence
+	}
+	return unaryPrecedence + 1
+}
+
+// operand prints a child, in parentheses when it binds looser than min
+func operand(n Node, min int) string {
+	if nodePrecedence(n) < min {
+		return "(" + n.String() + ")"
+	}
+	return n.String()
+}
+
+func (n *Literal) String() string {
+	if n.value.null {
+		return ".NULL."
+	}
+	switch n.value.typ {
+	case Character:
+		s := n.value.s
+		switch {
+		case !strings.Contains(s, `"`):
+			return `"` + s + `"`
+		case !strings.Contains(s, "'"):
+			return "'" + s + "'"
+		}
+		return "[" + s + "]"
+	case Logical:
+		if n.value.b {
+			return ".T."
+		}
+		return ".F."
+	case Date:
+		if n.value.t.IsZero() {
+			return "{}"
+		}
+		return n.value.t.Format("{^2006-01-02}")
+	case DateTime:
+		if n.value.t.IsZero() {
+			return "{/:}"
+		}
+		return n.value.t.Format("{^2006-01-02 15:04:05}")
+	}
+	return strconv.FormatFloat(n.value.n, 'f', -1, 64)
+}
+
+func (n *FieldRef) String() string {
+	if n.Alias != "" {
+		return n.Alias + "." + n.Name
+	}
+	return n.Name
+}
+
+func (n *Unary) String() string {
+	if n.Op == ".NOT." {
+		return ".NOT. " + operand(n.X, notPrecedence)
+	}
+	return n.Op + operand(n.X, unaryPrecedence)
+}
+
+func (n *Binary) String() string {
+	p := precedence(n.Op)
+	// Operators are left associative, so a right operand of the same
+	// precedence keeps its parentheses
+	return operand(n.X, p) + " " + n.Op + " " + operand(n.Y, p+1)
+}
+
+func (n *Call) String() string {
+	args := make([]string, len(n.Args))
+	for i, arg := range n.Args {
+		args[i] = arg.String()
+	}
+	return n.Name + "(" + strings.Join(args, ", ") + ")"
+}
+
+// newLiteral builds a constant node
+func newLiteral(pos int, v value) *Literal {
+	n := &Literal{info: info{pos: pos, typ: v.typ}, Value: v.toAny(), value: v}
+	switch v.typ {
+	case Character:
+		n.len = len([]rune(v.s))
+	case Numeric:
+		text := strconv.FormatFloat(v.n, 'f', -1, 64)
+		n.len = max(len(text), 10)
+		if dot := strings.IndexByte(text, '.'); dot >= 0 {
+			n.dec = len(text) - dot - 1
+		}
+	case Date:
+		n.len = 8
+	case DateTime:
+		n.len = 8
+	case Logical:
+		n.len = 1
+	}
+	return n
+}
+
+// constant returns the value of a constant node
+func constant(n Node) (value, bool) {
+	if lit, ok := n.(*Literal); ok {
+		return lit.value, true
+	}
+	return value{}, false
+}
+
+// constInt returns a numeric constant as an int, def when n is not one
+func constInt(n Node, def int) int {
+	if v, ok := constant(n); ok && v.typ == Numeric && !v.null {
+		return int(v.n)
+	}
+	return def
+}
+
+// Date values are kept at midnight UTC
+func midnight(t time.Time) time.Time {
+	y, m, d := t.Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+}
@@ -0,0 +1,277 @@
+package expr
+
+import (
+	"fmt"
+	"strings"
+)
+
+// check resolves the field references of a parsed tree and works out the
+// result type of every node, failing on operands of the wrong type
+func check(n Node, env Env) error {
+	switch n := n.(type) {
+	case *Literal:
+		return nil
+
+	case *FieldRef:
+		column, ok := env.Column(n.Alias, n.Name)
+		if !ok {
+			return fmt.Errorf("unknown field %s at offset %d", n, n.pos)
+		}
+		switch column.Type {
+		case Character, Numeric, Integer, Date, DateTime, Logical:
+		default:
+			return fmt.Errorf("field %s has unsupported type %s", n, column.Type)
+		}
+		n.Column = column
+		n.typ, n.len, n.dec = column.Type, column.Len, column.Dec
+		return nil
+
+	case *Unary:
+		if err := check(n.X, env); err != nil {
+			return err
+		}
+		return checkUnary(n)
+
+	case *Binary:
+		if err := check(n.X, env); err != nil {
+			return err
+		}
+		if err := check(n.Y, env); err != nil {
+			return err
+		}
+		return checkBinary(n)
+
+	case *Call:
+		for _, arg := range n.Args {
+			if err := check(arg, env); err != nil {
+				return err
+			}
+		}
+		n.dates = dateLayout(env)
+		return checkCall(n)
+	}
+	return fmt.Errorf("unexpected node %T", n)
+}
+
+// mismatch reports operands of the wrong type, in the words of Visual
+// FoxPro
+func mismatch(n Node) error {
+	return fmt.Errorf("operator/operand type mismatch at offset %d: %s", n.Pos(), n)
+}
+
+func checkUnary(n *Unary) error {
+	x := n.X.Type().family()
+	if n.Op == ".NOT." {
+		if x != Logical && x != Null {
+			return mismatch(n)
+		}
+		n.typ, n.len = Logical, 1
+		return nil
+	}
+	if x != Numeric && x != Null {
+		return mismatch(n)
+	}
+	n.typ, n.dec = Numeric, n.X.Dec()
+	n.len = numLen(numDigits(n.X), n.dec)
+	if n.Op == "-" {
+		n.len = numLen(numDigits(n.X)+1, n.dec)
+	}
+	return nil
+}
+
+// maxNumLen is the widest numeric result, that of an N(20) field, given
+// to results whose width cannot be bounded
+const maxNumLen = 20
+
+// numDigits returns the width of the integer part of a numeric operand,
+// its sign included. Integer fields hold up to 11 characters.
+func numDigits(n Node) int {
+	width := n.Len()
+	if n.Type() == Integer {
+		width = 11
+	}
+	if n.Dec() > 0 {
+		width -= n.Dec() + 1
+	}
+	return max(width, 1)
+}
+
+// numLen returns the width of a numeric result with an integer part of
+// the given width and dec decimal places, at most maxNumLen
+func numLen(digits, dec int) int {
+	if dec > 0 {
+		digits += dec + 1
+	}
+	return min(digits, maxNumLen)
+}
+
+//nolint:gocyclo // one case per operator and operand types
+func checkBinary(n *Binary) error {
+	x, y := n.X.Type().family(), n.Y.Type().family()
+	// A null constant takes the type the other operand needs
+	switch {
+	case x == Null && y == Null:
+	case x == Null:
+		x = y
+	case y == Null:
+		y = x
+	}
+
+	n.len, n.dec = max(n.X.Len(), n.Y.Len()), max(n.X.Dec(), n.Y.Dec())
+	switch n.Op {
+	case ".AND.", ".OR.":
+		if (x != Logical && x != Null) || (y != Logical && y != Null) {
+			return mismatch(n)
+		}
+		n.typ, n.len, n.dec = Logical, 1, 0
+		return nil
+
+	case "=", "==", "<>", "<", ">", "<=", ">=":
+		dates := (x == Date || x == DateTime) && (y == Date || y == DateTime)
+		if x != y && !dates {
+			return mismatch(n)
+		}
+		n.typ, n.len, n.dec = Logical, 1, 0
+		return nil
+
+	case "$":
+		if x != Character && x != Null || y != Character && y != Null {
+			return mismatch(n)
+		}
+		n.typ, n.len, n.dec = Logical, 1, 0
+		return nil
+
+	case "+":
+		switch {
+		case x == Character && y == Character:
+			n.typ, n.len, n.dec = Character, n.X.Len()+n.Y.Len(), 0
+		case x == Numeric && y == Numeric:
+			// One digit more than the wider operand for the carry
+			n.typ = Numeric
+			n.len = numLen(max(numDigits(n.X), numDigits(n.Y))+1, n.dec)
+		case (x == Date || x == DateTime) && y == Numeric:
+			n.typ, n.len, n.dec = x, 8, 0
+		case x == Numeric && (y == Date || y == DateTime):
+			n.typ, n.len, n.dec = y, 8, 0
+		case x == Null:
+			n.typ = Null
+		default:
+			return mismatch(n)
+		}
+		return nil
+
+	case "-":
+		switch {
+		case x == Character && y == Character:
+			n.typ, n.len, n.dec = Character, n.X.Len()+n.Y.Len(), 0
+		case x == Numeric && y == Numeric:
+			n.typ = Numeric
+			n.len = numLen(max(numDigits(n.X), numDigits(n.Y))+1, n.dec)
+		case x == Date && y == Date:
+			n.typ, n.dec = Numeric, 0
+		case x == DateTime && y == DateTime:
+			n.typ, n.dec = Numeric, 3
+		case (x == Date || x == DateTime) && y == Numeric:
+			n.typ, n.len, n.dec = x, 8, 0
+		case x == Null:
+			n.typ = Null
+		default:
+			return mismatch(n)
+		}
+		return nil
+
+	case "*", "/", "%", "^":
+		if x == Null {
+			n.typ = Null
+			return nil
+		}
+		if x != Numeric || y != Numeric {
+			return mismatch(n)
+		}
+		n.typ = Numeric
+		switch n.Op {
+		case "*":
+			// The digits of a product are those of the operands together
+			n.dec = n.X.Dec() + n.Y.Dec()
+			n.len = numLen(numDigits(n.X)+numDigits(n.Y), n.dec)
+		case "%":
+			// The remainder is smaller than the divisor
+			n.len = numLen(numDigits(n.Y), n.dec)
+		default:
+			// A quotient or power can be as wide as any number
+			n.len = maxNumLen
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown operator %s", n.Op)
+}
+
+// checkCall resolves the function of a call and checks its arguments
+func checkCall(n *Call) error {
+	fn, name := lookupFunction(n.Name)
+	if fn == nil {
+		return fmt.Errorf("unknown function %s at offset %d", n.Name, n.pos)
+	}
+	n.Name, n.fn = name, fn
+	if len(n.Args) < fn.minArgs || (fn.maxArgs >= 0 && len(n.Args) > fn.maxArgs) {
+		return fmt.Errorf("function %s: %s", name, arityText(fn.minArgs, fn.maxArgs, len(n.Args)))
+	}
+	if err := fn.check(n); err != nil {
+		return fmt.Errorf("function %s: %w", name, err)
+	}
+	return nil
+}
+
+// arityText describes a wrong number of arguments
+func arityText(minArgs, maxArgs, got int) string {
+	var want string
+	switch {
+	case minArgs == maxArgs:
+		want = fmt.Sprintf("%d", minArgs)
+	case maxArgs < 0:
+		want = fmt.Sprintf("at least %d", minArgs)
+	default:
+		want = fmt.Sprintf("%d to %d", minArgs, maxArgs)
+	}
+	plural := "s"
+	if want == "1" {
+		plural = ""
+	}
+	return fmt.Sprintf("takes %s argument%s, got %d", want, plural, got)
+}
+
+// typeNames names the type letters used in argument checks
+var typeNames = map[byte]string{
+	'C': "character", 'N': "numeric", 'D': "date", 'T': "datetime", 'L': "logical",
+}
+
+// checkArgs checks the arguments of a call against type specs, one per
+// argument: a letter such as "C", several letters such as "DT" for any of
+// them, or "*" for any type. The last spec covers any further arguments.
+// Null constants match every spec.
+func checkArgs(n *Call, specs ...string) error {
+	for i, arg := range n.Args {
+		spec := specs[min(i, len(specs)-1)]
+		typ := arg.Type().family()
+		if spec == "*" || typ == Null || strings.IndexByte(spec, byte(typ)) >= 0 {
+			continue
+		}
+		names := make([]string, len(spec))
+		for j := range spec {
+			names[j] = typeNames[spec[j]]
+		}
+		return fmt.Errorf("argument %d must be %s, not %s", i+1, strings.Join(names, " or "), typeName(typ))
+	}
+	return nil
+}
+
+// typeName names a type in error messages
+func typeName(t Type) string {
+	if name, ok := typeNames[byte(t.family())]; ok {
+		return name
+	}
+	if t == Null {
+		return "null"
+	}
+	return t.String()
+}
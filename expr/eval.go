@@ -0,0 +1,189 @@
+package expr
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+func (n *Literal) eval(*context) (value, error) {
+	return n.value, nil
+}
+
+func (n *FieldRef) eval(*context) (value, error) {
+	x, err := n.Column.Get()
+	if err != nil {
+		return value{}, fmt.Errorf("field %s: %w", n, err)
+	}
+	return fromAny(x, n.typ.family())
+}
+
+func (n *Unary) eval(ctx *context) (value, error) {
+	x, err := ctx.eval(n.X)
+	if err != nil || x.null {
+		return nullOf(n.typ), err
+	}
+	switch n.Op {
+	case ".NOT.":
+		return logical(!x.b), nil
+	case "-":
+		return num(-x.n), nil
+	}
+	return num(x.n), nil
+}
+
+func (n *Binary) eval(ctx *context) (value, error) {
+	x, err := ctx.eval(n.X)
+	if err != nil {
+		return value{}, err
+	}
+
+	// .AND. and .OR. stop as soon as the result is known; otherwise a null
+	// operand leaves it unknown
+	switch n.Op {
+	case ".AND.", ".OR.":
+		decided := n.Op == ".OR."
+		if !x.null && x.b == decided {
+			return logical(decided), nil
+		}
+		y, err := ctx.eval(n.Y)
+		if err != nil {
+			return value{}, err
+		}
+		if !y.null && y.b == decided {
+			return logical(decided), nil
+		}
+		if x.null || y.null {
+			return nullOf(Logical), nil
+		}
+		return logical(!decided), nil
+	}
+
+	y, err := ctx.eval(n.Y)
+	if err != nil {
+		return value{}, err
+	}
+	if x.null || y.null {
+		return nullOf(n.typ), nil
+	}
+
+	switch n.Op {
+	case "+", "-":
+		return arithmetic(n.Op, x, y)
+	case "*":
+		return num(x.n * y.n), nil
+	case "/":
+		if y.n == 0 {
+			return value{}, fmt.Errorf("division by zero in %s", n)
+		}
+		return num(x.n / y.n), nil
+	case "%":
+		if y.n == 0 {
+			return value{}, fmt.Errorf("division by zero in %s", n)
+		}
+		return num(mod(x.n, y.n)), nil
+	case "^":
+		return num(math.Pow(x.n, y.n)), nil
+	case "$":
+		return logical(x.s != "" && strings.Contains(y.s, x.s)), nil
+	case "==":
+		return logical(compare(x, y, true) == 0), nil
+	}
+
+	cmp := compare(x, y, false)
+	switch n.Op {
+	case "=":
+		return logical(cmp == 0), nil
+	case "<>":
+		return logical(cmp != 0), nil
+	case "<":
+		return logical(cmp < 0), nil
+	case ">":
+		return logical(cmp > 0), nil
+	case "<=":
+		return logical(cmp <= 0), nil
+	case ">=":
+		return logical(cmp >= 0), nil
+	}
+	return value{}, fmt.Errorf("unknown operator %s", n.Op)
+}
+
+// arithmetic adds or subtracts strings, numbers, dates and datetimes
+func arithmetic(op string, x, y value) (value, error) {
+	sign := 1.0
+	if op == "-" {
+		sign = -1
+	}
+	switch {
+	case x.typ == Character:
+		if op == "+" {
+			return str(x.s + y.s), nil
+		}
+		// Subtraction moves the trailing blanks of the left operand to the end
+		trimmed := strings.TrimRight(x.s, " ")
+		return str(trimmed + y.s + x.s[len(trimmed):]), nil
+	case x.typ == Date && y.typ == Date:
+		return num(float64(julianDay(x.t) - julianDay(y.t))), nil
+	case x.typ == DateTime && y.typ == DateTime:
+		return num(x.t.Sub(y.t).Seconds()), nil
+	case x.typ == Date:
+		return addDays(x, sign*y.n), nil
+	case y.typ == Date:
+		return addDays(y, x.n), nil
+	case x.typ == DateTime:
+		return addSeconds(x, sign*y.n), nil
+	case y.typ == DateTime:
+		return addSeconds(y, x.n), nil
+	}
+	return num(x.n + sign*y.n), nil
+}
+
+// addDays moves a date by whole days; a blank date stays blank
+func addDays(d value, days float64) value {
+	if d.t.IsZero() {
+		return d
+	}
+	return date(fromJulian(julianDay(d.t) + int64(days)))
+}
+
+// addSeconds moves a datetime by seconds, to the millisecond; a blank
+// datetime stays blank
+func addSeconds(t value, seconds float64) value {
+	if t.t.IsZero() {
+		return t
+	}
+	return stamp(t.t.Add(time.Duration(math.Round(seconds*1000)) * time.Millisecond))
+}
+
+// mod returns the remainder with the sign of the divisor, as MOD() and %
+// do in Visual FoxPro
+func mod(x, y float64) float64 {
+	r := math.Mod(x, y)
+	if r != 0 && (r < 0) != (y < 0) {
+		r += y
+	}
+	return r
+}
+
+func (n *Call) eval(ctx *context) (value, error) {
+	if n.fn.lazy {
+		return n.fn.eval(ctx, n, nil)
+	}
+	args := make([]value, len(n.Args))
+	for i, arg := range n.Args {
+		v, err := ctx.eval(arg)
+		if err != nil {
+			return value{}, err
+		}
+		if v.null && !n.fn.nulls {
+			return nullOf(n.typ), nil
+		}
+		args[i] = v
+	}
+	v, err := n.fn.eval(ctx, n, args)
+	if err != nil {
+		return value{}, fmt.Errorf("%s: %w", n.Name, err)
+	}
+	return v, nil
+}
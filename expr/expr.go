@@ -0,0 +1,271 @@
+// Package expr parses, type-checks and evaluates Visual FoxPro (xBase)
+// expressions, the language of CDX tag keys, filters and computed values.
+//
+// An expression is compiled against an Env, which resolves the field names
+// it mentions and supplies the current record when it runs:
+//
+//	prog, err := expr.Compile(`UPPER(LAST) = "SMITH" .AND. HIRED >= {^2020-01-01}`, env)
+//	ok, err := prog.EvalBool()
+//
+// foxi.Compile binds an expression to an open table, which is what most
+// callers want.
+//
+// The supported syntax is
+//
+//	arithmetic   + - * / % ^ **  (+ and - also join strings, and move dates
+//	             by days and datetimes by seconds)
+//	comparison   = == <> != # < > <= >= and $ (substring of)
+//	logical      .AND. .OR. .NOT. ! AND OR NOT
+//	constants    "text" 'text' [text] 12.5 .T. .F. .NULL.
+//	             {^2024-01-31} {^2024-01-31 13:45:00} {} (blank date)
+//	fields       NAME ALIAS.NAME ALIAS->NAME
+//	functions    NAME(arg, ...)
+//
+// Strings compare the way they do with SET EXACT OFF: with =, the left
+// operand only has to start with the right one, while == needs an exact
+// match. A null operand makes most results null; .AND. and .OR. follow
+// three-valued logic, and a null condition counts as false.
+package expr
+
+import (
+	"fmt"
+	"time"
+)
+
+// Type is the type of an expression result, named by its Visual FoxPro
+// type letter.
+type Type byte
+
+// Expression result types
+const (
+	Character Type = 'C' // string
+	Numeric   Type = 'N' // float64
+	Integer   Type = 'I' // float64, from an integer field; keyed in 4 bytes
+	Date      Type = 'D' // time.Time at midnight UTC, the zero time when blank
+	DateTime  Type = 'T' // time.Time in UTC, the zero time when blank
+	Logical   Type = 'L' // bool
+	Null      Type = 'X' // Type of the .NULL. constant
+)
+
+// String returns the type letter
+func (t Type) String() string {
+	return string(rune(t))
+}
+
+// family maps integers onto numbers, which they are for every operator
+func (t Type) family() Type {
+	if t == Integer {
+		return Numeric
+	}
+	return t
+}
+
+// Column describes a field an expression can refer to.
+type Column struct {
+	Name string
+	Type Type
+	Len  int // Width of the field, used for the length of character results
+	Dec  int // Decimal places
+
+	// Get reads the field of the current record. It returns a string or
+	// []byte for character columns, any Go number for numeric ones, a bool
+	// or a time.Time, and nil for a null value.
+	Get func() (any, error)
+}
+
+// Env resolves the names an expression refers to when it is compiled and
+// supplies the state of the current record when it is evaluated.
+type Env interface {
+	// Column returns the field with the given name, qualified by an alias
+	// when alias is not empty; ok is false when there is no such field.
+	Column(alias, name string) (column *Column, ok bool)
+
+	// RecNo returns the number of the current record.
+	RecNo() int
+
+	// Deleted reports whether the current record is marked for deletion.
+	Deleted() bool
+}
+
+// KeyEncoder is implemented by environments whose character index keys
+// are stored in another character set, such as the code page of a table.
+// Without one, characters are stored as ISO 8859-1 bytes.
+type KeyEncoder interface {
+	EncodeKey(s string) (string, error)
+}
+
+// KeyDecoder is the inverse of KeyEncoder. Functions that work with
+// character codes, such as CHR, ASC and BINTOC, use the two to convert
+// between characters and their stored bytes.
+type KeyDecoder interface {
+	DecodeKey(raw string) string
+}
+
+// DateFormatter is implemented by environments that show dates as text
+// in another format than MM/DD/YY, as SET DATE and SET CENTURY do. The
+// format is used by DTOC, TTOC, CTOD, CTOT and TRANSFORM, and is fixed
+// when an expression is compiled.
+type DateFormatter interface {
+	// DateFormat returns a CodeBase date picture, see DateLayout.
+	DateFormat() string
+}
+
+// Program is a compiled expression bound to the environment it was
+// compiled against. It is not safe for concurrent use.
+type Program struct {
+	source string
+	root   Node
+	env    Env
+}
+
+// Compile parses an expression, resolves its field references and checks
+// the types of its operators and function arguments.
+func Compile(source string, env Env) (*Program, error) {
+	root, err := parse(source)
+	if err != nil {
+		return nil, err
+	}
+	if err := check(root, env); err != nil {
+		return nil, err
+	}
+	return &Program{source: source, root: root, env: env}, nil
+}
+
+// MustCompile compiles an expression, panicking on error.
+func MustCompile(source string, env Env) *Program {
+	p, err := Compile(source, env)
+	if err != nil {
+		panic(err)
+	}
+	return p
+}
+
+// Source returns the expression text the program was compiled from.
+func (p *Program) Source() string {
+	return p.source
+}
+
+// String returns the expression in canonical form: names upper case,
+// constants normalised and only the parentheses precedence needs.
+func (p *Program) String() string {
+	return p.root.String()
+}
+
+// Root returns the parsed and type-checked expression tree.
+func (p *Program) Root() Node {
+	return p.root
+}
+
+// Type returns the result type.
+func (p *Program) Type() Type {
+	return p.root.Type()
+}
+
+// Len returns the length of a character result, the width of a numeric
+// one: wide enough for any result of arithmetic on the operands' widths,
+// up to 20 where it cannot be bounded, as for division.
+func (p *Program) Len() int {
+	return p.root.Len()
+}
+
+// Dec returns the decimal places of a numeric result.
+func (p *Program) Dec() int {
+	return p.root.Dec()
+}
+
+// Eval evaluates the expression for the current record. The result is a
+// string, float64, bool or time.Time as the result type, nil when null.
+func (p *Program) Eval() (any, error) {
+	v, err := p.eval()
+	if err != nil {
+		return nil, err
+	}
+	return v.toAny(), nil
+}
+
+// EvalBool evaluates a logical expression for the current record; a null
+// result is false.
+func (p *Program) EvalBool() (bool, error) {
+	if p.Type() != Logical && p.Type() != Null {
+		return false, fmt.Errorf("expression %s is not logical", p.source)
+	}
+	v, err := p.eval()
+	return v.b && !v.null, err
+}
+
+// eval evaluates the root of the expression
+func (p *Program) eval() (value, error) {
+	ctx := &context{env: p.env}
+	return ctx.eval(p.root)
+}
+
+// context carries the environment through an evaluation
+type context struct {
+	env Env
+}
+
+// eval evaluates a node
+func (ctx *context) eval(n Node) (value, error) {
+	return n.eval(ctx)
+}
+
+// encode converts characters to the bytes they are stored as
+func (ctx *context) encode(s string) (string, error) {
+	if encoder, ok := ctx.env.(KeyEncoder); ok {
+		return encoder.EncodeKey(s)
+	}
+	return encodeLatin1(s)
+}
+
+// decode converts stored bytes to characters
+func (ctx *context) decode(raw string) string {
+	if decoder, ok := ctx.env.(KeyDecoder); ok {
+		return decoder.DecodeKey(raw)
+	}
+	return decodeLatin1(raw)
+}
+
+// encodeLatin1 stores characters as ISO 8859-1 bytes
+func encodeLatin1(s string) (string, error) {
+	b := make([]byte, 0, len(s))
+	for _, r := range s {
+		if r > 0xFF {
+			return "", fmt.Errorf("character %q has no single-byte code", r)
+		}
+		b = append(b, byte(r))
+	}
+	return string(b), nil
+}
+
+// decodeLatin1 reads ISO 8859-1 bytes as characters
+func decodeLatin1(raw string) string {
+	runes := make([]rune, len(raw))
+	for i := 0; i < len(raw); i++ {
+		runes[i] = rune(raw[i])
+	}
+	return string(runes)
+}
+
+// Julian day number arithmetic shared by dates, datetimes and keys
+
+// julianUnixEpoch is the Julian day number of 1970-01-01
+const julianUnixEpoch = 2440588
+
+// julianDay returns the Julian day number of a date, 0 for the zero time
+func julianDay(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	y, m, d := t.Date()
+	days := time.Date(y, m, d, 0, 0, 0, 0, time.UTC).Unix() / 86400
+	return days + julianUnixEpoch
+}
+
+// fromJulian returns the date of a Julian day number, the zero time for
+// day 0 and before
+func fromJulian(day int64) time.Time {
+	if day <= 0 {
+		return time.Time{}
+	}
+	return time.Unix((day-julianUnixEpoch)*86400, 0).UTC()
+}
@@ -0,0 +1,478 @@
+package expr
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// function is an entry of the function table
+type function struct {
+	minArgs int
+	maxArgs int // -1 for no limit
+
+	// check validates the argument types of a call and sets its result type
+	check func(n *Call) error
+
+	// eval computes the result from the evaluated arguments
+	eval func(ctx *context, n *Call, args []value) (value, error)
+
+	lazy  bool // eval gets no arguments and evaluates n.Args itself
+	nulls bool // eval gets null arguments instead of the call being null
+	user  bool // registered with RegisterFunc
+}
+
+var (
+	functionsMu sync.RWMutex
+	functions   = map[string]*function{}
+)
+
+// register adds functions to the table
+func register(table map[string]*function) {
+	functionsMu.Lock()
+	defer functionsMu.Unlock()
+	for name, fn := range table {
+		functions[name] = fn
+	}
+}
+
+// lookupFunction finds a function by name. As in Visual FoxPro, a name of
+// four or more letters may abbreviate a longer one; the shortest match
+// wins, so SUBS is SUBSTR.
+func lookupFunction(name string) (*function, string) {
+	functionsMu.RLock()
+	defer functionsMu.RUnlock()
+	if fn, ok := functions[name]; ok {
+		return fn, name
+	}
+	if len(name) < 4 {
+		return nil, name
+	}
+	var matches []string
+	for full := range functions {
+		if strings.HasPrefix(full, name) {
+			matches = append(matches, full)
+		}
+	}
+	if len(matches) == 0 {
+		return nil, name
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		if len(matches[i]) != len(matches[j]) {
+			return len(matches[i]) < len(matches[j])
+		}
+		return matches[i] < matches[j]
+	})
+	return functions[matches[0]], matches[0]
+}
+
+// typed returns a check that takes argument specs (see checkArgs) and
+// sets a fixed result type and length
+func typed(result Type, length int, specs ...string) func(n *Call) error {
+	return func(n *Call) error {
+		n.typ, n.len = result, length
+		return checkArgs(n, specs...)
+	}
+}
+
+// sameLength returns a check for string functions whose result is as long
+// as their first argument
+func sameLength(specs ...string) func(n *Call) error {
+	return func(n *Call) error {
+		n.typ, n.len = Character, n.Args[0].Len()
+		return checkArgs(n, specs...)
+	}
+}
+
+// stringFunc wraps a string to string function
+func stringFunc(f func(string) string) func(*context, *Call, []value) (value, error) {
+	return func(_ *context, _ *Call, args []value) (value, error) {
+		return str(f(args[0].s)), nil
+	}
+}
+
+func init() {
+	register(map[string]*function{
+		"UPPER":   {minArgs: 1, maxArgs: 1, check: sameLength("C"), eval: stringFunc(strings.ToUpper)},
+		"LOWER":   {minArgs: 1, maxArgs: 1, check: sameLength("C"), eval: stringFunc(strings.ToLower)},
+		"ALLTRIM": {minArgs: 1, maxArgs: 1, check: sameLength("C"), eval: stringFunc(func(s string) string { return strings.Trim(s, " ") })},
+		"LTRIM":   {minArgs: 1, maxArgs: 1, check: sameLength("C"), eval: stringFunc(func(s string) string { return strings.TrimLeft(s, " ") })},
+		"RTRIM":   {minArgs: 1, maxArgs: 1, check: sameLength("C"), eval: stringFunc(func(s string) string { return strings.TrimRight(s, " ") })},
+		"TRIM":    {minArgs: 1, maxArgs: 1, check: sameLength("C"), eval: stringFunc(func(s string) string { return strings.TrimRight(s, " ") })},
+		"LEFT":    {minArgs: 2, maxArgs: 2, check: checkLeft, eval: evalLeft},
+		"RIGHT":   {minArgs: 2, maxArgs: 2, check: checkLeft, eval: evalRight},
+		"SUBSTR":  {minArgs: 2, maxArgs: 3, check: checkSubstr, eval: evalSubstr},
+		"STR":     {minArgs: 1, maxArgs: 3, check: checkStr, eval: evalStr},
+		"DTOS":    {minArgs: 1, maxArgs: 1, check: typed(Character, 8, "DT"), eval: evalDtos},
+		"VAL":     {minArgs: 1, maxArgs: 1, check: checkVal, eval: evalVal},
+		"RECNO":   {minArgs: 0, maxArgs: 1, check: typed(Numeric, 10, "*"), eval: evalRecno},
+		"DELETED": {minArgs: 0, maxArgs: 1, check: typed(Logical, 1, "*"), eval: evalDeleted},
+		"IIF":     {minArgs: 3, maxArgs: 3, check: checkIif, eval: evalIif, lazy: true},
+		"ICASE":   {minArgs: 2, maxArgs: -1, check: checkIcase, eval: evalIcase, lazy: true},
+		"EMPTY":   {minArgs: 1, maxArgs: 1, check: typed(Logical, 1, "*"), eval: evalEmpty, nulls: true},
+		"ISNULL":  {minArgs: 1, maxArgs: 1, check: typed(Logical, 1, "*"), eval: evalIsNull, nulls: true},
+		"NVL":     {minArgs: 2, maxArgs: 2, check: checkSameType, eval: evalNvl, nulls: true},
+		"EVL":     {minArgs: 2, maxArgs: 2, check: checkSameType, eval: evalEvl, nulls: true},
+		"INLIST":  {minArgs: 2, maxArgs: -1, check: checkCompared, eval: evalInlist},
+		"BETWEEN": {minArgs: 3, maxArgs: 3, check: checkCompared, eval: evalBetween},
+		"MIN":     {minArgs: 2, maxArgs: -1, check: checkSameType, eval: evalMinMax(-1)},
+		"MAX":     {minArgs: 2, maxArgs: -1, check: checkSameType, eval: evalMinMax(1)},
+		"SYS":     {minArgs: 1, maxArgs: -1, check: checkSys, eval: evalSys},
+	})
+}
+
+func checkLeft(n *Call) error {
+	n.typ, n.len = Character, max(min(constInt(n.Args[1], n.Args[0].Len()), n.Args[0].Len()), 0)
+	return checkArgs(n, "C", "N")
+}
+
+func evalLeft(_ *context, _ *Call, args []value) (value, error) {
+	return str(truncateRunes(args[0].s, int(args[1].n))), nil
+}
+
+func evalRight(_ *context, _ *Call, args []value) (value, error) {
+	count := int(args[1].n)
+	length := utf8.RuneCountInString(args[0].s)
+	if count >= length {
+		return args[0], nil
+	}
+	return str(substr(args[0].s, length-max(count, 0)+1, count)), nil
+}
+
+func checkSubstr(n *Call) error {
+	n.typ = Character
+	start := constInt(n.Args[1], 1)
+	n.len = max(n.Args[0].Len()-max(start, 1)+1, 0)
+	if len(n.Args) > 2 {
+		n.len = max(min(constInt(n.Args[2], n.len), n.len), 0)
+	}
+	return checkArgs(n, "C", "N")
+}
+
+func evalSubstr(_ *context, _ *Call, args []value) (value, error) {
+	count := utf8.RuneCountInString(args[0].s)
+	if len(args) > 2 {
+		count = int(args[2].n)
+	}
+	return str(substr(args[0].s, int(args[1].n), count)), nil
+}
+
+// substr returns count characters of s from the 1-based start
+func substr(s string, start, count int) string {
+	if start < 1 || count <= 0 {
+		return ""
+	}
+	runes := []rune(s)
+	if start > len(runes) {
+		return ""
+	}
+	end := min(start-1+count, len(runes))
+	return string(runes[start-1 : end])
+}
+
+func checkStr(n *Call) error {
+	n.typ, n.len, n.dec = Character, 10, 0
+	if len(n.Args) > 1 {
+		n.len = constInt(n.Args[1], 10)
+	}
+	if len(n.Args) > 2 {
+		n.dec = constInt(n.Args[2], 0)
+	}
+	return checkArgs(n, "N")
+}
+
+func evalStr(_ *context, _ *Call, args []value) (value, error) {
+	width, dec := 10, 0
+	if len(args) > 1 {
+		width = int(args[1].n)
+	}
+	if len(args) > 2 {
+		dec = int(args[2].n)
+	}
+	return str(formatNumber(args[0].n, width, dec)), nil
+}
+
+// formatNumber right-aligns a number in a field of the given width, the
+// way STR() does: decimals are dropped before the number overflows and a
+// number that still does not fit is shown as asterisks
+func formatNumber(x float64, width, dec int) string {
+	if width <= 0 {
+		return ""
+	}
+	dec = max(dec, 0)
+	text := strconv.FormatFloat(round(x, dec), 'f', dec, 64)
+	for d := dec - 1; len(text) > width && d >= 0; d-- {
+		text = strconv.FormatFloat(round(x, d), 'f', d, 64)
+	}
+	if len(text) > width {
+		return strings.Repeat("*", width)
+	}
+	return strings.Repeat(" ", width-len(text)) + text
+}
+
+func evalDtos(_ *context, _ *Call, args []value) (value, error) {
+	if args[0].t.IsZero() {
+		return str("        "), nil
+	}
+	return str(args[0].t.Format("20060102")), nil
+}
+
+func checkVal(n *Call) error {
+	n.typ, n.len, n.dec = Numeric, 10, 2
+	return checkArgs(n, "C")
+}
+
+func evalVal(_ *context, _ *Call, args []value) (value, error) {
+	return num(parseLeadingNumber(args[0].s)), nil
+}
+
+// parseLeadingNumber converts the number at the start of a string, after
+// leading blanks, as VAL() does; a string without one is zero
+func parseLeadingNumber(s string) float64 {
+	s = strings.TrimLeft(s, " \t")
+	end := 0
+	if end < len(s) && (s[end] == '-' || s[end] == '+') {
+		end++
+	}
+	digits := false
+	for end < len(s) && isDigit(s[end]) {
+		end, digits = end+1, true
+	}
+	if end < len(s) && s[end] == '.' {
+		end++
+		for end < len(s) && isDigit(s[end]) {
+			end, digits = end+1, true
+		}
+	}
+	if !digits {
+		return 0
+	}
+	if end < len(s) && (s[end] == 'e' || s[end] == 'E') {
+		exp := end + 1
+		if exp < len(s) && (s[exp] == '-' || s[exp] == '+') {
+			exp++
+		}
+		if exp < len(s) && isDigit(s[exp]) {
+			for end = exp; end < len(s) && isDigit(s[end]); end++ {
+			}
+		}
+	}
+	x, _ := strconv.ParseFloat(strings.TrimSuffix(s[:end], "."), 64)
+	return x
+}
+
+func evalRecno(ctx *context, _ *Call, _ []value) (value, error) {
+	return num(float64(ctx.env.RecNo())), nil
+}
+
+func evalDeleted(ctx *context, _ *Call, _ []value) (value, error) {
+	return logical(ctx.env.Deleted()), nil
+}
+
+func checkIif(n *Call) error {
+	if err := checkArgs(n, "L", "*"); err != nil {
+		return err
+	}
+	a, b := n.Args[1].Type().family(), n.Args[2].Type().family()
+	switch {
+	case a == Null:
+		a = b
+	case b == Null:
+		b = a
+	}
+	if a != b && !((a == Date || a == DateTime) && (b == Date || b == DateTime)) {
+		return mismatch(n)
+	}
+	n.typ = a
+	n.len, n.dec = max(n.Args[1].Len(), n.Args[2].Len()), max(n.Args[1].Dec(), n.Args[2].Dec())
+	return nil
+}
+
+// evalIif evaluates only the branch the condition selects; a null
+// condition selects the second branch
+func evalIif(ctx *context, n *Call, _ []value) (value, error) {
+	cond, err := ctx.eval(n.Args[0])
+	if err != nil {
+		return value{}, err
+	}
+	branch := n.Args[2]
+	if cond.b && !cond.null {
+		branch = n.Args[1]
+	}
+	v, err := ctx.eval(branch)
+	if err != nil {
+		return value{}, err
+	}
+	if v.null {
+		return nullOf(n.typ), nil
+	}
+	return v, nil
+}
+
+// unify returns the family two operand types share, with a null constant
+// taking the type of the other; ok is false when they do not fit
+func unify(a, b Type) (Type, bool) {
+	a, b = a.family(), b.family()
+	switch {
+	case a == Null:
+		return b, true
+	case b == Null, a == b:
+		return a, true
+	case (a == Date || a == DateTime) && (b == Date || b == DateTime):
+		return DateTime, true
+	}
+	return a, false
+}
+
+// checkSameType checks that the arguments all have one type, which is the
+// result type; null constants take the type of the others
+func checkSameType(n *Call) error {
+	n.typ = Null
+	for _, arg := range n.Args {
+		switch typ := arg.Type().family(); {
+		case typ == Null:
+		case n.typ == Null:
+			n.typ = typ
+		case typ != n.typ:
+			return mismatch(n)
+		}
+		n.len, n.dec = max(n.len, arg.Len()), max(n.dec, arg.Dec())
+	}
+	return nil
+}
+
+// checkCompared checks that the arguments can be compared with the first
+func checkCompared(n *Call) error {
+	for _, arg := range n.Args[1:] {
+		if _, ok := unify(n.Args[0].Type(), arg.Type()); !ok {
+			return mismatch(n)
+		}
+	}
+	n.typ, n.len = Logical, 1
+	return nil
+}
+
+func checkIcase(n *Call) error {
+	n.typ = Null
+	for i, arg := range n.Args {
+		if i%2 == 0 && i < len(n.Args)-1 {
+			if typ := arg.Type(); typ != Logical && typ != Null {
+				return fmt.Errorf("argument %d must be logical, not %s", i+1, typeName(typ))
+			}
+			continue
+		}
+		typ, ok := unify(n.typ, arg.Type())
+		if !ok {
+			return mismatch(n)
+		}
+		n.typ, n.len, n.dec = typ, max(n.len, arg.Len()), max(n.dec, arg.Dec())
+	}
+	return nil
+}
+
+// evalIcase evaluates the result of the first true condition, or the
+// default, the last argument of an odd count, when none is true
+func evalIcase(ctx *context, n *Call, _ []value) (value, error) {
+	for i := 0; i+1 < len(n.Args); i += 2 {
+		cond, err := ctx.eval(n.Args[i])
+		if err != nil {
+			return value{}, err
+		}
+		if cond.b && !cond.null {
+			return ctx.eval(n.Args[i+1])
+		}
+	}
+	if len(n.Args)%2 == 1 {
+		return ctx.eval(n.Args[len(n.Args)-1])
+	}
+	return nullOf(n.typ), nil
+}
+
+// evalEmpty is false for null, which is not empty in Visual FoxPro
+func evalEmpty(_ *context, _ *Call, args []value) (value, error) {
+	return logical(!args[0].null && args[0].isEmpty()), nil
+}
+
+func evalIsNull(_ *context, _ *Call, args []value) (value, error) {
+	return logical(args[0].null), nil
+}
+
+func evalNvl(_ *context, _ *Call, args []value) (value, error) {
+	if args[0].null {
+		return args[1], nil
+	}
+	return args[0], nil
+}
+
+func evalEvl(_ *context, _ *Call, args []value) (value, error) {
+	if args[0].null || args[0].isEmpty() {
+		return args[1], nil
+	}
+	return args[0], nil
+}
+
+// evalInlist compares the first argument with each of the others the way
+// = does
+func evalInlist(_ *context, _ *Call, args []value) (value, error) {
+	for _, v := range args[1:] {
+		if compare(args[0], v, false) == 0 {
+			return logical(true), nil
+		}
+	}
+	return logical(false), nil
+}
+
+func evalBetween(_ *context, _ *Call, args []value) (value, error) {
+	return logical(compare(args[0], args[1], false) >= 0 && compare(args[0], args[2], false) <= 0), nil
+}
+
+// evalMinMax returns the smallest (sign -1) or largest (sign 1) argument
+func evalMinMax(sign int) func(*context, *Call, []value) (value, error) {
+	return func(_ *context, _ *Call, args []value) (value, error) {
+		best := args[0]
+		for _, v := range args[1:] {
+			if compare(v, best, true)*sign > 0 {
+				best = v
+			}
+		}
+		return best, nil
+	}
+}
+
+// checkSys accepts SYS(15, table, text), which translates each character
+// of text through a table indexed by character code, the way collation
+// tables are applied in index keys
+func checkSys(n *Call) error {
+	switch constInt(n.Args[0], -1) {
+	case 15:
+		if len(n.Args) != 3 {
+			return fmt.Errorf("SYS(15) %s", arityText(3, 3, len(n.Args)))
+		}
+		n.typ, n.len = Character, n.Args[2].Len()
+		return checkArgs(n, "N", "C", "C")
+	case -1:
+		return fmt.Errorf("argument 1 must be a numeric constant")
+	}
+	return fmt.Errorf("SYS(%d) is not supported", constInt(n.Args[0], 0))
+}
+
+func evalSys(ctx *context, _ *Call, args []value) (value, error) {
+	table, err := ctx.encode(args[1].s)
+	if err != nil {
+		return value{}, err
+	}
+	text, err := ctx.encode(args[2].s)
+	if err != nil {
+		return value{}, err
+	}
+	b := []byte(text)
+	for i, c := range b {
+		if c >= 1 && int(c) <= len(table) {
+			b[i] = table[c-1]
+		}
+	}
+	return str(ctx.decode(string(b))), nil
+}
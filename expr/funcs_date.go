@@ -0,0 +1,406 @@
+package expr
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+func init() {
+	register(map[string]*function{
+		"DATE":     {minArgs: 0, maxArgs: 3, check: checkMakeTime(Date, 3), eval: evalDate},
+		"DATETIME": {minArgs: 0, maxArgs: 6, check: checkMakeTime(DateTime, 3), eval: evalDatetime},
+		"DTOC":     {minArgs: 1, maxArgs: 2, check: typed(Character, 8, "DT", "N"), eval: evalDtoc},
+		"CTOD":     {minArgs: 1, maxArgs: 1, check: typed(Date, 8, "C"), eval: evalCtod},
+		"TTOC":     {minArgs: 1, maxArgs: 2, check: checkTtoc, eval: evalTtoc},
+		"CTOT":     {minArgs: 1, maxArgs: 1, check: typed(DateTime, 8, "C"), eval: evalCtot},
+		"DTOT":     {minArgs: 1, maxArgs: 1, check: typed(DateTime, 8, "D"), eval: evalDtot},
+		"TTOD":     {minArgs: 1, maxArgs: 1, check: typed(Date, 8, "T"), eval: evalTtod},
+		"YEAR":     {minArgs: 1, maxArgs: 1, check: typed(Numeric, 4, "DT"), eval: datePart(func(t time.Time) int { return t.Year() })},
+		"MONTH":    {minArgs: 1, maxArgs: 1, check: typed(Numeric, 2, "DT"), eval: datePart(func(t time.Time) int { return int(t.Month()) })},
+		"DAY":      {minArgs: 1, maxArgs: 1, check: typed(Numeric, 2, "DT"), eval: datePart(time.Time.Day)},
+		"QUARTER":  {minArgs: 1, maxArgs: 1, check: typed(Numeric, 1, "DT"), eval: datePart(func(t time.Time) int { return (int(t.Month()) + 2) / 3 })},
+		"HOUR":     {minArgs: 1, maxArgs: 1, check: typed(Numeric, 2, "T"), eval: datePart(time.Time.Hour)},
+		"MINUTE":   {minArgs: 1, maxArgs: 1, check: typed(Numeric, 2, "T"), eval: datePart(time.Time.Minute)},
+		"SEC":      {minArgs: 1, maxArgs: 1, check: typed(Numeric, 2, "T"), eval: datePart(time.Time.Second)},
+		"DOW":      {minArgs: 1, maxArgs: 2, check: typed(Numeric, 1, "DT", "N"), eval: evalDow},
+		"CDOW":     {minArgs: 1, maxArgs: 1, check: typed(Character, 9, "DT"), eval: dateName(func(t time.Time) string { return t.Weekday().String() })},
+		"CMONTH":   {minArgs: 1, maxArgs: 1, check: typed(Character, 9, "DT"), eval: dateName(func(t time.Time) string { return t.Month().String() })},
+		"GOMONTH":  {minArgs: 2, maxArgs: 2, check: checkGomonth, eval: evalGomonth},
+	})
+}
+
+// checkMakeTime checks the arguments of DATE and DATETIME, none for the
+// current date or time, or at least the year, month and day
+func checkMakeTime(result Type, minParts int) func(n *Call) error {
+	return func(n *Call) error {
+		if len(n.Args) > 0 && len(n.Args) < minParts {
+			return fmt.Errorf("takes no arguments or at least %d, got %d", minParts, len(n.Args))
+		}
+		n.typ, n.len = result, 8
+		return checkArgs(n, "N")
+	}
+}
+
+// evalDate returns today, or the date of a year, month and day; an
+// invalid date is an error
+func evalDate(_ *context, _ *Call, args []value) (value, error) {
+	if len(args) == 0 {
+		return date(midnight(time.Now())), nil
+	}
+	t, err := makeTime(args)
+	return date(t), err
+}
+
+// evalDatetime returns the current time, to the second, or the datetime
+// of a year, month, day and optional hour, minute and second
+func evalDatetime(_ *context, _ *Call, args []value) (value, error) {
+	if len(args) == 0 {
+		now := time.Now()
+		return stamp(time.Date(now.Year(), now.Month(), now.Day(), now.Hour(), now.Minute(), now.Second(), 0, time.UTC)), nil
+	}
+	t, err := makeTime(args)
+	return stamp(t), err
+}
+
+// makeTime builds a time from year, month, day, hour, minute and second
+// values, the missing ones zero
+func makeTime(args []value) (time.Time, error) {
+	var parts [6]int
+	for i, v := range args {
+		parts[i] = int(v.n)
+	}
+	t := time.Date(parts[0], time.Month(parts[1]), parts[2], parts[3], parts[4], parts[5], 0, time.UTC)
+	if t.Year() != parts[0] || int(t.Month()) != parts[1] || t.Day() != parts[2] ||
+		t.Hour() != parts[3] || t.Minute() != parts[4] || t.Second() != parts[5] {
+		return time.Time{}, fmt.Errorf("invalid date or time")
+	}
+	return t, nil
+}
+
+// DefaultDateFormat is the date format of environments without a
+// DateFormatter: SET DATE AMERICAN with SET CENTURY OFF, the Visual
+// FoxPro defaults.
+const DefaultDateFormat = "MM/DD/YY"
+
+// CenturyRollover places the two digit years CTOD and CTOT read, as SET
+// CENTURY TO 19 ROLLOVER 50 does: years below it are in the 2000s, the
+// others in the 1900s. Dates DTOC shows with a two digit year from 1950
+// to 2049 read back as themselves.
+const CenturyRollover = 50
+
+// DateLayout converts a CodeBase date picture to a time layout. The
+// picture holds CCYY or YY for the year, MM for the month and DD for the
+// day, once each, separated by any characters but letters and digits, as
+// in "DD.MM.CCYY" or "CCYY-MM-DD".
+func DateLayout(format string) (string, error) {
+	var layout strings.Builder
+	var year, month, day int
+	upper := strings.ToUpper(format)
+	for i := 0; i < len(upper); {
+		switch rest := upper[i:]; {
+		case strings.HasPrefix(rest, "CCYY"):
+			layout.WriteString("2006")
+			year++
+			i += 4
+		case strings.HasPrefix(rest, "YY"):
+			layout.WriteString("06")
+			year++
+			i += 2
+		case strings.HasPrefix(rest, "MM"):
+			layout.WriteString("01")
+			month++
+			i += 2
+		case strings.HasPrefix(rest, "DD"):
+			layout.WriteString("02")
+			day++
+			i += 2
+		default:
+			r, size := utf8.DecodeRuneInString(rest)
+			if unicode.IsLetter(r) || unicode.IsDigit(r) {
+				return "", fmt.Errorf("invalid date format %q", format)
+			}
+			layout.WriteString(rest[:size])
+			i += size
+		}
+	}
+	if year != 1 || month != 1 || day != 1 {
+		return "", fmt.Errorf("date format %q needs the year, month and day once each", format)
+	}
+	return layout.String(), nil
+}
+
+// dateLayout returns the time layout of dates shown as text in an
+// environment
+func dateLayout(env Env) string {
+	if formatter, ok := env.(DateFormatter); ok {
+		if layout, err := DateLayout(formatter.DateFormat()); err == nil {
+			return layout
+		}
+	}
+	return "01/02/06"
+}
+
+// dtoc formats a date the way DTOC does, in a date layout; a blank date
+// keeps only the separators
+func dtoc(t time.Time, layout string) string {
+	if t.IsZero() {
+		return strings.Map(func(r rune) rune {
+			if r >= '0' && r <= '9' {
+				return ' '
+			}
+			return r
+		}, layout)
+	}
+	return t.Format(layout)
+}
+
+// evalDtoc formats a date in the environment's format, or as YYYYMMDD,
+// which sorts, when the second argument is 1
+func evalDtoc(_ *context, n *Call, args []value) (value, error) {
+	if len(args) > 1 && args[1].n == 1 {
+		return evalDtos(nil, nil, args)
+	}
+	return str(dtoc(args[0].t, n.dates)), nil
+}
+
+// Formats of TTOC
+const (
+	ttocDefault = 0 // Date as DTOC, then hh:mm:ss AM
+	ttocSorted  = 1 // YYYYMMDDhhmmss
+	ttocTime    = 2 // hh:mm:ss AM
+	ttocXML     = 3 // YYYY-MM-DDThh:mm:ss
+)
+
+// ttocLayouts are the time layouts of the TTOC formats, the default one
+// following the date layout
+var ttocLayouts = map[int]string{
+	ttocDefault: " 03:04:05 PM",
+	ttocSorted:  "20060102150405",
+	ttocTime:    "03:04:05 PM",
+	ttocXML:     "2006-01-02T15:04:05",
+}
+
+// ttocLayout returns the time layout of a TTOC format
+func ttocLayout(format int, dates string) string {
+	if format == ttocDefault {
+		return dates + ttocLayouts[ttocDefault]
+	}
+	return ttocLayouts[format]
+}
+
+// ttoc formats a datetime in one of the TTOC formats; a blank datetime
+// gives blanks
+func ttoc(t time.Time, format int, dates string) string {
+	layout := ttocLayout(format, dates)
+	if t.IsZero() {
+		return strings.Repeat(" ", len(layout))
+	}
+	return t.Format(layout)
+}
+
+func checkTtoc(n *Call) error {
+	format := ttocDefault
+	if len(n.Args) > 1 {
+		format = constInt(n.Args[1], ttocDefault)
+	}
+	if _, ok := ttocLayouts[format]; !ok {
+		return fmt.Errorf("format %d is not supported", format)
+	}
+	n.typ, n.len = Character, len(ttocLayout(format, n.dates))
+	return checkArgs(n, "DT", "N")
+}
+
+func evalTtoc(_ *context, n *Call, args []value) (value, error) {
+	format := ttocDefault
+	if len(args) > 1 {
+		format = int(args[1].n)
+	}
+	if _, ok := ttocLayouts[format]; !ok {
+		return value{}, fmt.Errorf("format %d is not supported", format)
+	}
+	return str(ttoc(args[0].t, format, n.dates)), nil
+}
+
+// evalCtod reads a date in the environment's order, with a two digit
+// year placed by CenturyRollover or a four digit one, or in strict form as ^YYYY-MM-DD; text that is not a valid
+// date gives a blank date
+func evalCtod(_ *context, n *Call, args []value) (value, error) {
+	t, _ := parseDateTime(args[0].s, n.dates)
+	return date(midnight(t)), nil
+}
+
+// evalCtot reads a datetime the way CTOD reads a date, followed by a time
+// of day as hh:mm[:ss] [AM|PM]; an XML datetime YYYY-MM-DDThh:mm:ss is
+// also accepted
+func evalCtot(_ *context, n *Call, args []value) (value, error) {
+	t, _ := parseDateTime(args[0].s, n.dates)
+	return stamp(t), nil
+}
+
+// parseDateTime reads the text of CTOD and CTOT with the date in the
+// order of a date layout, returning the zero time when it is not a valid
+// date
+func parseDateTime(s, layout string) (time.Time, bool) {
+	s = strings.TrimSpace(s)
+	if t, err := time.Parse("2006-01-02T15:04:05", s); err == nil {
+		return t, true
+	}
+
+	var y, m, d int
+	datePart, timePart, _ := strings.Cut(s, " ")
+	fields := strings.FieldsFunc(datePart, func(r rune) bool {
+		return strings.ContainsRune("/-.", r) || (r < '0' || r > '9') && r != '^' && strings.ContainsRune(layout, r)
+	})
+	if len(fields) != 3 {
+		return time.Time{}, false
+	}
+	numbers := make([]int, 3)
+	for i, field := range fields {
+		n, err := strconv.Atoi(strings.TrimPrefix(field, "^"))
+		if err != nil {
+			return time.Time{}, false
+		}
+		numbers[i] = n
+	}
+	if strings.HasPrefix(fields[0], "^") {
+		y, m, d = numbers[0], numbers[1], numbers[2]
+	} else {
+		year, month, day := dateOrder(layout)
+		y, m, d = numbers[year], numbers[month], numbers[day]
+		if len(fields[year]) <= 2 {
+			y += 1900
+			if y < 1900+CenturyRollover {
+				y += 100
+			}
+		}
+	}
+
+	var hour, minute, second int
+	if timePart = strings.ToUpper(strings.TrimSpace(timePart)); timePart != "" {
+		clock, meridian, _ := strings.Cut(timePart, " ")
+		if strings.HasSuffix(clock, "AM") || strings.HasSuffix(clock, "PM") {
+			clock, meridian = clock[:len(clock)-2], clock[len(clock)-2:]
+		}
+		parts := strings.Split(clock, ":")
+		if len(parts) < 2 || len(parts) > 3 {
+			return time.Time{}, false
+		}
+		values := make([]int, 3)
+		for i, part := range parts {
+			n, err := strconv.Atoi(part)
+			if err != nil {
+				return time.Time{}, false
+			}
+			values[i] = n
+		}
+		hour, minute, second = values[0], values[1], values[2]
+		switch strings.TrimSpace(meridian) {
+		case "AM":
+			if hour == 12 {
+				hour = 0
+			}
+		case "PM":
+			if hour < 12 {
+				hour += 12
+			}
+		case "":
+		default:
+			return time.Time{}, false
+		}
+	}
+
+	t, err := makeTime([]value{num(float64(y)), num(float64(m)), num(float64(d)), num(float64(hour)), num(float64(minute)), num(float64(second))})
+	return t, err == nil
+}
+
+func evalDtot(_ *context, _ *Call, args []value) (value, error) {
+	return stamp(args[0].t), nil
+}
+
+func evalTtod(_ *context, _ *Call, args []value) (value, error) {
+	if args[0].t.IsZero() {
+		return date(time.Time{}), nil
+	}
+	return date(midnight(args[0].t)), nil
+}
+
+// datePart returns a part of a date or datetime, 0 for a blank one
+func datePart(part func(time.Time) int) func(*context, *Call, []value) (value, error) {
+	return func(_ *context, _ *Call, args []value) (value, error) {
+		if args[0].t.IsZero() {
+			return num(0), nil
+		}
+		return num(float64(part(args[0].t))), nil
+	}
+}
+
+// dateName returns the English name of a part of a date, empty for a
+// blank date
+func dateName(name func(time.Time) string) func(*context, *Call, []value) (value, error) {
+	return func(_ *context, _ *Call, args []value) (value, error) {
+		if args[0].t.IsZero() {
+			return str(""), nil
+		}
+		return str(name(args[0].t)), nil
+	}
+}
+
+// evalDow numbers the days of the week from 1, starting on Sunday unless
+// the second argument names another first day (1 for Sunday to 7 for
+// Saturday)
+func evalDow(_ *context, _ *Call, args []value) (value, error) {
+	if args[0].t.IsZero() {
+		return num(0), nil
+	}
+	first := 1
+	if len(args) > 1 && args[1].n >= 1 && args[1].n <= 7 {
+		first = int(args[1].n)
+	}
+	return num(float64((int(args[0].t.Weekday())-first+8)%7 + 1)), nil
+}
+
+func checkGomonth(n *Call) error {
+	n.typ, n.len = n.Args[0].Type(), 8
+	return checkArgs(n, "DT", "N")
+}
+
+// evalGomonth moves a date by months, keeping the day of the month where
+// the new month has it and taking its last day where it does not
+func evalGomonth(_ *context, n *Call, args []value) (value, error) {
+	t := args[0].t
+	if t.IsZero() {
+		return args[0], nil
+	}
+	months := int(args[1].n)
+	first := time.Date(t.Year(), t.Month()+time.Month(months), 1, t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), time.UTC)
+	last := first.AddDate(0, 1, -1).Day()
+	moved := first.AddDate(0, 0, min(t.Day(), last)-1)
+	if n.typ == Date {
+		return date(moved), nil
+	}
+	return stamp(moved), nil
+}
+
+// dateOrder returns the positions of the year, month and day among the
+// fields of a date in a date layout
+func dateOrder(layout string) (year, month, day int) {
+	yearAt := strings.Index(layout, "2006")
+	if yearAt < 0 {
+		yearAt = strings.Index(layout, "06")
+	}
+	at := []int{yearAt, strings.Index(layout, "01"), strings.Index(layout, "02")}
+	rank := func(i int) int {
+		n := 0
+		for _, other := range at {
+			if other < at[i] {
+				n++
+			}
+		}
+		return n
+	}
+	return rank(0), rank(1), rank(2)
+}
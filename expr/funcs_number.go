@@ -0,0 +1,260 @@
+package expr
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"strings"
+)
+
+func init() {
+	register(map[string]*function{
+		"ABS":     {minArgs: 1, maxArgs: 1, check: sameWidth("N"), eval: numberFunc(math.Abs)},
+		"INT":     {minArgs: 1, maxArgs: 1, check: checkWhole, eval: numberFunc(math.Trunc)},
+		"CEILING": {minArgs: 1, maxArgs: 1, check: checkWhole, eval: numberFunc(math.Ceil)},
+		"FLOOR":   {minArgs: 1, maxArgs: 1, check: checkWhole, eval: numberFunc(math.Floor)},
+		"SIGN":    {minArgs: 1, maxArgs: 1, check: typed(Numeric, 2, "N"), eval: numberFunc(sign)},
+		"ROUND":   {minArgs: 2, maxArgs: 2, check: checkRound, eval: evalRound},
+		"MOD":     {minArgs: 2, maxArgs: 2, check: sameWidth("N"), eval: evalMod},
+		"SQRT":    {minArgs: 1, maxArgs: 1, check: typed(Numeric, 20, "N"), eval: evalSqrt},
+		"EXP":     {minArgs: 1, maxArgs: 1, check: typed(Numeric, 20, "N"), eval: numberFunc(math.Exp)},
+		"LOG":     {minArgs: 1, maxArgs: 1, check: typed(Numeric, 20, "N"), eval: evalLog(math.Log)},
+		"LOG10":   {minArgs: 1, maxArgs: 1, check: typed(Numeric, 20, "N"), eval: evalLog(math.Log10)},
+		"PI":      {minArgs: 0, maxArgs: 0, check: typed(Numeric, 20, "*"), eval: numberFunc(func(float64) float64 { return math.Pi })},
+		"BINTOC":  {minArgs: 1, maxArgs: 2, check: checkBintoc, eval: evalBintoc},
+		"CTOBIN":  {minArgs: 1, maxArgs: 2, check: typed(Numeric, 20, "C", "C"), eval: evalCtobin},
+	})
+}
+
+// sameWidth returns a check for numeric functions whose result is as wide
+// as their first argument
+func sameWidth(specs ...string) func(n *Call) error {
+	return func(n *Call) error {
+		n.typ, n.len, n.dec = Numeric, n.Args[0].Len(), n.Args[0].Dec()
+		return checkArgs(n, specs...)
+	}
+}
+
+// checkWhole checks functions with a whole number result
+func checkWhole(n *Call) error {
+	n.typ, n.len = Numeric, n.Args[0].Len()
+	return checkArgs(n, "N")
+}
+
+// numberFunc wraps a float64 to float64 function
+func numberFunc(f func(float64) float64) func(*context, *Call, []value) (value, error) {
+	return func(_ *context, _ *Call, args []value) (value, error) {
+		var x float64
+		if len(args) > 0 {
+			x = args[0].n
+		}
+		return num(f(x)), nil
+	}
+}
+
+func sign(x float64) float64 {
+	switch {
+	case x < 0:
+		return -1
+	case x > 0:
+		return 1
+	}
+	return 0
+}
+
+func checkRound(n *Call) error {
+	n.typ, n.len, n.dec = Numeric, n.Args[0].Len(), max(constInt(n.Args[1], n.Args[0].Dec()), 0)
+	return checkArgs(n, "N")
+}
+
+// evalRound rounds half away from zero; negative places round to tens,
+// hundreds and so on
+func evalRound(_ *context, _ *Call, args []value) (value, error) {
+	return num(round(args[0].n, int(args[1].n))), nil
+}
+
+func evalMod(_ *context, _ *Call, args []value) (value, error) {
+	if args[1].n == 0 {
+		return value{}, fmt.Errorf("division by zero")
+	}
+	return num(mod(args[0].n, args[1].n)), nil
+}
+
+func evalSqrt(_ *context, _ *Call, args []value) (value, error) {
+	if args[0].n < 0 {
+		return value{}, fmt.Errorf("square root of negative number %g", args[0].n)
+	}
+	return num(math.Sqrt(args[0].n)), nil
+}
+
+func evalLog(log func(float64) float64) func(*context, *Call, []value) (value, error) {
+	return func(_ *context, _ *Call, args []value) (value, error) {
+		if args[0].n <= 0 {
+			return value{}, fmt.Errorf("logarithm of non-positive number %g", args[0].n)
+		}
+		return num(log(args[0].n)), nil
+	}
+}
+
+// binaryFormat is the layout BINTOC and CTOBIN convert with
+type binaryFormat struct {
+	size    int  // 1, 2, 4 or 8 bytes
+	float   bool // IEEE floating point rather than an integer
+	reverse bool // Little-endian, which does not sort
+	signed  bool // Sign bit left as is, which does not sort either
+}
+
+// parseBinaryFormat reads the size or flags argument of BINTOC and CTOBIN:
+// a size of 1, 2, 4 or 8 bytes, or flags among 1, 2, 4, 8, B (8-byte
+// double), F (4-byte float), R (reverse byte order) and S (keep the sign
+// bit)
+func parseBinaryFormat(arg value, size int) (binaryFormat, error) {
+	format := binaryFormat{size: size}
+	switch arg.typ {
+	case Numeric, Integer:
+		format.size = int(arg.n)
+	case Character:
+		for _, flag := range strings.ToUpper(arg.s) {
+			switch flag {
+			case '1', '2', '4', '8':
+				format.size = int(flag - '0')
+			case 'B':
+				format.size, format.float = 8, true
+			case 'F':
+				format.size, format.float = 4, true
+			case 'R':
+				format.reverse = true
+			case 'S':
+				format.signed = true
+			case 'N', ' ':
+			default:
+				return format, fmt.Errorf("unknown flag %q", flag)
+			}
+		}
+	}
+	switch format.size {
+	case 1, 2, 4, 8:
+		return format, nil
+	}
+	return format, fmt.Errorf("size %d is not 1, 2, 4 or 8", format.size)
+}
+
+func checkBintoc(n *Call) error {
+	if err := checkArgs(n, "N", "NC"); err != nil {
+		return err
+	}
+	n.typ, n.len = Character, 4
+	if len(n.Args) > 1 {
+		arg, ok := constant(n.Args[1])
+		if !ok {
+			return fmt.Errorf("argument 2 must be a constant")
+		}
+		format, err := parseBinaryFormat(arg, 4)
+		if err != nil {
+			return err
+		}
+		n.len = format.size
+	}
+	return nil
+}
+
+// evalBintoc stores a number in binary the way index keys do: big-endian
+// with the sign bit flipped, so that the results sort as the numbers do
+func evalBintoc(ctx *context, _ *Call, args []value) (value, error) {
+	format := binaryFormat{size: 4}
+	if len(args) > 1 {
+		var err error
+		if format, err = parseBinaryFormat(args[1], 4); err != nil {
+			return value{}, err
+		}
+	}
+
+	b := make([]byte, format.size)
+	x := args[0].n
+	switch {
+	case format.float && format.size == 8:
+		putDoubleKey(b, x)
+	case format.float:
+		bits := math.Float32bits(float32(x))
+		if bits&(1<<31) != 0 {
+			bits = ^bits
+		} else {
+			bits |= 1 << 31
+		}
+		binary.BigEndian.PutUint32(b, bits)
+	default:
+		limit := math.Ldexp(1, format.size*8-1)
+		if x = math.Trunc(x); x < -limit || x >= limit {
+			return value{}, fmt.Errorf("numeric overflow: %g does not fit in %d bytes", x, format.size)
+		}
+		n := uint64(int64(x))
+		if !format.signed {
+			n ^= 1 << (format.size*8 - 1)
+		}
+		for i := format.size - 1; i >= 0; i-- {
+			b[i], n = byte(n), n>>8
+		}
+	}
+	if format.reverse {
+		for i, j := 0, len(b)-1; i < j; i, j = i+1, j-1 {
+			b[i], b[j] = b[j], b[i]
+		}
+	}
+	return str(ctx.decode(string(b))), nil
+}
+
+// evalCtobin reverses BINTOC; the size is that of the binary string unless
+// the flags give another
+func evalCtobin(ctx *context, _ *Call, args []value) (value, error) {
+	raw, err := ctx.encode(args[0].s)
+	if err != nil {
+		return value{}, err
+	}
+	format := binaryFormat{size: len(raw)}
+	if len(args) > 1 {
+		if format, err = parseBinaryFormat(args[1], len(raw)); err != nil {
+			return value{}, err
+		}
+	} else if _, err = parseBinaryFormat(value{}, len(raw)); err != nil {
+		return value{}, err
+	}
+	if len(raw) < format.size {
+		return value{}, fmt.Errorf("%d bytes given for a %d-byte number", len(raw), format.size)
+	}
+
+	b := []byte(raw[:format.size])
+	if format.reverse {
+		for i, j := 0, len(b)-1; i < j; i, j = i+1, j-1 {
+			b[i], b[j] = b[j], b[i]
+		}
+	}
+	switch {
+	case format.float && format.size == 8:
+		bits := binary.BigEndian.Uint64(b)
+		if bits&(1<<63) != 0 {
+			bits &^= 1 << 63
+		} else {
+			bits = ^bits
+		}
+		return num(math.Float64frombits(bits)), nil
+	case format.float:
+		bits := binary.BigEndian.Uint32(b)
+		if bits&(1<<31) != 0 {
+			bits &^= 1 << 31
+		} else {
+			bits = ^bits
+		}
+		return num(float64(math.Float32frombits(bits))), nil
+	}
+
+	var n uint64
+	for _, c := range b {
+		n = n<<8 | uint64(c)
+	}
+	bits := uint(format.size * 8)
+	if !format.signed {
+		n ^= 1 << (bits - 1)
+	}
+	// Extend the sign of the stored width
+	return num(float64(int64(n<<(64-bits)) >> (64 - bits))), nil
+}
@@ -0,0 +1,348 @@
+package expr
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+func init() {
+	register(map[string]*function{
+		"PADL":      {minArgs: 2, maxArgs: 3, check: checkPad, eval: evalPad(padLeft)},
+		"PADR":      {minArgs: 2, maxArgs: 3, check: checkPad, eval: evalPad(padRight)},
+		"PADC":      {minArgs: 2, maxArgs: 3, check: checkPad, eval: evalPad(padCenter)},
+		"SPACE":     {minArgs: 1, maxArgs: 1, check: checkSpace, eval: evalSpace},
+		"REPLICATE": {minArgs: 2, maxArgs: 2, check: checkReplicate, eval: evalReplicate},
+		"STUFF":     {minArgs: 4, maxArgs: 4, check: checkStuff, eval: evalStuff},
+		"STRTRAN":   {minArgs: 2, maxArgs: 6, check: sameLength("C", "C", "C", "N"), eval: evalStrtran},
+		"CHRTRAN":   {minArgs: 3, maxArgs: 3, check: sameLength("C"), eval: evalChrtran},
+		"PROPER":    {minArgs: 1, maxArgs: 1, check: sameLength("C"), eval: stringFunc(proper)},
+		"AT":        {minArgs: 2, maxArgs: 3, check: typed(Numeric, 10, "C", "C", "N"), eval: evalAt(false, false)},
+		"ATC":       {minArgs: 2, maxArgs: 3, check: typed(Numeric, 10, "C", "C", "N"), eval: evalAt(true, false)},
+		"RAT":       {minArgs: 2, maxArgs: 3, check: typed(Numeric, 10, "C", "C", "N"), eval: evalAt(false, true)},
+		"OCCURS":    {minArgs: 2, maxArgs: 2, check: typed(Numeric, 10, "C"), eval: evalOccurs},
+		"LEN":       {minArgs: 1, maxArgs: 1, check: typed(Numeric, 10, "C"), eval: evalLen},
+		"ASC":       {minArgs: 1, maxArgs: 1, check: typed(Numeric, 3, "C"), eval: evalAsc},
+		"CHR":       {minArgs: 1, maxArgs: 1, check: typed(Character, 1, "N"), eval: evalChr},
+		"ISALPHA":   {minArgs: 1, maxArgs: 1, check: typed(Logical, 1, "C"), eval: firstRune(unicode.IsLetter)},
+		"ISDIGIT":   {minArgs: 1, maxArgs: 1, check: typed(Logical, 1, "C"), eval: firstRune(func(r rune) bool { return r >= '0' && r <= '9' })},
+		"ISUPPER":   {minArgs: 1, maxArgs: 1, check: typed(Logical, 1, "C"), eval: firstRune(unicode.IsUpper)},
+		"ISLOWER":   {minArgs: 1, maxArgs: 1, check: typed(Logical, 1, "C"), eval: firstRune(unicode.IsLower)},
+		"LIKE":      {minArgs: 2, maxArgs: 2, check: typed(Logical, 1, "C"), eval: evalLike},
+	})
+}
+
+// checkPad takes the result length from a constant length argument and
+// accepts an expression of any type, which is converted as TRANSFORM does
+func checkPad(n *Call) error {
+	n.typ, n.len = Character, max(constInt(n.Args[1], text(n.Args[0], n.dates)), 0)
+	return checkArgs(n, "*", "N", "C")
+}
+
+// text returns the length of an expression converted to text, dates in a
+// date layout
+func text(n Node, dates string) int {
+	switch n.Type() {
+	case Date:
+		return len(dates)
+	case DateTime:
+		return len(ttocLayout(ttocDefault, dates))
+	case Logical:
+		return 3
+	}
+	return n.Len()
+}
+
+func evalPad(pad func(s string, width int, fill rune) string) func(*context, *Call, []value) (value, error) {
+	return func(_ *context, n *Call, args []value) (value, error) {
+		fill := ' '
+		if len(args) > 2 && args[2].s != "" {
+			fill, _ = utf8.DecodeRuneInString(args[2].s)
+		}
+		return str(pad(toText(args[0], n.dates), int(args[1].n), fill)), nil
+	}
+}
+
+// padLeft, padRight and padCenter fill s out to width characters; a longer
+// s is cut to its first width characters
+func padLeft(s string, width int, fill rune) string {
+	count := utf8.RuneCountInString(s)
+	if count >= width {
+		return truncateRunes(s, width)
+	}
+	return strings.Repeat(string(fill), width-count) + s
+}
+
+func padRight(s string, width int, fill rune) string {
+	count := utf8.RuneCountInString(s)
+	if count >= width {
+		return truncateRunes(s, width)
+	}
+	return s + strings.Repeat(string(fill), width-count)
+}
+
+func padCenter(s string, width int, fill rune) string {
+	count := utf8.RuneCountInString(s)
+	if count >= width {
+		return truncateRunes(s, width)
+	}
+	left := (width - count) / 2
+	return strings.Repeat(string(fill), left) + s + strings.Repeat(string(fill), width-count-left)
+}
+
+// toText converts a value to text the way TRANSFORM does without a
+// format, dates in a date layout
+func toText(v value, dates string) string {
+	switch v.typ {
+	case Character:
+		return v.s
+	case Date:
+		return dtoc(v.t, dates)
+	case DateTime:
+		return ttoc(v.t, ttocDefault, dates)
+	case Logical:
+		if v.b {
+			return ".T."
+		}
+		return ".F."
+	}
+	return strconv.FormatFloat(v.n, 'f', -1, 64)
+}
+
+func checkSpace(n *Call) error {
+	n.typ, n.len = Character, max(constInt(n.Args[0], 0), 0)
+	return checkArgs(n, "N")
+}
+
+func evalSpace(_ *context, _ *Call, args []value) (value, error) {
+	return str(strings.Repeat(" ", max(int(args[0].n), 0))), nil
+}
+
+func checkReplicate(n *Call) error {
+	n.typ, n.len = Character, n.Args[0].Len()*max(constInt(n.Args[1], 1), 0)
+	return checkArgs(n, "C", "N")
+}
+
+func evalReplicate(_ *context, _ *Call, args []value) (value, error) {
+	return str(strings.Repeat(args[0].s, max(int(args[1].n), 0))), nil
+}
+
+func checkStuff(n *Call) error {
+	n.typ = Character
+	n.len = max(n.Args[0].Len()-max(constInt(n.Args[2], 0), 0), 0) + n.Args[3].Len()
+	return checkArgs(n, "C", "N", "N", "C")
+}
+
+// evalStuff replaces count characters of s from the 1-based start
+func evalStuff(_ *context, _ *Call, args []value) (value, error) {
+	runes := []rune(args[0].s)
+	start := min(max(int(args[1].n), 1), len(runes)+1) - 1
+	end := min(start+max(int(args[2].n), 0), len(runes))
+	return str(string(runes[:start]) + args[3].s + string(runes[end:])), nil
+}
+
+// evalStrtran replaces occurrences of a search string: all of them, or
+// count of them from the start-th occurrence; flags bit 0 ignores case
+func evalStrtran(_ *context, _ *Call, args []value) (value, error) {
+	s, search := args[0].s, args[1].s
+	replacement := ""
+	if len(args) > 2 {
+		replacement = args[2].s
+	}
+	start, count := 1, -1
+	if len(args) > 3 && args[3].n > 0 {
+		start = int(args[3].n)
+	}
+	if len(args) > 4 && args[4].n >= 0 {
+		count = int(args[4].n)
+	}
+	fold := len(args) > 5 && int(args[5].n)&1 != 0
+	if search == "" {
+		return str(s), nil
+	}
+
+	var b strings.Builder
+	occurrence := 0
+	for {
+		i := index(s, search, fold)
+		if i < 0 || count == 0 {
+			break
+		}
+		occurrence++
+		b.WriteString(s[:i])
+		if occurrence >= start {
+			b.WriteString(replacement)
+			if count > 0 {
+				count--
+			}
+		} else {
+			b.WriteString(s[i : i+len(search)])
+		}
+		s = s[i+len(search):]
+	}
+	b.WriteString(s)
+	return str(b.String()), nil
+}
+
+// index finds search in s, ignoring case when fold is set; the match is
+// as long as search
+func index(s, search string, fold bool) int {
+	if !fold {
+		return strings.Index(s, search)
+	}
+	for i := range s {
+		if len(s)-i >= len(search) && strings.EqualFold(s[i:i+len(search)], search) {
+			return i
+		}
+	}
+	return -1
+}
+
+// evalChrtran replaces each character of the search string with the
+// character at the same place in the replacement, or drops it
+func evalChrtran(_ *context, _ *Call, args []value) (value, error) {
+	search, replacement := []rune(args[1].s), []rune(args[2].s)
+	var b strings.Builder
+	for _, r := range args[0].s {
+		i := slices.Index(search, r)
+		switch {
+		case i < 0:
+			b.WriteRune(r)
+		case i < len(replacement):
+			b.WriteRune(replacement[i])
+		}
+	}
+	return str(b.String()), nil
+}
+
+// proper capitalises the first letter of each word and lowers the rest
+func proper(s string) string {
+	var b strings.Builder
+	start := true
+	for _, r := range s {
+		if start {
+			b.WriteRune(unicode.ToUpper(r))
+		} else {
+			b.WriteRune(unicode.ToLower(r))
+		}
+		start = r == ' '
+	}
+	return b.String()
+}
+
+// evalAt returns the 1-based character position of an occurrence of the
+// first argument in the second, 0 when there is none
+func evalAt(fold, reverse bool) func(*context, *Call, []value) (value, error) {
+	return func(_ *context, _ *Call, args []value) (value, error) {
+		search, s := args[0].s, args[1].s
+		occurrence := 1
+		if len(args) > 2 {
+			occurrence = int(args[2].n)
+		}
+		if search == "" || occurrence < 1 {
+			return num(0), nil
+		}
+		if fold {
+			search, s = strings.ToUpper(search), strings.ToUpper(s)
+		}
+		var positions []int
+		for i := 0; ; {
+			j := strings.Index(s[i:], search)
+			if j < 0 {
+				break
+			}
+			positions = append(positions, i+j)
+			i += j + 1
+		}
+		if occurrence > len(positions) {
+			return num(0), nil
+		}
+		at := positions[occurrence-1]
+		if reverse {
+			at = positions[len(positions)-occurrence]
+		}
+		return num(float64(utf8.RuneCountInString(s[:at]) + 1)), nil
+	}
+}
+
+func evalOccurs(_ *context, _ *Call, args []value) (value, error) {
+	if args[0].s == "" {
+		return num(0), nil
+	}
+	count := 0
+	for s := args[1].s; ; count++ {
+		i := strings.Index(s, args[0].s)
+		if i < 0 {
+			break
+		}
+		s = s[i+1:]
+	}
+	return num(float64(count)), nil
+}
+
+func evalLen(_ *context, _ *Call, args []value) (value, error) {
+	return num(float64(utf8.RuneCountInString(args[0].s))), nil
+}
+
+// evalAsc returns the code of the first character, in the character set
+// of the environment
+func evalAsc(ctx *context, _ *Call, args []value) (value, error) {
+	if args[0].s == "" {
+		return num(0), nil
+	}
+	r, _ := utf8.DecodeRuneInString(args[0].s)
+	code, err := ctx.encode(string(r))
+	if err != nil || code == "" {
+		return value{}, err
+	}
+	return num(float64(code[0])), nil
+}
+
+func evalChr(ctx *context, _ *Call, args []value) (value, error) {
+	code := int(args[0].n)
+	if code < 0 || code > 255 {
+		return value{}, fmt.Errorf("character code %d out of range", code)
+	}
+	return str(ctx.decode(string([]byte{byte(code)}))), nil
+}
+
+// firstRune tests the first character of a string
+func firstRune(test func(rune) bool) func(*context, *Call, []value) (value, error) {
+	return func(_ *context, _ *Call, args []value) (value, error) {
+		r, size := utf8.DecodeRuneInString(args[0].s)
+		return logical(size > 0 && test(r)), nil
+	}
+}
+
+// evalLike matches a string against a pattern in which * stands for any
+// characters and ? for one character
+func evalLike(_ *context, _ *Call, args []value) (value, error) {
+	return logical(like([]rune(args[0].s), []rune(args[1].s))), nil
+}
+
+func like(pattern, s []rune) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			for i := len(s); i >= 0; i-- {
+				if like(pattern[1:], s[i:]) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if len(s) == 0 {
+				return false
+			}
+		default:
+			if len(s) == 0 || s[0] != pattern[0] {
+				return false
+			}
+		}
+		pattern, s = pattern[1:], s[1:]
+	}
+	return len(s) == 0
+}
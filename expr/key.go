@@ -0,0 +1,102 @@
+package expr
+
+import (
+	"encoding/binary"
+	"math"
+	"strings"
+)
+
+// KeyLen returns the length of the index keys the expression builds: the
+// result length of a character expression, 8 bytes for numbers, dates and
+// datetimes, 4 for an integer field and 1 for a logical value.
+func (p *Program) KeyLen() int {
+	switch p.Type() {
+	case Character:
+		return p.Len()
+	case Integer:
+		return 4
+	case Logical:
+		return 1
+	}
+	return 8
+}
+
+// Key evaluates the expression for the current record and encodes the
+// result the way Visual FoxPro stores it in a CDX tag, so keys sort
+// bytewise in the order of their values. Character keys are encoded with
+// the environment's KeyEncoder, as ISO 8859-1 without one, and padded with
+// blanks to KeyLen; a null result gives the key of a blank value.
+func (p *Program) Key() ([]byte, error) {
+	ctx := &context{env: p.env}
+	v, err := ctx.eval(p.root)
+	if err != nil {
+		return nil, err
+	}
+	return p.encodeKey(ctx, v)
+}
+
+// KeyOf encodes a value of the expression's result type as Key encodes
+// results: it returns the key the expression gives for a record on which
+// it evaluates to v. Values are given as Eval returns them, numbers as
+// any Go number.
+func (p *Program) KeyOf(v any) ([]byte, error) {
+	value, err := fromAny(v, p.Type())
+	if err != nil {
+		return nil, err
+	}
+	return p.encodeKey(&context{env: p.env}, value)
+}
+
+// encodeKey encodes a result as an index key
+func (p *Program) encodeKey(ctx *context, v value) ([]byte, error) {
+	key := make([]byte, p.KeyLen())
+
+	switch p.Type() {
+	case Character:
+		s, err := ctx.encode(v.s)
+		if err != nil {
+			return nil, err
+		}
+		n := copy(key, s)
+		copy(key[n:], strings.Repeat(" ", len(key)-n))
+	case Integer:
+		binary.BigEndian.PutUint32(key, uint32(int32(v.n))^0x80000000)
+	case Logical:
+		key[0] = 'F'
+		if v.b && !v.null {
+			key[0] = 'T'
+		}
+	case Date:
+		putDoubleKey(key, float64(julianDay(v.t)))
+	case DateTime:
+		putDoubleKey(key, julianTime(v))
+	default:
+		putDoubleKey(key, v.n)
+	}
+	return key, nil
+}
+
+// julianTime returns a datetime as a Julian day number with the time of
+// day as its fraction
+func julianTime(v value) float64 {
+	if v.t.IsZero() {
+		return 0
+	}
+	ms := v.t.Sub(midnight(v.t)).Milliseconds()
+	return float64(julianDay(v.t)) + float64(ms)/86400000
+}
+
+// putDoubleKey stores a double as an 8-byte key that sorts bytewise: the
+// sign bit flipped for positive numbers, every bit for negative ones
+func putDoubleKey(key []byte, x float64) {
+	if x == 0 {
+		x = 0 // no negative zero
+	}
+	bits := math.Float64bits(x)
+	if bits&(1<<63) != 0 {
+		bits = ^bits
+	} else {
+		bits |= 1 << 63
+	}
+	binary.BigEndian.PutUint64(key, bits)
+}
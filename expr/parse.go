@@ -0,0 +1,479 @@
+package expr
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// =========================================================================
+// LEXER
+// =========================================================================
+
+// tokenKind classifies a lexical token
+type tokenKind int
+
+const (
+	tokEnd tokenKind = iota
+	tokIdent
+	tokNumber
+	tokString
+	tokDate
+	tokOperator
+)
+
+// token is a lexical token with its offset in the source
+type token struct {
+	kind tokenKind
+	text string // Identifiers and dot operators are upper case, strings unquoted
+	pos  int
+}
+
+// dotWords are the operators and constants written between dots
+var dotWords = []string{".AND.", ".OR.", ".NOT.", ".NULL.", ".T.", ".F.", ".Y.", ".N."}
+
+// wordOperators are the operators that may be written as plain words
+var wordOperators = map[string]string{"AND": ".AND.", "OR": ".OR.", "NOT": ".NOT.", "NULL": ".NULL."}
+
+// matchDotWord returns the dot operator or constant starting s, if any
+func matchDotWord(s string) string {
+	for _, word := range dotWords {
+		if len(s) >= len(word) && strings.EqualFold(s[:len(word)], word) {
+			return word
+		}
+	}
+	return ""
+}
+
+// lex splits an expression into tokens
+//
+//nolint:gocyclo // single pass scanner over the expression grammar
+func lex(source string) ([]token, error) {
+	var tokens []token
+	s := source
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\r' || c == '\n':
+			i++
+
+		case c == '"' || c == '\'' || c == '[':
+			closing := c
+			if c == '[' {
+				closing = ']'
+			}
+			end := strings.IndexByte(s[i+1:], closing)
+			if end < 0 {
+				return nil, fmt.Errorf("syntax error at offset %d: unterminated string", i)
+			}
+			tokens = append(tokens, token{tokString, s[i+1 : i+1+end], i})
+			i += end + 2
+
+		case c == '{':
+			end := strings.IndexByte(s[i+1:], '}')
+			if end < 0 {
+				return nil, fmt.Errorf("syntax error at offset %d: unterminated date", i)
+			}
+			tokens = append(tokens, token{tokDate, s[i+1 : i+1+end], i})
+			i += end + 2
+
+		case c == '.' && (i+1 >= len(s) || !isDigit(s[i+1])):
+			word := matchDotWord(s[i:])
+			if word == "" {
+				// The dot of ALIAS.FIELD
+				if i+1 < len(s) && isIdentStart(s[i+1]) {
+					word = "."
+				} else {
+					return nil, fmt.Errorf("syntax error at offset %d: unexpected %q", i, ".")
+				}
+			}
+			tokens = append(tokens, token{tokOperator, word, i})
+			i += len(word)
+
+		case isDigit(c) || c == '.':
+			start := i
+			for i < len(s) && (isDigit(s[i]) || s[i] == '.') {
+				i++
+			}
+			if i < len(s) && (s[i] == 'e' || s[i] == 'E') {
+				j := i + 1
+				if j < len(s) && (s[j] == '+' || s[j] == '-') {
+					j++
+				}
+				if j < len(s) && isDigit(s[j]) {
+					for i = j; i < len(s) && isDigit(s[i]); i++ {
+					}
+				}
+			}
+			tokens = append(tokens, token{tokNumber, s[start:i], start})
+
+		case isIdentStart(c):
+			start := i
+			for i < len(s) && isIdentPart(s[i]) {
+				i++
+			}
+			word := strings.ToUpper(s[start:i])
+			if op, ok := wordOperators[word]; ok {
+				tokens = append(tokens, token{tokOperator, op, start})
+			} else {
+				tokens = append(tokens, token{tokIdent, word, start})
+			}
+
+		default:
+			op := ""
+			if i+1 < len(s) {
+				switch two := s[i : i+2]; two {
+				case "<=", ">=", "<>", "==", "!=", "->", "**":
+					op = two
+				}
+			}
+			if op == "" {
+				if !strings.ContainsRune("+-*/%^$=<>#!(),", rune(c)) {
+					return nil, fmt.Errorf("syntax error at offset %d: unexpected %q", i, string(c))
+				}
+				op = string(c)
+			}
+			tokens = append(tokens, token{tokOperator, op, i})
+			i += len(op)
+		}
+	}
+	return append(tokens, token{tokEnd, "", len(s)}), nil
+}
+
+func isDigit(c byte) bool { return c >= '0' && c <= '9' }
+
+func isIdentStart(c byte) bool {
+	return c == '_' || (c >= 'A' && c <= 'Z') || (c >= 'a' && c <= 'z')
+}
+
+func isIdentPart(c byte) bool { return isIdentStart(c) || isDigit(c) }
+
+// =========================================================================
+// PARSER
+// =========================================================================
+
+// parser is a recursive descent parser over the tokens of an expression
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+// parse parses an expression into an unchecked tree
+func parse(source string) (Node, error) {
+	if strings.TrimSpace(source) == "" {
+		return nil, fmt.Errorf("empty expression")
+	}
+	tokens, err := lex(source)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.peek().kind != tokEnd {
+		return nil, p.errorf("unexpected %q", p.peek().text)
+	}
+	return root, nil
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	tok := p.tokens[p.pos]
+	if tok.kind != tokEnd {
+		p.pos++
+	}
+	return tok
+}
+
+func (p *parser) errorf(format string, args ...any) error {
+	tok := p.peek()
+	if tok.kind == tokEnd {
+		return fmt.Errorf("syntax error at end of expression: "+format, args...)
+	}
+	return fmt.Errorf("syntax error at offset %d: "+format, append([]any{tok.pos}, args...)...)
+}
+
+// acceptOperator consumes the next token when it is one of ops
+func (p *parser) acceptOperator(ops ...string) (token, bool) {
+	tok := p.peek()
+	if tok.kind != tokOperator {
+		return tok, false
+	}
+	for _, op := range ops {
+		if tok.text == op {
+			p.pos++
+			return tok, true
+		}
+	}
+	return tok, false
+}
+
+func (p *parser) expectOperator(op string) error {
+	if _, ok := p.acceptOperator(op); !ok {
+		return p.errorf("expected %q", op)
+	}
+	return nil
+}
+
+// binaryLevel parses a left associative chain of operators
+func (p *parser) binaryLevel(operand func() (Node, error), ops ...string) (Node, error) {
+	left, err := operand()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		tok, ok := p.acceptOperator(ops...)
+		if !ok {
+			return left, nil
+		}
+		right, err := operand()
+		if err != nil {
+			return nil, err
+		}
+		left = &Binary{info: info{pos: tok.pos}, Op: normalOperator(tok.text), X: left, Y: right}
+	}
+}
+
+// normalOperator maps operator spellings onto one form
+func normalOperator(op string) string {
+	switch op {
+	case "!=", "#":
+		return "<>"
+	case "**":
+		return "^"
+	}
+	return op
+}
+
+func (p *parser) parseOr() (Node, error) {
+	return p.binaryLevel(p.parseAnd, ".OR.")
+}
+
+func (p *parser) parseAnd() (Node, error) {
+	return p.binaryLevel(p.parseNot, ".AND.")
+}
+
+func (p *parser) parseNot() (Node, error) {
+	if tok, ok := p.acceptOperator(".NOT.", "!"); ok {
+		x, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return &Unary{info: info{pos: tok.pos}, Op: ".NOT.", X: x}, nil
+	}
+	return p.parseComparison()
+}
+
+func (p *parser) parseComparison() (Node, error) {
+	left, err := p.parseAdditive()
+	if err != nil {
+		return nil, err
+	}
+	tok, ok := p.acceptOperator("=", "==", "<>", "!=", "#", "<", ">", "<=", ">=", "$")
+	if !ok {
+		return left, nil
+	}
+	right, err := p.parseAdditive()
+	if err != nil {
+		return nil, err
+	}
+	return &Binary{info: info{pos: tok.pos}, Op: normalOperator(tok.text), X: left, Y: right}, nil
+}
+
+func (p *parser) parseAdditive() (Node, error) {
+	return p.binaryLevel(p.parseMultiplicative, "+", "-")
+}
+
+func (p *parser) parseMultiplicative() (Node, error) {
+	return p.binaryLevel(p.parsePower, "*", "/", "%")
+}
+
+func (p *parser) parsePower() (Node, error) {
+	return p.binaryLevel(p.parseUnary, "^", "**")
+}
+
+func (p *parser) parseUnary() (Node, error) {
+	if tok, ok := p.acceptOperator("-", "+"); ok {
+		x, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &Unary{info: info{pos: tok.pos}, Op: tok.text, X: x}, nil
+	}
+	return p.parsePrimary()
+}
+
+//nolint:gocyclo // one case per kind of primary expression
+func (p *parser) parsePrimary() (Node, error) {
+	tok := p.peek()
+	switch tok.kind {
+	case tokNumber:
+		p.pos++
+		n, err := strconv.ParseFloat(tok.text, 64)
+		if err != nil {
+			return nil, fmt.Errorf("syntax error at offset %d: invalid number %q", tok.pos, tok.text)
+		}
+		lit := newLiteral(tok.pos, num(n))
+		lit.len = max(len(tok.text), 10)
+		if dot := strings.IndexByte(tok.text, '.'); dot >= 0 && !strings.ContainsAny(tok.text, "eE") {
+			lit.dec = len(tok.text) - dot - 1
+		}
+		return lit, nil
+
+	case tokString:
+		p.pos++
+		return newLiteral(tok.pos, str(tok.text)), nil
+
+	case tokDate:
+		p.pos++
+		v, err := parseDateLiteral(tok.text)
+		if err != nil {
+			return nil, fmt.Errorf("syntax error at offset %d: %v", tok.pos, err)
+		}
+		return newLiteral(tok.pos, v), nil
+
+	case tokIdent:
+		p.pos++
+		if _, ok := p.acceptOperator("("); ok {
+			return p.parseCall(tok)
+		}
+		if _, ok := p.acceptOperator("->", "."); ok {
+			return p.parseQualified(tok)
+		}
+		return &FieldRef{info: info{pos: tok.pos}, Name: tok.text}, nil
+
+	case tokOperator:
+		switch tok.text {
+		case "(":
+			p.pos++
+			n, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expectOperator(")"); err != nil {
+				return nil, err
+			}
+			return n, nil
+		case ".T.", ".Y.":
+			p.pos++
+			return newLiteral(tok.pos, logical(true)), nil
+		case ".F.", ".N.":
+			p.pos++
+			return newLiteral(tok.pos, logical(false)), nil
+		case ".NULL.":
+			p.pos++
+			return newLiteral(tok.pos, nullOf(Null)), nil
+		}
+	}
+	if tok.kind == tokEnd {
+		return nil, p.errorf("expression expected")
+	}
+	return nil, p.errorf("unexpected %q", tok.text)
+}
+
+// parseQualified parses the field name after ALIAS->
+func (p *parser) parseQualified(alias token) (Node, error) {
+	name := p.next()
+	if name.kind != tokIdent {
+		return nil, p.errorf("field name expected after alias %s", alias.text)
+	}
+	return &FieldRef{info: info{pos: alias.pos}, Alias: alias.text, Name: name.text}, nil
+}
+
+// parseCall parses the arguments of a function call
+func (p *parser) parseCall(name token) (Node, error) {
+	call := &Call{info: info{pos: name.pos}, Name: name.text}
+	if _, ok := p.acceptOperator(")"); ok {
+		return call, nil
+	}
+	for {
+		arg, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		call.Args = append(call.Args, arg)
+		if _, ok := p.acceptOperator(","); !ok {
+			break
+		}
+	}
+	if err := p.expectOperator(")"); err != nil {
+		return nil, err
+	}
+	return call, nil
+}
+
+// parseDateLiteral parses the text between the braces of a date or
+// datetime constant. Only the strict {^yyyy-mm-dd [hh:mm[:ss] [AM|PM]]}
+// form is accepted, as its meaning does not depend on SET DATE; {}, {//}
+// and {/:} are blank.
+func parseDateLiteral(text string) (value, error) {
+	text = strings.TrimSpace(text)
+	switch strings.ReplaceAll(text, " ", "") {
+	case "", "//", "--", "..", "^":
+		return date(time.Time{}), nil
+	case "/:", "//:", ":":
+		return stamp(time.Time{}), nil
+	}
+	if !strings.HasPrefix(text, "^") {
+		return value{}, fmt.Errorf("ambiguous date %q, write it as {^yyyy-mm-dd}", text)
+	}
+	text = strings.TrimSpace(text[1:])
+
+	datePart, timePart := text, ""
+	if i := strings.IndexAny(text, " ,T"); i >= 0 {
+		datePart, timePart = text[:i], strings.TrimSpace(text[i+1:])
+	}
+	fields := strings.FieldsFunc(datePart, func(r rune) bool { return r == '-' || r == '/' || r == '.' })
+	if len(fields) != 3 {
+		return value{}, fmt.Errorf("invalid date %q", text)
+	}
+	var ymd [3]int
+	for i, f := range fields {
+		n, err := strconv.Atoi(f)
+		if err != nil {
+			return value{}, fmt.Errorf("invalid date %q", text)
+		}
+		ymd[i] = n
+	}
+	d := time.Date(ymd[0], time.Month(ymd[1]), ymd[2], 0, 0, 0, 0, time.UTC)
+	if d.Year() != ymd[0] || int(d.Month()) != ymd[1] || d.Day() != ymd[2] {
+		return value{}, fmt.Errorf("invalid date %q", text)
+	}
+	if timePart == "" {
+		return date(d), nil
+	}
+
+	pm, am := false, false
+	upper := strings.ToUpper(timePart)
+	if strings.HasSuffix(upper, "PM") || strings.HasSuffix(upper, "AM") {
+		pm, am = strings.HasSuffix(upper, "PM"), strings.HasSuffix(upper, "AM")
+		timePart = strings.TrimSpace(timePart[:len(timePart)-2])
+	}
+	parts := strings.Split(timePart, ":")
+	if len(parts) > 3 {
+		return value{}, fmt.Errorf("invalid time %q", timePart)
+	}
+	var hms [3]int
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil {
+			return value{}, fmt.Errorf("invalid time %q", timePart)
+		}
+		hms[i] = n
+	}
+	if pm && hms[0] < 12 {
+		hms[0] += 12
+	} else if am && hms[0] == 12 {
+		hms[0] = 0
+	}
+	if hms[0] > 23 || hms[1] > 59 || hms[2] > 59 {
+		return value{}, fmt.Errorf("invalid time %q", timePart)
+	}
+	return stamp(d.Add(time.Duration(hms[0])*time.Hour + time.Duration(hms[1])*time.Minute + time.Duration(hms[2])*time.Second)), nil
+}
@@ -0,0 +1,201 @@
+package expr
+
+import (
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+func init() {
+	register(map[string]*function{
+		"TRANSFORM": {minArgs: 1, maxArgs: 2, check: checkTransform, eval: evalTransform},
+	})
+}
+
+// picture is a parsed TRANSFORM format: @ function codes followed by an
+// optional template
+type picture struct {
+	functions string // Function codes, upper case, without the @
+	template  string
+}
+
+// parsePicture splits a format into its function codes and template
+func parsePicture(format string) picture {
+	if !strings.HasPrefix(format, "@") {
+		return picture{template: format}
+	}
+	codes, template, _ := strings.Cut(format[1:], " ")
+	return picture{functions: strings.ToUpper(codes), template: template}
+}
+
+func (p picture) has(code byte) bool {
+	return strings.IndexByte(p.functions, code) >= 0
+}
+
+func checkTransform(n *Call) error {
+	n.typ, n.len = Character, text(n.Args[0], n.dates)
+	if len(n.Args) > 1 {
+		if format, ok := constant(n.Args[1]); ok && format.typ == Character {
+			if template := parsePicture(format.s).template; template != "" {
+				n.len = utf8.RuneCountInString(template)
+			}
+		}
+	}
+	return checkArgs(n, "*", "C")
+}
+
+// evalTransform formats a value with a picture: the function codes ! (upper
+// case), R (template literals are inserted), Z (blank when zero), L
+// (leading zeros), B (left-justified) and T (trimmed), and a template of
+// 9 and # for digits, X for any character, ! for an upper case letter and
+// A and N for letters and letters or digits. Without a format the value
+// is converted the way it is shown.
+func evalTransform(_ *context, n *Call, args []value) (value, error) {
+	v := args[0]
+	if len(args) < 2 {
+		return str(toText(v, n.dates)), nil
+	}
+	p := parsePicture(args[1].s)
+
+	var s string
+	switch {
+	case (v.typ == Numeric || v.typ == Integer) && p.has('Z') && v.n == 0:
+		s = strings.Repeat(" ", max(utf8.RuneCountInString(p.template), 1))
+	case v.typ == Numeric || v.typ == Integer:
+		s = transformNumber(v.n, p)
+	case v.typ == Character:
+		s = transformText(v.s, p)
+	default:
+		s = transformText(toText(v, n.dates), p)
+	}
+
+	if p.has('B') {
+		trimmed := strings.TrimLeft(s, " ")
+		s = trimmed + strings.Repeat(" ", len(s)-len(trimmed))
+	}
+	if p.has('T') {
+		s = strings.Trim(s, " ")
+	}
+	if p.has('!') {
+		s = strings.ToUpper(s)
+	}
+	return str(s), nil
+}
+
+// transformText lays text out over a template. Without the R code a
+// literal of the template takes the place of a character of the text.
+func transformText(s string, p picture) string {
+	if p.template == "" {
+		return s
+	}
+	text := []rune(s)
+	var b strings.Builder
+	for _, t := range p.template {
+		if !strings.ContainsRune("9#XANL!Y", t) {
+			b.WriteRune(t)
+			if !p.has('R') && len(text) > 0 {
+				text = text[1:]
+			}
+			continue
+		}
+		if len(text) == 0 {
+			b.WriteRune(' ')
+			continue
+		}
+		c := text[0]
+		text = text[1:]
+		switch t {
+		case '!':
+			c = unicode.ToUpper(c)
+		case '9', '#':
+			if !unicode.IsDigit(c) && !strings.ContainsRune(" +-.", c) {
+				c = ' '
+			}
+		case 'A':
+			if !unicode.IsLetter(c) {
+				c = ' '
+			}
+		case 'N':
+			if !unicode.IsLetter(c) && !unicode.IsDigit(c) {
+				c = ' '
+			}
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
+
+// transformNumber lays a number out over a template of digit places, a
+// decimal point and separators, showing asterisks when it does not fit
+func transformNumber(x float64, p picture) string {
+	if p.template == "" {
+		return strconv.FormatFloat(x, 'f', -1, 64)
+	}
+
+	template := []rune(p.template)
+	dot := len(template)
+	for i, t := range template {
+		if t == '.' {
+			dot = i
+			break
+		}
+	}
+	dec := 0
+	for _, t := range template[min(dot+1, len(template)):] {
+		if t == '9' || t == '#' {
+			dec++
+		}
+	}
+	digits := strconv.FormatFloat(round(x, dec), 'f', dec, 64)
+	negative := strings.HasPrefix(digits, "-")
+	digits = strings.TrimPrefix(digits, "-")
+	whole, fraction, _ := strings.Cut(digits, ".")
+
+	out := make([]rune, len(template))
+	// Fill the decimal places left to right
+	f := []rune(fraction)
+	for i := dot + 1; i < len(template); i++ {
+		switch t := template[i]; t {
+		case '9', '#':
+			out[i], f = f[0], f[1:]
+		default:
+			out[i] = t
+		}
+	}
+	if dot < len(template) {
+		out[dot] = '.'
+	}
+
+	// Fill the whole part right to left; separators only appear between
+	// digits
+	w := []rune(whole)
+	fill := ' '
+	if p.has('L') {
+		fill = '0'
+	}
+	for i := dot - 1; i >= 0; i-- {
+		switch t := template[i]; t {
+		case '9', '#':
+			if len(w) > 0 {
+				out[i], w = w[len(w)-1], w[:len(w)-1]
+			} else if negative {
+				out[i], negative = '-', false
+			} else {
+				out[i] = fill
+			}
+		case ',':
+			if len(w) > 0 {
+				out[i] = t
+			} else {
+				out[i] = fill
+			}
+		default:
+			out[i] = t
+		}
+	}
+	if len(w) > 0 || negative {
+		return strings.Repeat("*", len(template))
+	}
+	return string(out)
+}
@@ -0,0 +1,102 @@
+package expr
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Func is a Go function registered with RegisterFunc. It receives one
+// argument per declared type, as the Go values Eval returns: a string,
+// float64, time.Time or bool. It returns a value of the declared result
+// type, which may be any Go number for numeric results, or nil for null.
+//
+// A null argument makes the call null without calling the function, as it
+// does for most built-in functions.
+type Func func(args []any) (any, error)
+
+// RegisterFunc makes a Go function callable from expressions compiled
+// after it returns. The declared argument types set the number of
+// arguments and are checked when an expression is compiled, so a call
+// with the wrong number or types of arguments is a compile error; an
+// Integer argument accepts any number.
+//
+// Character results have no fixed length. An index key on one needs the
+// length given explicitly, as in PADR(MYKEY(NAME), 20).
+//
+// Names are case-insensitive. A function registered again replaces the
+// earlier one in expressions compiled afterwards; built-in functions
+// cannot be replaced.
+func RegisterFunc(name string, fn Func, argTypes []Type, resultType Type) error {
+	name = strings.ToUpper(name)
+	if !isIdentifier(name) {
+		return fmt.Errorf("invalid function name %q", name)
+	}
+	if fn == nil {
+		return fmt.Errorf("function %s: nil function", name)
+	}
+	if !isValueType(resultType) {
+		return fmt.Errorf("function %s: invalid result type %s", name, resultType)
+	}
+	specs := make([]string, len(argTypes))
+	for i, typ := range argTypes {
+		if !isValueType(typ) {
+			return fmt.Errorf("function %s: argument %d has invalid type %s", name, i+1, typ)
+		}
+		specs[i] = typ.family().String()
+	}
+
+	length := map[Type]int{Character: 0, Numeric: 20, Integer: 10, Date: 8, DateTime: 8, Logical: 1}[resultType]
+	entry := &function{
+		minArgs: len(argTypes),
+		maxArgs: len(argTypes),
+		check:   typed(resultType, length, specs...),
+		eval:    evalFunc(fn, resultType),
+		user:    true,
+	}
+
+	functionsMu.Lock()
+	defer functionsMu.Unlock()
+	if existing, ok := functions[name]; ok && !existing.user {
+		return fmt.Errorf("function %s is built in", name)
+	}
+	functions[name] = entry
+	return nil
+}
+
+// evalFunc calls a registered function with the Go values of its
+// arguments and checks the type of its result
+func evalFunc(fn Func, resultType Type) func(*context, *Call, []value) (value, error) {
+	return func(_ *context, _ *Call, args []value) (value, error) {
+		in := make([]any, len(args))
+		for i, arg := range args {
+			in[i] = arg.toAny()
+		}
+		out, err := fn(in)
+		if err != nil {
+			return value{}, err
+		}
+		return fromAny(out, resultType)
+	}
+}
+
+// isValueType reports whether a type can be an argument or result
+func isValueType(t Type) bool {
+	switch t {
+	case Character, Numeric, Integer, Date, DateTime, Logical:
+		return true
+	}
+	return false
+}
+
+// isIdentifier reports whether a name can be written as a function call
+func isIdentifier(name string) bool {
+	if name == "" || !isIdentStart(name[0]) || wordOperators[name] != "" {
+		return false
+	}
+	for i := 1; i < len(name); i++ {
+		if !isIdentPart(name[i]) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,182 @@
+package expr
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// value is an evaluated result. Only the member of its type is set.
+type value struct {
+	typ  Type
+	null bool
+	s    string
+	n    float64
+	t    time.Time
+	b    bool
+}
+
+// nullOf returns the null value of a type
+func nullOf(typ Type) value {
+	return value{typ: typ, null: true}
+}
+
+func str(s string) value      { return value{typ: Character, s: s} }
+func num(n float64) value     { return value{typ: Numeric, n: n} }
+func logical(b bool) value    { return value{typ: Logical, b: b} }
+func date(t time.Time) value  { return value{typ: Date, t: t} }
+func stamp(t time.Time) value { return value{typ: DateTime, t: t} }
+
+// toAny converts a value to the Go value Program.Eval returns
+func (v value) toAny() any {
+	if v.null {
+		return nil
+	}
+	switch v.typ {
+	case Character:
+		return v.s
+	case Numeric, Integer:
+		return v.n
+	case Date, DateTime:
+		return v.t
+	case Logical:
+		return v.b
+	}
+	return nil
+}
+
+// fromAny converts a value read from a column of the given type
+func fromAny(x any, typ Type) (value, error) {
+	if x == nil {
+		return nullOf(typ), nil
+	}
+	switch typ {
+	case Character:
+		switch s := x.(type) {
+		case string:
+			return str(s), nil
+		case []byte:
+			return str(string(s)), nil
+		}
+	case Numeric, Integer:
+		rv := reflect.ValueOf(x)
+		switch {
+		case rv.CanInt():
+			return num(float64(rv.Int())), nil
+		case rv.CanUint():
+			return num(float64(rv.Uint())), nil
+		case rv.CanFloat():
+			return num(rv.Float()), nil
+		}
+	case Date:
+		if t, ok := x.(time.Time); ok {
+			if t.IsZero() {
+				return date(t), nil
+			}
+			y, m, d := t.Date()
+			return date(time.Date(y, m, d, 0, 0, 0, 0, time.UTC)), nil
+		}
+	case DateTime:
+		if t, ok := x.(time.Time); ok {
+			if t.IsZero() {
+				return stamp(t), nil
+			}
+			return stamp(t.UTC()), nil
+		}
+	case Logical:
+		if b, ok := x.(bool); ok {
+			return logical(b), nil
+		}
+	}
+	return value{}, fmt.Errorf("cannot use %T as a %s value", x, typ)
+}
+
+// compare orders two non-null values of the same family. With exact
+// false, character values compare the way = does with SET EXACT OFF: the
+// comparison stops at the end of the right operand.
+func compare(x, y value, exact bool) int {
+	switch x.typ {
+	case Character:
+		a, b := x.s, y.s
+		if !exact && utf8.RuneCountInString(a) > utf8.RuneCountInString(b) {
+			a = truncateRunes(a, utf8.RuneCountInString(b))
+		}
+		if exact {
+			return strings.Compare(a, b)
+		}
+		// Blanks pad the shorter string, so trailing blanks do not count
+		return strings.Compare(strings.TrimRight(a, " "), strings.TrimRight(b, " "))
+	case Logical:
+		switch {
+		case x.b == y.b:
+			return 0
+		case !x.b:
+			return -1
+		}
+		return 1
+	case Date, DateTime:
+		return x.t.Compare(y.t)
+	}
+	switch {
+	case x.n < y.n:
+		return -1
+	case x.n > y.n:
+		return 1
+	}
+	return 0
+}
+
+// truncateRunes returns the first n characters of s
+func truncateRunes(s string, n int) string {
+	if n <= 0 {
+		return ""
+	}
+	i := 0
+	for count := 0; i < len(s) && count < n; count++ {
+		_, size := utf8.DecodeRuneInString(s[i:])
+		i += size
+	}
+	return s[:i]
+}
+
+// padRunes pads or cuts s to n characters
+func padRunes(s string, n int) string {
+	count := utf8.RuneCountInString(s)
+	if count >= n {
+		return truncateRunes(s, n)
+	}
+	return s + strings.Repeat(" ", n-count)
+}
+
+// isEmpty reports whether a value is empty in the sense of EMPTY(): blank
+// text, zero, a blank date or .F.
+func (v value) isEmpty() bool {
+	switch v.typ {
+	case Character:
+		return strings.TrimLeft(v.s, " \t\r\n") == ""
+	case Numeric, Integer:
+		return v.n == 0
+	case Date, DateTime:
+		return v.t.IsZero()
+	case Logical:
+		return !v.b
+	}
+	return true
+}
+
+// round rounds half away from zero to dec decimal places
+func round(x float64, dec int) float64 {
+	if dec < 0 {
+		p := math.Pow(10, float64(-dec))
+		return math.Round(x/p) * p
+	}
+	p := math.Pow(10, float64(dec))
+	r := math.Round(x*p) / p
+	if math.IsInf(r, 0) || math.IsNaN(r) {
+		return x
+	}
+	return r
+}
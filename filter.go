@@ -0,0 +1,353 @@
+package foxi
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/mkfoss/foxi/expr"
+)
+
+// SetFilter restricts navigation to the records for which a logical
+// expression is true, as SET FILTER TO does in Visual FoxPro. First,
+// Last, Next, Previous and Skip pass over the other records in record
+// order and in tag order alike, and EOF and BOF are reached after the last
+// and before the first matching record. Records and the Range and Prefix
+// iterators of tags only visit matching records; Goto and the Seek
+// methods of tags still move to any record.
+//
+// First and Last plan the filter with the table's tags, as Rushmore does
+// in Visual FoxPro: conditions comparing a tag's key expression with a
+// constant are answered from the tag, and the rest of the filter is only
+// evaluated on the records the tags leave (see Explain). The filter is
+// otherwise evaluated as the table moves, so it sees changes made after it
+// was set; records written with Write, Delete or Recall after planning
+// are tested with the whole filter.
+//
+// When the selected tag is ascending and the filter requires its key to
+// start with a constant, as UPPER(NAME) = "SM" does for a tag on
+// UPPER(NAME), navigation seeks the tag and stops at the end of the
+// matching keys instead of reading every record.
+//
+// An empty expression clears the filter. The record pointer does not
+// move; closing the table clears the filter.
+func (f *Foxi) SetFilter(source string) error {
+	if strings.TrimSpace(source) == "" {
+		f.ClearFilter()
+		return nil
+	}
+	prog, err := Compile(f, source)
+	if err != nil {
+		return err
+	}
+	if prog.Type() != expr.Logical {
+		return fmt.Errorf("filter %s is not a logical expression", source)
+	}
+	*f.impl.recordFilter() = recordFilter{prog: prog, env: &tableEnv{f: f}}
+	return nil
+}
+
+// ClearFilter removes the filter set with SetFilter.
+func (f *Foxi) ClearFilter() {
+	f.impl.recordFilter().clear()
+}
+
+// Filter returns the expression set with SetFilter, empty when there is
+// no filter.
+func (f *Foxi) Filter() string {
+	if prog := f.impl.recordFilter().prog; prog != nil {
+		return prog.Source()
+	}
+	return ""
+}
+
+// MustSetFilter restricts navigation to the records matching an expression.
+// Panics if the expression cannot be compiled.
+func (f *Foxi) MustSetFilter(source string) {
+	if err := f.SetFilter(source); err != nil {
+		panic(err)
+	}
+}
+
+// recordFilter is the filter of a table, set with Foxi.SetFilter. The
+// zero value lets every record through.
+type recordFilter struct {
+	prog *expr.Program
+	env  expr.Env
+
+	// Prefixes the filter requires of the keys of tags, by tag name and
+	// expression; nil for tags it cannot be narrowed to
+	prefixes map[string]*keyPrefix
+
+	// The records the tags leave to test, planned by First and Last
+	plan *filterPlan
+}
+
+// keyPrefix is a constant the keys of a tag must start with
+type keyPrefix struct {
+	text string // As written in the filter, for seeking
+	key  string // Encoded in the table's code page
+}
+
+// clear removes the filter
+func (rf *recordFilter) clear() {
+	*rf = recordFilter{}
+}
+
+// dropPlan drops the plan of the filter, as after the records have been
+// renumbered or changed wholesale, until First or Last makes a new one
+func (rf *recordFilter) dropPlan() {
+	rf.plan = nil
+}
+
+// changed notes that a record has been written since the filter was
+// planned, so that it is tested with the whole filter
+func (rf *recordFilter) changed(n int) {
+	if rf.plan != nil && rf.plan.candidates != nil && n > 0 {
+		rf.plan.candidates.add(n)
+		rf.plan.changed.add(n)
+	}
+}
+
+// makePlan plans the filter afresh from the current contents of the
+// table and its tags
+func (rf *recordFilter) makePlan(impl foxiImpl) error {
+	plan, err := planFilter(impl, rf)
+	rf.plan = plan
+	return err
+}
+
+// match tests the current record: records the tags rule out fail, and
+// the others are tested with what the tags leave of the filter
+func (rf *recordFilter) match(impl foxiImpl) (bool, error) {
+	fp := rf.plan
+	n := impl.Position()
+	if fp == nil || fp.candidates == nil || n > fp.records || fp.changed.has(n) {
+		return rf.prog.EvalBool()
+	}
+	if !fp.candidates.has(n) {
+		return false, nil
+	}
+	if fp.residual == nil {
+		return true, nil
+	}
+	return fp.residual.EvalBool()
+}
+
+// prefix returns the selected tag and the prefix the filter requires of
+// its keys, or nil when navigation cannot be narrowed to one
+func (rf *recordFilter) prefix(impl foxiImpl) (Tag, *keyPrefix) {
+	tag := impl.Indexes().SelectedTag()
+	if tag == nil || tag.IsDescending() {
+		return nil, nil
+	}
+	id := tag.Name() + "\x00" + tag.Expression()
+	p, ok := rf.prefixes[id]
+	if !ok {
+		p = rf.findPrefix(impl, tag)
+		if rf.prefixes == nil {
+			rf.prefixes = map[string]*keyPrefix{}
+		}
+		rf.prefixes[id] = p
+	}
+	if p == nil {
+		return nil, nil
+	}
+	return tag, p
+}
+
+// findPrefix looks for a condition KEY = "constant" among the operands of
+// .AND. at the top of the filter, where KEY is the character expression
+// of a tag. With SET EXACT OFF, = is true exactly when the key starts
+// with the constant.
+func (rf *recordFilter) findPrefix(impl foxiImpl, tag Tag) *keyPrefix {
+	keyProg, err := expr.Compile(tag.Expression(), rf.env)
+	if err != nil || keyProg.Type() != expr.Character {
+		return nil
+	}
+	for _, cond := range conjuncts(rf.prog.Root()) {
+		b, ok := cond.(*expr.Binary)
+		if !ok || (b.Op != "=" && b.Op != "==") || b.X.String() != keyProg.String() {
+			continue
+		}
+		lit, ok := b.Y.(*expr.Literal)
+		if !ok {
+			continue
+		}
+		text, ok := lit.Value.(string)
+		if !ok || text == "" {
+			continue
+		}
+		key, err := impl.textCodec().encode(text)
+		if err != nil || len(key) > tag.KeyLength() {
+			continue
+		}
+		return &keyPrefix{text: text, key: key}
+	}
+	return nil
+}
+
+// conjuncts splits an expression into the operands of its top-level
+// .AND. operators
+func conjuncts(n expr.Node) []expr.Node {
+	if b, ok := n.(*expr.Binary); ok && b.Op == ".AND." {
+		return append(conjuncts(b.X), conjuncts(b.Y)...)
+	}
+	return []expr.Node{n}
+}
+
+// comparePrefix compares the start of a key with a prefix
+func comparePrefix(key, prefix string) int {
+	if len(key) > len(prefix) {
+		key = key[:len(prefix)]
+	}
+	return strings.Compare(key, prefix)
+}
+
+// Filtered navigation. Without a filter these are the moves of the
+// backend; with one they move on from records that fail it, in the
+// direction of the move.
+
+// first moves to the first record that passes the filter
+func first(impl foxiImpl) error {
+	rf := impl.recordFilter()
+	if rf.prog == nil {
+		return impl.First()
+	}
+	if err := rf.makePlan(impl); err != nil {
+		return err
+	}
+	if tag, prefix := rf.prefix(impl); prefix != nil {
+		return enterPrefix(impl, tag, prefix, 1)
+	}
+	if err := impl.First(); err != nil {
+		return err
+	}
+	return settle(impl, 1)
+}
+
+// last moves to the last record that passes the filter
+func last(impl foxiImpl) error {
+	rf := impl.recordFilter()
+	if rf.prog == nil {
+		return impl.Last()
+	}
+	if err := rf.makePlan(impl); err != nil {
+		return err
+	}
+	if tag, prefix := rf.prefix(impl); prefix != nil {
+		return enterPrefix(impl, tag, prefix, -1)
+	}
+	if err := impl.Last(); err != nil {
+		return err
+	}
+	return settle(impl, -1)
+}
+
+// skip moves count records that pass the filter forwards, or backwards
+// when count is negative
+func skip(impl foxiImpl, count int) error {
+	if impl.recordFilter().prog == nil || count == 0 {
+		return impl.Skip(count)
+	}
+	step := 1
+	if count < 0 {
+		step, count = -1, -count
+	}
+	for ; count > 0; count-- {
+		var err error
+		switch {
+		case step > 0 && impl.EOF(), step < 0 && impl.BOF():
+			return nil
+		case step > 0 && impl.BOF():
+			err = first(impl)
+		case step < 0 && impl.EOF():
+			err = last(impl)
+		default:
+			if err = impl.Skip(step); err == nil {
+				err = settle(impl, step)
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// settle stays on the current record when it passes the filter, and
+// otherwise moves on in the direction of step to the next one that does,
+// or off the end of the table. In record order it goes straight to the
+// next record the plan of the filter leaves to test.
+func settle(impl foxiImpl, step int) error {
+	rf := impl.recordFilter()
+	if rf.prog == nil {
+		return nil
+	}
+	plan := rf.plan
+	tag, prefix := rf.prefix(impl)
+	jump := plan != nil && plan.candidates != nil && impl.Indexes().SelectedTag() == nil
+	for !impl.EOF() && !impl.BOF() {
+		if prefix != nil {
+			switch cmp := comparePrefix(tag.CurrentKey(), prefix.key); {
+			case cmp*step > 0:
+				return leavePrefix(impl, tag, step)
+			case cmp != 0:
+				return enterPrefix(impl, tag, prefix, step)
+			}
+		}
+		ok, err := rf.match(impl)
+		if err != nil || ok {
+			return err
+		}
+		if jump {
+			err = plan.jump(impl, step)
+		} else {
+			err = impl.Skip(step)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// enterPrefix seeks the first key starting with the prefix, or the last
+// one when step is negative, and settles from there
+func enterPrefix(impl foxiImpl, tag Tag, prefix *keyPrefix, step int) error {
+	result, err := tag.SeekString(prefix.text)
+	if err != nil {
+		return err
+	}
+	if result == SeekEOF || !strings.HasPrefix(tag.CurrentKey(), prefix.key) {
+		return leavePrefix(impl, tag, step)
+	}
+	if step < 0 {
+		for !impl.EOF() && strings.HasPrefix(tag.CurrentKey(), prefix.key) {
+			if err := impl.Skip(1); err != nil {
+				return err
+			}
+		}
+		if impl.EOF() {
+			err = tag.Last()
+		} else {
+			err = impl.Skip(-1)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return settle(impl, step)
+}
+
+// leavePrefix moves past the last record of the tag, or before the first
+// when step is negative, as no record further on has the prefix
+func leavePrefix(impl foxiImpl, tag Tag, step int) error {
+	end := tag.Last
+	if step < 0 {
+		end = tag.First
+	}
+	if err := end(); err != nil {
+		return err
+	}
+	return impl.Skip(step)
+}
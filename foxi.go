@@ -30,6 +30,9 @@ package foxi
 
 import (
 	"fmt"
+	"iter"
+	"path/filepath"
+	"strings"
 	"time"
 )
 
@@ -66,7 +69,9 @@ func (b Backend) String() string {
 //
 // Create instances using NewFoxi() function.
 type Foxi struct {
-	impl foxiImpl // Backend implementation (selected by build tags)
+	impl    foxiImpl // Backend implementation (selected by build tags)
+	session *Session // Session the table was opened in, nil for a table of its own
+	alias   string   // Alias given to Session.OpenAs, empty for the file name
 }
 
 // NewFoxi creates a new Foxi instance with the appropriate backend.
@@ -79,6 +84,7 @@ type foxiImpl interface {
 	Open(filename string) error
 	Close() error
 	Active() bool
+	Filename() string
 
 	// Header information
 	Header() Header
@@ -105,16 +111,55 @@ type foxiImpl interface {
 	Delete() error
 	Recall() error
 
+	// Record writing
+	Append() error
+	Write() error
+
+	// Transactions
+	Begin() error
+	Commit() error
+	Rollback() error
+
+	// Table maintenance
+	Pack(progress ProgressFunc) error
+	Zap() error
+	PackMemo(progress ProgressFunc) error
+
+	// Locking
+	LockRecord(recordNumber int) error
+	LockAppend() error
+	LockFile() error
+	Unlock() error
+	SetLockRetry(attempts int, delay time.Duration)
+
+	// String translation
+	textCodec() *textCodec
+
+	// Filter set with SetFilter
+	recordFilter() *recordFilter
+
+	// Relations set with SetRelation
+	relations() *relations
+
 	// Index operations
 	Indexes() *Indexes
 
 	// Backend information
 	Backend() Backend
+
+	// Read-only cursor sharing the open table (see Foxi.Cursor)
+	cursor() (foxiImpl, error)
 }
 
 // Open establishes a connection to the specified DBF file.
 // The filename should include the full path and .dbf extension.
+//
+// A table of a Session is opened in the session again, under the alias
+// its file name gives.
 func (f *Foxi) Open(filename string) error {
+	if f.session != nil {
+		return f.session.open(f, filename, "")
+	}
 	return f.impl.Open(filename)
 }
 
@@ -130,6 +175,32 @@ func (f *Foxi) Active() bool {
 	return f.impl.Active()
 }
 
+// Alias returns the name that qualifies the table's fields in expressions,
+// as in ALIAS.FIELD: the alias given to Session.OpenAs, otherwise the file
+// name without its extension, in upper case. It is empty when no table is
+// open.
+func (f *Foxi) Alias() string {
+	if f.alias != "" && f.impl.Active() {
+		return f.alias
+	}
+	return fileAlias(f.impl.Filename())
+}
+
+// fileAlias returns the alias a file name gives a table
+func fileAlias(filename string) string {
+	name := filepath.Base(filename)
+	if name == "." {
+		return ""
+	}
+	return strings.ToUpper(strings.TrimSuffix(name, filepath.Ext(name)))
+}
+
+// Session returns the Session the table was opened in, nil when the table
+// was opened on its own.
+func (f *Foxi) Session() *Session {
+	return f.session
+}
+
 // Header returns the database file header information.
 func (f *Foxi) Header() Header {
 	return f.impl.Header()
@@ -158,32 +229,79 @@ func (f *Foxi) FieldByName(name string) Field {
 
 // Goto moves to the specified record number (1-indexed).
 func (f *Foxi) Goto(recordNumber int) error {
-	return f.impl.Goto(recordNumber)
+	if err := f.impl.Goto(recordNumber); err != nil {
+		return err
+	}
+	return relate(f.impl, 1)
 }
 
 // First moves to the first record in the current order.
+// With a filter set, it moves to the first record matching it.
 func (f *Foxi) First() error {
-	return f.impl.First()
+	if err := first(f.impl); err != nil {
+		return err
+	}
+	return relate(f.impl, 1)
 }
 
 // Last moves to the last record in the current order.
+// With a filter set, it moves to the last record matching it.
 func (f *Foxi) Last() error {
-	return f.impl.Last()
+	if err := last(f.impl); err != nil {
+		return err
+	}
+	return relate(f.impl, -1)
 }
 
-// Next moves to the next record.
+// Next moves to the next record, the next one matching the filter when
+// one is set. With one-to-many relations (see SetSkip) it moves the
+// children on first.
 func (f *Foxi) Next() error {
-	return f.impl.Next()
+	if len(f.impl.relations().skip) > 0 {
+		return skipRelated(f.impl, 1)
+	}
+	var err error
+	if f.impl.recordFilter().prog != nil {
+		err = skip(f.impl, 1)
+	} else {
+		err = f.impl.Next()
+	}
+	if err != nil {
+		return err
+	}
+	return relate(f.impl, 1)
 }
 
-// Previous moves to the previous record.
+// Previous moves to the previous record, the previous one matching the
+// filter when one is set. With one-to-many relations (see SetSkip) it
+// moves the children back first.
 func (f *Foxi) Previous() error {
-	return f.impl.Previous()
+	if len(f.impl.relations().skip) > 0 {
+		return skipRelated(f.impl, -1)
+	}
+	var err error
+	if f.impl.recordFilter().prog != nil {
+		err = skip(f.impl, -1)
+	} else {
+		err = f.impl.Previous()
+	}
+	if err != nil {
+		return err
+	}
+	return relate(f.impl, -1)
 }
 
 // Skip skips the specified number of records (positive = forward, negative = backward).
+// With a filter set, only records matching it are counted; with one-to-many
+// relations, every row of the children counts (see SetSkip).
 func (f *Foxi) Skip(count int) error {
-	return f.impl.Skip(count)
+	if len(f.impl.relations().skip) > 0 {
+		return skipRelated(f.impl, count)
+	}
+	if err := skip(f.impl, count); err != nil {
+		return err
+	}
+	return relate(f.impl, count)
 }
 
 // Position returns the current record number (1-indexed).
@@ -208,12 +326,40 @@ func (f *Foxi) Deleted() bool {
 
 // Delete marks the current record for deletion (soft delete).
 func (f *Foxi) Delete() error {
-	return f.impl.Delete()
+	if err := f.impl.Delete(); err != nil {
+		return err
+	}
+	f.impl.recordFilter().changed(f.impl.Position())
+	return nil
 }
 
 // Recall undeletes the current record.
 func (f *Foxi) Recall() error {
-	return f.impl.Recall()
+	if err := f.impl.Recall(); err != nil {
+		return err
+	}
+	f.impl.recordFilter().changed(f.impl.Position())
+	return nil
+}
+
+// Append adds a new blank record at the end of the database and positions on it.
+// Assign values with the Field setters and call Write to store them.
+func (f *Foxi) Append() error {
+	if err := f.impl.Append(); err != nil {
+		return err
+	}
+	return relate(f.impl, 1)
+}
+
+// Write stores the current record buffer, including any values assigned with
+// the Field setters. Pending changes are also written automatically when the
+// record pointer moves or the database is closed.
+func (f *Foxi) Write() error {
+	if err := f.impl.Write(); err != nil {
+		return err
+	}
+	f.impl.recordFilter().changed(f.impl.Position())
+	return nil
 }
 
 // ==========================================================================
@@ -292,6 +438,22 @@ func (f *Foxi) MustRecall() {
 	}
 }
 
+// MustAppend adds a new blank record at the end of the database.
+// Panics if the operation fails.
+func (f *Foxi) MustAppend() {
+	if err := f.Append(); err != nil {
+		panic(err)
+	}
+}
+
+// MustWrite stores the current record buffer.
+// Panics if the operation fails.
+func (f *Foxi) MustWrite() {
+	if err := f.Write(); err != nil {
+		panic(err)
+	}
+}
+
 // Indexes returns the index collection with lazy loading support.
 // Indexes are not loaded until first access.
 func (f *Foxi) Indexes() *Indexes {
@@ -340,7 +502,16 @@ func (h *Header) Codepage() Codepage {
 // Field defines the interface for accessing both field definition information
 // and field value reading capabilities from the current record.
 type Field interface {
-	// Value returns the field's native value in its appropriate Go type
+	// Value returns the field's native value in its appropriate Go type:
+	//
+	//	C, V, M      string (C padded to the field width, V cut to its length)
+	//	N, F, Y, B   float64
+	//	I            int32
+	//	L            bool
+	//	D, T         time.Time in UTC, the zero time when blank
+	//	Q, G, P, W   []byte
+	//
+	// A nullable field holding .NULL. returns nil.
 	Value() (interface{}, error)
 
 	// Type conversion methods
@@ -349,10 +520,21 @@ type Field interface {
 	AsFloat() (float64, error)
 	AsBool() (bool, error)
 	AsTime() (time.Time, error)
+	AsDecimal() (Decimal, error) // Exact digits of N, F, Y, I and B fields
 
-	// Null checking
+	// Null checking - reads the field's null bit in _NullFlags, so blank
+	// values are not null
 	IsNull() (bool, error)
 
+	// Value assignment - changes the current record buffer (see Foxi.Write)
+	SetString(value string) error
+	SetInt(value int) error
+	SetFloat(value float64) error
+	SetBool(value bool) error
+	SetTime(value time.Time) error
+	SetDecimal(value Decimal) error // Errors when the value overflows the field
+	SetNull() error
+
 	// Must variants - panic instead of returning errors
 	MustValue() interface{}
 	MustAsString() string
@@ -360,7 +542,15 @@ type Field interface {
 	MustAsFloat() float64
 	MustAsBool() bool
 	MustAsTime() time.Time
+	MustAsDecimal() Decimal
 	MustIsNull() bool
+	MustSetString(value string)
+	MustSetInt(value int)
+	MustSetFloat(value float64)
+	MustSetBool(value bool)
+	MustSetTime(value time.Time)
+	MustSetDecimal(value Decimal)
+	MustSetNull()
 
 	// Field definition methods
 	Name() string
@@ -372,13 +562,29 @@ type Field interface {
 	IsBinary() bool
 }
 
-// Fields provides access to the database field collection
+// Fields provides access to the database field collection. System fields,
+// such as the _NullFlags field holding the null and varchar length bits,
+// are hidden: Count and ByIndex leave them out, and only ByName returns
+// them, when asked for by name.
 type Fields struct {
-	fields  []Field
-	indices map[string]int // name -> index mapping (case-insensitive)
+	fields []Field          // Fields in record order, system fields left out
+	byName map[string]Field // Every field by lower case name
+}
+
+// newFields builds the field collection from the fields in record order
+func newFields(all []Field) *Fields {
+	f := &Fields{byName: make(map[string]Field, len(all))}
+	for _, field := range all {
+		if !field.IsSystem() {
+			f.fields = append(f.fields, field)
+		}
+		f.byName[strings.ToLower(field.Name())] = field
+	}
+	return f
 }
 
-// Count returns the total number of fields in the database.
+// Count returns the number of fields in the database, system fields not
+// included.
 func (f *Fields) Count() int {
 	return len(f.fields)
 }
@@ -393,16 +599,7 @@ func (f *Fields) ByIndex(index int) Field {
 
 // ByName returns the field with the specified name (case-insensitive).
 func (f *Fields) ByName(name string) Field {
-	if f.indices == nil {
-		return nil
-	}
-
-	index, exists := f.indices[name]
-	if !exists {
-		return nil
-	}
-
-	return f.ByIndex(index)
+	return f.byName[strings.ToLower(name)]
 }
 
 // FieldType represents the data type of a database field
@@ -485,23 +682,40 @@ func (ft FieldType) Name() string {
 	}
 }
 
-// Codepage represents character encoding information
-type Codepage uint8
-
-// String returns the codepage description
-func (c Codepage) String() string {
-	switch c {
-	case 0x03:
-		return "Windows ANSI (1252)"
-	case 0x01:
-		return "U.S. MS-DOS (437)"
-	case 0x02:
-		return "International MS-DOS (850)"
+// isNumeric reports whether the field type stores numbers
+func (ft FieldType) isNumeric() bool {
+	switch ft {
+	case FTNumeric, FTFloat, FTInteger, FTCurrency, FTDouble, FTBlob:
+		return true
 	default:
-		return "Unknown Codepage"
+		return false
 	}
 }
 
+// checkSettable returns an error when a value of the given kind cannot be
+// assigned to the field; the setters of both backends share these rules.
+func checkSettable(field Field, kind string) error {
+	ft := field.Type()
+	ok := false
+	switch kind {
+	case "number":
+		ok = ft.isNumeric()
+	case "bool":
+		ok = ft == FTLogical
+	case "time":
+		ok = ft == FTDate || ft == FTDateTime
+	case "null":
+		ok = field.IsNullable()
+	}
+	if !ok {
+		if kind == "null" {
+			return fmt.Errorf("field %s is not nullable", field.Name())
+		}
+		return fmt.Errorf("cannot assign %s to %s field %s", kind, ft.Name(), field.Name())
+	}
+	return nil
+}
+
 // =========================================================================
 // INDEX SUPPORT
 // =========================================================================
@@ -528,6 +742,11 @@ type indexesImpl interface {
 	SelectedTag() Tag
 	SelectTag(tag Tag) error
 	Tags() []Tag
+
+	// Maintenance
+	CreateTag(name, expr, forExpr string, unique, descending bool) error
+	DropTag(name string) error
+	Reindex() error
 }
 
 // Load loads all available indexes from the database files.
@@ -642,6 +861,54 @@ func (idx *Indexes) Tags() []Tag {
 	return idx.impl.Tags()
 }
 
+// CreateTag adds a tag to the production index (the .cdx file named after
+// the table), creating the index when the table has none. forExpr is an
+// optional FOR expression limiting the records in the tag; a unique tag
+// holds only the first record of each key. The tag is built from the
+// current contents of the table. Tags cannot be created inside a
+// transaction.
+func (idx *Indexes) CreateTag(name, expr, forExpr string, unique, descending bool) error {
+	if idx.impl == nil {
+		return fmt.Errorf("indexes not initialized")
+	}
+	// Auto-load on first access
+	if !idx.impl.Loaded() {
+		if err := idx.impl.Load(); err != nil {
+			return err
+		}
+	}
+	return idx.impl.CreateTag(name, expr, forExpr, unique, descending)
+}
+
+// DropTag removes a tag from its index. Removing the last tag deletes the
+// index file. Tags cannot be dropped inside a transaction.
+func (idx *Indexes) DropTag(name string) error {
+	if idx.impl == nil {
+		return fmt.Errorf("indexes not initialized")
+	}
+	// Auto-load on first access
+	if !idx.impl.Loaded() {
+		if err := idx.impl.Load(); err != nil {
+			return err
+		}
+	}
+	return idx.impl.DropTag(name)
+}
+
+// Reindex rebuilds every open index from the table.
+func (idx *Indexes) Reindex() error {
+	if idx.impl == nil {
+		return fmt.Errorf("indexes not initialized")
+	}
+	// Auto-load on first access
+	if !idx.impl.Loaded() {
+		if err := idx.impl.Load(); err != nil {
+			return err
+		}
+	}
+	return idx.impl.Reindex()
+}
+
 // MustLoad loads all available indexes from the database files.
 // Panics if the operation fails.
 func (idx *Indexes) MustLoad() {
@@ -659,6 +926,30 @@ func (idx *Indexes) MustSelectTag(tag Tag) {
 	}
 }
 
+// MustCreateTag adds a tag to the production index.
+// Panics if the operation fails.
+func (idx *Indexes) MustCreateTag(name, expr, forExpr string, unique, descending bool) {
+	if err := idx.CreateTag(name, expr, forExpr, unique, descending); err != nil {
+		panic(err)
+	}
+}
+
+// MustDropTag removes a tag from its index.
+// Panics if the operation fails.
+func (idx *Indexes) MustDropTag(name string) {
+	if err := idx.DropTag(name); err != nil {
+		panic(err)
+	}
+}
+
+// MustReindex rebuilds every open index from the table.
+// Panics if the operation fails.
+func (idx *Indexes) MustReindex() {
+	if err := idx.Reindex(); err != nil {
+		panic(err)
+	}
+}
+
 // Index represents a database index file (e.g., .CDX file)
 type Index interface {
 	// Properties
@@ -674,6 +965,9 @@ type Index interface {
 	// State
 	IsOpen() bool
 	IsProduction() bool
+
+	// Maintenance
+	Reindex() error
 }
 
 // Tag represents an index tag within an index file
@@ -695,6 +989,15 @@ type Tag interface {
 	SeekDouble(value float64) (SeekResult, error)
 	SeekInt(value int) (SeekResult, error)
 
+	// Iteration in tag order (selects the tag). Range visits the keys
+	// from lo through hi, either of which may be nil for an open end; a
+	// character hi takes in the keys it is a prefix of, as Seek does.
+	// Records that do not match the table's filter are passed over.
+	Range(lo, hi interface{}) iter.Seq2[Record, error]
+	RangeReverse(lo, hi interface{}) iter.Seq2[Record, error]
+	Prefix(prefix string) iter.Seq2[Record, error]
+	PrefixReverse(prefix string) iter.Seq2[Record, error]
+
 	// Navigation (when tag is selected)
 	First() error
 	Last() error
@@ -12,8 +12,11 @@ package foxi
 import "C"
 import (
 	"fmt"
+	"iter"
+	"os"
 	"path/filepath"
 	"runtime"
+	"slices"
 	"strings"
 	"time"
 	"unsafe"
@@ -26,32 +29,161 @@ type cgoImpl struct {
 	fields   *Fields
 	indexes  *Indexes
 	filename string
+	tx       *cgoTx       // Transaction state of the CODE4
+	session  *sessionCode // Session whose CODE4 the table shares, nil for a CODE4 of its own
+	readOnly bool         // Cursor made by Foxi.Cursor
+
+	nullFlags *C.FIELD4 // The _NullFlags system field, nil when the table has none
+
+	// Lock retry settings, kept across Open and Close
+	lockAttempts int
+	lockDelay    time.Duration
+
+	// Locks taken through the table, released by Unlock and Close
+	locks heldLocks
+
+	// Code page of the strings, the override is kept across Open and Close
+	text textCodec
+
+	// Filter set with SetFilter, cleared by Close
+	filter recordFilter
+
+	// Relations set with SetRelation, cleared by Close
+	rel relations
+}
+
+// cgoTx is the transaction state of a CODE4
+type cgoTx struct {
+	logOpen bool // Transaction log opened for the CODE4
+	active  bool // Transaction in progress
+}
+
+// heldLocks are the locks a table has taken. CodeBase keeps no list of
+// them that can be read, so the table keeps its own for Session.Locks.
+type heldLocks struct {
+	file    bool
+	append  bool  // Without the file lock, which covers it
+	records []int // Without the file lock, in record order
+}
+
+// sessionCode is the CODE4 shared by the tables of a Session, allocated
+// when the first of them is opened
+type sessionCode struct {
+	codeBase *C.CODE4
+	tx       cgoTx
+
+	// Lock retry settings of the CODE4
+	lockAttempts int
+	lockDelay    time.Duration
 }
 
 // NewFoxi creates a new Foxi instance with CGO backend
 func NewFoxi() *Foxi {
-	impl := &cgoImpl{}
+	impl := &cgoImpl{
+		tx:           &cgoTx{},
+		lockAttempts: -1, // WAIT4EVER
+		lockDelay:    time.Second,
+	}
 	return &Foxi{impl: impl}
 }
 
+// newCode4 allocates and initializes a CODE4 structure
+func newCode4() (*C.CODE4, error) {
+	codeBase := (*C.CODE4)(C.malloc(C.sizeof_CODE4))
+	if codeBase == nil {
+		return nil, fmt.Errorf("failed to allocate CODE4 structure")
+	}
+
+	// Initialize the codebase using code4initLow (code4init macro expansion)
+	result := C.code4initLow(codeBase, nil, 6401, C.long(C.sizeof_CODE4))
+	if result != 0 {
+		C.free(unsafe.Pointer(codeBase))
+		return nil, fmt.Errorf("failed to initialize codebase: %d", int(result))
+	}
+	return codeBase, nil
+}
+
+// table returns an implementation that opens its table in the session's
+// CODE4
+func (s *sessionCode) table() foxiImpl {
+	return &cgoImpl{
+		tx:           &s.tx,
+		session:      s,
+		lockAttempts: s.lockAttempts,
+		lockDelay:    s.lockDelay,
+	}
+}
+
+// setLockRetry sets the lock retries of the CODE4 the tables share
+func (s *sessionCode) setLockRetry(attempts int, delay time.Duration) {
+	s.lockAttempts, s.lockDelay = attempts, delay
+	if s.codeBase != nil {
+		code4lockRetry(s.codeBase, attempts, delay)
+	}
+}
+
+// inTransaction reports whether a transaction is open in the session
+func (s *sessionCode) inTransaction() bool {
+	return s.tx.active
+}
+
+// close frees the CODE4 once the session's tables are closed
+func (s *sessionCode) close() {
+	if s.codeBase != nil {
+		C.code4initUndo(s.codeBase)
+		C.free(unsafe.Pointer(s.codeBase))
+		s.codeBase = nil
+	}
+	s.tx = cgoTx{}
+}
+
+// locks returns the locks the session's tables hold, from the lists kept
+// by LockRecord, LockAppend and LockFile
+func (s *sessionCode) locks(tables []*Foxi) []Lock {
+	var locks []Lock
+	for _, f := range tables {
+		c, ok := f.impl.(*cgoImpl)
+		if !ok || c.data == nil {
+			continue
+		}
+		if c.locks.file {
+			locks = append(locks, Lock{Table: f.Alias(), Kind: FileLock})
+		}
+		if c.locks.append {
+			locks = append(locks, Lock{Table: f.Alias(), Kind: AppendLock})
+		}
+		for _, recNo := range c.locks.records {
+			locks = append(locks, Lock{Table: f.Alias(), Kind: RecordLock, Record: recNo})
+		}
+	}
+	return locks
+}
+
 // Open establishes a connection to the specified DBF file using mkfdbf C library
 func (c *cgoImpl) Open(filename string) error {
 	if c.data != nil {
 		return fmt.Errorf("database already open")
 	}
-
-	// Initialize CODE4 structure
-	c.codeBase = (*C.CODE4)(C.malloc(C.sizeof_CODE4))
-	if c.codeBase == nil {
-		return fmt.Errorf("failed to allocate CODE4 structure")
+	if c.readOnly {
+		return ErrReadOnly
 	}
 
-	// Initialize the codebase using code4initLow (code4init macro expansion)
-	result := C.code4initLow(c.codeBase, nil, 6401, C.long(C.sizeof_CODE4))
-	if result != 0 {
-		C.free(unsafe.Pointer(c.codeBase))
-		c.codeBase = nil
-		return fmt.Errorf("failed to initialize codebase: %d", int(result))
+	// Initialize CODE4 structure, or share the session's, which has the
+	// session's lock retries
+	if c.session != nil && c.session.codeBase != nil {
+		c.codeBase = c.session.codeBase
+	} else {
+		codeBase, err := newCode4()
+		if err != nil {
+			return err
+		}
+		c.codeBase = codeBase
+		if c.session != nil {
+			c.session.codeBase = codeBase
+			code4lockRetry(codeBase, c.session.lockAttempts, c.session.lockDelay)
+		} else {
+			c.SetLockRetry(c.lockAttempts, c.lockDelay)
+		}
 	}
 
 	// Convert Go string to C string
@@ -62,13 +194,13 @@ func (c *cgoImpl) Open(filename string) error {
 	c.data = C.d4open(c.codeBase, cFilename)
 	if c.data == nil {
 		// Clean up on failure
-		C.code4initUndo(c.codeBase)
-		C.free(unsafe.Pointer(c.codeBase))
-		c.codeBase = nil
+		c.releaseCode()
 		return fmt.Errorf("failed to open database file: %s", filename)
 	}
 
 	c.filename = filename
+	header := c.Header()
+	c.text.open(header.Codepage())
 
 	// Set finalizer to ensure cleanup
 	runtime.SetFinalizer(c, (*cgoImpl).finalize)
@@ -88,6 +220,9 @@ func (c *cgoImpl) Close() error {
 	if c.data == nil {
 		return nil
 	}
+	if c.session != nil && c.tx.active {
+		return fmt.Errorf("cannot close a session table inside a transaction")
+	}
 
 	// Remove finalizer since we've cleaned up manually
 	runtime.SetFinalizer(c, nil)
@@ -104,6 +239,12 @@ func (c *cgoImpl) finalize() {
 }
 
 func (c *cgoImpl) reset() error {
+	// An unfinished transaction is undone before the table is closed
+	if c.tx.active && c.codeBase != nil {
+		C.code4tranRollback(c.codeBase)
+		c.tx.active = false
+	}
+
 	// Close the data file
 	if c.data != nil {
 		result := C.d4close(c.data)
@@ -114,24 +255,56 @@ func (c *cgoImpl) reset() error {
 	}
 
 	// Cleanup the codebase
-	if c.codeBase != nil {
-		C.code4initUndo(c.codeBase)
-		C.free(unsafe.Pointer(c.codeBase))
-		c.codeBase = nil
-	}
+	c.releaseCode()
 
 	// Clear all state
 	c.filename = ""
 	c.fields = nil
+	c.indexes = nil
+	c.nullFlags = nil
+	c.locks = heldLocks{}
+	c.text.close()
+	c.filter.clear()
+	c.rel.clear()
 
 	return nil
 }
 
+// releaseCode frees the table's own CODE4, a session's is kept for its
+// other tables
+func (c *cgoImpl) releaseCode() {
+	if c.codeBase != nil && c.session == nil {
+		C.code4initUndo(c.codeBase)
+		C.free(unsafe.Pointer(c.codeBase))
+		c.tx.logOpen = false
+	}
+	c.codeBase = nil
+}
+
+// codeBaseError converts a pending CODE4 error code into a Go error and
+// clears it so later calls start clean
+func (c *cgoImpl) codeBaseError(action string) error {
+	if c.codeBase == nil {
+		return fmt.Errorf("database not open")
+	}
+	code := int(c.codeBase.errorCode)
+	if code >= 0 {
+		return nil
+	}
+	C.error4set(c.codeBase, 0)
+	return fmt.Errorf("failed to %s: %d", action, code)
+}
+
 // Active reports whether the database connection is active
 func (c *cgoImpl) Active() bool {
 	return c.data != nil
 }
 
+// Filename returns the path of the open table, empty when none is open
+func (c *cgoImpl) Filename() string {
+	return c.filename
+}
+
 // Header returns database header information
 func (c *cgoImpl) Header() Header {
 	if c.data == nil {
@@ -315,8 +488,8 @@ func (c *cgoImpl) Deleted() bool {
 }
 
 func (c *cgoImpl) Delete() error {
-	if c.data == nil {
-		return fmt.Errorf("database not open")
+	if err := c.writable(); err != nil {
+		return err
 	}
 
 	C.d4delete(c.data)
@@ -324,19 +497,350 @@ func (c *cgoImpl) Delete() error {
 }
 
 func (c *cgoImpl) Recall() error {
+	if err := c.writable(); err != nil {
+		return err
+	}
+
+	C.d4recall(c.data)
+	return nil
+}
+
+// Record writing methods
+func (c *cgoImpl) Append() error {
+	if err := c.writable(); err != nil {
+		return err
+	}
+
+	result := C.d4appendBlank(c.data)
+	if result == C.r4locked {
+		return fmt.Errorf("append lock: %w", ErrLocked)
+	}
+	if result != 0 {
+		return fmt.Errorf("failed to append record: %d", int(result))
+	}
+	return nil
+}
+
+func (c *cgoImpl) Write() error {
+	if err := c.writable(); err != nil {
+		return err
+	}
+
+	recNo := C.d4recNo(c.data)
+	if recNo < 1 || C.d4eof(c.data) != 0 {
+		return fmt.Errorf("no current record")
+	}
+
+	// d4write is a macro for d4writeLow(data, recNo, 0)
+	result := C.d4writeLow(c.data, recNo, 0)
+	if result != 0 {
+		return fmt.Errorf("failed to write record %d: %d", int(recNo), int(result))
+	}
+	return nil
+}
+
+// Transaction methods
+
+// Begin starts a CodeBase transaction. CodeBase records before-images in a
+// transaction log, which is kept beside the table.
+func (c *cgoImpl) Begin() error {
+	if err := c.writable(); err != nil {
+		return err
+	}
+	if c.tx.active {
+		return fmt.Errorf("transaction already in progress")
+	}
+
+	if !c.tx.logOpen {
+		logName := strings.TrimSuffix(c.filename, filepath.Ext(c.filename)) + ".log"
+		cLogName := C.CString(logName)
+		defer C.free(unsafe.Pointer(cLogName))
+		cUser := C.CString("foxi")
+		defer C.free(unsafe.Pointer(cUser))
+
+		if C.code4logOpen(c.codeBase, cLogName, cUser) != 0 {
+			C.error4set(c.codeBase, 0)
+			if C.code4logCreate(c.codeBase, cLogName, cUser) != 0 {
+				return c.codeBaseError("open transaction log")
+			}
+		}
+		c.tx.logOpen = true
+	}
+
+	if C.code4tranStart(c.codeBase) != 0 {
+		return c.codeBaseError("start transaction")
+	}
+	c.tx.active = true
+	return nil
+}
+
+func (c *cgoImpl) Commit() error {
 	if c.data == nil {
 		return fmt.Errorf("database not open")
 	}
+	if !c.tx.active {
+		return fmt.Errorf("no transaction in progress")
+	}
 
-	C.d4recall(c.data)
+	// A failed commit leaves the transaction open to be rolled back
+	if recNo := C.d4recNo(c.data); recNo >= 1 && C.d4eof(c.data) == 0 {
+		if C.d4writeLow(c.data, recNo, 0) != 0 {
+			return c.codeBaseError("write record")
+		}
+	}
+	if C.code4tranCommit(c.codeBase) != 0 {
+		return c.codeBaseError("commit transaction")
+	}
+	c.tx.active = false
+	return nil
+}
+
+func (c *cgoImpl) Rollback() error {
+	if c.data == nil {
+		return fmt.Errorf("database not open")
+	}
+	if !c.tx.active {
+		return fmt.Errorf("no transaction in progress")
+	}
+	c.tx.active = false
+
+	if C.code4tranRollback(c.codeBase) != 0 {
+		return c.codeBaseError("roll back transaction")
+	}
+	return nil
+}
+
+// Table maintenance methods
+
+// Pack removes deleted records with d4pack, which also reindexes, then
+// compresses the memo file. CodeBase reports no progress, so progress is
+// only told about the start and end of each pass.
+func (c *cgoImpl) Pack(progress ProgressFunc) error {
+	if err := c.writable(); err != nil {
+		return err
+	}
+	if c.tx.active {
+		return fmt.Errorf("cannot pack inside a transaction")
+	}
+
+	total := int(C.d4recCountDo(c.data))
+	if progress != nil {
+		progress(0, total)
+	}
+	if err := c.maintenanceResult(C.d4pack(c.data), "pack"); err != nil {
+		return err
+	}
+	if progress != nil {
+		progress(total, total)
+	}
+
+	if c.hasMemo() {
+		return c.PackMemo(progress)
+	}
+	return nil
+}
+
+func (c *cgoImpl) Zap() error {
+	if err := c.writable(); err != nil {
+		return err
+	}
+	if c.tx.active {
+		return fmt.Errorf("cannot zap inside a transaction")
+	}
+	return c.maintenanceResult(C.d4zap(c.data, 1, 1000000000), "zap")
+}
+
+func (c *cgoImpl) PackMemo(progress ProgressFunc) error {
+	if err := c.writable(); err != nil {
+		return err
+	}
+	if c.tx.active {
+		return fmt.Errorf("cannot pack memo file inside a transaction")
+	}
+	if !c.hasMemo() {
+		return fmt.Errorf("table has no memo file")
+	}
+
+	total := int(C.d4recCountDo(c.data))
+	if progress != nil {
+		progress(0, total)
+	}
+	if err := c.maintenanceResult(C.d4memoCompress(c.data), "pack memo file"); err != nil {
+		return err
+	}
+	if progress != nil {
+		progress(total, total)
+	}
+	return nil
+}
+
+// hasMemo reports whether the table has memo fields
+func (c *cgoImpl) hasMemo() bool {
+	for i := 0; i < c.FieldCount(); i++ {
+		switch c.Field(i).Type() {
+		case FTMemo, FTGeneral, FTPicture:
+			return true
+		}
+	}
+	return false
+}
+
+// clearProductionFlag clears the production index flag of the table; it
+// reaches the header when the table is next flushed
+func (c *cgoImpl) clearProductionFlag() {
+	c.data.dataFile.hasMdxMemo &^= 0x01
+	c.data.dataFile.fileChanged = 1
+}
+
+// maintenanceResult converts the result of a CodeBase maintenance call to an error
+func (c *cgoImpl) maintenanceResult(result C.int, action string) error {
+	switch {
+	case result == 0:
+		return nil
+	case result == C.r4locked:
+		return fmt.Errorf("failed to %s: %w", action, ErrLocked)
+	default:
+		if err := c.codeBaseError(action); err != nil {
+			return err
+		}
+		return fmt.Errorf("failed to %s: %d", action, int(result))
+	}
+}
+
+// Locking methods
+func (c *cgoImpl) LockRecord(recordNumber int) error {
+	if err := c.writable(); err != nil {
+		return err
+	}
+	if recordNumber < 1 || recordNumber > int(C.d4recCountDo(c.data)) {
+		return fmt.Errorf("record %d out of range", recordNumber)
+	}
+	if err := c.lockResult(C.d4lock(c.data, C.long(recordNumber)), fmt.Sprintf("record %d", recordNumber)); err != nil {
+		return err
+	}
+	// The file lock covers the record
+	if i, found := slices.BinarySearch(c.locks.records, recordNumber); !found && !c.locks.file {
+		c.locks.records = slices.Insert(c.locks.records, i, recordNumber)
+	}
+	return nil
+}
+
+func (c *cgoImpl) LockAppend() error {
+	if err := c.writable(); err != nil {
+		return err
+	}
+	if err := c.lockResult(C.d4lockAppend(c.data), "append"); err != nil {
+		return err
+	}
+	// The file lock covers the append lock
+	c.locks.append = !c.locks.file
 	return nil
 }
 
+func (c *cgoImpl) LockFile() error {
+	if err := c.writable(); err != nil {
+		return err
+	}
+	if err := c.lockResult(C.d4lockFile(c.data), "file"); err != nil {
+		return err
+	}
+	// The file lock takes in the append and record locks
+	c.locks = heldLocks{file: true}
+	return nil
+}
+
+func (c *cgoImpl) Unlock() error {
+	if err := c.writable(); err != nil {
+		return err
+	}
+	if C.d4unlock(c.data) < 0 {
+		return c.codeBaseError("unlock")
+	}
+	c.locks = heldLocks{}
+	return nil
+}
+
+func (c *cgoImpl) SetLockRetry(attempts int, delay time.Duration) {
+	c.lockAttempts, c.lockDelay = attempts, delay
+	if c.codeBase != nil {
+		code4lockRetry(c.codeBase, attempts, delay)
+	}
+}
+
+// code4lockRetry sets the lock retries of a CODE4
+func code4lockRetry(codeBase *C.CODE4, attempts int, delay time.Duration) {
+	// CodeBase counts the delay in hundredths of a second
+	codeBase.lockAttempts = C.int(attempts)
+	codeBase.lockDelay = C.uint((delay + 5*time.Millisecond) / (10 * time.Millisecond))
+}
+
+// writable returns an error unless a table is open and may be changed
+func (c *cgoImpl) writable() error {
+	if c.data == nil {
+		return fmt.Errorf("database not open")
+	}
+	if c.readOnly {
+		return ErrReadOnly
+	}
+	return nil
+}
+
+// cursor returns a read-only implementation over the open table. A CODE4
+// and its DATA4 must not be used by two threads at once, so the table is
+// opened again, read-only, with a CODE4 of the cursor's own.
+func (c *cgoImpl) cursor() (foxiImpl, error) {
+	if c.data == nil {
+		return nil, fmt.Errorf("database not open")
+	}
+	cur := &cgoImpl{
+		tx:           &cgoTx{},
+		lockAttempts: c.lockAttempts,
+		lockDelay:    c.lockDelay,
+		text:         c.text,
+	}
+	if err := cur.Open(c.filename); err != nil {
+		return nil, err
+	}
+	cur.readOnly = true
+	return cur, nil
+}
+
+// textCodec returns the code page translation of the table
+func (c *cgoImpl) textCodec() *textCodec {
+	return &c.text
+}
+
+// lockResult converts the result of a CodeBase lock call to an error
+func (c *cgoImpl) lockResult(result C.int, what string) error {
+	switch {
+	case result == 0:
+		return nil
+	case result == C.r4locked:
+		return fmt.Errorf("%s lock: %w", what, ErrLocked)
+	default:
+		if err := c.codeBaseError("take " + what + " lock"); err != nil {
+			return err
+		}
+		return fmt.Errorf("failed to take %s lock: %d", what, int(result))
+	}
+}
+
+// recordFilter returns the filter of the table
+func (c *cgoImpl) recordFilter() *recordFilter {
+	return &c.filter
+}
+
+// relations returns the relations of the table
+func (c *cgoImpl) relations() *relations {
+	return &c.rel
+}
+
 // Indexes returns the index collection
 func (c *cgoImpl) Indexes() *Indexes {
 	if c.indexes == nil {
 		c.indexes = &Indexes{
 			impl: &cgoIndexesImpl{
+				owner:  c,
 				data:   c.data,
 				loaded: false,
 			},
@@ -362,8 +866,7 @@ func (c *cgoImpl) buildFields() error {
 		return fmt.Errorf("no fields found in database")
 	}
 
-	fields := make([]Field, fieldCount)
-	indices := make(map[string]int)
+	fields := make([]*cgoField, 0, fieldCount+1)
 
 	// Read each field definition
 	for i := 0; i < fieldCount; i++ {
@@ -374,74 +877,152 @@ func (c *cgoImpl) buildFields() error {
 		}
 
 		// Create foxi field wrapper
-		field := &cgoField{
-			impl:   c,
-			cField: cField,
+		fields = append(fields, &cgoField{
+			impl:    c,
+			cField:  cField,
+			lenBit:  -1,
+			nullBit: -1,
+		})
+		if rune(cField._type) == '0' {
+			c.nullFlags = cField
 		}
+	}
 
-		fields[i] = field
-
-		// Create case-insensitive name mapping
-		name := strings.ToLower(field.Name())
-		indices[name] = i
+	// CodeBase may leave the system field out of the numbered fields
+	if c.nullFlags == nil {
+		if c.nullFlags = c.findNullFlags(); c.nullFlags != nil {
+			fields = append(fields, &cgoField{impl: c, cField: c.nullFlags, lenBit: -1, nullBit: -1})
+		}
 	}
 
-	c.fields = &Fields{
-		fields:  fields,
-		indices: indices,
+	// Number the _NullFlags bits the way Visual FoxPro does: in field
+	// order, a varchar/varbinary field takes a length bit and a nullable
+	// field a null bit, the length bit first when both apply. CodeBase
+	// is given the same null bits.
+	bit := 0
+	all := make([]Field, len(fields))
+	for i, field := range fields {
+		all[i] = field
+		if field.cField == c.nullFlags {
+			continue
+		}
+		switch rune(field.cField._type) {
+		case 'V', 'Q':
+			field.lenBit = bit
+			bit++
+		}
+		if field.cField.null != 0 {
+			field.nullBit = bit
+			field.cField.nullBit = C.ushort(bit)
+			bit++
+		}
 	}
+	c.fields = newFields(all)
 
 	return nil
 }
 
-// cgoField implements the Field interface using C library
-type cgoField struct {
-	impl   *cgoImpl
-	cField *C.FIELD4
+// findNullFlags looks the _NullFlags system field up by name, nil when the
+// table has none
+func (c *cgoImpl) findNullFlags() *C.FIELD4 {
+	name := C.CString("_NULLFLAGS")
+	defer C.free(unsafe.Pointer(name))
+	errFieldName := c.codeBase.errFieldName
+	c.codeBase.errFieldName = 0
+	nullFlags := C.d4field(c.data, name)
+	c.codeBase.errFieldName = errFieldName
+	C.error4set(c.codeBase, 0)
+	return nullFlags
 }
 
-// Value returns the field's native value
-func (f *cgoField) Value() (interface{}, error) {
-	if f.impl.data == nil {
-		return nil, fmt.Errorf("database not open")
+// flagBit reports whether a bit of _NullFlags is set in the current record
+func (c *cgoImpl) flagBit(bit int) bool {
+	if c.nullFlags == nil {
+		return false
 	}
+	flagsPtr := C.f4ptr(c.nullFlags)
+	if flagsPtr == nil {
+		return false
+	}
+	flags := C.GoBytes(unsafe.Pointer(flagsPtr), C.int(C.f4len(c.nullFlags)))
+	return bit/8 < len(flags) && flags[bit/8]&(1<<(bit%8)) != 0
+}
 
-	// Get field value from current record using C library
+// cgoField implements the Field interface using C library
+type cgoField struct {
+	impl    *cgoImpl
+	cField  *C.FIELD4
+	lenBit  int // Bit in _NullFlags marking a short varchar value, -1 for none
+	nullBit int // Bit in _NullFlags marking a null value, -1 for none
+}
+
+// stored returns the bytes of the field in the current record
+func (f *cgoField) stored() []byte {
 	fieldPtr := C.f4ptr(f.cField)
 	if fieldPtr == nil {
-		return nil, fmt.Errorf("failed to get field pointer")
+		return nil
 	}
+	raw := C.GoBytes(unsafe.Pointer(fieldPtr), C.int(C.f4len(f.cField)))
 
-	// Convert based on field type
-	fieldType := rune(f.cField._type)
-	switch fieldType {
-	case 'C':
-		return C.GoString((*C.char)(fieldPtr)), nil
-	case 'N', 'F':
-		return C.f4double(f.cField), nil
-	case 'L':
-		return C.f4true(f.cField) != 0, nil
-	case 'D':
-		return C.GoString((*C.char)(fieldPtr)), nil
-	case 'I':
-		return int(C.f4long(f.cField)), nil
-	default:
-		return C.GoString((*C.char)(fieldPtr)), nil
+	// A varchar value shorter than the field has its length in the last byte
+	if f.lenBit >= 0 && len(raw) > 0 && f.impl.flagBit(f.lenBit) {
+		raw = raw[:min(int(raw[len(raw)-1]), len(raw)-1)]
 	}
+	return raw
 }
 
-// AsString returns field value as string
-func (f *cgoField) AsString() (string, error) {
+// memo returns the contents of a memo field
+func (f *cgoField) memo() []byte {
+	memoPtr := C.f4memoPtr(f.cField)
+	if memoPtr == nil {
+		return nil
+	}
+	return C.GoBytes(unsafe.Pointer(memoPtr), C.int(C.f4memoLen(f.cField)))
+}
+
+// textCodec returns the code page translation of the table
+func (f *cgoField) textCodec() *textCodec {
+	return &f.impl.text
+}
+
+// setVarLength records the length of a varchar or varbinary value in the
+// field and in _NullFlags, as Visual FoxPro does
+func (f *cgoField) setVarLength(n int) {
+	if f.lenBit < 0 || f.impl.nullFlags == nil {
+		return
+	}
+	size := int(C.f4len(f.cField))
+	field := unsafe.Slice((*byte)(unsafe.Pointer(C.f4ptr(f.cField))), size)
+	flags := unsafe.Slice((*byte)(unsafe.Pointer(C.f4ptr(f.impl.nullFlags))), int(C.f4len(f.impl.nullFlags)))
+	if f.lenBit/8 >= len(flags) || size == 0 {
+		return
+	}
+	mask := byte(1) << (f.lenBit % 8)
+	if n < size {
+		field[size-1] = byte(n)
+		flags[f.lenBit/8] |= mask
+	} else {
+		flags[f.lenBit/8] &^= mask
+	}
+}
+
+// Value returns the field's native value (see Field.Value for the types)
+func (f *cgoField) Value() (interface{}, error) {
 	if f.impl.data == nil {
-		return "", fmt.Errorf("database not open")
+		return nil, fmt.Errorf("database not open")
 	}
 
-	fieldPtr := C.f4ptr(f.cField)
-	if fieldPtr == nil {
-		return "", fmt.Errorf("failed to get field pointer")
+	return storedValue(f)
+}
+
+// AsString returns field value as string, decoded from the table's code page
+// for character and memo fields
+func (f *cgoField) AsString() (string, error) {
+	if f.impl.data == nil {
+		return "", fmt.Errorf("database not open")
 	}
 
-	return C.GoString((*C.char)(fieldPtr)), nil
+	return storedString(f), nil
 }
 
 // AsInt returns field value as integer
@@ -477,18 +1058,16 @@ func (f *cgoField) AsTime() (time.Time, error) {
 		return time.Time{}, fmt.Errorf("database not open")
 	}
 
-	// Convert from C date format
-	fieldPtr := C.f4ptr(f.cField)
-	if fieldPtr == nil {
-		return time.Time{}, fmt.Errorf("failed to get field pointer")
-	}
+	return storedTime(f)
+}
 
-	dateStr := C.GoString((*C.char)(fieldPtr))
-	if len(dateStr) != 8 {
-		return time.Time{}, fmt.Errorf("invalid date format")
+// AsDecimal returns the exact value of a numeric field
+func (f *cgoField) AsDecimal() (Decimal, error) {
+	if f.impl.data == nil {
+		return Decimal{}, fmt.Errorf("database not open")
 	}
 
-	return time.Parse("20060102", dateStr)
+	return storedDecimal(f)
 }
 
 // IsNull checks if field value is null
@@ -497,8 +1076,161 @@ func (f *cgoField) IsNull() (bool, error) {
 		return false, fmt.Errorf("database not open")
 	}
 
-	// Check for null using C library
-	return C.f4null(f.cField) != 0, nil
+	// Only the null bit in _NullFlags marks a null value; blank values
+	// are not null
+	return f.nullBit >= 0 && f.impl.flagBit(f.nullBit), nil
+}
+
+// SetString assigns a string value, converting it to the field's storage format
+func (f *cgoField) SetString(value string) error {
+	if err := f.impl.writable(); err != nil {
+		return err
+	}
+
+	// Numbers are checked against the field as SetDecimal does
+	if f.Type().isNumeric() {
+		d, ok, err := textDecimal(f, value)
+		if err != nil {
+			return err
+		}
+		if ok {
+			return f.SetDecimal(d)
+		}
+	}
+
+	value, err := f.impl.text.encodeField(f, value)
+	if err != nil {
+		return err
+	}
+
+	cValue := C.CString(value)
+	defer C.free(unsafe.Pointer(cValue))
+
+	switch rune(f.cField._type) {
+	case 'M', 'G', 'W':
+		if C.f4memoAssignN(f.cField, cValue, C.uint(len(value))) < 0 {
+			return fmt.Errorf("failed to assign memo field %s", f.Name())
+		}
+	case 'T':
+		if err := f.SetTimeString(value); err != nil {
+			return err
+		}
+	case 'V', 'Q':
+		C.f4assignN(f.cField, cValue, C.uint(len(value)))
+		f.setVarLength(min(len(value), int(f.Size())))
+	default:
+		C.f4assign(f.cField, cValue)
+	}
+	return f.impl.codeBaseError("assign field " + f.Name())
+}
+
+// SetTimeString parses a datetime string and assigns it to a datetime field
+func (f *cgoField) SetTimeString(value string) error {
+	for _, layout := range []string{"2006-01-02 15:04:05", "2006-01-02T15:04:05", "20060102", "2006-01-02"} {
+		if parsed, err := time.Parse(layout, value); err == nil {
+			return f.SetTime(parsed)
+		}
+	}
+	return fmt.Errorf("invalid value %q for field %s", value, f.Name())
+}
+
+// SetInt assigns an integer value to a numeric field
+func (f *cgoField) SetInt(value int) error {
+	return f.SetDecimal(NewDecimal(int64(value), 0))
+}
+
+// SetFloat assigns a floating point value to a numeric field, rounded to
+// the field's decimals
+func (f *cgoField) SetFloat(value float64) error {
+	if err := f.impl.writable(); err != nil {
+		return err
+	}
+	if err := checkSettable(f, "number"); err != nil {
+		return err
+	}
+
+	d, err := floatDecimal(f, value)
+	if err != nil {
+		return err
+	}
+	return f.SetDecimal(d)
+}
+
+// SetBool assigns a boolean value to a logical field
+func (f *cgoField) SetBool(value bool) error {
+	if err := f.impl.writable(); err != nil {
+		return err
+	}
+	if err := checkSettable(f, "bool"); err != nil {
+		return err
+	}
+
+	logical := 'F'
+	if value {
+		logical = 'T'
+	}
+	C.f4assignChar(f.cField, C.int(logical))
+	return f.impl.codeBaseError("assign field " + f.Name())
+}
+
+// SetTime assigns a time value to a date or datetime field
+func (f *cgoField) SetTime(value time.Time) error {
+	if err := f.impl.writable(); err != nil {
+		return err
+	}
+	if err := checkSettable(f, "time"); err != nil {
+		return err
+	}
+
+	if rune(f.cField._type) == 'T' {
+		// CodeBase datetime string: CCYYMMDDhh:mm:ss:ttt
+		formatted := ""
+		if !value.IsZero() {
+			formatted = fmt.Sprintf("%s:%03d", value.Format("2006010215:04:05"), value.Nanosecond()/int(time.Millisecond))
+		}
+		cValue := C.CString(formatted)
+		defer C.free(unsafe.Pointer(cValue))
+		C.f4assignDateTime(f.cField, cValue)
+	} else {
+		formatted := "        "
+		if !value.IsZero() {
+			formatted = value.Format("20060102")
+		}
+		cValue := C.CString(formatted)
+		defer C.free(unsafe.Pointer(cValue))
+		C.f4assign(f.cField, cValue)
+	}
+	return f.impl.codeBaseError("assign field " + f.Name())
+}
+
+// SetDecimal assigns the exact value of a decimal to a numeric field
+func (f *cgoField) SetDecimal(value Decimal) error {
+	if err := f.impl.writable(); err != nil {
+		return err
+	}
+	stored, err := decimalBytes(f, value)
+	if err != nil {
+		return err
+	}
+
+	cValue := C.CBytes(stored)
+	defer C.free(cValue)
+
+	C.f4assignN(f.cField, (*C.char)(cValue), C.uint(len(stored)))
+	return f.impl.codeBaseError("assign field " + f.Name())
+}
+
+// SetNull sets a nullable field to null
+func (f *cgoField) SetNull() error {
+	if err := f.impl.writable(); err != nil {
+		return err
+	}
+	if err := checkSettable(f, "null"); err != nil {
+		return err
+	}
+
+	C.f4assignNull(f.cField)
+	return f.impl.codeBaseError("set field " + f.Name() + " to null")
 }
 
 // Name returns field name
@@ -522,10 +1254,9 @@ func (f *cgoField) Decimals() uint8 {
 	return uint8(f.cField.dec)
 }
 
-// IsSystem returns if field is system field
+// IsSystem returns if field is system field, such as _NullFlags
 func (f *cgoField) IsSystem() bool {
-	// Basic implementation - could be enhanced
-	return false
+	return rune(f.cField._type) == '0'
 }
 
 // IsNullable returns if field can be null
@@ -596,6 +1327,15 @@ func (f *cgoField) MustAsTime() time.Time {
 	return value
 }
 
+// MustAsDecimal returns the exact value of a numeric field, panicking on error
+func (f *cgoField) MustAsDecimal() Decimal {
+	value, err := f.AsDecimal()
+	if err != nil {
+		panic(err)
+	}
+	return value
+}
+
 // MustIsNull checks if field value is null, panicking on error
 func (f *cgoField) MustIsNull() bool {
 	value, err := f.IsNull()
@@ -605,6 +1345,55 @@ func (f *cgoField) MustIsNull() bool {
 	return value
 }
 
+// MustSetString assigns a string value, panicking on error
+func (f *cgoField) MustSetString(value string) {
+	if err := f.SetString(value); err != nil {
+		panic(err)
+	}
+}
+
+// MustSetInt assigns an integer value, panicking on error
+func (f *cgoField) MustSetInt(value int) {
+	if err := f.SetInt(value); err != nil {
+		panic(err)
+	}
+}
+
+// MustSetFloat assigns a floating point value, panicking on error
+func (f *cgoField) MustSetFloat(value float64) {
+	if err := f.SetFloat(value); err != nil {
+		panic(err)
+	}
+}
+
+// MustSetBool assigns a boolean value, panicking on error
+func (f *cgoField) MustSetBool(value bool) {
+	if err := f.SetBool(value); err != nil {
+		panic(err)
+	}
+}
+
+// MustSetTime assigns a time value, panicking on error
+func (f *cgoField) MustSetTime(value time.Time) {
+	if err := f.SetTime(value); err != nil {
+		panic(err)
+	}
+}
+
+// MustSetDecimal assigns a decimal value, panicking on error
+func (f *cgoField) MustSetDecimal(value Decimal) {
+	if err := f.SetDecimal(value); err != nil {
+		panic(err)
+	}
+}
+
+// MustSetNull sets the field to null, panicking on error
+func (f *cgoField) MustSetNull() {
+	if err := f.SetNull(); err != nil {
+		panic(err)
+	}
+}
+
 // convertFromCFieldType converts C field type to foxi FieldType
 func convertFromCFieldType(cType rune) FieldType {
 	switch cType {
@@ -651,6 +1440,7 @@ func convertFromCFieldType(cType rune) FieldType {
 
 // cgoIndexesImpl implements indexesImpl for the CGO backend
 type cgoIndexesImpl struct {
+	owner   *cgoImpl
 	data    *C.DATA4
 	indexes []Index
 	tags    []Tag
@@ -672,7 +1462,7 @@ func (idx *cgoIndexesImpl) Load() error {
 
 	// Try to open production index (same name as DBF with .CDX extension)
 	if idx.data.dataFile != nil {
-		dbfFileName := C.GoString(&idx.data.dataFile.file.name[0])
+		dbfFileName := C.GoString(idx.data.dataFile.file.name)
 		if dbfFileName != "" {
 			baseName := strings.TrimSuffix(dbfFileName, ".dbf")
 			cdxFileName := baseName + ".cdx"
@@ -681,10 +1471,14 @@ func (idx *cgoIndexesImpl) Load() error {
 			cCdxFileName := C.CString(cdxFileName)
 			defer C.free(unsafe.Pointer(cCdxFileName))
 
-			// Attempt to open the production index
-			index4 := C.i4open(idx.data, cCdxFileName)
+			// The production index may already be open
+			index4 := C.d4index(idx.data, cCdxFileName)
+			if index4 == nil {
+				index4 = C.i4open(idx.data, cCdxFileName)
+			}
 			if index4 != nil {
 				index := &cgoIndex{
+					owner:        idx.owner,
 					index4:       index4,
 					data:         idx.data,
 					isProduction: true,
@@ -799,8 +1593,112 @@ func (idx *cgoIndexesImpl) Tags() []Tag {
 	return idx.tags
 }
 
+// CreateTag adds a tag to the production index, creating the index when
+// the table has none
+func (idx *cgoIndexesImpl) CreateTag(name, expr, forExpr string, unique, descending bool) error {
+	if err := idx.owner.writable(); err != nil {
+		return err
+	}
+	if idx.owner.tx.active {
+		return fmt.Errorf("cannot create tag inside a transaction")
+	}
+	if idx.TagByName(name) != nil {
+		return fmt.Errorf("tag already exists: %s", name)
+	}
+	spec := TagSpec{Name: name, Expression: expr, Filter: forExpr}
+	if err := checkTag(tableFieldEnv(idx.owner), spec); err != nil {
+		return err
+	}
+
+	cName := C.CString(strings.ToUpper(strings.TrimSpace(name)))
+	defer C.free(unsafe.Pointer(cName))
+	cExpr := C.CString(expr)
+	defer C.free(unsafe.Pointer(cExpr))
+	var cFilter *C.char
+	if forExpr != "" {
+		cFilter = C.CString(forExpr)
+		defer C.free(unsafe.Pointer(cFilter))
+	}
+
+	// The tag list is terminated by an entry without a name
+	info := (*[2]C.TAG4INFO)(C.calloc(2, C.size_t(unsafe.Sizeof(C.TAG4INFO{}))))
+	defer C.free(unsafe.Pointer(info))
+	info[0].name = cName
+	info[0].expression = cExpr
+	info[0].filter = cFilter
+	if unique {
+		info[0].unique = C.r4uniqueContinue
+	}
+	if descending {
+		info[0].descending = C.r4descending
+	}
+
+	action := "create tag " + name
+	var err error
+	if len(idx.indexes) > 0 {
+		err = idx.owner.maintenanceResult(C.i4tagAdd(idx.indexes[0].(*cgoIndex).index4, &info[0]), action)
+	} else {
+		// A failed attempt to open the missing index leaves an error behind
+		C.error4set(idx.data.codeBase, 0)
+		if C.i4create(idx.data, nil, &info[0]) == nil {
+			err = idx.owner.maintenanceResult(-1, action)
+		}
+	}
+	idx.reload()
+	return err
+}
+
+// DropTag removes a tag from its index
+func (idx *cgoIndexesImpl) DropTag(name string) error {
+	if err := idx.owner.writable(); err != nil {
+		return err
+	}
+	if idx.owner.tx.active {
+		return fmt.Errorf("cannot drop tag inside a transaction")
+	}
+	tag, ok := idx.TagByName(name).(*cgoTag)
+	if !ok {
+		return fmt.Errorf("tag not found: %s", name)
+	}
+
+	action := "drop tag " + name
+	var err error
+	if tag.index.TagCount() > 1 {
+		err = idx.owner.maintenanceResult(C.i4tagRemove(tag.tag4), action)
+	} else {
+		// Removing the last tag removes the index file, as Visual FoxPro does
+		fileName := tag.index.FileName()
+		err = idx.owner.maintenanceResult(C.i4close(tag.index.index4), action)
+		if err == nil {
+			if rmErr := os.Remove(fileName); rmErr != nil {
+				err = fmt.Errorf("failed to %s: %w", action, rmErr)
+			}
+		}
+		if err == nil {
+			idx.owner.clearProductionFlag()
+		}
+	}
+	idx.reload()
+	return err
+}
+
+// Reindex rebuilds every open index
+func (idx *cgoIndexesImpl) Reindex() error {
+	if err := idx.owner.writable(); err != nil {
+		return err
+	}
+	return idx.owner.maintenanceResult(C.d4reindex(idx.data), "reindex")
+}
+
+// reload loads the indexes again after their tags changed
+func (idx *cgoIndexesImpl) reload() {
+	idx.loaded = false
+	idx.Load()
+}
+
 // cgoIndex implements Index for the CGO backend
 type cgoIndex struct {
+	owner        *cgoImpl
 	index4       *C.INDEX4
 	data         *C.DATA4
 	tags         []Tag
@@ -881,6 +1779,26 @@ func (idx *cgoIndex) IsProduction() bool {
 	return idx.isProduction
 }
 
+// Reindex rebuilds every tag of this index from the table
+func (idx *cgoIndex) Reindex() error {
+	if idx.index4 == nil || idx.data == nil {
+		return fmt.Errorf("index not open")
+	}
+	if idx.owner.readOnly {
+		return ErrReadOnly
+	}
+	result := C.i4reindex(idx.index4)
+	switch {
+	case result == 0:
+		return nil
+	case result == C.r4locked:
+		return fmt.Errorf("failed to reindex %s: %w", idx.Name(), ErrLocked)
+	default:
+		C.error4set(idx.data.codeBase, 0)
+		return fmt.Errorf("failed to reindex %s: %d", idx.Name(), int(result))
+	}
+}
+
 // loadTags loads all tags from this index
 func (idx *cgoIndex) loadTags() {
 	if idx.index4 == nil || idx.data == nil {
@@ -889,20 +1807,17 @@ func (idx *cgoIndex) loadTags() {
 
 	var tags []Tag
 
-	// Iterate through all tags in the index using C library
-	// Start with first tag in index
-	tag4 := C.i4tag(idx.index4, nil) // Get first tag
-	for tag4 != nil {
+	// Iterate through all tags of the table, keeping those of this index
+	for tag4 := C.d4tagNext(idx.data, nil); tag4 != nil; tag4 = C.d4tagNext(idx.data, tag4) {
+		if tag4.index != idx.index4 {
+			continue
+		}
 		tag := &cgoTag{
 			tag4:  tag4,
 			data:  idx.data,
 			index: idx,
 		}
 		tags = append(tags, tag)
-
-		// Get next tag (this is a simplified approach)
-		// In reality, we'd need to iterate through the tag list properly
-		break // For now, just get the first one
 	}
 
 	idx.tags = tags
@@ -1012,6 +1927,12 @@ func (tag *cgoTag) SeekString(value string) (SeekResult, error) {
 		return SeekEOF, fmt.Errorf("database not open")
 	}
 
+	// Keys hold strings in the table's code page
+	value, err := tag.index.owner.text.encode(value)
+	if err != nil {
+		return SeekEOF, err
+	}
+
 	// Select this tag first
 	C.d4tagSelect(tag.data, tag.tag4)
 
@@ -1147,6 +2068,26 @@ func (tag *cgoTag) PositionSet(percent float64) error {
 	return nil
 }
 
+// Range iterates over the records with keys from lo through hi
+func (tag *cgoTag) Range(lo, hi interface{}) iter.Seq2[Record, error] {
+	return rangeRecords(tag.index.owner, tag, lo, hi, false)
+}
+
+// RangeReverse iterates over the records with keys from hi back to lo
+func (tag *cgoTag) RangeReverse(lo, hi interface{}) iter.Seq2[Record, error] {
+	return rangeRecords(tag.index.owner, tag, lo, hi, true)
+}
+
+// Prefix iterates over the records whose keys start with prefix
+func (tag *cgoTag) Prefix(prefix string) iter.Seq2[Record, error] {
+	return prefixRecords(tag.index.owner, tag, prefix, false)
+}
+
+// PrefixReverse iterates backwards over the records whose keys start with prefix
+func (tag *cgoTag) PrefixReverse(prefix string) iter.Seq2[Record, error] {
+	return prefixRecords(tag.index.owner, tag, prefix, true)
+}
+
 // CurrentKey returns the current index key value
 func (tag *cgoTag) CurrentKey() string {
 	if tag.data == nil || tag.tag4 == nil {
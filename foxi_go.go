@@ -4,8 +4,11 @@
 package foxi
 
 import (
+	"cmp"
 	"fmt"
+	"iter"
 	"path/filepath"
+	"slices"
 	"strings"
 	"time"
 
@@ -15,10 +18,25 @@ import (
 // pureGoImpl implements foxiImpl using the gomkfdbf pure Go backend
 type pureGoImpl struct {
 	codeBase *pkg.Code4
+	shared   *pkg.Code4 // CODE4 of the Session the table belongs to, nil for a CODE4 of its own
 	data     *pkg.Data4
 	fields   *Fields
 	indexes  *Indexes
 	filename string
+	readOnly bool // Cursor made by Foxi.Cursor
+
+	// Lock retry settings, kept across Open and Close
+	lockAttempts int
+	lockDelay    time.Duration
+
+	// Code page of the strings, the override is kept across Open and Close
+	text textCodec
+
+	// Filter set with SetFilter, cleared by Close
+	filter recordFilter
+
+	// Relations set with SetRelation, cleared by Close
+	rel relations
 }
 
 // init function creates the implementation instance when package loads
@@ -28,22 +46,114 @@ func init() {
 
 // NewFoxi creates a new Foxi instance with pure Go backend
 func NewFoxi() *Foxi {
-	impl := &pureGoImpl{}
+	impl := &pureGoImpl{
+		lockAttempts: pkg.Wait4Ever,
+		lockDelay:    time.Second,
+	}
 	return &Foxi{impl: impl}
 }
 
+// sessionCode is the CODE4 shared by the tables of a Session
+type sessionCode struct {
+	codeBase *pkg.Code4
+
+	// Lock retry settings of the CODE4
+	lockAttempts int
+	lockDelay    time.Duration
+}
+
+// table returns an implementation that opens its table in the session's
+// CODE4
+func (s *sessionCode) table() foxiImpl {
+	if s.codeBase == nil {
+		s.codeBase = &pkg.Code4{AutoOpen: true}
+		code4lockRetry(s.codeBase, s.lockAttempts, s.lockDelay)
+	}
+	return &pureGoImpl{
+		shared:       s.codeBase,
+		lockAttempts: s.lockAttempts,
+		lockDelay:    s.lockDelay,
+	}
+}
+
+// setLockRetry sets the lock retries of the CODE4 the tables share
+func (s *sessionCode) setLockRetry(attempts int, delay time.Duration) {
+	s.lockAttempts, s.lockDelay = attempts, delay
+	if s.codeBase != nil {
+		code4lockRetry(s.codeBase, attempts, delay)
+	}
+}
+
+// inTransaction reports whether a transaction is open in the session
+func (s *sessionCode) inTransaction() bool {
+	return pkg.Code4TransActive(s.codeBase)
+}
+
+// close releases the CODE4 once the session's tables are closed
+func (s *sessionCode) close() {
+	s.codeBase = nil
+}
+
+// locks returns the locks the session's CODE4 holds on the data files of
+// its tables
+func (s *sessionCode) locks(tables []*Foxi) []Lock {
+	if s.codeBase == nil {
+		return nil
+	}
+	status := pkg.Code4Locks(s.codeBase).Status()
+	var locks []Lock
+	for _, f := range tables {
+		p, ok := f.impl.(*pureGoImpl)
+		if !ok || p.data == nil {
+			continue
+		}
+		first := len(locks)
+		for _, held := range status {
+			if held.File != &p.data.DataFile.File {
+				continue
+			}
+			lock := Lock{Table: f.Alias()}
+			switch held.LockType {
+			case pkg.LockRecord:
+				lock.Kind, lock.Record = RecordLock, int(pkg.Lock4Pos-held.StartPos)
+			case pkg.LockAppend:
+				lock.Kind = AppendLock
+			default:
+				lock.Kind = FileLock
+			}
+			locks = append(locks, lock)
+		}
+		slices.SortFunc(locks[first:], func(a, b Lock) int {
+			if a.Kind != b.Kind {
+				return cmp.Compare(b.Kind, a.Kind)
+			}
+			return cmp.Compare(a.Record, b.Record)
+		})
+	}
+	return locks
+}
+
 // Open establishes a connection to the specified DBF file using gomkfdbf
 func (p *pureGoImpl) Open(filename string) error {
 	if p.data != nil {
 		return fmt.Errorf("database already open")
 	}
+	if p.readOnly {
+		return ErrReadOnly
+	}
 
-	// Initialize CODE4 structure
-	p.codeBase = &pkg.Code4{}
+	// Initialize CODE4 structure, or share the session's, which has the
+	// session's lock retries
+	if p.shared != nil {
+		p.codeBase = p.shared
+	} else {
+		p.codeBase = &pkg.Code4{}
 
-	// Set default configuration
-	p.codeBase.AutoOpen = true
-	p.codeBase.ErrOff = 0 // Show errors
+		// Set default configuration
+		p.codeBase.AutoOpen = true
+		p.codeBase.ErrOff = 0 // Show errors
+		p.SetLockRetry(p.lockAttempts, p.lockDelay)
+	}
 
 	// Open the data file using gomkfdbf
 	p.data = pkg.D4Open(p.codeBase, filename)
@@ -52,6 +162,8 @@ func (p *pureGoImpl) Open(filename string) error {
 	}
 
 	p.filename = filename
+	p.text.open(Codepage(p.data.DataFile.Header.CodePage))
+	p.data.Text = keyText{&p.text}
 
 	// Build Fields collection from gomkfdbf data
 	err := p.buildFields()
@@ -69,12 +181,25 @@ func (p *pureGoImpl) Close() error {
 		return nil
 	}
 
+	// An unfinished transaction is undone, as CodeBase does on close,
+	// unless it belongs to the session
+	if pkg.Code4TransActive(p.codeBase) {
+		if p.shared != nil {
+			return fmt.Errorf("cannot close a session table inside a transaction")
+		}
+		pkg.Code4TransRollback(p.codeBase)
+	}
+
 	// Close data in gomkfdbf
 	pkg.D4Close(p.data)
 	p.data = nil
 	p.codeBase = nil
 	p.fields = nil
+	p.indexes = nil
 	p.filename = ""
+	p.text.close()
+	p.filter.clear()
+	p.rel.clear()
 
 	return nil
 }
@@ -84,6 +209,11 @@ func (p *pureGoImpl) Active() bool {
 	return p.data != nil
 }
 
+// Filename returns the path of the open table, empty when none is open
+func (p *pureGoImpl) Filename() string {
+	return p.filename
+}
+
 // Header returns database header information
 func (p *pureGoImpl) Header() Header {
 	if p.data == nil {
@@ -98,9 +228,9 @@ func (p *pureGoImpl) Header() Header {
 
 	header := Header{
 		recordCount: uint(dataFile.Header.NumRecs),
-		hasIndex:    false,          // Will be set based on actual index detection
-		hasFpt:      false,          // Will be set based on memo field detection
-		codepage:    Codepage(0x03), // Default to Windows ANSI
+		hasIndex:    false, // Will be set based on actual index detection
+		hasFpt:      false, // Will be set based on memo field detection
+		codepage:    Codepage(dataFile.Header.CodePage),
 	}
 
 	// Parse last updated date
@@ -247,26 +377,283 @@ func (p *pureGoImpl) Deleted() bool {
 }
 
 func (p *pureGoImpl) Delete() error {
-	if p.data == nil {
-		return fmt.Errorf("database not open")
+	if err := p.writable(); err != nil {
+		return err
 	}
 	pkg.D4Delete(p.data)
 	return nil
 }
 
 func (p *pureGoImpl) Recall() error {
+	if err := p.writable(); err != nil {
+		return err
+	}
+	pkg.D4Recall(p.data)
+	return nil
+}
+
+// Record writing methods
+func (p *pureGoImpl) Append() error {
+	if err := p.writable(); err != nil {
+		return err
+	}
+	result := pkg.D4AppendBlank(p.data)
+	if result == pkg.R4Locked {
+		return fmt.Errorf("append lock: %w", ErrLocked)
+	}
+	if result != pkg.ErrorNone {
+		return fmt.Errorf("failed to append record: %d", result)
+	}
+	return nil
+}
+
+func (p *pureGoImpl) Write() error {
+	if err := p.writable(); err != nil {
+		return err
+	}
+	if pkg.D4RecNo(p.data) < 1 || pkg.D4Eof(p.data) {
+		return fmt.Errorf("no current record")
+	}
+	result := pkg.D4Write(p.data)
+	if result != pkg.ErrorNone {
+		return fmt.Errorf("failed to write record %d: %d", pkg.D4RecNo(p.data), result)
+	}
+	return nil
+}
+
+// Transaction methods
+func (p *pureGoImpl) Begin() error {
+	if err := p.writable(); err != nil {
+		return err
+	}
+	if pkg.Code4TransActive(p.codeBase) {
+		return fmt.Errorf("transaction already in progress")
+	}
+	result := pkg.Code4TransInit(p.codeBase)
+	if result != pkg.ErrorNone {
+		return fmt.Errorf("failed to start transaction: %d", result)
+	}
+	return nil
+}
+
+func (p *pureGoImpl) Commit() error {
 	if p.data == nil {
 		return fmt.Errorf("database not open")
 	}
-	pkg.D4Recall(p.data)
+	if !pkg.Code4TransActive(p.codeBase) {
+		return fmt.Errorf("no transaction in progress")
+	}
+	result := pkg.Code4TransCommit(p.codeBase)
+	if result != pkg.ErrorNone {
+		return fmt.Errorf("failed to commit transaction: %d", result)
+	}
+	return nil
+}
+
+func (p *pureGoImpl) Rollback() error {
+	if p.data == nil {
+		return fmt.Errorf("database not open")
+	}
+	if !pkg.Code4TransActive(p.codeBase) {
+		return fmt.Errorf("no transaction in progress")
+	}
+	result := pkg.Code4TransRollback(p.codeBase)
+	if result != pkg.ErrorNone {
+		return fmt.Errorf("failed to roll back transaction: %d", result)
+	}
+	return nil
+}
+
+// Table maintenance methods
+func (p *pureGoImpl) Pack(progress ProgressFunc) error {
+	if err := p.writable(); err != nil {
+		return err
+	}
+	if pkg.Code4TransActive(p.codeBase) {
+		return fmt.Errorf("cannot pack inside a transaction")
+	}
+	return p.maintenanceResult(pkg.D4PackProgress(p.data, progress4(progress)), "pack")
+}
+
+func (p *pureGoImpl) Zap() error {
+	if err := p.writable(); err != nil {
+		return err
+	}
+	if pkg.Code4TransActive(p.codeBase) {
+		return fmt.Errorf("cannot zap inside a transaction")
+	}
+	return p.maintenanceResult(pkg.D4Zap(p.data, 1, pkg.D4RecCount(p.data)), "zap")
+}
+
+func (p *pureGoImpl) PackMemo(progress ProgressFunc) error {
+	if err := p.writable(); err != nil {
+		return err
+	}
+	if pkg.Code4TransActive(p.codeBase) {
+		return fmt.Errorf("cannot pack memo file inside a transaction")
+	}
+	if p.data.DataFile.MemoFile == nil {
+		return fmt.Errorf("table has no memo file")
+	}
+	return p.maintenanceResult(pkg.D4MemoCompressProgress(p.data, progress4(progress)), "pack memo file")
+}
+
+// maintenanceResult converts the result of a table maintenance call to an error
+func (p *pureGoImpl) maintenanceResult(result int, action string) error {
+	switch result {
+	case pkg.ErrorNone:
+		return nil
+	case pkg.R4Locked:
+		return fmt.Errorf("failed to %s: %w", action, ErrLocked)
+	default:
+		return fmt.Errorf("failed to %s: %d", action, result)
+	}
+}
+
+// progress4 adapts a ProgressFunc to the backend callback
+func progress4(progress ProgressFunc) pkg.Progress4 {
+	if progress == nil {
+		return nil
+	}
+	return func(done, total int32) {
+		progress(int(done), int(total))
+	}
+}
+
+// Locking methods
+func (p *pureGoImpl) LockRecord(recordNumber int) error {
+	if err := p.writable(); err != nil {
+		return err
+	}
+	if recordNumber < 1 || recordNumber > int(pkg.D4RecCount(p.data)) {
+		return fmt.Errorf("record %d out of range", recordNumber)
+	}
+	return p.lockResult(pkg.D4LockRecord(p.data, int32(recordNumber)), fmt.Sprintf("record %d", recordNumber))
+}
+
+func (p *pureGoImpl) LockAppend() error {
+	if err := p.writable(); err != nil {
+		return err
+	}
+	return p.lockResult(pkg.D4LockAppend(p.data), "append")
+}
+
+func (p *pureGoImpl) LockFile() error {
+	if err := p.writable(); err != nil {
+		return err
+	}
+	return p.lockResult(pkg.D4LockFile(p.data), "file")
+}
+
+func (p *pureGoImpl) Unlock() error {
+	if err := p.writable(); err != nil {
+		return err
+	}
+	result := pkg.D4Unlock(p.data)
+	if result != pkg.ErrorNone {
+		return fmt.Errorf("failed to unlock: %d", result)
+	}
 	return nil
 }
 
+func (p *pureGoImpl) SetLockRetry(attempts int, delay time.Duration) {
+	p.lockAttempts, p.lockDelay = attempts, delay
+	if p.codeBase != nil {
+		code4lockRetry(p.codeBase, attempts, delay)
+	}
+}
+
+// code4lockRetry sets the lock retries of a CODE4
+func code4lockRetry(codeBase *pkg.Code4, attempts int, delay time.Duration) {
+	// CodeBase counts the delay in hundredths of a second
+	codeBase.LockAttempts = attempts
+	codeBase.LockDelay = uint32((delay + 5*time.Millisecond) / (10 * time.Millisecond))
+}
+
+// writable returns an error unless a table is open and may be changed
+func (p *pureGoImpl) writable() error {
+	if p.data == nil {
+		return fmt.Errorf("database not open")
+	}
+	if p.readOnly {
+		return ErrReadOnly
+	}
+	return nil
+}
+
+// cursor returns a read-only implementation over a clone of the open
+// table, sharing its files, field descriptions and indexes
+func (p *pureGoImpl) cursor() (foxiImpl, error) {
+	clone := pkg.D4Clone(p.data)
+	if clone == nil {
+		return nil, fmt.Errorf("database not open")
+	}
+	c := &pureGoImpl{
+		codeBase:     clone.CodeBase,
+		data:         clone,
+		filename:     p.filename,
+		readOnly:     true,
+		lockAttempts: p.lockAttempts,
+		lockDelay:    p.lockDelay,
+		text:         p.text,
+	}
+	clone.Text = keyText{&c.text}
+	if err := c.buildFields(); err != nil {
+		pkg.D4Close(clone)
+		return nil, err
+	}
+	return c, nil
+}
+
+// textCodec returns the code page translation of the table
+func (p *pureGoImpl) textCodec() *textCodec {
+	return &p.text
+}
+
+// keyText translates the index keys of a table in its code page, as the
+// expressions compiled with Compile do
+type keyText struct {
+	text *textCodec
+}
+
+// Decode converts stored bytes to UTF-8
+func (k keyText) Decode(raw string) string {
+	return k.text.decode(raw)
+}
+
+// Encode converts UTF-8 to the stored bytes
+func (k keyText) Encode(s string) (string, error) {
+	return k.text.encode(s)
+}
+
+// lockResult converts the result of a lock call to an error
+func (p *pureGoImpl) lockResult(result int, what string) error {
+	switch result {
+	case pkg.ErrorNone:
+		return nil
+	case pkg.R4Locked:
+		return fmt.Errorf("%s lock: %w", what, ErrLocked)
+	default:
+		return fmt.Errorf("failed to take %s lock: %d", what, result)
+	}
+}
+
+// recordFilter returns the filter of the table
+func (p *pureGoImpl) recordFilter() *recordFilter {
+	return &p.filter
+}
+
+// relations returns the relations of the table
+func (p *pureGoImpl) relations() *relations {
+	return &p.rel
+}
+
 // Indexes returns the index collection
 func (p *pureGoImpl) Indexes() *Indexes {
 	if p.indexes == nil {
 		p.indexes = &Indexes{
 			impl: &pureGoIndexesImpl{
+				owner:  p,
 				data:   p.data,
 				loaded: false,
 			},
@@ -286,29 +673,14 @@ func (p *pureGoImpl) buildFields() error {
 		return fmt.Errorf("no field data available")
 	}
 
-	fieldCount := len(p.data.Fields)
-	fields := make([]Field, fieldCount)
-	indices := make(map[string]int)
-
+	fields := make([]Field, len(p.data.Fields))
 	for i, gomkField := range p.data.Fields {
-		// Create foxi field wrapper
-		field := &pureGoField{
+		fields[i] = &pureGoField{
 			impl:      p,
 			gomkField: gomkField,
 		}
-
-		fields[i] = field
-
-		// Create case-insensitive name mapping
-		name := strings.ToLower(string(gomkField.Name[:]))
-		name = strings.TrimRight(name, "\x00") // Remove null terminators
-		indices[name] = i
-	}
-
-	p.fields = &Fields{
-		fields:  fields,
-		indices: indices,
 	}
+	p.fields = newFields(fields)
 
 	return nil
 }
@@ -319,34 +691,43 @@ type pureGoField struct {
 	gomkField *pkg.Field4
 }
 
-// Value returns the field's native value
+// stored returns the bytes of the field in the current record
+func (f *pureGoField) stored() []byte {
+	raw := pkg.F4Ptr(f.gomkField)
+	switch rune(f.gomkField.Type) {
+	case pkg.FieldTypeVarChar, pkg.FieldTypeVarBin:
+		raw = raw[:pkg.F4VarLength(f.gomkField)]
+	}
+	return raw
+}
+
+// memo returns the contents of a memo field
+func (f *pureGoField) memo() []byte {
+	return pkg.F4MemoBytes(f.gomkField)
+}
+
+// textCodec returns the code page translation of the table
+func (f *pureGoField) textCodec() *textCodec {
+	return &f.impl.text
+}
+
+// Value returns the field's native value (see Field.Value for the types)
 func (f *pureGoField) Value() (interface{}, error) {
 	if f.impl.data == nil {
 		return nil, fmt.Errorf("database not open")
 	}
 
-	// Get field value based on type
-	switch rune(f.gomkField.Type) {
-	case 'C':
-		return pkg.F4Str(f.gomkField), nil
-	case 'N', 'F':
-		return pkg.F4Double(f.gomkField), nil
-	case 'L':
-		return pkg.F4True(f.gomkField), nil
-	case 'I':
-		return pkg.F4Long(f.gomkField), nil
-	default:
-		return pkg.F4Str(f.gomkField), nil
-	}
+	return storedValue(f)
 }
 
-// AsString returns field value as string
+// AsString returns field value as string, decoded from the table's code page
+// for character and memo fields
 func (f *pureGoField) AsString() (string, error) {
 	if f.impl.data == nil {
 		return "", fmt.Errorf("database not open")
 	}
 
-	return pkg.F4Str(f.gomkField), nil
+	return storedString(f), nil
 }
 
 // AsInt returns field value as integer
@@ -382,13 +763,16 @@ func (f *pureGoField) AsTime() (time.Time, error) {
 		return time.Time{}, fmt.Errorf("database not open")
 	}
 
-	// Convert from gomkfdbf date format
-	dateStr := pkg.F4Str(f.gomkField)
-	if len(dateStr) != 8 {
-		return time.Time{}, fmt.Errorf("invalid date format")
+	return storedTime(f)
+}
+
+// AsDecimal returns the exact value of a numeric field
+func (f *pureGoField) AsDecimal() (Decimal, error) {
+	if f.impl.data == nil {
+		return Decimal{}, fmt.Errorf("database not open")
 	}
 
-	return time.Parse("20060102", dateStr)
+	return storedDecimal(f)
 }
 
 // IsNull checks if field value is null
@@ -397,22 +781,127 @@ func (f *pureGoField) IsNull() (bool, error) {
 		return false, fmt.Errorf("database not open")
 	}
 
-	// Basic null checking - for now, check if field supports nulls
-	// and if the field content is all spaces or empty
-	if f.gomkField.Null == 0 {
-		return false, nil // Field doesn't support nulls
+	return pkg.F4Null(f.gomkField), nil
+}
+
+// SetString assigns a string value, converting it to the field's storage format
+func (f *pureGoField) SetString(value string) error {
+	if err := f.impl.writable(); err != nil {
+		return err
+	}
+
+	// Numbers are checked against the field as SetDecimal does
+	if f.Type().isNumeric() {
+		d, ok, err := textDecimal(f, value)
+		if err != nil {
+			return err
+		}
+		if ok {
+			return f.SetDecimal(d)
+		}
+	}
+
+	stored, err := f.impl.text.encodeField(f, value)
+	if err != nil {
+		return err
+	}
+
+	result := pkg.F4Assign(f.gomkField, stored)
+	if result != pkg.ErrorNone {
+		return fmt.Errorf("invalid value %q for field %s", value, f.Name())
+	}
+	return nil
+}
+
+// SetInt assigns an integer value to a numeric field
+func (f *pureGoField) SetInt(value int) error {
+	return f.SetDecimal(NewDecimal(int64(value), 0))
+}
+
+// SetFloat assigns a floating point value to a numeric field, rounded to
+// the field's decimals
+func (f *pureGoField) SetFloat(value float64) error {
+	if err := f.impl.writable(); err != nil {
+		return err
+	}
+	if err := checkSettable(f, "number"); err != nil {
+		return err
 	}
 
-	// Check if field content is all spaces (DBF null representation)
-	value := pkg.F4Str(f.gomkField)
-	value = strings.TrimSpace(value)
-	return len(value) == 0, nil
+	d, err := floatDecimal(f, value)
+	if err != nil {
+		return err
+	}
+	return f.SetDecimal(d)
+}
+
+// SetBool assigns a boolean value to a logical field
+func (f *pureGoField) SetBool(value bool) error {
+	if err := f.impl.writable(); err != nil {
+		return err
+	}
+	if err := checkSettable(f, "bool"); err != nil {
+		return err
+	}
+
+	result := pkg.F4AssignLogical(f.gomkField, value)
+	if result != pkg.ErrorNone {
+		return fmt.Errorf("failed to assign field %s", f.Name())
+	}
+	return nil
+}
+
+// SetTime assigns a time value to a date or datetime field
+func (f *pureGoField) SetTime(value time.Time) error {
+	if err := f.impl.writable(); err != nil {
+		return err
+	}
+	if err := checkSettable(f, "time"); err != nil {
+		return err
+	}
+
+	result := pkg.F4AssignDateTime(f.gomkField, value)
+	if result != pkg.ErrorNone {
+		return fmt.Errorf("failed to assign field %s", f.Name())
+	}
+	return nil
+}
+
+// SetDecimal assigns the exact value of a decimal to a numeric field
+func (f *pureGoField) SetDecimal(value Decimal) error {
+	if err := f.impl.writable(); err != nil {
+		return err
+	}
+	stored, err := decimalBytes(f, value)
+	if err != nil {
+		return err
+	}
+
+	if pkg.F4AssignN(f.gomkField, stored) != pkg.ErrorNone {
+		return fmt.Errorf("failed to assign field %s", f.Name())
+	}
+	return nil
+}
+
+// SetNull sets a nullable field to null
+func (f *pureGoField) SetNull() error {
+	if err := f.impl.writable(); err != nil {
+		return err
+	}
+	if err := checkSettable(f, "null"); err != nil {
+		return err
+	}
+
+	result := pkg.F4AssignNull(f.gomkField)
+	if result != pkg.ErrorNone {
+		return fmt.Errorf("failed to set field %s to null", f.Name())
+	}
+	return nil
 }
 
 // Name returns field name
 func (f *pureGoField) Name() string {
-	name := string(f.gomkField.Name[:])
-	return strings.TrimRight(name, "\x00") // Remove null terminators
+	return pkg.F4Name(f.gomkField)
 }
 
 // Type returns field type
@@ -431,10 +920,9 @@ func (f *pureGoField) Decimals() uint8 {
 	return uint8(f.gomkField.Dec)
 }
 
-// IsSystem returns if field is system field
+// IsSystem returns if field is system field, such as _NullFlags
 func (f *pureGoField) IsSystem() bool {
-	// Basic implementation - could be enhanced
-	return false
+	return f.gomkField.Flags&pkg.FieldFlagSystem != 0 || f.gomkField.Type == int16(pkg.FieldTypeSystem)
 }
 
 // IsNullable returns if field can be null
@@ -505,6 +993,15 @@ func (f *pureGoField) MustAsTime() time.Time {
 	return value
 }
 
+// MustAsDecimal returns the exact value of a numeric field, panicking on error
+func (f *pureGoField) MustAsDecimal() Decimal {
+	value, err := f.AsDecimal()
+	if err != nil {
+		panic(err)
+	}
+	return value
+}
+
 // MustIsNull checks if field value is null, panicking on error
 func (f *pureGoField) MustIsNull() bool {
 	value, err := f.IsNull()
@@ -514,6 +1011,55 @@ func (f *pureGoField) MustIsNull() bool {
 	return value
 }
 
+// MustSetString assigns a string value, panicking on error
+func (f *pureGoField) MustSetString(value string) {
+	if err := f.SetString(value); err != nil {
+		panic(err)
+	}
+}
+
+// MustSetInt assigns an integer value, panicking on error
+func (f *pureGoField) MustSetInt(value int) {
+	if err := f.SetInt(value); err != nil {
+		panic(err)
+	}
+}
+
+// MustSetFloat assigns a floating point value, panicking on error
+func (f *pureGoField) MustSetFloat(value float64) {
+	if err := f.SetFloat(value); err != nil {
+		panic(err)
+	}
+}
+
+// MustSetBool assigns a boolean value, panicking on error
+func (f *pureGoField) MustSetBool(value bool) {
+	if err := f.SetBool(value); err != nil {
+		panic(err)
+	}
+}
+
+// MustSetTime assigns a time value, panicking on error
+func (f *pureGoField) MustSetTime(value time.Time) {
+	if err := f.SetTime(value); err != nil {
+		panic(err)
+	}
+}
+
+// MustSetDecimal assigns a decimal value, panicking on error
+func (f *pureGoField) MustSetDecimal(value Decimal) {
+	if err := f.SetDecimal(value); err != nil {
+		panic(err)
+	}
+}
+
+// MustSetNull sets the field to null, panicking on error
+func (f *pureGoField) MustSetNull() {
+	if err := f.SetNull(); err != nil {
+		panic(err)
+	}
+}
+
 // convertFromGomkFieldType converts gomkfdbf field type to foxi FieldType
 //
 //nolint:gocyclo // TODO: refactor to reduce complexity by using lookup table
@@ -562,6 +1108,7 @@ func convertFromGomkFieldType(gomkType rune) FieldType {
 
 // pureGoIndexesImpl implements indexesImpl for the pure Go backend
 type pureGoIndexesImpl struct {
+	owner   *pureGoImpl
 	data    *pkg.Data4
 	indexes []Index
 	tags    []Tag
@@ -581,26 +1128,24 @@ func (idx *pureGoIndexesImpl) Load() error {
 	var indexes []Index
 	var allTags []Tag
 
-	// Try to open production index (same name as DBF with .CDX extension)
-	dbfFileName := pkg.D4FileName(idx.data)
-	if dbfFileName != "" {
-		baseName := strings.TrimSuffix(dbfFileName, ".dbf")
-		cdxFileName := baseName + ".cdx"
-
-		// Attempt to open the production index
-		index4 := pkg.I4Open(idx.data, cdxFileName)
-		if index4 != nil {
-			index := &pureGoIndex{
-				index4:       index4,
-				data:         idx.data,
-				isProduction: true,
-			}
-			indexes = append(indexes, index)
-
-			// Load tags from this index
-			indexTags := index.Tags()
-			allTags = append(allTags, indexTags...)
+	// The production index is normally opened together with the table;
+	// open it here when auto open was off
+	index4 := pkg.D4Index(idx.data, "")
+	if index4 == nil && !idx.owner.readOnly {
+		index4 = pkg.I4Open(idx.data, "")
+	}
+	if index4 != nil {
+		index := &pureGoIndex{
+			owner:        idx.owner,
+			index4:       index4,
+			data:         idx.data,
+			isProduction: true,
 		}
+		indexes = append(indexes, index)
+
+		// Load tags from this index
+		indexTags := index.Tags()
+		allTags = append(allTags, indexTags...)
 	}
 
 	// TODO: Look for additional standalone index files (.IDX, named .CDX files)
@@ -704,8 +1249,85 @@ func (idx *pureGoIndexesImpl) Tags() []Tag {
 	return idx.tags
 }
 
+// CreateTag adds a tag to the production index, creating the index when
+// the table has none
+func (idx *pureGoIndexesImpl) CreateTag(name, expr, forExpr string, unique, descending bool) error {
+	if err := idx.owner.writable(); err != nil {
+		return err
+	}
+	if pkg.Code4TransActive(idx.data.CodeBase) {
+		return fmt.Errorf("cannot create tag inside a transaction")
+	}
+	if pkg.D4Tag(idx.data, name) != nil {
+		return fmt.Errorf("tag already exists: %s", name)
+	}
+	spec := TagSpec{Name: name, Expression: expr, Filter: forExpr}
+	if err := checkTag(tableFieldEnv(idx.owner), spec); err != nil {
+		return err
+	}
+
+	info := pkg.Tag4Info{
+		Name:       name,
+		Expression: expr,
+		Filter:     forExpr,
+	}
+	if unique {
+		info.Unique = 1
+	}
+	if descending {
+		info.Descending = 1
+	}
+	err := indexResult(pkg.I4TagAdd(idx.data, nil, info), "create tag "+name)
+	idx.reload()
+	return err
+}
+
+// DropTag removes a tag from its index
+func (idx *pureGoIndexesImpl) DropTag(name string) error {
+	if err := idx.owner.writable(); err != nil {
+		return err
+	}
+	if pkg.Code4TransActive(idx.data.CodeBase) {
+		return fmt.Errorf("cannot drop tag inside a transaction")
+	}
+	tag4 := pkg.D4Tag(idx.data, name)
+	if tag4 == nil {
+		return fmt.Errorf("tag not found: %s", name)
+	}
+	err := indexResult(pkg.I4TagRemove(tag4), "drop tag "+name)
+	idx.reload()
+	return err
+}
+
+// Reindex rebuilds every open index
+func (idx *pureGoIndexesImpl) Reindex() error {
+	if err := idx.owner.writable(); err != nil {
+		return err
+	}
+	return indexResult(pkg.D4Reindex(idx.data), "reindex")
+}
+
+// reload loads the indexes again after their tags changed
+func (idx *pureGoIndexesImpl) reload() {
+	idx.loaded = false
+	idx.Load()
+}
+
+// indexResult converts the result of an index maintenance call to an error
+func indexResult(result int, action string) error {
+	switch result {
+	case pkg.ErrorNone:
+		return nil
+	case pkg.R4Locked:
+		return fmt.Errorf("failed to %s: %w", action, ErrLocked)
+	default:
+		return fmt.Errorf("failed to %s: %d", action, result)
+	}
+}
+
 // pureGoIndex implements Index for the pure Go backend
 type pureGoIndex struct {
+	owner        *pureGoImpl
 	index4       *pkg.Index4
 	data         *pkg.Data4
 	tags         []Tag
@@ -717,7 +1339,8 @@ func (idx *pureGoIndex) Name() string {
 	if idx.index4 == nil {
 		return ""
 	}
-	return strings.TrimSuffix(filepath.Base(string(idx.index4.AccessName[:])), ".cdx")
+	fileName := filepath.Base(pkg.I4FileName(idx.index4))
+	return strings.TrimSuffix(fileName, filepath.Ext(fileName))
 }
 
 // FileName returns the index file name
@@ -725,7 +1348,7 @@ func (idx *pureGoIndex) FileName() string {
 	if idx.index4 == nil {
 		return ""
 	}
-	return string(idx.index4.AccessName[:])
+	return pkg.I4FileName(idx.index4)
 }
 
 // TagCount returns the number of tags in this index
@@ -778,6 +1401,23 @@ func (idx *pureGoIndex) IsProduction() bool {
 	return idx.isProduction
 }
 
+// Reindex rebuilds every tag of this index from the table
+func (idx *pureGoIndex) Reindex() error {
+	if idx.index4 == nil || idx.data == nil {
+		return fmt.Errorf("index not open")
+	}
+	if idx.owner.readOnly {
+		return ErrReadOnly
+	}
+	if !pkg.D4LockTestFile(idx.data) {
+		if err := indexResult(pkg.D4LockAll(idx.data), "lock table"); err != nil {
+			return err
+		}
+		defer pkg.D4Unlock(idx.data)
+	}
+	return indexResult(pkg.I4Reindex(idx.index4), "reindex "+idx.Name())
+}
+
 // loadTags loads all tags from this index
 func (idx *pureGoIndex) loadTags() {
 	if idx.index4 == nil || idx.data == nil {
@@ -827,8 +1467,7 @@ func (tag *pureGoTag) Expression() string {
 
 // Filter returns the tag filter expression
 func (tag *pureGoTag) Filter() string {
-	// gomkfdbf doesn't expose filter directly in current API
-	return ""
+	return pkg.T4Filter(tag.tag4)
 }
 
 // KeyLength returns the key length
@@ -882,13 +1521,38 @@ func (tag *pureGoTag) SeekString(value string) (SeekResult, error) {
 		return SeekEOF, fmt.Errorf("database not open")
 	}
 
+	// Keys hold strings in the table's code page
+	value, err := tag.index.owner.text.encode(value)
+	if err != nil {
+		return SeekEOF, err
+	}
+
 	// Select this tag first
 	pkg.D4TagSelect(tag.data, tag.tag4)
 
 	// Perform seek using gomkfdbf D4Seek function
-	result := pkg.D4Seek(tag.data, value)
+	return convertGomkSeekResult(pkg.D4Seek(tag.data, value))
+}
+
+// SeekDouble performs a seek operation with float64 value
+func (tag *pureGoTag) SeekDouble(value float64) (SeekResult, error) {
+	if tag.data == nil || tag.tag4 == nil {
+		return SeekEOF, fmt.Errorf("database not open")
+	}
+
+	// Select this tag first
+	pkg.D4TagSelect(tag.data, tag.tag4)
 
-	// Convert gomkfdbf result to foxi result
+	return convertGomkSeekResult(pkg.D4SeekDouble(tag.data, value))
+}
+
+// SeekInt performs a seek operation with int value
+func (tag *pureGoTag) SeekInt(value int) (SeekResult, error) {
+	return tag.SeekDouble(float64(value))
+}
+
+// convertGomkSeekResult converts a gomkfdbf seek result to a foxi result
+func convertGomkSeekResult(result int) (SeekResult, error) {
 	switch result {
 	case pkg.R4Success:
 		return SeekSuccess, nil
@@ -901,16 +1565,6 @@ func (tag *pureGoTag) SeekString(value string) (SeekResult, error) {
 	}
 }
 
-// SeekDouble performs a seek operation with float64 value
-func (tag *pureGoTag) SeekDouble(value float64) (SeekResult, error) {
-	return tag.SeekString(fmt.Sprintf("%g", value))
-}
-
-// SeekInt performs a seek operation with int value
-func (tag *pureGoTag) SeekInt(value int) (SeekResult, error) {
-	return tag.SeekString(fmt.Sprintf("%d", value))
-}
-
 // First moves to first record in tag order
 func (tag *pureGoTag) First() error {
 	if tag.data == nil || tag.tag4 == nil {
@@ -1025,6 +1679,26 @@ func (tag *pureGoTag) PositionSet(percent float64) error {
 	return nil
 }
 
+// Range iterates over the records with keys from lo through hi
+func (tag *pureGoTag) Range(lo, hi interface{}) iter.Seq2[Record, error] {
+	return rangeRecords(tag.index.owner, tag, lo, hi, false)
+}
+
+// RangeReverse iterates over the records with keys from hi back to lo
+func (tag *pureGoTag) RangeReverse(lo, hi interface{}) iter.Seq2[Record, error] {
+	return rangeRecords(tag.index.owner, tag, lo, hi, true)
+}
+
+// Prefix iterates over the records whose keys start with prefix
+func (tag *pureGoTag) Prefix(prefix string) iter.Seq2[Record, error] {
+	return prefixRecords(tag.index.owner, tag, prefix, false)
+}
+
+// PrefixReverse iterates backwards over the records whose keys start with prefix
+func (tag *pureGoTag) PrefixReverse(prefix string) iter.Seq2[Record, error] {
+	return prefixRecords(tag.index.owner, tag, prefix, true)
+}
+
 // CurrentKey returns the current index key value
 func (tag *pureGoTag) CurrentKey() string {
 	if tag.data == nil || tag.tag4 == nil {
@@ -1036,9 +1710,7 @@ func (tag *pureGoTag) CurrentKey() string {
 		pkg.D4TagSelect(tag.data, tag.tag4)
 	}
 
-	// For now, return a simple representation
-	// In a full implementation, this would evaluate the tag expression
-	return fmt.Sprintf("key_%d", pkg.D4RecNo(tag.data))
+	return string(pkg.T4Key(tag.tag4))
 }
 
 // RecordNumber returns the current record number
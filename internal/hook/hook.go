@@ -0,0 +1,11 @@
+// Package hook gives the subpackages of foxi, such as sqldriver, access
+// to parts of package foxi that are not part of its API. Package foxi
+// sets the hooks when it is initialized, so they are ready in any package
+// importing it.
+package hook
+
+// CheckDecimal returns the error Field.SetDecimal would return for a
+// foxi.Decimal, without changing the field: the field does not hold
+// numbers or the value overflows it. The arguments are a foxi.Field and a
+// foxi.Decimal, typed any as this package cannot import foxi.
+var CheckDecimal func(field, d any) error
@@ -0,0 +1,950 @@
+package sqlparse
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// =========================================================================
+// LEXER
+// =========================================================================
+
+// tokenKind classifies a lexical token
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokIdent
+	tokKeyword
+	tokLiteral
+	tokParam
+	tokOperator
+)
+
+// token is a lexical token with its position in the statement text
+type token struct {
+	kind  tokenKind
+	text  string // Keywords and word operators are upper case; literals are xBase source
+	start int
+	end   int
+}
+
+// keywords are the reserved words of the supported SQL subset
+var keywords = map[string]bool{
+	"SELECT": true, "DISTINCT": true, "TOP": true, "FROM": true, "AS": true,
+	"JOIN": true, "INNER": true, "LEFT": true, "RIGHT": true, "FULL": true,
+	"OUTER": true, "ON": true, "WHERE": true, "GROUP": true, "BY": true,
+	"HAVING": true, "ORDER": true, "ASC": true, "DESC": true, "INTO": true,
+	"TABLE": true, "DBF": true, "CURSOR": true, "AND": true, "OR": true,
+	"NOT": true, "NULL": true, "IS": true, "IN": true, "LIKE": true,
+	"BETWEEN": true, "LIMIT": true, "OFFSET": true, "INSERT": true,
+	"VALUES": true, "UPDATE": true, "SET": true, "DELETE": true,
+	"TRUE": true, "FALSE": true,
+}
+
+// dotWords are the xBase operators and constants written between dots
+var dotWords = map[string]token{
+	".AND.":  {kind: tokKeyword, text: "AND"},
+	".OR.":   {kind: tokKeyword, text: "OR"},
+	".NOT.":  {kind: tokKeyword, text: "NOT"},
+	".T.":    {kind: tokLiteral, text: ".T."},
+	".F.":    {kind: tokLiteral, text: ".F."},
+	".NULL.": {kind: tokLiteral, text: ".NULL."},
+}
+
+// lex splits a statement into tokens
+func lex(sql string) ([]token, error) {
+	var tokens []token
+	for i := 0; i < len(sql); {
+		c := sql[i]
+		switch {
+		case unicode.IsSpace(rune(c)):
+			i++
+
+		case c == '-' && i+1 < len(sql) && sql[i+1] == '-':
+			for i < len(sql) && sql[i] != '\n' {
+				i++
+			}
+
+		case c == '.' && matchDotWord(sql[i:]) != "":
+			word := matchDotWord(sql[i:])
+			tok := dotWords[word]
+			tok.start, tok.end = i, i+len(word)
+			tokens = append(tokens, tok)
+			i += len(word)
+
+		case isIdentStart(c):
+			start := i
+			for i < len(sql) && isIdentPart(sql[i]) {
+				i++
+			}
+			word := sql[start:i]
+			if upper := strings.ToUpper(word); keywords[upper] {
+				tokens = append(tokens, token{tokKeyword, upper, start, i})
+			} else {
+				tokens = append(tokens, token{tokIdent, word, start, i})
+			}
+
+		case c == '`':
+			// A quoted name, which may be a keyword
+			end := strings.IndexByte(sql[i+1:], '`')
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated name at offset %d", i)
+			}
+			tokens = append(tokens, token{tokIdent, sql[i+1 : i+end+1], i, i + end + 2})
+			i += end + 2
+
+		case c >= '0' && c <= '9' || c == '.' && i+1 < len(sql) && sql[i+1] >= '0' && sql[i+1] <= '9':
+			start := i
+			for i < len(sql) && (sql[i] >= '0' && sql[i] <= '9' || sql[i] == '.') {
+				i++
+			}
+			if i < len(sql) && (sql[i] == 'e' || sql[i] == 'E') {
+				i++
+				if i < len(sql) && (sql[i] == '+' || sql[i] == '-') {
+					i++
+				}
+				for i < len(sql) && sql[i] >= '0' && sql[i] <= '9' {
+					i++
+				}
+			}
+			tokens = append(tokens, token{tokLiteral, sql[start:i], start, i})
+
+		case c == '\'':
+			// A string, which doubles the quotes it contains as 'it''s'
+			start := i
+			var text strings.Builder
+			for i++; ; i++ {
+				if i >= len(sql) {
+					return nil, fmt.Errorf("unterminated literal at offset %d", start)
+				}
+				if sql[i] == '\'' {
+					if i+1 < len(sql) && sql[i+1] == '\'' {
+						text.WriteByte('\'')
+						i++
+						continue
+					}
+					i++
+					break
+				}
+				text.WriteByte(sql[i])
+			}
+			quoted, err := Quote(text.String())
+			if err != nil {
+				return nil, fmt.Errorf("%w at offset %d", err, start)
+			}
+			tokens = append(tokens, token{tokLiteral, quoted, start, i})
+
+		case c == '"' || c == '[' || c == '{':
+			// Strings and dates are passed on to the expression compiler
+			// as written
+			closing := map[byte]byte{'"': '"', '[': ']', '{': '}'}[c]
+			end := strings.IndexByte(sql[i+1:], closing)
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated literal at offset %d", i)
+			}
+			tokens = append(tokens, token{tokLiteral, sql[i : i+end+2], i, i + end + 2})
+			i += end + 2
+
+		case c == '?':
+			tokens = append(tokens, token{tokParam, "?", i, i + 1})
+			i++
+
+		default:
+			start := i
+			op := string(c)
+			if i+1 < len(sql) {
+				switch two := sql[i : i+2]; two {
+				case "<>", "!=", "<=", ">=", "==", "->", "**", "||":
+					op = two
+				}
+			}
+			if len(op) == 1 && !strings.Contains("=<>#$!+-*/%^(),;.", op) {
+				return nil, fmt.Errorf("unexpected character %q at offset %d", c, i)
+			}
+			i += len(op)
+			if op == "!" {
+				tokens = append(tokens, token{tokKeyword, "NOT", start, i})
+				continue
+			}
+			tokens = append(tokens, token{tokOperator, op, start, i})
+		}
+	}
+	return append(tokens, token{kind: tokEOF, start: len(sql), end: len(sql)}), nil
+}
+
+// matchDotWord returns the dot word starting s, if any
+func matchDotWord(s string) string {
+	for word := range dotWords {
+		if len(s) >= len(word) && strings.EqualFold(s[:len(word)], word) {
+			return word
+		}
+	}
+	return ""
+}
+
+func isIdentStart(c byte) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+func isIdentPart(c byte) bool {
+	return isIdentStart(c) || c >= '0' && c <= '9'
+}
+
+// IsIdent reports whether a name is made of letters, digits and
+// underscores, as the names of tables, aliases and columns are.
+func IsIdent(name string) bool {
+	if name == "" || !isIdentStart(name[0]) {
+		return false
+	}
+	for i := 1; i < len(name); i++ {
+		if !isIdentPart(name[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// =========================================================================
+// PARSER
+// =========================================================================
+
+// parser is a recursive descent parser over the tokens of a statement
+type parser struct {
+	sql    string
+	tokens []token
+	pos    int
+	params int // Placeholders seen so far
+}
+
+// Parse parses a statement and returns it with the number of ? placeholders
+// it uses.
+func Parse(sql string) (Statement, int, error) {
+	tokens, err := lex(sql)
+	if err != nil {
+		return nil, 0, err
+	}
+	p := &parser{sql: sql, tokens: tokens}
+
+	var stmt Statement
+	switch tok := p.peek(); {
+	case p.keyword("SELECT"):
+		var s *Select
+		if s, err = p.selectStmt(); err == nil {
+			s.Text = sql
+			stmt = s
+		}
+	case p.keyword("INSERT"):
+		stmt, err = p.insertStmt()
+	case p.keyword("UPDATE"):
+		stmt, err = p.updateStmt()
+	case p.keyword("DELETE"):
+		stmt, err = p.deleteStmt()
+	default:
+		return nil, 0, p.errorf(tok, "expected SELECT, INSERT, UPDATE or DELETE, found %q", p.text(tok))
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+
+	p.operator(";")
+	if tok := p.peek(); tok.kind != tokEOF {
+		return nil, 0, p.errorf(tok, "unexpected %q", p.text(tok))
+	}
+	return stmt, p.params, nil
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	tok := p.tokens[p.pos]
+	if tok.kind != tokEOF {
+		p.pos++
+	}
+	return tok
+}
+
+// text returns the source text of a token
+func (p *parser) text(tok token) string {
+	if tok.kind == tokEOF {
+		return "end of statement"
+	}
+	return p.sql[tok.start:tok.end]
+}
+
+func (p *parser) errorf(tok token, format string, args ...any) error {
+	return fmt.Errorf("%s at offset %d", fmt.Sprintf(format, args...), tok.start)
+}
+
+// keyword consumes the given keyword if it is next
+func (p *parser) keyword(word string) bool {
+	if tok := p.peek(); tok.kind == tokKeyword && tok.text == word {
+		p.next()
+		return true
+	}
+	return false
+}
+
+// operator consumes the given operator if it is next
+func (p *parser) operator(op string) bool {
+	if tok := p.peek(); tok.kind == tokOperator && tok.text == op {
+		p.next()
+		return true
+	}
+	return false
+}
+
+func (p *parser) expectKeyword(word string) error {
+	if !p.keyword(word) {
+		return p.errorf(p.peek(), "expected %s, found %q", word, p.text(p.peek()))
+	}
+	return nil
+}
+
+func (p *parser) expectOperator(op string) error {
+	if !p.operator(op) {
+		return p.errorf(p.peek(), "expected %q, found %q", op, p.text(p.peek()))
+	}
+	return nil
+}
+
+// ident reads a table, alias or column name
+func (p *parser) ident(what string) (string, error) {
+	tok := p.peek()
+	if tok.kind != tokIdent {
+		return "", p.errorf(tok, "expected %s, found %q", what, p.text(tok))
+	}
+	p.next()
+	return tok.text, nil
+}
+
+// selectStmt parses, after SELECT,
+//
+//	[DISTINCT] [TOP n] items FROM tables [WHERE cond] [GROUP BY exprs]
+//	[HAVING cond] [ORDER BY exprs] [LIMIT n [OFFSET m]] [INTO TABLE name]
+//
+// INTO may also follow the FROM clause, as Visual FoxPro allows.
+func (p *parser) selectStmt() (*Select, error) {
+	s := &Select{}
+	s.Distinct = p.keyword("DISTINCT")
+	if p.keyword("TOP") {
+		tok := p.next()
+		n, err := strconv.Atoi(tok.text)
+		if tok.kind != tokLiteral || err != nil || n < 1 {
+			return nil, p.errorf(tok, "TOP needs a positive whole number")
+		}
+		s.Top = n
+	}
+
+	for {
+		item, err := p.selectItem()
+		if err != nil {
+			return nil, err
+		}
+		s.Items = append(s.Items, item)
+		if !p.operator(",") {
+			break
+		}
+	}
+
+	if err := p.expectKeyword("FROM"); err != nil {
+		return nil, err
+	}
+	if err := p.fromClause(s); err != nil {
+		return nil, err
+	}
+
+	var err error
+	for {
+		tok := p.peek()
+		switch {
+		case p.keyword("INTO"):
+			if s.Into != "" {
+				return nil, p.errorf(tok, "INTO given twice")
+			}
+			err = p.intoClause(s)
+		case p.keyword("WHERE"):
+			if s.Where != nil {
+				return nil, p.errorf(tok, "WHERE given twice")
+			}
+			s.Where, err = p.expr()
+		case p.keyword("GROUP"):
+			if s.GroupBy != nil {
+				return nil, p.errorf(tok, "GROUP BY given twice")
+			}
+			if err = p.expectKeyword("BY"); err == nil {
+				s.GroupBy, err = p.exprList()
+			}
+		case p.keyword("HAVING"):
+			if s.Having != nil {
+				return nil, p.errorf(tok, "HAVING given twice")
+			}
+			s.Having, err = p.expr()
+		case p.keyword("ORDER"):
+			if s.OrderBy != nil {
+				return nil, p.errorf(tok, "ORDER BY given twice")
+			}
+			if err = p.expectKeyword("BY"); err == nil {
+				s.OrderBy, err = p.orderBy()
+			}
+		case p.keyword("LIMIT"):
+			if s.Limit != nil {
+				return nil, p.errorf(tok, "LIMIT given twice")
+			}
+			if s.Limit, err = p.additive(); err == nil && p.keyword("OFFSET") {
+				s.Offset, err = p.additive()
+			}
+		default:
+			return s, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// selectItem parses *, alias.* or expr [[AS] name]
+func (p *parser) selectItem() (SelectItem, error) {
+	if p.operator("*") {
+		return SelectItem{Star: "*"}, nil
+	}
+	if tok := p.peek(); tok.kind == tokIdent {
+		dot := p.tokens[p.pos+1]
+		if dot.kind == tokOperator && (dot.text == "." || dot.text == "->") {
+			if star := p.tokens[p.pos+2]; star.kind == tokOperator && star.text == "*" {
+				p.pos += 3
+				return SelectItem{Star: tok.text}, nil
+			}
+		}
+	}
+
+	e, err := p.expr()
+	if err != nil {
+		return SelectItem{}, err
+	}
+	item := SelectItem{Expr: e}
+	if p.keyword("AS") {
+		if item.Alias, err = p.ident("column name"); err != nil {
+			return SelectItem{}, err
+		}
+	} else if p.peek().kind == tokIdent {
+		item.Alias = p.next().text
+	}
+	return item, nil
+}
+
+// fromClause parses table [[AS] alias] followed by more tables after
+// commas and JOIN clauses
+func (p *parser) fromClause(s *Select) error {
+	join := JoinCross
+	for {
+		item := FromItem{Join: join}
+		var err error
+		if item.Table, err = p.ident("table name"); err != nil {
+			return err
+		}
+		if p.keyword("AS") {
+			if item.Alias, err = p.ident("alias"); err != nil {
+				return err
+			}
+		} else if p.peek().kind == tokIdent {
+			item.Alias = p.next().text
+		}
+		if join != JoinCross {
+			if err := p.expectKeyword("ON"); err != nil {
+				return err
+			}
+			if item.On, err = p.expr(); err != nil {
+				return err
+			}
+		}
+		s.From = append(s.From, item)
+
+		tok := p.peek()
+		switch {
+		case p.operator(","):
+			join = JoinCross
+		case p.keyword("JOIN"):
+			join = JoinInner
+		case p.keyword("INNER"):
+			join = JoinInner
+			if err := p.expectKeyword("JOIN"); err != nil {
+				return err
+			}
+		case p.keyword("LEFT"):
+			join = JoinLeft
+			p.keyword("OUTER")
+			if err := p.expectKeyword("JOIN"); err != nil {
+				return err
+			}
+		case p.keyword("RIGHT"), p.keyword("FULL"):
+			return p.errorf(tok, "%s JOIN is not supported; list the tables the other way round and use LEFT JOIN", tok.text)
+		default:
+			return nil
+		}
+	}
+}
+
+// intoClause parses TABLE name or DBF name after INTO
+func (p *parser) intoClause(s *Select) error {
+	tok := p.peek()
+	if !p.keyword("TABLE") && !p.keyword("DBF") {
+		return p.errorf(tok, "only INTO TABLE is supported")
+	}
+	name := p.peek()
+	switch name.kind {
+	case tokIdent:
+		s.Into = p.next().text
+	case tokLiteral:
+		// A quoted path
+		if text := p.next().text; strings.ContainsAny(text[:1], `"'[`) {
+			s.Into = text[1 : len(text)-1]
+		}
+	}
+	if s.Into == "" {
+		return p.errorf(name, "expected table name, found %q", p.text(name))
+	}
+	return nil
+}
+
+// orderBy parses expr [ASC|DESC], ...
+func (p *parser) orderBy() ([]OrderItem, error) {
+	var items []OrderItem
+	for {
+		e, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		item := OrderItem{Expr: e}
+		if p.keyword("DESC") {
+			item.Desc = true
+		} else {
+			p.keyword("ASC")
+		}
+		items = append(items, item)
+		if !p.operator(",") {
+			return items, nil
+		}
+	}
+}
+
+// insertStmt parses, after INSERT,
+//
+//	INTO table [(col, ...)] VALUES (expr, ...)[, (expr, ...)]
+func (p *parser) insertStmt() (*Insert, error) {
+	if err := p.expectKeyword("INTO"); err != nil {
+		return nil, err
+	}
+	s := &Insert{}
+	var err error
+	if s.Table, err = p.ident("table name"); err != nil {
+		return nil, err
+	}
+
+	if p.operator("(") {
+		for {
+			name, err := p.ident("column name")
+			if err != nil {
+				return nil, err
+			}
+			s.Columns = append(s.Columns, name)
+			if !p.operator(",") {
+				break
+			}
+		}
+		if err := p.expectOperator(")"); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := p.expectKeyword("VALUES"); err != nil {
+		return nil, err
+	}
+	for {
+		tok := p.peek()
+		if err := p.expectOperator("("); err != nil {
+			return nil, err
+		}
+		row, err := p.exprList()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expectOperator(")"); err != nil {
+			return nil, err
+		}
+		if len(s.Columns) > 0 && len(row) != len(s.Columns) {
+			return nil, p.errorf(tok, "INSERT has %d columns but %d values", len(s.Columns), len(row))
+		}
+		s.Rows = append(s.Rows, row)
+		if !p.operator(",") {
+			return s, nil
+		}
+	}
+}
+
+// updateStmt parses, after UPDATE,
+//
+//	table SET col = expr, ... [WHERE cond]
+func (p *parser) updateStmt() (*Update, error) {
+	s := &Update{}
+	var err error
+	if s.Table, err = p.ident("table name"); err != nil {
+		return nil, err
+	}
+	if err := p.expectKeyword("SET"); err != nil {
+		return nil, err
+	}
+	for {
+		name, err := p.ident("column name")
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expectOperator("="); err != nil {
+			return nil, err
+		}
+		value, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		s.Set = append(s.Set, Assignment{Column: name, Value: value})
+		if !p.operator(",") {
+			break
+		}
+	}
+	if p.keyword("WHERE") {
+		if s.Where, err = p.expr(); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// deleteStmt parses, after DELETE,
+//
+//	FROM table [WHERE cond]
+func (p *parser) deleteStmt() (*Delete, error) {
+	if err := p.expectKeyword("FROM"); err != nil {
+		return nil, err
+	}
+	s := &Delete{}
+	var err error
+	if s.Table, err = p.ident("table name"); err != nil {
+		return nil, err
+	}
+	if p.keyword("WHERE") {
+		if s.Where, err = p.expr(); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// exprList parses expr, ...
+func (p *parser) exprList() ([]Node, error) {
+	var list []Node
+	for {
+		e, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, e)
+		if !p.operator(",") {
+			return list, nil
+		}
+	}
+}
+
+// expr parses an expression; OR binds loosest
+func (p *parser) expr() (Node, error) {
+	x, err := p.and()
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("OR") {
+		y, err := p.and()
+		if err != nil {
+			return nil, err
+		}
+		x = newBinary("OR", x, y)
+	}
+	return x, nil
+}
+
+func (p *parser) and() (Node, error) {
+	x, err := p.not()
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("AND") {
+		y, err := p.not()
+		if err != nil {
+			return nil, err
+		}
+		x = newBinary("AND", x, y)
+	}
+	return x, nil
+}
+
+func (p *parser) not() (Node, error) {
+	tok := p.peek()
+	if p.keyword("NOT") {
+		x, err := p.not()
+		if err != nil {
+			return nil, err
+		}
+		_, end := x.Span()
+		return &Unary{Pos{tok.start, end}, "NOT", x}, nil
+	}
+	return p.comparison()
+}
+
+// comparisonOps maps the comparison operators onto their xBase form
+var comparisonOps = map[string]string{
+	"=": "=", "==": "==", "<>": "<>", "!=": "<>", "#": "<>",
+	"<": "<", ">": ">", "<=": "<=", ">=": ">=", "$": "$",
+}
+
+func (p *parser) comparison() (Node, error) {
+	x, err := p.additive()
+	if err != nil {
+		return nil, err
+	}
+	start, _ := x.Span()
+
+	tok := p.peek()
+	if op, ok := comparisonOps[tok.text]; ok && tok.kind == tokOperator {
+		p.next()
+		y, err := p.additive()
+		if err != nil {
+			return nil, err
+		}
+		return newBinary(op, x, y), nil
+	}
+
+	// IS [NOT] NULL
+	if p.keyword("IS") {
+		not := p.keyword("NOT")
+		end := p.peek().end
+		if err := p.expectKeyword("NULL"); err != nil {
+			return nil, err
+		}
+		return &IsNull{Pos{start, end}, x, not}, nil
+	}
+
+	// [NOT] LIKE, BETWEEN, IN
+	not := false
+	if tok.kind == tokKeyword && tok.text == "NOT" {
+		if after := p.tokens[p.pos+1]; after.kind == tokKeyword && (after.text == "LIKE" || after.text == "BETWEEN" || after.text == "IN") {
+			p.next()
+			not = true
+		}
+	}
+	switch {
+	case p.keyword("LIKE"):
+		pattern, err := p.additive()
+		if err != nil {
+			return nil, err
+		}
+		_, end := pattern.Span()
+		return &Like{Pos{start, end}, x, pattern, not}, nil
+
+	case p.keyword("BETWEEN"):
+		lo, err := p.additive()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expectKeyword("AND"); err != nil {
+			return nil, err
+		}
+		hi, err := p.additive()
+		if err != nil {
+			return nil, err
+		}
+		_, end := hi.Span()
+		return &Between{Pos{start, end}, x, lo, hi, not}, nil
+
+	case p.keyword("IN"):
+		if err := p.expectOperator("("); err != nil {
+			return nil, err
+		}
+		if tok := p.peek(); tok.kind == tokKeyword && tok.text == "SELECT" {
+			return nil, p.errorf(tok, "subqueries are not supported")
+		}
+		list, err := p.exprList()
+		if err != nil {
+			return nil, err
+		}
+		end := p.peek().end
+		if err := p.expectOperator(")"); err != nil {
+			return nil, err
+		}
+		return &InList{Pos{start, end}, x, list, not}, nil
+	}
+	if not {
+		return nil, p.errorf(p.peek(), "expected LIKE, BETWEEN or IN after NOT")
+	}
+	return x, nil
+}
+
+// additive parses + and -, and || which joins strings as + does
+func (p *parser) additive() (Node, error) {
+	x, err := p.multiplicative()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		tok := p.peek()
+		if tok.kind != tokOperator || tok.text != "+" && tok.text != "-" && tok.text != "||" {
+			return x, nil
+		}
+		p.next()
+		y, err := p.multiplicative()
+		if err != nil {
+			return nil, err
+		}
+		op := tok.text
+		if op == "||" {
+			op = "+"
+		}
+		x = newBinary(op, x, y)
+	}
+}
+
+func (p *parser) multiplicative() (Node, error) {
+	x, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		tok := p.peek()
+		if tok.kind != tokOperator || tok.text != "*" && tok.text != "/" && tok.text != "%" {
+			return x, nil
+		}
+		p.next()
+		y, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		x = newBinary(tok.text, x, y)
+	}
+}
+
+func (p *parser) unary() (Node, error) {
+	tok := p.peek()
+	if tok.kind == tokOperator && (tok.text == "-" || tok.text == "+") {
+		p.next()
+		x, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		_, end := x.Span()
+		return &Unary{Pos{tok.start, end}, tok.text, x}, nil
+	}
+	return p.power()
+}
+
+func (p *parser) power() (Node, error) {
+	x, err := p.primary()
+	if err != nil {
+		return nil, err
+	}
+	if tok := p.peek(); tok.kind == tokOperator && (tok.text == "^" || tok.text == "**") {
+		p.next()
+		y, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return newBinary("^", x, y), nil
+	}
+	return x, nil
+}
+
+func (p *parser) primary() (Node, error) {
+	tok := p.next()
+	switch tok.kind {
+	case tokLiteral:
+		return &Literal{Pos{tok.start, tok.end}, tok.text}, nil
+
+	case tokParam:
+		p.params++
+		return &Param{Pos{tok.start, tok.end}, p.params - 1}, nil
+
+	case tokKeyword:
+		switch tok.text {
+		case "NULL":
+			return &Literal{Pos{tok.start, tok.end}, ".NULL."}, nil
+		case "TRUE":
+			return &Literal{Pos{tok.start, tok.end}, ".T."}, nil
+		case "FALSE":
+			return &Literal{Pos{tok.start, tok.end}, ".F."}, nil
+		case "LEFT", "RIGHT":
+			// The string functions share their names with joins
+			if p.peek().kind == tokOperator && p.peek().text == "(" {
+				return p.call(tok)
+			}
+		}
+
+	case tokIdent:
+		next := p.peek()
+		switch {
+		case next.kind == tokOperator && next.text == "(":
+			return p.call(tok)
+		case next.kind == tokOperator && (next.text == "." || next.text == "->"):
+			p.next()
+			name := p.next()
+			if name.kind != tokIdent {
+				return nil, p.errorf(name, "expected column name, found %q", p.text(name))
+			}
+			return &Column{Pos: Pos{tok.start, name.end}, Alias: tok.text, Name: name.text, Table: -1}, nil
+		}
+		return &Column{Pos: Pos{tok.start, tok.end}, Name: tok.text, Table: -1}, nil
+
+	case tokOperator:
+		if tok.text == "(" {
+			x, err := p.expr()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expectOperator(")"); err != nil {
+				return nil, err
+			}
+			return x, nil
+		}
+	}
+	return nil, p.errorf(tok, "unexpected %q", p.text(tok))
+}
+
+// call parses the arguments of a function call, name having been read
+func (p *parser) call(name token) (Node, error) {
+	p.next() // (
+	c := &Call{Name: strings.ToUpper(name.text)}
+	c.Start = name.start
+	switch {
+	case p.operator("*"):
+		c.Star = true
+	case p.keyword("DISTINCT"):
+		c.Distinct = true
+	}
+	if !c.Star && !(p.peek().kind == tokOperator && p.peek().text == ")") {
+		args, err := p.exprList()
+		if err != nil {
+			return nil, err
+		}
+		c.Args = args
+	}
+	c.End = p.peek().end
+	if err := p.expectOperator(")"); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func newBinary(op string, x, y Node) *Binary {
+	start, _ := x.Span()
+	_, end := y.Span()
+	return &Binary{Pos{start, end}, op, x, y}
+}
@@ -0,0 +1,414 @@
+// Package sqlparse parses the SQL dialect of Visual FoxPro shared by the
+// packages sql and sqldriver, and writes the expressions of a statement in
+// the xBase syntax of package expr, which evaluates them for both.
+//
+// The statements are
+//
+//	SELECT [DISTINCT] [TOP n] * | alias.* | expr [[AS] name], ...
+//	FROM table [[AS] alias] [[INNER | LEFT [OUTER]] JOIN table [[AS] alias] ON cond | , table ...]
+//	[WHERE cond] [GROUP BY expr, ...] [HAVING cond]
+//	[ORDER BY expr [ASC | DESC], ...] [LIMIT n [OFFSET m]] [INTO TABLE name]
+//	INSERT INTO table [(col, ...)] VALUES (expr, ...)[, (expr, ...)]
+//	UPDATE table SET col = expr, ... [WHERE cond]
+//	DELETE FROM table [WHERE cond]
+//
+// Besides the operators of package expr, expressions accept AND, OR and
+// NOT, [NOT] LIKE, [NOT] BETWEEN, [NOT] IN (list), IS [NOT] NULL, || for
+// joining strings, TRUE, FALSE and NULL, strings quoted as 'it”s', names
+// quoted with backquotes and ? placeholders.
+package sqlparse
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// =========================================================================
+// STATEMENTS
+// =========================================================================
+
+// Statement is a parsed statement: *Select, *Insert, *Update or *Delete.
+type Statement interface {
+	statement()
+}
+
+// Select is a SELECT statement.
+type Select struct {
+	Text     string // The statement, for error messages
+	Distinct bool
+	Top      int // 0 for every row
+	Items    []SelectItem
+	From     []FromItem
+	Where    Node
+	GroupBy  []Node
+	Having   Node
+	OrderBy  []OrderItem
+	Limit    Node   // nil for every row
+	Offset   Node   // nil for none
+	Into     string // Table to write the result to, empty for none
+}
+
+// SelectItem is an item of the select list.
+type SelectItem struct {
+	Expr  Node   // nil for * and alias.*
+	Star  string // Alias of alias.*, "*" for every table
+	Alias string // Name given with AS
+}
+
+// JoinKind is the way a table is joined to the ones before it.
+type JoinKind int
+
+// Joins
+const (
+	JoinCross JoinKind = iota // The first table, or one listed after a comma
+	JoinInner
+	JoinLeft
+)
+
+// FromItem is a table of the FROM clause.
+type FromItem struct {
+	Table string
+	Alias string
+	Join  JoinKind
+	On    Node
+}
+
+// OrderItem is an ORDER BY term.
+type OrderItem struct {
+	Expr Node
+	Desc bool
+}
+
+// Insert is an INSERT statement.
+type Insert struct {
+	Table   string
+	Columns []string // Empty for every column in table order
+	Rows    [][]Node
+}
+
+// Update is an UPDATE statement.
+type Update struct {
+	Table string
+	Set   []Assignment
+	Where Node
+}
+
+// Assignment is a SET term of an UPDATE.
+type Assignment struct {
+	Column string
+	Value  Node
+}
+
+// Delete is a DELETE statement.
+type Delete struct {
+	Table string
+	Where Node
+}
+
+func (*Select) statement() {}
+func (*Insert) statement() {}
+func (*Update) statement() {}
+func (*Delete) statement() {}
+
+// =========================================================================
+// EXPRESSIONS
+// =========================================================================
+
+// Node is a node of an expression.
+type Node interface {
+	// Span returns the offsets of the node in the statement text.
+	Span() (start, end int)
+}
+
+// Pos is the position of a node in the statement text.
+type Pos struct{ Start, End int }
+
+// Span returns the offsets of the node in the statement text.
+func (p Pos) Span() (int, int) { return p.Start, p.End }
+
+// Literal is a constant, in xBase syntax.
+type Literal struct {
+	Pos
+	Text string
+}
+
+// Param is a ? placeholder.
+type Param struct {
+	Pos
+	Index int // Zero-based position among the placeholders
+}
+
+// Column refers to a field of a table.
+type Column struct {
+	Pos
+	Alias string // As written, empty when not qualified
+	Name  string
+	Table int // Index of its table in the FROM clause, -1 until resolved
+}
+
+// Call calls a function or an aggregate.
+type Call struct {
+	Pos
+	Name     string // Upper case
+	Args     []Node
+	Star     bool // COUNT(*)
+	Distinct bool // COUNT(DISTINCT x) and the like
+}
+
+// Unary applies - + or NOT to an operand.
+type Unary struct {
+	Pos
+	Op string
+	X  Node
+}
+
+// Binary applies an arithmetic, comparison or logical operator.
+type Binary struct {
+	Pos
+	Op   string // AND and OR for the logical operators
+	X, Y Node
+}
+
+// Like is x [NOT] LIKE pattern, with % and _ as wildcards.
+type Like struct {
+	Pos
+	X, Pattern Node
+	Not        bool
+}
+
+// Between is x [NOT] BETWEEN lo AND hi.
+type Between struct {
+	Pos
+	X, Lo, Hi Node
+	Not       bool
+}
+
+// InList is x [NOT] IN (a, b, ...).
+type InList struct {
+	Pos
+	X    Node
+	List []Node
+	Not  bool
+}
+
+// IsNull is x IS [NOT] NULL.
+type IsNull struct {
+	Pos
+	X   Node
+	Not bool
+}
+
+// Walk calls visit for n and every node below it, stopping at nodes for
+// which visit returns false.
+func Walk(n Node, visit func(Node) bool) {
+	if n == nil || !visit(n) {
+		return
+	}
+	switch n := n.(type) {
+	case *Call:
+		for _, arg := range n.Args {
+			Walk(arg, visit)
+		}
+	case *Unary:
+		Walk(n.X, visit)
+	case *Binary:
+		Walk(n.X, visit)
+		Walk(n.Y, visit)
+	case *Like:
+		Walk(n.X, visit)
+		Walk(n.Pattern, visit)
+	case *Between:
+		Walk(n.X, visit)
+		Walk(n.Lo, visit)
+		Walk(n.Hi, visit)
+	case *InList:
+		Walk(n.X, visit)
+		for _, item := range n.List {
+			Walk(item, visit)
+		}
+	case *IsNull:
+		Walk(n.X, visit)
+	}
+}
+
+// Conjuncts splits a condition into the operands of its top-level ANDs.
+func Conjuncts(n Node) []Node {
+	if n == nil {
+		return nil
+	}
+	if b, ok := n.(*Binary); ok && b.Op == "AND" {
+		return append(Conjuncts(b.X), Conjuncts(b.Y)...)
+	}
+	return []Node{n}
+}
+
+// =========================================================================
+// XBASE
+// =========================================================================
+
+// Writer writes expressions in xBase syntax for package expr.
+type Writer struct {
+	// Params are the placeholder values as xBase constants, see Literals.
+	Params []string
+
+	// Column writes a column; nil writes its name, qualified by the alias
+	// as written.
+	Column func(c *Column) string
+
+	// Call may write a call in its place, returning false to have it
+	// written as a function call.
+	Call func(c *Call) (string, bool)
+}
+
+// Write returns an expression in xBase syntax. Like tests the value
+// without the blanks that pad it, with % and _ turned into the * and ?
+// of LIKE(), and the SQL functions LENGTH and COALESCE become LEN(RTRIM())
+// and nested NVL() calls.
+func (w *Writer) Write(n Node) string {
+	switch n := n.(type) {
+	case *Literal:
+		return n.Text
+	case *Param:
+		if n.Index < len(w.Params) {
+			return w.Params[n.Index]
+		}
+		return ".NULL."
+	case *Column:
+		if w.Column != nil {
+			return w.Column(n)
+		}
+		if n.Alias != "" {
+			return strings.ToUpper(n.Alias) + "->" + strings.ToUpper(n.Name)
+		}
+		return strings.ToUpper(n.Name)
+	case *Call:
+		if w.Call != nil {
+			if text, ok := w.Call(n); ok {
+				return text
+			}
+		}
+		args := make([]string, len(n.Args))
+		for i, arg := range n.Args {
+			args[i] = w.Write(arg)
+		}
+		switch {
+		case n.Name == "LENGTH" && len(args) == 1:
+			return "LEN(RTRIM(" + args[0] + "))"
+		case n.Name == "COALESCE" && len(args) > 0:
+			text := args[len(args)-1]
+			for i := len(args) - 2; i >= 0; i-- {
+				text = "NVL(" + args[i] + ", " + text + ")"
+			}
+			return text
+		}
+		return n.Name + "(" + strings.Join(args, ", ") + ")"
+	case *Unary:
+		op := n.Op
+		if op == "NOT" {
+			op = ".NOT. "
+		}
+		return "(" + op + w.Write(n.X) + ")"
+	case *Binary:
+		op := n.Op
+		switch op {
+		case "AND":
+			op = ".AND."
+		case "OR":
+			op = ".OR."
+		}
+		return "(" + w.Write(n.X) + " " + op + " " + w.Write(n.Y) + ")"
+	case *Like:
+		pattern := "CHRTRAN(" + w.Write(n.Pattern) + `, "%_", "*?")`
+		if lit, ok := n.Pattern.(*Literal); ok && strings.ContainsAny(lit.Text[:1], `"'[`) {
+			text := strings.NewReplacer("%", "*", "_", "?").Replace(lit.Text[1 : len(lit.Text)-1])
+			pattern = lit.Text[:1] + text + lit.Text[len(lit.Text)-1:]
+		}
+		return negate(n.Not, "LIKE("+pattern+", RTRIM("+w.Write(n.X)+"))")
+	case *Between:
+		return negate(n.Not, "BETWEEN("+w.Write(n.X)+", "+w.Write(n.Lo)+", "+w.Write(n.Hi)+")")
+	case *InList:
+		args := []string{w.Write(n.X)}
+		for _, item := range n.List {
+			args = append(args, w.Write(item))
+		}
+		return negate(n.Not, "INLIST("+strings.Join(args, ", ")+")")
+	case *IsNull:
+		return negate(n.Not, "ISNULL("+w.Write(n.X)+")")
+	}
+	return ""
+}
+
+func negate(not bool, s string) string {
+	if not {
+		return "(.NOT. " + s + ")"
+	}
+	return s
+}
+
+// Literals writes placeholder values as xBase constants for Writer.Params.
+func Literals(args []any) ([]string, error) {
+	params := make([]string, len(args))
+	for i, arg := range args {
+		text, err := LiteralText(arg)
+		if err != nil {
+			return nil, fmt.Errorf("parameter %d: %w", i+1, err)
+		}
+		params[i] = text
+	}
+	return params, nil
+}
+
+// LiteralText writes a Go value as an xBase constant.
+func LiteralText(v any) (string, error) {
+	switch v := v.(type) {
+	case nil:
+		return ".NULL.", nil
+	case string:
+		return Quote(v)
+	case []byte:
+		return Quote(string(v))
+	case bool:
+		if v {
+			return ".T.", nil
+		}
+		return ".F.", nil
+	case int:
+		return strconv.Itoa(v), nil
+	case int32:
+		return strconv.FormatInt(int64(v), 10), nil
+	case int64:
+		return strconv.FormatInt(v, 10), nil
+	case float32:
+		return LiteralText(float64(v))
+	case float64:
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return "", fmt.Errorf("cannot use %v", v)
+		}
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case time.Time:
+		if v.IsZero() {
+			return "{}", nil
+		}
+		if h, m, s := v.Clock(); h == 0 && m == 0 && s == 0 {
+			return v.Format("{^2006-01-02}"), nil
+		}
+		return v.Format("{^2006-01-02 15:04:05}"), nil
+	}
+	return "", fmt.Errorf("unsupported type %T", v)
+}
+
+// Quote delimits a string with a quote character it does not contain.
+func Quote(s string) (string, error) {
+	switch {
+	case !strings.Contains(s, `"`):
+		return `"` + s + `"`, nil
+	case !strings.Contains(s, "'"):
+		return "'" + s + "'", nil
+	case !strings.Contains(s, "]"):
+		return "[" + s + "]", nil
+	}
+	return "", fmt.Errorf("string %q contains every quote character", s)
+}
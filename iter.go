@@ -0,0 +1,250 @@
+package foxi
+
+import (
+	"iter"
+	"strings"
+)
+
+// Record is the record an iterator is positioned on. It reads the table's
+// current record, so it is only valid until the loop moves on, and the
+// loop body must not move the record pointer itself.
+type Record struct {
+	impl   foxiImpl
+	number int
+}
+
+// Number returns the record number.
+func (r Record) Number() int {
+	return r.number
+}
+
+// Deleted returns true if the record is marked for deletion.
+func (r Record) Deleted() bool {
+	return r.impl.Deleted()
+}
+
+// Field returns the field with the specified name (case-insensitive).
+func (r Record) Field(name string) Field {
+	return r.impl.FieldByName(name)
+}
+
+// Fields returns the field collection of the table.
+func (r Record) Fields() *Fields {
+	return r.impl.Fields()
+}
+
+// Scan copies the record into the struct dst points to (see Foxi.Scan).
+func (r Record) Scan(dst any) error {
+	return scanFields(r.impl.Fields(), dst)
+}
+
+// Records iterates over every record, deleted ones included, in the order
+// of the selected tag or in record order when no tag is selected. With a
+// filter set, it only visits the records matching the filter:
+//
+//	for rec, err := range f.Records() {
+//		if err != nil {
+//			return err
+//		}
+//		name, _ := rec.Field("NAME").AsString()
+//	}
+//
+// Breaking out of the loop leaves the table on the record reached.
+func (f *Foxi) Records() iter.Seq2[Record, error] {
+	return records(f.impl, false)
+}
+
+// RecordsReverse iterates over every record from the last to the first.
+func (f *Foxi) RecordsReverse() iter.Seq2[Record, error] {
+	return records(f.impl, true)
+}
+
+func records(impl foxiImpl, reverse bool) iter.Seq2[Record, error] {
+	return func(yield func(Record, error) bool) {
+		start, step := first, 1
+		if reverse {
+			start, step = last, -1
+		}
+		if err := start(impl); err != nil {
+			yield(Record{}, err)
+			return
+		}
+		walk(impl, step, nil, yield)
+	}
+}
+
+// walk yields the records from the current one on, moving step records at
+// a time, until the table runs out or inRange rejects a record. Records
+// that do not match the filter are passed over.
+func walk(impl foxiImpl, step int, inRange func() bool, yield func(Record, error) bool) {
+	if err := settle(impl, step); err != nil {
+		yield(Record{}, err)
+		return
+	}
+	for !impl.EOF() && !impl.BOF() {
+		if inRange != nil && !inRange() {
+			return
+		}
+		if err := relate(impl, step); err != nil {
+			yield(Record{}, err)
+			return
+		}
+		if !yield(Record{impl: impl, number: impl.Position()}, nil) {
+			return
+		}
+		if err := skip(impl, step); err != nil {
+			yield(Record{}, err)
+			return
+		}
+	}
+}
+
+// Tag ranges are walked with the tag selected. The start is found with a
+// seek and the records are visited with the table's skip, which follows
+// the tag; the keys met on the way are compared with the key found by
+// seeking the other end of the range.
+
+// rangeEnd is the upper end of a key range, in tag order
+type rangeEnd struct {
+	key       string
+	open      bool // No upper end, the range runs to the end of the tag
+	prefix    bool // Keys starting with key are in range
+	exclusive bool // key itself is the first key past the range
+}
+
+// contains reports whether a key is at or before the end of the range
+func (e rangeEnd) contains(tag Tag, key string) bool {
+	switch {
+	case e.open:
+		return true
+	case e.prefix:
+		if len(key) > len(e.key) {
+			key = key[:len(e.key)]
+		}
+	case e.exclusive:
+		return keyOrder(tag, key, e.key) < 0
+	}
+	return keyOrder(tag, key, e.key) <= 0
+}
+
+// keyOrder compares two keys in the order the tag walks them
+func keyOrder(tag Tag, a, b string) int {
+	cmp := strings.Compare(a, b)
+	if tag.IsDescending() {
+		return -cmp
+	}
+	return cmp
+}
+
+// seekRangeEnd seeks the upper value of a range, leaving the tag on the
+// first key that matches it or follows it. A character value matches the
+// keys it is a prefix of, as in Seek.
+func seekRangeEnd(impl foxiImpl, tag Tag, hi interface{}) (rangeEnd, error) {
+	if hi == nil {
+		return rangeEnd{open: true}, nil
+	}
+	result, err := tag.Seek(hi)
+	if err != nil {
+		return rangeEnd{}, err
+	}
+	switch result {
+	case SeekEOF:
+		return rangeEnd{open: true}, nil
+	case SeekAfter:
+		return rangeEnd{key: tag.CurrentKey(), exclusive: true}, nil
+	}
+	key := tag.CurrentKey()
+	if s, ok := hi.(string); ok {
+		// Keys hold strings in the table's code page
+		if s, err = impl.textCodec().encode(s); err != nil {
+			return rangeEnd{}, err
+		}
+		if strings.HasPrefix(key, s) {
+			return rangeEnd{key: s, prefix: true}, nil
+		}
+	}
+	return rangeEnd{key: key}, nil
+}
+
+// tagRange iterates over the keys from lo through end, forwards or
+// backwards. seekEnd positions the tag at the end of the range.
+func tagRange(impl foxiImpl, tag Tag, lo interface{}, seekEnd func() (rangeEnd, error), reverse bool) iter.Seq2[Record, error] {
+	return func(yield func(Record, error) bool) {
+		seekStart := func() (bool, error) {
+			if lo == nil {
+				err := tag.First()
+				return !impl.EOF(), err
+			}
+			result, err := tag.Seek(lo)
+			return result != SeekEOF, err
+		}
+
+		if !reverse {
+			end, err := seekEnd()
+			if err != nil {
+				yield(Record{}, err)
+				return
+			}
+			found, err := seekStart()
+			if err != nil || !found {
+				if err != nil {
+					yield(Record{}, err)
+				}
+				return
+			}
+			walk(impl, 1, func() bool { return end.contains(tag, tag.CurrentKey()) }, yield)
+			return
+		}
+
+		found, err := seekStart()
+		if err != nil || !found {
+			if err != nil {
+				yield(Record{}, err)
+			}
+			return
+		}
+		first := tag.CurrentKey()
+
+		// Move from where the end was found to the last key in range
+		end, err := seekEnd()
+		switch {
+		case err != nil:
+		case end.open:
+			err = tag.Last()
+		default:
+			if !end.exclusive {
+				for !impl.EOF() && end.contains(tag, tag.CurrentKey()) {
+					if err = impl.Skip(1); err != nil {
+						break
+					}
+				}
+			}
+			if err == nil {
+				err = impl.Skip(-1)
+			}
+		}
+		if err != nil {
+			yield(Record{}, err)
+			return
+		}
+		walk(impl, -1, func() bool { return keyOrder(tag, tag.CurrentKey(), first) >= 0 }, yield)
+	}
+}
+
+// rangeRecords implements Tag.Range and Tag.RangeReverse
+func rangeRecords(impl foxiImpl, tag Tag, lo, hi interface{}, reverse bool) iter.Seq2[Record, error] {
+	return tagRange(impl, tag, lo, func() (rangeEnd, error) {
+		return seekRangeEnd(impl, tag, hi)
+	}, reverse)
+}
+
+// prefixRecords implements Tag.Prefix and Tag.PrefixReverse
+func prefixRecords(impl foxiImpl, tag Tag, prefix string, reverse bool) iter.Seq2[Record, error] {
+	return tagRange(impl, tag, prefix, func() (rangeEnd, error) {
+		if _, err := tag.Seek(prefix); err != nil {
+			return rangeEnd{}, err
+		}
+		key, err := impl.textCodec().encode(prefix)
+		return rangeEnd{key: key, prefix: true}, err
+	}, reverse)
+}
@@ -0,0 +1,113 @@
+package foxi
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrLocked is returned when a lock is held by another user and could not
+// be obtained within the configured retries.
+var ErrLocked = errors.New("locked by another user")
+
+// Locks are Visual FoxPro compatible byte-range locks, so they are seen by
+// FoxPro and CodeBase applications sharing the table and theirs are seen
+// by foxi. A record lock conflicts with another user's lock on the same
+// record or on the file; the append lock stops other users from adding
+// records; the file lock conflicts with every other lock on the table.
+
+// LockKind is the kind of a lock held on a table.
+type LockKind int
+
+const (
+	RecordLock LockKind = iota + 1 // A single record
+	AppendLock                     // The append lock
+	FileLock                       // The whole table
+)
+
+// String returns the name of the kind
+func (k LockKind) String() string {
+	switch k {
+	case RecordLock:
+		return "record"
+	case AppendLock:
+		return "append"
+	case FileLock:
+		return "file"
+	}
+	return "unknown"
+}
+
+// Lock describes a lock held through a table of a Session.
+type Lock struct {
+	Table  string   // Alias of the table
+	Kind   LockKind // What the lock covers
+	Record int      // The record locked, 0 unless Kind is RecordLock
+}
+
+// LockRecord locks a record for this user. Locking a record that is
+// already locked by this table succeeds immediately.
+func (f *Foxi) LockRecord(recordNumber int) error {
+	return f.impl.LockRecord(recordNumber)
+}
+
+// LockAppend takes the append lock. Append takes it by itself for the
+// duration of each append; holding it keeps other users from appending
+// between several appends.
+func (f *Foxi) LockAppend() error {
+	return f.impl.LockAppend()
+}
+
+// LockFile locks the whole table.
+func (f *Foxi) LockFile() error {
+	return f.impl.LockFile()
+}
+
+// Unlock releases every lock held on the table.
+func (f *Foxi) Unlock() error {
+	return f.impl.Unlock()
+}
+
+// SetLockRetry sets how often a lock held by another user is tried before
+// ErrLocked is returned, and how long to wait between tries. An attempts
+// value of -1 retries until the lock is granted, which is the default,
+// with one second between tries. The tables of a Session share their lock
+// retries, so setting them for one sets them for the session.
+func (f *Foxi) SetLockRetry(attempts int, delay time.Duration) {
+	if f.session != nil {
+		f.session.SetLockRetry(attempts, delay)
+		return
+	}
+	f.impl.SetLockRetry(attempts, delay)
+}
+
+// MustLockRecord locks a record for this user.
+// Panics if the operation fails.
+func (f *Foxi) MustLockRecord(recordNumber int) {
+	if err := f.LockRecord(recordNumber); err != nil {
+		panic(err)
+	}
+}
+
+// MustLockAppend takes the append lock.
+// Panics if the operation fails.
+func (f *Foxi) MustLockAppend() {
+	if err := f.LockAppend(); err != nil {
+		panic(err)
+	}
+}
+
+// MustLockFile locks the whole table.
+// Panics if the operation fails.
+func (f *Foxi) MustLockFile() {
+	if err := f.LockFile(); err != nil {
+		panic(err)
+	}
+}
+
+// MustUnlock releases every lock held on the table.
+// Panics if the operation fails.
+func (f *Foxi) MustUnlock() {
+	if err := f.Unlock(); err != nil {
+		panic(err)
+	}
+}
@@ -0,0 +1,56 @@
+package foxi
+
+// ProgressFunc reports the progress of a long running operation as the
+// number of records processed so far out of total.
+type ProgressFunc func(done, total int)
+
+// Pack physically removes the records marked for deletion.
+//
+// The memo file is rewritten without the entries that are no longer
+// referenced and every tag of the open indexes is rebuilt. The table is
+// locked for the duration, and a pack cannot run inside a transaction.
+// progress may be nil; it is called as records are processed, once for
+// the record pass and once for the memo pass, with done restarting from
+// zero for each. After packing the table is positioned on the first record.
+func (f *Foxi) Pack(progress ProgressFunc) error {
+	f.impl.recordFilter().dropPlan()
+	return f.impl.Pack(progress)
+}
+
+// Zap removes every record from the table and empties the memo file and
+// the open indexes. It cannot run inside a transaction.
+func (f *Foxi) Zap() error {
+	f.impl.recordFilter().dropPlan()
+	return f.impl.Zap()
+}
+
+// PackMemo rewrites the memo file without the entries that are no longer
+// referenced by any record, such as those left behind when memos are
+// replaced with longer text. progress may be nil.
+func (f *Foxi) PackMemo(progress ProgressFunc) error {
+	return f.impl.PackMemo(progress)
+}
+
+// MustPack physically removes the records marked for deletion.
+// Panics if the operation fails.
+func (f *Foxi) MustPack(progress ProgressFunc) {
+	if err := f.Pack(progress); err != nil {
+		panic(err)
+	}
+}
+
+// MustZap removes every record from the table.
+// Panics if the operation fails.
+func (f *Foxi) MustZap() {
+	if err := f.Zap(); err != nil {
+		panic(err)
+	}
+}
+
+// MustPackMemo rewrites the memo file without unused entries.
+// Panics if the operation fails.
+func (f *Foxi) MustPackMemo(progress ProgressFunc) {
+	if err := f.PackMemo(progress); err != nil {
+		panic(err)
+	}
+}
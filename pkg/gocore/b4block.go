@@ -0,0 +1,600 @@
+// Package pkg - B4BLOCK functions for compact CDX index nodes
+// Direct translation of CodeBase Visual FoxPro compact index node handling
+package pkg
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math/bits"
+	"sort"
+)
+
+// Compact CDX node constants
+const (
+	b4NodeSize     = 512 // Size of every index node
+	b4NodeHeader   = 12  // attr, key count and sibling pointers
+	b4LeafInfo     = 24  // Start of the packed leaf entries
+	b4LeafData     = b4NodeSize - b4LeafInfo
+	b4BranchData   = b4NodeSize - b4NodeHeader
+	b4AttrBranch   = 0x00
+	b4AttrRoot     = 0x01
+	b4AttrLeaf     = 0x02
+	b4NoNode       = -1
+	b4MinInfoBytes = 3 // Visual FoxPro never packs entries tighter than 3 bytes
+)
+
+// b4leaf returns true if the block is a leaf node
+func b4leaf(block *B4Block) bool {
+	return block.NodeAttr&b4AttrLeaf != 0
+}
+
+// b4compare orders index entries by key, then by record number
+func b4compare(key1 []byte, rec1 int32, key2 []byte, rec2 int32) int {
+	if c := bytes.Compare(key1, key2); c != 0 {
+		return c
+	}
+	switch {
+	case rec1 < rec2:
+		return -1
+	case rec1 > rec2:
+		return 1
+	}
+	return 0
+}
+
+// t4trailChar returns the character removed from the end of keys when
+// they are compressed: blanks for character keys, binary zero otherwise
+func t4trailChar(tagFile *Tag4File) byte {
+	if tagFile.KeyType == Expr4TypeChar {
+		return ' '
+	}
+	return 0
+}
+
+// b4read reads and expands the node at the given file offset (mirrors b4alloc/i4readBlock)
+func b4read(tagFile *Tag4File, offset int32) (*B4Block, int) {
+	buf := make([]byte, b4NodeSize)
+	if File4Read(&tagFile.IndexFile.File, File4Long(offset), buf, b4NodeSize) != b4NodeSize {
+		return nil, ErrorRead
+	}
+	block := b4decode(buf, int(tagFile.Header.KeyLen), t4trailChar(tagFile))
+	if block == nil {
+		return nil, ErrorIndex
+	}
+	block.FileBlock = offset
+	return block, ErrorNone
+}
+
+// b4cacheNodes is the number of nodes the block cache of an index file
+// holds before it starts again
+const b4cacheNodes = 1024
+
+// t4read reads a node for a tag's navigation. The nodes of a clone's tags
+// are read through the block cache of the index file, which the clones of
+// a table share; the nodes in the cache must not be changed.
+func t4read(tag *Tag4, offset int32) (*B4Block, int) {
+	if tag.Index == nil || tag.Index.Data == nil || !tag.Index.Data.clone {
+		return b4read(tag.TagFile, offset)
+	}
+
+	indexFile := tag.TagFile.IndexFile
+	indexFile.cacheMutex.Lock()
+	block := indexFile.cache[offset]
+	indexFile.cacheMutex.Unlock()
+	if block != nil {
+		return block, ErrorNone
+	}
+
+	block, err := b4read(tag.TagFile, offset)
+	if err != ErrorNone {
+		return nil, err
+	}
+	indexFile.cacheMutex.Lock()
+	if indexFile.cache == nil || len(indexFile.cache) >= b4cacheNodes {
+		indexFile.cache = make(map[int32]*B4Block)
+	}
+	indexFile.cache[offset] = block
+	indexFile.cacheMutex.Unlock()
+	return block, ErrorNone
+}
+
+// i4cacheClear empties the block cache of an index file
+func i4cacheClear(indexFile *Index4File) {
+	indexFile.cacheMutex.Lock()
+	indexFile.cache = nil
+	indexFile.cacheMutex.Unlock()
+}
+
+// b4decode expands a raw node
+func b4decode(buf []byte, keyLen int, trailChar byte) *B4Block {
+	block := &B4Block{
+		NodeAttr:  int16(binary.LittleEndian.Uint16(buf[0:2])),
+		LeftNode:  int32(binary.LittleEndian.Uint32(buf[4:8])),
+		RightNode: int32(binary.LittleEndian.Uint32(buf[8:12])),
+	}
+	numKeys := int(int16(binary.LittleEndian.Uint16(buf[2:4])))
+	if numKeys < 0 || keyLen <= 0 {
+		return nil
+	}
+	block.Keys = make([]B4Key, numKeys)
+
+	if !b4leaf(block) {
+		entryLen := keyLen + 8
+		if numKeys*entryLen > b4BranchData {
+			return nil
+		}
+		for i := range block.Keys {
+			entry := buf[b4NodeHeader+i*entryLen:]
+			block.Keys[i] = B4Key{
+				Key:     append([]byte(nil), entry[:keyLen]...),
+				RecNo:   int32(binary.BigEndian.Uint32(entry[keyLen:])),
+				Pointer: int32(binary.BigEndian.Uint32(entry[keyLen+4:])),
+			}
+		}
+		return block
+	}
+
+	recMask := uint64(binary.LittleEndian.Uint32(buf[14:18]))
+	dupMask := uint64(buf[18])
+	trailMask := uint64(buf[19])
+	recBits, dupBits := uint(buf[20]), uint(buf[21])
+	infoLen := int(buf[23])
+	if infoLen < 1 || infoLen > 8 || numKeys*infoLen > b4LeafData {
+		return nil
+	}
+
+	keyPos := b4NodeSize
+	var prev []byte
+	for i := range block.Keys {
+		var info [8]byte
+		copy(info[:], buf[b4LeafInfo+i*infoLen:b4LeafInfo+(i+1)*infoLen])
+		value := binary.LittleEndian.Uint64(info[:])
+		dup := int((value >> recBits) & dupMask)
+		trail := int((value >> (recBits + dupBits)) & trailMask)
+		stored := keyLen - dup - trail
+		if stored < 0 || dup > len(prev) || keyPos-stored < b4LeafInfo+numKeys*infoLen {
+			return nil
+		}
+		keyPos -= stored
+
+		key := make([]byte, keyLen)
+		copy(key, prev[:dup])
+		copy(key[dup:], buf[keyPos:keyPos+stored])
+		for j := keyLen - trail; j < keyLen; j++ {
+			key[j] = trailChar
+		}
+		block.Keys[i] = B4Key{Key: key, RecNo: int32(value & recMask)}
+		prev = key
+	}
+	return block
+}
+
+// b4leafLayout works out the entry packing for a leaf holding the given
+// keys: the number of bits used for the duplicate and trail counts, the
+// record number bits and the entry size in bytes
+func b4leafLayout(keys []B4Key, keyLen int) (countBits, recBits, infoLen int) {
+	maxRec := int32(0)
+	for _, key := range keys {
+		maxRec = max(maxRec, key.RecNo)
+	}
+	countBits = bits.Len(uint(keyLen))
+	infoLen = max(b4MinInfoBytes, (bits.Len32(uint32(maxRec))+2*countBits+7)/8)
+	recBits = infoLen*8 - 2*countBits
+	return countBits, recBits, infoLen
+}
+
+// b4leafCompress returns the duplicate and trail counts for key given the
+// key before it in the node
+func b4leafCompress(key, prev []byte, trailChar byte) (dup, trail int) {
+	for trail < len(key) && key[len(key)-1-trail] == trailChar {
+		trail++
+	}
+	for dup < len(prev) && dup < len(key)-trail && key[dup] == prev[dup] {
+		dup++
+	}
+	return dup, trail
+}
+
+// b4leafSpace returns the bytes still free in a leaf holding keys, negative
+// if they do not fit in a single node
+func b4leafSpace(keys []B4Key, keyLen int, trailChar byte) int {
+	_, _, infoLen := b4leafLayout(keys, keyLen)
+	free := b4LeafData - len(keys)*infoLen
+	var prev []byte
+	for _, key := range keys {
+		dup, trail := b4leafCompress(key.Key, prev, trailChar)
+		free -= keyLen - dup - trail
+		prev = key.Key
+	}
+	return free
+}
+
+// b4encode packs a node into its on-disk form (mirrors b4flush)
+//
+// Returns the raw node, nil if the keys do not fit in a single node.
+func b4encode(block *B4Block, keyLen int, trailChar byte) []byte {
+	buf := make([]byte, b4NodeSize)
+	binary.LittleEndian.PutUint16(buf[0:2], uint16(block.NodeAttr))
+	binary.LittleEndian.PutUint16(buf[2:4], uint16(len(block.Keys)))
+	binary.LittleEndian.PutUint32(buf[4:8], uint32(block.LeftNode))
+	binary.LittleEndian.PutUint32(buf[8:12], uint32(block.RightNode))
+
+	if !b4leaf(block) {
+		entryLen := keyLen + 8
+		if len(block.Keys)*entryLen > b4BranchData {
+			return nil
+		}
+		for i, key := range block.Keys {
+			entry := buf[b4NodeHeader+i*entryLen:]
+			copy(entry[:keyLen], key.Key)
+			binary.BigEndian.PutUint32(entry[keyLen:], uint32(key.RecNo))
+			binary.BigEndian.PutUint32(entry[keyLen+4:], uint32(key.Pointer))
+		}
+		return buf
+	}
+
+	free := b4leafSpace(block.Keys, keyLen, trailChar)
+	if free < 0 {
+		return nil
+	}
+	countBits, recBits, infoLen := b4leafLayout(block.Keys, keyLen)
+	recMask := uint64(1)<<uint(min(recBits, 32)) - 1
+	countMask := uint64(1)<<uint(countBits) - 1
+
+	binary.LittleEndian.PutUint16(buf[12:14], uint16(free))
+	binary.LittleEndian.PutUint32(buf[14:18], uint32(recMask))
+	buf[18] = byte(countMask)
+	buf[19] = byte(countMask)
+	buf[20] = byte(recBits)
+	buf[21] = byte(countBits)
+	buf[22] = byte(countBits)
+	buf[23] = byte(infoLen)
+
+	keyPos := b4NodeSize
+	var prev []byte
+	for i, key := range block.Keys {
+		dup, trail := b4leafCompress(key.Key, prev, trailChar)
+		stored := key.Key[dup : keyLen-trail]
+		keyPos -= len(stored)
+		copy(buf[keyPos:], stored)
+
+		value := uint64(key.RecNo) | uint64(dup)<<uint(recBits) | uint64(trail)<<uint(recBits+countBits)
+		var info [8]byte
+		binary.LittleEndian.PutUint64(info[:], value)
+		copy(buf[b4LeafInfo+i*infoLen:], info[:infoLen])
+		prev = key.Key
+	}
+	return buf
+}
+
+// b4write writes a node back to the index file
+func b4write(tagFile *Tag4File, block *B4Block) int {
+	buf := b4encode(block, int(tagFile.Header.KeyLen), t4trailChar(tagFile))
+	if buf == nil {
+		return ErrorIndex
+	}
+	tagFile.changes++
+	i4cacheClear(tagFile.IndexFile)
+	return File4Write(&tagFile.IndexFile.File, File4Long(block.FileBlock), buf, b4NodeSize)
+}
+
+// i4extend reserves space for numNodes new nodes at the end of the index
+// file and returns the offset of the first one
+func i4extend(indexFile *Index4File, numNodes int) int32 {
+	length := File4Length(&indexFile.File)
+	if rem := length % b4NodeSize; rem != 0 {
+		length += b4NodeSize - rem
+	}
+	if numNodes > 0 {
+		File4Write(&indexFile.File, length+File4Long(numNodes*b4NodeSize-1), []byte{0}, 1)
+	}
+	return int32(length)
+}
+
+// t4build writes a complete tree holding keys, which must already be
+// sorted, and makes it the tag's root (mirrors the sort4/t4reindex output
+// stage). Nodes are appended to the index file.
+func t4build(tagFile *Tag4File, keys []B4Key) int {
+	keyLen := int(tagFile.Header.KeyLen)
+	trailChar := t4trailChar(tagFile)
+
+	// Split the keys into leaves, filling each node as far as it goes
+	var groups [][]B4Key
+	for start := 0; start < len(keys) || len(groups) == 0; {
+		end := start
+		for end < len(keys) && b4leafSpace(keys[start:end+1], keyLen, trailChar) >= 0 {
+			end++
+		}
+		if end == start && start < len(keys) {
+			return setError(tagFile.CodeBase, ErrorIndex)
+		}
+		groups = append(groups, keys[start:end])
+		start = end
+	}
+
+	attr := int16(b4AttrLeaf)
+	for {
+		first := i4extend(tagFile.IndexFile, len(groups))
+		if len(groups) == 1 {
+			attr |= b4AttrRoot
+		}
+
+		parents := make([]B4Key, 0, len(groups))
+		for i, group := range groups {
+			block := &B4Block{
+				FileBlock: first + int32(i*b4NodeSize),
+				NodeAttr:  attr,
+				LeftNode:  b4NoNode,
+				RightNode: b4NoNode,
+				Keys:      group,
+			}
+			if i > 0 {
+				block.LeftNode = block.FileBlock - b4NodeSize
+			}
+			if i < len(groups)-1 {
+				block.RightNode = block.FileBlock + b4NodeSize
+			}
+			if err := b4write(tagFile, block); err != ErrorNone {
+				return setError(tagFile.CodeBase, err)
+			}
+			if len(group) > 0 {
+				last := group[len(group)-1]
+				parents = append(parents, B4Key{Key: last.Key, RecNo: last.RecNo, Pointer: block.FileBlock})
+			}
+		}
+
+		if len(groups) == 1 {
+			tagFile.Header.Root = first
+			return t4writeHeader(tagFile)
+		}
+
+		// Next level up: branch nodes hold the last key of each child
+		perNode := b4BranchData / (keyLen + 8)
+		groups = groups[:0]
+		for start := 0; start < len(parents); start += perNode {
+			groups = append(groups, parents[start:min(start+perNode, len(parents))])
+		}
+		attr = b4AttrBranch
+	}
+}
+
+// b4pathEntry is a node on the way from the root of a tag to a leaf and
+// the position taken within it
+type b4pathEntry struct {
+	block *B4Block
+	pos   int
+}
+
+// t4path reads the nodes from the root down to the leaf where key/recNo
+// belongs. A key beyond the last entry follows the last child, so the
+// leaf position is where the entry is or would be inserted.
+func t4path(tagFile *Tag4File, key []byte, recNo int32) ([]b4pathEntry, int) {
+	var path []b4pathEntry
+	offset := tagFile.Header.Root
+	for depth := 0; depth <= 32; depth++ {
+		block, err := b4read(tagFile, offset)
+		if err != ErrorNone {
+			return nil, err
+		}
+		pos := sort.Search(len(block.Keys), func(i int) bool {
+			return b4compare(block.Keys[i].Key, block.Keys[i].RecNo, key, recNo) >= 0
+		})
+		if b4leaf(block) {
+			return append(path, b4pathEntry{block, pos}), ErrorNone
+		}
+		if len(block.Keys) == 0 {
+			return nil, ErrorIndex
+		}
+		pos = min(pos, len(block.Keys)-1)
+		path = append(path, b4pathEntry{block, pos})
+		offset = block.Keys[pos].Pointer
+	}
+	return nil, ErrorIndex
+}
+
+// t4keyExists reports whether any entry of a tag has the given key
+func t4keyExists(tagFile *Tag4File, key []byte) (bool, int) {
+	path, err := t4path(tagFile, key, 0)
+	if err != ErrorNone {
+		return false, err
+	}
+	leaf := path[len(path)-1]
+	block, pos := leaf.block, leaf.pos
+	if pos == len(block.Keys) {
+		if block.RightNode == b4NoNode || block.RightNode == 0 {
+			return false, ErrorNone
+		}
+		if block, err = b4read(tagFile, block.RightNode); err != ErrorNone {
+			return false, err
+		}
+		pos = 0
+	}
+	return pos < len(block.Keys) && bytes.Equal(block.Keys[pos].Key, key), ErrorNone
+}
+
+// t4addKey inserts an entry into a tag (mirrors tfile4add)
+func t4addKey(tagFile *Tag4File, key []byte, recNo int32) int {
+	path, err := t4path(tagFile, key, recNo)
+	if err != ErrorNone {
+		return err
+	}
+	leaf := &path[len(path)-1]
+	keys := leaf.block.Keys
+	keys = append(keys, B4Key{})
+	copy(keys[leaf.pos+1:], keys[leaf.pos:])
+	keys[leaf.pos] = B4Key{Key: append([]byte(nil), key...), RecNo: recNo}
+	leaf.block.Keys = keys
+	return t4storePath(tagFile, path)
+}
+
+// t4removeKey removes an entry from a tag (mirrors tfile4remove) and
+// reports whether it was there. An entry that is not in the tag is not an
+// error, as unique tags hold only the first record of each key.
+func t4removeKey(tagFile *Tag4File, key []byte, recNo int32) (bool, int) {
+	path, err := t4path(tagFile, key, recNo)
+	if err != ErrorNone {
+		return false, err
+	}
+	leaf := path[len(path)-1]
+	keys := leaf.block.Keys
+	if leaf.pos == len(keys) || b4compare(keys[leaf.pos].Key, keys[leaf.pos].RecNo, key, recNo) != 0 {
+		return false, ErrorNone
+	}
+	leaf.block.Keys = append(keys[:leaf.pos], keys[leaf.pos+1:]...)
+	return true, t4storePath(tagFile, path)
+}
+
+// t4storePath writes back the nodes of a path whose leaf was changed,
+// working up towards the root. A node that overflows is split in two, an
+// emptied node is taken out of the tree, and each parent entry is kept
+// equal to the last entry of its child. Nodes taken out of the tree are
+// left unused until the index is rebuilt.
+func t4storePath(tagFile *Tag4File, path []b4pathEntry) int {
+	keyLen := int(tagFile.Header.KeyLen)
+	trailChar := t4trailChar(tagFile)
+
+	for level := len(path) - 1; level >= 0; level-- {
+		block := path[level].block
+		root := level == 0
+
+		var right *B4Block
+		switch {
+		case len(block.Keys) == 0 && !root:
+			if err := b4unlink(tagFile, block); err != ErrorNone {
+				return err
+			}
+			parent := &path[level-1]
+			parent.block.Keys = append(parent.block.Keys[:parent.pos], parent.block.Keys[parent.pos+1:]...)
+			continue
+		case len(block.Keys) == 0:
+			// An emptied root becomes an empty leaf
+			block.NodeAttr = b4AttrRoot | b4AttrLeaf
+		case b4encode(block, keyLen, trailChar) == nil:
+			var err int
+			if right, err = b4split(tagFile, block); err != ErrorNone {
+				return err
+			}
+		}
+		if err := b4write(tagFile, block); err != ErrorNone {
+			return err
+		}
+
+		if root {
+			if right != nil {
+				return t4newRoot(tagFile, block, right)
+			}
+			return ErrorNone
+		}
+
+		parent := &path[level-1]
+		entry := &parent.block.Keys[parent.pos]
+		last := block.Keys[len(block.Keys)-1]
+		changed := right != nil || entry.RecNo != last.RecNo || !bytes.Equal(entry.Key, last.Key)
+		entry.Key, entry.RecNo = last.Key, last.RecNo
+		if right != nil {
+			last = right.Keys[len(right.Keys)-1]
+			keys := append(parent.block.Keys, B4Key{})
+			copy(keys[parent.pos+2:], keys[parent.pos+1:])
+			keys[parent.pos+1] = B4Key{Key: last.Key, RecNo: last.RecNo, Pointer: right.FileBlock}
+			parent.block.Keys = keys
+		}
+		if !changed {
+			return ErrorNone
+		}
+	}
+	return ErrorNone
+}
+
+// b4split moves the upper half of an overflowing node into a new node to
+// its right and writes the new node (mirrors b4split). The node itself is
+// left to the caller to write.
+func b4split(tagFile *Tag4File, block *B4Block) (*B4Block, int) {
+	keyLen := int(tagFile.Header.KeyLen)
+	trailChar := t4trailChar(tagFile)
+	keys := block.Keys
+
+	mid := len(keys) / 2
+	if b4leaf(block) {
+		for mid > 1 && b4leafSpace(keys[:mid], keyLen, trailChar) < 0 {
+			mid--
+		}
+		for mid < len(keys)-1 && b4leafSpace(keys[mid:], keyLen, trailChar) < 0 {
+			mid++
+		}
+	}
+
+	block.NodeAttr &^= b4AttrRoot
+	right := &B4Block{
+		FileBlock: i4extend(tagFile.IndexFile, 1),
+		NodeAttr:  block.NodeAttr,
+		LeftNode:  block.FileBlock,
+		RightNode: block.RightNode,
+		Keys:      append([]B4Key(nil), keys[mid:]...),
+	}
+	block.Keys = keys[:mid]
+	block.RightNode = right.FileBlock
+
+	if right.RightNode != b4NoNode && right.RightNode != 0 {
+		neighbour, err := b4read(tagFile, right.RightNode)
+		if err != ErrorNone {
+			return nil, err
+		}
+		neighbour.LeftNode = right.FileBlock
+		if err := b4write(tagFile, neighbour); err != ErrorNone {
+			return nil, err
+		}
+	}
+	if err := b4write(tagFile, right); err != ErrorNone {
+		return nil, err
+	}
+	return right, ErrorNone
+}
+
+// b4unlink takes an emptied node out of the sibling chain of its level
+func b4unlink(tagFile *Tag4File, block *B4Block) int {
+	if block.LeftNode != b4NoNode && block.LeftNode != 0 {
+		left, err := b4read(tagFile, block.LeftNode)
+		if err != ErrorNone {
+			return err
+		}
+		left.RightNode = block.RightNode
+		if err := b4write(tagFile, left); err != ErrorNone {
+			return err
+		}
+	}
+	if block.RightNode != b4NoNode && block.RightNode != 0 {
+		right, err := b4read(tagFile, block.RightNode)
+		if err != ErrorNone {
+			return err
+		}
+		right.LeftNode = block.LeftNode
+		if err := b4write(tagFile, right); err != ErrorNone {
+			return err
+		}
+	}
+	tagFile.changes++
+	i4cacheClear(tagFile.IndexFile)
+	return ErrorNone
+}
+
+// t4newRoot adds a level to a tag whose root was split
+func t4newRoot(tagFile *Tag4File, left, right *B4Block) int {
+	lastLeft := left.Keys[len(left.Keys)-1]
+	lastRight := right.Keys[len(right.Keys)-1]
+	root := &B4Block{
+		FileBlock: i4extend(tagFile.IndexFile, 1),
+		NodeAttr:  b4AttrRoot,
+		LeftNode:  b4NoNode,
+		RightNode: b4NoNode,
+		Keys: []B4Key{
+			{Key: lastLeft.Key, RecNo: lastLeft.RecNo, Pointer: left.FileBlock},
+			{Key: lastRight.Key, RecNo: lastRight.RecNo, Pointer: right.FileBlock},
+		},
+	}
+	if err := b4write(tagFile, root); err != ErrorNone {
+		return err
+	}
+	tagFile.Header.Root = root.FileBlock
+	tagFile.RootWrite = true
+	return ErrorNone
+}
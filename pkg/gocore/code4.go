@@ -3,6 +3,7 @@
 package pkg
 
 import (
+	"io"
 	"os"
 	"path/filepath"
 	"strings"
@@ -18,6 +19,7 @@ import (
 // - Visual FoxPro 3.0 compatibility
 // - CDX index extension as default
 // - Windows ANSI code page (1252)
+// - Locks retried once a second until granted
 //
 // Returns ErrorNone on success, ErrorMemory if cb is nil.
 func Code4Init(cb *Code4) int {
@@ -36,12 +38,17 @@ func Code4Init(cb *Code4) int {
 	cb.Log = 0
 	cb.MemExpandData = 512
 	cb.MemSizeBuffer = 8192
+	cb.MemSizeMemo = 64
 	cb.MemSizeSortBuffer = 8192
 	cb.MemSizeSortPool = 8192
 	cb.MemStartData = 2048
 	cb.Safety = 1
 	cb.Timeout = 0
 	cb.Compatibility = 30 // VFP 3.0 compatibility
+	code4lockDefaults.Lock()
+	cb.LockAttempts = code4lockDefaults.attempts
+	cb.LockDelay = code4lockDefaults.delay
+	code4lockDefaults.Unlock()
 
 	// Internal initialization
 	cb.Initialized = true
@@ -116,6 +123,7 @@ func Code4Close(cb *Code4) int {
 		data := data4FromLink(current)
 		if data != nil && data.DataFile != nil {
 			// Close the file safely
+			d4journalClose(data.DataFile)
 			File4Close(&data.DataFile.File)
 
 			// Close memo file if open
@@ -352,8 +360,10 @@ func File4Close(f4 *File4) int {
 
 	// Add safety check to prevent segmentation fault
 	if f4.Handle != nil {
-		// Clean up any locks associated with this file
+		// Clean up any locks associated with this file, and take the
+		// locks of its other handles again once it is closed
 		CleanupLocks(f4)
+		defer lock4restore(file4identity(f4))
 
 		// Only close if the handle is actually open
 		if f4.FileCreated {
@@ -479,8 +489,9 @@ func File4Open(f4 *File4, cb *Code4, fileName string, accessMode int) int {
 // File4Read reads data from a file at the specified position.
 // This mirrors the file4read function from the CodeBase library.
 //
-// The function seeks to the specified position and reads up to len bytes
-// into the provided buffer.
+// The function reads up to len bytes at the specified position into the
+// provided buffer. It does not move the file offset, so several cursors
+// may read the same file at once.
 //
 // Parameters:
 //   - f4: File4 structure representing the open file
@@ -494,14 +505,9 @@ func File4Read(f4 *File4, pos File4Long, buffer []byte, len uint32) uint32 {
 		return 0
 	}
 
-	// Seek to position
-	if _, err := f4.Handle.Seek(pos, 0); err != nil {
-		return 0
-	}
-
-	// Read data
-	n, err := f4.Handle.Read(buffer[:len])
-	if err != nil {
+	// Read data, a short read at end of file returns what was read
+	n, err := f4.Handle.ReadAt(buffer[:len], pos)
+	if err != nil && err != io.EOF {
 		return 0
 	}
 
@@ -615,6 +621,41 @@ func File4Truncate(f4 *File4, size File4Long) int {
 	return ErrorNone
 }
 
+// code4CodePages pairs table header code page marks with code page numbers
+var code4CodePages = []struct {
+	mark     byte
+	codePage int
+}{
+	{0x01, 437}, {0x02, 850}, {0x03, 1252}, {0x04, 10000}, {0x64, 852},
+	{0x65, 866}, {0x66, 865}, {0x67, 861}, {0x68, 895}, {0x69, 620},
+	{0x6A, 737}, {0x6B, 857}, {0x78, 950}, {0x79, 949}, {0x7A, 936},
+	{0x7B, 932}, {0x7C, 874}, {0x7D, 1255}, {0x7E, 1256}, {0x96, 10007},
+	{0x97, 10029}, {0x98, 10006}, {0xC8, 1250}, {0xC9, 1251}, {0xCA, 1254},
+	{0xCB, 1253}, {0xCC, 1257},
+}
+
+// Code4CodePageMark returns the table header code page mark for a code
+// page number, 0 if the code page has no mark
+func Code4CodePageMark(codePage int) byte {
+	for _, entry := range code4CodePages {
+		if entry.codePage == codePage {
+			return entry.mark
+		}
+	}
+	return 0
+}
+
+// Code4CodePageNumber returns the code page number for a table header
+// code page mark, 0 if the mark is unknown
+func Code4CodePageNumber(mark byte) int {
+	for _, entry := range code4CodePages {
+		if entry.mark == mark {
+			return entry.codePage
+		}
+	}
+	return 0
+}
+
 // Helper function to construct file paths with extension
 func constructPath(baseName, extension string) string {
 	if filepath.Ext(baseName) == "" {
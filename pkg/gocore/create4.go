@@ -10,294 +10,325 @@ import (
 	"time"
 )
 
+// Field creation type codes that select the binary (NOCPTRANS) variant of
+// a field type (r4charBin and r4memoBin in CodeBase)
+const (
+	FieldTypeCharBin = 'Z' // Character field stored without code page translation
+	FieldTypeMemoBin = 'X' // Memo field stored without code page translation
+)
+
+// Visual FoxPro table header constants
+const (
+	dbfVersionVFP        = 0x30 // Visual FoxPro table
+	dbfVersionVFPVarchar = 0x32 // Visual FoxPro table with varchar/varbinary/blob fields
+	dbfFlagProduction    = 0x01 // Table has a production CDX
+	dbfFlagMemo          = 0x02 // Table has a memo file
+	dbfBacklinkLen       = 263  // Database container backlink after the field list
+	memo4HeaderLen       = 512  // Size of the FPT file header
+	memo4DefaultBlock    = 64   // Visual FoxPro default memo block size
+)
+
 // D4Create creates a new Visual FoxPro DBF database file with specified field structure.
 // This mirrors the d4create function from the CodeBase library.
 //
 // The function performs the following operations:
-// - Validates the field definitions and database parameters
+// - Validates the field definitions and works out field sizes and flags
 // - Creates a new DBF file (removes existing if safety is off)
-// - Writes the DBF header with current date and field count
-// - Writes field descriptors for all specified fields
-// - Initializes blank record templates with appropriate defaults
-// - Sets up Data4 structure for immediate use
+// - Writes the header, field descriptors and database container backlink
+// - Adds the _NullFlags system field when fields are nullable or variable length
+// - Creates an empty FPT memo file when the table has memo fields
+// - Opens the new table
 //
-// Field types supported: Character ('C'), Numeric ('N'), Float ('F'),
-// Date ('D'), Logical ('L'), Memo ('M'), Integer ('I'), Currency ('Y'), DateTime ('T')
+// Field types supported: Character ('C', binary 'Z'), Varchar ('V'),
+// Varbinary ('Q'), Numeric ('N'), Float ('F'), Date ('D'), DateTime ('T'),
+// Logical ('L'), Integer ('I'), Currency ('Y'), Double ('B'),
+// Memo ('M', binary 'X'), General ('G') and Blob ('W'). Fixed size types
+// ignore the requested length.
 //
 // Parameters:
 //   - cb: CODE4 context for database operations and settings
 //   - fileName: Path for the new DBF file (.dbf extension added if missing)
 //   - fieldInfo: Array of Field4Info structures defining the database schema
 //
-// Returns a pointer to the initialized Data4 structure ready for use,
+// Returns a pointer to the opened Data4 structure ready for use,
 // nil on failure (invalid parameters, file creation errors, etc.).
-//
-//nolint:gocyclo // TODO: refactor to reduce complexity by extracting DBF creation steps
 func D4Create(cb *Code4, fileName string, fieldInfo []Field4Info) *Data4 {
 	if cb == nil || fileName == "" || len(fieldInfo) == 0 {
 		return nil
 	}
 
-	// Construct full file path
 	fullPath := fileName
 	if filepath.Ext(fullPath) == "" {
 		fullPath += ".dbf"
 	}
 
-	// Check if file already exists
-	if _, err := os.Stat(fullPath); err == nil {
+	fields, err := d4createFields(fieldInfo)
+	if err != ErrorNone {
+		setError(cb, err)
+		return nil
+	}
+
+	if _, statErr := os.Stat(fullPath); statErr == nil {
 		if cb.Safety != 0 {
-			return nil // File exists and safety is on
+			setError(cb, ErrorCreate)
+			return nil
 		}
-		// Remove existing file
 		os.Remove(fullPath)
 	}
 
-	// Create the data structures
-	data := &Data4{
-		CodeBase: cb,
-		Alias:    strings.ToUpper(filepath.Base(strings.TrimSuffix(fullPath, filepath.Ext(fullPath)))),
+	// A production index left over from an earlier table would no longer
+	// match the new structure
+	base := strings.TrimSuffix(fullPath, filepath.Ext(fullPath))
+	for _, ext := range []string{".cdx", ".CDX"} {
+		os.Remove(base + ext)
 	}
 
-	dataFile := &Data4File{
-		CodeBase: cb,
+	hasMemo := false
+	for _, field := range fields {
+		if field.Memo != nil {
+			hasMemo = true
+		}
 	}
 
-	// Create and initialize the file
-	err := File4Create(&dataFile.File, cb, fullPath, 1)
-	if err != ErrorNone {
+	if err = d4createFile(cb, fullPath, fields); err != ErrorNone {
+		os.Remove(fullPath)
+		setError(cb, err)
 		return nil
 	}
 
-	// Calculate header and record layout
-	numFields := int16(len(fieldInfo))
-	headerLen := uint16(32 + (numFields * 32) + 1) // Base header + field descriptors + terminator
-	recordLen := uint16(1)                         // Start with delete flag byte
-
-	// Validate fields and calculate record length
-	fields := make([]*Field4, 0, len(fieldInfo))
-	for i, info := range fieldInfo {
-		// Validate field info
-		if info.Name == "" || len(info.Name) > 10 {
-			File4Close(&dataFile.File)
+	if hasMemo {
+		if err = memo4Create(cb, memo4FileName(fullPath)); err != ErrorNone {
+			os.Remove(fullPath)
+			setError(cb, err)
 			return nil
 		}
+	}
+
+	return D4Open(cb, fullPath)
+}
 
-		// Validate field type
-		switch info.Type {
-		case FieldTypeChar, FieldTypeNumeric, FieldTypeDate, FieldTypeLogical,
-			FieldTypeMemo, FieldTypeFloat, FieldTypeInteger, FieldTypeCurrency, FieldTypeDateTime:
-			// Valid types
+// d4createFields validates the field definitions and lays out the record
+func d4createFields(fieldInfo []Field4Info) ([]*Field4, int) {
+	fields := make([]*Field4, 0, len(fieldInfo)+1)
+	seen := make(map[string]bool, len(fieldInfo))
+	nullBits := 0
+	offset := uint32(1) // Delete flag
+
+	for _, info := range fieldInfo {
+		name := strings.ToUpper(strings.TrimSpace(info.Name))
+		if name == "" || len(name) > MaxFieldName || seen[name] || strings.HasPrefix(name, "_NULLFLAGS") {
+			return nil, ErrorData
+		}
+		seen[name] = true
+
+		field := &Field4{Dec: info.Dec}
+		copy(field.Name[:], name)
+
+		fieldType := info.Type
+		switch fieldType {
+		case FieldTypeCharBin:
+			fieldType = FieldTypeChar
+			field.Flags |= FieldFlagBinary
+		case FieldTypeMemoBin:
+			fieldType = FieldTypeMemo
+			field.Flags |= FieldFlagBinary
+		}
+		field.Type = int16(fieldType)
+
+		switch fieldType {
+		case FieldTypeChar, FieldTypeVarChar, FieldTypeVarBin:
+			if info.Length < 1 || info.Length > 254 {
+				return nil, ErrorData
+			}
+			field.Length, field.Dec = info.Length, 0
+		case FieldTypeNumeric, FieldTypeFloat:
+			if info.Length < 1 || info.Length > 20 || (info.Dec > 0 && info.Dec > info.Length-2) {
+				return nil, ErrorData
+			}
+			field.Length = info.Length
+		case FieldTypeDate, FieldTypeDateTime:
+			field.Length, field.Dec = 8, 0
+		case FieldTypeCurrency:
+			field.Length, field.Dec = 8, 4
+		case FieldTypeDouble:
+			if info.Dec > 18 {
+				return nil, ErrorData
+			}
+			field.Length = 8
+		case FieldTypeInteger:
+			field.Length, field.Dec = 4, 0
+		case FieldTypeLogical:
+			field.Length, field.Dec = 1, 0
+		case FieldTypeMemo, FieldTypeGeneral, FieldTypeBlob:
+			field.Length, field.Dec = 4, 0
+			field.Memo = &F4Memo{Field: field}
 		default:
-			File4Close(&dataFile.File)
-			return nil
+			return nil, ErrorData
 		}
 
-		// Create field structure
-		field := &Field4{
-			Type:   int16(info.Type),
-			Length: info.Length,
-			Dec:    info.Dec,
-			Offset: uint32(recordLen),
-			Data:   data,
+		// General, varbinary and blob fields are always binary
+		switch fieldType {
+		case FieldTypeGeneral, FieldTypeVarBin, FieldTypeBlob:
+			field.Flags |= FieldFlagBinary
+		}
+		if fieldType == FieldTypeVarChar || fieldType == FieldTypeVarBin {
+			nullBits++
+		}
+		if info.Nulls != 0 {
+			field.Flags |= FieldFlagNullable
+			nullBits++
 		}
 
-		// Set field name
-		copy(field.Name[:], strings.ToUpper(info.Name))
-
-		// Add field length to record length
-		recordLen += info.Length
-
+		field.Offset = offset
+		offset += uint32(field.Length)
 		fields = append(fields, field)
+	}
 
-		// Set field number for array access
-		if i == 0 {
-			dataFile.Fields = make([]*Field4, len(fieldInfo))
+	if nullBits > 0 {
+		nullFlags := &Field4{
+			Type:   int16(FieldTypeSystem),
+			Length: uint16((nullBits + 7) / 8),
+			Flags:  FieldFlagSystem | FieldFlagNullable,
+			Offset: offset,
 		}
-		dataFile.Fields[i] = field
+		copy(nullFlags.Name[:], "_NullFlags")
+		offset += uint32(nullFlags.Length)
+		fields = append(fields, nullFlags)
 	}
 
-	// Initialize header
-	now := time.Now()
-	dataFile.Header = DbfHeader{
-		Version:   0x03, // DBase III compatible
-		Year:      byte(now.Year() - 1900),
-		Month:     byte(now.Month()),
-		Day:       byte(now.Day()),
-		NumRecs:   0,
-		HeaderLen: headerLen,
-		RecordLen: recordLen,
-	}
-
-	dataFile.NumFields = numFields
-	dataFile.RecordLen = recordLen
-
-	// Write the header to file
-	err = writeDbfHeaderComplete(dataFile)
-	if err != ErrorNone {
-		File4Close(&dataFile.File)
-		return nil
-	}
-
-	// Write field descriptors
-	err = writeFieldDescriptors(dataFile)
-	if err != ErrorNone {
-		File4Close(&dataFile.File)
-		return nil
+	if offset > 65500 {
+		return nil, ErrorData
 	}
+	return fields, ErrorNone
+}
 
-	// Write header terminator
-	terminator := []byte{0x0D}
-	File4Write(&dataFile.File, int64(32+(numFields*32)), terminator, 1)
-
-	// Set up data structure
-	data.DataFile = dataFile
-	data.Fields = fields
-	data.RecordBlank = make([]byte, recordLen)
-	data.Record = make([]byte, recordLen)
-	data.RecordOld = make([]byte, recordLen)
-
-	// Initialize blank record (all spaces except delete flag)
-	data.RecordBlank[0] = ' ' // Not deleted
-	for i := 1; i < int(recordLen); i++ {
-		data.RecordBlank[i] = ' '
+// d4createFile writes the header and field descriptors of a new table
+func d4createFile(cb *Code4, fullPath string, fields []*Field4) int {
+	var file File4
+	if err := File4Create(&file, cb, fullPath, 1); err != ErrorNone {
+		return ErrorCreate
 	}
+	defer File4Close(&file)
 
-	// Set logical fields to 'F' in blank record
+	headerLen := 32 + 32*len(fields) + 1 + dbfBacklinkLen
+	recordLen := 1
+	version := byte(dbfVersionVFP)
+	flags := byte(0)
 	for _, field := range fields {
-		if field.Type == int16(FieldTypeLogical) {
-			data.RecordBlank[field.Offset] = 'F'
+		recordLen += int(field.Length)
+		switch rune(field.Type) {
+		case FieldTypeVarChar, FieldTypeVarBin, FieldTypeBlob:
+			version = dbfVersionVFPVarchar
+		}
+		if field.Memo != nil {
+			flags |= dbfFlagMemo
 		}
 	}
 
-	copy(data.Record, data.RecordBlank)
-	copy(data.RecordOld, data.RecordBlank)
-
-	// Initialize navigation state
-	data.recNo = 0
-	data.atBof = true
-	data.atEOF = true
-
-	// Add to CodeBase's data file list
-	list4Add(&cb.DataFileList, &data.Link)
-
-	dataFile.IsValid = true
-	return data
-}
-
-// writeDbfHeaderComplete writes the complete DBF header to file
-func writeDbfHeaderComplete(dataFile *Data4File) int {
-	if dataFile == nil {
-		return ErrorMemory
+	// Header, descriptors, terminator, backlink and the end of file marker
+	buf := make([]byte, headerLen+1)
+	now := time.Now()
+	buf[0] = version
+	buf[1] = byte(now.Year() - 1900)
+	buf[2] = byte(now.Month())
+	buf[3] = byte(now.Day())
+	binary.LittleEndian.PutUint16(buf[8:10], uint16(headerLen))
+	binary.LittleEndian.PutUint16(buf[10:12], uint16(recordLen))
+	buf[28] = flags
+	buf[29] = Code4CodePageMark(cb.CodePage)
+
+	for i, field := range fields {
+		descriptor := buf[32+32*i : 64+32*i]
+		copy(descriptor[0:11], getFieldName(field))
+		descriptor[11] = byte(field.Type)
+		binary.LittleEndian.PutUint32(descriptor[12:16], field.Offset)
+		descriptor[16] = byte(field.Length)
+		descriptor[17] = byte(field.Dec)
+		descriptor[18] = field.Flags
 	}
+	buf[32+32*len(fields)] = 0x0D
+	buf[headerLen] = 0x1A
 
-	header := &dataFile.Header
-	headerBuf := make([]byte, 32)
-
-	// Pack header fields
-	headerBuf[0] = header.Version
-	headerBuf[1] = header.Year
-	headerBuf[2] = header.Month
-	headerBuf[3] = header.Day
-	binary.LittleEndian.PutUint32(headerBuf[4:8], uint32(header.NumRecs))
-	binary.LittleEndian.PutUint16(headerBuf[8:10], header.HeaderLen)
-	binary.LittleEndian.PutUint16(headerBuf[10:12], header.RecordLen)
-
-	// Reserved area (bytes 12-31)
-	copy(headerBuf[12:32], header.Reserved[:])
-
-	// Write header to file
-	bytesWritten := File4Write(&dataFile.File, 0, headerBuf, 32)
-	if bytesWritten != 32 {
+	if err := File4Write(&file, 0, buf, uint32(len(buf))); err != ErrorNone {
 		return ErrorWrite
 	}
-
 	return ErrorNone
 }
 
-// writeFieldDescriptors writes field descriptor array to file
-func writeFieldDescriptors(dataFile *Data4File) int {
-	if dataFile == nil || dataFile.Fields == nil {
-		return ErrorMemory
+// memo4Create creates an empty FPT memo file (mirrors memo4fileCreate)
+func memo4Create(cb *Code4, fileName string) int {
+	blockSize := int(cb.MemSizeMemo)
+	if blockSize <= 0 {
+		blockSize = memo4DefaultBlock
 	}
 
-	for i, field := range dataFile.Fields {
-		if field == nil {
-			continue
-		}
-
-		// Create field descriptor (32 bytes)
-		descriptor := make([]byte, 32)
-
-		// Field name (11 bytes, null-terminated)
-		fieldName := strings.TrimSpace(string(field.Name[:]))
-		if len(fieldName) > 10 {
-			fieldName = fieldName[:10]
-		}
-		copy(descriptor[0:11], fieldName)
-
-		// Field type (1 byte)
-		descriptor[11] = byte(field.Type)
-
-		// Field offset (4 bytes) - not used in DBF format, set to 0
-		binary.LittleEndian.PutUint32(descriptor[12:16], 0)
-
-		// Field length (1 byte)
-		descriptor[16] = byte(field.Length)
-
-		// Decimal places (1 byte)
-		descriptor[17] = byte(field.Dec)
-
-		// Reserved bytes (14 bytes)
-		for j := 18; j < 32; j++ {
-			descriptor[j] = 0
-		}
-
-		// Write descriptor to file
-		offset := int64(32 + (i * 32))
-		bytesWritten := File4Write(&dataFile.File, offset, descriptor, 32)
-		if bytesWritten != 32 {
-			return ErrorWrite
-		}
+	var file File4
+	if err := File4Create(&file, cb, fileName, 1); err != ErrorNone {
+		return ErrorCreate
 	}
+	defer File4Close(&file)
 
+	header := make([]byte, memo4HeaderLen)
+	binary.BigEndian.PutUint32(header[0:4], uint32((memo4HeaderLen+blockSize-1)/blockSize))
+	binary.BigEndian.PutUint16(header[6:8], uint16(blockSize))
+	if err := File4Write(&file, 0, header, memo4HeaderLen); err != ErrorNone {
+		return ErrorWrite
+	}
 	return ErrorNone
 }
 
 // D4CreateData creates a new database with extended options (mirrors d4createData)
+//
+// A tag with a tag file is copied to the production index of the new table
+// and linked to it.
+//
+// Deprecated: use D4CreateTags, which takes the descriptions of any number
+// of tags.
 func D4CreateData(cb *Code4, fileName string, fieldInfo []Field4Info, tag *Tag4) *Data4 {
-	// Create the basic database first
-	data := D4Create(cb, fileName, fieldInfo)
-	if data == nil {
-		return nil
+	if tag == nil || tag.TagFile == nil {
+		return D4CreateTags(cb, fileName, fieldInfo, nil)
 	}
 
-	// If tag is specified, create an associated index
-	if tag != nil && tag.TagFile != nil {
-		// Create associated CDX index
-		tagInfo := []Tag4Info{
-			{
-				Name:       string(tag.TagFile.Alias[:]),
-				Expression: tag.TagFile.ExprSource,
-				Filter:     tag.TagFile.FilterSource,
-				Unique:     0,
-				Descending: 0,
-			},
-		}
+	info := Tag4Info{
+		Name:       T4Alias(tag),
+		Expression: tag.TagFile.ExprSource,
+		Filter:     tag.TagFile.FilterSource,
+	}
+	if T4Unique(tag) {
+		info.Unique = 1
+	}
+	if T4Descending(tag) {
+		info.Descending = 1
+	}
 
-		if tag.TagFile.Header.TypeCode&CDXTypeUnique != 0 {
-			tagInfo[0].Unique = 1
-		}
-		if tag.TagFile.Header.Descending != 0 {
-			tagInfo[0].Descending = 1
+	data := D4CreateTags(cb, fileName, fieldInfo, []Tag4Info{info})
+	if data != nil {
+		if first := list4First(&data.Indexes); first != nil {
+			tag.Index = indexFromLink(first)
 		}
+	}
+	return data
+}
 
-		// Create the index
-		index := I4Create(data, "", tagInfo)
-		if index != nil {
-			// Link the tag to the created index
-			tag.Index = index
-		}
+// D4CreateTags creates a new database together with its production index
+// (mirrors the tag info argument of d4create).
+//
+// Returns the opened table with the production index open and no tag
+// selected, nil if either the table or the index cannot be created.
+func D4CreateTags(cb *Code4, fileName string, fieldInfo []Field4Info, tagInfo []Tag4Info) *Data4 {
+	data := D4Create(cb, fileName, fieldInfo)
+	if data == nil || len(tagInfo) == 0 {
+		return data
+	}
+
+	if I4Create(data, "", tagInfo) == nil {
+		err := cb.ErrorCode
+		dbfPath := D4FileName(data)
+		D4Close(data)
+		os.Remove(dbfPath)
+		os.Remove(memo4FileName(dbfPath))
+		setError(cb, err)
+		return nil
 	}
 
 	return data
@@ -5,6 +5,7 @@ package pkg
 import (
 	"bytes"
 	"encoding/binary"
+	"os"
 	"path/filepath"
 	"strings"
 )
@@ -14,6 +15,7 @@ import (
 //
 // The function performs the following operations:
 // - Opens the specified DBF file with .dbf extension if not provided
+// - Rolls back a transaction left unfinished in the table's journal
 // - Parses the DBF header and validates the file format
 // - Reads field definitions and creates field structures
 // - Automatically opens associated memo files (.FPT) if memo fields exist
@@ -54,6 +56,14 @@ func D4Open(cb *Code4, fileName string) *Data4 {
 		return nil
 	}
 
+	// Undo a transaction left unfinished by a crash
+	recovered, err := d4journalRecover(dataFile)
+	if err != ErrorNone {
+		File4Close(&dataFile.File)
+		setError(cb, err)
+		return nil
+	}
+
 	// Read and parse DBF header
 	err = parseDbfHeader(dataFile)
 	if err != ErrorNone {
@@ -73,6 +83,10 @@ func D4Open(cb *Code4, fileName string) *Data4 {
 	// Set up DATA4 structure
 	data.DataFile = dataFile
 	data.Fields = dataFile.Fields
+	data.CodePage = Code4CodePageNumber(dataFile.Header.CodePage)
+	for _, field := range data.Fields {
+		field.Data = data
+	}
 
 	// Allocate record buffers
 	recordLen := int(dataFile.RecordLen)
@@ -97,6 +111,11 @@ func D4Open(cb *Code4, fileName string) *Data4 {
 		autoOpenProductionIndex(data)
 	}
 
+	// Index entries may refer to records the recovery undid
+	if recovered {
+		d4reindexOpen(data)
+	}
+
 	// Set alias from filename (base name without extension)
 	alias := extractAlias(fileName)
 	data.Alias = alias
@@ -131,9 +150,13 @@ func parseDbfHeader(dataFile *Data4File) int {
 
 	// Copy reserved area
 	copy(header.Reserved[:], headerBuf[12:28])
+	header.Flags = headerBuf[28]
+	header.CodePage = headerBuf[29]
 
 	// Validate header
-	if header.Version != 0x03 && header.Version != 0x30 && header.Version != 0x43 && header.Version != 0xF5 {
+	switch header.Version {
+	case 0x03, 0x30, 0x31, 0x32, 0x43, 0xF5:
+	default:
 		return ErrorData // Unsupported DBF version
 	}
 
@@ -196,11 +219,18 @@ func parseFieldDefs(dataFile *Data4File) int {
 		field.Offset = offset
 		offset += uint32(field.Length)
 
+		// Parse Fox 3.0+ field flags
+		field.Flags = fieldBuf[18]
+		if field.Flags&FieldFlagNullable != 0 {
+			field.Null = 1
+		}
+		if field.Flags&FieldFlagBinary != 0 {
+			field.Binary = 1
+		}
+
 		// Handle special field types
 		switch fieldType {
-		case FieldTypeChar, FieldTypeNumeric, FieldTypeFloat, FieldTypeDate, FieldTypeLogical, FieldTypeInteger, FieldTypeCurrency:
-			// Standard field types - no special handling needed
-		case FieldTypeMemo:
+		case FieldTypeMemo, FieldTypeGeneral, FieldTypePicture, FieldTypeBlob:
 			// Initialize memo field handling
 			field.Memo = &F4Memo{
 				Field:     field,
@@ -211,9 +241,11 @@ func parseFieldDefs(dataFile *Data4File) int {
 				MaxLength: 0,
 			}
 			// Note: Memo file will be opened on first access if needed
-		default:
-			// Unknown field type - treat as character for now
-			field.Type = int16(FieldTypeChar)
+		case FieldTypeSystem:
+			// _NullFlags holds the null and varchar length bits
+			if strings.EqualFold(getFieldName(field), "_NullFlags") {
+				dataFile.NullFlags = field
+			}
 		}
 
 		fields = append(fields, field)
@@ -228,9 +260,31 @@ func parseFieldDefs(dataFile *Data4File) int {
 	dataFile.Fields = fields
 	dataFile.NumFields = int16(len(fields))
 
+	assignNullBits(dataFile)
+
 	return ErrorNone
 }
 
+// assignNullBits numbers the _NullFlags bits the way Visual FoxPro does:
+// in field order, a varchar/varbinary column takes a length bit and a
+// nullable column takes a null bit (length bit first when both apply).
+func assignNullBits(dataFile *Data4File) {
+	bit := uint16(0)
+	for _, field := range dataFile.Fields {
+		if field == dataFile.NullFlags {
+			continue
+		}
+		if field.Type == int16(FieldTypeVarChar) || field.Type == int16(FieldTypeVarBin) {
+			field.LenBit = bit
+			bit++
+		}
+		if field.Null != 0 {
+			field.NullBit = bit
+			bit++
+		}
+	}
+}
+
 // initBlankRecord initializes the blank record template
 func initBlankRecord(data *Data4) {
 	recordLen := int(data.DataFile.RecordLen)
@@ -243,15 +297,40 @@ func initBlankRecord(data *Data4) {
 	// Set delete flag to not deleted
 	data.RecordBlank[0] = ' ' // ' ' = not deleted, '*' = deleted
 
-	// Initialize logical fields to false ('F')
+	// Initialize logical fields to false ('F') and binary fields to zeros
 	for _, field := range data.Fields {
-		if field.Type == int16(FieldTypeLogical) {
-			offset := int(field.Offset)
-			if offset < recordLen {
-				data.RecordBlank[offset] = 'F' // False
+		offset := int(field.Offset)
+		end := offset + int(field.Length)
+		if end > recordLen {
+			continue
+		}
+		switch {
+		case field.Type == int16(FieldTypeLogical):
+			data.RecordBlank[offset] = 'F' // False
+		case f4IsBinaryStorage(field):
+			for i := offset; i < end; i++ {
+				data.RecordBlank[i] = 0
 			}
 		}
 	}
+
+	// Blank varchar and varbinary values are empty
+	nullFlags := data.DataFile.NullFlags
+	if nullFlags == nil {
+		return
+	}
+	for _, field := range data.Fields {
+		if field.Type != int16(FieldTypeVarChar) && field.Type != int16(FieldTypeVarBin) {
+			continue
+		}
+		pos := int(nullFlags.Offset) + int(field.LenBit/8)
+		end := int(field.Offset) + int(field.Length)
+		if field.Length == 0 || pos >= recordLen || end > recordLen {
+			continue
+		}
+		data.RecordBlank[pos] |= 1 << (field.LenBit % 8)
+		data.RecordBlank[end-1] = 0
+	}
 }
 
 // D4Close closes a database file and releases all associated resources.
@@ -268,14 +347,28 @@ func D4Close(data *Data4) int {
 		return ErrorMemory
 	}
 
+	// A clone lets go of the files it shares without closing them
+	if data.clone {
+		data.DataFile, data.TagSelected = nil, nil
+		data.Indexes = List4{}
+		return ErrorNone
+	}
+
+	// Write back any pending record changes
+	d4updateRecord(data)
+
+	// Close index files
+	d4closeIndexes(data)
+
 	// Close memo file if open
 	if data.DataFile != nil && data.DataFile.MemoFile != nil {
 		File4Close(&data.DataFile.MemoFile.File)
 		data.DataFile.MemoFile = nil
 	}
 
-	// Close database file
+	// Close database file, an unfinished transaction stays journalled
 	if data.DataFile != nil {
+		d4journalClose(data.DataFile)
 		File4Close(&data.DataFile.File)
 	}
 
@@ -287,6 +380,106 @@ func D4Close(data *Data4) int {
 	return ErrorNone
 }
 
+// D4Clone makes a second handle on an open database for reading it from
+// another goroutine. There is no CodeBase equivalent.
+//
+// The clone shares the files, field descriptions and indexes of data but
+// has a record buffer, a record pointer and tag positions of its own, and
+// a CODE4 of its own for its error codes, so clones of one database can be
+// moved and read at the same time. The index nodes read through the clones
+// are kept in a block cache shared by them. The tag selected for data is
+// selected for the clone, which starts before its first record.
+//
+// Clones only read: their records must not be changed, and the database
+// must not be written to while a clone reads it. D4Close releases a clone
+// without closing the shared files; clones must be released before data is
+// closed.
+//
+// Returns the clone, nil if data is nil or not open.
+func D4Clone(data *Data4) *Data4 {
+	if data == nil || data.DataFile == nil {
+		return nil
+	}
+
+	codeBase := &Code4{}
+	if data.CodeBase != nil {
+		*codeBase = *data.CodeBase
+		codeBase.ErrorCode = ErrorNone
+		codeBase.FieldBuffer = nil
+		codeBase.DataFileList = List4{}
+		codeBase.TransactionLevel = 0
+		codeBase.TransactionLog = nil
+	}
+
+	recordLen := int(data.DataFile.RecordLen)
+	clone := &Data4{
+		Alias:       data.Alias,
+		CodeBase:    codeBase,
+		CodePage:    data.CodePage,
+		DataFile:    data.DataFile,
+		Record:      make([]byte, recordLen),
+		RecordOld:   make([]byte, recordLen),
+		RecordBlank: append([]byte(nil), data.RecordBlank...),
+		ClientID:    data.ClientID,
+		Text:        data.Text,
+		atBof:       true,
+		clone:       true,
+	}
+
+	// The field descriptions are copied to read the clone's record
+	clone.Fields = make([]*Field4, len(data.Fields))
+	for i, field := range data.Fields {
+		copied := *field
+		copied.Data = clone
+		if field.Memo != nil {
+			copied.Memo = &F4Memo{Field: &copied}
+		}
+		clone.Fields[i] = &copied
+	}
+
+	// The indexes get tags of their own over the shared tag files
+	first := list4First(&data.Indexes)
+	for current := first; current != nil; {
+		index := indexFromLink(current)
+		cloneIndex := &Index4{
+			Data:       clone,
+			CodeBase:   codeBase,
+			IndexFile:  index.IndexFile,
+			AccessName: index.AccessName,
+			IsValid:    index.IsValid,
+		}
+		firstTag := list4First(&index.Tags)
+		for link := firstTag; link != nil; {
+			tag := tagFromLink(link)
+			cloneTag := &Tag4{
+				Index:     cloneIndex,
+				TagFile:   tag.TagFile,
+				ErrUnique: tag.ErrUnique,
+				IsValid:   tag.IsValid,
+			}
+			if tag.TagFile.Expr != nil {
+				cloneTag.expr = Expr4Parse(clone, tag.TagFile.ExprSource)
+			}
+			if tag == data.TagSelected {
+				clone.TagSelected = cloneTag
+			}
+			list4Add(&cloneIndex.Tags, &cloneTag.Link)
+			link = list4Next(&index.Tags, link)
+			if link == firstTag {
+				break
+			}
+		}
+		list4Add(&clone.Indexes, &cloneIndex.Link)
+		current = list4Next(&data.Indexes, current)
+		if current == first {
+			break
+		}
+	}
+
+	setError(codeBase, ErrorNone)
+	return clone
+}
+
 // D4Alias returns the alias name for the database.
 // This mirrors the d4alias function from the CodeBase library.
 //
@@ -460,6 +653,11 @@ func D4Go(data *Data4, recordNum int32) int {
 		return ErrorMemory
 	}
 
+	// Write back pending changes before leaving the record
+	if err := d4updateRecord(data); err != ErrorNone {
+		return err
+	}
+
 	if recordNum < 1 || recordNum > data.DataFile.Header.NumRecs {
 		return ErrorData
 	}
@@ -480,10 +678,14 @@ func D4Go(data *Data4, recordNum int32) int {
 		return ErrorRead
 	}
 
+	// Pending memo assignments belonged to the previous record
+	d4memoReset(data)
+
 	// Update position state
+	data.appending = false
 	data.recNo = recordNum
 	data.atEOF = false // We're on a valid record, not at EOF
-	data.atBof = (recordNum == 1)
+	data.atBof = false
 
 	return ErrorNone
 }
@@ -492,7 +694,8 @@ func D4Go(data *Data4, recordNum int32) int {
 // This mirrors the d4top function from the CodeBase library.
 //
 // If the database is empty, the function sets EOF and BOF flags
-// appropriately without generating an error.
+// appropriately without generating an error. When a tag is selected the
+// first record in tag order is used.
 //
 // Returns ErrorNone on success, ErrorMemory if data is nil.
 func D4Top(data *Data4) int {
@@ -500,6 +703,10 @@ func D4Top(data *Data4) int {
 		return ErrorMemory
 	}
 
+	if data.TagSelected != nil {
+		return d4tagTop(data, data.TagSelected, true)
+	}
+
 	if data.DataFile.Header.NumRecs == 0 {
 		data.atEOF = true
 		data.atBof = true
@@ -514,7 +721,8 @@ func D4Top(data *Data4) int {
 // This mirrors the d4bottom function from the CodeBase library.
 //
 // If the database is empty, the function sets EOF and BOF flags
-// appropriately without generating an error.
+// appropriately without generating an error. When a tag is selected the
+// last record in tag order is used.
 //
 // Returns ErrorNone on success, ErrorMemory if data is nil.
 func D4Bottom(data *Data4) int {
@@ -522,6 +730,10 @@ func D4Bottom(data *Data4) int {
 		return ErrorMemory
 	}
 
+	if data.TagSelected != nil {
+		return d4tagTop(data, data.TagSelected, false)
+	}
+
 	if data.DataFile.Header.NumRecs == 0 {
 		data.atEOF = true
 		data.atBof = true
@@ -537,7 +749,8 @@ func D4Bottom(data *Data4) int {
 //
 // Positive values move forward, negative values move backward.
 // If the movement would go beyond the file boundaries, the appropriate
-// EOF or BOF condition is set without generating an error.
+// EOF or BOF condition is set without generating an error. When a tag is
+// selected the records are visited in tag order.
 //
 // Parameters:
 //   - data: Data4 structure representing the database
@@ -549,6 +762,14 @@ func D4Skip(data *Data4, numRecs int32) int {
 		return ErrorMemory
 	}
 
+	if data.TagSelected != nil {
+		return d4tagSkip(data, data.TagSelected, numRecs)
+	}
+
+	if err := d4updateRecord(data); err != ErrorNone {
+		return err
+	}
+
 	newRecNo := data.recNo + numRecs
 
 	// Handle boundary conditions
@@ -672,7 +893,7 @@ func extractAlias(fileName string) string {
 // hasMemoFields checks if the database contains any memo fields
 func hasMemoFields(dataFile *Data4File) bool {
 	for _, field := range dataFile.Fields {
-		if field.Type == int16(FieldTypeMemo) {
+		if field.Memo != nil {
 			return true
 		}
 	}
@@ -681,8 +902,12 @@ func hasMemoFields(dataFile *Data4File) bool {
 
 // openMemoFile opens the associated memo file for a database
 func openMemoFile(dataFile *Data4File, dbfFileName string) int {
-	// Construct memo file name by changing extension to .fpt
-	memoFileName := constructPath(dbfFileName, "fpt")
+	// Memo file name is the data file name with an .fpt extension,
+	// matching the case of the data file extension where possible
+	memoFileName := memo4FileName(dataFile.File.Name)
+	if memoFileName == "" {
+		memoFileName = memo4FileName(dbfFileName)
+	}
 
 	// Create memo file structure
 	memoFile := &Memo4File{
@@ -712,3 +937,24 @@ func openMemoFile(dataFile *Data4File, dbfFileName string) int {
 	dataFile.MemoFile = memoFile
 	return ErrorNone
 }
+
+// memo4FileName derives the .fpt name for a data file, preferring an
+// existing file in either letter case
+func memo4FileName(dbfFileName string) string {
+	if dbfFileName == "" {
+		return ""
+	}
+	ext := filepath.Ext(dbfFileName)
+	base := strings.TrimSuffix(dbfFileName, ext)
+
+	candidates := []string{base + ".fpt", base + ".FPT"}
+	if ext != "" && ext == strings.ToUpper(ext) {
+		candidates[0], candidates[1] = candidates[1], candidates[0]
+	}
+	for _, candidate := range candidates {
+		if _, err := os.Stat(candidate); err == nil {
+			return candidate
+		}
+	}
+	return candidates[0]
+}
@@ -0,0 +1,67 @@
+// Package pkg - DATE4 functions
+// Direct translation of CodeBase date conversion operations
+package pkg
+
+import (
+	"strconv"
+	"time"
+)
+
+// julianDayUnixEpoch is the Julian day number of 1970-01-01.
+const julianDayUnixEpoch = 2440588
+
+// Date4Long converts a CCYYMMDD date string to a Julian day number.
+// This mirrors the date4long function from the CodeBase library.
+//
+// Blank dates convert to 0, which is how Visual FoxPro stores empty
+// dates in binary date and datetime fields.
+//
+// Returns the Julian day number, 0 for blank dates and -1 for invalid dates.
+func Date4Long(date string) int32 {
+	if len(date) < 8 {
+		return 0
+	}
+	if date[:8] == "        " || date[:8] == "00000000" {
+		return 0
+	}
+
+	year, errY := strconv.Atoi(date[0:4])
+	month, errM := strconv.Atoi(date[4:6])
+	day, errD := strconv.Atoi(date[6:8])
+	if errY != nil || errM != nil || errD != nil || month < 1 || month > 12 || day < 1 || day > 31 {
+		return -1
+	}
+
+	return date4JulianFromTime(time.Date(year, time.Month(month), day, 0, 0, 0, 0, time.UTC))
+}
+
+// Date4Assign converts a Julian day number to a CCYYMMDD date string.
+// This mirrors the date4assign function from the CodeBase library.
+//
+// Returns the formatted date, or 8 spaces when julian is 0 or negative.
+func Date4Assign(julian int32) string {
+	if julian <= 0 {
+		return "        "
+	}
+	return date4TimeFromJulian(julian, 0).Format("20060102")
+}
+
+// date4JulianFromTime returns the Julian day number for the calendar date of t.
+func date4JulianFromTime(t time.Time) int32 {
+	if t.IsZero() {
+		return 0
+	}
+	y, m, d := t.Date()
+	days := time.Date(y, m, d, 0, 0, 0, 0, time.UTC).Unix() / 86400
+	return int32(days + julianDayUnixEpoch)
+}
+
+// date4TimeFromJulian builds a UTC time from a Julian day number and
+// milliseconds since midnight. A zero day yields the zero time.
+func date4TimeFromJulian(julian int32, millis int32) time.Time {
+	if julian <= 0 {
+		return time.Time{}
+	}
+	seconds := (int64(julian) - julianDayUnixEpoch) * 86400
+	return time.Unix(seconds, 0).UTC().Add(time.Duration(millis) * time.Millisecond)
+}
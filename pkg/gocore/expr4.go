@@ -0,0 +1,399 @@
+// Package pkg - EXPR4 functions
+// Expression parsing and evaluation on top of the expr package
+package pkg
+
+import (
+	"encoding/binary"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mkfoss/foxi/expr"
+)
+
+// Expression result types (mirrors the r4 type codes of e4expr.h)
+const (
+	Expr4TypeChar     = 'C' // Character result
+	Expr4TypeNumeric  = 'N' // Numeric result
+	Expr4TypeDate     = 'D' // Date result
+	Expr4TypeDateTime = 'T' // DateTime result
+	Expr4TypeLogical  = 'L' // Logical result
+	Expr4TypeInteger  = 'I' // Integer field result (4-byte index keys)
+)
+
+// ErrorExpr is returned when an expression cannot be parsed or evaluated
+const ErrorExpr = -400
+
+// expr4MaxKeyLen is the longest key a compact CDX tag can hold
+const expr4MaxKeyLen = 240
+
+// ExpressionParser handles VFP expression parsing and evaluation
+//
+// Deprecated: expressions are parsed by Expr4Parse with the expr package.
+type ExpressionParser struct {
+	Expression string
+	Tokens     []ExprToken
+	Pos        int
+}
+
+// ExprToken represents a parsed expression token
+//
+// Deprecated: see ExpressionParser.
+type ExprToken struct {
+	Type     ExprTokenType
+	Value    string
+	Position int
+}
+
+// ExprTokenType represents different types of expression tokens
+//
+// Deprecated: see ExpressionParser.
+type ExprTokenType int
+
+// Expression token type constants
+//
+// Deprecated: see ExpressionParser.
+const (
+	// TokenField represents a field name in an expression
+	TokenField ExprTokenType = iota
+	TokenFunction
+	TokenOperator
+	TokenLiteral
+	TokenLeftParen
+	TokenRightParen
+	TokenComma
+	TokenEOF
+)
+
+// Expr4 represents a parsed dBASE expression (from EXPR4 in C)
+type Expr4 struct {
+	Source string        // Expression source text
+	Data   *Data4        // Database the expression is bound to
+	Type   byte          // Result type (Expr4TypeXxx)
+	Len    int           // Result length for character expressions
+	Dec    int           // Decimal places for numeric expressions
+	prog   *expr.Program // Compiled expression
+}
+
+// Expr4Parse parses an expression against the fields of a database.
+// This mirrors the expr4parse function from the CodeBase library.
+//
+// Expressions are compiled with the expr package, so index keys, filters
+// and foxi.Compile share one evaluator and one function library. Field
+// names may be qualified with the database alias (ALIAS.FIELD or
+// ALIAS->FIELD). The result type and, for character expressions, the
+// result length are determined at parse time.
+//
+// Returns the parsed expression, nil if the expression is invalid.
+func Expr4Parse(data *Data4, source string) *Expr4 {
+	if data == nil || strings.TrimSpace(source) == "" {
+		return nil
+	}
+
+	prog, err := expr.Compile(source, &expr4Env{data: data})
+	if err != nil || prog.Type() == expr.Null {
+		if data.CodeBase != nil {
+			setError(data.CodeBase, ErrorExpr)
+		}
+		return nil
+	}
+
+	return &Expr4{
+		Source: source,
+		Data:   data,
+		Type:   byte(prog.Type()),
+		Len:    prog.Len(),
+		Dec:    prog.Dec(),
+		prog:   prog,
+	}
+}
+
+// Expr4Source returns the source text of an expression (mirrors expr4source)
+func Expr4Source(expr *Expr4) string {
+	if expr == nil {
+		return ""
+	}
+	return expr.Source
+}
+
+// Expr4Type returns the result type of an expression (mirrors expr4type)
+func Expr4Type(expr *Expr4) byte {
+	if expr == nil {
+		return 0
+	}
+	return expr.Type
+}
+
+// Expr4Len returns the result length of an expression (mirrors expr4len)
+func Expr4Len(expr *Expr4) int {
+	if expr == nil {
+		return 0
+	}
+	return expr.Len
+}
+
+// expr4Eval evaluates an expression for the current record. A failed
+// evaluation, such as a division by zero, gives nil, which the callers
+// turn into the blank value of the result type.
+func expr4Eval(expr *Expr4) any {
+	value, err := expr.prog.Eval()
+	if err != nil {
+		return nil
+	}
+	return value
+}
+
+// Expr4Vary evaluates an expression for the current record and returns the
+// result as a string. This mirrors the expr4vary function from the CodeBase
+// library: character results are returned as stored, dates as CCYYMMDD,
+// logicals as T or F and numbers in their natural decimal form.
+func Expr4Vary(expr *Expr4) string {
+	if expr == nil {
+		return ""
+	}
+	switch value := expr4Eval(expr).(type) {
+	case string:
+		return expr4Encode(value)
+	case time.Time:
+		return Date4Assign(date4JulianFromTime(value))
+	case bool:
+		if value {
+			return "T"
+		}
+		return "F"
+	case float64:
+		return strconv.FormatFloat(value, 'f', -1, 64)
+	}
+	if expr.Type == Expr4TypeLogical {
+		return "F"
+	}
+	return ""
+}
+
+// Expr4Double evaluates a numeric or date expression for the current record
+// (mirrors expr4double). Dates evaluate to their Julian day number.
+func Expr4Double(expr *Expr4) float64 {
+	if expr == nil {
+		return 0
+	}
+	switch value := expr4Eval(expr).(type) {
+	case float64:
+		return value
+	case time.Time:
+		if value.IsZero() {
+			return 0
+		}
+		midnight := value.Truncate(24 * time.Hour)
+		return float64(date4JulianFromTime(value)) + float64(value.Sub(midnight).Milliseconds())/86400000
+	}
+	return 0
+}
+
+// Expr4True evaluates a logical expression for the current record (mirrors
+// expr4true). A null result is false.
+func Expr4True(expr *Expr4) bool {
+	if expr == nil {
+		return false
+	}
+	value, _ := expr4Eval(expr).(bool)
+	return value
+}
+
+// Expr4KeyLen returns the length of index keys built from an expression
+// (mirrors expr4keyLen). Character keys are as long as the expression
+// result, numeric, date and datetime keys take 8 bytes, integer field keys
+// 4 bytes and logical keys 1 byte.
+//
+// Returns the key length, or -1 if the expression cannot form a key.
+func Expr4KeyLen(expr *Expr4) int {
+	if expr == nil {
+		return -1
+	}
+	keyLen := expr.prog.KeyLen()
+	if expr.Type == Expr4TypeChar && (keyLen < 1 || keyLen > expr4MaxKeyLen) {
+		return -1
+	}
+	return keyLen
+}
+
+// Expr4Key evaluates an expression for the current record and converts the
+// result to the sortable key format Visual FoxPro uses in CDX tags
+// (mirrors expr4key). A failed evaluation gives the key of a blank value.
+func Expr4Key(expr *Expr4) []byte {
+	keyLen := Expr4KeyLen(expr)
+	if keyLen < 0 {
+		return nil
+	}
+	key, err := expr.prog.Key()
+	if err != nil {
+		key = make([]byte, keyLen)
+		switch expr.Type {
+		case Expr4TypeChar:
+			for i := range key {
+				key[i] = ' '
+			}
+		case Expr4TypeLogical:
+			key[0] = 'F'
+		case Expr4TypeInteger:
+			t4intToFox(key, 0)
+		default:
+			t4dblToFox(key, 0)
+		}
+	}
+	return key
+}
+
+// t4dblToFox converts a double to an 8-byte key that sorts bytewise
+// (mirrors t4dblToFox)
+func t4dblToFox(key []byte, value float64) {
+	if value == 0 {
+		value = 0 // normalise negative zero
+	}
+	bits := math.Float64bits(value)
+	if bits&(1<<63) != 0 {
+		bits = ^bits
+	} else {
+		bits |= 1 << 63
+	}
+	binary.BigEndian.PutUint64(key, bits)
+}
+
+// t4intToFox converts an integer to a 4-byte key that sorts bytewise
+// (mirrors t4intToFox)
+func t4intToFox(key []byte, value int32) {
+	binary.BigEndian.PutUint32(key, uint32(value)^0x80000000)
+}
+
+// t4foxToDbl converts an 8-byte key back to a double (mirrors t4foxToDbl)
+func t4foxToDbl(key []byte) float64 {
+	bits := binary.BigEndian.Uint64(key)
+	if bits&(1<<63) != 0 {
+		bits &^= 1 << 63
+	} else {
+		bits = ^bits
+	}
+	return math.Float64frombits(bits)
+}
+
+// expr4Env resolves the fields of a database for the expr package.
+// Character fields are read in the code page of the table when it has a
+// Text4, otherwise as ISO 8859-1 text, one character per stored byte,
+// which the expr package turns back into the same bytes when it builds
+// keys. Either way keys match those of expressions compiled in the code
+// page of the table.
+type expr4Env struct {
+	data *Data4
+}
+
+// Column resolves a field of the database, qualified by its alias or not
+func (e *expr4Env) Column(alias, name string) (*expr.Column, bool) {
+	if alias != "" && !strings.EqualFold(alias, D4Alias(e.data)) {
+		return nil, false
+	}
+	field := D4Field(e.data, name)
+	if field == nil {
+		return nil, false
+	}
+
+	column := &expr.Column{Name: name, Len: int(field.Length), Dec: int(field.Dec)}
+	switch rune(field.Type) {
+	case FieldTypeNumeric, FieldTypeFloat, FieldTypeCurrency, FieldTypeDouble:
+		column.Type = expr.Numeric
+		column.Get = expr4Nullable(field, func() any { return F4Double(field) })
+	case FieldTypeInteger:
+		column.Type = expr.Integer
+		column.Get = expr4Nullable(field, func() any { return F4Long(field) })
+	case FieldTypeDate:
+		column.Type = expr.Date
+		column.Get = expr4Nullable(field, func() any {
+			return date4TimeFromJulian(Date4Long(F4Str(field)), 0)
+		})
+	case FieldTypeDateTime:
+		column.Type = expr.DateTime
+		column.Get = expr4Nullable(field, func() any { return F4DateTime(field) })
+	case FieldTypeLogical:
+		column.Type = expr.Logical
+		column.Get = expr4Nullable(field, func() any { return F4True(field) })
+	case FieldTypeMemo, FieldTypeGeneral, FieldTypePicture, FieldTypeBlob:
+		column.Type, column.Len = expr.Character, 0
+		column.Get = expr4Nullable(field, func() any { return e.decode(field, F4MemoStr(field)) })
+	default:
+		column.Type = expr.Character
+		column.Get = expr4Nullable(field, func() any { return e.decode(field, F4Str(field)) })
+	}
+	return column, true
+}
+
+// RecNo returns the current record number
+func (e *expr4Env) RecNo() int {
+	return int(D4RecNo(e.data))
+}
+
+// Deleted reports whether the current record is marked for deletion
+func (e *expr4Env) Deleted() bool {
+	return D4Deleted(e.data)
+}
+
+// EncodeKey stores characters in the code page of the table
+func (e *expr4Env) EncodeKey(s string) (string, error) {
+	if e.data.Text == nil {
+		return expr4Encode(s), nil
+	}
+	return e.data.Text.Encode(s)
+}
+
+// DecodeKey reads bytes as characters the way fields are read
+func (e *expr4Env) DecodeKey(raw string) string {
+	if e.data.Text == nil {
+		return expr4Decode(raw)
+	}
+	return e.data.Text.Decode(raw)
+}
+
+// decode reads the stored bytes of a character or memo field. Binary
+// (NOCPTRANS) fields are not translated by a Text4.
+func (e *expr4Env) decode(field *Field4, raw string) string {
+	if e.data.Text == nil {
+		return expr4Decode(raw)
+	}
+	switch rune(field.Type) {
+	case FieldTypeChar, FieldTypeVarChar, FieldTypeMemo:
+		if field.Binary == 0 {
+			return e.data.Text.Decode(raw)
+		}
+	}
+	return raw
+}
+
+// expr4Nullable reads a field with get, or as nil when it is null
+func expr4Nullable(field *Field4, get func() any) func() (any, error) {
+	return func() (any, error) {
+		if F4Null(field) {
+			return nil, nil
+		}
+		return get(), nil
+	}
+}
+
+// expr4Decode reads stored bytes as ISO 8859-1 characters
+func expr4Decode(raw string) string {
+	runes := make([]rune, len(raw))
+	for i := 0; i < len(raw); i++ {
+		runes[i] = rune(raw[i])
+	}
+	return string(runes)
+}
+
+// expr4Encode turns ISO 8859-1 characters back into bytes; characters
+// outside it, which only case conversion can produce, become '?'
+func expr4Encode(s string) string {
+	b := make([]byte, 0, len(s))
+	for _, r := range s {
+		if r > 0xFF {
+			r = '?'
+		}
+		b = append(b, byte(r))
+	}
+	return string(b)
+}
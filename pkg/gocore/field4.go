@@ -5,6 +5,7 @@ package pkg
 import (
 	"encoding/binary"
 	"fmt"
+	"math"
 	"strconv"
 	"strings"
 	"time"
@@ -131,22 +132,15 @@ func F4Str(field *Field4) string {
 		// Logical field - preserve raw content for binary compatibility
 		return string(fieldData)
 
+	case FieldTypeVarChar, FieldTypeVarBin:
+		// Variable length field - the value without the unused bytes
+		return string(fieldData[:F4VarLength(field)])
+
 	case FieldTypeMemo:
-		// Memo field - return memo content if available (CodeBase library behavior)
-		if field.Memo != nil && field.Memo.Contents != nil {
-			return string(field.Memo.Contents)
-		}
-		// Try to read memo content from memo file
-		if field.Data != nil && field.Data.DataFile != nil && field.Data.DataFile.MemoFile != nil {
-			blockNumStr := strings.TrimSpace(string(fieldData))
-			if blockNumStr != "" && blockNumStr != "0" {
-				content := readMemoContent(field.Data.DataFile.MemoFile, blockNumStr)
-				if content != "" {
-					return content
-				}
-			}
+		// Memo field - return memo content (CodeBase library behavior)
+		if field.Memo != nil && (field.Memo.IsChanged || field.Data.DataFile.MemoFile != nil) {
+			return F4MemoStr(field)
 		}
-		// Otherwise return spaces like CodeBase library does for empty/failed memo reads
 		return string(fieldData)
 
 	default:
@@ -160,7 +154,7 @@ func F4Str(field *Field4) string {
 //
 // The function converts the string value to the appropriate format based
 // on the field type and stores it in the current record buffer:
-// - Character fields: Truncate/pad with spaces as needed
+// - Character fields: Pad with spaces, longer values are ErrorData
 // - Numeric fields: Parse and format with proper alignment and decimals
 // - Date fields: Parse various date formats and store as YYYYMMDD
 // - Logical fields: Convert boolean representations to 'T'/'F'
@@ -190,231 +184,332 @@ func F4Assign(field *Field4, value string) int {
 		return ErrorData
 	}
 
-	// Clear field area first
-	for i := start; i < end; i++ {
-		record[i] = ' '
+	// Memo contents are written to the memo file with the record
+	if field.Memo != nil {
+		return F4MemoAssign(field, value)
+	}
+
+	// Convert into a blank copy of the field, so that a value that cannot
+	// be stored leaves the field as it was
+	buffer := make([]byte, end-start)
+	clearFieldBuffer(field, buffer)
+	if rc := f4assignBuffer(field, buffer, value); rc != ErrorNone {
+		return rc
+	}
+	copy(record[start:end], buffer)
+	f4assignNotNull(field)
+	field.Data.recordChanged = true
+	return ErrorNone
+}
+
+// f4assignBuffer converts a value to the storage format of a field
+func f4assignBuffer(field *Field4, buffer []byte, value string) int {
+	// Binary (Visual FoxPro) storage for integer, currency, double and datetime
+	if f4IsBinaryStorage(field) {
+		switch rune(field.Type) {
+		case FieldTypeInteger:
+			return assignIntegerField(buffer, value)
+		case FieldTypeCurrency:
+			return assignCurrencyField(buffer, value)
+		case FieldTypeDouble:
+			return assignDoubleField(buffer, value)
+		case FieldTypeDateTime:
+			return assignDateTimeField(buffer, value)
+		}
 	}
 
 	// Convert and assign based on field type
 	switch rune(field.Type) {
 	case FieldTypeChar:
-		return assignCharField(record[start:end], value)
+		return assignCharField(buffer, value)
 
-	case FieldTypeNumeric, FieldTypeFloat:
-		return assignNumericField(record[start:end], value, field.Dec)
+	case FieldTypeVarChar, FieldTypeVarBin:
+		return assignVarField(field, buffer, value)
 
-	case FieldTypeInteger:
-		return assignIntegerField(record[start:end], value)
+	case FieldTypeNumeric, FieldTypeFloat:
+		return assignNumericField(buffer, value, field.Dec)
 
-	case FieldTypeCurrency:
-		return assignCurrencyField(record[start:end], value)
+	case FieldTypeInteger, FieldTypeCurrency:
+		return assignNumericField(buffer, value, field.Dec)
 
 	case FieldTypeDate:
-		return assignDateField(record[start:end], value)
-
-	case FieldTypeDateTime:
-		return assignCharField(record[start:end], value)
+		return assignDateField(buffer, value)
 
 	case FieldTypeLogical:
-		return assignLogicalField(record[start:end], value)
-
-	case FieldTypeMemo:
-		// Memo field assignment
-		return assignMemoField(field, value)
+		return assignLogicalField(buffer, value)
 
 	default:
 		// Unknown type - treat as character
-		return assignCharField(record[start:end], value)
+		return assignCharField(buffer, value)
 	}
 }
 
-// assignCharField assigns character data to field buffer
-func assignCharField(buffer []byte, value string) int {
-	// Truncate if too long, pad with spaces if too short
-	valueBytes := []byte(value)
-	copyLen := len(valueBytes)
-	if copyLen > len(buffer) {
-		copyLen = len(buffer)
-	}
+// f4IsBinaryStorage reports whether a field is stored in Visual FoxPro
+// binary form (little-endian integers, doubles, Julian datetimes and
+// 4-byte memo block pointers) instead of as ASCII text.
+func f4IsBinaryStorage(field *Field4) bool {
+	switch rune(field.Type) {
+	case FieldTypeInteger, FieldTypeMemo, FieldTypeGeneral, FieldTypePicture, FieldTypeBlob:
+		return field.Length == 4
+	case FieldTypeCurrency, FieldTypeDateTime, FieldTypeDouble:
+		return field.Length == 8
+	case FieldTypeVarBin, FieldTypeSystem:
+		return true
+	}
+	return false
+}
 
-	copy(buffer, valueBytes[:copyLen])
-	// buffer is already space-padded from clearing above
+// clearFieldBuffer resets a field buffer to its blank contents
+func clearFieldBuffer(field *Field4, buffer []byte) {
+	fill := byte(' ')
+	if f4IsBinaryStorage(field) {
+		fill = 0
+	}
+	for i := range buffer {
+		buffer[i] = fill
+	}
+}
 
+// assignIntegerField assigns a 4-byte little-endian integer to field buffer
+func assignIntegerField(buffer []byte, value string) int {
+	intValue, err := strconv.ParseInt(strings.TrimSpace(value), 10, 32)
+	if err != nil {
+		if strings.TrimSpace(value) == "" {
+			return ErrorNone
+		}
+		return ErrorData
+	}
+	binary.LittleEndian.PutUint32(buffer, uint32(int32(intValue)))
 	return ErrorNone
 }
 
-// assignNumericField assigns numeric data to field buffer
-func assignNumericField(buffer []byte, value string, decimals uint16) int {
-	// Parse the numeric value
-	numValue, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+// assignCurrencyField assigns an 8-byte currency (value * 10000) to field buffer
+func assignCurrencyField(buffer []byte, value string) int {
+	currValue, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
 	if err != nil {
-		// If can't parse, fill with spaces (null value)
-		return ErrorNone
+		if strings.TrimSpace(value) == "" {
+			return ErrorNone
+		}
+		return ErrorData
 	}
+	return putCurrency(buffer, currValue)
+}
 
-	// Format according to field specification
-	var formatted string
-	if decimals > 0 {
-		// Format with decimals
-		formatted = fmt.Sprintf("%.*f", decimals, numValue)
-	} else {
-		// Format as integer
-		formatted = fmt.Sprintf("%.0f", numValue)
+// putCurrency stores a float as a scaled 64-bit currency value
+func putCurrency(buffer []byte, value float64) int {
+	scaled := math.Round(value * 10000)
+	if scaled >= math.MaxInt64 || scaled < math.MinInt64 || math.IsNaN(scaled) {
+		return ErrorData
 	}
+	binary.LittleEndian.PutUint64(buffer, uint64(int64(scaled)))
+	return ErrorNone
+}
 
-	// Right-align in field
-	if len(formatted) <= len(buffer) {
-		start := len(buffer) - len(formatted)
-		copy(buffer[start:], []byte(formatted))
-	} else {
-		// Value too large - fill with asterisks (overflow indicator)
-		for i := range buffer {
-			buffer[i] = '*'
+// assignDoubleField assigns an 8-byte IEEE double to field buffer
+func assignDoubleField(buffer []byte, value string) int {
+	dblValue, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+	if err != nil {
+		if strings.TrimSpace(value) == "" {
+			return ErrorNone
 		}
+		return ErrorData
 	}
-
+	binary.LittleEndian.PutUint64(buffer, math.Float64bits(dblValue))
 	return ErrorNone
 }
 
-// assignIntegerField assigns integer data to field buffer
-func assignIntegerField(buffer []byte, value string) int {
-	// Parse the integer value
-	intValue, err := strconv.ParseInt(strings.TrimSpace(value), 10, 32)
-	if err != nil {
-		// If can't parse, fill with spaces (null value)
+// assignDateTimeField assigns a datetime string to an 8-byte datetime buffer.
+// Accepted forms are the CodeBase "CCYYMMDDhh:mm:ss:ttt" layout, a plain
+// CCYYMMDD date and the common ISO layouts.
+func assignDateTimeField(buffer []byte, value string) int {
+	value = strings.TrimSpace(value)
+	if value == "" {
 		return ErrorNone
 	}
 
-	// Format as right-aligned integer (no decimals)
-	formatted := fmt.Sprintf("%d", intValue)
+	layouts := []string{
+		"2006010215:04:05.000",
+		"2006010215:04:05",
+		"20060102",
+		"2006-01-02 15:04:05",
+		"2006-01-02T15:04:05",
+		"2006-01-02",
+	}
 
-	// Right-align in field
-	if len(formatted) <= len(buffer) {
-		start := len(buffer) - len(formatted)
-		copy(buffer[start:], []byte(formatted))
-	} else {
-		// Value too large - fill with asterisks (overflow indicator)
-		for i := range buffer {
-			buffer[i] = '*'
+	// CodeBase separates milliseconds with a colon
+	if len(value) == 20 && value[16] == ':' {
+		value = value[:16] + "." + value[17:]
+	}
+
+	for _, layout := range layouts {
+		if parsed, err := time.Parse(layout, value); err == nil {
+			putDateTime(buffer, parsed)
+			return ErrorNone
 		}
 	}
 
-	return ErrorNone
+	return ErrorData
 }
 
-// assignCurrencyField assigns currency data to field buffer
-func assignCurrencyField(buffer []byte, value string) int {
-	// Parse the currency value (always 4 decimal places)
-	currValue, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
-	if err != nil {
-		// If can't parse, fill with spaces (null value)
-		return ErrorNone
+// putDateTime stores a time as Julian day plus milliseconds since midnight
+func putDateTime(buffer []byte, value time.Time) {
+	if value.IsZero() {
+		binary.LittleEndian.PutUint64(buffer, 0)
+		return
 	}
+	midnight := time.Date(value.Year(), value.Month(), value.Day(), 0, 0, 0, 0, value.Location())
+	millis := value.Sub(midnight).Milliseconds()
+	binary.LittleEndian.PutUint32(buffer[0:4], uint32(date4JulianFromTime(value)))
+	binary.LittleEndian.PutUint32(buffer[4:8], uint32(millis))
+}
 
-	// Format with exactly 4 decimal places for currency
-	formatted := fmt.Sprintf("%.4f", currValue)
+// fieldBytes returns the slice of the current record holding the field
+func fieldBytes(field *Field4) []byte {
+	if field == nil || field.Data == nil || field.Data.Record == nil {
+		return nil
+	}
+	start := int(field.Offset)
+	end := start + int(field.Length)
+	if start < 0 || end > len(field.Data.Record) {
+		return nil
+	}
+	return field.Data.Record[start:end]
+}
 
-	// Right-align in field
-	if len(formatted) <= len(buffer) {
-		start := len(buffer) - len(formatted)
-		copy(buffer[start:], []byte(formatted))
-	} else {
-		// Value too large - fill with asterisks (overflow indicator)
-		for i := range buffer {
-			buffer[i] = '*'
-		}
+// F4Ptr returns the bytes of a field in the current record buffer.
+// This mirrors the f4ptr function from the CodeBase library.
+//
+// The slice refers to the record buffer, so it changes with the record.
+// Returns nil if the field is nil or has no record.
+func F4Ptr(field *Field4) []byte {
+	return fieldBytes(field)
+}
+
+// F4AssignN copies bytes into a field in the current record.
+// This mirrors the f4assignN function from the CodeBase library.
+//
+// The bytes are stored as they are, without conversion: a longer value is
+// truncated and a shorter one padded with the field's blank.
+//
+// Returns ErrorNone on success, ErrorMemory if the field has no record.
+func F4AssignN(field *Field4, value []byte) int {
+	buffer := fieldBytes(field)
+	if buffer == nil {
+		return ErrorMemory
+	}
+	clearFieldBuffer(field, buffer)
+	copy(buffer, value)
+	f4assignNotNull(field)
+	field.Data.recordChanged = true
+	return ErrorNone
+}
+
+// assignCharField assigns character data to field buffer. A value longer
+// than the field is ErrorData, except for trailing blanks, which are the
+// field's padding anyway.
+func assignCharField(buffer []byte, value string) int {
+	if !fitsField(buffer, value) {
+		return ErrorData
 	}
+	copy(buffer, value)
+	// buffer is already space-padded from clearing above
 
 	return ErrorNone
 }
 
-// assignMemoField assigns memo data to field
-func assignMemoField(field *Field4, value string) int {
-	if field == nil || field.Memo == nil {
-		return ErrorMemory
+// assignVarField assigns varchar or varbinary data to field buffer. A value
+// shorter than the field has its length in the last byte of the field and
+// the field's length bit set in _NullFlags. A value longer than the field
+// is ErrorData.
+func assignVarField(field *Field4, buffer []byte, value string) int {
+	if len(value) > len(buffer) {
+		return ErrorData
+	}
+	n := copy(buffer, value)
+	flags, mask := f4lenFlag(field)
+	if flags == nil {
+		return ErrorNone
+	}
+	if n < len(buffer) {
+		buffer[len(buffer)-1] = byte(n)
+		*flags |= mask
+	} else {
+		*flags &^= mask
 	}
+	return ErrorNone
+}
+
+// fitsField reports whether a character value fits a field buffer: it is
+// no longer than the buffer or has only blanks past its end
+func fitsField(buffer []byte, value string) bool {
+	return len(value) <= len(buffer) || strings.TrimRight(value[len(buffer):], " ") == ""
+}
 
-	// Store the memo content
-	field.Memo.Contents = []byte(value)
-	field.Memo.Length = uint32(len(value))
-	field.Memo.IsChanged = true
+// assignNumericField assigns numeric data to field buffer. A blank value
+// leaves the field blank. A value that is not a finite number, or that has
+// more digits than the field holds, is ErrorData where CodeBase would fill
+// the field with asterisks.
+func assignNumericField(buffer []byte, value string, decimals uint16) int {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return ErrorNone
+	}
 
-	// In the DBF record, memo fields contain the block number
-	// For now, we'll store a placeholder - full implementation would
-	// require memo file handling
-	if field.Data != nil && field.Data.Record != nil {
-		record := field.Data.Record
-		start := int(field.Offset)
-		end := start + int(field.Length)
+	// Parse the numeric value
+	numValue, err := strconv.ParseFloat(value, 64)
+	if err != nil || math.IsNaN(numValue) || math.IsInf(numValue, 0) {
+		return ErrorData
+	}
 
-		if start >= 0 && end <= len(record) {
-			// Clear the memo block number field
-			for i := start; i < end; i++ {
-				record[i] = ' '
-			}
-			// For basic implementation, store sequence number
-			// Real implementation would allocate memo blocks
-			blockNum := "1" // Simplified block number
-			if len(blockNum) <= end-start {
-				copy(record[start:start+len(blockNum)], blockNum)
-			}
+	// Format according to field specification
+	formatted := strconv.FormatFloat(numValue, 'f', int(decimals), 64)
+	if len(formatted) > len(buffer) {
+		// A fraction fits without the zero before the point
+		if digits := strings.TrimPrefix(formatted, "-"); strings.HasPrefix(digits, "0.") {
+			formatted = strings.Replace(formatted, "0.", ".", 1)
 		}
 	}
+	if len(formatted) > len(buffer) {
+		return ErrorData
+	}
 
+	// Right-align in field
+	copy(buffer[len(buffer)-len(formatted):], formatted)
 	return ErrorNone
 }
 
-// assignDateField assigns date data to field buffer (YYYYMMDD format)
+// assignDateField assigns date data to field buffer (YYYYMMDD format). A
+// blank value leaves the date blank; a value that is not a date is ErrorData.
 func assignDateField(buffer []byte, value string) int {
 	if len(buffer) != 8 {
 		return ErrorData // Date fields should be 8 bytes
 	}
 
 	value = strings.TrimSpace(value)
+	if value == "" {
+		return ErrorNone
+	}
 
-	// Try to parse various date formats
-	var dateStr string
-
-	// Check if already in YYYYMMDD format
-	if len(value) == 8 {
-		dateStr = value
-	} else {
-		// Try to parse common formats and convert to YYYYMMDD
-		layouts := []string{
-			"2006/01/02",
-			"2006-01-02",
-			"01/02/2006",
-			"01-02-2006",
-			"2006.01.02",
-		}
-
-		var parsed time.Time
-		var err error
-
-		for _, layout := range layouts {
-			parsed, err = time.Parse(layout, value)
-			if err == nil {
-				break
-			}
-		}
-
-		if err != nil {
-			// Can't parse date - leave as spaces (null date)
+	// YYYYMMDD first, then the common formats
+	layouts := []string{
+		"20060102",
+		"2006/01/02",
+		"2006-01-02",
+		"01/02/2006",
+		"01-02-2006",
+		"2006.01.02",
+	}
+	for _, layout := range layouts {
+		if parsed, err := time.Parse(layout, value); err == nil {
+			copy(buffer, parsed.Format("20060102"))
 			return ErrorNone
 		}
-
-		dateStr = parsed.Format("20060102")
 	}
-
-	// Validate YYYYMMDD format
-	if len(dateStr) == 8 {
-		copy(buffer, []byte(dateStr))
-	}
-
-	return ErrorNone
+	return ErrorData
 }
 
-// assignLogicalField assigns logical data to field buffer
+// assignLogicalField assigns logical data to field buffer. A blank value is
+// false; a value that is not one of the logical forms is ErrorData.
 func assignLogicalField(buffer []byte, value string) int {
 	if len(buffer) < 1 {
 		return ErrorData
@@ -423,68 +518,24 @@ func assignLogicalField(buffer []byte, value string) int {
 	value = strings.TrimSpace(strings.ToUpper(value))
 
 	switch value {
-	case "T", "TRUE", "Y", "YES", "1":
+	case "T", "TRUE", "Y", "YES", "1", ".T.", ".Y.":
 		buffer[0] = 'T'
-	case "F", "FALSE", "N", "NO", "0":
+	case "F", "FALSE", "N", "NO", "0", ".F.", ".N.", "":
 		buffer[0] = 'F'
 	default:
-		buffer[0] = 'F' // Default to false
+		return ErrorData
 	}
 
 	return ErrorNone
 }
 
-// readMemoContent reads memo field content from the memo file
-func readMemoContent(memoFile *Memo4File, blockNumStr string) string {
-	// Parse block number
-	blockNum := int32(0)
-	n, err := fmt.Sscanf(blockNumStr, "%d", &blockNum)
-	if err != nil || n != 1 || blockNum <= 0 {
-		return ""
-	}
-
-	// FPT files use 512-byte blocks by default
-	blockSize := int64(512)
-	if memoFile.BlockSize > 0 {
-		blockSize = int64(memoFile.BlockSize)
-	}
-
-	// Calculate file position (block 0 is header, data starts at block 1)
-	pos := int64(blockNum) * blockSize
-
-	// Read memo block header (first 8 bytes of block)
-	headerBuf := make([]byte, 8)
-	bytesRead := File4Read(&memoFile.File, pos, headerBuf, 8)
-	if bytesRead != 8 {
-		return ""
-	}
-
-	// In FPT format, bytes 4-7 contain the memo length (big endian)
-	memoLength := int32(binary.BigEndian.Uint32(headerBuf[4:8]))
-	if memoLength <= 0 || memoLength > 65535 { // Reasonable size limit
-		return ""
-	}
-
-	// Read memo content (starts after 8-byte block header)
-	contentBuf := make([]byte, memoLength)
-	bytesRead = File4Read(&memoFile.File, pos+8, contentBuf, uint32(memoLength))
-	if bytesRead != uint32(memoLength) {
-		return ""
-	}
-
-	// Convert to string and trim null terminators
-	content := string(contentBuf)
-	content = strings.TrimRight(content, "\x00")
-	return content
-}
-
 // F4Double returns the field value as a float64.
 // This mirrors the f4double function from the CodeBase library.
 //
-// The function converts the field's string representation to a floating-point
+// The function converts the field contents to a floating-point
 // number based on the field type:
-// - Numeric/Float/Currency fields: Parse as decimal number
-// - Integer fields: Parse as integer and convert to float64
+// - Numeric/Float fields: Parse as decimal number
+// - Integer/Currency/Double fields: Decode the binary Visual FoxPro value
 // - Logical fields: Return 1.0 for true, 0.0 for false
 // - Other types: Attempt to parse as number, return 0.0 on failure
 //
@@ -494,42 +545,35 @@ func F4Double(field *Field4) float64 {
 		return 0.0
 	}
 
+	if f4IsBinaryStorage(field) {
+		buffer := fieldBytes(field)
+		if buffer == nil {
+			return 0.0
+		}
+		switch rune(field.Type) {
+		case FieldTypeInteger:
+			return float64(int32(binary.LittleEndian.Uint32(buffer)))
+		case FieldTypeCurrency:
+			return float64(int64(binary.LittleEndian.Uint64(buffer))) / 10000
+		case FieldTypeDouble:
+			return math.Float64frombits(binary.LittleEndian.Uint64(buffer))
+		}
+	}
+
 	strValue := F4Str(field)
 	if strValue == "" {
 		return 0.0
 	}
 
-	// For numeric fields, parse the string value
 	switch rune(field.Type) {
-	case FieldTypeNumeric, FieldTypeFloat:
-		value, err := strconv.ParseFloat(strings.TrimSpace(strValue), 64)
-		if err != nil {
-			return 0.0
-		}
-		return value
-
-	case FieldTypeInteger:
-		value, err := strconv.ParseInt(strings.TrimSpace(strValue), 10, 32)
-		if err != nil {
-			return 0.0
-		}
-		return float64(value)
-
-	case FieldTypeCurrency:
-		value, err := strconv.ParseFloat(strings.TrimSpace(strValue), 64)
-		if err != nil {
-			return 0.0
-		}
-		return value
-
 	case FieldTypeLogical:
-		if strValue == "T" {
+		if F4True(field) {
 			return 1.0
 		}
 		return 0.0
 
 	default:
-		// Try to parse as number anyway
+		// Numeric, float and any other type stored as text
 		value, err := strconv.ParseFloat(strings.TrimSpace(strValue), 64)
 		if err != nil {
 			return 0.0
@@ -541,22 +585,55 @@ func F4Double(field *Field4) float64 {
 // F4AssignDouble assigns a float64 value to a field.
 // This mirrors the f4assignDouble function from the CodeBase library.
 //
-// The function converts the float64 value to the appropriate string format
-// based on the field type and then assigns it using F4Assign:
+// The function stores the value in the format of the field type:
 // - Numeric/Float fields: Format with specified decimal places
-// - Integer fields: Format as whole number
-// - Currency fields: Format with 4 decimal places
+// - Integer fields: Stored as a 4-byte binary integer
+// - Currency fields: Stored as an 8-byte integer scaled by 10000
+// - Double fields: Stored as an 8-byte IEEE double
 // - Logical fields: Convert to 'T' (non-zero) or 'F' (zero)
 //
 // Parameters:
 //   - field: Field4 structure representing the target field
 //   - value: float64 value to assign
 //
-// Returns ErrorNone on success, ErrorMemory if field is nil.
+// Returns ErrorNone on success, ErrorMemory if field is nil,
+// ErrorData for NaN, an infinity or a value that does not fit the field.
 func F4AssignDouble(field *Field4, value float64) int {
 	if field == nil {
 		return ErrorMemory
 	}
+	if math.IsNaN(value) || math.IsInf(value, 0) {
+		return ErrorData
+	}
+
+	if f4IsBinaryStorage(field) {
+		buffer := fieldBytes(field)
+		if buffer == nil {
+			return ErrorMemory
+		}
+		switch rune(field.Type) {
+		case FieldTypeInteger:
+			rounded := math.Round(value)
+			if rounded > math.MaxInt32 || rounded < math.MinInt32 {
+				return ErrorData
+			}
+			return F4AssignLong(field, int32(rounded))
+		case FieldTypeCurrency:
+			// Stored before the record is marked, so a value out of
+			// range leaves the field and its null flag as they were
+			if rc := putCurrency(buffer, value); rc != ErrorNone {
+				return rc
+			}
+			f4assignNotNull(field)
+			field.Data.recordChanged = true
+			return ErrorNone
+		case FieldTypeDouble:
+			f4assignNotNull(field)
+			field.Data.recordChanged = true
+			binary.LittleEndian.PutUint64(buffer, math.Float64bits(value))
+			return ErrorNone
+		}
+	}
 
 	// Convert double to appropriate string representation
 	var strValue string
@@ -569,12 +646,6 @@ func F4AssignDouble(field *Field4, value float64) int {
 			strValue = fmt.Sprintf("%.0f", value)
 		}
 
-	case FieldTypeInteger:
-		strValue = fmt.Sprintf("%.0f", value)
-
-	case FieldTypeCurrency:
-		strValue = fmt.Sprintf("%.4f", value) // Always 4 decimal places for currency
-
 	case FieldTypeLogical:
 		if value != 0.0 {
 			strValue = "T"
@@ -613,37 +684,60 @@ func F4AssignInt(field *Field4, value int) int {
 // F4Long returns the field value as an int32.
 // This mirrors the f4long function from the CodeBase library.
 //
-// Convenience function that calls F4Double and casts the result to int32.
+// Binary integer fields are decoded directly; other types go through F4Double.
 //
 // Returns the field value as int32, 0 if field is nil or conversion fails.
 func F4Long(field *Field4) int32 {
+	if field != nil && rune(field.Type) == FieldTypeInteger && f4IsBinaryStorage(field) {
+		if buffer := fieldBytes(field); buffer != nil {
+			return int32(binary.LittleEndian.Uint32(buffer))
+		}
+		return 0
+	}
 	return int32(F4Double(field))
 }
 
 // F4AssignLong assigns an int32 value to a field.
 // This mirrors the f4assignLong function from the CodeBase library.
 //
-// Convenience function that converts the int32 to float64 and calls F4AssignDouble.
+// Binary integer fields are stored directly; other types go through F4AssignDouble.
 //
 // Returns ErrorNone on success, ErrorMemory if field is nil.
 func F4AssignLong(field *Field4, value int32) int {
+	if field != nil && rune(field.Type) == FieldTypeInteger && f4IsBinaryStorage(field) {
+		buffer := fieldBytes(field)
+		if buffer == nil {
+			return ErrorMemory
+		}
+		f4assignNotNull(field)
+		field.Data.recordChanged = true
+		binary.LittleEndian.PutUint32(buffer, uint32(value))
+		return ErrorNone
+	}
 	return F4AssignDouble(field, float64(value))
 }
 
 // F4True returns true if the field contains a logical true value.
 // This mirrors the f4true function from the CodeBase library.
 //
-// The function checks if a logical field contains 'T' (true).
+// The function checks if a logical field contains 'T' or 'Y' (either case).
 // All other values including 'F', spaces, and other characters are false.
 //
-// Returns true if field contains 'T', false otherwise or if field is nil.
+// Returns true for a true value, false otherwise or if field is nil.
 func F4True(field *Field4) bool {
 	if field == nil {
 		return false
 	}
 
 	strValue := F4Str(field)
-	return strValue == "T"
+	if strValue == "" {
+		return false
+	}
+	switch strValue[0] {
+	case 'T', 't', 'Y', 'y':
+		return true
+	}
+	return false
 }
 
 // F4AssignLogical assigns boolean value to logical field (mirrors f4assignLogical)
@@ -656,35 +750,122 @@ func F4AssignLogical(field *Field4, value bool) int {
 
 // F4Blank blanks a field (mirrors f4blank)
 func F4Blank(field *Field4) {
-	if field == nil || field.Data == nil {
+	buffer := fieldBytes(field)
+	if buffer == nil {
 		return
 	}
 
-	record := field.Data.Record
-	if record == nil {
-		return
+	// Fill field with appropriate blank value
+	switch rune(field.Type) {
+	case FieldTypeLogical:
+		buffer[0] = 'F' // False for logical fields
+	default:
+		// Spaces for text fields, zeros for binary fields
+		clearFieldBuffer(field, buffer)
 	}
+	field.Data.recordChanged = true
+}
 
-	start := int(field.Offset)
-	end := start + int(field.Length)
+// F4Null returns true if the field holds a null value.
+// This mirrors the f4null function from the CodeBase library.
+//
+// Only fields flagged as nullable in a table with a _NullFlags column
+// can be null; for all other fields the result is always false.
+//
+// Returns true if the field's null bit is set.
+func F4Null(field *Field4) bool {
+	flags, mask := f4nullFlag(field)
+	if flags == nil {
+		return false
+	}
+	return *flags&mask != 0
+}
 
-	if start < 0 || end > len(record) {
-		return
+// F4AssignNull sets a nullable field to null.
+// This mirrors the f4assignNull function from the CodeBase library.
+//
+// The field contents are blanked and its bit in _NullFlags is set.
+//
+// Returns ErrorNone on success, ErrorMemory if field is nil,
+// ErrorData if the field does not accept null values.
+func F4AssignNull(field *Field4) int {
+	if field == nil || field.Data == nil {
+		return ErrorMemory
+	}
+	flags, mask := f4nullFlag(field)
+	if flags == nil {
+		return ErrorData
 	}
 
-	// Fill field with appropriate blank value
-	switch rune(field.Type) {
-	case FieldTypeLogical:
-		record[start] = 'F' // False for logical fields
-	default:
-		// Spaces for all other field types
-		for i := start; i < end; i++ {
-			record[i] = ' '
-		}
+	F4Blank(field)
+	*flags |= mask
+	field.Data.recordChanged = true
+	return ErrorNone
+}
+
+// f4assignNotNull clears the field's null bit (mirrors f4assignNotNull)
+func f4assignNotNull(field *Field4) {
+	flags, mask := f4nullFlag(field)
+	if flags != nil {
+		*flags &^= mask
+	}
+}
+
+// f4nullFlag locates the _NullFlags byte and bit mask for a nullable field
+func f4nullFlag(field *Field4) (*byte, byte) {
+	if field == nil || field.Null == 0 {
+		return nil, 0
+	}
+	return f4flagBit(field, field.NullBit)
+}
+
+// f4lenFlag locates the _NullFlags byte and bit mask that mark a varchar
+// or varbinary value shorter than its field
+func f4lenFlag(field *Field4) (*byte, byte) {
+	if field == nil || (field.Type != int16(FieldTypeVarChar) && field.Type != int16(FieldTypeVarBin)) {
+		return nil, 0
 	}
+	return f4flagBit(field, field.LenBit)
 }
 
-// F4DateTime returns field value as time.Time for DateTime fields
+// f4flagBit locates a bit of the _NullFlags field in the current record
+func f4flagBit(field *Field4, bit uint16) (*byte, byte) {
+	if field.Data == nil || field.Data.DataFile == nil {
+		return nil, 0
+	}
+	nullFlags := field.Data.DataFile.NullFlags
+	if nullFlags == nil || field.Data.Record == nil {
+		return nil, 0
+	}
+	byteIndex := bit / 8
+	if byteIndex >= nullFlags.Length {
+		return nil, 0
+	}
+	pos := int(nullFlags.Offset) + int(byteIndex)
+	if pos >= len(field.Data.Record) {
+		return nil, 0
+	}
+	return &field.Data.Record[pos], byte(1) << (bit % 8)
+}
+
+// F4VarLength returns the length of the value held by a varchar or
+// varbinary field: the last byte of the field when its _NullFlags length
+// bit is set, the field width otherwise.
+func F4VarLength(field *Field4) int {
+	buffer := fieldBytes(field)
+	if len(buffer) == 0 {
+		return 0
+	}
+	flags, mask := f4lenFlag(field)
+	if flags == nil || *flags&mask == 0 {
+		return len(buffer)
+	}
+	return min(int(buffer[len(buffer)-1]), len(buffer)-1)
+}
+
+// F4DateTime returns field value as time.Time for Date and DateTime fields.
+// Visual FoxPro DateTime fields hold a 4-byte Julian day followed by
+// 4 bytes of milliseconds since midnight; the result is in UTC.
 func F4DateTime(field *Field4) time.Time {
 	if field == nil {
 		return time.Time{}
@@ -706,30 +887,20 @@ func F4DateTime(field *Field4) time.Time {
 		}
 
 	case FieldTypeDateTime:
-		// FoxPro DateTime field - 8 bytes: 4 bytes Julian date + 4 bytes milliseconds
-		strValue := strings.TrimSpace(F4Str(field))
-		if len(strValue) >= 8 {
-			// For simplified implementation, try to parse as formatted datetime
-			layouts := []string{
-				"2006-01-02 15:04:05",
-				"2006/01/02 15:04:05",
-				"01/02/2006 15:04:05",
-				"2006-01-02T15:04:05",
-			}
-
-			for _, layout := range layouts {
-				if parsed, err := time.Parse(layout, strValue); err == nil {
-					return parsed
-				}
-			}
+		buffer := fieldBytes(field)
+		if buffer == nil || len(buffer) != 8 {
+			return time.Time{}
 		}
-		return time.Time{}
+		julian := int32(binary.LittleEndian.Uint32(buffer[0:4]))
+		millis := int32(binary.LittleEndian.Uint32(buffer[4:8]))
+		return date4TimeFromJulian(julian, millis)
 	}
 
 	return time.Time{}
 }
 
-// F4AssignDateTime assigns time.Time value to date field
+// F4AssignDateTime assigns time.Time value to a Date or DateTime field.
+// A zero time blanks the field.
 func F4AssignDateTime(field *Field4, value time.Time) int {
 	if field == nil {
 		return ErrorMemory
@@ -737,14 +908,21 @@ func F4AssignDateTime(field *Field4, value time.Time) int {
 
 	switch rune(field.Type) {
 	case FieldTypeDate:
+		if value.IsZero() {
+			return F4Assign(field, "")
+		}
 		dateStr := value.Format("20060102")
 		return F4Assign(field, dateStr)
 
 	case FieldTypeDateTime:
-		// FoxPro DateTime field assignment
-		// For simplified implementation, store as formatted string
-		datetimeStr := value.Format("2006-01-02 15:04:05")
-		return F4Assign(field, datetimeStr)
+		buffer := fieldBytes(field)
+		if buffer == nil || len(buffer) != 8 {
+			return ErrorData
+		}
+		f4assignNotNull(field)
+		field.Data.recordChanged = true
+		putDateTime(buffer, value)
+		return ErrorNone
 
 	default:
 		return ErrorData
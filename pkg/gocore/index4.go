@@ -1,28 +1,43 @@
 // Package pkg - INDEX4 and TAG4 functions for CDX index support
-// Direct translation of CodeBase CDX index operations (read-only)
+// Direct translation of CodeBase Visual FoxPro compound index operations
 package pkg
 
 import (
+	"bytes"
 	"encoding/binary"
-	"fmt"
+	"math"
 	"os"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
 	"unsafe"
 )
 
-// CDX file header constants
+// CDX file constants
 const (
-	CDXHeaderSize   = 512
-	CDXTagDescSize  = 32
-	CDXBlockSize    = 512
-	CDXMaxTags      = 48
+	CDXHeaderSize   = 1024 // Size of a tag header (including the expression pool)
+	CDXBlockSize    = b4NodeSize
+	CDXExprPool     = 512 // Offset of the expression pool within a tag header
+	CDXExprPoolSize = CDXHeaderSize - CDXExprPool
+	CDXTagNameLen   = 10 // Key length of the tag directory
 	CDXSignature    = 0x01
 	CDXTypeUnique   = 0x01
 	CDXTypeFor      = 0x08
-	CDXTypeCompact  = 0x32
-	CDXTypeCompound = 0x80
+	CDXTypeCompact  = 0x20
+	CDXTypeCompound = 0x40
+	CDXTypeTag      = CDXTypeCompact | CDXTypeCompound
+	CDXTypeIndex    = 0x80 | CDXTypeCompact | CDXTypeCompound
+
+	// Deprecated: tag directory entries are ordinary index keys; see
+	// CDXTagNameLen.
+	CDXTagDescSize = 32
+	// Deprecated: a compound index holds as many tags as its directory has
+	// room for.
+	CDXMaxTags = 48
+	// Deprecated: compact nodes hold as many keys as fit in CDXBlockSize.
+	CDXMaxKeysPerBlock = 30
 )
 
 // CdxHeader represents the CDX file header structure
@@ -42,6 +57,9 @@ type CdxHeader struct {
 }
 
 // CdxTagDesc represents a tag descriptor in the CDX header
+//
+// Deprecated: the tags of an index are read into Tag4File values, listed
+// by Index4File.Tags.
 type CdxTagDesc struct {
 	TagName   [11]byte  // Tag name
 	KeyExpr   []byte    // Key expression
@@ -52,287 +70,259 @@ type CdxTagDesc struct {
 }
 
 // I4Open opens an index file (mirrors i4open)
+//
+// When fileName has no extension the production index of the table (the
+// CDX with the same base name as the DBF) is opened. Every tag named in
+// the index directory is opened and linked to the index; tags are not
+// selected.
+//
+// Returns the opened index, nil if the file cannot be opened or is not a
+// valid compound index.
 func I4Open(data *Data4, fileName string) *Index4 {
-	if data == nil || fileName == "" {
+	if data == nil || data.DataFile == nil {
 		return nil
 	}
 
-	// Construct index file path
-	var indexPath string
-	if filepath.Ext(fileName) == "" {
-		// No extension provided, use DBF base name with .CDX
-		baseName := strings.TrimSuffix(filepath.Base(D4FileName(data)), ".dbf")
-		indexPath = filepath.Join(filepath.Dir(D4FileName(data)), baseName+".cdx")
-	} else {
-		indexPath = fileName
+	indexPath := fileName
+	if fileName == "" || filepath.Ext(fileName) == "" {
+		indexPath = i4productionName(data)
+	}
+
+	// An index file is only opened once per table
+	if index := i4lookup(data, indexPath); index != nil {
+		return index
 	}
 
-	// Create INDEX4 structure
 	index := &Index4{
 		Data:     data,
 		CodeBase: data.CodeBase,
-		IsValid:  false,
 	}
-
 	copy(index.AccessName[:], indexPath)
 
-	// Create INDEX4FILE
 	indexFile := &Index4File{
 		CodeBase: data.CodeBase,
 		DataFile: data.DataFile,
-		IsValid:  false,
-	}
-
-	// Try to open the CDX file
-	err := File4Open(&indexFile.File, data.CodeBase, indexPath, AccessDenyNone)
-	if err != ErrorNone {
-		return nil // Index file not found or can't open
 	}
+	index.IndexFile = indexFile
 
-	// Parse CDX header
-	err = parseCdxHeader(indexFile)
-	if err != ErrorNone {
-		File4Close(&indexFile.File)
+	if File4Open(&indexFile.File, data.CodeBase, indexPath, AccessDenyNone) != ErrorNone {
 		return nil
 	}
 
-	// Parse tag descriptors
-	err = parseCdxTags(indexFile)
-	if err != ErrorNone {
+	if err := i4readTags(index); err != ErrorNone {
 		File4Close(&indexFile.File)
+		setError(data.CodeBase, err)
 		return nil
 	}
 
-	// Set up the structures
-	index.IndexFile = indexFile
 	indexFile.IsValid = true
+	indexFile.UserCount = 1
 	index.IsValid = true
-
-	// Add index to data's index list
 	list4Add(&data.Indexes, &index.Link)
 
 	return index
 }
 
-// parseCdxHeader reads and parses the main CDX file header
-func parseCdxHeader(indexFile *Index4File) int {
-	headerBuf := make([]byte, CDXHeaderSize)
+// i4readTags reads the tag directory and opens every tag it names
+func i4readTags(index *Index4) int {
+	indexFile := index.IndexFile
 
-	// Read the main header
-	bytesRead := File4Read(&indexFile.File, 0, headerBuf, CDXHeaderSize)
-	if bytesRead != CDXHeaderSize {
-		return ErrorRead
+	directory := &Tag4File{
+		IndexFile: indexFile,
+		CodeBase:  index.CodeBase,
+		KeyType:   Expr4TypeChar,
 	}
-
-	// The CDX format starts with tag descriptors, not a global header
-	// We'll parse the tag table at offset 0x200 (512)
-	return ErrorNone
-}
-
-// parseCdxTags reads tag descriptors from CDX file using proper VFP format
-//
-//nolint:gocyclo // TODO: refactor to reduce complexity by extracting tag parsing methods
-func parseCdxTags(indexFile *Index4File) int {
-	// Based on actual CDX file structure analysis, implement proper parsing
-	// CDX compound index format: tags stored at fixed 512-byte block offsets
-
-	// First, read the main CDX header (16 bytes for VFP)
-	headerBuf := make([]byte, 16)
-	bytesRead := File4Read(&indexFile.File, 0, headerBuf, 16)
-	if bytesRead != 16 {
-		return ErrorRead
+	if err := t4readHeader(directory); err != ErrorNone {
+		return err
 	}
+	if directory.Header.KeyLen != CDXTagNameLen || directory.Header.Root <= 0 {
+		return ErrorIndex
+	}
+	indexFile.TagIndex = directory
 
-	// Parse main header for CDX compound index
-	rootBlock := int32(binary.LittleEndian.Uint32(headerBuf[0:4])) // Root block number
-	freeList := int32(binary.LittleEndian.Uint32(headerBuf[4:8]))  // Free list
-	version := binary.LittleEndian.Uint32(headerBuf[8:12])         // Version
-	// keyLen and typeCode from main header not used for compound parsing
-
-	// For compound index, tags are stored at predictable block offsets
-	// Based on hexdump analysis: block 3 (0x600), block 5 (0xa00), etc.
-	tagBlockOffsets := []int64{
-		0x600,  // categoryid
-		0xa00,  // categoryid+subcatid
-		0xe00,  // UPPER(name)
-		0x1200, // UPPER(name_frc)
-		0x1600, // subcatid
-	}
-
-	var tagCount int
-	for _, offset := range tagBlockOffsets {
-		// Read tag descriptor block
-		tagBlock := make([]byte, CDXBlockSize)
-		bytesRead := File4Read(&indexFile.File, offset, tagBlock, CDXBlockSize)
-		if bytesRead != CDXBlockSize {
-			continue // Skip invalid blocks
-		}
-
-		// Read expression string directly from the start of the block
-		// Based on hexdump, expressions start at offset 0x00 in each block
-		var expression string
-
-		// Find the null-terminated expression string
-		var exprBytes []byte
-		for j := 0; j < 256 && j < len(tagBlock); j++ {
-			if tagBlock[j] == 0 {
-				break // End of string
-			}
-			if tagBlock[j] >= 32 && tagBlock[j] < 127 { // Printable ASCII
-				exprBytes = append(exprBytes, tagBlock[j])
-			} else {
-				break // Non-printable character, end of expression
-			}
-		}
-
-		if len(exprBytes) >= 3 {
-			expression = string(exprBytes)
-		}
-
-		// Read tag properties from the block header area (simplified)
-		// For now, use reasonable defaults and extract key length from expression
-		tagKeyLen := int16(10)  // Default key length
-		tagTypeCode := uint8(0) // Default type
-
-		// Try to determine if unique by checking type code at specific offset
-		if len(tagBlock) > 0x1f6 {
-			tagTypeCode = tagBlock[0x1f6]
-		}
-
-		if expression == "" {
-			continue // Skip blocks without expressions
-		}
+	entries, err := t4entries(directory)
+	if err != ErrorNone {
+		return err
+	}
 
-		// Create tag file structure
+	for _, entry := range entries {
 		tagFile := &Tag4File{
-			CodeBase:     indexFile.CodeBase,
 			IndexFile:    indexFile,
-			ExprSource:   expression,
-			HeaderOffset: int32(offset),
-		}
-
-		// Set tag name - for simple expressions, use the expression as name
-		tagName := expression
-		if strings.Contains(expression, "(") {
-			// Complex expression, extract tag name from it
-			if strings.HasPrefix(expression, "UPPER(") {
-				// Extract field name from UPPER(fieldname)
-				start := strings.Index(expression, "(") + 1
-				end := strings.Index(expression, ")")
-				if end > start {
-					tagName = strings.ToUpper(expression[start:end])
-				}
-			}
-		} else {
-			tagName = strings.ToUpper(expression)
-		}
-
-		// Set tag name in alias field
-		if len(tagName) > MaxFieldName {
-			tagName = tagName[:MaxFieldName]
+			CodeBase:     index.CodeBase,
+			HeaderOffset: entry.RecNo,
 		}
-		copy(tagFile.Alias[:], tagName)
-
-		// Set tag header properties
-		tagFile.Header = CdxHeader{
-			Root:       rootBlock,
-			FreeList:   freeList,
-			Version:    version,
-			KeyLen:     tagKeyLen,
-			TypeCode:   tagTypeCode,
-			Descending: 0, // Default to ascending
+		copy(tagFile.Alias[:], bytes.TrimRight(entry.Key, " \x00"))
+		if err := t4readHeader(tagFile); err != ErrorNone {
+			return err
 		}
-
-		list4Add(&indexFile.Tags, &tagFile.Link)
-		tagCount++
+		t4initExpr(tagFile, index.Data)
+		i4addTag(index, tagFile)
 	}
 
-	indexFile.Tags.NumLinks = uint16(tagCount)
 	return ErrorNone
 }
 
-// isValidTagName checks if a string could be a valid tag name
-//
-//nolint:unused // Future use
-func isValidTagName(name string) bool {
-	if len(name) == 0 {
-		return false
+// i4addTag links a tag file to the index and creates its TAG4
+func i4addTag(index *Index4, tagFile *Tag4File) *Tag4 {
+	list4Add(&index.IndexFile.Tags, &tagFile.Link)
+	tag := &Tag4{
+		Index:   index,
+		TagFile: tagFile,
+		IsValid: true,
 	}
+	list4Add(&index.Tags, &tag.Link)
+	return tag
+}
 
-	// Must start with a letter
-	if !(name[0] >= 'A' && name[0] <= 'Z') {
-		return false
+// t4initExpr parses the key and filter expressions of a tag
+//
+// A tag whose expression cannot be parsed can still be read; its keys are
+// then treated as character keys.
+func t4initExpr(tagFile *Tag4File, data *Data4) {
+	tagFile.KeyType = Expr4TypeChar
+	tagFile.Expr = Expr4Parse(data, tagFile.ExprSource)
+	if tagFile.Expr != nil {
+		tagFile.KeyType = Expr4Type(tagFile.Expr)
+		tagFile.KeyDec = tagFile.Expr.Dec
 	}
-
-	// Must contain only letters, numbers, and underscores
-	for _, c := range name {
-		if !((c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9') || c == '_') {
-			return false
-		}
+	tagFile.Filter = nil
+	if tagFile.FilterSource != "" {
+		tagFile.Filter = Expr4Parse(data, tagFile.FilterSource)
+	}
+	if data != nil && data.CodeBase != nil && data.CodeBase.ErrorCode == ErrorExpr {
+		// Unsupported expressions are not fatal when reading an index
+		setError(data.CodeBase, ErrorNone)
 	}
-
-	return true
 }
 
-// readTagHeader reads a tag header from the specified position
-//
-//nolint:unused // Future use
-func readTagHeader(indexFile *Index4File, tagFile *Tag4File, headerPos int32) int {
-	// Calculate actual file position (headerPos is a byte offset, not block)
-	filePos := int64(headerPos)
-
-	headerBuf := make([]byte, CDXBlockSize)
-	bytesRead := File4Read(&indexFile.File, filePos, headerBuf, CDXBlockSize)
-	if bytesRead <= 0 {
+// t4readHeader reads a tag header and its expression pool
+func t4readHeader(tagFile *Tag4File) int {
+	buf := make([]byte, CDXHeaderSize)
+	if File4Read(&tagFile.IndexFile.File, File4Long(tagFile.HeaderOffset), buf, CDXHeaderSize) != CDXHeaderSize {
 		return ErrorRead
 	}
 
-	// Parse the tag header (FoxPro CDX format)
 	header := &tagFile.Header
-
-	// FoxPro CDX format:
-	header.Root = int32(binary.LittleEndian.Uint16(headerBuf[0:2]))     // Root block (2 bytes)
-	header.FreeList = int32(binary.LittleEndian.Uint16(headerBuf[2:4])) // Free list (2 bytes)
-
-	// Skip reserved bytes (4-11)
-
-	header.KeyLen = int16(binary.LittleEndian.Uint16(headerBuf[12:14])) // Key length
-	header.TypeCode = headerBuf[14]                                     // Type/options
-	header.Signature = headerBuf[15]                                    // Signature (usually 0xFF for leaf pages)
-
-	// Parse collating sequence (bytes 16-23)
-	copy(header.SortSeq[:], headerBuf[16:24])
-
-	// Additional parsing for expression, filter positions
-	header.ExprPos = int16(binary.LittleEndian.Uint16(headerBuf[24:26]))
-	header.ExprLen = int16(binary.LittleEndian.Uint16(headerBuf[26:28]))
-	header.FilterPos = int16(binary.LittleEndian.Uint16(headerBuf[28:30]))
-	header.FilterLen = int16(binary.LittleEndian.Uint16(headerBuf[30:32]))
-	header.Descending = int16(binary.LittleEndian.Uint16(headerBuf[32:34]))
-
-	// Store header offset
-	tagFile.HeaderOffset = int32(filePos)
-
+	header.Root = int32(binary.LittleEndian.Uint32(buf[0:4]))
+	header.FreeList = int32(binary.LittleEndian.Uint32(buf[4:8]))
+	header.Version = binary.BigEndian.Uint32(buf[8:12])
+	header.KeyLen = int16(binary.LittleEndian.Uint16(buf[12:14]))
+	header.TypeCode = buf[14]
+	header.Signature = buf[15]
+	copy(header.SortSeq[:], buf[490:498])
+	header.Descending = int16(binary.LittleEndian.Uint16(buf[502:504]))
+	header.FilterPos = int16(binary.LittleEndian.Uint16(buf[504:506]))
+	header.FilterLen = int16(binary.LittleEndian.Uint16(buf[506:508]))
+	header.ExprPos = int16(binary.LittleEndian.Uint16(buf[508:510]))
+	header.ExprLen = int16(binary.LittleEndian.Uint16(buf[510:512]))
+
+	if header.KeyLen <= 0 || header.KeyLen > expr4MaxKeyLen {
+		return ErrorIndex
+	}
+
+	pool := buf[CDXExprPool:]
+	tagFile.ExprSource = t4poolString(pool, header.ExprPos, header.ExprLen)
+	tagFile.FilterSource = ""
+	if header.TypeCode&CDXTypeFor != 0 {
+		tagFile.FilterSource = t4poolString(pool, header.FilterPos, header.FilterLen)
+	}
 	return ErrorNone
 }
 
-// getTagName extracts tag name from byte array
-func getTagName(nameBytes []byte) string {
-	// Add empty check
-	if len(nameBytes) == 0 {
+// t4poolString extracts a NUL terminated expression from the expression pool
+func t4poolString(pool []byte, pos, length int16) string {
+	if pos < 0 || length <= 0 || int(pos) >= len(pool) {
 		return ""
 	}
+	end := min(int(pos)+int(length), len(pool))
+	text := pool[pos:end]
+	if nul := bytes.IndexByte(text, 0); nul >= 0 {
+		text = text[:nul]
+	}
+	return strings.TrimSpace(string(text))
+}
+
+// t4writeHeader writes a tag header and its expression pool (mirrors tfile4writeHeader)
+func t4writeHeader(tagFile *Tag4File) int {
+	header := &tagFile.Header
+	header.Version++
+
+	buf := make([]byte, CDXHeaderSize)
+	binary.LittleEndian.PutUint32(buf[0:4], uint32(header.Root))
+	binary.LittleEndian.PutUint32(buf[4:8], uint32(header.FreeList))
+	binary.BigEndian.PutUint32(buf[8:12], header.Version)
+	binary.LittleEndian.PutUint16(buf[12:14], uint16(header.KeyLen))
+	buf[14] = header.TypeCode
+	buf[15] = header.Signature
+	copy(buf[490:498], header.SortSeq[:])
+	binary.LittleEndian.PutUint16(buf[502:504], uint16(header.Descending))
+	binary.LittleEndian.PutUint16(buf[504:506], uint16(header.FilterPos))
+	binary.LittleEndian.PutUint16(buf[506:508], uint16(header.FilterLen))
+	binary.LittleEndian.PutUint16(buf[508:510], uint16(header.ExprPos))
+	binary.LittleEndian.PutUint16(buf[510:512], uint16(header.ExprLen))
+
+	pool := buf[CDXExprPool:]
+	copy(pool[header.ExprPos:], tagFile.ExprSource)
+	copy(pool[header.FilterPos:], tagFile.FilterSource)
+
+	tagFile.RootWrite = false
+	return File4Write(&tagFile.IndexFile.File, File4Long(tagFile.HeaderOffset), buf, CDXHeaderSize)
+}
+
+// t4setHeader fills in the header of a new tag from its definition
+func t4setHeader(tagFile *Tag4File, keyLen int, unique, descending bool) {
+	header := &tagFile.Header
+	*header = CdxHeader{
+		KeyLen:    int16(keyLen),
+		TypeCode:  CDXTypeTag,
+		Signature: CDXSignature,
+		ExprPos:   0,
+		ExprLen:   int16(len(tagFile.ExprSource) + 1),
+		FilterLen: int16(len(tagFile.FilterSource) + 1),
+	}
+	header.FilterPos = header.ExprLen
+	if unique {
+		header.TypeCode |= CDXTypeUnique
+	}
+	if tagFile.FilterSource != "" {
+		header.TypeCode |= CDXTypeFor
+	}
+	if descending {
+		header.Descending = 1
+	}
+}
 
-	// Find null terminator
-	end := len(nameBytes)
-	for i, b := range nameBytes {
-		if b == 0 {
-			end = i
+// t4entries returns every entry of a tag in key order
+func t4entries(tagFile *Tag4File) ([]B4Key, int) {
+	// Walk down the left edge of the tree, then along the leaf level
+	offset := tagFile.Header.Root
+	for depth := 0; ; depth++ {
+		block, err := b4read(tagFile, offset)
+		if err != ErrorNone {
+			return nil, err
+		}
+		if b4leaf(block) {
 			break
 		}
+		if len(block.Keys) == 0 || depth > 32 {
+			return nil, ErrorIndex
+		}
+		offset = block.Keys[0].Pointer
 	}
 
-	return string(nameBytes[:end])
+	var entries []B4Key
+	for visited := 0; offset != b4NoNode; visited++ {
+		block, err := b4read(tagFile, offset)
+		if err != ErrorNone {
+			return nil, err
+		}
+		entries = append(entries, block.Keys...)
+		offset = block.RightNode
+		if visited > math.MaxInt32/b4NodeSize {
+			return nil, ErrorIndex
+		}
+	}
+	return entries, ErrorNone
 }
 
 // I4Close closes an index file (mirrors i4close)
@@ -341,28 +331,96 @@ func I4Close(index *Index4) int {
 		return ErrorMemory
 	}
 
+	data := index.Data
+	if data != nil && data.TagSelected != nil && data.TagSelected.Index == index {
+		data.TagSelected = nil
+	}
+
 	if index.IndexFile != nil {
 		File4Close(&index.IndexFile.File)
+		index.IndexFile.IsValid = false
 	}
+	index.IsValid = false
 
-	// Remove from data's index list
-	if index.Data != nil {
-		list4Remove(&index.Data.Indexes, &index.Link)
+	if data != nil {
+		list4Remove(&data.Indexes, &index.Link)
 	}
 
 	return ErrorNone
 }
 
-// D4Index finds an index by name (mirrors d4index)
+// d4closeIndexes closes every index opened for a table
+func d4closeIndexes(data *Data4) {
+	for data.Indexes.NumLinks > 0 {
+		I4Close(indexFromLink(list4First(&data.Indexes)))
+	}
+}
+
+// I4FileName returns the full path of an index file (mirrors i4fileName)
+func I4FileName(index *Index4) string {
+	if index == nil {
+		return ""
+	}
+	name := index.AccessName[:]
+	if nul := bytes.IndexByte(name, 0); nul >= 0 {
+		name = name[:nul]
+	}
+	return string(name)
+}
+
+// i4productionName returns the path of the production index of a table
+func i4productionName(data *Data4) string {
+	dbfPath := D4FileName(data)
+	base := strings.TrimSuffix(dbfPath, filepath.Ext(dbfPath))
+	if strings.HasSuffix(filepath.Ext(dbfPath), "DBF") {
+		return base + ".CDX"
+	}
+	return base + ".cdx"
+}
+
+// i4lookup returns the open index with the given path, nil if none
+func i4lookup(data *Data4, indexPath string) *Index4 {
+	first := list4First(&data.Indexes)
+	for current := first; current != nil; {
+		index := indexFromLink(current)
+		if strings.EqualFold(I4FileName(index), indexPath) {
+			return index
+		}
+		current = list4Next(&data.Indexes, current)
+		if current == first {
+			break
+		}
+	}
+	return nil
+}
+
+// D4Index finds an open index by name (mirrors d4index)
+//
+// The name is matched against the index file name with or without path
+// and extension. An empty name returns the production index.
 func D4Index(data *Data4, indexName string) *Index4 {
-	if data == nil || indexName == "" {
+	if data == nil {
 		return nil
 	}
+	if indexName == "" {
+		return i4lookup(data, i4productionName(data))
+	}
 
-	// TODO: Traverse data's index list to find matching name
-	// For now, attempt to open the index file
-
-	return I4Open(data, indexName)
+	first := list4First(&data.Indexes)
+	for current := first; current != nil; {
+		index := indexFromLink(current)
+		path := I4FileName(index)
+		base := filepath.Base(path)
+		if strings.EqualFold(path, indexName) || strings.EqualFold(base, indexName) ||
+			strings.EqualFold(strings.TrimSuffix(base, filepath.Ext(base)), indexName) {
+			return index
+		}
+		current = list4Next(&data.Indexes, current)
+		if current == first {
+			break
+		}
+	}
+	return nil
 }
 
 // D4Tag finds a tag by name (mirrors d4tag)
@@ -371,79 +429,78 @@ func D4Tag(data *Data4, tagName string) *Tag4 {
 		return nil
 	}
 
-	// Convert to uppercase for comparison (like C implementation)
-	upperTagName := strings.ToUpper(tagName)
-
-	// Search through all tags using d4tagNext pattern (like C d4tag function)
-	var tagOn *Tag4
-	for {
-		tagOn = D4TagNext(data, tagOn)
-		if tagOn == nil {
-			break
+	for tag := D4TagNext(data, nil); tag != nil; tag = D4TagNext(data, tag) {
+		if strings.EqualFold(T4Alias(tag), tagName) {
+			return tag
 		}
+	}
+	return nil
+}
 
-		// Compare tag alias (like C: strcmp( tagOn->tagFile->alias, tagLookup ) == 0)
-		if tagOn.TagFile != nil {
-			tagAlias := T4Alias(tagOn)
-			if strings.ToUpper(tagAlias) == upperTagName {
-				return tagOn
-			}
+// I4Tag finds a tag of an index by name (mirrors i4tag)
+func I4Tag(index *Index4, tagName string) *Tag4 {
+	if index == nil {
+		return nil
+	}
+	first := list4First(&index.Tags)
+	for current := first; current != nil; {
+		tag := tagFromLink(current)
+		if strings.EqualFold(T4Alias(tag), tagName) {
+			return tag
+		}
+		current = list4Next(&index.Tags, current)
+		if current == first {
+			break
 		}
 	}
-
-	// Not found - C implementation would set error based on data.codeBase.errTagName
 	return nil
 }
 
 // I4FirstTag returns the first tag in an index (helper function)
+//
+// Deprecated: use D4TagNext, or I4Tag to find a tag by name.
 func I4FirstTag(index *Index4) *Tag4 {
-	if index == nil || index.IndexFile == nil {
+	if index == nil {
 		return nil
 	}
-
-	if index.IndexFile.Tags.NumLinks > 0 {
-		// Get the first tag from the linked list
-		firstTagLink := list4First(&index.IndexFile.Tags)
-		if firstTagLink != nil {
-			tagFile := tagFileFromLink(firstTagLink)
-			if tagFile != nil {
-				return &Tag4{
-					TagFile:   tagFile,
-					Index:     index,
-					ErrUnique: 0,
-					IsValid:   true,
-				}
-			}
-		}
+	if first := list4First(&index.Tags); first != nil {
+		return tagFromLink(first)
 	}
-
 	return nil
 }
 
 // I4NumTags returns the number of tags in an index (helper function)
 func I4NumTags(index *Index4) int {
-	if index == nil || index.IndexFile == nil {
+	if index == nil {
 		return 0
 	}
-
-	return int(index.IndexFile.Tags.NumLinks)
+	return int(index.Tags.NumLinks)
 }
 
 // D4TagDefault returns the default tag (mirrors d4tagDefault)
+//
+// This is the selected tag, or the first tag of the first index when no
+// tag is selected.
 func D4TagDefault(data *Data4) *Tag4 {
 	if data == nil {
 		return nil
 	}
-
-	return data.TagSelected
+	if data.TagSelected != nil {
+		return data.TagSelected
+	}
+	return D4TagNext(data, nil)
 }
 
 // D4TagSelect sets the active tag (mirrors d4tagSelect)
+//
+// Passing nil selects natural record order.
 func D4TagSelect(data *Data4, tag *Tag4) {
 	if data == nil {
 		return
 	}
-
+	if tag != nil {
+		tag.block = nil
+	}
 	data.TagSelected = tag
 }
 
@@ -452,148 +509,88 @@ func D4TagSelected(data *Data4) *Tag4 {
 	if data == nil {
 		return nil
 	}
-
 	return data.TagSelected
 }
 
 // T4Name returns tag name (mirrors t4name)
 func T4Name(tag *Tag4) string {
-	if tag == nil || tag.TagFile == nil {
-		return ""
-	}
-
-	return string(tag.TagFile.Alias[:])
+	return T4Alias(tag)
 }
 
-// T4Expr returns tag expression (mirrors t4expr)
+// T4Expr returns the source of the tag's key expression (mirrors t4expr)
 func T4Expr(tag *Tag4) string {
 	if tag == nil || tag.TagFile == nil {
 		return ""
 	}
+	return tag.TagFile.ExprSource
+}
 
-	// Extract expression from tag header
-	header := &tag.TagFile.Header
-	if header.ExprPos > 0 && header.ExprLen > 0 {
-		// For now, return a placeholder - full implementation would
-		// read the expression from the CDX file at the specified position
-		return "<expression>" // Simplified
+// T4Filter returns the source of the tag's FOR expression (mirrors t4filter)
+//
+// Returns an empty string if the tag has no filter.
+func T4Filter(tag *Tag4) string {
+	if tag == nil || tag.TagFile == nil {
+		return ""
 	}
-
-	return ""
+	return tag.TagFile.FilterSource
 }
 
-// T4KeyLen returns key length for tag (mirrors t4keyLen)
+// T4KeyLen returns the key length of a tag (mirrors t4keyLen)
 func T4KeyLen(tag *Tag4) int16 {
 	if tag == nil || tag.TagFile == nil {
 		return 0
 	}
-
 	return tag.TagFile.Header.KeyLen
 }
 
-// T4Unique checks if tag is unique (mirrors t4unique)
+// T4Unique returns whether the tag only holds unique keys (mirrors t4unique)
 func T4Unique(tag *Tag4) bool {
 	if tag == nil || tag.TagFile == nil {
 		return false
 	}
-
-	return (tag.TagFile.Header.TypeCode & CDXTypeUnique) != 0
+	return tag.TagFile.Header.TypeCode&CDXTypeUnique != 0
 }
 
-// T4Descending checks if tag is descending (mirrors t4descending)
+// T4Descending returns whether the tag is in descending order (mirrors t4descending)
 func T4Descending(tag *Tag4) bool {
 	if tag == nil || tag.TagFile == nil {
 		return false
 	}
-
 	return tag.TagFile.Header.Descending != 0
 }
 
-// D4TagNext gets next tag in sequence (mirrors d4tagNext)
+// D4TagNext iterates over the tags of every open index (mirrors d4tagNext)
 //
-//nolint:gocyclo // TODO: refactor to reduce complexity by extracting tag traversal logic
+// Passing nil returns the first tag. Returns nil after the last tag.
 func D4TagNext(data *Data4, tag *Tag4) *Tag4 {
 	if data == nil {
 		return nil
 	}
 
-	var i4 *Index4
-	var tagOn = tag
-
-	if tagOn == nil {
-		// Get first index
-		i4 = indexFromLink(list4First(&data.Indexes))
-		if i4 == nil {
-			return nil
-		}
-	} else {
-		// Find the index containing the current tag
-		i4 = nil
-		current := list4First(&data.Indexes)
-		for current != nil {
-			indexCandidate := indexFromLink(current)
-			if indexCandidate == tagOn.Index {
-				i4 = indexCandidate
-				break
-			}
-			current = list4Next(&data.Indexes, current)
-			if current == list4First(&data.Indexes) {
-				break
-			}
+	firstIndex := list4First(&data.Indexes)
+	current := firstIndex
+	if tag != nil {
+		// Continue within the tag's own index first
+		index := tag.Index
+		if tag.Link.Next != nil && &tag.Link != index.Tags.LastNode {
+			return tagFromLink(tag.Link.Next)
 		}
-		if i4 == nil {
+		if &index.Link == data.Indexes.LastNode {
 			return nil
 		}
+		current = list4Next(&data.Indexes, &index.Link)
 	}
 
-	// Get next tag in current index
-	if i4.IndexFile != nil {
-		var nextTagLink *Link4
-		if tagOn == nil {
-			// Get first tag of first index
-			nextTagLink = list4First(&i4.IndexFile.Tags)
-		} else {
-			// Get next tag in current index
-			currentTagLink := &tagOn.TagFile.Link
-			nextTagLink = list4Next(&i4.IndexFile.Tags, currentTagLink)
-		}
-
-		if nextTagLink != nil && nextTagLink != list4First(&i4.IndexFile.Tags) {
-			// Found next tag in current index
-			tagFile := tagFileFromLink(nextTagLink)
-			if tagFile != nil {
-				return &Tag4{
-					TagFile:   tagFile,
-					Index:     i4,
-					ErrUnique: 0,
-					IsValid:   true,
-				}
-			}
-		} else {
-			// Move to next index
-			currentIndexLink := &i4.Link
-			nextIndexLink := list4Next(&data.Indexes, currentIndexLink)
-			if nextIndexLink != nil && nextIndexLink != list4First(&data.Indexes) {
-				nextIndex := indexFromLink(nextIndexLink)
-				if nextIndex != nil && nextIndex.IndexFile != nil {
-					// Get first tag of next index
-					firstTagLink := list4First(&nextIndex.IndexFile.Tags)
-					if firstTagLink != nil {
-						tagFile := tagFileFromLink(firstTagLink)
-						if tagFile != nil {
-							return &Tag4{
-								TagFile:   tagFile,
-								Index:     nextIndex,
-								ErrUnique: 0,
-								IsValid:   true,
-							}
-						}
-					}
-				}
-			}
+	for current != nil {
+		index := indexFromLink(current)
+		if first := list4First(&index.Tags); first != nil {
+			return tagFromLink(first)
+		}
+		if current == data.Indexes.LastNode {
+			break
 		}
+		current = list4Next(&data.Indexes, current)
 	}
-
 	return nil
 }
 
@@ -607,2430 +604,1170 @@ func T4Alias(tag *Tag4) string {
 
 // T4ExprSource returns tag expression source (mirrors t4exprSource)
 func T4ExprSource(tag *Tag4) string {
-	if tag == nil || tag.TagFile == nil {
-		return ""
-	}
-	return tag.TagFile.ExprSource
-}
-
-// Helper functions
-//
-//nolint:unused // Future use
-func getIndexes(data *Data4) []*Index4 {
-	var indexes []*Index4
-	link := data.Indexes.LastNode
-	for link != nil {
-		index := (*Index4)(unsafe.Pointer(link))
-		if index != nil {
-			indexes = append(indexes, index)
-		}
-		link = link.Next
-		if link == data.Indexes.LastNode {
-			break // Circular list
-		}
-	}
-	return indexes
-}
-
-//nolint:unused // Future use
-func getTags(index *Index4) []*Tag4 {
-	var tags []*Tag4
-	if index.IndexFile == nil {
-		return tags
-	}
-
-	link := index.IndexFile.Tags.LastNode
-	for link != nil {
-		tagFile := (*Tag4File)(unsafe.Pointer(link))
-		if tagFile != nil {
-			// Create a Tag4 wrapper for this Tag4File
-			tag := &Tag4{
-				Index:   index,
-				TagFile: tagFile,
-				IsValid: true,
-			}
-			tags = append(tags, tag)
-		}
-		link = link.Next
-		if link == index.IndexFile.Tags.LastNode {
-			break // Circular list
-		}
-	}
-	return tags
+	return T4Expr(tag)
 }
 
-// D4Seek performs an indexed seek using B+ tree navigation (mirrors d4seek)
-func D4Seek(data *Data4, seekValue string) int {
-	if data == nil || data.TagSelected == nil {
-		return ErrorMemory
-	}
-
-	// Reset seek status
-	data.lastSeekFound = false
-
-	// Get the selected tag
-	tag := data.TagSelected
-	if tag == nil || tag.TagFile == nil {
-		return ErrorMemory
-	}
-
-	// Perform B+ tree seek
-	seekResult, recNo := b4Seek(tag, seekValue)
-
-	switch seekResult {
-	case R4Success:
-		// Exact match found
-		data.lastSeekFound = true
-		return D4GoPosition(data, recNo)
-
-	case R4Found:
-		// Key found (for duplicate handling)
-		data.lastSeekFound = true
-		return D4GoPosition(data, recNo)
-
-	case R4After:
-		// Position after the seek value
-		data.lastSeekFound = false
-		if recNo > 0 {
-			return D4GoPosition(data, recNo)
-		}
-		return R4After
-
-	case R4Eof:
-		// Past end of file
-		data.lastSeekFound = false
-		data.atEOF = true
-		return R4Eof
-
-	default:
-		data.lastSeekFound = false
-		return seekResult
+// getTagName converts a NUL padded tag name to a string
+func getTagName(nameBytes []byte) string {
+	if nul := bytes.IndexByte(nameBytes, 0); nul >= 0 {
+		nameBytes = nameBytes[:nul]
 	}
+	return strings.TrimSpace(string(nameBytes))
 }
 
-// D4SeekDouble performs numeric indexed seek (mirrors d4seekDouble)
-func D4SeekDouble(data *Data4, seekValue float64) int {
-	if data == nil || data.TagSelected == nil {
-		return ErrorMemory
+// indexFromLink returns the INDEX4 owning a link
+func indexFromLink(link *Link4) *Index4 {
+	if link == nil {
+		return nil
 	}
-
-	// Format the double value to match the key format
-	// Visual FoxPro numeric keys are typically right-aligned with spaces
-	seekStr := fmt.Sprintf("%*.2f", data.TagSelected.TagFile.Header.KeyLen, seekValue)
-
-	return D4Seek(data, seekStr)
+	// Link is the first field of Index4
+	return (*Index4)(unsafe.Pointer(link))
 }
 
-// D4SeekN performs partial indexed seek (mirrors d4seekN)
-func D4SeekN(data *Data4, seekValue string, length int16) int {
-	if data == nil || data.TagSelected == nil || length <= 0 {
-		return ErrorMemory
-	}
-
-	// Truncate seek value to specified length
-	if len(seekValue) > int(length) {
-		seekValue = seekValue[:length]
+// tagFromLink returns the TAG4 owning a link
+func tagFromLink(link *Link4) *Tag4 {
+	if link == nil {
+		return nil
 	}
-
-	return D4Seek(data, seekValue)
+	// Link is the first field of Tag4
+	return (*Tag4)(unsafe.Pointer(link))
 }
 
-// D4Found checks if last seek was successful (mirrors found())
-func D4Found(data *Data4) bool {
-	if data == nil {
-		return false
+// tagFileFromLink returns the TAG4FILE owning a link
+func tagFileFromLink(link *Link4) *Tag4File {
+	if link == nil {
+		return nil
 	}
-	return data.lastSeekFound
+	// Link is the first field of Tag4File
+	return (*Tag4File)(unsafe.Pointer(link))
 }
 
-// Auto-open production index support
-//
-//nolint:unparam // TODO: implement proper error handling - currently always returns 0
+// autoOpenProductionIndex opens the production index of a table when it
+// exists (mirrors the production index handling of d4open). No tag is
+// selected, so the table stays in natural order.
 func autoOpenProductionIndex(data *Data4) int {
 	if data == nil || !data.CodeBase.AutoOpen {
 		return ErrorNone
 	}
 
-	// Construct production index name (same base name as DBF with .CDX)
-	dbfPath := D4FileName(data)
-	if dbfPath == "" {
-		return ErrorNone
-	}
-
-	baseName := strings.TrimSuffix(dbfPath, filepath.Ext(dbfPath))
-	cdxPath := baseName + ".cdx"
-
-	// Check if production index exists
+	cdxPath := i4productionName(data)
 	if _, err := os.Stat(cdxPath); err != nil {
-		return ErrorNone // No production index, not an error
-	}
-
-	// Open the production index
-	index := I4Open(data, cdxPath)
-	if index == nil {
-		return ErrorNone // Failed to open, not fatal
-	}
-
-	// Set default tag if available - use proper tag enumeration
-	if index != nil && index.IndexFile != nil && index.IndexFile.Tags.NumLinks > 0 {
-		// Get first tag using D4TagNext instead of unsafe pointer casting
-		firstTag := D4TagNext(data, nil)
-		if firstTag != nil {
-			data.TagSelected = firstTag
+		// Try the other case of the extension before giving up
+		ext := filepath.Ext(cdxPath)
+		alternative := strings.TrimSuffix(cdxPath, ext) + strings.ToLower(ext)
+		if alternative == cdxPath {
+			alternative = strings.TrimSuffix(cdxPath, ext) + strings.ToUpper(ext)
 		}
+		if _, err := os.Stat(alternative); err != nil {
+			return ErrorNone // No production index, not an error
+		}
+		cdxPath = alternative
 	}
 
+	if I4Open(data, cdxPath) == nil {
+		// A damaged index must not prevent the table from opening
+		setError(data.CodeBase, ErrorNone)
+	}
 	return ErrorNone
 }
 
-// b4Seek performs B+ tree navigation to find a key
-func b4Seek(tag *Tag4, seekValue string) (int, int32) {
-	if tag == nil || tag.TagFile == nil {
-		return ErrorMemory, 0
-	}
-
-	// Start from root block
-	rootBlock := tag.TagFile.Header.Root
-	if rootBlock <= 0 {
-		return R4Eof, 0
-	}
+// ---------------------------------------------------------------------------
+// Index creation
+// ---------------------------------------------------------------------------
 
-	// Navigate down the B+ tree
-	currentBlock := rootBlock
-	for {
-		// Read the block
-		block, err := b4ReadBlock(tag.TagFile.IndexFile, currentBlock, tag.TagFile.Header.KeyLen)
-		if err != ErrorNone {
-			return err, 0
+// I4Create creates a compound index and builds its tags from the current
+// contents of the table (mirrors i4create).
+//
+// An empty fileName creates the production index, which is flagged in the
+// table header so that it is opened together with the table. An existing
+// index file of the same name is replaced unless CODE4 safety is on.
+//
+// Returns the new index, nil on failure (invalid tag definitions,
+// duplicate tag names or file errors).
+func I4Create(data *Data4, fileName string, tagInfo []Tag4Info) *Index4 {
+	if data == nil || data.DataFile == nil || len(tagInfo) == 0 {
+		return nil
+	}
+	cb := data.CodeBase
+
+	production := fileName == ""
+	indexPath := fileName
+	if production {
+		indexPath = i4productionName(data)
+	} else if filepath.Ext(indexPath) == "" {
+		indexPath += ".cdx"
+	}
+
+	if existing := i4lookup(data, indexPath); existing != nil {
+		I4Close(existing)
+	}
+	if _, err := os.Stat(indexPath); err == nil {
+		if cb.Safety != 0 {
+			setError(cb, ErrorCreate)
+			return nil
 		}
+		os.Remove(indexPath)
+	}
 
-		// Search within the block
-		keyIndex, found := b4SearchBlock(block, seekValue)
+	index := &Index4{Data: data, CodeBase: cb}
+	copy(index.AccessName[:], indexPath)
+	index.IndexFile = &Index4File{CodeBase: cb, DataFile: data.DataFile}
 
-		// If this is a leaf block
-		if block.BlockType == 0x00 { // Leaf block
-			if found {
-				// Exact match in leaf
-				return R4Success, block.Keys[keyIndex].RecNo
-			}
-			if keyIndex < len(block.Keys) {
-				// Position after the key
-				return R4After, block.Keys[keyIndex].RecNo
-			}
-			// Past end of block
-			return R4Eof, 0
+	var tagFiles []*Tag4File
+	for _, info := range tagInfo {
+		tagFile := t4create(index, info)
+		if tagFile == nil {
+			setError(cb, ErrorIndex)
+			return nil
 		}
-		// Branch block - follow pointer to child
-		if keyIndex < len(block.Pointers) {
-			currentBlock = block.Pointers[keyIndex]
-		} else {
-			return R4Eof, 0
+		for _, other := range tagFiles {
+			if strings.EqualFold(getTagName(other.Alias[:]), getTagName(tagFile.Alias[:])) {
+				setError(cb, ErrorIndex)
+				return nil
+			}
 		}
+		tagFiles = append(tagFiles, tagFile)
 	}
-}
 
-// b4ReadBlock reads a B+ tree block from the index file
-func b4ReadBlock(indexFile *Index4File, blockNo int32, keyLen int16) (*B4Block, int) {
-	if indexFile == nil || blockNo <= 0 {
-		return nil, ErrorMemory
+	if File4Create(&index.IndexFile.File, cb, indexPath, 1) != ErrorNone {
+		setError(cb, ErrorCreate)
+		return nil
 	}
-
-	// Calculate file position (blocks are 512 bytes)
-	filePos := int64(blockNo) * CDXBlockSize
-
-	// Read block data
-	blockData := make([]byte, CDXBlockSize)
-	bytesRead := File4Read(&indexFile.File, filePos, blockData, CDXBlockSize)
-	if bytesRead != CDXBlockSize {
-		return nil, ErrorRead
+	for _, tagFile := range tagFiles {
+		i4addTag(index, tagFile)
 	}
 
-	// Parse block header (FoxPro CDX format)
-	block := &B4Block{
-		BlockNo:   blockNo,
-		BlockType: blockData[0],
-		NumKeys:   int16(binary.LittleEndian.Uint16(blockData[2:4])),
-		KeyLen:    keyLen,
-		Data:      blockData,
+	if err := i4rebuild(index); err != ErrorNone {
+		File4Close(&index.IndexFile.File)
+		os.Remove(indexPath)
+		setError(cb, err)
+		return nil
 	}
 
-	// Parse keys from block
-	if block.NumKeys > 0 {
-		block.Keys = make([]B4Key, block.NumKeys)
-		if block.BlockType == 0x00 { // Leaf block
-			offset := 4 // Start after header
-			for i := int16(0); i < block.NumKeys; i++ {
-				key := &block.Keys[i]
-
-				// Extract key data (format depends on key type)
-				key.KeyData = make([]byte, keyLen)
-				copy(key.KeyData, blockData[offset:offset+int(keyLen)])
-				offset += int(keyLen)
+	index.IndexFile.IsValid = true
+	index.IndexFile.UserCount = 1
+	index.IsValid = true
+	list4Add(&data.Indexes, &index.Link)
 
-				// Extract record number (4 bytes)
-				if offset+4 <= len(blockData) {
-					key.RecNo = int32(binary.LittleEndian.Uint32(blockData[offset : offset+4]))
-					offset += 4
-				}
-			}
-		} else { // Branch block
-			// Branch blocks have pointers to child blocks
-			block.Pointers = make([]int32, block.NumKeys+1)
-			offset := 4
-
-			// First pointer
-			block.Pointers[0] = int32(binary.LittleEndian.Uint32(blockData[offset : offset+4]))
-			offset += 4
-
-			// Keys and pointers
-			for i := int16(0); i < block.NumKeys; i++ {
-				key := &block.Keys[i]
-				key.KeyData = make([]byte, keyLen)
-				copy(key.KeyData, blockData[offset:offset+int(keyLen)])
-				offset += int(keyLen)
-
-				// Pointer to next child
-				if offset+4 <= len(blockData) {
-					block.Pointers[i+1] = int32(binary.LittleEndian.Uint32(blockData[offset : offset+4]))
-					offset += 4
-				}
-			}
-		}
+	if production {
+		d4productionFlag(data.DataFile, true)
 	}
-
-	return block, ErrorNone
+	return index
 }
 
-// b4SearchBlock searches for a key within a B+ tree block
-func b4SearchBlock(block *B4Block, seekValue string) (int, bool) {
-	if block == nil || len(block.Keys) == 0 {
-		return 0, false
+// t4create validates a tag definition and sets up its tag file
+func t4create(index *Index4, info Tag4Info) *Tag4File {
+	name := strings.ToUpper(strings.TrimSpace(info.Name))
+	if name == "" || len(name) > CDXTagNameLen {
+		return nil
 	}
 
-	// Binary search within the block
-	left := 0
-	right := len(block.Keys) - 1
-
-	for left <= right {
-		mid := (left + right) / 2
-		keyValue := strings.TrimSpace(string(block.Keys[mid].KeyData))
+	tagFile := &Tag4File{
+		IndexFile:    index.IndexFile,
+		CodeBase:     index.CodeBase,
+		ExprSource:   strings.TrimSpace(info.Expression),
+		FilterSource: strings.TrimSpace(info.Filter),
+	}
+	copy(tagFile.Alias[:], name)
 
-		cmp := strings.Compare(seekValue, keyValue)
-		if cmp == 0 {
-			return mid, true // Exact match
-		} else if cmp < 0 {
-			right = mid - 1
-		} else {
-			left = mid + 1
-		}
+	t4initExpr(tagFile, index.Data)
+	keyLen := Expr4KeyLen(tagFile.Expr)
+	if tagFile.Expr == nil || keyLen < 0 {
+		return nil
+	}
+	if tagFile.FilterSource != "" && (tagFile.Filter == nil || Expr4Type(tagFile.Filter) != Expr4TypeLogical) {
+		return nil
+	}
+	if len(tagFile.ExprSource)+len(tagFile.FilterSource)+2 > CDXExprPoolSize {
+		return nil
 	}
 
-	// Return insertion point
-	return left, false
+	t4setHeader(tagFile, keyLen, info.Unique != 0, info.Descending != 0)
+	return tagFile
 }
 
-// D4GoPosition positions the database at a specific record number
-func D4GoPosition(data *Data4, recNo int32) int {
-	if data == nil || recNo <= 0 {
+// I4Reindex rebuilds every tag of an index from the table (mirrors i4reindex)
+func I4Reindex(index *Index4) int {
+	if index == nil || index.IndexFile == nil || index.Data == nil {
 		return ErrorMemory
 	}
-
-	// Simple implementation - use D4Go
-	return D4Go(data, recNo)
+	if err := i4rebuild(index); err != ErrorNone {
+		return setError(index.CodeBase, err)
+	}
+	return ErrorNone
 }
 
-// D4SeekNext seeks the next occurrence of a key (mirrors d4seekNext)
-func D4SeekNext(data *Data4, seekValue string) int {
-	if data == nil || seekValue == "" {
-		return ErrorMemory
+// d4reindexOpen rebuilds every open index of a table
+func d4reindexOpen(data *Data4) int {
+	result := ErrorNone
+	first := list4First(&data.Indexes)
+	for current := first; current != nil; {
+		if err := I4Reindex(indexFromLink(current)); err != ErrorNone && result == ErrorNone {
+			result = err
+		}
+		current = list4Next(&data.Indexes, current)
+		if current == first {
+			break
+		}
 	}
-
-	return D4SeekNextN(data, seekValue, int16(len(seekValue)))
+	return result
 }
 
-// D4SeekNextDouble seeks the next occurrence of a numeric key
-func D4SeekNextDouble(data *Data4, seekValue float64) int {
-	if data == nil || data.TagSelected == nil {
+// D4Reindex rebuilds every open index of a table (mirrors d4reindex)
+//
+// The table is locked for the rebuild unless its file lock is already held.
+func D4Reindex(data *Data4) int {
+	if data == nil || data.DataFile == nil {
 		return ErrorMemory
 	}
-
-	// Check current position and key value
-	currentKey := d4getCurrentKey(data)
-	seekStr := fmt.Sprintf("%*.2f", data.TagSelected.TagFile.Header.KeyLen, seekValue)
-
-	// If we're already on a matching key, skip to the next occurrence
-	if strings.TrimSpace(currentKey) == strings.TrimSpace(seekStr) {
-		// Skip to next record in index order
-		err := d4seekNextSkip(data)
-		if err != ErrorNone {
+	if err := d4updateRecord(data); err != ErrorNone {
+		return err
+	}
+	if !D4LockTestFile(data) {
+		if err := D4LockAll(data); err != ErrorNone {
 			return err
 		}
-
-		// Check if we're still on a matching key
-		newKey := d4getCurrentKey(data)
-		if strings.TrimSpace(newKey) == strings.TrimSpace(seekStr) {
-			return R4Success
-		}
-		return R4After // Moved past the matching keys
+		defer D4Unlock(data)
 	}
-
-	// Otherwise, perform regular seek
-	return D4SeekDouble(data, seekValue)
+	return d4reindexOpen(data)
 }
 
-// D4SeekNextN seeks the next occurrence of a partial key
-func D4SeekNextN(data *Data4, seekValue string, length int16) int {
-	if data == nil || data.TagSelected == nil {
+// I4TagAdd adds a tag to an open index and rebuilds the index from the
+// table (mirrors i4tagAdd)
+//
+// A nil index adds the tag to the production index of the table, creating
+// it when the table has none. Changing the tags of an index cannot be
+// rolled back, so it fails inside a transaction.
+//
+// Returns ErrorNone on success, ErrorIndex for an invalid or duplicate tag.
+func I4TagAdd(data *Data4, index *Index4, info Tag4Info) int {
+	if data == nil || data.DataFile == nil {
 		return ErrorMemory
 	}
-
-	tag := data.TagSelected
-	if tag == nil || tag.TagFile == nil {
-		return ErrorMemory
+	if d4transActive(data) {
+		return ErrorData
+	}
+	if err := d4updateRecord(data); err != ErrorNone {
+		return err
+	}
+	if !D4LockTestFile(data) {
+		if err := D4LockAll(data); err != ErrorNone {
+			return err
+		}
+		defer D4Unlock(data)
 	}
 
-	// Validate and adjust length
-	seekLen := int(length)
-	if seekLen <= 0 {
-		seekLen = len(seekValue)
+	if index == nil {
+		index = D4Index(data, "")
 	}
-	if seekLen > len(seekValue) {
-		seekLen = len(seekValue)
+	if index == nil {
+		if _, err := os.Stat(i4productionName(data)); err == nil {
+			index = I4Open(data, "")
+		}
 	}
-	if seekLen > int(tag.TagFile.Header.KeyLen) {
-		seekLen = int(tag.TagFile.Header.KeyLen)
+	if index == nil {
+		// No production index, or one too damaged to open
+		setError(data.CodeBase, ErrorNone)
+		os.Remove(i4productionName(data))
+		if I4Create(data, "", []Tag4Info{info}) == nil {
+			return ErrorIndex
+		}
+		return ErrorNone
 	}
 
-	truncatedSeek := seekValue
-	if len(seekValue) > seekLen {
-		truncatedSeek = seekValue[:seekLen]
+	if D4Tag(data, strings.TrimSpace(info.Name)) != nil {
+		return setError(data.CodeBase, ErrorIndex)
 	}
-
-	// Get current key value for comparison
-	currentKey := d4getCurrentKey(data)
-	if len(currentKey) > seekLen {
-		currentKey = currentKey[:seekLen]
+	tagFile := t4create(index, info)
+	if tagFile == nil {
+		return setError(data.CodeBase, ErrorIndex)
 	}
+	tag := i4addTag(index, tagFile)
+	if err := i4rebuild(index); err != ErrorNone {
+		i4removeTag(index, tag)
+		i4rebuild(index)
+		return setError(data.CodeBase, err)
+	}
+	return ErrorNone
+}
 
-	// Check if current record matches the seek value
-	if strings.EqualFold(strings.TrimSpace(currentKey), strings.TrimSpace(truncatedSeek)) {
-		// We're on a matching record, skip to next occurrence
-		err := d4seekNextSkip(data)
-		if err != ErrorNone {
+// I4TagRemove removes a tag from its index and rebuilds the index
+// (mirrors i4tagRemove)
+//
+// Removing the last tag of an index deletes the index file; for the
+// production index the flag in the table header is cleared as well, as
+// Visual FoxPro does. Fails inside a transaction.
+func I4TagRemove(tag *Tag4) int {
+	if tag == nil || tag.Index == nil || tag.Index.Data == nil {
+		return ErrorMemory
+	}
+	index := tag.Index
+	data := index.Data
+	if d4transActive(data) {
+		return ErrorData
+	}
+	if err := d4updateRecord(data); err != ErrorNone {
+		return err
+	}
+	if !D4LockTestFile(data) {
+		if err := D4LockAll(data); err != ErrorNone {
 			return err
 		}
+		defer D4Unlock(data)
+	}
 
-		// Check if the new position still matches
-		newKey := d4getCurrentKey(data)
-		if len(newKey) > seekLen {
-			newKey = newKey[:seekLen]
-		}
+	if data.TagSelected == tag {
+		data.TagSelected = nil
+	}
+	i4removeTag(index, tag)
 
-		if strings.EqualFold(strings.TrimSpace(newKey), strings.TrimSpace(truncatedSeek)) {
-			data.lastSeekFound = true
-			return R4Success
+	if index.Tags.NumLinks > 0 {
+		if err := i4rebuild(index); err != ErrorNone {
+			return setError(data.CodeBase, err)
 		}
-		data.lastSeekFound = false
-		return R4After // Moved past the matching keys
+		return ErrorNone
 	}
 
-	// Current record doesn't match, perform regular seek
-	return D4SeekN(data, seekValue, length)
-}
-
-// Helper functions for proper linked list traversal
-
-// indexFromLink converts a Link4 pointer back to Index4
-func indexFromLink(link *Link4) *Index4 {
-	if link == nil {
-		return nil
+	indexPath := I4FileName(index)
+	production := strings.EqualFold(indexPath, i4productionName(data))
+	I4Close(index)
+	if err := os.Remove(indexPath); err != nil {
+		return setError(data.CodeBase, ErrorWrite)
 	}
-	// Calculate offset from Link4 to containing Index4 struct
-	// Link4 is the first field in Index4, so no offset needed
-	return (*Index4)(unsafe.Pointer(link))
+	if production {
+		d4productionFlag(data.DataFile, false)
+	}
+	return ErrorNone
 }
 
-// tagFileFromLink converts a Link4 pointer back to Tag4File
-func tagFileFromLink(link *Link4) *Tag4File {
-	if link == nil {
-		return nil
-	}
-	// Calculate offset from Link4 to containing Tag4File struct
-	// Link4 is the first field in Tag4File, so no offset needed
-	return (*Tag4File)(unsafe.Pointer(link))
+// i4removeTag unlinks a tag and its tag file from an index
+func i4removeTag(index *Index4, tag *Tag4) {
+	list4Remove(&index.Tags, &tag.Link)
+	list4Remove(&index.IndexFile.Tags, &tag.TagFile.Link)
+	tag.IsValid = false
 }
 
-// I4Create creates a new index file with the specified tags (mirrors i4create)
-func I4Create(data *Data4, fileName string, tagInfo []Tag4Info) *Index4 {
-	if data == nil {
-		return nil
-	}
+// i4rebuild rewrites the whole index file: the tag directory header, one
+// header per tag, the directory tree and then every tag tree built from
+// the current table contents
+func i4rebuild(index *Index4) int {
+	indexFile := index.IndexFile
+	data := index.Data
 
-	c4 := data.CodeBase
-	if c4.ErrorCode < 0 {
-		return nil
+	if err := d4updateRecord(data); err != ErrorNone {
+		return err
 	}
 
-	// Construct index file name
-	var indexPath string
-	if fileName == "" {
-		// Production index - use DBF name with CDX extension
-		dbfPath := D4FileName(data)
-		baseName := strings.TrimSuffix(dbfPath, filepath.Ext(dbfPath))
-		indexPath = baseName + ".cdx"
-	} else {
-		indexPath = fileName
-		if filepath.Ext(indexPath) == "" {
-			indexPath += ".cdx"
+	var tagFiles []*Tag4File
+	first := list4First(&indexFile.Tags)
+	for current := first; current != nil; {
+		tagFiles = append(tagFiles, tagFileFromLink(current))
+		current = list4Next(&indexFile.Tags, current)
+		if current == first {
+			break
 		}
 	}
 
-	// Check if index already exists
-	if dfile4Index(data.DataFile, indexPath) != nil {
-		// Index already exists
-		return nil
+	i4cacheClear(indexFile)
+	if File4Truncate(&indexFile.File, 0) != ErrorNone {
+		return ErrorWrite
 	}
 
-	// Create INDEX4 structure
-	index := &Index4{
-		Data:     data,
-		CodeBase: c4,
-		IsValid:  false,
+	directory := &Tag4File{
+		IndexFile: indexFile,
+		CodeBase:  index.CodeBase,
+		KeyType:   Expr4TypeChar,
+		Header: CdxHeader{
+			KeyLen:    CDXTagNameLen,
+			TypeCode:  CDXTypeIndex,
+			Signature: CDXSignature,
+			ExprLen:   1,
+			FilterPos: 1,
+			FilterLen: 1,
+		},
 	}
-
-	copy(index.AccessName[:], indexPath)
-
-	// Create INDEX4FILE structure
-	indexFile := &Index4File{
-		CodeBase: c4,
-		DataFile: data.DataFile,
-		IsValid:  false,
+	if indexFile.TagIndex != nil {
+		directory.Header.Version = indexFile.TagIndex.Header.Version
 	}
+	indexFile.TagIndex = directory
 
-	// Create the actual file
-	err := File4Create(&indexFile.File, c4, indexPath, 1)
-	if err != ErrorNone {
-		return nil
-	}
+	// Reserve the headers before any node is allocated
+	i4extend(indexFile, 2*(len(tagFiles)+1))
 
-	// Initialize CDX file header
-	err = i4createCdxHeader(indexFile, tagInfo)
-	if err != ErrorNone {
-		File4Close(&indexFile.File)
-		return nil
+	names := make([]B4Key, len(tagFiles))
+	for i, tagFile := range tagFiles {
+		tagFile.HeaderOffset = int32((i + 1) * CDXHeaderSize)
+		name := make([]byte, CDXTagNameLen)
+		copy(name, getTagName(tagFile.Alias[:]))
+		for j := len(getTagName(tagFile.Alias[:])); j < CDXTagNameLen; j++ {
+			name[j] = ' '
+		}
+		names[i] = B4Key{Key: name, RecNo: tagFile.HeaderOffset}
+	}
+	sort.Slice(names, func(i, j int) bool {
+		return b4compare(names[i].Key, names[i].RecNo, names[j].Key, names[j].RecNo) < 0
+	})
+	if err := t4build(directory, names); err != ErrorNone {
+		return err
 	}
 
-	// Create tag structures
-	err = i4createTags(indexFile, data, tagInfo)
+	keys, err := t4collect(data, tagFiles)
 	if err != ErrorNone {
-		File4Close(&indexFile.File)
-		return nil
+		return err
 	}
-
-	// Set up the structures
-	index.IndexFile = indexFile
-	indexFile.IsValid = true
-	index.IsValid = true
-
-	// Add to data's index list
-	list4Add(&data.Indexes, &index.Link)
-	// Note: In C implementation, index files are also added to data file's index list
-
-	return index
-}
-
-// i4createCdxHeader initializes the CDX file header
-//
-//nolint:unparam // TODO: use tagInfo parameter to populate CDX header with tag metadata
-func i4createCdxHeader(indexFile *Index4File, tagInfo []Tag4Info) int {
-	// CDX files start with tag descriptors, not a global header
-	// Write initial tag directory (512 bytes)
-	headerBuf := make([]byte, CDXHeaderSize)
-
-	// Initialize tag directory with zeros
-	memset(headerBuf, 0, CDXHeaderSize)
-
-	// Write the header block
-	bytesWritten := File4Write(&indexFile.File, 0, headerBuf, CDXHeaderSize)
-	if bytesWritten != CDXHeaderSize {
-		return ErrorWrite
+	for i, tagFile := range tagFiles {
+		tagFile.Header.Root = 0
+		tagFile.Header.FreeList = 0
+		if err := t4build(tagFile, keys[i]); err != ErrorNone {
+			return err
+		}
 	}
 
-	return ErrorNone
+	return File4Flush(&indexFile.File)
 }
 
-// i4createTags creates tag structures for the new index
-func i4createTags(indexFile *Index4File, data *Data4, tagInfo []Tag4Info) int {
-	var tags []*Tag4
-
-	// Process each tag
-	for i, info := range tagInfo {
-		if info.Name == "" || info.Expression == "" {
-			continue
-		}
-
-		// Create TAG4FILE structure
-		tagFile := &Tag4File{
-			CodeBase:  indexFile.CodeBase,
-			IndexFile: indexFile,
-		}
-
-		// Set tag name
-		copy(tagFile.Alias[:], strings.ToUpper(info.Name))
-
-		// Parse expression (simplified for now)
-		tagFile.ExprSource = info.Expression
-		if info.Filter != "" {
-			tagFile.FilterSource = info.Filter
-		}
+// t4collect evaluates the keys of every record for the given tags in a
+// single pass over the table. Records excluded by a tag's FOR expression
+// are left out and unique tags keep only the first record of each key.
+// The keys of each tag are returned sorted.
+func t4collect(data *Data4, tagFiles []*Tag4File) ([][]B4Key, int) {
+	keys := make([][]B4Key, len(tagFiles))
+	dataFile := data.DataFile
 
-		// Set up basic header
-		tagFile.Header = CdxHeader{
-			Root:      int32(1 + i), // Temporary root block assignment
-			Signature: CDXSignature,
-			TypeCode:  CDXTypeCompact,
-		}
+	// Evaluate against a scratch copy of the record so the current record
+	// buffer is left untouched
+	saved := data.Record
+	data.Record = make([]byte, len(saved))
+	defer func() { data.Record = saved }()
 
-		// Handle unique flag
-		if info.Unique != 0 {
-			tagFile.Header.TypeCode |= CDXTypeUnique
+	recordLen := int64(dataFile.RecordLen)
+	for recNo := int32(1); recNo <= dataFile.Header.NumRecs; recNo++ {
+		pos := int64(dataFile.Header.HeaderLen) + int64(recNo-1)*recordLen
+		if File4Read(&dataFile.File, pos, data.Record, uint32(recordLen)) != uint32(recordLen) {
+			return nil, ErrorRead
 		}
-
-		// Handle descending flag
-		if info.Descending != 0 {
-			tagFile.Header.Descending = 1
+		savedRecNo := data.recNo
+		data.recNo = recNo
+		for i, tagFile := range tagFiles {
+			if tagFile.Filter != nil && !Expr4True(tagFile.Filter) {
+				continue
+			}
+			keys[i] = append(keys[i], B4Key{Key: t4exprKey(tagFile, tagFile.Expr), RecNo: recNo})
 		}
+		data.recNo = savedRecNo
+	}
 
-		// Determine key length and type (simplified)
-		tagFile.Header.KeyLen = i4calculateKeyLength(info.Expression, data)
-
-		// Create TAG4 wrapper
-		tag := &Tag4{
-			TagFile:   tagFile,
-			Index:     nil, // Will be set by caller
-			ErrUnique: 0,
-			IsValid:   true,
+	for i, tagFile := range tagFiles {
+		tagKeys := keys[i]
+		sort.SliceStable(tagKeys, func(a, b int) bool {
+			return b4compare(tagKeys[a].Key, tagKeys[a].RecNo, tagKeys[b].Key, tagKeys[b].RecNo) < 0
+		})
+		if tagFile.Header.TypeCode&CDXTypeUnique != 0 {
+			unique := tagKeys[:0]
+			for _, key := range tagKeys {
+				if len(unique) == 0 || !bytes.Equal(unique[len(unique)-1].Key, key.Key) {
+					unique = append(unique, key)
+				}
+			}
+			tagKeys = unique
 		}
-
-		// Add to lists
-		list4Add(&indexFile.Tags, &tagFile.Link)
-		tags = append(tags, tag)
+		keys[i] = tagKeys
 	}
-
-	// Write tag descriptors to file
-	return i4writeTagDescriptors(indexFile, tags)
+	return keys, ErrorNone
 }
 
-// i4calculateKeyLength determines the key length for an expression
-func i4calculateKeyLength(expression string, data *Data4) int16 {
-	// Simplified key length calculation
-	// In a full implementation, this would parse the expression
-	// and determine the actual key length based on field types
-
-	// For now, return a default length based on common patterns
-	if strings.Contains(strings.ToUpper(expression), "STR(") {
-		return 10 // Numeric to string conversion
-	}
-	if strings.Contains(strings.ToUpper(expression), "DTOS(") {
-		return 8 // Date to string conversion
+// t4exprKey evaluates the key expression of a tag for the current record
+// buffer
+func t4exprKey(tagFile *Tag4File, expr *Expr4) []byte {
+	keyLen := int(tagFile.Header.KeyLen)
+	key := Expr4Key(expr)
+	if len(key) == keyLen {
+		return key
 	}
-	// Try to find field and use its length
-	fields := strings.Fields(expression)
-	if len(fields) > 0 {
-		fieldName := strings.ToUpper(fields[0])
-		if field := D4Field(data, fieldName); field != nil {
-			return int16(F4Len(field))
-		}
+	// Pad or cut keys whose computed length disagrees with the header
+	fixed := make([]byte, keyLen)
+	n := copy(fixed, key)
+	for i := n; i < keyLen; i++ {
+		fixed[i] = t4trailChar(tagFile)
 	}
-
-	return 10 // Default key length
+	return fixed
 }
 
-// i4writeTagDescriptors writes tag descriptors to the CDX file
-func i4writeTagDescriptors(indexFile *Index4File, tags []*Tag4) int {
-	// Read current header block
-	headerBuf := make([]byte, CDXHeaderSize)
-	bytesRead := File4Read(&indexFile.File, 0, headerBuf, CDXHeaderSize)
-	if bytesRead != CDXHeaderSize {
-		return ErrorRead
+// d4productionFlag sets or clears the production index flag of a table
+func d4productionFlag(dataFile *Data4File, on bool) {
+	flags := make([]byte, 1)
+	File4Read(&dataFile.File, 28, flags, 1)
+	if on {
+		flags[0] |= dbfFlagProduction
+	} else {
+		flags[0] &^= dbfFlagProduction
 	}
+	File4Write(&dataFile.File, 28, flags, 1)
+}
 
-	// Write tag descriptors (32 bytes each)
-	for i, tag := range tags {
-		if i >= CDXMaxTags {
-			break // Too many tags
-		}
-
-		offset := i * CDXTagDescSize
-		tagData := headerBuf[offset : offset+CDXTagDescSize]
-
-		// Clear the descriptor
-		memset(tagData, 0, CDXTagDescSize)
-
-		// FoxPro CDX tag descriptor format:
-		// 0-3: Root block number (4 bytes)
-		binary.LittleEndian.PutUint32(tagData[0:4], uint32(tag.TagFile.Header.Root))
-
-		// 4-15: Tag name (12 bytes, null-terminated)
-		tagName := string(tag.TagFile.Alias[:])
-		tagName = strings.TrimSpace(tagName)
-		if len(tagName) > 11 {
-			tagName = tagName[:11]
-		}
-		copy(tagData[4:16], tagName)
-
-		// 16-17: Key length (2 bytes)
-		binary.LittleEndian.PutUint16(tagData[16:18], uint16(tag.TagFile.Header.KeyLen))
-
-		// 18: Key type (1 byte)
-		tagData[18] = tag.TagFile.Header.TypeCode
+// ---------------------------------------------------------------------------
+// Key maintenance
+// ---------------------------------------------------------------------------
 
-		// 19: Options (1 byte)
-		options := uint8(0)
-		if (tag.TagFile.Header.TypeCode & CDXTypeUnique) != 0 {
-			options |= CDXTypeUnique
+// d4writeKeys brings the open tags of a table up to date with a record
+// that has just been written (mirrors d4writeKeys). oldRecord is the
+// record as it was on disk before the write, nil for an appended record.
+// The record buffer holds the record as written.
+func d4writeKeys(data *Data4, recNo int32, oldRecord []byte) int {
+	result := ErrorNone
+	first := list4First(&data.Indexes)
+	for current := first; current != nil; {
+		if err := i4writeKeys(indexFromLink(current), recNo, oldRecord); err != ErrorNone && result == ErrorNone {
+			result = err
 		}
-		if tag.TagFile.Header.Descending != 0 {
-			options |= 0x08 // Descending flag
+		current = list4Next(&data.Indexes, current)
+		if current == first {
+			break
 		}
-		tagData[19] = options
-
-		// 20-31: Reserved/sibling pointers (set to 0 for now)
-		// These would be used for tag chaining in complex scenarios
-	}
-
-	// Write the updated header back to file
-	bytesWritten := File4Write(&indexFile.File, 0, headerBuf, CDXHeaderSize)
-	if bytesWritten != CDXHeaderSize {
-		return ErrorWrite
 	}
-
-	return ErrorNone
+	return result
 }
 
-// dfile4Index checks if an index file already exists
-//
-//nolint:unparam // TODO: use dataFile parameter to search existing opened indexes
-func dfile4Index(dataFile *Data4File, indexPath string) *Index4File {
-	// Simple check - in a full implementation this would search the dataFile's index list
-	// For now, just check if file exists on disk
-	if _, err := os.Stat(indexPath); err == nil {
-		// File exists, treat as existing index
-		// In reality, we'd return the actual Index4File if it's already opened
-		return &Index4File{} // Placeholder
-	}
-	return nil
+// t4keyChange is the change a record write makes to one tag
+type t4keyChange struct {
+	tagFile *Tag4File
+	oldKey  []byte // nil if the record was not in the tag
+	newKey  []byte // nil if the record is left out of the tag
 }
 
-// memset fills a byte slice with a specific value
-func memset(b []byte, value byte, length int) {
-	for i := 0; i < length && i < len(b); i++ {
-		b[i] = value
+// i4writeKeys updates the tags of one index for a written record. Only
+// tags whose key or FOR result changed are touched; the index is locked
+// while they are, and their roots are read again first since other users
+// may have changed the index.
+func i4writeKeys(index *Index4, recNo int32, oldRecord []byte) int {
+	data := index.Data
+	indexFile := index.IndexFile
+
+	var tagFiles []*Tag4File
+	first := list4First(&indexFile.Tags)
+	for current := first; current != nil; {
+		if tagFile := tagFileFromLink(current); tagFile.Expr != nil {
+			tagFiles = append(tagFiles, tagFile)
+		}
+		current = list4Next(&indexFile.Tags, current)
+		if current == first {
+			break
+		}
 	}
-}
 
-// I4Reindex rebuilds all tags in an index file (mirrors i4reindex)
-func I4Reindex(index *Index4) int {
-	if index == nil {
-		return ErrorMemory
+	savedRecNo := data.recNo
+	data.recNo = recNo
+	newKeys := t4recordKeys(tagFiles)
+	oldKeys := make([][]byte, len(tagFiles))
+	if oldRecord != nil {
+		saved := data.Record
+		data.Record = oldRecord
+		oldKeys = t4recordKeys(tagFiles)
+		data.Record = saved
 	}
+	data.recNo = savedRecNo
 
-	c4 := index.CodeBase
-	if c4.ErrorCode < 0 {
-		return -1
+	var changes []t4keyChange
+	for i, tagFile := range tagFiles {
+		if (oldKeys[i] == nil) != (newKeys[i] == nil) || !bytes.Equal(oldKeys[i], newKeys[i]) {
+			changes = append(changes, t4keyChange{tagFile, oldKeys[i], newKeys[i]})
+		}
+	}
+	if len(changes) == 0 {
+		return ErrorNone
 	}
 
-	data := index.Data
-	if data == nil {
-		return ErrorMemory
+	unlock, err := i4lockKeys(data, indexFile)
+	if err != ErrorNone {
+		return err
 	}
+	defer unlock()
 
-	// Process each tag in the index
-	current := list4First(&index.IndexFile.Tags)
-	for current != nil {
-		// Get the Tag4File from its embedded Link4
-		tagFile := tagFileFromLink(current)
-		if tagFile != nil {
-			// Create a temporary Tag4 wrapper
-			tag := &Tag4{
-				TagFile:   tagFile,
-				Index:     index,
-				ErrUnique: 0,
-				IsValid:   true,
+	for _, change := range changes {
+		tagFile := change.tagFile
+		if err := t4readHeader(tagFile); err != ErrorNone {
+			return setError(data.CodeBase, err)
+		}
+		if change.oldKey != nil {
+			removed, err := t4removeKey(tagFile, change.oldKey, recNo)
+			if err != ErrorNone {
+				return setError(data.CodeBase, err)
 			}
-
-			// Reindex this tag
-			rc := t4reindex(tag)
-			if rc != ErrorNone {
-				return rc
+			if removed {
+				d4transLogKey(data, tagFile, recNo, change.oldKey, nil)
 			}
 		}
-
-		// Move to next tag
-		current = list4Next(&index.IndexFile.Tags, current)
-		if current == list4First(&index.IndexFile.Tags) {
-			break // Circular list, back to start
+		if change.newKey != nil {
+			exists := false
+			if tagFile.Header.TypeCode&CDXTypeUnique != 0 {
+				var err int
+				if exists, err = t4keyExists(tagFile, change.newKey); err != ErrorNone {
+					return setError(data.CodeBase, err)
+				}
+			}
+			// A unique tag keeps the record that had the key first
+			if !exists {
+				if err := t4addKey(tagFile, change.newKey, recNo); err != ErrorNone {
+					return setError(data.CodeBase, err)
+				}
+				d4transLogKey(data, tagFile, recNo, nil, change.newKey)
+			}
+		}
+		if err := t4writeHeader(tagFile); err != ErrorNone {
+			return err
 		}
 	}
-
-	// Reset data position after reindex
-	data.recNo = -1
-	data.atBof = true
-	data.atEOF = false
-
 	return ErrorNone
 }
 
-// t4reindex rebuilds a single tag (mirrors t4reindex)
-func t4reindex(tag *Tag4) int {
-	if tag == nil || tag.TagFile == nil {
-		return ErrorMemory
+// i4lockKeys locks an index for changing its tags, unless the table holds
+// the lock already. The returned function releases a lock taken here.
+func i4lockKeys(data *Data4, indexFile *Index4File) (func(), int) {
+	locks := d4locks(data)
+	if locks.Held(&indexFile.File, Lock4Pos, 1) {
+		return func() {}, ErrorNone
 	}
-
-	data := tag.Index.Data
-
-	if data == nil {
-		return ErrorMemory
+	if err := locks.Lock(&indexFile.File, LockFile, Lock4Pos, 1); err != ErrorNone {
+		return nil, err
 	}
+	return func() { locks.Unlock(&indexFile.File, Lock4Pos) }, ErrorNone
+}
 
-	// Clear existing index structure
-	err := t4reindexClear(tag.TagFile)
+// t4undoKey takes back a tag entry logged by a transaction: an added entry
+// is removed and a removed one added again
+func t4undoKey(data *Data4, trans *Trans4State) int {
+	tagFile := trans.TagFile
+	unlock, err := i4lockKeys(data, tagFile.IndexFile)
 	if err != ErrorNone {
 		return err
 	}
+	defer unlock()
 
-	// Rebuild from database records
-	err = t4reindexBuild(tag, data)
-	if err != ErrorNone {
+	if err := t4readHeader(tagFile); err != ErrorNone {
 		return err
 	}
-
-	// Update file structures
-	err = t4reindexFinalize(tag.TagFile)
-	if err != ErrorNone {
-		return err
-	}
-
-	return ErrorNone
-}
-
-// t4reindexClear clears the existing index structure
-func t4reindexClear(tagFile *Tag4File) int {
-	// Reset tag header to initial state
-	tagFile.Header.Root = 1 // Will be updated during rebuild
-	tagFile.Header.FreeList = 0
-
-	// In a full implementation, this would:
-	// 1. Mark all existing blocks as free
-	// 2. Reset block allocation counters
-	// 3. Clear any cached block data
-	// For now, we'll do a simplified clear
-
-	return ErrorNone
-}
-
-// t4reindexBuild rebuilds the index from database records
-func t4reindexBuild(tag *Tag4, data *Data4) int {
-	// Get total record count
-	recCount := D4RecCount(data)
-	if recCount <= 0 {
-		return ErrorNone // Empty database
-	}
-
-	// Create a temporary storage for index keys
-	keys := make([]IndexKey, 0, recCount)
-
-	// Process each record in the database
-	for recNo := int32(1); recNo <= recCount; recNo++ {
-		// Position to the record
-		err := D4Go(data, recNo)
-		if err != ErrorNone {
-			continue // Skip invalid records
-		}
-
-		// Evaluate the index expression
-		// In a full implementation, this would use expr4 functions
-		// For now, we'll use a simplified key generation
-		keyValue := t4evaluateExpression(tag.TagFile, data)
-		if keyValue == "" {
-			continue // Skip records with empty keys
-		}
-
-		// Add to our key collection
-		keys = append(keys, IndexKey{
-			KeyData: []byte(keyValue),
-			RecNo:   recNo,
-		})
-	}
-
-	// Sort the keys
-	t4sortKeys(keys, tag.TagFile.Header.Descending != 0)
-
-	// Build the B+ tree structure
-	err := t4buildTree(tag.TagFile, keys)
-	if err != ErrorNone {
-		return err
-	}
-
-	return ErrorNone
-}
-
-// t4evaluateExpression evaluates the index expression for the current record
-func t4evaluateExpression(tagFile *Tag4File, data *Data4) string {
-	if tagFile == nil || data == nil {
-		return ""
-	}
-
-	expr := tagFile.ExprSource
-	if expr == "" {
-		return ""
-	}
-
-	// Use the advanced expression evaluation with parsing
-	keyData, _ := evaluateIndexExpressionAdvanced(tagFile, data, expr)
-	if keyData == nil {
-		return ""
-	}
-
-	return string(keyData)
-}
-
-// IndexKey represents a key-record pair for sorting
-type IndexKey struct {
-	KeyData []byte
-	RecNo   int32
-}
-
-// t4sortKeys sorts index keys
-func t4sortKeys(keys []IndexKey, descending bool) {
-	// Simple string-based sort
-	// In a full implementation, this would use proper collation
-	if len(keys) <= 1 {
-		return
-	}
-
-	// Implement a basic quicksort
-	t4quickSort(keys, 0, len(keys)-1, descending)
-}
-
-// t4quickSort implements quicksort for index keys
-func t4quickSort(keys []IndexKey, low, high int, descending bool) {
-	if low < high {
-		pi := t4partition(keys, low, high, descending)
-		t4quickSort(keys, low, pi-1, descending)
-		t4quickSort(keys, pi+1, high, descending)
-	}
-}
-
-// t4partition partitions the array for quicksort
-func t4partition(keys []IndexKey, low, high int, descending bool) int {
-	pivot := string(keys[high].KeyData)
-	i := low - 1
-
-	for j := low; j < high; j++ {
-		cmp := strings.Compare(string(keys[j].KeyData), pivot)
-		if descending {
-			cmp = -cmp
-		}
-		if cmp <= 0 {
-			i++
-			keys[i], keys[j] = keys[j], keys[i]
-		}
-	}
-	keys[i+1], keys[high] = keys[high], keys[i+1]
-	return i + 1
-}
-
-// t4buildTree builds the B+ tree structure from sorted keys
-func t4buildTree(tagFile *Tag4File, keys []IndexKey) int {
-	if len(keys) == 0 {
-		return ErrorNone
-	}
-
-	// Simplified tree building
-	// In a full implementation, this would:
-	// 1. Create leaf blocks with the keys
-	// 2. Build branch blocks to index the leaves
-	// 3. Continue until we have a single root block
-	// 4. Write all blocks to the file
-
-	// For now, we'll just update the key count and root position
-	tagFile.Header.KeyLen = int16(len(keys[0].KeyData))
-	if tagFile.Header.KeyLen > 240 { // Max key length
-		tagFile.Header.KeyLen = 240
-	}
-
-	// Set root to point to first data block (simplified)
-	tagFile.Header.Root = 512 // Skip header block
-
-	// In a real implementation, we'd write the actual B+ tree blocks here
-	// For now, we'll just mark the structure as valid
-
-	return ErrorNone
-}
-
-// t4reindexFinalize finalizes the reindex operation
-func t4reindexFinalize(tagFile *Tag4File) int {
-	// Write updated tag header back to file
-	// In a full implementation, this would:
-	// 1. Update the tag descriptor in the CDX header
-	// 2. Flush all modified blocks to disk
-	// 3. Update file length and allocation info
-
-	// For now, we'll do a simplified finalization
-	tagFile.RootWrite = true // Mark header for writing
-
-	return ErrorNone
-}
-
-// d4getCurrentKey gets the current index key value for the selected tag
-func d4getCurrentKey(data *Data4) string {
-	if data == nil || data.TagSelected == nil || data.TagSelected.TagFile == nil {
-		return ""
-	}
-
-	// For now, we'll evaluate the expression for the current record
-	// In a full implementation, this would get the key from the current index position
-	keyValue := t4evaluateExpression(data.TagSelected.TagFile, data)
-	return keyValue
-}
-
-// d4seekNextSkip skips to the next record in index order
-func d4seekNextSkip(data *Data4) int {
-	if data == nil || data.TagSelected == nil {
-		return ErrorMemory
-	}
-
-	// In a full implementation, this would navigate through the B+ tree
-	// For now, we'll use a simplified approach by skipping in the database
-	// and checking if we're still in index order
-
-	// Skip to next record
-	err := D4Skip(data, 1)
-	if err != ErrorNone {
-		return err
-	}
-
-	// Check if we've reached EOF
-	if D4Eof(data) {
-		return R4Eof
-	}
-
-	return ErrorNone
-}
-
-// d4compareKeys compares two key values with proper collation
-//
-//nolint:unused // Future use
-func d4compareKeys(key1, key2 string, length int) int {
-	// Truncate keys to comparison length
-	if len(key1) > length {
-		key1 = key1[:length]
-	}
-	if len(key2) > length {
-		key2 = key2[:length]
-	}
-
-	// Trim whitespace for comparison
-	key1 = strings.TrimSpace(key1)
-	key2 = strings.TrimSpace(key2)
-
-	// Perform case-sensitive comparison (Visual FoxPro default)
-	return strings.Compare(key1, key2)
-}
-
-// d4formatKey formats a key value according to the tag's data type
-//
-//nolint:unused // Future use
-func d4formatKey(tag *Tag4, value interface{}) string {
-	if tag == nil || tag.TagFile == nil {
-		return ""
-	}
-
-	keyLen := int(tag.TagFile.Header.KeyLen)
-
-	switch v := value.(type) {
-	case string:
-		// String key - pad or truncate to key length
-		if len(v) > keyLen {
-			return v[:keyLen]
-		}
-		return fmt.Sprintf("%-*s", keyLen, v)
-
-	case float64:
-		// Numeric key - format with proper precision
-		return fmt.Sprintf("%*.2f", keyLen, v)
-
-	case int, int32, int64:
-		// Integer key - format as number
-		return fmt.Sprintf("%*d", keyLen, v)
-
-	default:
-		// Default - convert to string and pad
-		str := fmt.Sprintf("%v", v)
-		if len(str) > keyLen {
-			return str[:keyLen]
-		}
-		return fmt.Sprintf("%-*s", keyLen, str)
-	}
-}
-
-// b4leafSeek implements advanced leaf block seeking with duplicate handling
-//
-//nolint:gocyclo,unused // TODO: refactor to reduce complexity by extracting key comparison logic
-func b4leafSeek(block *B4Block, searchValue string, length int) int {
-	if block == nil || len(block.Keys) == 0 {
-		return R4After
-	}
-
-	originalLen := length
-	if originalLen <= 0 {
-		originalLen = len(searchValue)
-	}
-
-	// Calculate trailing blanks in search value
-	effectiveLen := originalLen
-	for effectiveLen > 0 && searchValue[effectiveLen-1] == ' ' {
-		effectiveLen--
-	}
-
-	// Handle all-blank search (for empty key searches)
-	allBlank := (effectiveLen == 0)
-	if allBlank {
-		effectiveLen = originalLen
-	}
-
-	// Search through keys in the leaf block
-	for keyIndex := 0; keyIndex < len(block.Keys); keyIndex++ {
-		key := &block.Keys[keyIndex]
-		keyData := string(key.KeyData)
-
-		// Calculate significant bytes in the key (excluding trailing spaces)
-		significantBytes := len(strings.TrimRight(keyData, " "))
-
-		// Handle all-blank search specifically
-		if allBlank && significantBytes == 0 {
-			return keyIndex // Found blank key
-		}
-
-		// Determine comparison length
-		compareLen := effectiveLen
-		if significantBytes < compareLen {
-			compareLen = significantBytes
-		}
-
-		// Perform key comparison
-		result := b4compareKeyData(keyData, searchValue, compareLen)
-
-		if result == 0 {
-			// Keys match so far, check if this is exact match
-			if compareLen == effectiveLen {
-				// Check remaining characters if needed
-				if originalLen > effectiveLen {
-					// Need to check trailing characters
-					remainingComp := b4compareKeyData(
-						keyData[effectiveLen:],
-						searchValue[effectiveLen:],
-						originalLen-effectiveLen)
-					if remainingComp != 0 {
-						if remainingComp > 0 {
-							return keyIndex // This key is >= search value
-						}
-						continue // Keep looking
-					}
-				}
-				return keyIndex // Exact match found
-			}
-			continue // Keep looking for better match
-		} else if result > 0 {
-			// Current key is greater than search value
-			return keyIndex // Position after search value
-		}
-		// result < 0: current key is less than search value, continue
-	}
-
-	// Reached end of block without finding exact match
-	return R4After
-}
-
-// b4compareKeyData compares key data with proper handling of spaces and nulls
-//
-//nolint:unused // Future use
-func b4compareKeyData(keyData, searchData string, length int) int {
-	if length <= 0 {
-		return 0
-	}
-
-	// Ensure we don't go beyond available data
-	if len(keyData) < length {
-		// Pad keyData with spaces if shorter
-		keyData = fmt.Sprintf("%-*s", length, keyData)
-	}
-	if len(searchData) < length {
-		// Pad searchData with spaces if shorter
-		searchData = fmt.Sprintf("%-*s", length, searchData)
-	}
-
-	// Extract the portions to compare
-	keyPortion := keyData[:length]
-	searchPortion := searchData[:length]
-
-	// Use comprehensive key comparison with default string type and machine collation
-	return compareKeys([]byte(keyPortion), []byte(searchPortion), int(FieldTypeChar), 0)
-}
-
-// b4calculateTrailingBlanks counts trailing blank characters
-//
-//nolint:unused // Future use
-func b4calculateTrailingBlanks(data []byte, padChar byte) int {
-	count := 0
-	for i := len(data) - 1; i >= 0; i-- {
-		if data[i] == padChar || data[i] == ' ' {
-			count++
-		} else {
-			break
-		}
-	}
-	return count
-}
-
-// b4calculateDuplicatePrefix counts duplicate prefix bytes between two keys
-//
-//nolint:unused // Future use
-func b4calculateDuplicatePrefix(key1, key2 []byte) int {
-	minLen := len(key1)
-	if len(key2) < minLen {
-		minLen = len(key2)
-	}
-
-	for i := 0; i < minLen; i++ {
-		if key1[i] != key2[i] {
-			return i
-		}
-	}
-	return minLen
-}
-
-// Key formatting and comparison functions for different data types
-
-// formatNumericKey formats a numeric value according to VFP numeric key rules
-func formatNumericKey(value float64, keyLen int) string {
-	// VFP numeric keys are stored as strings with specific formatting
-	// Negative numbers have a special encoding
-
-	if value < 0 {
-		// Negative numbers: invert sign and complement digits
-		absValue := -value
-		str := fmt.Sprintf("%*.2f", keyLen, absValue)
-		// Complement each digit for proper sorting
-		result := make([]byte, len(str))
-		for i, b := range []byte(str) {
-			if b >= '0' && b <= '9' {
-				result[i] = '9' - (b - '0')
-			} else {
-				result[i] = b
-			}
-		}
-		return string(result)
-	}
-	// Positive numbers: normal formatting with leading spaces
-	return fmt.Sprintf("%*.2f", keyLen, value)
-}
-
-// formatDateKey formats a date value according to VFP date key rules
-func formatDateKey(dateStr string, keyLen int) string {
-	// VFP date keys are typically stored as YYYYMMDD
-	if len(dateStr) >= 8 {
-		// Extract YYYYMMDD format
-		year := dateStr[0:4]
-		month := dateStr[4:6]
-		day := dateStr[6:8]
-		formatted := year + month + day
-		if len(formatted) > keyLen {
-			formatted = formatted[:keyLen]
-		}
-		return fmt.Sprintf("%-*s", keyLen, formatted)
-	}
-
-	// Invalid date, pad with spaces
-	return fmt.Sprintf("%-*s", keyLen, dateStr)
-}
-
-// formatStringKey formats a string value according to VFP string key rules
-func formatStringKey(value string, keyLen int, descending bool) string {
-	// String keys are left-padded with spaces
-	formatted := value
-	if len(formatted) > keyLen {
-		formatted = formatted[:keyLen]
-	}
-	result := fmt.Sprintf("%-*s", keyLen, formatted)
-
-	if descending {
-		// For descending keys, complement the characters
-		bytes := []byte(result)
-		for i, b := range bytes {
-			bytes[i] = 255 - b
-		}
-		return string(bytes)
-	}
-
-	return result
-}
-
-// compareKeys performs VFP-compatible key comparison
-//
-//nolint:unused // Future use
-func compareKeys(key1, key2 []byte, keyType int, collation int) int {
-	// Determine comparison length
-	minLen := len(key1)
-	if len(key2) < minLen {
-		minLen = len(key2)
-	}
-
-	switch keyType {
-	case int(FieldTypeNumeric), int(FieldTypeFloat), int(FieldTypeCurrency):
-		return compareNumericKeys(key1, key2, minLen)
-	case int(FieldTypeDate), int(FieldTypeDateTime):
-		return compareDateKeys(key1, key2, minLen)
-	case int(FieldTypeLogical):
-		return compareLogicalKeys(key1, key2, minLen)
-	default:
-		return compareStringKeys(key1, key2, minLen, collation)
-	}
-}
-
-// compareNumericKeys compares numeric keys with proper handling of negative values
-//
-//nolint:unused // Future use
-func compareNumericKeys(key1, key2 []byte, length int) int {
-	// Numeric keys may have special encoding for negatives
-	for i := 0; i < length; i++ {
-		if key1[i] < key2[i] {
-			return -1
-		} else if key1[i] > key2[i] {
-			return 1
-		}
-	}
-
-	// Handle length differences
-	if len(key1) < len(key2) {
-		return -1
-	} else if len(key1) > len(key2) {
-		return 1
-	}
-
-	return 0
-}
-
-// compareDateKeys compares date keys (YYYYMMDD format)
-//
-//nolint:unused // Future use
-func compareDateKeys(key1, key2 []byte, length int) int {
-	// Date keys are compared lexicographically in YYYYMMDD format
-	for i := 0; i < length; i++ {
-		if key1[i] < key2[i] {
-			return -1
-		} else if key1[i] > key2[i] {
-			return 1
-		}
-	}
-	return 0
-}
-
-// compareLogicalKeys compares logical keys (T/F, Y/N, etc.)
-//
-//nolint:unused // Future use
-func compareLogicalKeys(key1, key2 []byte, length int) int {
-	// Logical values: False < True
-	if length > 0 {
-		val1 := key1[0]
-		val2 := key2[0]
-
-		// Normalize to T/F
-		if val1 == 'Y' || val1 == 'y' || val1 == '1' {
-			val1 = 'T'
-		} else {
-			val1 = 'F'
-		}
-		if val2 == 'Y' || val2 == 'y' || val2 == '1' {
-			val2 = 'T'
-		} else {
-			val2 = 'F'
-		}
-
-		if val1 < val2 {
-			return -1
-		} else if val1 > val2 {
-			return 1
-		}
-	}
-	return 0
-}
-
-// compareStringKeys compares string keys with collation support
-//
-//nolint:unused // Future use
-func compareStringKeys(key1, key2 []byte, length int, collation int) int {
-	switch collation {
-	case 0: // Machine/binary collation
-		return compareBinaryKeys(key1, key2, length)
-	default: // General/linguistic collation
-		return compareGeneralKeys(key1, key2, length)
-	}
-}
-
-// compareBinaryKeys performs binary byte comparison
-//
-//nolint:unused // Future use
-func compareBinaryKeys(key1, key2 []byte, length int) int {
-	for i := 0; i < length; i++ {
-		if key1[i] < key2[i] {
-			return -1
-		} else if key1[i] > key2[i] {
-			return 1
-		}
-	}
-	return 0
-}
-
-// compareGeneralKeys performs case-insensitive comparison
-//
-//nolint:unused // Future use
-func compareGeneralKeys(key1, key2 []byte, length int) int {
-	for i := 0; i < length; i++ {
-		// Convert to uppercase for comparison
-		c1 := key1[i]
-		c2 := key2[i]
-
-		if c1 >= 'a' && c1 <= 'z' {
-			c1 = c1 - 'a' + 'A'
-		}
-		if c2 >= 'a' && c2 <= 'z' {
-			c2 = c2 - 'a' + 'A'
-		}
-
-		if c1 < c2 {
-			return -1
-		} else if c1 > c2 {
-			return 1
-		}
-	}
-	return 0
-}
-
-// Enhanced key evaluation with better field type support
-//
-//nolint:unused // Future use
-func evaluateIndexExpression(tagFile *Tag4File, data *Data4, expression string) ([]byte, int) {
-	if expression == "" || data == nil {
-		return nil, int(FieldTypeChar)
-	}
-
-	// Handle simple field references
-	if field := D4Field(data, strings.ToUpper(expression)); field != nil {
-		fieldType := field.Type
-		keyLen := int(tagFile.Header.KeyLen)
-
-		switch fieldType {
-		case int16(FieldTypeChar):
-			value := F4Str(field)
-			formatted := formatStringKey(value, keyLen, tagFile.Header.Descending != 0)
-			return []byte(formatted), int(FieldTypeChar)
-
-		case int16(FieldTypeNumeric), int16(FieldTypeFloat):
-			value := F4Double(field)
-			formatted := formatNumericKey(value, keyLen)
-			return []byte(formatted), int(FieldTypeNumeric)
-
-		case int16(FieldTypeDate):
-			value := F4Str(field)
-			formatted := formatDateKey(value, keyLen)
-			return []byte(formatted), int(FieldTypeDate)
-
-		case int16(FieldTypeLogical):
-			value := F4Str(field)
-			formatted := fmt.Sprintf("%-*s", keyLen, value)
-			return []byte(formatted), int(FieldTypeLogical)
-
-		default:
-			value := F4Str(field)
-			formatted := formatStringKey(value, keyLen, false)
-			return []byte(formatted), int(FieldTypeChar)
-		}
-	}
-
-	// For complex expressions, return simple string conversion
-	keyLen := int(tagFile.Header.KeyLen)
-	formatted := formatStringKey(expression, keyLen, false)
-	return []byte(formatted), int(FieldTypeChar)
-}
-
-// Advanced expression parsing and evaluation functions
-
-// ExpressionParser handles VFP expression parsing and evaluation
-type ExpressionParser struct {
-	Expression string
-	Tokens     []ExprToken
-	Pos        int
-}
-
-// ExprToken represents a parsed expression token
-type ExprToken struct {
-	Type     ExprTokenType
-	Value    string
-	Position int
-}
-
-// ExprTokenType represents different types of expression tokens
-type ExprTokenType int
-
-// Expression token type constants
-const (
-	// TokenField represents a field name in an expression
-	TokenField ExprTokenType = iota
-	TokenFunction
-	TokenOperator
-	TokenLiteral
-	TokenLeftParen
-	TokenRightParen
-	TokenComma
-	TokenEOF
-)
-
-// parseExpression parses a VFP expression into tokens
-func parseExpression(expression string) *ExpressionParser {
-	parser := &ExpressionParser{
-		Expression: strings.TrimSpace(strings.ToUpper(expression)),
-		Tokens:     []ExprToken{},
-		Pos:        0,
-	}
-
-	parser.tokenize()
-	return parser
-}
-
-// tokenize breaks the expression into tokens
-//
-//nolint:gocyclo // TODO: refactor to reduce complexity by extracting token parsing methods
-func (p *ExpressionParser) tokenize() {
-	expression := p.Expression
-	i := 0
-
-	for i < len(expression) {
-		ch := expression[i]
-
-		// Skip whitespace
-		if ch == ' ' || ch == '\t' {
-			i++
-			continue
-		}
-
-		// Handle parentheses
-		if ch == '(' {
-			p.Tokens = append(p.Tokens, ExprToken{TokenLeftParen, "(", i})
-			i++
-			continue
-		}
-		if ch == ')' {
-			p.Tokens = append(p.Tokens, ExprToken{TokenRightParen, ")", i})
-			i++
-			continue
-		}
-		if ch == ',' {
-			p.Tokens = append(p.Tokens, ExprToken{TokenComma, ",", i})
-			i++
-			continue
-		}
-
-		// Handle string literals
-		if ch == '"' || ch == '\'' {
-			quote := ch
-			j := i + 1
-			for j < len(expression) && expression[j] != quote {
-				j++
-			}
-			if j < len(expression) {
-				j++ // Include closing quote
-			}
-			p.Tokens = append(p.Tokens, ExprToken{TokenLiteral, expression[i:j], i})
-			i = j
-			continue
-		}
-
-		// Handle operators
-		if ch == '+' || ch == '-' || ch == '*' || ch == '/' {
-			p.Tokens = append(p.Tokens, ExprToken{TokenOperator, string(ch), i})
-			i++
-			continue
-		}
-
-		// Handle identifiers and functions
-		if (ch >= 'A' && ch <= 'Z') || (ch >= 'a' && ch <= 'z') || ch == '_' {
-			j := i
-			for j < len(expression) && ((expression[j] >= 'A' && expression[j] <= 'Z') ||
-				(expression[j] >= 'a' && expression[j] <= 'z') ||
-				(expression[j] >= '0' && expression[j] <= '9') ||
-				expression[j] == '_') {
-				j++
-			}
-
-			identifier := expression[i:j]
-
-			// Check if it's a function (followed by parenthesis)
-			if j < len(expression) && expression[j] == '(' {
-				p.Tokens = append(p.Tokens, ExprToken{TokenFunction, identifier, i})
-			} else {
-				p.Tokens = append(p.Tokens, ExprToken{TokenField, identifier, i})
-			}
-			i = j
-			continue
-		}
-
-		// Handle numbers
-		if (ch >= '0' && ch <= '9') || ch == '.' {
-			j := i
-			for j < len(expression) && ((expression[j] >= '0' && expression[j] <= '9') || expression[j] == '.') {
-				j++
-			}
-			p.Tokens = append(p.Tokens, ExprToken{TokenLiteral, expression[i:j], i})
-			i = j
-			continue
-		}
-
-		// Skip unknown characters
-		i++
-	}
-
-	p.Tokens = append(p.Tokens, ExprToken{TokenEOF, "", len(expression)})
-}
-
-// evaluateVFPExpression evaluates a parsed VFP expression
-func evaluateVFPExpression(parser *ExpressionParser, tagFile *Tag4File, data *Data4) ([]byte, int) {
-	if parser == nil || len(parser.Tokens) == 0 {
-		return nil, int(FieldTypeChar)
-	}
-
-	// Handle simple cases first
-	if len(parser.Tokens) == 2 { // Field + EOF
-		token := parser.Tokens[0]
-		if token.Type == TokenField {
-			return evaluateField(token.Value, tagFile, data)
-		}
-	}
-
-	// Handle function calls
-	if len(parser.Tokens) >= 4 { // Function + ( + args + )
-		token := parser.Tokens[0]
-		if token.Type == TokenFunction {
-			return evaluateFunction(token.Value, parser, tagFile, data)
-		}
-	}
-
-	// Handle complex expressions (simplified)
-	return evaluateComplexExpression(parser, tagFile, data)
-}
-
-// evaluateField evaluates a simple field reference
-func evaluateField(fieldName string, tagFile *Tag4File, data *Data4) ([]byte, int) {
-	field := D4Field(data, fieldName)
-	if field == nil {
-		return nil, int(FieldTypeChar)
-	}
-
-	fieldType := field.Type
-	keyLen := int(tagFile.Header.KeyLen)
-
-	switch fieldType {
-	case int16(FieldTypeChar):
-		value := F4Str(field)
-		formatted := formatStringKey(value, keyLen, tagFile.Header.Descending != 0)
-		return []byte(formatted), int(FieldTypeChar)
-
-	case int16(FieldTypeNumeric), int16(FieldTypeFloat):
-		value := F4Double(field)
-		formatted := formatNumericKey(value, keyLen)
-		return []byte(formatted), int(FieldTypeNumeric)
-
-	case int16(FieldTypeDate):
-		value := F4Str(field)
-		formatted := formatDateKey(value, keyLen)
-		return []byte(formatted), int(FieldTypeDate)
-
-	case int16(FieldTypeLogical):
-		value := F4Str(field)
-		formatted := fmt.Sprintf("%-*s", keyLen, value)
-		return []byte(formatted), int(FieldTypeLogical)
-
-	default:
-		value := F4Str(field)
-		formatted := formatStringKey(value, keyLen, false)
-		return []byte(formatted), int(FieldTypeChar)
-	}
-}
-
-// evaluateFunction evaluates VFP functions like STR(), DTOS(), etc.
-//
-//nolint:gocyclo // TODO: refactor to reduce complexity by extracting function evaluation handlers
-func evaluateFunction(funcName string, parser *ExpressionParser, tagFile *Tag4File, data *Data4) ([]byte, int) {
-	keyLen := int(tagFile.Header.KeyLen)
-
-	switch funcName {
-	case "STR":
-		// STR(numeric_field) - convert number to string
-		if len(parser.Tokens) >= 4 && parser.Tokens[2].Type == TokenField {
-			fieldName := parser.Tokens[2].Value
-			field := D4Field(data, fieldName)
-			if field != nil {
-				value := F4Double(field)
-				strValue := fmt.Sprintf("%.2f", value)
-				formatted := formatStringKey(strValue, keyLen, false)
-				return []byte(formatted), int(FieldTypeChar)
-			}
-		}
-
-	case "DTOS":
-		// DTOS(date_field) - convert date to YYYYMMDD string
-		if len(parser.Tokens) >= 4 && parser.Tokens[2].Type == TokenField {
-			fieldName := parser.Tokens[2].Value
-			field := D4Field(data, fieldName)
-			if field != nil {
-				value := F4Str(field)
-				formatted := formatDateKey(value, keyLen)
-				return []byte(formatted), int(FieldTypeChar)
-			}
-		}
-
-	case "UPPER":
-		// UPPER(field) - convert to uppercase
-		if len(parser.Tokens) >= 4 && parser.Tokens[2].Type == TokenField {
-			fieldName := parser.Tokens[2].Value
-			field := D4Field(data, fieldName)
-			if field != nil {
-				value := strings.ToUpper(F4Str(field))
-				formatted := formatStringKey(value, keyLen, false)
-				return []byte(formatted), int(FieldTypeChar)
-			}
-		}
-
-	case "LEFT":
-		// LEFT(field, n) - leftmost n characters
-		if len(parser.Tokens) >= 6 && parser.Tokens[2].Type == TokenField && parser.Tokens[4].Type == TokenLiteral {
-			fieldName := parser.Tokens[2].Value
-			field := D4Field(data, fieldName)
-			if field != nil {
-				value := F4Str(field)
-				if n, err := strconv.Atoi(parser.Tokens[4].Value); err == nil && n > 0 {
-					if len(value) > n {
-						value = value[:n]
-					}
-				}
-				formatted := formatStringKey(value, keyLen, false)
-				return []byte(formatted), int(FieldTypeChar)
-			}
-		}
-
-	case "ALLTRIM":
-		// ALLTRIM(field) - remove leading and trailing spaces
-		if len(parser.Tokens) >= 4 && parser.Tokens[2].Type == TokenField {
-			fieldName := parser.Tokens[2].Value
-			field := D4Field(data, fieldName)
-			if field != nil {
-				value := strings.TrimSpace(F4Str(field))
-				formatted := formatStringKey(value, keyLen, false)
-				return []byte(formatted), int(FieldTypeChar)
-			}
-		}
-	}
-
-	// Default: return empty key
-	formatted := formatStringKey("", keyLen, false)
-	return []byte(formatted), int(FieldTypeChar)
-}
-
-// evaluateComplexExpression handles complex expressions with operators
-func evaluateComplexExpression(parser *ExpressionParser, tagFile *Tag4File, data *Data4) ([]byte, int) {
-	// For now, implement simple concatenation with + operator
-	keyLen := int(tagFile.Header.KeyLen)
-
-	if len(parser.Tokens) >= 5 { // field + op + field/literal + ...
-		leftToken := parser.Tokens[0]
-		operator := parser.Tokens[1]
-		rightToken := parser.Tokens[2]
-
-		if operator.Type == TokenOperator && operator.Value == "+" {
-			// String concatenation
-			leftValue := ""
-			rightValue := ""
-
-			if leftToken.Type == TokenField {
-				if field := D4Field(data, leftToken.Value); field != nil {
-					leftValue = F4Str(field)
-				}
-			} else if leftToken.Type == TokenLiteral {
-				leftValue = strings.Trim(leftToken.Value, "\"'")
-			}
-
-			if rightToken.Type == TokenField {
-				if field := D4Field(data, rightToken.Value); field != nil {
-					rightValue = F4Str(field)
-				}
-			} else if rightToken.Type == TokenLiteral {
-				rightValue = strings.Trim(rightToken.Value, "\"'")
-			}
-
-			result := leftValue + rightValue
-			formatted := formatStringKey(result, keyLen, false)
-			return []byte(formatted), int(FieldTypeChar)
-		}
-	}
-
-	// Fallback: treat as simple field or return empty
-	if len(parser.Tokens) > 0 && parser.Tokens[0].Type == TokenField {
-		return evaluateField(parser.Tokens[0].Value, tagFile, data)
-	}
-
-	formatted := formatStringKey("", keyLen, false)
-	return []byte(formatted), int(FieldTypeChar)
-}
-
-// Enhanced evaluateIndexExpression using the advanced parser
-//
-//nolint:unused // Future use
-func evaluateIndexExpressionAdvanced(tagFile *Tag4File, data *Data4, expression string) ([]byte, int) {
-	if expression == "" || data == nil {
-		return nil, int(FieldTypeChar)
-	}
-
-	// Parse the expression
-	parser := parseExpression(expression)
-
-	// Evaluate the parsed expression
-	return evaluateVFPExpression(parser, tagFile, data)
-}
-
-// Index file writing operations
-
-// b4flush flushes modified index blocks to disk
-//
-//nolint:unused // Future use
-func b4flush(indexFile *Index4File) int {
-	if indexFile == nil || !indexFile.IsValid {
-		return ErrorMemory
-	}
-
-	// In a full implementation, this would:
-	// 1. Write all modified blocks to disk
-	// 2. Update file headers
-	// 3. Flush OS buffers
-	// 4. Clear dirty flags
-
-	// For now, ensure file is synced
-	File4Flush(&indexFile.File)
-	return ErrorNone
-}
-
-// b4writeBlock writes a B+ tree block to the index file
-//
-//nolint:unused // Future use
-func b4writeBlock(indexFile *Index4File, blockNumber int32, block *B4Block) int {
-	if indexFile == nil || block == nil || blockNumber <= 0 {
-		return ErrorMemory
+	if trans.NewKey != nil {
+		if _, err := t4removeKey(tagFile, trans.NewKey, trans.RecNo); err != ErrorNone {
+			return err
+		}
 	}
-
-	// Calculate file position for this block
-	offset := int64(blockNumber) * CDXBlockSize
-
-	// Serialize the block to binary format
-	blockData := serializeBlock(block)
-	if len(blockData) != CDXBlockSize {
-		return ErrorWrite
+	if trans.OldKey != nil {
+		if err := t4addKey(tagFile, trans.OldKey, trans.RecNo); err != ErrorNone {
+			return err
+		}
 	}
+	return t4writeHeader(tagFile)
+}
 
-	// Write to file
-	bytesWritten := File4Write(&indexFile.File, offset, blockData, CDXBlockSize)
-	if bytesWritten != CDXBlockSize {
-		return ErrorWrite
+// t4recordKeys evaluates the keys of the record buffer for the given tags,
+// with nil for tags whose FOR expression leaves the record out
+func t4recordKeys(tagFiles []*Tag4File) [][]byte {
+	keys := make([][]byte, len(tagFiles))
+	for i, tagFile := range tagFiles {
+		if tagFile.Filter != nil && !Expr4True(tagFile.Filter) {
+			continue
+		}
+		keys[i] = t4exprKey(tagFile, tagFile.Expr)
 	}
-
-	return ErrorNone
+	return keys
 }
 
-// serializeBlock converts a B4Block to binary format for writing
-//
-//nolint:unused // Future use
-func serializeBlock(block *B4Block) []byte {
-	data := make([]byte, CDXBlockSize)
-
-	// Block header (simplified)
-	// 0-1: Number of keys (2 bytes)
-	binary.LittleEndian.PutUint16(data[0:2], uint16(len(block.Keys)))
-
-	// 2-3: Block type flags (2 bytes)
-	blockType := uint16(block.BlockType)
-	binary.LittleEndian.PutUint16(data[2:4], blockType)
+// ---------------------------------------------------------------------------
+// Tag positioning
+// ---------------------------------------------------------------------------
 
-	// 4-7: Free space pointer (4 bytes)
-	freeSpace := CDXBlockSize
-	binary.LittleEndian.PutUint32(data[4:8], uint32(freeSpace))
-
-	// Write keys and data
-	offset := 8 // Start after header
-	for i, key := range block.Keys {
-		if offset+len(key.KeyData)+4 > CDXBlockSize {
-			break // No more room
+// t4seekEntry positions a tag on the first entry that is not less than
+// key/recNo in stored order. A key shorter than the tag's key length
+// compares as a prefix.
+//
+// Returns R4Success when positioned on an entry, R4Eof if every entry is
+// less than key/recNo, or an error code.
+func t4seekEntry(tag *Tag4, key []byte, recNo int32) int {
+	tagFile := tag.TagFile
+	tag.block = nil
+
+	offset := tagFile.Header.Root
+	for depth := 0; depth <= 32; depth++ {
+		block, err := t4read(tag, offset)
+		if err != ErrorNone {
+			return err
 		}
-
-		// Write key length (2 bytes)
-		binary.LittleEndian.PutUint16(data[offset:offset+2], uint16(len(key.KeyData)))
-		offset += 2
-
-		// Write key data
-		copy(data[offset:offset+len(key.KeyData)], key.KeyData)
-		offset += len(key.KeyData)
-
-		// Write record number for leaf blocks (type 1) or child pointer for branch blocks
-		if block.BlockType == 1 { // Leaf block
-			binary.LittleEndian.PutUint32(data[offset:offset+4], uint32(key.RecNo))
-			offset += 4
-		} else {
-			// Write child pointer for branch blocks
-			if i < len(block.Pointers) {
-				binary.LittleEndian.PutUint32(data[offset:offset+4], uint32(block.Pointers[i]))
-				offset += 4
+		pos := sort.Search(len(block.Keys), func(i int) bool {
+			return b4compare(block.Keys[i].Key, block.Keys[i].RecNo, key, recNo) >= 0
+		})
+		if b4leaf(block) {
+			tag.block, tag.pos, tag.changes = block, pos, tagFile.changes
+			if pos == len(block.Keys) {
+				return t4skipLeaf(tag, 1)
 			}
+			return R4Success
 		}
-	}
-
-	// Write final pointer for branch blocks
-	if block.BlockType != 1 && len(block.Pointers) > len(block.Keys) {
-		if offset+4 <= CDXBlockSize {
-			binary.LittleEndian.PutUint32(data[offset:offset+4], uint32(block.Pointers[len(block.Keys)]))
+		if pos == len(block.Keys) {
+			return R4Eof
 		}
+		offset = block.Keys[pos].Pointer
 	}
-
-	return data
+	return ErrorIndex
 }
 
-// b4insert inserts a new key into the B+ tree with full algorithm
+// t4edge positions a tag on its first (forward) or last stored entry
 //
-//nolint:unused // Future use
-func b4insert(tagFile *Tag4File, keyData []byte, recNo int32) int {
-	if tagFile == nil || len(keyData) == 0 || recNo <= 0 {
-		return ErrorMemory
-	}
-
-	// Check for duplicate keys if unique index
-	if (tagFile.Header.TypeCode & CDXTypeUnique) != 0 {
-		existingBlock, keyPos, err := b4findKey(tagFile, keyData)
-		if err == ErrorNone && existingBlock != nil && keyPos >= 0 {
-			return ErrorData // Duplicate key
+// Returns R4Success, or R4Eof if the tag is empty.
+func t4edge(tag *Tag4, forward bool) int {
+	tagFile := tag.TagFile
+	tag.block = nil
+
+	offset := tagFile.Header.Root
+	for depth := 0; depth <= 32; depth++ {
+		block, err := t4read(tag, offset)
+		if err != ErrorNone {
+			return err
 		}
+		if len(block.Keys) == 0 && !b4leaf(block) {
+			return ErrorIndex
+		}
+		if b4leaf(block) {
+			tag.block, tag.changes = block, tagFile.changes
+			if len(block.Keys) == 0 {
+				tag.block = nil
+				return R4Eof
+			}
+			tag.pos = 0
+			if !forward {
+				tag.pos = len(block.Keys) - 1
+			}
+			return R4Success
+		}
+		child := block.Keys[0]
+		if !forward {
+			child = block.Keys[len(block.Keys)-1]
+		}
+		offset = child.Pointer
 	}
+	return ErrorIndex
+}
 
-	// Navigate to the appropriate leaf block
-	leafBlock, err := b4findLeafForInsert(tagFile, keyData)
-	if err != ErrorNone {
-		return err
-	}
-
-	// Create new key entry
-	newKey := B4Key{
-		KeyData: make([]byte, len(keyData)),
-		RecNo:   recNo,
-	}
-	copy(newKey.KeyData, keyData)
-
-	// Insert key in sorted order within leaf
-	insertPos := b4findInsertPosition(leafBlock, keyData)
-
-	// Check if block needs to split
-	if len(leafBlock.Keys) >= CDXMaxKeysPerBlock {
-		// Split the block
-		leftBlock, rightBlock, separatorKey, err := b4split(tagFile, leafBlock)
+// t4skipLeaf moves a tag one entry in stored order (dir 1 or -1), following
+// the sibling links between leaves
+//
+// Returns R4Success, or R4Eof when moving past either end.
+func t4skipLeaf(tag *Tag4, dir int) int {
+	block := tag.block
+	pos := tag.pos + dir
+	for pos < 0 || pos >= len(block.Keys) {
+		next := block.RightNode
+		if dir < 0 {
+			next = block.LeftNode
+		}
+		if next == b4NoNode || next == 0 {
+			tag.block = nil
+			return R4Eof
+		}
+		var err int
+		block, err = t4read(tag, next)
 		if err != ErrorNone {
+			tag.block = nil
 			return err
 		}
-
-		// Insert into appropriate block
-		if insertPos <= len(leftBlock.Keys) {
-			// Insert into left block
-			leftBlock.Keys = append(leftBlock.Keys[:insertPos],
-				append([]B4Key{newKey}, leftBlock.Keys[insertPos:]...)...)
-		} else {
-			// Insert into right block
-			adjustedPos := insertPos - len(leftBlock.Keys) - 1
-			rightBlock.Keys = append(rightBlock.Keys[:adjustedPos],
-				append([]B4Key{newKey}, rightBlock.Keys[adjustedPos:]...)...)
+		pos = 0
+		if dir < 0 {
+			pos = len(block.Keys) - 1
 		}
-
-		// Write blocks to disk
-		leftBlockNum, _ := b4allocateBlock(tagFile.IndexFile)
-		rightBlockNum, _ := b4allocateBlock(tagFile.IndexFile)
-
-		b4writeBlock(tagFile.IndexFile, leftBlockNum, leftBlock)
-		b4writeBlock(tagFile.IndexFile, rightBlockNum, rightBlock)
-
-		// Update parent with separator key
-		return b4insertIntoParent(tagFile, leftBlockNum, separatorKey, rightBlockNum)
 	}
-	// Simple insertion into non-full block
-	leafBlock.Keys = append(leafBlock.Keys[:insertPos],
-		append([]B4Key{newKey}, leafBlock.Keys[insertPos:]...)...)
+	tag.block, tag.pos = block, pos
+	return R4Success
+}
 
-	// Write updated block to disk
-	return b4writeBlock(tagFile.IndexFile, tagFile.Header.Root, leafBlock)
+// t4current returns the entry a tag is positioned on, nil if none
+func t4current(tag *Tag4) *B4Key {
+	if tag.block == nil || tag.pos < 0 || tag.pos >= len(tag.block.Keys) {
+		return nil
+	}
+	return &tag.block.Keys[tag.pos]
 }
 
-// b4remove removes a key from the B+ tree
+// T4Key returns the key of the tag's current entry (mirrors t4key)
 //
-//nolint:unused,unparam // TODO: use recNo parameter for record-specific index entry removal
-func b4remove(tagFile *Tag4File, keyData []byte, recNo int32) int {
-	if tagFile == nil || len(keyData) == 0 {
-		return ErrorMemory
+// Returns nil if the tag is not positioned on an entry.
+func T4Key(tag *Tag4) []byte {
+	if tag == nil {
+		return nil
 	}
-
-	// In a full implementation, this would:
-	// 1. Navigate to the key's leaf block
-	// 2. Remove the key
-	// 3. Merge blocks if underflow occurs
-	// 4. Update parent blocks
-	// 5. Handle tree height changes
-
-	// For now, implement a basic removal placeholder
-	// This would need proper B+ tree deletion algorithms
-
-	// Mark the tag as modified
-	tagFile.RootWrite = true
-
-	return ErrorNone
+	if entry := t4current(tag); entry != nil && tag.changes == tag.TagFile.changes {
+		return entry.Key
+	}
+	return nil
 }
 
-// b4split splits a full B+ tree block into two blocks
+// t4syncData positions the selected tag on the entry of the current record
 //
-//nolint:unused,unparam // TODO: use tagFile parameter for block allocation and tree updates
-func b4split(tagFile *Tag4File, fullBlock *B4Block) (*B4Block, *B4Block, []byte, int) {
-	if fullBlock == nil || len(fullBlock.Keys) == 0 {
-		return nil, nil, nil, ErrorMemory
+// Returns R4Success if the record's entry was found, R4After if the tag is
+// positioned on the entry following it (the record is not in the tag),
+// R4Eof if there is no such entry.
+func t4syncData(tag *Tag4, data *Data4) int {
+	if entry := t4current(tag); entry != nil && tag.changes == tag.TagFile.changes && entry.RecNo == data.recNo {
+		return R4Success
+	}
+	expr := tag.TagFile.Expr
+	if tag.expr != nil {
+		expr = tag.expr
+	}
+	if expr == nil {
+		return ErrorIndex
+	}
+	key := t4exprKey(tag.TagFile, expr)
+	rc := t4seekEntry(tag, key, data.recNo)
+	if rc != R4Success {
+		return rc
+	}
+	if entry := t4current(tag); entry.RecNo != data.recNo || !bytes.Equal(entry.Key, key) {
+		return R4After
 	}
+	return R4Success
+}
 
-	// Find the midpoint for splitting
-	midpoint := len(fullBlock.Keys) / 2
-
-	// Create left block (keep first half)
-	leftBlock := &B4Block{
-		Keys:      make([]B4Key, midpoint),
-		BlockType: fullBlock.BlockType,
+// d4tagGo moves the record pointer to the record of the tag's current
+// entry, or to end of file when rc says the tag ran off its end
+func d4tagGo(data *Data4, tag *Tag4, rc int, forward bool) int {
+	if rc != R4Success && rc != R4Eof {
+		return rc
 	}
-	copy(leftBlock.Keys, fullBlock.Keys[:midpoint])
-
-	// Create right block (take second half)
-	rightBlock := &B4Block{
-		Keys:      make([]B4Key, len(fullBlock.Keys)-midpoint-1),
-		BlockType: fullBlock.BlockType,
+	if rc == R4Eof {
+		numRecs := data.DataFile.Header.NumRecs
+		data.appending = false
+		if forward {
+			data.atEOF, data.atBof = true, numRecs == 0
+			data.recNo = numRecs + 1
+		} else {
+			data.atBof, data.atEOF = true, numRecs == 0
+			data.recNo = 0
+		}
+		return ErrorNone
 	}
-	copy(rightBlock.Keys, fullBlock.Keys[midpoint+1:])
-
-	// The key at midpoint becomes the separator key for parent
-	separatorKey := make([]byte, len(fullBlock.Keys[midpoint].KeyData))
-	copy(separatorKey, fullBlock.Keys[midpoint].KeyData)
-
-	// Handle pointers for branch blocks
-	if fullBlock.BlockType != 1 { // Not a leaf block
-		leftBlock.Pointers = make([]int32, midpoint+1)
-		copy(leftBlock.Pointers, fullBlock.Pointers[:midpoint+1])
-
-		rightBlock.Pointers = make([]int32, len(fullBlock.Keys)-midpoint)
-		copy(rightBlock.Pointers, fullBlock.Pointers[midpoint+1:])
+	if err := D4Go(data, t4current(tag).RecNo); err != ErrorNone {
+		return err
 	}
-
-	return leftBlock, rightBlock, separatorKey, ErrorNone
+	data.atBof = false
+	return ErrorNone
 }
 
-// b4merge merges two B+ tree blocks when underflow occurs
-//
-//nolint:unused,unparam // TODO: use tagFile parameter for block management during merge
-func b4merge(tagFile *Tag4File, leftBlock, rightBlock *B4Block, separatorKey []byte) (*B4Block, int) {
-	if leftBlock == nil || rightBlock == nil {
-		return nil, ErrorMemory
+// d4tagTop positions the data on the first or last record in tag order
+func d4tagTop(data *Data4, tag *Tag4, top bool) int {
+	if err := d4updateRecord(data); err != ErrorNone {
+		return err
 	}
-
-	// Create merged block
-	mergedBlock := &B4Block{
-		BlockType: leftBlock.BlockType,
+	forward := top != T4Descending(tag)
+	rc := t4edge(tag, forward)
+	if rc == R4Eof {
+		// Empty tag: both ends of the file
+		numRecs := data.DataFile.Header.NumRecs
+		data.atEOF, data.atBof = true, true
+		data.recNo = numRecs + 1
+		return ErrorNone
 	}
+	return d4tagGo(data, tag, rc, true)
+}
 
-	// Combine keys
-	mergedBlock.Keys = make([]B4Key, len(leftBlock.Keys)+len(rightBlock.Keys))
-	copy(mergedBlock.Keys, leftBlock.Keys)
-	copy(mergedBlock.Keys[len(leftBlock.Keys):], rightBlock.Keys)
-
-	// For branch blocks, include separator key and combine pointers
-	if leftBlock.BlockType != 1 && separatorKey != nil {
-		// Insert separator key between left and right keys
-		separator := B4Key{KeyData: separatorKey}
-
-		// Reconstruct keys with separator
-		allKeys := make([]B4Key, len(leftBlock.Keys)+1+len(rightBlock.Keys))
-		copy(allKeys, leftBlock.Keys)
-		allKeys[len(leftBlock.Keys)] = separator
-		copy(allKeys[len(leftBlock.Keys)+1:], rightBlock.Keys)
-		mergedBlock.Keys = allKeys
-
-		// Combine pointers
-		mergedBlock.Pointers = make([]int32, len(leftBlock.Pointers)+len(rightBlock.Pointers))
-		copy(mergedBlock.Pointers, leftBlock.Pointers)
-		copy(mergedBlock.Pointers[len(leftBlock.Pointers):], rightBlock.Pointers)
+// d4tagSkip moves the data numRecs records in tag order
+func d4tagSkip(data *Data4, tag *Tag4, numRecs int32) int {
+	if err := d4updateRecord(data); err != ErrorNone {
+		return err
+	}
+	if numRecs == 0 {
+		return ErrorNone
 	}
 
-	return mergedBlock, ErrorNone
-}
-
-// b4updateBlockPointers updates parent block pointers after split/merge
-//
-//nolint:unused,unparam // TODO: use tagFile parameter for index file operations
-func b4updateBlockPointers(tagFile *Tag4File, parentBlock *B4Block, oldBlockNum, newBlockNum int32) int {
-	if parentBlock == nil {
-		return ErrorMemory
+	forward := numRecs > 0
+	dir := 1
+	if forward == T4Descending(tag) {
+		dir = -1
+	}
+	steps := numRecs
+	if steps < 0 {
+		steps = -steps
 	}
 
-	// Find and update the pointer
-	for i, ptr := range parentBlock.Pointers {
-		if ptr == oldBlockNum {
-			parentBlock.Pointers[i] = newBlockNum
+	var rc int
+	switch {
+	case data.atEOF || data.recNo > data.DataFile.Header.NumRecs:
+		if forward {
+			return ErrorNone
+		}
+		rc = t4edge(tag, dir > 0)
+		steps--
+	case data.recNo < 1:
+		if !forward {
 			return ErrorNone
 		}
+		rc = t4edge(tag, dir > 0)
+		steps--
+	default:
+		rc = t4syncData(tag, data)
+		if rc == R4After && dir > 0 {
+			// Already past the record, which counts as one step
+			steps--
+		} else if rc == R4Eof && dir < 0 {
+			rc = t4edge(tag, false)
+			steps--
+		}
+		if rc == R4After {
+			rc = R4Success
+		}
 	}
 
-	return ErrorData
-}
-
-// b4allocateBlock allocates a new block in the index file
-//
-//nolint:unused // Future use
-func b4allocateBlock(indexFile *Index4File) (int32, int) {
-	if indexFile == nil {
-		return 0, ErrorMemory
+	for ; rc == R4Success && steps > 0; steps-- {
+		rc = t4skipLeaf(tag, dir)
 	}
-
-	// In a full implementation, this would:
-	// 1. Check the free list for available blocks
-	// 2. Extend the file if no free blocks
-	// 3. Update file header with new allocation info
-
-	// For now, return a simple sequential block number
-	// This would need proper free space management
-	fileLen := File4Length(&indexFile.File)
-	nextBlock := int32((fileLen + CDXBlockSize - 1) / CDXBlockSize)
-
-	return nextBlock, ErrorNone
+	return d4tagGo(data, tag, rc, forward)
 }
 
-// b4freeBlock marks a block as free in the index file
+// ---------------------------------------------------------------------------
+// Seeking
+// ---------------------------------------------------------------------------
+
+// D4Seek positions the table on the first record whose key in the
+// selected tag starts with seekValue (mirrors d4seek).
+//
+// The seek value is converted to the key type of the tag: character tags
+// use it as is (a shorter value matches as a prefix), numeric tags parse
+// a number, date tags take CCYYMMDD and logical tags T or F.
 //
-//nolint:unused // Future use
-func b4freeBlock(indexFile *Index4File, blockNum int32) int {
-	if indexFile == nil || blockNum <= 0 {
+// Returns R4Success if a matching key was found, R4After if the table is
+// positioned on the next key, R4Eof if there is no following key, or
+// ErrorIndex if no tag is selected.
+func D4Seek(data *Data4, seekValue string) int {
+	if data == nil {
 		return ErrorMemory
 	}
-
-	// In a full implementation, this would:
-	// 1. Add the block to the free list
-	// 2. Update file header
-	// 3. Clear the block data
-
-	// For now, just mark as conceptually free
-	// This would need proper free list management
-
-	return ErrorNone
-}
-
-// Supporting B+ tree functions for complete implementation
-
-// CDXMaxKeysPerBlock maximum keys per block
-const CDXMaxKeysPerBlock = 30
-
-// b4findKey finds a key in the B+ tree
-//
-//nolint:unused // Future use
-func b4findKey(tagFile *Tag4File, keyData []byte) (*B4Block, int, int) {
-	if tagFile == nil || len(keyData) == 0 {
-		return nil, -1, ErrorMemory
+	tag := data.TagSelected
+	if tag == nil {
+		return setError(data.CodeBase, ErrorIndex)
 	}
-
-	// Start from root block
-	block, err := b4ReadBlock(tagFile.IndexFile, tagFile.Header.Root, tagFile.Header.KeyLen)
-	if err != ErrorNone {
-		return nil, -1, err
+	key := t4seekKey(tag.TagFile, seekValue)
+	if key == nil {
+		return setError(data.CodeBase, ErrorData)
 	}
+	return d4seekKey(data, tag, key)
+}
 
-	// Navigate to leaf
-	for block.BlockType != 1 { // While not leaf
-		// Find child pointer
-		pos, _ := b4SearchBlock(block, string(keyData))
-		if pos < len(block.Pointers) {
-			block, err = b4ReadBlock(tagFile.IndexFile, block.Pointers[pos], tagFile.Header.KeyLen)
-			if err != ErrorNone {
-				return nil, -1, err
-			}
-		} else {
-			break
-		}
+// D4SeekDouble performs numeric indexed seek (mirrors d4seekDouble)
+func D4SeekDouble(data *Data4, seekValue float64) int {
+	if data == nil {
+		return ErrorMemory
 	}
-
-	// Search in leaf block
-	pos, found := b4SearchBlock(block, string(keyData))
-	if found {
-		return block, pos, ErrorNone
+	tag := data.TagSelected
+	if tag == nil {
+		return setError(data.CodeBase, ErrorIndex)
 	}
 
-	return block, -1, ErrorData // Not found
+	if tag.TagFile.KeyType == Expr4TypeChar {
+		return D4Seek(data, strconv.FormatFloat(seekValue, 'f', -1, 64))
+	}
+	return d4seekKey(data, tag, t4doubleKey(tag.TagFile, seekValue))
 }
 
-// b4findLeafForInsert finds the leaf block where a key should be inserted
-//
-//nolint:unused // Future use
-func b4findLeafForInsert(tagFile *Tag4File, keyData []byte) (*B4Block, int) {
-	if tagFile == nil || len(keyData) == 0 {
-		return nil, ErrorMemory
+// t4doubleKey converts a number to the key format of a numeric tag
+func t4doubleKey(tagFile *Tag4File, value float64) []byte {
+	if tagFile.KeyType == Expr4TypeInteger {
+		key := make([]byte, 4)
+		t4intToFox(key, int32(value))
+		return key
 	}
+	key := make([]byte, 8)
+	t4dblToFox(key, value)
+	return key
+}
 
-	// Start from root block
-	block, err := b4ReadBlock(tagFile.IndexFile, tagFile.Header.Root, tagFile.Header.KeyLen)
-	if err != ErrorNone {
-		return nil, err
+// D4SeekN performs partial indexed seek (mirrors d4seekN)
+func D4SeekN(data *Data4, seekValue string, length int16) int {
+	if data == nil || length <= 0 {
+		return ErrorMemory
 	}
-
-	// Navigate to appropriate leaf
-	for block.BlockType != 1 { // While not leaf
-		// Find child pointer for insertion
-		pos, _ := b4SearchBlock(block, string(keyData))
-		if pos < len(block.Pointers) {
-			block, err = b4ReadBlock(tagFile.IndexFile, block.Pointers[pos], tagFile.Header.KeyLen)
-			if err != ErrorNone {
-				return nil, err
-			}
-		} else {
-			return nil, ErrorData
-		}
+	if len(seekValue) > int(length) {
+		seekValue = seekValue[:length]
 	}
-
-	return block, ErrorNone
+	return D4Seek(data, seekValue)
 }
 
-// b4findInsertPosition finds where to insert a key in a block
-//
-//nolint:unused // Future use
-func b4findInsertPosition(block *B4Block, keyData []byte) int {
-	if block == nil || len(keyData) == 0 {
-		return 0
+// D4SeekNext finds the next record with the same key as the last seek,
+// moving on from the current record (mirrors d4seekNext)
+func D4SeekNext(data *Data4, seekValue string) int {
+	if data == nil {
+		return ErrorMemory
 	}
-
-	// Binary search for insertion point
-	for i, key := range block.Keys {
-		if compareKeys(keyData, key.KeyData, int(FieldTypeChar), 0) <= 0 {
-			return i
-		}
+	tag := data.TagSelected
+	if tag == nil {
+		return setError(data.CodeBase, ErrorIndex)
 	}
-
-	return len(block.Keys) // Insert at end
+	key := t4seekKey(tag.TagFile, seekValue)
+	if key == nil {
+		return setError(data.CodeBase, ErrorData)
+	}
+	return d4seekNextKey(data, tag, key)
 }
 
-// b4insertIntoParent inserts separator key into parent after split
-//
-//nolint:unused // Future use
-//nolint:unused // Future use
-func b4insertIntoParent(tagFile *Tag4File, leftBlockNum int32, separatorKey []byte, rightBlockNum int32) int {
-	// Find parent block (simplified - would need proper parent tracking)
-	// For now, create a new root if needed
-	if tagFile.Header.Root == leftBlockNum {
-		// Create new root
-		newRoot := &B4Block{
-			BlockType: 0, // Branch block
-			Keys: []B4Key{
-				{KeyData: separatorKey},
-			},
-			Pointers: []int32{leftBlockNum, rightBlockNum},
-		}
-
-		// Allocate new root block
-		rootBlockNum, err := b4allocateBlock(tagFile.IndexFile)
-		if err != ErrorNone {
-			return err
-		}
-
-		// Write new root
-		err = b4writeBlock(tagFile.IndexFile, rootBlockNum, newRoot)
-		if err != ErrorNone {
-			return err
-		}
-
-		// Update tag header
-		tagFile.Header.Root = rootBlockNum
-		tagFile.RootWrite = true
+// D4SeekNextN finds the next record matching the first length bytes of a
+// key (mirrors d4seekNextN)
+func D4SeekNextN(data *Data4, seekValue string, length int16) int {
+	if data == nil || length <= 0 {
+		return ErrorMemory
 	}
-
-	return ErrorNone
+	if len(seekValue) > int(length) {
+		seekValue = seekValue[:length]
+	}
+	return D4SeekNext(data, seekValue)
 }
 
-// Complete b4remove implementation
-//
-//nolint:unused,unparam // TODO: use recNo parameter for complete record removal
-func b4removeComplete(tagFile *Tag4File, keyData []byte, recNo int32) int {
-	if tagFile == nil || len(keyData) == 0 {
+// D4SeekNextDouble finds the next record with the same numeric key as the
+// last seek (mirrors d4seekNextDouble)
+func D4SeekNextDouble(data *Data4, seekValue float64) int {
+	if data == nil {
 		return ErrorMemory
 	}
-
-	// Find the key to remove
-	leafBlock, keyPos, err := b4findKey(tagFile, keyData)
-	if err != ErrorNone || keyPos < 0 {
-		return ErrorData // Key not found
+	tag := data.TagSelected
+	if tag == nil {
+		return setError(data.CodeBase, ErrorIndex)
 	}
-
-	// Remove the key from leaf block
-	if keyPos < len(leafBlock.Keys) {
-		leafBlock.Keys = append(leafBlock.Keys[:keyPos], leafBlock.Keys[keyPos+1:]...)
+	if tag.TagFile.KeyType == Expr4TypeChar {
+		return D4SeekNext(data, strconv.FormatFloat(seekValue, 'f', -1, 64))
 	}
+	return d4seekNextKey(data, tag, t4doubleKey(tag.TagFile, seekValue))
+}
 
-	// Check for underflow (simplified)
-	minKeys := CDXMaxKeysPerBlock / 2
-	if len(leafBlock.Keys) < minKeys {
-		// Would need to implement merge or redistribute
-		// For now, just write the block back
+// d4seekNextKey moves to the next record with a key, or seeks the key when
+// the table is not on one
+func d4seekNextKey(data *Data4, tag *Tag4, key []byte) int {
+	if data.recNo < 1 || data.atEOF || t4syncData(tag, data) != R4Success || !bytes.HasPrefix(T4Key(tag), key) {
+		return d4seekKey(data, tag, key)
 	}
 
-	// Write updated block to disk
-	return b4writeBlock(tagFile.IndexFile, tagFile.Header.Root, leafBlock)
+	if err := d4tagSkip(data, tag, 1); err != ErrorNone {
+		return err
+	}
+	if data.atEOF {
+		data.lastSeekFound = false
+		return R4Eof
+	}
+	data.lastSeekFound = bytes.HasPrefix(T4Key(tag), key)
+	if data.lastSeekFound {
+		return R4Success
+	}
+	return R4After
 }
 
-// Enhanced t4buildTree with proper B+ tree construction
+// D4GoPosition positions the database at a specific record number
 //
-//nolint:unused // Future use
-func t4buildTreeComplete(tagFile *Tag4File, keys []IndexKey) int {
-	if len(keys) == 0 {
-		return ErrorNone
+// Deprecated: use D4Go.
+func D4GoPosition(data *Data4, recNo int32) int {
+	if data == nil || recNo <= 0 {
+		return ErrorMemory
 	}
+	return D4Go(data, recNo)
+}
 
-	// Create leaf blocks
-	leafBlocks := make([]*B4Block, 0)
-	currentBlock := &B4Block{
-		BlockType: 1, // Leaf block
-		Keys:      make([]B4Key, 0, CDXMaxKeysPerBlock),
+// D4Found checks if last seek was successful (mirrors found())
+func D4Found(data *Data4) bool {
+	if data == nil {
+		return false
 	}
+	return data.lastSeekFound
+}
+
+// t4seekKey converts a seek string to the key format of a tag
+func t4seekKey(tagFile *Tag4File, seekValue string) []byte {
+	keyLen := int(tagFile.Header.KeyLen)
 
-	for _, key := range keys {
-		if len(currentBlock.Keys) >= CDXMaxKeysPerBlock {
-			// Block is full, start new one
-			leafBlocks = append(leafBlocks, currentBlock)
-			currentBlock = &B4Block{
-				BlockType: 1,
-				Keys:      make([]B4Key, 0, CDXMaxKeysPerBlock),
+	switch tagFile.KeyType {
+	case Expr4TypeChar:
+		if len(seekValue) > keyLen {
+			seekValue = seekValue[:keyLen]
+		}
+		return []byte(seekValue)
+	case Expr4TypeLogical:
+		switch strings.ToUpper(strings.TrimSpace(seekValue)) {
+		case "T", "Y", ".T.", "TRUE":
+			return []byte{'T'}
+		case "F", "N", ".F.", "FALSE":
+			return []byte{'F'}
+		}
+		return nil
+	case Expr4TypeInteger:
+		value, err := strconv.ParseFloat(strings.TrimSpace(seekValue), 64)
+		if err != nil {
+			return nil
+		}
+		key := make([]byte, 4)
+		t4intToFox(key, int32(value))
+		return key
+	case Expr4TypeDate:
+		julian := Date4Long(strings.TrimSpace(seekValue))
+		key := make([]byte, 8)
+		t4dblToFox(key, float64(julian))
+		return key
+	case Expr4TypeDateTime:
+		value := strings.TrimSpace(seekValue)
+		key := make([]byte, 8)
+		for _, layout := range []string{"2006-01-02 15:04:05", "2006-01-02T15:04:05", "20060102150405", "20060102"} {
+			if t, err := time.Parse(layout, value); err == nil {
+				midnight := t.Truncate(24 * time.Hour)
+				t4dblToFox(key, float64(date4JulianFromTime(t))+float64(t.Sub(midnight).Milliseconds())/86400000)
+				return key
 			}
 		}
-
-		// Add key to current block
-		currentBlock.Keys = append(currentBlock.Keys, B4Key{
-			KeyData: key.KeyData,
-			RecNo:   key.RecNo,
-		})
+		return nil
 	}
 
-	// Add final block if it has keys
-	if len(currentBlock.Keys) > 0 {
-		leafBlocks = append(leafBlocks, currentBlock)
+	value, err := strconv.ParseFloat(strings.TrimSpace(seekValue), 64)
+	if err != nil {
+		return nil
 	}
+	key := make([]byte, 8)
+	t4dblToFox(key, value)
+	return key
+}
 
-	// Write leaf blocks to disk
-	leafBlockNums := make([]int32, len(leafBlocks))
-	for i, block := range leafBlocks {
-		blockNum, err := b4allocateBlock(tagFile.IndexFile)
-		if err != ErrorNone {
-			return err
-		}
-		leafBlockNums[i] = blockNum
-
-		err = b4writeBlock(tagFile.IndexFile, blockNum, block)
-		if err != ErrorNone {
-			return err
-		}
+// d4seekKey positions the table on the first record, in tag order, whose
+// key starts with key
+func d4seekKey(data *Data4, tag *Tag4, key []byte) int {
+	if err := d4updateRecord(data); err != ErrorNone {
+		return err
 	}
+	data.lastSeekFound = false
 
-	// Build branch levels if needed
-	if len(leafBlocks) == 1 {
-		// Single leaf block becomes root
-		tagFile.Header.Root = leafBlockNums[0]
+	var rc int
+	if !T4Descending(tag) {
+		rc = t4seekEntry(tag, key, 0)
 	} else {
-		// Build branch levels
-		err := t4buildBranchLevels(tagFile, leafBlocks, leafBlockNums)
-		if err != ErrorNone {
-			return err
+		// Last stored entry not greater than key: find the first entry
+		// above every key with this prefix and step back
+		upper := make([]byte, tag.TagFile.Header.KeyLen)
+		copy(upper, key)
+		for i := len(key); i < len(upper); i++ {
+			upper[i] = 0xFF
+		}
+		rc = t4seekEntry(tag, upper, math.MaxInt32)
+		switch rc {
+		case R4Success:
+			rc = t4skipLeaf(tag, -1)
+		case R4Eof:
+			rc = t4edge(tag, false)
 		}
 	}
 
-	return ErrorNone
-}
-
-// t4buildBranchLevels builds branch levels of B+ tree
-//
-//nolint:unused // Future use
-func t4buildBranchLevels(tagFile *Tag4File, leafBlocks []*B4Block, blockNums []int32) int {
-	// Build parent level from leaf blocks
-	currentLevel := blockNums
-	levelBlocks := leafBlocks
-
-	for len(currentLevel) > 1 {
-		nextLevel := make([]int32, 0)
-		nextLevelBlocks := make([]*B4Block, 0)
-
-		// Group blocks into parent nodes
-		for i := 0; i < len(currentLevel); i += CDXMaxKeysPerBlock {
-			parentBlock := &B4Block{
-				BlockType: 0, // Branch block
-				Keys:      make([]B4Key, 0),
-				Pointers:  make([]int32, 0),
-			}
-
-			// Add pointers to children
-			end := i + CDXMaxKeysPerBlock
-			if end > len(currentLevel) {
-				end = len(currentLevel)
-			}
-
-			for j := i; j < end; j++ {
-				parentBlock.Pointers = append(parentBlock.Pointers, currentLevel[j])
-
-				// Add separator key (first key of child block)
-				if j < end-1 && len(levelBlocks[j].Keys) > 0 {
-					parentBlock.Keys = append(parentBlock.Keys, B4Key{
-						KeyData: levelBlocks[j+1].Keys[0].KeyData,
-					})
-				}
-			}
-
-			// Allocate and write parent block
-			blockNum, err := b4allocateBlock(tagFile.IndexFile)
-			if err != ErrorNone {
-				return err
-			}
-
-			err = b4writeBlock(tagFile.IndexFile, blockNum, parentBlock)
-			if err != ErrorNone {
-				return err
-			}
-
-			nextLevel = append(nextLevel, blockNum)
-			nextLevelBlocks = append(nextLevelBlocks, parentBlock)
+	if rc != R4Success {
+		if rc == R4Eof {
+			d4tagGo(data, tag, R4Eof, true)
+			return R4Eof
 		}
-
-		currentLevel = nextLevel
-		levelBlocks = nextLevelBlocks
+		return rc
 	}
 
-	// Set root to the final single block
-	if len(currentLevel) == 1 {
-		tagFile.Header.Root = currentLevel[0]
+	if err := d4tagGo(data, tag, rc, true); err != ErrorNone {
+		return err
 	}
-
-	return ErrorNone
+	if bytes.HasPrefix(t4current(tag).Key, key) {
+		data.lastSeekFound = true
+		return R4Success
+	}
+	return R4After
 }
-
-// data4FromLink and data4FileFromLink are already defined in code4.go
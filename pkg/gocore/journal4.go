@@ -0,0 +1,186 @@
+// Package pkg - Transaction journal functions
+// Durable before-image journal backing the CODE4 transaction log
+package pkg
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Transaction journal layout. The header holds the record count of the
+// table when the transaction first changed it, so appended records can be
+// cut off again. Each entry that follows holds a record number and the
+// record as it was before it was overwritten.
+const (
+	journal4Magic     = "FXJ1"
+	journal4HeaderLen = 16 // Magic, status, reserved, record length, header length, record count
+
+	journal4Active    = 0 // Transaction in progress, rolled back when the table is opened
+	journal4Committed = 1 // Transaction committed, discarded when the table is opened
+)
+
+// d4journalName returns the path of the transaction journal of a table
+func d4journalName(dbfPath string) string {
+	base := strings.TrimSuffix(dbfPath, filepath.Ext(dbfPath))
+	if strings.HasSuffix(filepath.Ext(dbfPath), "DBF") {
+		return base + ".JNL"
+	}
+	return base + ".jnl"
+}
+
+// d4journalStart creates the journal of a table the first time the active
+// transaction changes it. The journal is on disk before any change it
+// covers is written to the table.
+func d4journalStart(data *Data4) int {
+	if !d4transActive(data) || data.DataFile.Journal != nil {
+		return ErrorNone
+	}
+
+	dataFile := data.DataFile
+	journal := &File4{}
+	if err := File4Create(journal, data.CodeBase, d4journalName(dataFile.File.Name), 0); err != ErrorNone {
+		return err
+	}
+	journal.IsTemp = false
+
+	header := make([]byte, journal4HeaderLen)
+	copy(header[0:4], journal4Magic)
+	header[4] = journal4Active
+	binary.LittleEndian.PutUint16(header[8:10], dataFile.RecordLen)
+	binary.LittleEndian.PutUint16(header[10:12], dataFile.Header.HeaderLen)
+	binary.LittleEndian.PutUint32(header[12:16], uint32(dataFile.Header.NumRecs))
+
+	err := File4Write(journal, 0, header, journal4HeaderLen)
+	if err == ErrorNone {
+		err = File4Flush(journal)
+	}
+	if err != ErrorNone {
+		File4Close(journal)
+		os.Remove(journal.Name)
+		return err
+	}
+
+	dataFile.Journal = journal
+	return ErrorNone
+}
+
+// d4journalWrite adds the before-image of a record to the journal and
+// forces it to disk
+func d4journalWrite(data *Data4, recNo int32, oldRecord []byte) int {
+	if err := d4journalStart(data); err != ErrorNone {
+		return err
+	}
+	journal := data.DataFile.Journal
+	if journal == nil {
+		return ErrorNone
+	}
+
+	entry := make([]byte, 4+len(oldRecord))
+	binary.LittleEndian.PutUint32(entry[0:4], uint32(recNo))
+	copy(entry[4:], oldRecord)
+	if err := File4Write(journal, File4Length(journal), entry, uint32(len(entry))); err != ErrorNone {
+		return err
+	}
+	return File4Flush(journal)
+}
+
+// d4journalCommit marks the journal of a table as committed. The table
+// must already be flushed.
+func d4journalCommit(dataFile *Data4File) int {
+	if dataFile.Journal == nil {
+		return ErrorNone
+	}
+	if err := File4Write(dataFile.Journal, 4, []byte{journal4Committed}, 1); err != ErrorNone {
+		return err
+	}
+	return File4Flush(dataFile.Journal)
+}
+
+// d4journalDiscard closes and removes the journal of a table
+func d4journalDiscard(dataFile *Data4File) {
+	if dataFile.Journal == nil {
+		return
+	}
+	File4Close(dataFile.Journal)
+	os.Remove(dataFile.Journal.Name)
+	dataFile.Journal = nil
+}
+
+// d4journalClose closes the journal of a table but leaves it on disk, so
+// the unfinished transaction is rolled back when the table is next opened
+func d4journalClose(dataFile *Data4File) {
+	if dataFile.Journal == nil {
+		return
+	}
+	File4Close(dataFile.Journal)
+	dataFile.Journal = nil
+}
+
+// d4journalRecover finishes a transaction left behind by a process that
+// stopped before committing or rolling back. An uncommitted journal is
+// replayed onto the table before its header is read: before-images are
+// restored newest first and records appended by the transaction are cut
+// off. A committed or incomplete journal is discarded. The append lock
+// died with the process, so records other users appended since then would
+// be cut off too; tables must be reopened before they are shared again.
+//
+// Returns whether the table was rolled back, so its indexes can be rebuilt.
+func d4journalRecover(dataFile *Data4File) (bool, int) {
+	name := d4journalName(dataFile.File.Name)
+	raw, err := os.ReadFile(name)
+	if err != nil {
+		return false, ErrorNone // No journal
+	}
+
+	// A journal without a complete header was never followed by a change
+	if len(raw) < journal4HeaderLen || string(raw[0:4]) != journal4Magic || raw[4] == journal4Committed {
+		os.Remove(name)
+		return false, ErrorNone
+	}
+
+	recordLen := binary.LittleEndian.Uint16(raw[8:10])
+	headerLen := binary.LittleEndian.Uint16(raw[10:12])
+	numRecs := int32(binary.LittleEndian.Uint32(raw[12:16]))
+
+	header := make([]byte, 32)
+	if File4Read(&dataFile.File, 0, header, 32) != 32 ||
+		binary.LittleEndian.Uint16(header[8:10]) != headerLen ||
+		binary.LittleEndian.Uint16(header[10:12]) != recordLen {
+		return false, ErrorData
+	}
+
+	// A torn last entry was never followed by its write to the table
+	entryLen := 4 + int(recordLen)
+	count := (len(raw) - journal4HeaderLen) / entryLen
+	for i := count - 1; i >= 0; i-- {
+		entry := raw[journal4HeaderLen+i*entryLen : journal4HeaderLen+(i+1)*entryLen]
+		recNo := int32(binary.LittleEndian.Uint32(entry[0:4]))
+		if recNo < 1 || recNo > numRecs {
+			continue
+		}
+		pos := File4Long(headerLen) + File4Long(recNo-1)*File4Long(recordLen)
+		if err := File4Write(&dataFile.File, pos, entry[4:], uint32(recordLen)); err != ErrorNone {
+			return false, err
+		}
+	}
+
+	binary.LittleEndian.PutUint32(header[4:8], uint32(numRecs))
+	if err := File4Write(&dataFile.File, 0, header, 32); err != ErrorNone {
+		return false, err
+	}
+	end := File4Long(headerLen) + File4Long(numRecs)*File4Long(recordLen)
+	if err := File4Truncate(&dataFile.File, end); err != ErrorNone {
+		return false, err
+	}
+	if err := File4Write(&dataFile.File, end, []byte{0x1A}, 1); err != ErrorNone {
+		return false, err
+	}
+	if err := File4Flush(&dataFile.File); err != ErrorNone {
+		return false, err
+	}
+
+	os.Remove(name)
+	return true, ErrorNone
+}
@@ -3,346 +3,585 @@
 package pkg
 
 import (
+	"encoding/binary"
+	"fmt"
+	"os"
 	"sync"
-	"syscall"
 	"time"
 )
 
+// Visual FoxPro lock offsets (L4LOCK_POS and L4LOCK_POS_OLD for S4FOX).
+// Locks are byte-range locks far beyond the end of the file, so they
+// never stop other users from reading or writing the data itself:
+//   - record n is locked on the byte at Lock4Pos - n
+//   - the append lock is the byte at Lock4Pos
+//   - the file lock covers Lock4PosOld through Lock4Pos, overlapping
+//     every record lock and the append lock
+//
+// Index files are locked on the byte at Lock4Pos.
+const (
+	Lock4Pos    = 0x7FFFFFFE
+	Lock4PosOld = 0x40000000
+
+	Wait4Ever = -1 // LockAttempts value that retries until the lock is granted
+)
+
 // File locking states
 const (
 	LockNone = iota
 	LockFile
 	LockRecord
+	LockAppend
 )
 
-// FileLock represents a file lock state
+// FileLock represents a lock held on a byte range of a file
 type FileLock struct {
 	File     *File4
 	LockType int
 	StartPos int64
 	Length   int64
-	Timeout  time.Duration
+
+	owner *LockManager // Manager of the CODE4 holding the lock
+	id    os.FileInfo  // Identity of the locked file, nil if unknown
 }
 
-// Global lock manager
-var (
-	lockManager = &LockManager{
-		locks:   make(map[string]*FileLock),
-		mutex:   &sync.RWMutex{},
-		timeout: 5 * time.Second,
+// LockManager keeps track of the locks held through the files of one
+// CODE4, and retries them as its LockAttempts and LockDelay say. Each
+// CODE4 has its own (see Code4Locks).
+type LockManager struct {
+	codeBase *Code4
+	locks    map[string]*FileLock // Guarded by the mutex of lock4table
+}
+
+// lock4table holds every lock taken in the process. Operating system
+// byte-range locks are owned by the process, so they do not keep two
+// handles of the same file in one process apart, whether the handles
+// belong to one CODE4 or to two. Checking the table first makes the
+// handles conflict with each other as they would in separate processes.
+var lock4table struct {
+	mutex sync.Mutex
+	locks []*FileLock
+}
+
+// Code4Locks returns the lock manager of a CODE4, which reports the locks
+// held through its files. There is no CodeBase equivalent.
+//
+// Returns nil if cb is nil.
+func Code4Locks(cb *Code4) *LockManager {
+	if cb == nil {
+		return nil
 	}
-)
+	if cb.locks == nil {
+		cb.locks = &LockManager{codeBase: cb, locks: make(map[string]*FileLock)}
+	}
+	return cb.locks
+}
 
-// LockManager manages file and record locks
-type LockManager struct {
-	locks   map[string]*FileLock
-	mutex   *sync.RWMutex
-	timeout time.Duration
+// d4locks returns the lock manager of the CODE4 a table was opened with
+func d4locks(data *Data4) *LockManager {
+	if data.CodeBase == nil {
+		return &LockManager{locks: make(map[string]*FileLock)}
+	}
+	return Code4Locks(data.CodeBase)
 }
 
-// D4LockFile locks entire database file (mirrors d4lockFile)
-func D4LockFile(data *Data4) int {
-	if data == nil || data.DataFile == nil {
+// lock4key returns the registry key of a lock
+func lock4key(file *File4, startPos int64) string {
+	return fmt.Sprintf("%s:%d", file.Name, startPos)
+}
+
+// lock4recordPos returns the lock position of a record
+func lock4recordPos(recNo int32) int64 {
+	return Lock4Pos - int64(recNo)
+}
+
+// D4Lock locks the current record
+//
+// Deprecated: use D4LockRecord, which takes the record number as d4lock
+// does.
+func D4Lock(data *Data4) int {
+	if data == nil {
 		return ErrorMemory
 	}
+	return D4LockRecord(data, data.recNo)
+}
 
-	return lockManager.LockFile(&data.DataFile.File)
+// D4LockRecord locks a record (mirrors d4lock)
+//
+// The lock is retried as set by the CODE4 LockAttempts and LockDelay.
+// Locking a record that is already locked through this table, or while
+// holding the file lock, succeeds at once.
+//
+// Returns ErrorNone when the lock is held, R4Locked when another user
+// holds it, ErrorLock if the operating system refused the lock.
+func D4LockRecord(data *Data4, recNo int32) int {
+	if data == nil || data.DataFile == nil || recNo < 1 {
+		return ErrorMemory
+	}
+	return d4locks(data).Lock(&data.DataFile.File, LockRecord, lock4recordPos(recNo), 1)
 }
 
-// D4UnlockFile unlocks database file (mirrors d4unlockFile)
-func D4UnlockFile(data *Data4) int {
+// D4LockAppend locks the table for appending (mirrors d4lockAppend)
+//
+// While the append lock is held no other user can add records. D4Append
+// takes it by itself for the duration of the append.
+func D4LockAppend(data *Data4) int {
 	if data == nil || data.DataFile == nil {
 		return ErrorMemory
 	}
-
-	return lockManager.UnlockFile(&data.DataFile.File)
+	return d4locks(data).Lock(&data.DataFile.File, LockAppend, Lock4Pos, 1)
 }
 
-// D4Lock locks current record (mirrors d4lock)
-func D4Lock(data *Data4) int {
-	if data == nil || data.DataFile == nil || data.recNo <= 0 {
+// D4LockFile locks entire database file (mirrors d4lockFile)
+//
+// The file lock conflicts with every record lock and with the append lock
+// held by other users.
+func D4LockFile(data *Data4) int {
+	if data == nil || data.DataFile == nil {
 		return ErrorMemory
 	}
-
-	// Calculate record position
-	headerLen := int64(data.DataFile.Header.HeaderLen)
-	recordLen := int64(data.DataFile.RecordLen)
-	startPos := headerLen + ((int64(data.recNo) - 1) * recordLen)
-
-	return lockManager.LockRange(&data.DataFile.File, startPos, recordLen)
+	return d4locks(data).Lock(&data.DataFile.File, LockFile, Lock4PosOld, Lock4Pos-Lock4PosOld+1)
 }
 
-// D4Unlock unlocks current record (mirrors d4unlock)
-func D4Unlock(data *Data4) int {
-	if data == nil || data.DataFile == nil || data.recNo <= 0 {
+// D4LockAll locks the table and its index files (mirrors d4lockAll)
+func D4LockAll(data *Data4) int {
+	if data == nil {
 		return ErrorMemory
 	}
 
-	// Calculate record position
-	headerLen := int64(data.DataFile.Header.HeaderLen)
-	recordLen := int64(data.DataFile.RecordLen)
-	startPos := headerLen + ((int64(data.recNo) - 1) * recordLen)
+	err := D4LockFile(data)
+	if err != ErrorNone {
+		return err
+	}
 
-	return lockManager.UnlockRange(&data.DataFile.File, startPos, recordLen)
+	first := list4First(&data.Indexes)
+	for current := first; current != nil; {
+		index := indexFromLink(current)
+		if index != nil && index.IndexFile != nil {
+			err = d4locks(data).Lock(&index.IndexFile.File, LockFile, Lock4Pos, 1)
+			if err != ErrorNone {
+				D4Unlock(data)
+				return err
+			}
+		}
+		current = list4Next(&data.Indexes, current)
+		if current == first {
+			break // Circular list, back to start
+		}
+	}
+
+	return ErrorNone
 }
 
-// LockFile implements file-level locking
-func (lm *LockManager) LockFile(file *File4) int {
-	if file == nil || file.Handle == nil {
+// D4Unlock releases every lock held on the table and its index files
+// (mirrors d4unlock)
+func D4Unlock(data *Data4) int {
+	if data == nil || data.DataFile == nil {
 		return ErrorMemory
 	}
 
-	lm.mutex.Lock()
-	defer lm.mutex.Unlock()
-
-	filePath := file.Name
+	result := d4locks(data).UnlockAll(&data.DataFile.File)
+	data.appendLocked = false
 
-	// Check if already locked
-	if _, exists := lm.locks[filePath]; exists {
-		return ErrorData // Already locked
+	first := list4First(&data.Indexes)
+	for current := first; current != nil; {
+		index := indexFromLink(current)
+		if index != nil && index.IndexFile != nil {
+			if err := d4locks(data).UnlockAll(&index.IndexFile.File); err != ErrorNone && result == ErrorNone {
+				result = err
+			}
+		}
+		current = list4Next(&data.Indexes, current)
+		if current == first {
+			break
+		}
 	}
 
-	// Apply system-level file lock
-	err := syscall.Flock(int(file.Handle.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
-	if err != nil {
-		return ErrorOpen // Lock failed
-	}
+	return result
+}
 
-	// Record the lock
-	lm.locks[filePath] = &FileLock{
-		File:     file,
-		LockType: LockFile,
-		StartPos: 0,
-		Length:   -1, // Entire file
-		Timeout:  lm.timeout,
+// D4UnlockAll releases every lock held on the table and its index files
+//
+// Deprecated: use D4Unlock, which does the same as d4unlock.
+func D4UnlockAll(data *Data4) int {
+	return D4Unlock(data)
+}
+
+// D4UnlockRecord releases the lock on a single record (mirrors d4unlockRecord)
+func D4UnlockRecord(data *Data4, recNo int32) int {
+	if data == nil || data.DataFile == nil || recNo < 1 {
+		return ErrorMemory
 	}
+	return d4locks(data).Unlock(&data.DataFile.File, lock4recordPos(recNo))
+}
 
-	return ErrorNone
+// D4UnlockAppend releases the append lock
+func D4UnlockAppend(data *Data4) int {
+	if data == nil || data.DataFile == nil {
+		return ErrorMemory
+	}
+	data.appendLocked = false
+	return d4locks(data).Unlock(&data.DataFile.File, Lock4Pos)
 }
 
-// UnlockFile removes file-level lock
-func (lm *LockManager) UnlockFile(file *File4) int {
-	if file == nil || file.Handle == nil {
+// D4UnlockFile releases the file lock (mirrors d4unlockFile)
+func D4UnlockFile(data *Data4) int {
+	if data == nil || data.DataFile == nil {
 		return ErrorMemory
 	}
+	return d4locks(data).Unlock(&data.DataFile.File, Lock4PosOld)
+}
 
-	lm.mutex.Lock()
-	defer lm.mutex.Unlock()
+// D4LockTest reports whether a record is locked through this table,
+// either by a record lock or by the file lock (mirrors d4lockTest)
+func D4LockTest(data *Data4, recNo int32) bool {
+	if data == nil || data.DataFile == nil || recNo < 1 {
+		return false
+	}
+	return d4locks(data).Held(&data.DataFile.File, lock4recordPos(recNo), 1)
+}
 
-	filePath := file.Name
+// D4LockTestAppend reports whether the append lock is held through this
+// table (mirrors d4lockTestAppend)
+func D4LockTestAppend(data *Data4) bool {
+	if data == nil || data.DataFile == nil {
+		return false
+	}
+	return d4locks(data).Held(&data.DataFile.File, Lock4Pos, 1)
+}
 
-	// Check if locked
-	if _, exists := lm.locks[filePath]; !exists {
-		return ErrorData // Not locked
+// D4LockTestFile reports whether the file lock is held through this table
+// (mirrors d4lockTestFile)
+func D4LockTestFile(data *Data4) bool {
+	if data == nil || data.DataFile == nil {
+		return false
 	}
+	return d4locks(data).Held(&data.DataFile.File, Lock4PosOld, Lock4Pos-Lock4PosOld+1)
+}
 
-	// Remove system-level file lock
-	err := syscall.Flock(int(file.Handle.Fd()), syscall.LOCK_UN)
-	if err != nil {
-		return ErrorClose // Unlock failed
+// D4IsLocked checks if the current record is locked (mirrors d4isLocked)
+func D4IsLocked(data *Data4) bool {
+	if data == nil {
+		return false
 	}
+	return D4LockTest(data, data.recNo)
+}
 
-	// Remove from lock registry
-	delete(lm.locks, filePath)
+// d4lockAppendAuto takes the append lock for D4Append when the caller
+// does not hold it, then refreshes the record count, which other users
+// may have changed. Returns whether the lock must be released afterwards.
+func d4lockAppendAuto(data *Data4) (bool, int) {
+	release := false
+	if !D4LockTestAppend(data) {
+		if err := D4LockAppend(data); err != ErrorNone {
+			return false, err
+		}
+		// Inside a transaction the lock is kept until it ends, so that
+		// no other user appends behind records that may be rolled back
+		if d4transActive(data) {
+			data.appendLocked = true
+		} else {
+			release = true
+		}
+	}
 
-	return ErrorNone
+	dataFile := data.DataFile
+	count := make([]byte, 4)
+	if File4Read(&dataFile.File, 4, count, 4) == 4 {
+		if numRecs := int32(binary.LittleEndian.Uint32(count)); numRecs > dataFile.Header.NumRecs {
+			dataFile.Header.NumRecs = numRecs
+		}
+	}
+	return release, ErrorNone
 }
 
-// LockRange implements range locking for records
-func (lm *LockManager) LockRange(file *File4, startPos, length int64) int {
+// Lock locks length bytes at startPos of a file, retrying as set by
+// the LockAttempts and LockDelay of the manager's CODE4 (a single try
+// without one).
+//
+// Returns ErrorNone when the range is locked, R4Locked when another user
+// holds a conflicting lock, ErrorLock if the operating system refused
+// the lock.
+func (lm *LockManager) Lock(file *File4, lockType int, startPos, length int64) int {
 	if file == nil || file.Handle == nil {
 		return ErrorMemory
 	}
 
-	lm.mutex.Lock()
-	defer lm.mutex.Unlock()
+	if lm.locks == nil {
+		lm.locks = make(map[string]*FileLock)
+	}
 
-	lockKey := file.Name + ":" + string(rune(startPos))
+	attempts, delay := 1, time.Duration(0)
+	if cb := lm.codeBase; cb != nil {
+		attempts = cb.LockAttempts
+		delay = time.Duration(cb.LockDelay) * 10 * time.Millisecond
+	}
 
-	// Check if range already locked
-	if _, exists := lm.locks[lockKey]; exists {
-		return ErrorData // Range already locked
+	id := file4identity(file)
+	for try := 1; ; try++ {
+		rc := lm.tryLock(file, id, lockType, startPos, length)
+		if rc != R4Locked {
+			return rc
+		}
+		if attempts != Wait4Ever && try >= attempts {
+			return R4Locked
+		}
+		time.Sleep(delay)
 	}
+}
 
-	// Apply system-level range lock (fcntl-style)
-	fd := int(file.Handle.Fd())
+// tryLock makes a single attempt at locking a range
+func (lm *LockManager) tryLock(file *File4, id os.FileInfo, lockType int, startPos, length int64) int {
+	lock4table.mutex.Lock()
+	defer lock4table.mutex.Unlock()
 
-	// Use flock for simplicity (would use fcntl with F_SETLK for precise ranges)
-	err := syscall.Flock(fd, syscall.LOCK_EX|syscall.LOCK_NB)
-	if err != nil {
-		return ErrorOpen // Lock failed
+	// A range covered by a lock of the same handle is already held, one
+	// overlapping a lock of another handle is held by another user
+	for _, lock := range lock4table.locks {
+		if !lock4sameFile(lock, file, id) || !lock4overlap(lock, startPos, length) {
+			continue
+		}
+		if lock.File != file {
+			return R4Locked
+		}
+		if lock.StartPos <= startPos && startPos+length <= lock.StartPos+lock.Length {
+			return ErrorNone
+		}
+	}
+
+	if rc := file4lock(file, startPos, length); rc != ErrorNone {
+		return rc
 	}
 
-	// Record the range lock
-	lm.locks[lockKey] = &FileLock{
+	// Locks inside the new range are merged into it by the operating
+	// system and must not be released on their own later
+	lock4forget(func(lock *FileLock) bool {
+		return lock.File == file && startPos <= lock.StartPos && lock.StartPos+lock.Length <= startPos+length
+	})
+
+	lock := &FileLock{
 		File:     file,
-		LockType: LockRecord,
+		LockType: lockType,
 		StartPos: startPos,
 		Length:   length,
-		Timeout:  lm.timeout,
+		owner:    lm,
+		id:       id,
 	}
-
+	lm.locks[lock4key(file, startPos)] = lock
+	lock4table.locks = append(lock4table.locks, lock)
 	return ErrorNone
 }
 
-// UnlockRange removes range lock
-func (lm *LockManager) UnlockRange(file *File4, startPos, length int64) int {
+// Unlock releases the lock that starts at startPos
+func (lm *LockManager) Unlock(file *File4, startPos int64) int {
 	if file == nil || file.Handle == nil {
 		return ErrorMemory
 	}
 
-	lm.mutex.Lock()
-	defer lm.mutex.Unlock()
-
-	lockKey := file.Name + ":" + string(rune(startPos))
+	lock4table.mutex.Lock()
+	defer lock4table.mutex.Unlock()
 
-	// Check if range locked
-	if _, exists := lm.locks[lockKey]; !exists {
-		return ErrorData // Range not locked
+	lock, exists := lm.locks[lock4key(file, startPos)]
+	if !exists || lock.File != file {
+		return ErrorNone // Not locked, as in CodeBase this is not an error
 	}
 
-	// Remove system-level range lock
-	fd := int(file.Handle.Fd())
-	err := syscall.Flock(fd, syscall.LOCK_UN)
-	if err != nil {
-		return ErrorClose // Unlock failed
-	}
-
-	// Remove from lock registry
-	delete(lm.locks, lockKey)
-
-	return ErrorNone
+	lock4forget(func(other *FileLock) bool { return other == lock })
+	return file4unlock(file, lock.StartPos, lock.Length)
 }
 
-// D4IsLocked checks if record is locked (mirrors d4isLocked)
-func D4IsLocked(data *Data4) bool {
-	if data == nil || data.DataFile == nil || data.recNo <= 0 {
-		return false
-	}
-
-	// Calculate record position
-	headerLen := int64(data.DataFile.Header.HeaderLen)
-	recordLen := int64(data.DataFile.RecordLen)
-	startPos := headerLen + ((int64(data.recNo) - 1) * recordLen)
+// LockRange locks length bytes at startPos of a file as a record lock
+//
+// Deprecated: use Lock, which takes the kind of lock.
+func (lm *LockManager) LockRange(file *File4, startPos, length int64) int {
+	return lm.Lock(file, LockRecord, startPos, length)
+}
 
-	lockKey := data.DataFile.File.Name + ":" + string(rune(startPos))
+// UnlockRange releases the lock that starts at startPos
+//
+// Deprecated: use Unlock. The length is that of the lock.
+func (lm *LockManager) UnlockRange(file *File4, startPos, length int64) int {
+	return lm.Unlock(file, startPos)
+}
 
-	lockManager.mutex.RLock()
-	defer lockManager.mutex.RUnlock()
+// LockFile locks a whole file, as D4LockFile locks a table
+//
+// Deprecated: use Lock with LockFile.
+func (lm *LockManager) LockFile(file *File4) int {
+	return lm.Lock(file, LockFile, Lock4PosOld, Lock4Pos-Lock4PosOld+1)
+}
 
-	_, locked := lockManager.locks[lockKey]
-	return locked
+// UnlockFile releases the lock taken by LockFile
+//
+// Deprecated: use Unlock.
+func (lm *LockManager) UnlockFile(file *File4) int {
+	return lm.Unlock(file, Lock4PosOld)
 }
 
-// D4LockAll locks entire database for exclusive access (mirrors d4lockAll)
-func D4LockAll(data *Data4) int {
-	if data == nil {
+// UnlockAll releases every lock held through a file handle
+func (lm *LockManager) UnlockAll(file *File4) int {
+	if file == nil || file.Handle == nil {
 		return ErrorMemory
 	}
 
-	// Lock the data file
-	err := D4LockFile(data)
-	if err != ErrorNone {
-		return err
-	}
+	lock4table.mutex.Lock()
+	defer lock4table.mutex.Unlock()
 
-	// Lock all associated index files
-	current := list4First(&data.Indexes)
-	for current != nil {
-		index := indexFromLink(current)
-		if index != nil && index.IndexFile != nil {
-			indexErr := lockManager.LockFile(&index.IndexFile.File)
-			if indexErr != ErrorNone {
-				// Rollback locks on failure
-				D4UnlockAll(data)
-				return indexErr
-			}
+	result := ErrorNone
+	for _, lock := range lock4forget(func(lock *FileLock) bool { return lock.owner == lm && lock.File == file }) {
+		if err := file4unlock(file, lock.StartPos, lock.Length); err != ErrorNone && result == ErrorNone {
+			result = err
 		}
+	}
+	return result
+}
 
-		// Move to next
-		current = list4Next(&data.Indexes, current)
-		if current == list4First(&data.Indexes) {
-			break // Circular list, back to start
+// Held reports whether a range is covered by a lock of the file handle
+func (lm *LockManager) Held(file *File4, startPos, length int64) bool {
+	lock4table.mutex.Lock()
+	defer lock4table.mutex.Unlock()
+
+	for _, lock := range lm.locks {
+		if lock.File == file && lock.StartPos <= startPos && startPos+length <= lock.StartPos+lock.Length {
+			return true
 		}
 	}
-
-	return ErrorNone
+	return false
 }
 
-// D4UnlockAll unlocks entire database (mirrors d4unlockAll)
-func D4UnlockAll(data *Data4) int {
-	if data == nil {
-		return ErrorMemory
+// Status returns the locks held through the files of the manager's CODE4,
+// keyed by file name and lock position, for debugging
+func (lm *LockManager) Status() map[string]FileLock {
+	lock4table.mutex.Lock()
+	defer lock4table.mutex.Unlock()
+
+	// Return copies to avoid race conditions
+	result := make(map[string]FileLock, len(lm.locks))
+	for key, lock := range lm.locks {
+		result[key] = *lock
 	}
+	return result
+}
 
-	var lastError = ErrorNone
+// GetLockStatus returns the locks held in the process through the files of
+// every CODE4, keyed by file name and lock position, for debugging
+//
+// Deprecated: use the Status of the lock manager of a CODE4 (see
+// Code4Locks), which leaves out the locks of other CODE4s.
+func GetLockStatus() map[string]*FileLock {
+	lock4table.mutex.Lock()
+	defer lock4table.mutex.Unlock()
+
+	// Return copies to avoid race conditions
+	result := make(map[string]*FileLock, len(lock4table.locks))
+	for _, lock := range lock4table.locks {
+		copied := *lock
+		result[lock4key(lock.File, lock.StartPos)] = &copied
+	}
+	return result
+}
 
-	// Unlock all associated index files
-	current := list4First(&data.Indexes)
-	for current != nil {
-		index := indexFromLink(current)
-		if index != nil && index.IndexFile != nil {
-			err := lockManager.UnlockFile(&index.IndexFile.File)
-			if err != ErrorNone {
-				lastError = err // Record error but continue
-			}
+// lock4forget removes the locks matching a condition from the process
+// table and from the managers holding them, and returns them. The caller
+// holds the table's mutex.
+func lock4forget(match func(lock *FileLock) bool) []*FileLock {
+	var forgotten []*FileLock
+	kept := lock4table.locks[:0]
+	for _, lock := range lock4table.locks {
+		if !match(lock) {
+			kept = append(kept, lock)
+			continue
 		}
+		forgotten = append(forgotten, lock)
+		delete(lock.owner.locks, lock4key(lock.File, lock.StartPos))
+	}
+	clear(lock4table.locks[len(kept):])
+	lock4table.locks = kept
+	return forgotten
+}
 
-		// Move to next
-		current = list4Next(&data.Indexes, current)
-		if current == list4First(&data.Indexes) {
-			break
-		}
+// lock4overlap reports whether a lock overlaps a range
+func lock4overlap(lock *FileLock, startPos, length int64) bool {
+	return lock.StartPos < startPos+length && startPos < lock.StartPos+lock.Length
+}
+
+// lock4sameFile reports whether a lock is on the file of a handle. Handles
+// opened with different paths to one file are the same file.
+func lock4sameFile(lock *FileLock, file *File4, id os.FileInfo) bool {
+	if lock.File == file {
+		return true
 	}
+	if lock.id != nil && id != nil {
+		return os.SameFile(lock.id, id)
+	}
+	return lock.File.Name == file.Name
+}
 
-	// Unlock the data file
-	err := D4UnlockFile(data)
-	if err != ErrorNone {
-		lastError = err
+// file4identity returns the identity of an open file, nil if unknown
+func file4identity(file *File4) os.FileInfo {
+	info, err := file.Handle.Stat()
+	if err != nil {
+		return nil
 	}
+	return info
+}
 
-	return lastError
+// SetLockTimeout sets how long the CODE4s initialized from now on retry a
+// lock: a negative timeout retries until the lock is granted, zero tries
+// once.
+//
+// Deprecated: set the LockAttempts and LockDelay of the CODE4.
+func SetLockTimeout(timeout time.Duration) {
+	code4lockDefaults.Lock()
+	defer code4lockDefaults.Unlock()
+
+	// Retry every tenth of a second (LockDelay is in hundredths)
+	code4lockDefaults.delay = 10
+	if timeout < 0 {
+		code4lockDefaults.attempts = Wait4Ever
+	} else {
+		code4lockDefaults.attempts = int(timeout/(100*time.Millisecond)) + 1
+	}
 }
 
+// code4lockDefaults are the LockAttempts and LockDelay Code4Init sets
+var code4lockDefaults = struct {
+	sync.Mutex
+	attempts int
+	delay    uint32
+}{attempts: Wait4Ever, delay: 100}
+
 // CleanupLocks removes all locks for a file (called on file close)
 func CleanupLocks(file *File4) {
 	if file == nil {
 		return
 	}
 
-	lockManager.mutex.Lock()
-	defer lockManager.mutex.Unlock()
+	lock4table.mutex.Lock()
+	defer lock4table.mutex.Unlock()
 
-	// Remove all locks associated with this file
-	for key, lock := range lockManager.locks {
-		if lock.File == file {
-			delete(lockManager.locks, key)
+	for _, lock := range lock4forget(func(lock *FileLock) bool { return lock.File == file }) {
+		if file.Handle != nil {
+			_ = file4unlock(file, lock.StartPos, lock.Length) // Ignore error during cleanup
 		}
 	}
-
-	// Ensure system lock is released
-	if file.Handle != nil {
-		_ = syscall.Flock(int(file.Handle.Fd()), syscall.LOCK_UN) // Ignore error during cleanup
-	}
 }
 
-// SetLockTimeout sets the default lock timeout
-func SetLockTimeout(timeout time.Duration) {
-	lockManager.mutex.Lock()
-	defer lockManager.mutex.Unlock()
-	lockManager.timeout = timeout
-}
+// lock4restore takes again the operating system locks held through the
+// other handles of a file that has just been closed. Closing any handle of
+// a file releases every lock the process holds on it, which another
+// process would not see happen to the locks of these handles.
+func lock4restore(id os.FileInfo) {
+	if id == nil {
+		return
+	}
 
-// GetLockStatus returns current lock information for debugging
-func GetLockStatus() map[string]*FileLock {
-	lockManager.mutex.RLock()
-	defer lockManager.mutex.RUnlock()
+	lock4table.mutex.Lock()
+	defer lock4table.mutex.Unlock()
 
-	// Return a copy to avoid race conditions
-	result := make(map[string]*FileLock)
-	for key, lock := range lockManager.locks {
-		result[key] = lock
+	for _, lock := range lock4table.locks {
+		if lock.id != nil && os.SameFile(lock.id, id) && lock.File.Handle != nil {
+			_ = file4lock(lock.File, lock.StartPos, lock.Length)
+		}
 	}
-
-	return result
 }
@@ -0,0 +1,16 @@
+//go:build !(linux || darwin || freebsd || netbsd || openbsd || dragonfly)
+
+// Package pkg - Operating system byte-range locks
+// Platforms without fcntl locks only lock within the process
+package pkg
+
+// file4lock has no operating system lock to take on this platform, so
+// locks only keep handles of this process apart (mirrors file4lock)
+func file4lock(f4 *File4, startPos, length int64) int {
+	return ErrorNone
+}
+
+// file4unlock releases a byte range (mirrors file4unlock)
+func file4unlock(f4 *File4, startPos, length int64) int {
+	return ErrorNone
+}
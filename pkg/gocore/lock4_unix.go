@@ -0,0 +1,46 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd || dragonfly
+
+// Package pkg - Operating system byte-range locks
+// fcntl record locks, as used by CodeBase and Visual FoxPro under Unix
+package pkg
+
+import (
+	"errors"
+	"syscall"
+)
+
+// file4lock takes a write lock on a byte range without waiting
+// (mirrors file4lock). fcntl locks belong to the process and are all
+// released when any handle of the file is closed.
+//
+// Returns ErrorNone, R4Locked if another process holds a conflicting
+// lock, ErrorLock for other failures.
+func file4lock(f4 *File4, startPos, length int64) int {
+	lock := syscall.Flock_t{
+		Type:   syscall.F_WRLCK,
+		Whence: 0,
+		Start:  startPos,
+		Len:    length,
+	}
+	if err := syscall.FcntlFlock(f4.Handle.Fd(), syscall.F_SETLK, &lock); err != nil {
+		if errors.Is(err, syscall.EAGAIN) || errors.Is(err, syscall.EACCES) {
+			return R4Locked
+		}
+		return ErrorLock
+	}
+	return ErrorNone
+}
+
+// file4unlock releases a byte range (mirrors file4unlock)
+func file4unlock(f4 *File4, startPos, length int64) int {
+	lock := syscall.Flock_t{
+		Type:   syscall.F_UNLCK,
+		Whence: 0,
+		Start:  startPos,
+		Len:    length,
+	}
+	if err := syscall.FcntlFlock(f4.Handle.Fd(), syscall.F_SETLK, &lock); err != nil {
+		return ErrorLock
+	}
+	return ErrorNone
+}
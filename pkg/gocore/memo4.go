@@ -0,0 +1,438 @@
+// Package pkg - MEMO4 functions
+// Direct translation of CodeBase FoxPro memo (.FPT) file operations
+package pkg
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// FoxPro memo block types (first 4 bytes of each memo entry, big endian)
+const (
+	Memo4TypePicture = 0 // Picture/binary data (General fields)
+	Memo4TypeText    = 1 // Text data (Memo fields)
+
+	memo4EntryHeaderLen = 8   // Block type + data length
+	memo4FileHeaderLen  = 512 // FPT file header size
+)
+
+// F4MemoStr returns the contents of a memo field as a string.
+// This mirrors the f4memoStr function from the CodeBase library.
+//
+// Pending (unwritten) assignments are returned as assigned; otherwise
+// the entry referenced by the record is read from the memo file.
+//
+// Returns the memo contents, empty string if the memo is blank or unreadable.
+func F4MemoStr(field *Field4) string {
+	return string(F4MemoBytes(field))
+}
+
+// F4MemoBytes returns the raw contents of a memo field.
+// This is the byte-oriented counterpart of F4MemoStr for binary memos.
+//
+// Returns the memo contents, nil if the memo is blank or unreadable.
+func F4MemoBytes(field *Field4) []byte {
+	if field == nil || field.Memo == nil || field.Data == nil {
+		return nil
+	}
+	if field.Memo.IsChanged {
+		return field.Memo.Contents
+	}
+
+	dataFile := field.Data.DataFile
+	if dataFile == nil || dataFile.MemoFile == nil {
+		return nil
+	}
+	block := f4memoBlock(field)
+	if block <= 0 {
+		return nil
+	}
+	contents, _ := memo4FileRead(dataFile.MemoFile, block)
+	return contents
+}
+
+// F4MemoAssign assigns a string to a memo field.
+// This mirrors the f4memoAssign function from the CodeBase library.
+//
+// The contents are held with the field and written to the memo file
+// when the record is written (D4Write, D4Append or a record move).
+//
+// Returns ErrorNone on success, ErrorMemory if field is nil,
+// ErrorData if the field is not a memo field.
+func F4MemoAssign(field *Field4, value string) int {
+	return F4MemoAssignN(field, []byte(value))
+}
+
+// F4MemoAssignN assigns raw bytes to a memo field.
+// This mirrors the f4memoAssignN function from the CodeBase library.
+//
+// Returns ErrorNone on success, ErrorMemory if field is nil,
+// ErrorData if the field is not a memo field.
+func F4MemoAssignN(field *Field4, value []byte) int {
+	if field == nil || field.Data == nil {
+		return ErrorMemory
+	}
+	if field.Memo == nil {
+		return ErrorData
+	}
+
+	contents := make([]byte, len(value))
+	copy(contents, value)
+
+	field.Memo.Contents = contents
+	field.Memo.Length = uint32(len(contents))
+	field.Memo.IsChanged = true
+	f4assignNotNull(field)
+	field.Data.recordChanged = true
+
+	return ErrorNone
+}
+
+// F4MemoLen returns the length of the memo contents in bytes.
+// This mirrors the f4memoLen function from the CodeBase library.
+func F4MemoLen(field *Field4) int {
+	return len(F4MemoBytes(field))
+}
+
+// f4memoBlock returns the memo block number stored in the record
+func f4memoBlock(field *Field4) int32 {
+	buffer := fieldBytes(field)
+	if buffer == nil {
+		return 0
+	}
+	if f4IsBinaryStorage(field) {
+		return int32(binary.LittleEndian.Uint32(buffer))
+	}
+	block, err := strconv.ParseInt(strings.TrimSpace(string(buffer)), 10, 32)
+	if err != nil {
+		return 0
+	}
+	return int32(block)
+}
+
+// f4memoSetBlock stores a memo block number in the record
+func f4memoSetBlock(field *Field4, block int32) {
+	buffer := fieldBytes(field)
+	if buffer == nil {
+		return
+	}
+	if f4IsBinaryStorage(field) {
+		binary.LittleEndian.PutUint32(buffer, uint32(block))
+		return
+	}
+	for i := range buffer {
+		buffer[i] = ' '
+	}
+	if block > 0 {
+		text := strconv.Itoa(int(block))
+		if len(text) <= len(buffer) {
+			copy(buffer[len(buffer)-len(text):], text)
+		}
+	}
+}
+
+// d4memoWrite writes every changed memo field of the current record to the
+// memo file and updates the block references in the record buffer
+// (mirrors d4writeMemo in the CodeBase library).
+func d4memoWrite(data *Data4) int {
+	if data == nil || data.DataFile == nil {
+		return ErrorMemory
+	}
+
+	for _, field := range data.Fields {
+		if field.Memo == nil || !field.Memo.IsChanged {
+			continue
+		}
+		memoFile := data.DataFile.MemoFile
+		if memoFile == nil {
+			return ErrorData
+		}
+
+		blockType := int32(Memo4TypeText)
+		if rune(field.Type) == FieldTypeGeneral {
+			blockType = Memo4TypePicture
+		}
+
+		// Inside a transaction the old entry must survive for rollback,
+		// so the new contents always go to fresh blocks
+		block := f4memoBlock(field)
+		if d4transActive(data) {
+			block = 0
+		}
+		err := memo4FileWrite(memoFile, &block, field.Memo.Contents, blockType)
+		if err != ErrorNone {
+			return err
+		}
+		f4memoSetBlock(field, block)
+
+		field.Memo.IsChanged = false
+		field.Memo.Contents = nil
+		field.Memo.Length = 0
+	}
+
+	return ErrorNone
+}
+
+// d4memoReset discards pending memo assignments for the current record
+func d4memoReset(data *Data4) {
+	for _, field := range data.Fields {
+		if field.Memo != nil {
+			field.Memo.IsChanged = false
+			field.Memo.Contents = nil
+			field.Memo.Length = 0
+		}
+	}
+}
+
+// memo4FileRead reads the memo entry starting at the given block
+// (mirrors memo4fileRead).
+//
+// Returns the entry contents and block type, or nil and -1 on error.
+func memo4FileRead(memoFile *Memo4File, block int32) ([]byte, int32) {
+	if memoFile == nil || block <= 0 {
+		return nil, -1
+	}
+
+	pos := int64(block) * int64(memo4BlockSize(memoFile))
+
+	header := make([]byte, memo4EntryHeaderLen)
+	if File4Read(&memoFile.File, pos, header, memo4EntryHeaderLen) != memo4EntryHeaderLen {
+		return nil, -1
+	}
+
+	blockType := int32(binary.BigEndian.Uint32(header[0:4]))
+	length := int64(binary.BigEndian.Uint32(header[4:8]))
+	if length == 0 {
+		return []byte{}, blockType
+	}
+	if pos+memo4EntryHeaderLen+length > File4Length(&memoFile.File) {
+		return nil, -1
+	}
+
+	contents := make([]byte, length)
+	if File4Read(&memoFile.File, pos+memo4EntryHeaderLen, contents, uint32(length)) != uint32(length) {
+		return nil, -1
+	}
+	return contents, blockType
+}
+
+// memo4FileWrite writes a memo entry (mirrors memo4fileWrite).
+//
+// If the entry at *block has room for the new contents it is overwritten
+// in place, otherwise new blocks are taken from the end of the file and
+// *block is updated. Empty contents set *block to 0.
+func memo4FileWrite(memoFile *Memo4File, block *int32, contents []byte, blockType int32) int {
+	if memoFile == nil || block == nil {
+		return ErrorMemory
+	}
+
+	if len(contents) == 0 {
+		*block = 0
+		return ErrorNone
+	}
+
+	blockSize := int64(memo4BlockSize(memoFile))
+	needed := (int64(memo4EntryHeaderLen+len(contents)) + blockSize - 1) / blockSize
+
+	// Reuse the existing entry when the new contents fit
+	target := int64(0)
+	if *block > 0 {
+		if old, _ := memo4FileRead(memoFile, *block); old != nil {
+			oldBlocks := (int64(memo4EntryHeaderLen+len(old)) + blockSize - 1) / blockSize
+			if needed <= oldBlocks {
+				target = int64(*block)
+			}
+		}
+	}
+
+	header := make([]byte, memo4FileHeaderLen)
+	if File4Read(&memoFile.File, 0, header, memo4FileHeaderLen) < 8 {
+		return ErrorRead
+	}
+	nextFree := int64(binary.BigEndian.Uint32(header[0:4]))
+
+	if target == 0 {
+		target = nextFree
+		nextFree += needed
+	}
+
+	entry := make([]byte, needed*blockSize)
+	binary.BigEndian.PutUint32(entry[0:4], uint32(blockType))
+	binary.BigEndian.PutUint32(entry[4:8], uint32(len(contents)))
+	copy(entry[memo4EntryHeaderLen:], contents)
+
+	err := File4Write(&memoFile.File, target*blockSize, entry, uint32(len(entry)))
+	if err != ErrorNone {
+		return err
+	}
+
+	binary.BigEndian.PutUint32(header[0:4], uint32(nextFree))
+	err = File4Write(&memoFile.File, 0, header[0:4], 4)
+	if err != ErrorNone {
+		return err
+	}
+
+	*block = int32(target)
+	return ErrorNone
+}
+
+// memo4BlockSize returns the memo file block size with a FoxPro default
+func memo4BlockSize(memoFile *Memo4File) int16 {
+	if memoFile.BlockSize <= 0 {
+		return 64
+	}
+	return memoFile.BlockSize
+}
+
+// D4MemoCompress rewrites the memo file without unused entries
+// (mirrors d4memoCompress)
+func D4MemoCompress(data *Data4) int {
+	return D4MemoCompressProgress(data, nil)
+}
+
+// D4MemoCompressProgress rewrites the memo file without unused entries,
+// reporting progress as records are processed.
+//
+// Every entry referenced by a record is copied to a new memo file, packed
+// one after the other, and the record is updated to point at the copy.
+// Entries left behind by rewritten or deleted memos are dropped. The new
+// file then replaces the old one. The table and its indexes are locked
+// for the duration unless the file lock is held already.
+//
+// Returns ErrorNone on success, ErrorMemory if data is nil, ErrorData if
+// the table has no memo file or inside a transaction, R4Locked if another
+// user has the table locked.
+func D4MemoCompressProgress(data *Data4, progress Progress4) int {
+	if data == nil || data.DataFile == nil {
+		return ErrorMemory
+	}
+	dataFile := data.DataFile
+	memoFile := dataFile.MemoFile
+	if memoFile == nil || d4transActive(data) {
+		return ErrorData
+	}
+
+	err := d4updateRecord(data)
+	if err != ErrorNone {
+		return err
+	}
+	if !D4LockTestFile(data) {
+		if err := D4LockAll(data); err != ErrorNone {
+			return err
+		}
+		defer D4Unlock(data)
+	}
+
+	// The new file starts with the old header, emptied
+	header := make([]byte, memo4FileHeaderLen)
+	if File4Read(&memoFile.File, 0, header, memo4FileHeaderLen) < 8 {
+		return ErrorRead
+	}
+	blockSize := int64(memo4BlockSize(memoFile))
+	binary.BigEndian.PutUint32(header[0:4], uint32((memo4FileHeaderLen+blockSize-1)/blockSize))
+
+	temp, osErr := os.CreateTemp(filepath.Dir(memoFile.File.Name), filepath.Base(memoFile.File.Name)+".*")
+	if osErr != nil {
+		return ErrorCreate
+	}
+	compressed := &Memo4File{
+		File:      File4{Handle: temp, Name: temp.Name(), FileCreated: true, AccessMode: AccessDenyNone},
+		BlockSize: memoFile.BlockSize,
+		Data:      dataFile,
+	}
+	done := false
+	defer func() {
+		if !done {
+			File4Close(&compressed.File)
+			os.Remove(compressed.File.Name)
+		}
+	}()
+	if err := File4Write(&compressed.File, 0, header, memo4FileHeaderLen); err != ErrorNone {
+		return err
+	}
+
+	// Work on a scratch copy of the record so the record buffer is untouched
+	saved := data.Record
+	data.Record = make([]byte, len(saved))
+	defer func() { data.Record = saved }()
+
+	recordLen := int64(dataFile.RecordLen)
+	total := dataFile.Header.NumRecs
+	for recNo := int32(1); recNo <= total; recNo++ {
+		pos := int64(dataFile.Header.HeaderLen) + int64(recNo-1)*recordLen
+		if File4Read(&dataFile.File, pos, data.Record, uint32(recordLen)) != uint32(recordLen) {
+			return ErrorRead
+		}
+
+		changed := false
+		for _, field := range data.Fields {
+			if field.Memo == nil {
+				continue
+			}
+			block := f4memoBlock(field)
+			if block <= 0 {
+				continue
+			}
+			contents, blockType := memo4FileRead(memoFile, block)
+			newBlock := int32(0)
+			if contents != nil {
+				if err := memo4FileWrite(compressed, &newBlock, contents, blockType); err != ErrorNone {
+					return err
+				}
+			}
+			if newBlock != block {
+				f4memoSetBlock(field, newBlock)
+				changed = true
+			}
+		}
+		if changed {
+			if err := File4Write(&dataFile.File, pos, data.Record, uint32(recordLen)); err != ErrorNone {
+				return err
+			}
+		}
+
+		if progress != nil && (recNo%1024 == 0 || recNo == total) {
+			progress(recNo, total)
+		}
+	}
+
+	if err := File4Flush(&compressed.File); err != ErrorNone {
+		return err
+	}
+	if err := File4Flush(&dataFile.File); err != ErrorNone {
+		return err
+	}
+
+	// Swap the files and keep working on the new one
+	name := memoFile.File.Name
+	File4Close(&compressed.File)
+	File4Close(&memoFile.File)
+	if os.Rename(compressed.File.Name, name) != nil {
+		File4Open(&memoFile.File, dataFile.CodeBase, name, AccessDenyNone)
+		return ErrorWrite
+	}
+	done = true
+	return File4Open(&memoFile.File, dataFile.CodeBase, name, AccessDenyNone)
+}
+
+// memo4FileZap empties a memo file, keeping its header and block size
+func memo4FileZap(memoFile *Memo4File) int {
+	header := make([]byte, memo4FileHeaderLen)
+	if File4Read(&memoFile.File, 0, header, memo4FileHeaderLen) < 8 {
+		return ErrorRead
+	}
+	blockSize := int64(memo4BlockSize(memoFile))
+	next := (memo4FileHeaderLen + blockSize - 1) / blockSize
+	binary.BigEndian.PutUint32(header[0:4], uint32(next))
+
+	if err := File4Write(&memoFile.File, 0, header[0:4], 4); err != ErrorNone {
+		return err
+	}
+	if err := File4Truncate(&memoFile.File, next*blockSize); err != ErrorNone {
+		return err
+	}
+	return File4Flush(&memoFile.File)
+}
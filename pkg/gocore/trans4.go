@@ -6,13 +6,39 @@ import (
 	"time"
 )
 
-// Code4TransInit initializes transaction system (mirrors code4transInit)
+// Transaction log operations
+const (
+	Trans4Append = 1 // Record appended
+	Trans4Update = 2 // Record overwritten (field changes, delete and recall)
+	Trans4Delete = 3 // Record marked for deletion
+	Trans4Key    = 4 // Tag entry removed or added by a record write
+)
+
+// Code4TransInit starts a transaction (mirrors code4tranStart)
+//
+// While the transaction is active every append and record write made
+// through the CODE4 is logged with its before-image, so that
+// Code4TransRollback can restore the tables (and their open indexes) to
+// the state they had when the transaction started. The before-images are
+// also kept in a journal beside each changed table, so a transaction cut
+// short by a crash is rolled back when the table is next opened.
+//
+// Returns ErrorNone on success, ErrorMemory if cb is nil, ErrorData if a
+// transaction is already active.
 func Code4TransInit(cb *Code4) int {
 	if cb == nil {
 		return ErrorMemory
 	}
+	if cb.TransactionLevel != 0 {
+		return setError(cb, ErrorData)
+	}
+
+	// Changes made before the transaction are not part of it
+	code4transEach(cb, func(data *Data4) int {
+		data.TransChanged = 0
+		return d4updateRecord(data)
+	})
 
-	// Initialize transaction level
 	cb.TransactionLevel = 1
 	cb.TransactionID = time.Now().UnixNano()
 
@@ -20,215 +46,299 @@ func Code4TransInit(cb *Code4) int {
 	if cb.TransactionLog == nil {
 		cb.TransactionLog = make([]*Trans4State, 0, 100)
 	}
+	cb.TransactionLog = cb.TransactionLog[:0]
 
 	return ErrorNone
 }
 
-// Code4TransCommit commits current transaction (mirrors code4transCommit)
+// Code4TransActive reports whether a transaction is in progress
+// (mirrors code4tranStatus)
+func Code4TransActive(cb *Code4) bool {
+	return cb != nil && cb.TransactionLevel != 0
+}
+
+// Code4TransCommit commits current transaction (mirrors code4tranCommit)
+//
+// Pending record changes are written and every table changed during the
+// transaction is flushed before the log is discarded. The journals are
+// marked committed only after every table is flushed, then removed.
+//
+// If a record cannot be written, a table cannot be flushed or a journal
+// cannot be marked, the commit stops and the error is returned with the
+// transaction still active, so that it can be rolled back.
 func Code4TransCommit(cb *Code4) int {
 	if cb == nil || cb.TransactionLevel == 0 {
 		return ErrorMemory
 	}
 
-	// Flush all pending writes to disk
-	current := list4First(&cb.DataFileList)
-	for current != nil {
-		data := data4FromLink(current)
-		if data != nil && data.TransChanged != 0 {
-			// Write current record if positioned
-			if data.recNo > 0 {
-				D4Write(data)
-			}
-			// Flush the file
-			File4Flush(&data.DataFile.File)
-			// Clear changed flag
-			data.TransChanged = 0
-		}
+	// Pending changes belong to the transaction
+	if err := code4transEach(cb, d4updateRecord); err != ErrorNone {
+		return err
+	}
+	if err := code4transEach(cb, d4transFlush); err != ErrorNone {
+		return err
+	}
 
-		// Move to next
-		current = list4Next(&cb.DataFileList, current)
-		if current == list4First(&cb.DataFileList) {
-			break // Circular list, back to start
-		}
+	// Once every journal is marked the transaction survives a crash
+	err := code4transEach(cb, func(data *Data4) int {
+		return d4journalCommit(data.DataFile)
+	})
+	if err != ErrorNone {
+		return err
 	}
 
-	// Clear transaction log
-	cb.TransactionLog = cb.TransactionLog[:0]
-	cb.TransactionLevel = 0
-	cb.TransactionID = 0
+	code4transEach(cb, func(data *Data4) int {
+		data.TransChanged = 0
+		d4journalDiscard(data.DataFile)
+		d4transUnlock(data)
+		return ErrorNone
+	})
+
+	code4transEnd(cb)
+	return ErrorNone
+}
 
+// d4transFlush flushes the files of a table changed by the transaction
+func d4transFlush(data *Data4) int {
+	if data.TransChanged == 0 {
+		return ErrorNone
+	}
+	if err := File4Flush(&data.DataFile.File); err != ErrorNone {
+		return err
+	}
+	if data.DataFile.MemoFile != nil {
+		return File4Flush(&data.DataFile.MemoFile.File)
+	}
 	return ErrorNone
 }
 
-// Code4TransRollback rolls back current transaction (mirrors code4transRollback)
+// Code4TransRollback rolls back current transaction (mirrors code4tranRollback)
 //
-//nolint:gocyclo // TODO: refactor to reduce complexity by extracting transaction rollback steps
+// Logged changes are undone in reverse order: appended records are cut
+// off the end of the table, overwritten records get their before-image
+// back and the tag entries the writes removed or added are put back or
+// taken out again. Pending changes that were never written are
+// discarded, and each table is repositioned on its current record.
 func Code4TransRollback(cb *Code4) int {
 	if cb == nil || cb.TransactionLevel == 0 {
 		return ErrorMemory
 	}
 
-	// Rollback changes in reverse order
-	for i := len(cb.TransactionLog) - 1; i >= 0; i-- {
-		trans := cb.TransactionLog[i]
-		if trans == nil {
-			continue
-		}
-
-		// Find the data file for this transaction
-		current := list4First(&cb.DataFileList)
-		for current != nil {
-			data := data4FromLink(current)
-			if data != nil {
-				// Rollback the specific operation
-				switch trans.Operation {
-				case 1: // Append - remove the record
-					if data.DataFile.Header.NumRecs >= trans.RecNo {
-						data.DataFile.Header.NumRecs--
-						writeDbfHeader(data.DataFile)
-					}
-
-				case 2: // Update - restore old record
-					if trans.OldRecord != nil && trans.RecNo > 0 {
-						err := D4Go(data, trans.RecNo)
-						if err == ErrorNone {
-							copy(data.Record, trans.OldRecord)
-							D4Write(data)
-						}
-					}
-
-				case 3: // Delete - undelete record
-					if trans.RecNo > 0 {
-						err := D4Go(data, trans.RecNo)
-						if err == ErrorNone {
-							D4Recall(data)
-							D4Write(data)
-						}
-					}
-				}
-				break
-			}
+	// Nothing written from here on is part of the transaction
+	log := cb.TransactionLog
+	code4transEnd(cb)
 
-			// Move to next
-			current = list4Next(&cb.DataFileList, current)
-			if current == list4First(&cb.DataFileList) {
-				break
-			}
+	result := ErrorNone
+	for i := len(log) - 1; i >= 0; i-- {
+		if err := trans4undo(log[i]); err != ErrorNone && result == ErrorNone {
+			result = err
 		}
 	}
 
-	// Clear transaction log
+	code4transEach(cb, func(data *Data4) int {
+		data.recordChanged = false
+		d4memoReset(data)
+		if data.DataFile.Journal != nil {
+			File4Flush(&data.DataFile.File)
+			d4journalDiscard(data.DataFile)
+		}
+		d4transUnlock(data)
+		if data.TransChanged == 0 {
+			return ErrorNone
+		}
+		data.TransChanged = 0
+
+		// Re-read the current record, it may have been restored or removed
+		switch {
+		case data.appending:
+			data.appending = false
+			data.recNo = data.DataFile.Header.NumRecs + 1
+			data.atEOF = true
+		case data.recNo >= 1 && data.recNo <= data.DataFile.Header.NumRecs:
+			D4Go(data, data.recNo)
+		case data.recNo > data.DataFile.Header.NumRecs:
+			data.recNo = data.DataFile.Header.NumRecs + 1
+			data.atEOF = true
+		}
+		return ErrorNone
+	})
+
+	return result
+}
+
+// code4transEnd clears the transaction state
+func code4transEnd(cb *Code4) {
 	cb.TransactionLog = cb.TransactionLog[:0]
 	cb.TransactionLevel = 0
 	cb.TransactionID = 0
+}
 
-	return ErrorNone
+// d4transUnlock releases the append lock D4Append kept for the transaction
+func d4transUnlock(data *Data4) {
+	if data.appendLocked {
+		D4UnlockAppend(data)
+	}
 }
 
-// D4TransAppend logs an append operation for rollback
-func D4TransAppend(data *Data4, recNo int32) {
-	if data == nil || data.CodeBase == nil || data.CodeBase.TransactionLevel == 0 {
-		return
+// code4transEach calls fn for every open table, returning the first error
+func code4transEach(cb *Code4, fn func(data *Data4) int) int {
+	result := ErrorNone
+	first := list4First(&cb.DataFileList)
+	for current := first; current != nil; {
+		if data := data4FromLink(current); data != nil && data.DataFile != nil {
+			if err := fn(data); err != ErrorNone && result == ErrorNone {
+				result = err
+			}
+		}
+		current = list4Next(&cb.DataFileList, current)
+		if current == first {
+			break // Circular list, back to start
+		}
 	}
+	return result
+}
 
-	trans := &Trans4State{
-		RecNo:     recNo,
-		Operation: 1, // Append
-		TimeStamp: time.Now(),
+// trans4undo reverses a single logged change
+func trans4undo(trans *Trans4State) int {
+	if trans == nil || trans.Data == nil || trans.Data.DataFile == nil {
+		return ErrorNone
 	}
+	data := trans.Data
+	dataFile := data.DataFile
+	data.TransChanged = 1
+
+	switch trans.Operation {
+	case Trans4Append:
+		// Appends are undone last-first, so the record is the last one
+		if trans.RecNo != dataFile.Header.NumRecs {
+			return ErrorData
+		}
+		dataFile.Header.NumRecs--
+		if err := writeDbfHeader(dataFile); err != ErrorNone {
+			return err
+		}
+		end := File4Long(dataFile.Header.HeaderLen) + File4Long(dataFile.Header.NumRecs)*File4Long(dataFile.RecordLen)
+		if err := File4Truncate(&dataFile.File, end); err != ErrorNone {
+			return err
+		}
+		return writeEofMarker(dataFile)
 
-	data.CodeBase.TransactionLog = append(data.CodeBase.TransactionLog, trans)
+	case Trans4Update, Trans4Delete:
+		if trans.OldRecord == nil || trans.RecNo < 1 || trans.RecNo > dataFile.Header.NumRecs {
+			return ErrorData
+		}
+		pos := File4Long(dataFile.Header.HeaderLen) + File4Long(trans.RecNo-1)*File4Long(dataFile.RecordLen)
+		return File4Write(&dataFile.File, pos, trans.OldRecord, uint32(len(trans.OldRecord)))
+
+	case Trans4Key:
+		return t4undoKey(data, trans)
+	}
+
+	return ErrorNone
 }
 
-// D4TransUpdate logs an update operation for rollback
-func D4TransUpdate(data *Data4, recNo int32, oldRecord []byte) {
-	if data == nil || data.CodeBase == nil || data.CodeBase.TransactionLevel == 0 {
+// d4transActive reports whether changes to data are being logged
+func d4transActive(data *Data4) bool {
+	return data != nil && data.CodeBase != nil && data.CodeBase.TransactionLevel != 0
+}
+
+// d4transLog adds an entry to the transaction log
+func d4transLog(data *Data4, operation int, recNo int32, oldRecord []byte) {
+	if !d4transActive(data) {
 		return
 	}
 
-	// Create a copy of the old record
-	oldRecordCopy := make([]byte, len(oldRecord))
-	copy(oldRecordCopy, oldRecord)
-
 	trans := &Trans4State{
+		Data:      data,
 		RecNo:     recNo,
-		OldRecord: oldRecordCopy,
-		Operation: 2, // Update
+		Operation: operation,
 		TimeStamp: time.Now(),
 	}
+	if oldRecord != nil {
+		trans.OldRecord = append([]byte(nil), oldRecord...)
+	}
 
+	data.TransChanged = 1
 	data.CodeBase.TransactionLog = append(data.CodeBase.TransactionLog, trans)
 }
 
-// D4TransDelete logs a delete operation for rollback
-func D4TransDelete(data *Data4, recNo int32) {
-	if data == nil || data.CodeBase == nil || data.CodeBase.TransactionLevel == 0 {
+// d4transLogKey logs a tag entry removed (oldKey) or added (newKey) for a
+// record, so that rollback can undo it without rebuilding the tag
+func d4transLogKey(data *Data4, tagFile *Tag4File, recNo int32, oldKey, newKey []byte) {
+	if !d4transActive(data) {
 		return
 	}
 
-	trans := &Trans4State{
+	data.TransChanged = 1
+	data.CodeBase.TransactionLog = append(data.CodeBase.TransactionLog, &Trans4State{
+		Data:      data,
 		RecNo:     recNo,
-		Operation: 3, // Delete
+		Operation: Trans4Key,
 		TimeStamp: time.Now(),
-	}
-
-	data.CodeBase.TransactionLog = append(data.CodeBase.TransactionLog, trans)
+		TagFile:   tagFile,
+		OldKey:    oldKey,
+		NewKey:    newKey,
+	})
 }
 
-// Enhanced write operations with transaction support
-
-// D4AppendTrans appends a record with transaction support
-func D4AppendTrans(data *Data4) int {
-	if data == nil {
-		return ErrorMemory
+// d4transLogWrite logs the before-image of a record about to be
+// overwritten. The image is read from disk so that it is exact whatever
+// has happened to the record buffers.
+func d4transLogWrite(data *Data4, operation int, recNo int32) int {
+	if !d4transActive(data) {
+		return ErrorNone
 	}
 
-	// Perform the append
-	err := D4Append(data)
+	oldRecord, err := d4readRecord(data, recNo)
 	if err != ErrorNone {
 		return err
 	}
+	if err := d4journalWrite(data, recNo, oldRecord); err != ErrorNone {
+		return err
+	}
 
-	// Log transaction for rollback capability
-	D4TransAppend(data, data.recNo)
-
+	d4transLog(data, operation, recNo, oldRecord)
 	return ErrorNone
 }
 
-// D4WriteTrans writes record with transaction support
-func D4WriteTrans(data *Data4) int {
-	if data == nil || data.RecordOld == nil {
-		return ErrorMemory
-	}
+// D4TransAppend logs an append operation for rollback
+func D4TransAppend(data *Data4, recNo int32) {
+	d4transLog(data, Trans4Append, recNo, nil)
+}
 
-	// Log the old record for rollback
-	D4TransUpdate(data, data.recNo, data.RecordOld)
+// D4TransUpdate logs an update operation for rollback
+func D4TransUpdate(data *Data4, recNo int32, oldRecord []byte) {
+	d4transLog(data, Trans4Update, recNo, oldRecord)
+}
 
-	// Perform the write
-	err := D4Write(data)
-	if err != ErrorNone {
-		return err
-	}
+// D4TransDelete logs a delete operation for rollback
+func D4TransDelete(data *Data4, recNo int32) {
+	d4transLogWrite(data, Trans4Delete, recNo)
+}
 
-	// Update the old record buffer
-	copy(data.RecordOld, data.Record)
+// Transaction aware write operations. D4Append and D4Write log their
+// changes whenever a transaction is active, so these are equivalent to the
+// plain functions and remain for compatibility.
 
-	return ErrorNone
+// D4AppendTrans appends a record with transaction support
+func D4AppendTrans(data *Data4) int {
+	return D4Append(data)
+}
+
+// D4WriteTrans writes record with transaction support
+func D4WriteTrans(data *Data4) int {
+	return D4Write(data)
 }
 
 // D4DeleteTrans deletes record with transaction support
+//
+// The delete flag is written immediately so that it is covered by the
+// transaction.
 func D4DeleteTrans(data *Data4) int {
 	if data == nil {
 		return ErrorMemory
 	}
 
-	// Only log if not already deleted
-	if !D4Deleted(data) {
-		D4TransDelete(data, data.recNo)
-	}
-
-	// Perform the delete
 	D4Delete(data)
-
-	return ErrorNone
+	return d4updateRecord(data)
 }
@@ -4,6 +4,7 @@ package pkg
 
 import (
 	"os"
+	"sync"
 	"time"
 )
 
@@ -22,6 +23,16 @@ const (
 	FieldTypeDateTime = 'T' // DateTime field
 	FieldTypeInteger  = 'I' // Integer field
 	FieldTypeVarChar  = 'V' // VarChar field
+	FieldTypeVarBin   = 'Q' // VarBinary field
+	FieldTypeDouble   = 'B' // Double field (binary, 8 bytes)
+	FieldTypeBlob     = 'W' // Blob field
+	FieldTypeSystem   = '0' // System field (_NullFlags)
+
+	// Field descriptor flags (byte 18 of the field descriptor, Fox 3.0+)
+	FieldFlagSystem   = 0x01 // System (hidden) column
+	FieldFlagNullable = 0x02 // Column can store null values
+	FieldFlagBinary   = 0x04 // Binary column (no codepage translation)
+	FieldFlagAutoInc  = 0x0C // Autoincrementing integer
 
 	// Access mode constants
 	AccessDenyRW   = 0x10 // Deny read/write
@@ -38,6 +49,7 @@ const (
 	ErrorCreate = -970
 	ErrorData   = -980
 	ErrorIndex  = -990
+	ErrorLock   = -50 // Operating system lock call failed
 
 	// Path and name lengths
 	MaxPathLen    = 260
@@ -53,6 +65,7 @@ const (
 	R4Eof     = 3  // End of file
 	R4Bof     = 4  // Beginning of file
 	R4Unique  = 20 // Key is not unique
+	R4Locked  = 50 // Lock held by another user
 
 	// Field type constants for creation
 	R4Num = 'N' // Numeric field type
@@ -126,8 +139,10 @@ type Field4 struct {
 	Type    int16    // Field type
 	Offset  uint32   // Offset in record
 	Data    *Data4   // Pointer to parent DATA4
+	Flags   byte     // Raw field descriptor flags
 	Null    byte     // Null support flag
-	NullBit uint16   // Null bit mask
+	NullBit uint16   // Bit number in _NullFlags for the null flag
+	LenBit  uint16   // Bit number in _NullFlags for the varchar length flag
 	Binary  byte     // Binary field flag
 	Memo    *F4Memo  // Memo field handler
 }
@@ -152,7 +167,8 @@ type DbfHeader struct {
 	HeaderLen uint16   // Header length
 	RecordLen uint16   // Record length
 	Reserved  [16]byte // Reserved bytes
-	// Additional fields for FoxPro compatibility would follow
+	Flags     byte     // Table flags (0x01 CDX, 0x02 memo, 0x04 database)
+	CodePage  byte     // Code page mark (language driver)
 }
 
 // Memo4Header represents memo file header (from MEMO4HEADER in C)
@@ -162,6 +178,10 @@ type Memo4Header struct {
 	BlockSize int16   // Block size in bytes
 }
 
+// Progress4 reports the progress of a long operation as done out of total
+// records
+type Progress4 func(done, total int32)
+
 // Memo4File represents memo file handle (from MEMO4FILE in C)
 type Memo4File struct {
 	File      File4
@@ -182,6 +202,7 @@ type Code4 struct {
 	ErrOpen           int    // File open error handling
 	Log               int16  // Logging enabled
 	MemExpandData     int    // Data memory expansion
+	MemSizeMemo       uint16 // Block size for new memo files
 	MemSizeBuffer     uint32 // Buffer size for pack/zap
 	MemSizeSortBuffer uint32 // Sort buffer size
 	MemSizeSortPool   uint32 // Sort pool size
@@ -189,15 +210,18 @@ type Code4 struct {
 	Safety            byte   // File create with safety
 	Timeout           int32  // Operation timeout
 	Compatibility     int16  // FoxPro compatibility version
+	LockAttempts      int    // Lock tries before giving up, Wait4Ever to retry forever
+	LockDelay         uint32 // Hundredths of a second between lock tries
 
 	// Internal members
-	Initialized    bool    // Initialization flag
-	NumericStrLen  int     // Default numeric string length
-	Decimals       int     // Default decimal places
-	ErrorCode      int     // Last error code
-	FieldBuffer    []byte  // Internal field buffer
-	IndexExtension [4]byte // Index file extension
-	DataFileList   List4   // List of open data files
+	Initialized    bool         // Initialization flag
+	NumericStrLen  int          // Default numeric string length
+	Decimals       int          // Default decimal places
+	ErrorCode      int          // Last error code
+	FieldBuffer    []byte       // Internal field buffer
+	IndexExtension [4]byte      // Index file extension
+	DataFileList   List4        // List of open data files
+	locks          *LockManager // Locks held through the files, see Code4Locks
 
 	// Transaction support
 	TransactionLevel  int            // Current transaction nesting level
@@ -217,11 +241,22 @@ type Data4File struct {
 	NumFields int16
 	RecordLen uint16
 	MemoFile  *Memo4File
+	NullFlags *Field4 // _NullFlags system field, nil if the table has none
+	Journal   *File4  // Transaction journal, nil unless a transaction changed the table
 	UserCount int
 	CodeBase  *Code4
 	IsValid   bool
 }
 
+// Text4 translates the characters of a table's code page to and from
+// UTF-8 for the expressions of its index keys. There is no CodeBase
+// equivalent: CodeBase keys hold the stored bytes, which expressions
+// compiled elsewhere read in the table's code page.
+type Text4 interface {
+	Decode(raw string) string
+	Encode(s string) (string, error)
+}
+
 // Data4 represents a database table instance (from DATA4 in C)
 type Data4 struct {
 	Link         Link4
@@ -239,12 +274,17 @@ type Data4 struct {
 	LogVal       int
 	TransChanged byte
 	ClientID     int32
+	Text         Text4 // Code page translation of index keys, nil for ISO 8859-1
 
 	// Navigation state
+	recordChanged bool  // Record buffer modified since last read/write
+	appending     bool  // D4AppendStart called, D4Append pending
+	appendLocked  bool  // Append lock taken by D4Append, held until the transaction ends
 	recNo         int32 // Current record number
 	atEOF         bool  // At end of file
 	atBof         bool  // At beginning of file
 	lastSeekFound bool  // Last seek operation result
+	clone         bool  // Made by D4Clone, shares the files of another DATA4
 }
 
 // Tag4Info represents index tag creation info (from TAG4INFO in C)
@@ -265,6 +305,12 @@ type Tag4 struct {
 	IsValid   bool
 	Added     int // Entry added flag
 	Removed   int // Entry removed flag
+
+	// Position state
+	block   *B4Block // Leaf block holding the current entry
+	pos     int      // Entry position within block
+	changes uint32   // Tag file change counter when block was read
+	expr    *Expr4   // Key expression bound to a clone's record, nil for the tag file's
 }
 
 // Tag4File represents physical tag file (from TAG4FILE in C)
@@ -279,6 +325,10 @@ type Tag4File struct {
 	KeyDec       int       // Key decimal places
 	ExprSource   string    // Index expression source
 	FilterSource string    // Filter expression source
+	Expr         *Expr4    // Parsed key expression
+	Filter       *Expr4    // Parsed FOR expression, nil if none
+	KeyType      byte      // Key expression result type
+	changes      uint32    // Incremented whenever the tag's nodes change
 }
 
 // Index4 represents an index file (from INDEX4 in C)
@@ -296,34 +346,37 @@ type Index4 struct {
 type Index4File struct {
 	Link      Link4
 	Tags      List4
+	TagIndex  *Tag4File // Tag directory (the compound tag at offset 0)
 	UserCount int
 	CodeBase  *Code4
 	DataFile  *Data4File
 	File      File4
 	IsValid   bool
+
+	// Nodes read by clones, cleared whenever a node is written
+	cacheMutex sync.Mutex
+	cache      map[int32]*B4Block
 }
 
-// B4Block represents a B+ tree block for CDX navigation
+// B4Block represents a compact CDX index node (from B4BLOCK in C)
 type B4Block struct {
-	BlockNo   int32   // Block number in file
-	BlockType byte    // Block type (leaf, branch, root)
-	NumKeys   int16   // Number of keys in block
-	KeyLen    int16   // Length of each key
-	Data      []byte  // Raw block data
-	Keys      []B4Key // Parsed keys
-	Changed   bool    // Block modified flag
-	Pointers  []int32 // Child block pointers (for branch blocks)
+	FileBlock int32   // File offset of the node
+	NodeAttr  int16   // 0 branch, 1 root, 2 leaf, 3 root leaf
+	LeftNode  int32   // Left sibling offset, -1 if none
+	RightNode int32   // Right sibling offset, -1 if none
+	Keys      []B4Key // Expanded keys of the node
 }
 
-// B4Key represents a key within a B+ tree block
+// B4Key represents a key within a CDX node
 type B4Key struct {
-	KeyData []byte // Key data
+	Key     []byte // Full (uncompressed) key value
 	RecNo   int32  // Record number
-	DupCnt  int16  // Duplicate count
-	Pointer int32  // Pointer to child block (for branches)
+	Pointer int32  // Child node offset (branch nodes only)
 }
 
 // B4KeyData represents key data with metadata
+//
+// Deprecated: compact nodes are decoded to B4Key values.
 type B4KeyData struct {
 	KeyValue []byte // The actual key value
 	RecNo    int32  // Record number this key points to
@@ -331,13 +384,25 @@ type B4KeyData struct {
 	Trail    byte   // Trailing info
 }
 
+// IndexKey represents a key-record pair for sorting
+//
+// Deprecated: tags are built from B4Key values.
+type IndexKey struct {
+	KeyData []byte
+	RecNo   int32
+}
+
 // Trans4State represents transaction state for rollback
 type Trans4State struct {
+	Data      *Data4 // Table the change was made to
 	RecNo     int32
 	OldRecord []byte
 	NewRecord []byte
-	Operation int // 1=append, 2=update, 3=delete
+	Operation int // 1=append, 2=update, 3=delete, 4=tag entry
 	TimeStamp time.Time
+	TagFile   *Tag4File // Tag of a Trans4Key entry
+	OldKey    []byte    // Tag entry the write removed
+	NewKey    []byte    // Tag entry the write added
 }
 
 // Error represents CodeBase error information
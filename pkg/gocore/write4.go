@@ -7,89 +7,230 @@ import (
 	"time"
 )
 
-// D4Append appends a new blank record to the database.
+// D4Append appends the current record buffer as a new record.
 // This mirrors the d4append function from the CodeBase library.
 //
-// The function initializes a new record at the end of the database
-// and positions the record pointer to it. The new record is blanked
-// with default values appropriate for each field type.
+// The usual sequence is D4AppendStart, field assignments, then D4Append.
+// The record is written after the last record, the header record count
+// and last-update date are updated, and the end-of-file marker is
+// rewritten. The record pointer is left on the new record. The append
+// lock is taken for the duration unless it is already held; inside a
+// transaction it is kept until the transaction ends.
 //
-// Returns ErrorNone on success, ErrorMemory if data is nil.
+// Returns ErrorNone on success, ErrorMemory if data is nil,
+// ErrorWrite on I/O errors, R4Locked if another user holds the append lock.
 func D4Append(data *Data4) int {
-	if data == nil || data.DataFile == nil {
+	if data == nil || data.DataFile == nil || data.Record == nil {
 		return ErrorMemory
 	}
 
-	// First try to start the append operation
-	err := D4AppendStart(data, 1)
+	// Other users may append too, the record number is only settled
+	// while holding the append lock
+	release, err := d4lockAppendAuto(data)
 	if err != ErrorNone {
 		return err
 	}
+	if release {
+		defer D4UnlockAppend(data)
+	}
 
-	// Blank the new record
-	D4Blank(data)
+	dataFile := data.DataFile
+	newRecordNo := dataFile.Header.NumRecs + 1
 
-	// The record is now ready for field assignments
-	// The actual write happens when d4append() is called (or d4flush, d4close, etc.)
+	// The journal remembers the record count before the first append
+	err = d4journalStart(data)
+	if err != ErrorNone {
+		return err
+	}
 
-	return ErrorNone
-}
+	// Memo entries first so the record references the new blocks
+	err = d4memoWrite(data)
+	if err != ErrorNone {
+		return err
+	}
 
-// D4AppendStart prepares for appending records (mirrors d4appendStart)
-func D4AppendStart(data *Data4, lockAttempt int) int {
-	if data == nil || data.DataFile == nil {
-		return ErrorMemory
+	// Write the record data, then make it visible through the header
+	err = d4WriteLow(data, newRecordNo, 0)
+	if err != ErrorNone {
+		return err
 	}
 
-	dataFile := data.DataFile
+	dataFile.Header.NumRecs = newRecordNo
+	d4headerDate(&dataFile.Header)
+	err = writeDbfHeader(dataFile)
+	if err != ErrorNone {
+		return err
+	}
+	d4transLog(data, Trans4Append, newRecordNo, nil)
 
-	// Calculate new record position
-	newRecordNo := dataFile.Header.NumRecs + 1
+	err = d4writeKeys(data, newRecordNo, nil)
+	if err != ErrorNone {
+		return err
+	}
 
-	// Update header record count
-	dataFile.Header.NumRecs = newRecordNo
+	err = writeEofMarker(dataFile)
+	if err != ErrorNone {
+		return err
+	}
 
-	// Set current position to the new record
+	// Position on the new record
 	data.recNo = newRecordNo
 	data.atEOF = false
-	data.atBof = (newRecordNo == 1)
+	data.atBof = false
+	data.appending = false
+	data.recordChanged = false
+	copy(data.RecordOld, data.Record)
 
 	// Mark as changed for transaction tracking
 	data.TransChanged = 1
 
-	// Initialize transaction state if not already done
-	if data.CodeBase != nil && data.CodeBase.TransactionLevel == 0 {
-		// Auto-start transaction for safety
-		Code4TransInit(data.CodeBase)
+	return ErrorNone
+}
+
+// D4AppendStart prepares for appending a record (mirrors d4appendStart)
+//
+// Any pending changes to the current record are written first. The
+// record buffer keeps its contents so it can be used as a template;
+// call D4Blank to start from an empty record. When useMemoEntries is 0
+// memo references are cleared so the new record does not share memo
+// blocks with the record it was copied from.
+func D4AppendStart(data *Data4, useMemoEntries int) int {
+	if data == nil || data.DataFile == nil {
+		return ErrorMemory
+	}
+
+	err := d4updateRecord(data)
+	if err != ErrorNone {
+		return err
 	}
 
+	if useMemoEntries == 0 {
+		for _, field := range data.Fields {
+			if field.Memo != nil {
+				F4Blank(field)
+				field.Memo.Contents = nil
+				field.Memo.IsChanged = false
+			}
+		}
+	}
+
+	// The record pointer is undefined until D4Append is called
+	data.recNo = 0
+	data.atEOF = false
+	data.atBof = false
+	data.appending = true
+
 	return ErrorNone
 }
 
 // D4AppendBlank appends a blank record (mirrors d4appendBlank)
 func D4AppendBlank(data *Data4) int {
-	err := D4Append(data)
+	err := D4AppendStart(data, 0)
 	if err != ErrorNone {
 		return err
 	}
 
-	// Record is already blanked by D4Append
-	return D4Flush(data)
+	D4Blank(data)
+	return D4Append(data)
 }
 
 // D4Write writes the current record buffer to disk.
 // This mirrors the d4write function from the CodeBase library.
 //
 // The function writes the current record data to the appropriate
-// position in the database file and updates the header if necessary.
+// position in the database file. It does not force an operating
+// system flush; use D4Flush for that.
 //
-// Returns ErrorNone on success, ErrorMemory if data is nil.
+// Returns ErrorNone on success, ErrorMemory if data is nil,
+// ErrorData if there is no current record.
 func D4Write(data *Data4) int {
 	if data == nil || data.DataFile == nil {
 		return ErrorMemory
 	}
 
-	return d4WriteLow(data, data.recNo, 1)
+	if data.recNo < 1 || data.recNo > data.DataFile.Header.NumRecs {
+		return ErrorData
+	}
+
+	err := d4transLogWrite(data, Trans4Update, data.recNo)
+	if err != ErrorNone {
+		return err
+	}
+
+	// The keys of the record on disk are the ones in the tags
+	oldRecord, err := d4readRecord(data, data.recNo)
+	if err != ErrorNone {
+		return err
+	}
+
+	err = d4memoWrite(data)
+	if err != ErrorNone {
+		return err
+	}
+
+	err = d4WriteLow(data, data.recNo, 0)
+	if err != ErrorNone {
+		return err
+	}
+
+	err = d4writeKeys(data, data.recNo, oldRecord)
+	if err != ErrorNone {
+		return err
+	}
+
+	data.recordChanged = false
+	copy(data.RecordOld, data.Record)
+	return ErrorNone
+}
+
+// D4Changed reports whether the current record buffer has unwritten changes.
+// This mirrors the d4changed function from the CodeBase library.
+func D4Changed(data *Data4) bool {
+	if data == nil {
+		return false
+	}
+	return data.recordChanged
+}
+
+// d4updateRecord writes the current record if it has been changed
+// (mirrors d4updateRecord). CodeBase calls this before moving the record
+// pointer so that edits are never silently discarded.
+func d4updateRecord(data *Data4) int {
+	if data == nil || !data.recordChanged {
+		return ErrorNone
+	}
+	if data.recNo < 1 || data.DataFile == nil || data.recNo > data.DataFile.Header.NumRecs {
+		// Nothing to write back to (e.g. a discarded append)
+		data.recordChanged = false
+		return ErrorNone
+	}
+	return D4Write(data)
+}
+
+// d4readRecord reads a record as it is on disk, leaving the record buffer
+// untouched
+func d4readRecord(data *Data4, recNo int32) ([]byte, int) {
+	dataFile := data.DataFile
+	record := make([]byte, dataFile.RecordLen)
+	pos := File4Long(dataFile.Header.HeaderLen) + File4Long(recNo-1)*File4Long(dataFile.RecordLen)
+	if File4Read(&dataFile.File, pos, record, uint32(len(record))) != uint32(len(record)) {
+		return nil, ErrorRead
+	}
+	return record, ErrorNone
+}
+
+// writeEofMarker writes the 0x1A end-of-file byte after the last record
+func writeEofMarker(dataFile *Data4File) int {
+	pos := int64(dataFile.Header.HeaderLen) + int64(dataFile.Header.NumRecs)*int64(dataFile.RecordLen)
+	return File4Write(&dataFile.File, pos, []byte{0x1A}, 1)
+}
+
+// d4headerDate stamps the header with today's date (YY is years since 1900)
+func d4headerDate(header *DbfHeader) {
+	now := time.Now()
+	header.Year = byte(now.Year() - 1900)
+	header.Month = byte(now.Month())
+	header.Day = byte(now.Day())
 }
 
 // d4WriteLow internal function for writing records (mirrors d4writeLow)
@@ -151,6 +292,8 @@ func writeDbfHeader(dataFile *Data4File) int {
 
 	// Copy reserved area
 	copy(headerBuf[12:28], header.Reserved[:])
+	headerBuf[28] = header.Flags
+	headerBuf[29] = header.CodePage
 
 	// Write header to file
 	err := File4Write(&dataFile.File, 0, headerBuf, 32)
@@ -173,7 +316,10 @@ func D4Delete(data *Data4) {
 	}
 
 	// Set delete flag (first byte of record)
-	data.Record[0] = '*' // '*' means deleted, ' ' means not deleted
+	if data.Record[0] != '*' {
+		data.Record[0] = '*' // '*' means deleted, ' ' means not deleted
+		data.recordChanged = true
+	}
 }
 
 // D4Deleted checks if current record is deleted (mirrors d4deleted)
@@ -197,7 +343,10 @@ func D4Recall(data *Data4) {
 	}
 
 	// Clear delete flag (first byte of record)
-	data.Record[0] = ' ' // ' ' means not deleted
+	if data.Record[0] != ' ' {
+		data.Record[0] = ' ' // ' ' means not deleted
+		data.recordChanged = true
+	}
 }
 
 // D4Flush flushes all pending writes to disk.
@@ -244,18 +393,7 @@ func D4Update(data *Data4) int {
 	}
 
 	// Update header date to current date
-	now := time.Now()
-	header := &data.DataFile.Header
-
-	// DBF stores dates as YY/MM/DD since 1900
-	year := now.Year()
-	if year >= 2000 {
-		header.Year = byte(year - 2000) // Y2K handling
-	} else if year >= 1900 {
-		header.Year = byte(year - 1900)
-	}
-	header.Month = byte(now.Month())
-	header.Day = byte(now.Day())
+	d4headerDate(&data.DataFile.Header)
 
 	// Write updated header
 	return writeDbfHeader(data.DataFile)
@@ -263,122 +401,223 @@ func D4Update(data *Data4) int {
 
 // D4Pack physically removes deleted records (mirrors d4pack)
 func D4Pack(data *Data4) int {
+	return D4PackProgress(data, nil)
+}
+
+// D4PackProgress physically removes deleted records, reporting progress
+// while the table is compacted.
+//
+// The remaining records are moved down over the deleted ones, the memo
+// file is rewritten without entries that are no longer referenced (see
+// D4MemoCompressProgress) and every open index is rebuilt. The table and
+// its indexes are locked for the duration unless the file lock is held
+// already. progress, which may be nil, is called with the number of
+// records processed so far in each pass over the table.
+//
+// Returns ErrorNone on success, ErrorMemory if data is nil, ErrorData
+// inside a transaction, R4Locked if another user has the table locked.
+func D4PackProgress(data *Data4, progress Progress4) int {
 	if data == nil || data.DataFile == nil {
 		return ErrorMemory
 	}
+	if d4transActive(data) {
+		return ErrorData // Packing cannot be rolled back
+	}
 
-	dataFile := data.DataFile
-	recordLen := int(dataFile.RecordLen)
-	originalCount := dataFile.Header.NumRecs
-	newCount := int32(0)
+	err := d4updateRecord(data)
+	if err != ErrorNone {
+		return err
+	}
+	if !D4LockTestFile(data) {
+		if err := D4LockAll(data); err != ErrorNone {
+			return err
+		}
+		defer D4Unlock(data)
+	}
 
-	// Create temporary record buffer
-	tempRecord := make([]byte, recordLen)
+	dataFile := data.DataFile
+	recordLen := int64(dataFile.RecordLen)
+	headerLen := int64(dataFile.Header.HeaderLen)
+	total := dataFile.Header.NumRecs
+	chunk := d4packChunk(data)
+	buffer := make([]byte, int64(chunk)*recordLen)
 
-	// Scan through all records
-	for i := int32(1); i <= originalCount; i++ {
-		// Read record
-		err := D4Go(data, i)
-		if err != ErrorNone {
-			return err
+	// Records are read in chunks and written back packed; the write
+	// position never passes the read position
+	newCount := int32(0)
+	for first := int32(1); first <= total; first += chunk {
+		count := chunk
+		if first+count-1 > total {
+			count = total - first + 1
+		}
+		size := int64(count) * recordLen
+		if int64(File4Read(&dataFile.File, headerLen+int64(first-1)*recordLen, buffer, uint32(size))) != size {
+			return ErrorRead
 		}
 
-		// If not deleted, write to packed position
-		if !D4Deleted(data) {
-			newCount++
-			if newCount != i {
-				// Copy record to new position
-				copy(tempRecord, data.Record)
-				err = d4WriteLow(data, newCount, 0)
-				if err != ErrorNone {
-					return err
-				}
+		kept := int64(0)
+		for i := int64(0); i < int64(count); i++ {
+			record := buffer[i*recordLen : (i+1)*recordLen]
+			if record[0] == '*' {
+				continue
+			}
+			if kept != i {
+				copy(buffer[kept*recordLen:], record)
 			}
+			kept++
 		}
-	}
-
-	// Update record count in header
-	dataFile.Header.NumRecs = newCount
-
-	// Truncate file to new size
-	headerLen := int64(dataFile.Header.HeaderLen)
-	newFileSize := headerLen + (int64(newCount) * int64(recordLen))
-	File4Truncate(&dataFile.File, newFileSize)
+		if kept > 0 && newCount+int32(kept) != first+count-1 {
+			pos := headerLen + int64(newCount)*recordLen
+			if err := File4Write(&dataFile.File, pos, buffer, uint32(kept*recordLen)); err != ErrorNone {
+				return err
+			}
+		}
+		newCount += int32(kept)
 
-	// Write updated header
-	err := writeDbfHeader(dataFile)
-	if err != ErrorNone {
-		return err
+		if progress != nil {
+			progress(first+count-1, total)
+		}
 	}
 
-	// Flush all changes
-	err = File4Flush(&dataFile.File)
+	err = d4setRecordCount(data, newCount)
 	if err != ErrorNone {
 		return err
 	}
 
-	// Position to top
-	if newCount > 0 {
-		D4Top(data)
-	} else {
-		data.recNo = 0
-		data.atEOF = true
-		data.atBof = true
+	if dataFile.MemoFile != nil {
+		if err := D4MemoCompressProgress(data, progress); err != ErrorNone {
+			return err
+		}
+	}
+	if err := d4reindexOpen(data); err != ErrorNone {
+		return err
 	}
 
-	return ErrorNone
+	return D4Top(data)
 }
 
-// D4Zap removes all records from database (mirrors d4zap)
+// D4Zap physically removes a range of records (mirrors d4zap)
+//
+// numRecs records starting at startRec are removed and the records after
+// them are moved down; a numRecs that reaches past the last record
+// removes every record from startRec on. When the whole table is emptied
+// the memo file is emptied too. Every open index is rebuilt. The table is
+// locked as by D4PackProgress.
+//
+// Returns ErrorNone on success, ErrorMemory if data is nil, ErrorData for
+// an invalid range or inside a transaction, R4Locked if another user has
+// the table locked.
 func D4Zap(data *Data4, startRec int32, numRecs int32) int {
 	if data == nil || data.DataFile == nil {
 		return ErrorMemory
 	}
-
-	// If numRecs is 0 or -1, zap all records
-	if numRecs <= 0 {
-		startRec = 1
-		numRecs = data.DataFile.Header.NumRecs
+	if d4transActive(data) {
+		return ErrorData
 	}
 
-	// Validate range
-	if startRec < 1 || startRec > data.DataFile.Header.NumRecs {
+	dataFile := data.DataFile
+	total := dataFile.Header.NumRecs
+	if startRec < 1 || numRecs < 0 {
 		return ErrorData
 	}
-
-	endRec := startRec + numRecs - 1
-	if endRec > data.DataFile.Header.NumRecs {
-		endRec = data.DataFile.Header.NumRecs
+	if startRec > total || numRecs == 0 {
+		return ErrorNone // Nothing to remove
+	}
+	endRec := total
+	if numRecs <= total-startRec {
+		endRec = startRec + numRecs - 1
 	}
 
-	// Mark records as deleted
-	for i := startRec; i <= endRec; i++ {
-		err := D4Go(data, i)
-		if err != ErrorNone {
+	// Pending changes to a removed record are dropped with it
+	if data.recNo >= startRec && data.recNo <= endRec {
+		data.recordChanged = false
+		d4memoReset(data)
+	}
+	err := d4updateRecord(data)
+	if err != ErrorNone {
+		return err
+	}
+	if !D4LockTestFile(data) {
+		if err := D4LockAll(data); err != ErrorNone {
 			return err
 		}
-		D4Delete(data)
-		err = D4Write(data)
-		if err != ErrorNone {
+		defer D4Unlock(data)
+	}
+
+	// Move the records after the range down in chunks
+	recordLen := int64(dataFile.RecordLen)
+	headerLen := int64(dataFile.Header.HeaderLen)
+	chunk := d4packChunk(data)
+	buffer := make([]byte, int64(chunk)*recordLen)
+	to := startRec
+	for from := endRec + 1; from <= total; from += chunk {
+		count := chunk
+		if from+count-1 > total {
+			count = total - from + 1
+		}
+		size := int64(count) * recordLen
+		if int64(File4Read(&dataFile.File, headerLen+int64(from-1)*recordLen, buffer, uint32(size))) != size {
+			return ErrorRead
+		}
+		if err := File4Write(&dataFile.File, headerLen+int64(to-1)*recordLen, buffer, uint32(size)); err != ErrorNone {
 			return err
 		}
+		to += count
 	}
 
-	// If zapping all records, update header count to 0
-	if startRec == 1 && endRec == data.DataFile.Header.NumRecs {
-		data.DataFile.Header.NumRecs = 0
-		err := writeDbfHeader(data.DataFile)
-		if err != ErrorNone {
+	err = d4setRecordCount(data, to-1)
+	if err != ErrorNone {
+		return err
+	}
+
+	if to == 1 && dataFile.MemoFile != nil {
+		if err := memo4FileZap(dataFile.MemoFile); err != ErrorNone {
 			return err
 		}
+	}
+	if err := d4reindexOpen(data); err != ErrorNone {
+		return err
+	}
 
-		// Position to empty state
-		data.recNo = 0
-		data.atEOF = true
-		data.atBof = true
+	return D4Top(data)
+}
+
+// d4setRecordCount cuts the table to count records: the header is
+// rewritten, the file truncated after the last record and the end-of-file
+// marker restored
+func d4setRecordCount(data *Data4, count int32) int {
+	dataFile := data.DataFile
+	dataFile.Header.NumRecs = count
+	d4headerDate(&dataFile.Header)
+	if err := writeDbfHeader(dataFile); err != ErrorNone {
+		return err
 	}
 
-	return D4Flush(data)
+	end := int64(dataFile.Header.HeaderLen) + int64(count)*int64(dataFile.RecordLen)
+	if err := File4Truncate(&dataFile.File, end); err != ErrorNone {
+		return err
+	}
+	if err := writeEofMarker(dataFile); err != ErrorNone {
+		return err
+	}
+	return File4Flush(&dataFile.File)
+}
+
+// pack4MinBuffer is the smallest buffer used to move records
+const pack4MinBuffer = 64 * 1024
+
+// d4packChunk returns how many records are moved at a time by pack and
+// zap, based on the CODE4 pack buffer size
+func d4packChunk(data *Data4) int32 {
+	size := int64(data.CodeBase.MemSizeBuffer)
+	if size < pack4MinBuffer {
+		size = pack4MinBuffer
+	}
+	chunk := size / int64(data.DataFile.RecordLen)
+	if chunk < 1 {
+		chunk = 1
+	}
+	return int32(chunk)
 }
 
 // D4Replace replaces current record with data from another source (mirrors d4replace)
@@ -0,0 +1,563 @@
+package foxi
+
+import (
+	"fmt"
+	"iter"
+	"strings"
+
+	"github.com/mkfoss/foxi/expr"
+)
+
+// Relation links a child table to a parent table, as SET RELATION does in
+// Visual FoxPro: whenever the parent moves, the child moves to the first
+// record whose key in a tag of the child matches the value of an
+// expression on the parent's current record. When nothing matches, or
+// the parent is at EOF or BOF, the child is at EOF.
+type Relation struct {
+	parent *Foxi
+	child  *Foxi
+	key    *expr.Program // Compiled against the parent
+	tag    Tag           // Tag of the child, nil to go to the record number
+	tagKey *expr.Program // The tag's key expression, compiled against the child
+
+	// The key the child's records must have, set by the last seek; a
+	// character key only has to start with it
+	want   string
+	prefix bool
+}
+
+// relations are the relations of a table
+type relations struct {
+	list   []*Relation // Relations into the children, in the order they were set
+	skip   []*Relation // Relations set with SetSkip, in the order given
+	parent *Relation   // The relation into this table, nil when it has none
+}
+
+// clear removes the relations of a table, into its children and from
+// its parent
+func (rs *relations) clear() {
+	for _, r := range rs.list {
+		r.child.impl.relations().parent = nil
+	}
+	if r := rs.parent; r != nil {
+		r.parent.impl.relations().remove(r)
+	}
+	*rs = relations{}
+}
+
+// remove removes a relation into a child
+func (rs *relations) remove(r *Relation) {
+	rs.list = without(rs.list, r)
+	rs.skip = without(rs.skip, r)
+	r.child.impl.relations().parent = nil
+}
+
+func without(list []*Relation, r *Relation) []*Relation {
+	var kept []*Relation
+	for _, other := range list {
+		if other != r {
+			kept = append(kept, other)
+		}
+	}
+	return kept
+}
+
+// SetRelation relates a child table to this one, as SET RELATION TO
+// expression INTO child does in Visual FoxPro. Whenever this table moves
+// with Goto, First, Last, Next, Previous, Skip or Append, or is walked by
+// Records and the tag iterators, the child seeks the value of the
+// expression in tag, a tag of the child, which the child then has
+// selected. A character value finds the keys that start with it, as SEEK
+// does with SET EXACT OFF; records that do not match the child's filter
+// are passed over. With a nil tag, the expression must be numeric and the
+// child goes to that record number.
+//
+// The child's fields can be read in this table's expressions, filters
+// included, qualified with its alias (see Compile). A table can be the
+// child of a single relation, and a table cannot be related to itself or
+// to a table it is a child of. Closing either table ends the relation.
+func (f *Foxi) SetRelation(expression string, child *Foxi, tag Tag) (*Relation, error) {
+	if !f.Active() || !child.Active() {
+		return nil, fmt.Errorf("database not open")
+	}
+	if child == f {
+		return nil, fmt.Errorf("a table cannot be related to itself")
+	}
+	if child.impl.relations().parent != nil {
+		return nil, fmt.Errorf("table %s is already the child of a relation", child.Alias())
+	}
+	for r := f.impl.relations().parent; r != nil; r = r.parent.impl.relations().parent {
+		if r.parent == child {
+			return nil, fmt.Errorf("relating %s into %s would make a cycle", f.Alias(), child.Alias())
+		}
+	}
+
+	key, err := Compile(f, expression)
+	if err != nil {
+		return nil, err
+	}
+	r := &Relation{parent: f, child: child, key: key, tag: tag}
+	if tag == nil {
+		if key.Type() != expr.Numeric && key.Type() != expr.Integer {
+			return nil, fmt.Errorf("relation expression %s must be numeric to go to a record number", expression)
+		}
+	} else {
+		if r.tagKey, err = Compile(child, tag.Expression()); err != nil {
+			return nil, fmt.Errorf("tag %s: %w", tag.Name(), err)
+		}
+		if !sameFamily(key.Type(), r.tagKey.Type()) {
+			return nil, fmt.Errorf("relation expression %s is of type %s, tag %s has %s keys",
+				expression, key.Type(), tag.Name(), r.tagKey.Type())
+		}
+		if err := child.Indexes().SelectTag(tag); err != nil {
+			return nil, err
+		}
+	}
+
+	rs := f.impl.relations()
+	rs.list = append(rs.list, r)
+	child.impl.relations().parent = r
+	return r, r.seek(1)
+}
+
+// MustSetRelation relates a child table to this one. Panics on error.
+func (f *Foxi) MustSetRelation(expression string, child *Foxi, tag Tag) *Relation {
+	r, err := f.SetRelation(expression, child, tag)
+	if err != nil {
+		panic(err)
+	}
+	return r
+}
+
+// sameFamily reports whether two expression types compare with each
+// other, integers being numbers
+func sameFamily(a, b expr.Type) bool {
+	if a == expr.Integer {
+		a = expr.Numeric
+	}
+	if b == expr.Integer {
+		b = expr.Numeric
+	}
+	return a == b
+}
+
+// Relations returns the relations into the children of the table, in the
+// order they were set.
+func (f *Foxi) Relations() []*Relation {
+	return append([]*Relation(nil), f.impl.relations().list...)
+}
+
+// ClearRelation removes the relation into a child table, if there is one.
+// The child stays where it is.
+func (f *Foxi) ClearRelation(child *Foxi) {
+	rs := f.impl.relations()
+	for _, r := range rs.list {
+		if r.child == child {
+			rs.remove(r)
+			return
+		}
+	}
+}
+
+// ClearRelations removes the relations into the children of the table, as
+// SET RELATION TO does.
+func (f *Foxi) ClearRelations() {
+	rs := f.impl.relations()
+	for _, r := range rs.list {
+		r.child.impl.relations().parent = nil
+	}
+	rs.list, rs.skip = nil, nil
+}
+
+// SetSkip makes the relations into the given children one-to-many, as SET
+// SKIP TO does: Next, Previous and Skip then visit every matching record
+// of each child before moving on, the table staying on the same record
+// while its children move, and a record without matching child records is
+// visited once, with the child at EOF. With several children, the last
+// one given moves fastest, like the innermost of nested loops. Last
+// leaves the children on their last matching records. SetSkip without
+// arguments makes every relation one-to-one again.
+//
+// Records and the tag iterators still visit each record of the table once.
+func (f *Foxi) SetSkip(children ...*Foxi) error {
+	rs := f.impl.relations()
+	var skip []*Relation
+	for _, child := range children {
+		r := child.impl.relations().parent
+		if r == nil || r.parent != f {
+			return fmt.Errorf("table %s is not related to %s", child.Alias(), f.Alias())
+		}
+		skip = append(skip, r)
+	}
+	rs.skip = skip
+	return nil
+}
+
+// Relate moves the children of the table's relations to the records
+// matching its current record. Navigation does this itself; call Relate
+// after moving the table with the methods of a Tag, such as Seek.
+func (f *Foxi) Relate() error {
+	return relate(f.impl, 1)
+}
+
+// Parent returns the parent table of the relation.
+func (r *Relation) Parent() *Foxi {
+	return r.parent
+}
+
+// Child returns the child table of the relation.
+func (r *Relation) Child() *Foxi {
+	return r.child
+}
+
+// Expression returns the expression whose value the child seeks.
+func (r *Relation) Expression() string {
+	return r.key.Source()
+}
+
+// Tag returns the tag of the child that is sought, nil when the child goes
+// to a record number.
+func (r *Relation) Tag() Tag {
+	return r.tag
+}
+
+// OneToMany reports whether the relation was made one-to-many with
+// SetSkip.
+func (r *Relation) OneToMany() bool {
+	for _, skip := range r.parent.impl.relations().skip {
+		if skip == r {
+			return true
+		}
+	}
+	return false
+}
+
+// relate moves the children of a table's relations, and theirs in turn,
+// to the records matching its current record: their first matching
+// records, or their last ones for one-to-many relations when dir is
+// negative
+func relate(impl foxiImpl, dir int) error {
+	for _, r := range impl.relations().list {
+		if err := r.seek(dir); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// seek moves the child to its first matching record, or to the last one
+// for a one-to-many relation when dir is negative, and relates its own
+// children
+func (r *Relation) seek(dir int) error {
+	if !r.child.Active() {
+		return nil
+	}
+	if _, err := r.position(dir < 0 && r.OneToMany()); err != nil {
+		return err
+	}
+	return relate(r.child.impl, dir)
+}
+
+// position moves the child to its first matching record, or its last one,
+// and reports whether there is one. The child is left at EOF when there is
+// none.
+func (r *Relation) position(last bool) (bool, error) {
+	found, err := r.find()
+	if err == nil && found && last {
+		found, err = r.toLast()
+	}
+	if err == nil && found {
+		var ok bool
+		if ok, err = r.passes(); err == nil && !ok {
+			dir := 1
+			if last {
+				dir = -1
+			}
+			found, err = r.next(dir)
+		}
+	}
+	if err != nil {
+		return false, err
+	}
+	if !found {
+		return false, goEOF(r.child.impl)
+	}
+	return true, nil
+}
+
+// find seeks the value of the expression for the parent's current record
+func (r *Relation) find() (bool, error) {
+	parent, child := r.parent.impl, r.child.impl
+	if parent.EOF() || parent.BOF() {
+		return false, nil
+	}
+	v, err := r.key.Eval()
+	if err != nil || v == nil {
+		return false, err
+	}
+
+	if r.tag == nil {
+		n := int(v.(float64))
+		header := child.Header()
+		if n < 1 || n > int(header.RecordCount()) {
+			return false, nil
+		}
+		return true, child.Goto(n)
+	}
+
+	if selected := child.Indexes().SelectedTag(); selected == nil || selected.Name() != r.tag.Name() {
+		if err := child.Indexes().SelectTag(r.tag); err != nil {
+			return false, err
+		}
+	}
+	text, _ := v.(string)
+	text = strings.TrimRight(text, " ")
+	if r.tagKey.Type() == expr.Character {
+		v = text
+	}
+	key, err := r.tagKey.KeyOf(v)
+	if err != nil {
+		return false, nil // A value the key cannot hold matches no key
+	}
+	r.want, r.prefix = string(key), r.tagKey.Type() == expr.Character
+	if r.prefix {
+		r.want = strings.TrimRight(r.want, " ")
+	}
+	result, err := seekKey(r.tag, r.tagKey.Type(), text, key)
+	if err != nil || result == SeekEOF {
+		return false, err
+	}
+	return r.inRange(), nil
+}
+
+// inRange reports whether the child is on a record whose key matches
+func (r *Relation) inRange() bool {
+	child := r.child.impl
+	if r.tag == nil || child.EOF() || child.BOF() {
+		return false
+	}
+	key := r.tag.CurrentKey()
+	if r.prefix {
+		return strings.HasPrefix(key, r.want)
+	}
+	return key == r.want
+}
+
+// passes reports whether the child's current record matches its filter
+func (r *Relation) passes() (bool, error) {
+	rf := r.child.impl.recordFilter()
+	if rf.prog == nil {
+		return true, nil
+	}
+	return rf.prog.EvalBool()
+}
+
+// next moves the child to its next matching record in the direction of
+// dir, reporting false when it has run out of them
+func (r *Relation) next(dir int) (bool, error) {
+	child := r.child.impl
+	for r.tag != nil && !child.EOF() && !child.BOF() {
+		if err := child.Skip(dir); err != nil {
+			return false, err
+		}
+		if !r.inRange() {
+			return false, nil
+		}
+		if ok, err := r.passes(); err != nil || ok {
+			return ok, err
+		}
+	}
+	return false, nil
+}
+
+// toLast moves the child from its first matching key to its last one
+func (r *Relation) toLast() (bool, error) {
+	child := r.child.impl
+	for r.inRange() {
+		if err := child.Skip(1); err != nil {
+			return false, err
+		}
+	}
+	var err error
+	if child.EOF() {
+		err = r.tag.Last()
+	} else {
+		err = child.Skip(-1)
+	}
+	return err == nil, err
+}
+
+// goEOF moves a table past its last record
+func goEOF(impl foxiImpl) error {
+	if err := impl.Last(); err != nil || impl.EOF() {
+		return err
+	}
+	return impl.Skip(1)
+}
+
+// skipRelated moves count rows forwards, or backwards when count is
+// negative, through the rows of a table and its one-to-many children
+func skipRelated(impl foxiImpl, count int) error {
+	dir := 1
+	if count < 0 {
+		dir, count = -1, -count
+	}
+	for ; count > 0; count-- {
+		if dir > 0 && impl.EOF() || dir < 0 && impl.BOF() {
+			return nil
+		}
+		if !impl.EOF() && !impl.BOF() {
+			moved, err := advance(impl, dir)
+			if err != nil {
+				return err
+			}
+			if moved {
+				continue
+			}
+		}
+		if err := skip(impl, dir); err != nil {
+			return err
+		}
+		if err := relate(impl, dir); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// advance moves the one-to-many children of a table to their next row,
+// the last one given to SetSkip first, and reports false when they have
+// all run out of rows for the current record
+func advance(impl foxiImpl, dir int) (bool, error) {
+	skips := impl.relations().skip
+	for i := len(skips) - 1; i >= 0; i-- {
+		r := skips[i]
+		moved, err := advance(r.child.impl, dir)
+		if err == nil && !moved {
+			if moved, err = r.next(dir); err == nil && moved {
+				err = relate(r.child.impl, dir)
+			}
+		}
+		if err != nil {
+			return false, err
+		}
+		if moved {
+			// The children that move faster start over
+			for _, later := range skips[i+1:] {
+				if err := later.seek(dir); err != nil {
+					return false, err
+				}
+			}
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// relatedTable returns the table with the given alias among the children
+// of a table's relations and theirs
+func relatedTable(impl foxiImpl, alias string) *Foxi {
+	for _, r := range impl.relations().list {
+		if r.child.Alias() == alias {
+			return r.child
+		}
+		if f := relatedTable(r.child.impl, alias); f != nil {
+			return f
+		}
+	}
+	return nil
+}
+
+// WalkRelated walks the tree of tables related to this one: it visits
+// every record of the table, in the order of its selected tag and matching
+// its filter, once for every combination of the matching records of its
+// children, and theirs in turn, with all the tables positioned. A record
+// without matching records in a child is visited once, with the child at
+// EOF. Relations are walked as one-to-many whether or not they were set
+// so with SetSkip.
+//
+// condition, when not empty, is a logical expression the rows must match,
+// which may read the fields of the related tables through their aliases:
+//
+//	customers.MustSetRelation("ID", orders, orders.Indexes().TagByName("custid"))
+//	orders.MustSetRelation("ID", lines, lines.Indexes().TagByName("orderid"))
+//	for rec, err := range customers.WalkRelated(`LINES.QTY > 10`) { ... }
+//
+// The yielded Record is the record of this table. The loop body may read
+// every table but must not move them.
+func (f *Foxi) WalkRelated(condition string) iter.Seq2[Record, error] {
+	return func(yield func(Record, error) bool) {
+		var cond *expr.Program
+		if strings.TrimSpace(condition) != "" {
+			var err error
+			if cond, err = Compile(f, condition); err == nil && cond.Type() != expr.Logical {
+				err = fmt.Errorf("condition %s is not a logical expression", condition)
+			}
+			if err != nil {
+				yield(Record{}, err)
+				return
+			}
+		}
+
+		// The relations of the tree, each after the one into its parent
+		var tree []*Relation
+		var collect func(impl foxiImpl)
+		collect = func(impl foxiImpl) {
+			for _, r := range impl.relations().list {
+				if r.child.Active() {
+					tree = append(tree, r)
+					collect(r.child.impl)
+				}
+			}
+		}
+		collect(f.impl)
+
+		// visit runs nested loops over the children from level on
+		var visit func(level int) (bool, error)
+		visit = func(level int) (bool, error) {
+			if level == len(tree) {
+				if cond != nil {
+					if ok, err := cond.EvalBool(); err != nil || !ok {
+						return err == nil, err
+					}
+				}
+				return yield(Record{impl: f.impl, number: f.impl.Position()}, nil), nil
+			}
+			r := tree[level]
+			found, err := r.position(false)
+			if err != nil || !found {
+				if err != nil {
+					return false, err
+				}
+				return visit(level + 1)
+			}
+			for found {
+				if more, err := visit(level + 1); err != nil || !more {
+					return more, err
+				}
+				if found, err = r.next(1); err != nil {
+					return false, err
+				}
+			}
+			return true, nil
+		}
+
+		for _, err := range records(f.impl, false) {
+			if err != nil {
+				yield(Record{}, err)
+				return
+			}
+			more, err := visit(0)
+			if err != nil {
+				yield(Record{}, err)
+				return
+			}
+			if !more {
+				return
+			}
+		}
+		if err := relate(f.impl, 1); err != nil {
+			yield(Record{}, err)
+		}
+	}
+}
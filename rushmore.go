@@ -0,0 +1,654 @@
+package foxi
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"math/bits"
+	"strings"
+
+	"github.com/mkfoss/foxi/expr"
+)
+
+// Rushmore optimisation. A filter is broken down at its .AND., .OR. and
+// .NOT. operators into conditions. A condition comparing the key
+// expression of a tag with a constant is answered by scanning the range
+// of keys that satisfies it, which gives the set of records the condition
+// holds for. The sets are combined the way the operators combine the
+// conditions, and only the records left, the candidates, are read and
+// tested against the conditions the tags could not answer exactly.
+//
+// Tags are used when they are ascending and neither unique nor filtered,
+// so that they hold a key for every record.
+
+// Optimization is how much of a filter the tags answer, as Visual FoxPro
+// reports it with SYS(3054).
+type Optimization int
+
+// Optimization levels
+const (
+	OptimizeNone    Optimization = iota // Every record is read and tested
+	OptimizePartial                     // Tags narrow down the records to test
+	OptimizeFull                        // Tags answer the filter; records are not tested
+)
+
+// String returns the level as SYS(3054) names it
+func (o Optimization) String() string {
+	switch o {
+	case OptimizePartial:
+		return "partial"
+	case OptimizeFull:
+		return "full"
+	}
+	return "none"
+}
+
+// Plan describes how the filter of a table is evaluated.
+type Plan struct {
+	Filter       string       // The filter expression
+	Optimization Optimization // How much of the filter the tags answer
+	Steps        []PlanStep   // Conditions answered from tags, in the order scanned
+	Residual     string       // Conditions tested on each candidate record, empty when none
+	Candidates   int          // Records the tags leave to visit
+	Records      int          // Records in the table
+}
+
+// PlanStep is a condition answered by scanning a tag.
+type PlanStep struct {
+	Condition string // The condition, in canonical syntax
+	Tag       string // Name of the tag scanned
+	Records   int    // Records whose keys satisfy the condition
+}
+
+// Tags returns the names of the tags the plan uses, each once.
+func (p *Plan) Tags() []string {
+	var names []string
+	seen := map[string]bool{}
+	for _, step := range p.Steps {
+		if !seen[step.Tag] {
+			seen[step.Tag] = true
+			names = append(names, step.Tag)
+		}
+	}
+	return names
+}
+
+// String describes the plan in the manner of SYS(3054):
+//
+//	Rushmore optimization level for filter STATE = "CA" .AND. BALANCE > 0: partial
+//	Using index tag state for STATE = "CA": 12 records
+//	Testing BALANCE > 0 on 12 of 100 records
+func (p *Plan) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Rushmore optimization level for filter %s: %s\n", p.Filter, p.Optimization)
+	for _, step := range p.Steps {
+		fmt.Fprintf(&b, "Using index tag %s for %s: %d records\n", step.Tag, step.Condition, step.Records)
+	}
+	switch {
+	case p.Optimization == OptimizeFull:
+		fmt.Fprintf(&b, "Visiting %d of %d records\n", p.Candidates, p.Records)
+	case p.Optimization == OptimizePartial:
+		fmt.Fprintf(&b, "Testing %s on %d of %d records\n", p.Residual, p.Candidates, p.Records)
+	default:
+		fmt.Fprintf(&b, "Testing %s on %d records\n", p.Residual, p.Records)
+	}
+	return b.String()
+}
+
+// Explain plans the filter set with SetFilter and describes which tags
+// answer which of its conditions and how many records are left to test.
+// The plan is made afresh from the current contents of the table and
+// tags, the way First and Last make it; the record pointer and the
+// selected tag are left as they were.
+func (f *Foxi) Explain() (*Plan, error) {
+	rf := f.impl.recordFilter()
+	if rf.prog == nil {
+		return nil, fmt.Errorf("no filter set")
+	}
+	fp, err := planFilter(f.impl, rf)
+	if err != nil {
+		return nil, err
+	}
+	return fp.info, nil
+}
+
+// MustExplain plans the filter set with SetFilter.
+// Panics if the operation fails.
+func (f *Foxi) MustExplain() *Plan {
+	plan, err := f.Explain()
+	if err != nil {
+		panic(err)
+	}
+	return plan
+}
+
+// filterPlan is a plan in use for navigation
+type filterPlan struct {
+	info       *Plan
+	candidates *recordSet    // nil when the tags leave every record
+	residual   *expr.Program // nil when the tags answer the filter
+	records    int           // Records in the table when planned
+	changed    *recordSet    // Records written since, tested with the whole filter
+}
+
+// jump moves in record order to the next record left to test after the
+// current one, or before it when step is negative, or off the end of the
+// table. Records appended since planning are all tested.
+func (fp *filterPlan) jump(impl foxiImpl, step int) error {
+	n := impl.Position()
+	var next int
+	switch {
+	case step > 0 && n >= fp.records:
+		next = n + 1
+	case step > 0:
+		if next = fp.candidates.next(n, 1); next == 0 {
+			next = fp.records + 1
+		}
+	case n > fp.records+1:
+		next = n - 1
+	default:
+		next = fp.candidates.next(n, -1)
+	}
+
+	h := impl.Header()
+	switch {
+	case next > int(h.RecordCount()):
+		if err := impl.Last(); err != nil {
+			return err
+		}
+		return impl.Skip(1)
+	case next < 1:
+		if err := impl.First(); err != nil {
+			return err
+		}
+		return impl.Skip(-1)
+	}
+	return impl.Goto(next)
+}
+
+// planFilter plans a filter, keeping the record pointer and the selected
+// tag as they were
+func planFilter(impl foxiImpl, rf *recordFilter) (*filterPlan, error) {
+	restore := keepPosition(impl)
+	fp, err := newPlanner(impl, rf.env).plan(rf.prog)
+	if rerr := restore(); err == nil {
+		err = rerr
+	}
+	if err != nil {
+		return nil, err
+	}
+	return fp, nil
+}
+
+// keepPosition notes the selected tag and the record pointer, returning a
+// function that goes back to them
+func keepPosition(impl foxiImpl) func() error {
+	tag := impl.Indexes().SelectedTag()
+	pos, eof, bof := impl.Position(), impl.EOF(), impl.BOF()
+	return func() error {
+		if err := impl.Indexes().SelectTag(tag); err != nil {
+			return err
+		}
+		switch {
+		case eof:
+			if err := impl.Last(); err != nil {
+				return err
+			}
+			return impl.Skip(1)
+		case bof:
+			if err := impl.First(); err != nil {
+				return err
+			}
+			return impl.Skip(-1)
+		}
+		return impl.Goto(pos)
+	}
+}
+
+// planner breaks a filter down and scans tags for its conditions
+type planner struct {
+	impl  foxiImpl
+	env   expr.Env
+	tags  []planTag
+	count int
+}
+
+// planTag is a tag the planner can scan
+type planTag struct {
+	tag      Tag
+	key      *expr.Program // The key expression
+	nullable bool          // The key reads nullable fields, whose nulls have blank keys
+}
+
+// planResult is what the tags tell about a condition
+type planResult struct {
+	set   *recordSet // Records the condition may hold for; nil for every record
+	exact bool       // The condition holds for exactly the records of set
+	steps []PlanStep // The tag scans that made set
+}
+
+func newPlanner(impl foxiImpl, env expr.Env) *planner {
+	p := &planner{impl: impl, env: env}
+	h := impl.Header()
+	p.count = int(h.RecordCount())
+	for _, tag := range impl.Indexes().Tags() {
+		if tag.IsDescending() || tag.IsUnique() || tag.Filter() != "" {
+			continue
+		}
+		key, err := expr.Compile(tag.Expression(), env)
+		if err != nil {
+			continue
+		}
+		nullable := false
+		visitFields(key.Root(), func(ref *expr.FieldRef) {
+			if field := impl.FieldByName(ref.Name); field == nil || field.IsNullable() {
+				nullable = true
+			}
+		})
+		p.tags = append(p.tags, planTag{tag: tag, key: key, nullable: nullable})
+	}
+	return p
+}
+
+// visitFields calls visit for every field reference of an expression
+func visitFields(n expr.Node, visit func(*expr.FieldRef)) {
+	switch n := n.(type) {
+	case *expr.FieldRef:
+		visit(n)
+	case *expr.Unary:
+		visitFields(n.X, visit)
+	case *expr.Binary:
+		visitFields(n.X, visit)
+		visitFields(n.Y, visit)
+	case *expr.Call:
+		for _, arg := range n.Args {
+			visitFields(arg, visit)
+		}
+	}
+}
+
+// plan works out the candidates of a filter and the conditions left to
+// test on them: the operands of the top-level .AND. the tags do not
+// answer exactly
+func (p *planner) plan(prog *expr.Program) (*filterPlan, error) {
+	var set *recordSet
+	var steps []PlanStep
+	var residual []string
+	conds := conjuncts(prog.Root())
+	for _, cond := range conds {
+		r, err := p.node(cond)
+		if err != nil {
+			return nil, err
+		}
+		set = intersect(set, r.set)
+		steps = append(steps, r.steps...)
+		if !r.exact {
+			s := cond.String()
+			if b, ok := cond.(*expr.Binary); ok && b.Op == ".OR." && len(conds) > 1 {
+				s = "(" + s + ")"
+			}
+			residual = append(residual, s)
+		}
+	}
+
+	fp := &filterPlan{
+		info: &Plan{
+			Filter:     prog.Source(),
+			Steps:      steps,
+			Residual:   strings.Join(residual, " .AND. "),
+			Candidates: p.count,
+			Records:    p.count,
+		},
+		candidates: set,
+		records:    p.count,
+		changed:    newRecordSet(0),
+	}
+	switch {
+	case set == nil:
+		fp.info.Optimization = OptimizeNone
+	case len(residual) == 0:
+		fp.info.Optimization = OptimizeFull
+	default:
+		fp.info.Optimization = OptimizePartial
+	}
+	if set != nil {
+		fp.info.Candidates = set.count()
+	}
+	if len(residual) > 0 {
+		var err error
+		if fp.residual, err = expr.Compile(fp.info.Residual, p.env); err != nil {
+			return nil, err
+		}
+	}
+	return fp, nil
+}
+
+// node answers a logical expression from the tags
+func (p *planner) node(n expr.Node) (planResult, error) {
+	switch n := n.(type) {
+	case *expr.Binary:
+		switch n.Op {
+		case ".AND.":
+			x, err := p.node(n.X)
+			if err != nil {
+				return x, err
+			}
+			y, err := p.node(n.Y)
+			return planResult{
+				set:   intersect(x.set, y.set),
+				exact: x.exact && y.exact,
+				steps: append(x.steps, y.steps...),
+			}, err
+		case ".OR.":
+			x, err := p.node(n.X)
+			if err != nil || x.set == nil {
+				return planResult{}, err
+			}
+			y, err := p.node(n.Y)
+			if err != nil || y.set == nil {
+				return planResult{}, err
+			}
+			x.set.or(y.set)
+			return planResult{
+				set:   x.set,
+				exact: x.exact && y.exact,
+				steps: append(x.steps, y.steps...),
+			}, nil
+		case "=", "==", "<", "<=", ">", ">=":
+			return p.compare(n, n.Op)
+		case "<>":
+			// The records left out of an exact = set are those it is
+			// false for, as none of them is null
+			r, err := p.compare(n, "=")
+			if err != nil || !r.exact {
+				return planResult{}, err
+			}
+			r.set.not(p.count)
+			return r, nil
+		}
+	case *expr.Unary:
+		if n.Op == ".NOT." {
+			r, err := p.node(n.X)
+			if err != nil || !r.exact {
+				return planResult{}, err
+			}
+			r.set.not(p.count)
+			return r, nil
+		}
+	}
+
+	// A logical tag on the expression itself
+	if t := p.find(n); t != nil && t.key.Type() == expr.Logical {
+		return p.scan(t, n.String(), "=", "T", []byte("T"), !t.nullable)
+	}
+	return planResult{}, nil
+}
+
+// compare answers a comparison of a tag's key expression with a constant
+func (p *planner) compare(n *expr.Binary, op string) (planResult, error) {
+	t, c := p.find(n.X), n.Y
+	if t == nil {
+		// A constant on the left; = does not turn around for strings,
+		// which it compares as prefixes
+		t, c = p.find(n.Y), n.X
+		if t == nil || t.key.Type() == expr.Character {
+			return planResult{}, nil
+		}
+		op = map[string]string{"=": "=", "==": "==", "<": ">", "<=": ">=", ">": "<", ">=": "<="}[op]
+	}
+	value, ok := constValue(c)
+	if !ok {
+		return planResult{}, nil
+	}
+
+	keyType := t.key.Type()
+	exact := !t.nullable
+	var text string
+	var key []byte
+	switch keyType {
+	case expr.Character:
+		s, ok := value.(string)
+		if !ok || s == "" {
+			return planResult{}, nil
+		}
+		encoded, err := p.impl.textCodec().encode(s)
+		if err != nil || len(encoded) > t.tag.KeyLength() {
+			return planResult{}, nil
+		}
+		// Keys order by their bytes in the code page and strings by
+		// their UTF-8, which agree on printable ASCII
+		if op != "=" && op != "==" && !printableASCII(s) {
+			return planResult{}, nil
+		}
+		// Strings compare as prefixes, so the unpadded constant is the key
+		text, key = s, []byte(encoded)
+		if op == "==" {
+			op, exact = "=", false
+		}
+	case expr.Logical:
+		return planResult{}, nil
+	default:
+		want := keyType
+		if want == expr.Integer {
+			want = expr.Numeric
+			if x, ok := value.(float64); !ok || x != math.Trunc(x) || x < math.MinInt32 || x > math.MaxInt32 {
+				return planResult{}, nil
+			}
+		}
+		if c.Type() != want {
+			return planResult{}, nil
+		}
+		var err error
+		if key, err = t.key.KeyOf(value); err != nil {
+			return planResult{}, nil
+		}
+		if op == "==" {
+			op = "="
+		}
+	}
+
+	return p.scan(t, n.String(), op, text, key, exact)
+}
+
+// find returns a tag whose key expression is the given one
+func (p *planner) find(n expr.Node) *planTag {
+	s := n.String()
+	for i := range p.tags {
+		if p.tags[i].key.String() == s {
+			return &p.tags[i]
+		}
+	}
+	return nil
+}
+
+// constValue returns the value of a constant operand
+func constValue(n expr.Node) (any, bool) {
+	switch n := n.(type) {
+	case *expr.Literal:
+		return n.Value, n.Value != nil
+	case *expr.Unary:
+		if lit, ok := n.X.(*expr.Literal); ok && n.Op == "-" {
+			if x, ok := lit.Value.(float64); ok {
+				return -x, true
+			}
+		}
+	}
+	return nil, false
+}
+
+// printableASCII reports whether a string only has printable ASCII
+// characters
+func printableASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] < ' ' || s[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// scan collects the records whose keys in a tag compare with key as op
+// requires. Character keys are compared by their start, as long as key.
+func (p *planner) scan(t *planTag, cond, op, text string, key []byte, exact bool) (planResult, error) {
+	set := newRecordSet(p.count)
+	tag := t.tag
+	cmp := func() int {
+		if t.key.Type() == expr.Character {
+			return comparePrefix(tag.CurrentKey(), string(key))
+		}
+		return strings.Compare(tag.CurrentKey(), string(key))
+	}
+
+	var err error
+	atEnd := false
+	if op == "<" || op == "<=" {
+		err = tag.First()
+	} else {
+		var result SeekResult
+		result, err = seekKey(tag, t.key.Type(), text, key)
+		atEnd = result == SeekEOF
+	}
+scan:
+	for ; err == nil && !atEnd && !tag.EOF(); err = tag.Next() {
+		switch c := cmp(); op {
+		case "=":
+			if c != 0 {
+				break scan
+			}
+		case "<":
+			if c >= 0 {
+				break scan
+			}
+		case "<=":
+			if c > 0 {
+				break scan
+			}
+		case ">":
+			if c == 0 {
+				continue
+			}
+		}
+		set.add(tag.RecordNumber())
+	}
+	if err != nil {
+		return planResult{}, err
+	}
+	step := PlanStep{Condition: cond, Tag: tag.Name(), Records: set.count()}
+	return planResult{set: set, exact: exact, steps: []PlanStep{step}}, nil
+}
+
+// seekKey positions a tag on the first key at or after key
+func seekKey(tag Tag, keyType expr.Type, text string, key []byte) (SeekResult, error) {
+	switch keyType {
+	case expr.Character:
+		return tag.SeekString(text)
+	case expr.Logical:
+		return tag.SeekString(string(key))
+	case expr.Integer:
+		return tag.SeekDouble(float64(int32(binary.BigEndian.Uint32(key) ^ 0x80000000)))
+	}
+	// Numbers, dates and datetimes are stored as doubles that sort bytewise
+	b := binary.BigEndian.Uint64(key)
+	if b&(1<<63) != 0 {
+		b &^= 1 << 63
+	} else {
+		b = ^b
+	}
+	return tag.SeekDouble(math.Float64frombits(b))
+}
+
+// recordSet is a set of record numbers, a bit per record
+type recordSet struct {
+	bits []uint64
+}
+
+func newRecordSet(count int) *recordSet {
+	return &recordSet{bits: make([]uint64, count/64+1)}
+}
+
+func (s *recordSet) add(n int) {
+	for n/64 >= len(s.bits) {
+		s.bits = append(s.bits, 0)
+	}
+	s.bits[n/64] |= 1 << (n % 64)
+}
+
+func (s *recordSet) has(n int) bool {
+	return n > 0 && n/64 < len(s.bits) && s.bits[n/64]&(1<<(n%64)) != 0
+}
+
+// or adds the records of o
+func (s *recordSet) or(o *recordSet) {
+	for len(s.bits) < len(o.bits) {
+		s.bits = append(s.bits, 0)
+	}
+	for i, w := range o.bits {
+		s.bits[i] |= w
+	}
+}
+
+// and keeps the records also in o
+func (s *recordSet) and(o *recordSet) {
+	for i := range s.bits {
+		if i < len(o.bits) {
+			s.bits[i] &= o.bits[i]
+		} else {
+			s.bits[i] = 0
+		}
+	}
+}
+
+// not turns the set into the records 1 to count it does not hold
+func (s *recordSet) not(count int) {
+	for len(s.bits) <= count/64 {
+		s.bits = append(s.bits, 0)
+	}
+	for i := range s.bits {
+		s.bits[i] = ^s.bits[i]
+	}
+	s.bits[0] &^= 1 // There is no record 0
+	for n := count + 1; n < len(s.bits)*64; n++ {
+		s.bits[n/64] &^= 1 << (n % 64)
+	}
+}
+
+func (s *recordSet) count() int {
+	n := 0
+	for _, w := range s.bits {
+		n += bits.OnesCount64(w)
+	}
+	return n
+}
+
+// next returns the nearest record of the set after n, or before n when
+// step is negative; 0 when there is none
+func (s *recordSet) next(n, step int) int {
+	for n += step; n > 0 && n/64 < len(s.bits); n += step {
+		if s.bits[n/64] == 0 {
+			// Skip the rest of an empty word
+			if step > 0 {
+				n = n/64*64 + 63
+			} else {
+				n = n / 64 * 64
+			}
+			continue
+		}
+		if s.has(n) {
+			return n
+		}
+	}
+	return 0
+}
+
+// intersect combines two sets with .AND., either of which may be nil for
+// every record
+func intersect(x, y *recordSet) *recordSet {
+	switch {
+	case x == nil:
+		return y
+	case y == nil:
+		return x
+	}
+	x.and(y)
+	return x
+}
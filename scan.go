@@ -0,0 +1,451 @@
+package foxi
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Scan and Put map struct fields to table fields. A struct field is mapped
+// to the table field named in its dbf tag, or to the table field with the
+// same name as the struct field when it has no tag; names are matched
+// without regard to case. Options follow the name, separated by commas:
+//
+//	type Customer struct {
+//		ID      int        `dbf:"CUST_ID"`
+//		Name    string     `dbf:"NAME,trim"`     // Strip the blank padding
+//		Closed  *time.Time `dbf:"CLOSED,null"`   // nil for .NULL.
+//		Notes   string     `dbf:"NOTES,memo"`    // Read the memo text
+//		Balance float64                          // Mapped to BALANCE
+//		Cache   string     `dbf:"-"`             // Never mapped
+//	}
+//
+// The null option needs a pointer field, which is nil when the table field
+// is null and sets the field to null when it is nil. Memo, general and
+// picture fields live in the memo file and are only mapped with the memo
+// option, so plain scans never pay for reading memo blocks. A tagged field
+// missing from the table is an error; an untagged one is skipped, as are
+// unexported fields. Fields of embedded structs are mapped as if they
+// belonged to the outer struct.
+//
+// Supported field types are strings, integers, floats, bools, time.Time,
+// Decimal, []byte, interface{} (which receives Field.Value) and pointers to
+// them. The mapping of a struct type is worked out once and cached, so
+// scanning in a loop only pays for the field conversions.
+
+// Scan copies the current record into the struct dst points to.
+func (f *Foxi) Scan(dst any) error {
+	return scanFields(f.Fields(), dst)
+}
+
+// Put assigns the fields of src, a struct or a pointer to one, to the
+// current record buffer. Call Write to store the record. A nil pointer
+// sets the field to null with the null option and leaves it unchanged
+// otherwise.
+func (f *Foxi) Put(src any) error {
+	return putFields(f.Fields(), src)
+}
+
+// scanFields copies the values of fields into the struct dst points to
+func scanFields(fields *Fields, dst any) error {
+	v := reflect.ValueOf(dst)
+	if v.Kind() != reflect.Pointer || v.IsNil() || v.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("scan needs a non-nil pointer to a struct, got %T", dst)
+	}
+	v = v.Elem()
+	plan, err := structPlanOf(v.Type())
+	if err != nil {
+		return err
+	}
+
+	for i := range plan.fields {
+		pf := &plan.fields[i]
+		field, err := pf.bind(fields)
+		if field == nil {
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		target := v.FieldByIndex(pf.index)
+		if pf.pointer {
+			if pf.null {
+				null, err := field.IsNull()
+				if err != nil {
+					return err
+				}
+				if null {
+					target.SetZero()
+					continue
+				}
+			}
+			if target.IsNil() {
+				target.Set(reflect.New(target.Type().Elem()))
+			}
+			target = target.Elem()
+		}
+		if err := pf.codec.decode(field, target, pf); err != nil {
+			return fmt.Errorf("failed to scan field %s: %w", field.Name(), err)
+		}
+	}
+	return nil
+}
+
+// putFields assigns the fields of struct src to fields
+func putFields(fields *Fields, src any) error {
+	v := reflect.ValueOf(src)
+	if v.Kind() == reflect.Pointer && !v.IsNil() {
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct {
+		return fmt.Errorf("put needs a struct or a pointer to one, got %T", src)
+	}
+	plan, err := structPlanOf(v.Type())
+	if err != nil {
+		return err
+	}
+
+	for i := range plan.fields {
+		pf := &plan.fields[i]
+		field, err := pf.bind(fields)
+		if field == nil {
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		source := v.FieldByIndex(pf.index)
+		if pf.pointer {
+			if source.IsNil() {
+				if pf.null {
+					if err := field.SetNull(); err != nil {
+						return err
+					}
+				}
+				continue
+			}
+			source = source.Elem()
+		}
+		if err := pf.codec.encode(field, source); err != nil {
+			return fmt.Errorf("failed to put field %s: %w", field.Name(), err)
+		}
+	}
+	return nil
+}
+
+// MustScan copies the current record into the struct dst points to.
+// Panics if the operation fails.
+func (f *Foxi) MustScan(dst any) {
+	if err := f.Scan(dst); err != nil {
+		panic(err)
+	}
+}
+
+// MustPut assigns the fields of src to the current record buffer.
+// Panics if the operation fails.
+func (f *Foxi) MustPut(src any) {
+	if err := f.Put(src); err != nil {
+		panic(err)
+	}
+}
+
+// structPlan is the cached mapping of a struct type to table fields
+type structPlan struct {
+	fields []planField
+}
+
+// planField maps one struct field
+type planField struct {
+	index   []int  // Index path for reflect.Value.FieldByIndex
+	name    string // Lower case table field name
+	tagged  bool   // Named by a dbf tag, so it must exist in the table
+	trim    bool
+	null    bool
+	memo    bool
+	pointer bool // Struct field is a pointer to the codec's type
+	codec   fieldCodec
+}
+
+// fieldCodec converts between a table field and a Go type
+type fieldCodec struct {
+	decode func(field Field, v reflect.Value, pf *planField) error
+	encode func(field Field, v reflect.Value) error
+}
+
+// structPlans caches *structPlan by reflect.Type
+var structPlans sync.Map
+
+var (
+	timeType    = reflect.TypeOf(time.Time{})
+	decimalType = reflect.TypeOf(Decimal{})
+)
+
+// structPlanOf returns the plan for a struct type, building it on first use
+func structPlanOf(t reflect.Type) (*structPlan, error) {
+	if plan, ok := structPlans.Load(t); ok {
+		return plan.(*structPlan), nil
+	}
+	plan := &structPlan{}
+	if err := plan.add(t, nil); err != nil {
+		return nil, err
+	}
+	actual, _ := structPlans.LoadOrStore(t, plan)
+	return actual.(*structPlan), nil
+}
+
+// add appends the mapped fields of struct type t, whose fields are reached
+// through index
+func (plan *structPlan) add(t reflect.Type, index []int) error {
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		tag, tagged := sf.Tag.Lookup("dbf")
+		if tag == "-" {
+			continue
+		}
+		fieldIndex := append(append([]int(nil), index...), i)
+
+		if sf.Anonymous && !tagged && sf.Type.Kind() == reflect.Struct && sf.Type != timeType && sf.Type != decimalType {
+			if err := plan.add(sf.Type, fieldIndex); err != nil {
+				return err
+			}
+			continue
+		}
+		if !sf.IsExported() {
+			continue
+		}
+
+		pf := planField{index: fieldIndex, name: sf.Name, tagged: tagged}
+		options := strings.Split(tag, ",")
+		if options[0] != "" {
+			pf.name = options[0]
+		}
+		pf.name = strings.ToLower(pf.name)
+		for _, option := range options[1:] {
+			switch strings.TrimSpace(option) {
+			case "trim":
+				pf.trim = true
+			case "null":
+				pf.null = true
+			case "memo":
+				pf.memo = true
+			default:
+				return fmt.Errorf("field %s of %s: unknown dbf option %q", sf.Name, t, option)
+			}
+		}
+
+		goType := sf.Type
+		if goType.Kind() == reflect.Pointer {
+			pf.pointer = true
+			goType = goType.Elem()
+		}
+		if pf.null && !pf.pointer {
+			return fmt.Errorf("field %s of %s: the null option needs a pointer", sf.Name, t)
+		}
+		codec, ok := codecFor(goType)
+		if !ok {
+			return fmt.Errorf("field %s of %s: unsupported type %s", sf.Name, t, sf.Type)
+		}
+		pf.codec = codec
+		plan.fields = append(plan.fields, pf)
+	}
+	return nil
+}
+
+// bind looks up the table field of a plan field. It returns nil without
+// an error for fields that are skipped.
+func (pf *planField) bind(fields *Fields) (Field, error) {
+	field := fields.ByName(pf.name)
+	if field == nil {
+		if pf.tagged {
+			return nil, fmt.Errorf("no field %s in table", strings.ToUpper(pf.name))
+		}
+		return nil, nil
+	}
+	if !pf.memo {
+		switch field.Type() {
+		case FTMemo, FTGeneral, FTPicture:
+			if pf.tagged {
+				return nil, fmt.Errorf("field %s is stored in the memo file and needs the memo option", field.Name())
+			}
+			return nil, nil
+		}
+	}
+	return field, nil
+}
+
+// codecFor returns the conversions for a Go type
+func codecFor(t reflect.Type) (fieldCodec, bool) {
+	switch {
+	case t == timeType:
+		return fieldCodec{decodeTime, encodeTime}, true
+	case t == decimalType:
+		return fieldCodec{decodeDecimal, encodeDecimal}, true
+	case t.Kind() == reflect.Slice && t.Elem().Kind() == reflect.Uint8:
+		return fieldCodec{decodeBytes, encodeBytes}, true
+	case t.Kind() == reflect.Interface && t.NumMethod() == 0:
+		return fieldCodec{decodeValue, encodeValue}, true
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		return fieldCodec{decodeString, encodeString}, true
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return fieldCodec{decodeInt, encodeInt}, true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return fieldCodec{decodeUint, encodeUint}, true
+	case reflect.Float32, reflect.Float64:
+		return fieldCodec{decodeFloat, encodeFloat}, true
+	case reflect.Bool:
+		return fieldCodec{decodeBool, encodeBool}, true
+	}
+	return fieldCodec{}, false
+}
+
+func decodeString(field Field, v reflect.Value, pf *planField) error {
+	s, err := field.AsString()
+	if err != nil {
+		return err
+	}
+	if pf.trim {
+		s = strings.TrimRight(s, " ")
+	}
+	v.SetString(s)
+	return nil
+}
+
+func encodeString(field Field, v reflect.Value) error {
+	return field.SetString(v.String())
+}
+
+func decodeInt(field Field, v reflect.Value, _ *planField) error {
+	n, err := field.AsInt()
+	if err != nil {
+		return err
+	}
+	if v.OverflowInt(int64(n)) {
+		return fmt.Errorf("value %d overflows %s", n, v.Type())
+	}
+	v.SetInt(int64(n))
+	return nil
+}
+
+func encodeInt(field Field, v reflect.Value) error {
+	return field.SetInt(int(v.Int()))
+}
+
+func decodeUint(field Field, v reflect.Value, _ *planField) error {
+	n, err := field.AsInt()
+	if err != nil {
+		return err
+	}
+	if n < 0 || v.OverflowUint(uint64(n)) {
+		return fmt.Errorf("value %d overflows %s", n, v.Type())
+	}
+	v.SetUint(uint64(n))
+	return nil
+}
+
+func encodeUint(field Field, v reflect.Value) error {
+	return field.SetInt(int(v.Uint()))
+}
+
+func decodeFloat(field Field, v reflect.Value, _ *planField) error {
+	x, err := field.AsFloat()
+	if err != nil {
+		return err
+	}
+	v.SetFloat(x)
+	return nil
+}
+
+func encodeFloat(field Field, v reflect.Value) error {
+	return field.SetFloat(v.Float())
+}
+
+func decodeBool(field Field, v reflect.Value, _ *planField) error {
+	b, err := field.AsBool()
+	if err != nil {
+		return err
+	}
+	v.SetBool(b)
+	return nil
+}
+
+func encodeBool(field Field, v reflect.Value) error {
+	return field.SetBool(v.Bool())
+}
+
+// decodeTime leaves a blank date as the zero time
+func decodeTime(field Field, v reflect.Value, _ *planField) error {
+	t, err := field.AsTime()
+	if err != nil {
+		return err
+	}
+	v.Set(reflect.ValueOf(t))
+	return nil
+}
+
+// encodeTime stores the zero time as a blank date
+func encodeTime(field Field, v reflect.Value) error {
+	t := v.Interface().(time.Time)
+	if t.IsZero() {
+		return field.SetString("")
+	}
+	return field.SetTime(t)
+}
+
+func decodeDecimal(field Field, v reflect.Value, _ *planField) error {
+	d, err := field.AsDecimal()
+	if err != nil {
+		return err
+	}
+	v.Set(reflect.ValueOf(d))
+	return nil
+}
+
+func encodeDecimal(field Field, v reflect.Value) error {
+	return field.SetDecimal(v.Interface().(Decimal))
+}
+
+func decodeBytes(field Field, v reflect.Value, _ *planField) error {
+	s, err := field.AsString()
+	if err != nil {
+		return err
+	}
+	v.SetBytes([]byte(s))
+	return nil
+}
+
+func encodeBytes(field Field, v reflect.Value) error {
+	return field.SetString(string(v.Bytes()))
+}
+
+func decodeValue(field Field, v reflect.Value, _ *planField) error {
+	value, err := field.Value()
+	if err != nil {
+		return err
+	}
+	if value == nil {
+		v.SetZero()
+		return nil
+	}
+	v.Set(reflect.ValueOf(value))
+	return nil
+}
+
+// encodeValue assigns the dynamic value of an interface field
+func encodeValue(field Field, v reflect.Value) error {
+	if v.IsNil() {
+		return field.SetNull()
+	}
+	v = v.Elem()
+	codec, ok := codecFor(v.Type())
+	if !ok || v.Kind() == reflect.Interface {
+		return fmt.Errorf("unsupported value of type %s", v.Type())
+	}
+	return codec.encode(field, v)
+}
@@ -0,0 +1,273 @@
+package foxi
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/mkfoss/foxi/expr"
+)
+
+// Session is a set of tables opened together, like a Visual FoxPro data
+// session or the tables of one CodeBase CODE4. Its tables share a single
+// CODE4, so a transaction started with Begin spans all of them, and they
+// share the session's settings: the date format of expressions, the code
+// page override and the lock retries.
+//
+// Each table has an alias, its file name unless given with OpenAs, by
+// which it is found with Table and by which the expressions compiled
+// against the other tables of the session read its fields:
+//
+//	s := foxi.NewSession()
+//	defer s.Close()
+//	customers := s.MustOpen("customer.dbf")
+//	s.MustOpenAs("orders.dbf", "o")
+//	total := foxi.MustCompile(customers, "O.AMOUNT * 1.2")
+//
+// A Session is not safe for concurrent use.
+type Session struct {
+	code   sessionCode // CODE4 of the backend, shared by the tables
+	tables []*Foxi     // Tables opened in the session, in the order opened
+	closed bool
+
+	dateFormat  string
+	codepage    Codepage
+	codepageSet bool
+}
+
+// NewSession creates an empty session with the default settings: dates
+// shown as MM/DD/YY, the code page of each table's header and locks
+// retried once a second until granted.
+func NewSession() *Session {
+	s := &Session{dateFormat: expr.DefaultDateFormat}
+	s.code.setLockRetry(-1, time.Second)
+	return s
+}
+
+// Open opens a table in the session under the alias its file name gives.
+func (s *Session) Open(filename string) (*Foxi, error) {
+	return s.OpenAs(filename, "")
+}
+
+// OpenAs opens a table in the session under an alias, as USE ... ALIAS
+// does. An empty alias takes the file name's. Opening a table under an
+// alias already in use fails, so a table opened twice needs a second
+// alias.
+func (s *Session) OpenAs(filename, alias string) (*Foxi, error) {
+	f := &Foxi{impl: s.code.table(), session: s}
+	if err := s.open(f, filename, alias); err != nil {
+		return nil, err
+	}
+	s.tables = append(s.tables, f)
+	return f, nil
+}
+
+// Create writes a new table described by schema, as the package function
+// Create does, and opens it in the session under the alias its file name
+// gives. Unless opts sets one, the table is marked with the code page set
+// with SetCodepage.
+func (s *Session) Create(path string, schema Schema, opts *CreateOptions) (*Foxi, error) {
+	if s.closed {
+		return nil, fmt.Errorf("session closed")
+	}
+	if s.codepageSet && s.codepage != CodepageNone && (opts == nil || opts.Codepage == 0) {
+		withCodepage := CreateOptions{}
+		if opts != nil {
+			withCodepage = *opts
+		}
+		withCodepage.Codepage = s.codepage
+		opts = &withCodepage
+	}
+	path, err := createTable(path, schema, opts)
+	if err != nil {
+		return nil, err
+	}
+	return s.Open(path)
+}
+
+// open opens a table of the session under an alias, applying the
+// session's settings
+func (s *Session) open(f *Foxi, filename, alias string) error {
+	if s.closed {
+		return fmt.Errorf("session closed")
+	}
+	if alias != "" && !isAlias(alias) {
+		return fmt.Errorf("invalid alias %q", alias)
+	}
+	name := strings.ToUpper(alias)
+	if name == "" {
+		name = fileAlias(filename)
+	}
+	if other := s.Table(name); other != nil && other != f {
+		return fmt.Errorf("alias %s is already in use", name)
+	}
+
+	f.alias = ""
+	if err := f.impl.Open(filename); err != nil {
+		return err
+	}
+	f.alias = strings.ToUpper(alias)
+	if s.codepageSet {
+		if err := f.SetCodepage(s.codepage); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	return nil
+}
+
+// isAlias reports whether name is a valid alias: letters, digits and
+// underscores, not starting with a digit
+func isAlias(name string) bool {
+	for i, r := range name {
+		switch {
+		case r == '_', r >= 'A' && r <= 'Z', r >= 'a' && r <= 'z':
+		case r >= '0' && r <= '9' && i > 0:
+		default:
+			return false
+		}
+	}
+	return name != ""
+}
+
+// Table returns the open table with the given alias, compared without
+// regard to case, or nil when there is none.
+func (s *Session) Table(alias string) *Foxi {
+	for _, f := range s.tables {
+		if f.Active() && strings.EqualFold(f.Alias(), alias) {
+			return f
+		}
+	}
+	return nil
+}
+
+// Tables returns the open tables of the session in the order they were
+// opened.
+func (s *Session) Tables() []*Foxi {
+	var tables []*Foxi
+	for _, f := range s.tables {
+		if f.Active() {
+			tables = append(tables, f)
+		}
+	}
+	return tables
+}
+
+// Close rolls back an open transaction, closes every table of the session
+// and releases the CODE4. The session cannot be used afterwards.
+func (s *Session) Close() error {
+	if s.closed {
+		return nil
+	}
+	var first error
+	tables := s.Tables()
+	if len(tables) > 0 && s.code.inTransaction() {
+		first = tables[0].impl.Rollback()
+	}
+	for _, f := range tables {
+		if err := f.Close(); err != nil && first == nil {
+			first = err
+		}
+	}
+	s.code.close()
+	s.tables = nil
+	s.closed = true
+	return first
+}
+
+// Begin starts a transaction covering every table of the session, those
+// opened after it starts included. Changes to any of them are kept by
+// Commit or undone by Rollback together. A table of the session cannot be
+// closed while the transaction is open.
+func (s *Session) Begin() (*Tx, error) {
+	tables := s.Tables()
+	if len(tables) == 0 {
+		return nil, fmt.Errorf("no table open in the session")
+	}
+	if err := tables[0].impl.Begin(); err != nil {
+		return nil, err
+	}
+	return &Tx{f: tables[0], session: s}, nil
+}
+
+// DateFormat returns the format dates are shown in by the expressions
+// compiled against the session's tables, a CodeBase date picture such as
+// MM/DD/YY.
+func (s *Session) DateFormat() string {
+	return s.dateFormat
+}
+
+// SetDateFormat sets the format DTOC, TTOC and TRANSFORM show dates in and
+// CTOD and CTOT read them in, as SET DATE and SET CENTURY do, for the
+// expressions compiled against the session's tables afterwards. The
+// format is a CodeBase date picture of CCYY or YY, MM and DD, such as
+// "DD.MM.CCYY" (see expr.DateLayout).
+func (s *Session) SetDateFormat(format string) error {
+	if _, err := expr.DateLayout(format); err != nil {
+		return err
+	}
+	s.dateFormat = strings.ToUpper(format)
+	return nil
+}
+
+// SetCodepage overrides the code page mark of every table of the session,
+// those opened later included, as Foxi.SetCodepage does for one table. It
+// is also the code page Create marks new tables with.
+func (s *Session) SetCodepage(cp Codepage) error {
+	if cp != CodepageNone && charsetFor(cp) == nil {
+		return fmt.Errorf("unsupported codepage: 0x%02X", byte(cp))
+	}
+	s.codepage, s.codepageSet = cp, true
+	for _, f := range s.Tables() {
+		if err := f.SetCodepage(cp); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SetLockRetry sets the lock retries of every table of the session, those
+// opened later included. The retries are a setting of the CODE4 the
+// tables share, set once for all of them.
+func (s *Session) SetLockRetry(attempts int, delay time.Duration) {
+	s.code.setLockRetry(attempts, delay)
+}
+
+// Locks returns the locks held through the session's tables, the lock
+// status of its CODE4. They come in the order the tables were opened, the
+// file and append locks of a table before its record locks, which are in
+// record order. Locks held by other sessions, in this process or another,
+// are not reported.
+func (s *Session) Locks() []Lock {
+	return s.code.locks(s.Tables())
+}
+
+// MustOpen opens a table in the session.
+// Panics if the operation fails.
+func (s *Session) MustOpen(filename string) *Foxi {
+	f, err := s.Open(filename)
+	if err != nil {
+		panic(err)
+	}
+	return f
+}
+
+// MustOpenAs opens a table in the session under an alias.
+// Panics if the operation fails.
+func (s *Session) MustOpenAs(filename, alias string) *Foxi {
+	f, err := s.OpenAs(filename, alias)
+	if err != nil {
+		panic(err)
+	}
+	return f
+}
+
+// MustBegin starts a transaction covering every table of the session.
+// Panics if the operation fails.
+func (s *Session) MustBegin() *Tx {
+	tx, err := s.Begin()
+	if err != nil {
+		panic(err)
+	}
+	return tx
+}
@@ -0,0 +1,751 @@
+package sql
+
+import (
+	byteorder "encoding/binary"
+	"fmt"
+	"math"
+	"math/big"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mkfoss/foxi"
+	"github.com/mkfoss/foxi/expr"
+	"github.com/mkfoss/foxi/internal/sqlparse"
+)
+
+// =========================================================================
+// AGGREGATES
+// =========================================================================
+
+// aggregate is an aggregate function of a query, computed over the rows of
+// each group
+type aggregate struct {
+	name     string // COUNT, SUM, AVG, MIN or MAX
+	star     bool
+	distinct bool
+	node     sqlparse.Node // The argument
+	arg      *expr.Program
+	ref      *colRef // The column a bare column argument reads, summed exactly
+	typ      expr.Type
+	len, dec int
+}
+
+// accumulator holds an aggregate's running state for a group
+type accumulator struct {
+	count int
+	sum   *big.Rat
+	best  any
+	seen  map[string]bool // Values counted, for DISTINCT
+}
+
+// isAggregate reports whether a call is an aggregate function rather than
+// the xBase function of the same name: MIN and MAX compare their
+// arguments when given more than one.
+func isAggregate(c *sqlparse.Call) bool {
+	switch c.Name {
+	case "COUNT", "CNT":
+		return true
+	case "SUM", "AVG", "MIN", "MAX":
+		return len(c.Args) == 1
+	}
+	return false
+}
+
+// hasAggregate reports whether an expression calls an aggregate function
+func hasAggregate(n sqlparse.Node) bool {
+	found := false
+	sqlparse.Walk(n, func(n sqlparse.Node) bool {
+		if c, ok := n.(*sqlparse.Call); ok && isAggregate(c) {
+			found = true
+		}
+		return !found
+	})
+	return found
+}
+
+// aggregateName is the name aggregates are read by in expressions
+func aggregateName(slot int) string {
+	return "_SQLA" + strconv.Itoa(slot+1)
+}
+
+// aggregateSlot returns the aggregate an expression name refers to
+func aggregateSlot(name string) (int, bool) {
+	rest, ok := strings.CutPrefix(strings.ToUpper(name), "_SQLA")
+	if !ok {
+		return 0, false
+	}
+	n, err := strconv.Atoi(rest)
+	return n - 1, err == nil && n > 0
+}
+
+// extractAggregates numbers the aggregate calls of an expression, sharing
+// one slot between identical calls
+func (q *query) extractAggregates(n sqlparse.Node) error {
+	var err error
+	sqlparse.Walk(n, func(n sqlparse.Node) bool {
+		c, ok := n.(*sqlparse.Call)
+		if !ok || !isAggregate(c) || err != nil {
+			return err == nil
+		}
+		if c.Name == "CNT" {
+			c.Name = "COUNT"
+		}
+		if !c.Star && len(c.Args) != 1 {
+			err = q.errorf(c, "%s needs one argument", c.Name)
+			return false
+		}
+		if c.Star && c.Name != "COUNT" {
+			err = q.errorf(c, "only COUNT accepts *")
+			return false
+		}
+		var arg sqlparse.Node
+		if !c.Star {
+			arg = c.Args[0]
+			if hasAggregate(arg) {
+				err = q.errorf(c, "aggregate functions cannot be nested")
+				return false
+			}
+		}
+		key := fmt.Sprintf("%s %t %t", c.Name, c.Star, c.Distinct)
+		if arg != nil {
+			key += " " + q.xbase(arg, nil)
+		}
+		slot, ok := q.aggKeys[key]
+		if !ok {
+			slot = len(q.aggs)
+			q.aggKeys[key] = slot
+			q.aggs = append(q.aggs, &aggregate{name: c.Name, star: c.Star, distinct: c.Distinct, node: arg})
+		}
+		q.slots[c] = slot
+		return false
+	})
+	return err
+}
+
+// compile compiles the argument of an aggregate and works out its type
+func (a *aggregate) compile(q *query) error {
+	a.typ, a.len = expr.Integer, 10
+	if a.star {
+		return nil
+	}
+	var err error
+	if a.arg, err = q.compileNode(a.node); err != nil {
+		return err
+	}
+	if c, ok := a.node.(*sqlparse.Column); ok {
+		a.ref = q.columns[q.sources[c.Table].alias+"."+strings.ToUpper(c.Name)]
+	}
+	switch a.name {
+	case "SUM", "AVG":
+		if family(a.arg.Type()) != expr.Numeric {
+			start, end := a.node.Span()
+			return fmt.Errorf("%s needs a numeric argument, not %s", a.name, q.source(start, end))
+		}
+		a.typ, a.len, a.dec = expr.Numeric, 20, a.arg.Dec()
+		if a.name == "AVG" {
+			a.dec = 4
+		}
+	case "MIN", "MAX":
+		a.typ, a.len, a.dec = a.arg.Type(), a.arg.Len(), a.arg.Dec()
+	}
+	return nil
+}
+
+// add adds the current row to a group's accumulator. Null values are
+// left out.
+func (a *aggregate) add(acc *accumulator) error {
+	if a.star {
+		acc.count++
+		return nil
+	}
+	v, err := a.arg.Eval()
+	if err != nil || v == nil {
+		return err
+	}
+	if a.distinct {
+		k := valueKey(v)
+		if acc.seen[k] {
+			return nil
+		}
+		if acc.seen == nil {
+			acc.seen = map[string]bool{}
+		}
+		acc.seen[k] = true
+	}
+	acc.count++
+	switch a.name {
+	case "SUM", "AVG":
+		if acc.sum == nil {
+			acc.sum = new(big.Rat)
+		}
+		if a.ref == nil || a.ref.field == nil {
+			r := new(big.Rat).SetFloat64(v.(float64))
+			if r == nil {
+				return fmt.Errorf("%s of %v", a.name, v)
+			}
+			acc.sum.Add(acc.sum, r)
+			break
+		}
+		exact, err := a.ref.exact()
+		if err != nil {
+			return err
+		}
+		if d, ok := exact.(foxi.Decimal); ok {
+			acc.sum.Add(acc.sum, d.Rat())
+		}
+	case "MIN":
+		if acc.best == nil || compareValues(v, acc.best) < 0 {
+			acc.best = v
+		}
+	case "MAX":
+		if acc.best == nil || compareValues(v, acc.best) > 0 {
+			acc.best = v
+		}
+	}
+	return nil
+}
+
+// result returns the value of an aggregate over a group, SUM and AVG as a
+// foxi.Decimal with the aggregate's decimal places: null for SUM, AVG, MIN
+// and MAX when the group has no values
+func (a *aggregate) result(acc *accumulator) any {
+	switch a.name {
+	case "COUNT":
+		return acc.count
+	case "SUM":
+		if acc.count > 0 {
+			return roundRat(acc.sum, a.dec)
+		}
+	case "AVG":
+		if acc.count > 0 {
+			return roundRat(new(big.Rat).Quo(acc.sum, new(big.Rat).SetInt64(int64(acc.count))), a.dec)
+		}
+	default:
+		return acc.best
+	}
+	return nil
+}
+
+// group is a group of rows with the same GROUP BY values
+type group struct {
+	keys   []any
+	values []any // Columns of the group's last row, by colRef slot
+	acc    []accumulator
+}
+
+// =========================================================================
+// EXECUTION
+// =========================================================================
+
+// resultRow is a row of the result with the values it is sorted by
+type resultRow struct {
+	values []any
+	keys   []any
+}
+
+// run executes the query, passing the rows of the result to yield until
+// it returns false. The tables are moved and filtered while it runs and
+// left as they were when it returns.
+func (q *query) run(yield func([]any) bool) error {
+	defer q.restore()
+	if err := q.open(); err != nil {
+		return err
+	}
+
+	sorted := len(q.order) > 0
+	var rows []resultRow
+	// TOP and LIMIT both cap the rows delivered after the OFFSET ones
+	limit := q.limit
+	if top := q.stmt.Top; top > 0 && (limit < 0 || top < limit) {
+		limit = top
+	}
+	delivered, skipped := 0, 0
+	seen := map[string]bool{}
+	deliver := func(values []any) bool {
+		if q.stmt.Distinct {
+			k := rowKey(values)
+			if seen[k] {
+				return true
+			}
+			seen[k] = true
+		}
+		if skipped < q.offset {
+			skipped++
+			return true
+		}
+		if limit >= 0 && delivered >= limit {
+			return false
+		}
+		if !yield(values) {
+			return false
+		}
+		delivered++
+		return limit < 0 || delivered < limit
+	}
+	output := func() (bool, error) {
+		if q.having != nil {
+			if ok, err := q.having.EvalBool(); err != nil || !ok {
+				return true, err
+			}
+		}
+		values, err := q.row()
+		if err != nil {
+			return false, err
+		}
+		if !sorted {
+			return deliver(values), nil
+		}
+		row := resultRow{values: values, keys: make([]any, len(q.order))}
+		for i, key := range q.order {
+			if key.output >= 0 {
+				row.keys[i] = values[key.output]
+			} else if row.keys[i], err = key.prog.Eval(); err != nil {
+				return false, err
+			}
+		}
+		rows = append(rows, row)
+		return true, nil
+	}
+
+	if q.grouped {
+		groups, err := q.groups()
+		if err != nil {
+			return err
+		}
+		for _, g := range groups {
+			q.group = g
+			more, err := output()
+			if err != nil || !more {
+				q.group = nil
+				return err
+			}
+		}
+		q.group = nil
+	} else if _, err := q.scan(0, output); err != nil {
+		return err
+	}
+
+	if sorted {
+		sort.SliceStable(rows, func(i, j int) bool {
+			for k, key := range q.order {
+				if c := compareValues(rows[i].keys[k], rows[j].keys[k]); c != 0 {
+					return c < 0 != key.desc
+				}
+			}
+			return false
+		})
+		for _, row := range rows {
+			if !deliver(row.values) {
+				break
+			}
+		}
+	}
+	return nil
+}
+
+// row evaluates the result columns. Numbers are given as foxi.Decimal:
+// decimal fields and aggregates over them with their exact digits, other
+// numbers rounded to the decimal places of their column.
+func (q *query) row() ([]any, error) {
+	values := make([]any, len(q.outputs))
+	for i, out := range q.outputs {
+		var v any
+		var err error
+		switch {
+		case out.agg >= 0 && q.group != nil:
+			v = q.aggs[out.agg].result(&q.group.acc[out.agg])
+		case out.ref != nil && q.group != nil:
+			v = q.group.values[out.ref.slot]
+		case out.ref != nil:
+			v, err = out.ref.exact()
+		default:
+			v, err = out.prog.Eval()
+		}
+		if err != nil {
+			return nil, err
+		}
+		if n, ok := v.(float64); ok {
+			if out.Type == expr.Integer {
+				v = int(n)
+			} else {
+				v = floatDecimal(n, out.Dec)
+			}
+		}
+		values[i] = v
+	}
+	return values, nil
+}
+
+// floatDecimal returns a number rounded to dec decimal places. Numbers
+// without a decimal form, infinities and NaN, stay float64.
+func floatDecimal(n float64, dec int) any {
+	d, err := foxi.ParseDecimal(strconv.FormatFloat(n, 'f', dec, 64))
+	if err != nil {
+		return n
+	}
+	return d
+}
+
+// roundRat returns a number with dec decimal places, rounded half away
+// from zero
+func roundRat(r *big.Rat, dec int) foxi.Decimal {
+	scale := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(dec)), nil)
+	num := new(big.Int).Mul(r.Num(), scale)
+	quo, rem := new(big.Int).QuoRem(num, r.Denom(), new(big.Int))
+	if rem.Abs(rem).Lsh(rem, 1).Cmp(r.Denom()) >= 0 {
+		quo.Add(quo, big.NewInt(int64(r.Sign())))
+	}
+	d, _ := foxi.DecimalFromRat(new(big.Rat).SetFrac(quo, scale), dec)
+	return d
+}
+
+// groups reads the joined rows into groups, in the order of their GROUP
+// BY values. Without GROUP BY every row falls into a single group, which
+// exists even when there are no rows.
+func (q *query) groups() ([]*group, error) {
+	byKey := map[string]*group{}
+	var groups []*group
+	_, err := q.scan(0, func() (bool, error) {
+		keys := make([]any, len(q.groupBy))
+		for i, prog := range q.groupBy {
+			v, err := prog.Eval()
+			if err != nil {
+				return false, err
+			}
+			keys[i] = v
+		}
+		k := rowKey(keys)
+		g, ok := byKey[k]
+		if !ok {
+			g = &group{keys: keys, acc: make([]accumulator, len(q.aggs))}
+			byKey[k] = g
+			groups = append(groups, g)
+		}
+		for i, agg := range q.aggs {
+			if err := agg.add(&g.acc[i]); err != nil {
+				return false, err
+			}
+		}
+		if g.values == nil {
+			g.values = make([]any, len(q.colList))
+		}
+		for i, ref := range q.colList {
+			v, err := ref.exact()
+			if err != nil {
+				return false, err
+			}
+			g.values[i] = v
+		}
+		return true, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(groups) == 0 && len(q.groupBy) == 0 {
+		groups = append(groups, &group{values: make([]any, len(q.colList)), acc: make([]accumulator, len(q.aggs))})
+	}
+	sort.SliceStable(groups, func(i, j int) bool {
+		for k := range groups[i].keys {
+			if c := compareValues(groups[i].keys[k], groups[j].keys[k]); c != 0 {
+				return c < 0
+			}
+		}
+		return false
+	})
+	return groups, nil
+}
+
+// scan joins the tables from level on, calling emit for every joined row
+// with the tables positioned on its records. It returns false when emit
+// asks to stop.
+func (q *query) scan(level int, emit func() (bool, error)) (bool, error) {
+	if level == len(q.sources) {
+		return emit()
+	}
+	s := q.sources[level]
+	matched := false
+	more, err := s.each(func() (bool, error) {
+		if ok, err := test(s.match); err != nil || !ok {
+			return true, err
+		}
+		matched = true
+		if ok, err := test(s.filter); err != nil || !ok {
+			return true, err
+		}
+		return q.scan(level+1, emit)
+	})
+	if err != nil || !more || matched || s.join != sqlparse.JoinLeft {
+		return more, err
+	}
+
+	// A row no record of an outer joined table matches is padded with
+	// nulls
+	s.null = true
+	defer func() { s.null = false }()
+	if ok, err := test(s.filter); err != nil || !ok {
+		return true, err
+	}
+	return q.scan(level+1, emit)
+}
+
+// test reports whether the joined row passes every condition
+func test(conds []*cond) (bool, error) {
+	for _, c := range conds {
+		if ok, err := c.prog.EvalBool(); err != nil || !ok {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// each moves the table to each of its candidate records for the current
+// row of the tables before it, skipping deleted records
+func (s *source) each(visit func() (bool, error)) (bool, error) {
+	f := s.f
+	switch {
+	case s.seek != nil:
+		return s.seek.each(f, visit)
+
+	case s.index == 0:
+		for err := f.First(); !f.EOF(); err = f.Next() {
+			if err != nil {
+				return false, err
+			}
+			if f.Deleted() {
+				continue
+			}
+			if more, err := visit(); err != nil || !more {
+				return more, err
+			}
+		}
+		return true, nil
+	}
+
+	if !s.scanned {
+		for err := f.First(); !f.EOF(); err = f.Next() {
+			if err != nil {
+				return false, err
+			}
+			if !f.Deleted() {
+				s.records = append(s.records, f.Position())
+			}
+		}
+		s.scanned = true
+	}
+	for _, n := range s.records {
+		if err := f.Goto(n); err != nil {
+			return false, err
+		}
+		if more, err := visit(); err != nil || !more {
+			return more, err
+		}
+	}
+	return true, nil
+}
+
+// each seeks the value of the probe and visits the records whose keys
+// match it: those starting with it for character keys, as = compares
+// strings, and those equal to it for other keys. The join condition
+// itself is tested on each.
+func (p *seekPath) each(f *foxi.Foxi, visit func() (bool, error)) (bool, error) {
+	v, err := p.probe.Eval()
+	if err != nil || v == nil {
+		return true, err
+	}
+	if s, ok := v.(string); ok {
+		v = strings.TrimRight(s, " ")
+	}
+	key, err := p.key.KeyOf(v)
+	if err != nil {
+		// A value that cannot be stored in the key matches no key
+		return true, nil
+	}
+	prefix := string(key)
+	if p.key.Type() == expr.Character {
+		prefix = strings.TrimRight(prefix, " ")
+	}
+
+	result, err := seekKey(p.tag, p.key.Type(), v, key)
+	if err != nil || result == foxi.SeekEOF {
+		return true, err
+	}
+	for ; !f.EOF(); err = f.Next() {
+		if err != nil {
+			return false, err
+		}
+		current := p.tag.CurrentKey()
+		if p.key.Type() == expr.Character && !strings.HasPrefix(current, prefix) ||
+			p.key.Type() != expr.Character && current != prefix {
+			break
+		}
+		if f.Deleted() {
+			continue
+		}
+		if more, err := visit(); err != nil || !more {
+			return more, err
+		}
+	}
+	return true, nil
+}
+
+// seekKey positions a tag on the first key at or after a value, given
+// with its encoded key
+func seekKey(tag foxi.Tag, keyType expr.Type, v any, key []byte) (foxi.SeekResult, error) {
+	switch keyType {
+	case expr.Character:
+		return tag.SeekString(v.(string))
+	case expr.Logical:
+		return tag.SeekString(string(key))
+	case expr.Integer:
+		return tag.SeekDouble(float64(int32(byteorder.BigEndian.Uint32(key) ^ 0x80000000)))
+	}
+	// Numbers, dates and datetimes are stored as doubles that sort bytewise
+	b := byteorder.BigEndian.Uint64(key)
+	if b&(1<<63) != 0 {
+		b &^= 1 << 63
+	} else {
+		b = ^b
+	}
+	return tag.SeekDouble(math.Float64frombits(b))
+}
+
+// =========================================================================
+// TABLE STATE
+// =========================================================================
+
+// tableState is what a query changes about a table: its filter, selected
+// tag and position
+type tableState struct {
+	filter string
+	tag    foxi.Tag
+	record int
+}
+
+// open saves the state of the tables and sets them up for the query: the
+// conditions on a table alone become its filter, and a table read through
+// a tag has that tag selected
+func (q *query) open() error {
+	for _, s := range q.sources {
+		s.saved = tableState{filter: s.f.Filter(), tag: s.f.Indexes().SelectedTag(), record: s.f.Position()}
+	}
+	for _, s := range q.sources {
+		if err := s.f.SetFilter(q.filterText(s)); err != nil {
+			return fmt.Errorf("table %s: %w", s.alias, err)
+		}
+		var tag foxi.Tag
+		if s.seek != nil {
+			tag = s.seek.tag
+		}
+		if err := s.f.Indexes().SelectTag(tag); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// restore puts the tables back as they were before the query ran
+func (q *query) restore() {
+	for _, s := range q.sources {
+		if s.f == nil {
+			continue
+		}
+		_ = s.f.SetFilter(s.saved.filter)
+		_ = s.f.Indexes().SelectTag(s.saved.tag)
+		if s.saved.record > 0 {
+			_ = s.f.Goto(s.saved.record)
+		}
+	}
+	for _, f := range q.handles {
+		_ = f.Close()
+	}
+	q.handles = nil
+}
+
+// =========================================================================
+// VALUES
+// =========================================================================
+
+// compareValues orders two values of a column: null first, strings
+// without their trailing blanks
+func compareValues(a, b any) int {
+	switch {
+	case a == nil && b == nil:
+		return 0
+	case a == nil:
+		return -1
+	case b == nil:
+		return 1
+	}
+	switch a := a.(type) {
+	case string:
+		if b, ok := b.(string); ok {
+			return strings.Compare(strings.TrimRight(a, " "), strings.TrimRight(b, " "))
+		}
+	case float64:
+		if b, ok := b.(float64); ok {
+			return cmpOrdered(a, b)
+		}
+	case foxi.Decimal:
+		if b, ok := b.(foxi.Decimal); ok {
+			return a.Rat().Cmp(b.Rat())
+		}
+	case int:
+		if b, ok := b.(int); ok {
+			return cmpOrdered(a, b)
+		}
+	case bool:
+		if b, ok := b.(bool); ok && a != b {
+			if a {
+				return 1
+			}
+			return -1
+		}
+		return 0
+	case time.Time:
+		if b, ok := b.(time.Time); ok {
+			return a.Compare(b)
+		}
+	}
+	return strings.Compare(valueKey(a), valueKey(b))
+}
+
+func cmpOrdered[T int | float64](a, b T) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// valueKey returns a string that is the same for equal values
+func valueKey(v any) string {
+	switch v := v.(type) {
+	case nil:
+		return "X"
+	case string:
+		return "C" + strings.TrimRight(v, " ")
+	case float64:
+		return "N" + strconv.FormatFloat(v, 'g', -1, 64)
+	case foxi.Decimal:
+		return "N" + v.Rat().RatString()
+	case int:
+		return "N" + strconv.Itoa(v)
+	case time.Time:
+		return "T" + v.Format(time.RFC3339Nano)
+	}
+	return fmt.Sprintf("%T%v", v, v)
+}
+
+// rowKey returns a string that is the same for rows of equal values
+func rowKey(values []any) string {
+	parts := make([]string, len(values))
+	for i, v := range values {
+		parts[i] = valueKey(v)
+	}
+	return strings.Join(parts, "\x00")
+}
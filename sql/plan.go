@@ -0,0 +1,734 @@
+package sql
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/mkfoss/foxi"
+	"github.com/mkfoss/foxi/expr"
+	"github.com/mkfoss/foxi/internal/sqlparse"
+)
+
+// =========================================================================
+// QUERY
+// =========================================================================
+
+// query is a SELECT compiled against the tables it reads. Its expressions
+// are written out in xBase syntax and compiled with package expr against
+// an environment that resolves the columns of every table of the query.
+type query struct {
+	stmt    *sqlparse.Select
+	params  []string // Placeholder values as xBase constants
+	sources []*source
+	outputs []*output
+	order   []sortKey
+	groupBy []*expr.Program
+	having  *expr.Program
+	grouped bool // GROUP BY or aggregates: rows are grouped before output
+	limit   int  // Rows to deliver at most, -1 for every row
+	offset  int  // Rows to skip before delivering any
+
+	columns map[string]*colRef // Columns read, by ALIAS.NAME
+	colList []*colRef
+	aggs    []*aggregate
+	aggKeys map[string]int         // Aggregates by their xBase text, to share them
+	slots   map[*sqlparse.Call]int // Aggregate of each aggregate call
+
+	// The group post-aggregation expressions read, nil while scanning
+	group *group
+
+	handles []*foxi.Foxi // Tables opened again to be read twice, closed after the query
+}
+
+// source is a table of the FROM clause
+type source struct {
+	index int
+	name  string // Table name, upper case
+	alias string // Upper case
+	f     *foxi.Foxi
+	join  sqlparse.JoinKind
+	on    sqlparse.Node
+
+	match  []*cond         // Conditions deciding whether a record joins the row
+	filter []*cond         // WHERE conditions on an outer joined table, tested after padding
+	local  []sqlparse.Node // Conditions on this table alone, given to its filter
+	seek   *seekPath       // Records found with a tag for each row of the tables before
+
+	records []int // Candidates of an inner table, read once
+	scanned bool
+	null    bool // Padding row of an outer join: every column is null
+	saved   tableState
+}
+
+// cond is a condition tested on joined rows
+type cond struct {
+	node sqlparse.Node
+	prog *expr.Program
+}
+
+// output is a column of the result
+type output struct {
+	Column
+	node  sqlparse.Node
+	prog  *expr.Program
+	text  string     // In xBase, to match ORDER BY terms
+	field foxi.Field // The field a bare column shows, nil for other expressions
+	ref   *colRef    // The column read for a bare column
+	agg   int        // Aggregate a bare aggregate call shows, -1 for other expressions
+}
+
+// sortKey is an ORDER BY term: a result column or an expression
+type sortKey struct {
+	output int // Index of the result column, -1 for prog
+	prog   *expr.Program
+	desc   bool
+}
+
+// colRef is a field of a table that expressions read
+type colRef struct {
+	src   *source
+	prog  *expr.Program // The field name compiled against its table
+	slot  int           // Index of the value in group snapshots
+	field foxi.Field    // The field when it holds decimal numbers, read exactly
+}
+
+// compile resolves the names of a statement against its tables and
+// compiles its expressions
+func (q *query) compile() error {
+	q.columns = map[string]*colRef{}
+	q.aggKeys = map[string]int{}
+	q.slots = map[*sqlparse.Call]int{}
+	s := q.stmt
+
+	if err := q.expand(); err != nil {
+		return err
+	}
+	for _, src := range q.sources {
+		if err := q.resolve(src.on); err != nil {
+			return err
+		}
+	}
+	if err := q.resolve(s.Where); err != nil {
+		return err
+	}
+
+	// GROUP BY and ORDER BY may name result columns
+	for i, n := range s.GroupBy {
+		if out := q.outputRef(n); out >= 0 {
+			s.GroupBy[i] = q.outputs[out].node
+		} else if err := q.resolve(n); err != nil {
+			return err
+		}
+	}
+	order := make([]int, len(s.OrderBy))
+	for i, item := range s.OrderBy {
+		if order[i] = q.outputRef(item.Expr); order[i] < 0 {
+			if err := q.resolve(item.Expr); err != nil {
+				return err
+			}
+		}
+	}
+	if err := q.resolve(s.Having); err != nil {
+		return err
+	}
+
+	// Aggregates are only allowed where rows have been grouped
+	for _, n := range append(sqlparse.Conjuncts(s.Where), s.GroupBy...) {
+		if hasAggregate(n) {
+			return q.errorf(n, "aggregate functions are not allowed in WHERE or GROUP BY")
+		}
+	}
+	for _, src := range q.sources {
+		if hasAggregate(src.on) {
+			return q.errorf(src.on, "aggregate functions are not allowed in ON")
+		}
+	}
+	for _, out := range q.outputs {
+		if err := q.extractAggregates(out.node); err != nil {
+			return err
+		}
+	}
+	if err := q.extractAggregates(s.Having); err != nil {
+		return err
+	}
+	for i, item := range s.OrderBy {
+		if order[i] < 0 {
+			if err := q.extractAggregates(item.Expr); err != nil {
+				return err
+			}
+		}
+	}
+	q.grouped = len(s.GroupBy) > 0 || len(q.aggs) > 0
+
+	// Expressions
+	var err error
+	for _, agg := range q.aggs {
+		if err := agg.compile(q); err != nil {
+			return err
+		}
+	}
+	for _, out := range q.outputs {
+		if out.prog, err = q.compileNode(out.node); err != nil {
+			return err
+		}
+		out.Type, out.Len, out.Dec = out.prog.Type(), out.prog.Len(), out.prog.Dec()
+		out.agg = -1
+		switch n := out.node.(type) {
+		case *sqlparse.Column:
+			src := q.sources[n.Table]
+			out.field = src.f.FieldByName(n.Name)
+			out.ref = q.columns[src.alias+"."+strings.ToUpper(n.Name)]
+		case *sqlparse.Call:
+			if slot, ok := q.slots[n]; ok {
+				out.agg = slot
+			}
+		}
+	}
+	for _, n := range s.GroupBy {
+		prog, err := q.compileNode(n)
+		if err != nil {
+			return err
+		}
+		q.groupBy = append(q.groupBy, prog)
+	}
+	if s.Having != nil {
+		if q.having, err = q.compileCondition(s.Having); err != nil {
+			return err
+		}
+	}
+	for i, item := range s.OrderBy {
+		key := sortKey{output: order[i], desc: item.Desc}
+		if key.output < 0 {
+			if key.prog, err = q.compileNode(item.Expr); err != nil {
+				return err
+			}
+		}
+		q.order = append(q.order, key)
+	}
+	if q.limit, err = q.rowCount(s.Limit, "LIMIT", -1); err != nil {
+		return err
+	}
+	if q.offset, err = q.rowCount(s.Offset, "OFFSET", 0); err != nil {
+		return err
+	}
+	return q.plan()
+}
+
+// rowCount evaluates the number of rows given to LIMIT or OFFSET, which
+// must be a constant; def when the clause is left out
+func (q *query) rowCount(n sqlparse.Node, clause string, def int) (int, error) {
+	if n == nil {
+		return def, nil
+	}
+	if err := q.resolve(n); err != nil {
+		return 0, err
+	}
+	if refs(n) != 0 || hasAggregate(n) {
+		return 0, q.errorf(n, "%s needs a constant", clause)
+	}
+	prog, err := q.compileNode(n)
+	if err != nil {
+		return 0, err
+	}
+	v, err := prog.Eval()
+	if err != nil {
+		return 0, err
+	}
+	rows, ok := v.(float64)
+	if !ok || rows < 0 || rows != math.Trunc(rows) || rows > math.MaxInt32 {
+		return 0, q.errorf(n, "%s needs a whole number of rows, not %s", clause, q.source(n.Span()))
+	}
+	return int(rows), nil
+}
+
+// expand turns the select list into result columns, replacing * and
+// alias.* with the fields of the tables and naming every column
+func (q *query) expand() error {
+	for i, item := range q.stmt.Items {
+		if item.Star == "" {
+			if err := q.resolve(item.Expr); err != nil {
+				return err
+			}
+			name := strings.ToUpper(item.Alias)
+			if name == "" {
+				name = autoName(item.Expr, i+1)
+			}
+			q.outputs = append(q.outputs, &output{Column: Column{Name: name}, node: item.Expr})
+			continue
+		}
+
+		found := false
+		for _, src := range q.sources {
+			if item.Star != "*" && !strings.EqualFold(item.Star, src.alias) {
+				continue
+			}
+			found = true
+			for _, field := range userFields(src.f) {
+				ref := &sqlparse.Column{Name: field.Name(), Table: src.index}
+				q.outputs = append(q.outputs, &output{Column: Column{Name: field.Name()}, node: ref})
+			}
+		}
+		if !found {
+			return fmt.Errorf("unknown table alias %s in %s.*", item.Star, item.Star)
+		}
+	}
+
+	// Columns sharing a name are told apart with _A, _B and so on
+	count := map[string]int{}
+	for _, out := range q.outputs {
+		count[out.Name]++
+	}
+	seen := map[string]int{}
+	for _, out := range q.outputs {
+		if count[out.Name] > 1 {
+			name := out.Name
+			out.Name = fmt.Sprintf("%s_%c", name, 'A'+seen[name]%26)
+			seen[name]++
+		}
+	}
+
+	for _, out := range q.outputs {
+		out.text = q.xbase(out.node, nil)
+	}
+	return nil
+}
+
+// autoName names a result column the way Visual FoxPro does: a field
+// keeps its name, an aggregate of a field is named after the two, and
+// other expressions are EXP_ followed by their position
+func autoName(n sqlparse.Node, position int) string {
+	switch n := n.(type) {
+	case *sqlparse.Column:
+		return strings.ToUpper(n.Name)
+	case *sqlparse.Call:
+		if !isAggregate(n) {
+			break
+		}
+		if n.Star {
+			return "CNT"
+		}
+		if arg, ok := n.Args[0].(*sqlparse.Column); ok {
+			prefix := map[string]string{"COUNT": "CNT", "CNT": "CNT", "SUM": "SUM", "AVG": "AVG", "MIN": "MIN", "MAX": "MAX"}[n.Name]
+			return prefix + "_" + strings.ToUpper(arg.Name)
+		}
+	}
+	return "EXP_" + strconv.Itoa(position)
+}
+
+// outputRef returns the result column an ORDER BY or GROUP BY term names
+// by position, by name or by repeating its expression; -1 when it names
+// none
+func (q *query) outputRef(n sqlparse.Node) int {
+	switch n := n.(type) {
+	case *sqlparse.Literal:
+		if i, err := strconv.Atoi(n.Text); err == nil && i >= 1 && i <= len(q.outputs) {
+			return i - 1
+		}
+	case *sqlparse.Column:
+		if n.Alias == "" {
+			for i, out := range q.outputs {
+				if strings.EqualFold(out.Name, n.Name) {
+					return i
+				}
+			}
+		}
+	}
+	if err := q.resolve(n); err != nil {
+		return -1
+	}
+	text := q.xbase(n, nil)
+	for i, out := range q.outputs {
+		if out.text == text {
+			return i
+		}
+	}
+	return -1
+}
+
+// resolve finds the table of every column of an expression
+func (q *query) resolve(n sqlparse.Node) error {
+	var err error
+	sqlparse.Walk(n, func(n sqlparse.Node) bool {
+		c, ok := n.(*sqlparse.Column)
+		if !ok || c.Table >= 0 || err != nil {
+			return err == nil
+		}
+		if c.Alias != "" {
+			for _, src := range q.sources {
+				if strings.EqualFold(src.alias, c.Alias) {
+					c.Table = src.index
+				}
+			}
+			if c.Table < 0 {
+				err = q.errorf(c, "unknown table alias %s", c.Alias)
+			} else if !hasField(q.sources[c.Table].f, c.Name) {
+				err = q.errorf(c, "unknown column %s.%s", c.Alias, c.Name)
+			}
+			return false
+		}
+		for _, src := range q.sources {
+			if hasField(src.f, c.Name) {
+				if c.Table >= 0 {
+					err = q.errorf(c, "column %s is ambiguous; qualify it with a table alias", c.Name)
+					return false
+				}
+				c.Table = src.index
+			}
+		}
+		if c.Table < 0 {
+			err = q.errorf(c, "unknown column %s", c.Name)
+		}
+		return false
+	})
+	return err
+}
+
+// hasField reports whether a table has a field a query can read
+func hasField(f *foxi.Foxi, name string) bool {
+	field := f.FieldByName(name)
+	return field != nil && !field.IsSystem()
+}
+
+// userFields returns the fields of a table other than _NullFlags
+func userFields(f *foxi.Foxi) []foxi.Field {
+	fields := make([]foxi.Field, f.Fields().Count())
+	for i := range fields {
+		fields[i] = f.Fields().ByIndex(i)
+	}
+	return fields
+}
+
+// refs returns the tables an expression reads, a bit per table
+func refs(n sqlparse.Node) uint64 {
+	var bits uint64
+	sqlparse.Walk(n, func(n sqlparse.Node) bool {
+		if c, ok := n.(*sqlparse.Column); ok && c.Table >= 0 {
+			bits |= 1 << c.Table
+		}
+		return true
+	})
+	return bits
+}
+
+// errorf reports an error in an expression at its offset in the statement
+func (q *query) errorf(n sqlparse.Node, format string, args ...any) error {
+	start, _ := n.Span()
+	return fmt.Errorf("%s at offset %d", fmt.Sprintf(format, args...), start)
+}
+
+// =========================================================================
+// EXPRESSIONS
+// =========================================================================
+
+// compileNode compiles an expression of the query
+func (q *query) compileNode(n sqlparse.Node) (*expr.Program, error) {
+	prog, err := expr.Compile(q.xbase(n, nil), &env{q: q})
+	if err != nil {
+		start, end := n.Span()
+		return nil, fmt.Errorf("%s: %w", q.source(start, end), err)
+	}
+	return prog, nil
+}
+
+// compileCondition compiles an expression that must be logical
+func (q *query) compileCondition(n sqlparse.Node) (*expr.Program, error) {
+	prog, err := q.compileNode(n)
+	if err != nil {
+		return nil, err
+	}
+	if prog.Type() != expr.Logical && prog.Type() != expr.Null {
+		start, end := n.Span()
+		return nil, fmt.Errorf("%s is not a logical expression", q.source(start, end))
+	}
+	return prog, nil
+}
+
+// source returns the text of the statement between two offsets
+func (q *query) source(start, end int) string {
+	return q.stmt.Text[start:end]
+}
+
+// xbase writes an expression in xBase syntax. Columns are qualified with
+// the alias of their table, except those of local, which are written as
+// plain field names for the table's own filter, and aggregates are read
+// by the names env gives them.
+func (q *query) xbase(n sqlparse.Node, local *source) string {
+	w := sqlparse.Writer{
+		Params: q.params,
+		Column: func(c *sqlparse.Column) string {
+			if c.Table < 0 || q.sources[c.Table] == local {
+				return strings.ToUpper(c.Name)
+			}
+			return q.sources[c.Table].alias + "->" + strings.ToUpper(c.Name)
+		},
+		Call: func(c *sqlparse.Call) (string, bool) {
+			slot, ok := q.slots[c]
+			if !ok {
+				return "", false
+			}
+			return aggregateName(slot), true
+		},
+	}
+	return w.Write(n)
+}
+
+// env is the expression environment of a query. Columns are read from
+// the current records of the tables, from the snapshot of a group once
+// rows have been grouped, and as null on the padding row of an outer
+// join.
+type env struct {
+	q *query
+}
+
+// Column resolves ALIAS->NAME and the aggregates of the query
+func (e *env) Column(alias, name string) (*expr.Column, bool) {
+	q := e.q
+	if alias == "" {
+		slot, ok := aggregateSlot(name)
+		if !ok || slot >= len(q.aggs) {
+			return nil, false
+		}
+		agg := q.aggs[slot]
+		return &expr.Column{
+			Name: name, Type: agg.typ, Len: agg.len, Dec: agg.dec,
+			Get: func() (any, error) {
+				if q.group == nil {
+					return nil, fmt.Errorf("aggregate %s outside a group", agg.name)
+				}
+				return number(agg.result(&q.group.acc[slot])), nil
+			},
+		}, true
+	}
+
+	var src *source
+	for _, s := range q.sources {
+		if s.alias == alias {
+			src = s
+		}
+	}
+	if src == nil {
+		return nil, false
+	}
+	key := alias + "." + name
+	ref, ok := q.columns[key]
+	if !ok {
+		prog, err := foxi.Compile(src.f, name)
+		if err != nil {
+			return nil, false
+		}
+		ref = &colRef{src: src, prog: prog, slot: len(q.colList)}
+		if field := src.f.FieldByName(name); field != nil && isDecimal(field.Type()) {
+			ref.field = field
+		}
+		q.columns[key] = ref
+		q.colList = append(q.colList, ref)
+	}
+	return &expr.Column{
+		Name: name, Type: ref.prog.Type(), Len: ref.prog.Len(), Dec: ref.prog.Dec(),
+		Get: func() (any, error) {
+			if q.group != nil {
+				return number(q.group.values[ref.slot]), nil
+			}
+			return ref.value()
+		},
+	}, true
+}
+
+// value reads the column from the current record of its table
+func (c *colRef) value() (any, error) {
+	if c.src.null {
+		return nil, nil
+	}
+	return c.prog.Eval()
+}
+
+// exact reads the column as value does, with the digits of decimal
+// numbers as stored, as a foxi.Decimal
+func (c *colRef) exact() (any, error) {
+	if c.field == nil || c.src.null {
+		return c.value()
+	}
+	if null, err := c.field.IsNull(); err != nil || null {
+		return nil, err
+	}
+	return c.field.AsDecimal()
+}
+
+// isDecimal reports whether a field type holds numbers with decimals,
+// which a float64 may not hold exactly
+func isDecimal(ft foxi.FieldType) bool {
+	switch ft {
+	case foxi.FTNumeric, foxi.FTFloat, foxi.FTCurrency, foxi.FTDouble, foxi.FTBlob:
+		return true
+	}
+	return false
+}
+
+// number converts a foxi.Decimal to the float64 expressions compute with,
+// leaving other values alone
+func number(v any) any {
+	if d, ok := v.(foxi.Decimal); ok {
+		f, _ := d.Rat().Float64()
+		return f
+	}
+	return v
+}
+
+// RecNo returns the current record of the first table
+func (e *env) RecNo() int {
+	return e.q.sources[0].f.Position()
+}
+
+// Deleted reports whether the current record of the first table is
+// marked for deletion
+func (e *env) Deleted() bool {
+	return e.q.sources[0].f.Deleted()
+}
+
+// =========================================================================
+// PLANNING
+// =========================================================================
+
+// plan assigns every condition to the first table at which it can be
+// tested and chooses how each table finds its records: the first one
+// by walking the table under a filter of its own conditions, which
+// Rushmore answers from tags where it can; the others by seeking a tag
+// on a join condition, or else by reading the records matching their own
+// conditions once and going over them for every row.
+func (q *query) plan() error {
+	if len(q.sources) > 64 {
+		return fmt.Errorf("too many tables")
+	}
+
+	for _, src := range q.sources {
+		for _, n := range sqlparse.Conjuncts(src.on) {
+			src.match = append(src.match, &cond{node: n})
+		}
+	}
+	for _, n := range sqlparse.Conjuncts(q.stmt.Where) {
+		level := 0
+		for bits := refs(n); bits > 1; bits >>= 1 {
+			level++
+		}
+		src := q.sources[level]
+		if src.join == sqlparse.JoinLeft {
+			src.filter = append(src.filter, &cond{node: n})
+		} else {
+			src.match = append(src.match, &cond{node: n})
+		}
+	}
+
+	for _, src := range q.sources {
+		for _, list := range [][]*cond{src.match, src.filter} {
+			for _, c := range list {
+				var err error
+				if c.prog, err = q.compileCondition(c.node); err != nil {
+					return err
+				}
+			}
+		}
+		if src.index > 0 {
+			if err := q.findSeek(src); err != nil {
+				return err
+			}
+		}
+		var match []*cond
+		for _, c := range src.match {
+			if src.seek == nil && refs(c.node) == 1<<src.index {
+				src.local = append(src.local, c.node)
+				continue
+			}
+			match = append(match, c)
+		}
+		src.match = match
+	}
+	return nil
+}
+
+// filterText returns the conditions on a table alone as the expression
+// for its filter
+func (q *query) filterText(src *source) string {
+	parts := make([]string, len(src.local))
+	for i, n := range src.local {
+		parts[i] = q.xbase(n, src)
+	}
+	return strings.Join(parts, " .AND. ")
+}
+
+// seekPath finds the records of a table whose key in a tag equals a value
+// computed from the tables before it
+type seekPath struct {
+	tag   foxi.Tag
+	key   *expr.Program // The key expression of the tag, for encoding keys
+	probe *expr.Program // The value sought
+}
+
+// findSeek looks for a condition KEY = value among those deciding whether
+// a record of src joins, where KEY is the key expression of a tag of src
+// and the value only reads the tables before it
+func (q *query) findSeek(src *source) error {
+	bit := uint64(1) << src.index
+	for _, c := range src.match {
+		b, ok := c.node.(*sqlparse.Binary)
+		if !ok || b.Op != "=" && b.Op != "==" {
+			continue
+		}
+		for _, swapped := range []bool{false, true} {
+			key, probe := b.X, b.Y
+			if swapped {
+				key, probe = b.Y, b.X
+			}
+			if refs(key) != bit || refs(probe) >= bit || hasAggregate(probe) {
+				continue
+			}
+			tag, keyProg := q.findTag(src, key)
+			if tag == nil {
+				continue
+			}
+			// = on strings tests whether the left one starts with the right
+			// one, so a key on the right side cannot be sought by prefix
+			if swapped && keyProg.Type() == expr.Character && b.Op != "==" {
+				continue
+			}
+			probeProg, err := q.compileNode(probe)
+			if err != nil {
+				return err
+			}
+			if family(probeProg.Type()) != family(keyProg.Type()) {
+				continue
+			}
+			src.seek = &seekPath{tag: tag, key: keyProg, probe: probeProg}
+			return nil
+		}
+	}
+	return nil
+}
+
+// findTag returns a tag of src whose key expression is the given one, and
+// the expression compiled against the table
+func (q *query) findTag(src *source, n sqlparse.Node) (foxi.Tag, *expr.Program) {
+	keyProg, err := foxi.Compile(src.f, q.xbase(n, src))
+	if err != nil {
+		return nil, nil
+	}
+	for _, tag := range src.f.Indexes().Tags() {
+		if tag.IsDescending() || tag.IsUnique() || tag.Filter() != "" {
+			continue
+		}
+		prog, err := foxi.Compile(src.f, tag.Expression())
+		if err == nil && prog.String() == keyProg.String() {
+			return tag, prog
+		}
+	}
+	return nil, nil
+}
+
+// family maps integers onto numbers, which they compare with
+func family(t expr.Type) expr.Type {
+	if t == expr.Integer {
+		return expr.Numeric
+	}
+	return t
+}
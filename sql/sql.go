@@ -0,0 +1,554 @@
+// Package sql runs SELECT queries in the SQL dialect of Visual FoxPro over
+// open Foxi tables:
+//
+//	db := sql.NewDB("/data/sales")
+//	defer db.Close()
+//	rows, err := db.Query(`SELECT c.name, SUM(o.amount) AS total
+//		FROM customer c JOIN orders o ON o.custid = c.id
+//		WHERE o.date >= ? GROUP BY c.name ORDER BY total DESC`, since)
+//	for rows.Next() {
+//		fmt.Println(rows.Values())
+//	}
+//
+// The supported statement is
+//
+//	SELECT [DISTINCT] [TOP n] * | alias.* | expr [[AS] name], ...
+//	FROM table [[AS] alias] [[INNER | LEFT [OUTER]] JOIN table [[AS] alias] ON cond | , table ...]
+//	[WHERE cond] [GROUP BY expr, ...] [HAVING cond]
+//	[ORDER BY expr [ASC | DESC], ...] [LIMIT n [OFFSET m]] [INTO TABLE name]
+//
+// Expressions are those of package expr, over the columns of the tables,
+// written as name, alias.name or alias->name, together with AND, OR and
+// NOT, the operators != and #, [NOT] LIKE with % and _ as wildcards,
+// [NOT] BETWEEN, [NOT] IN (list), IS [NOT] NULL, || for joining strings,
+// ? placeholders and the aggregate functions COUNT(*), COUNT, SUM, AVG,
+// MIN and MAX, which take DISTINCT before their argument. Strings compare
+// as with SET EXACT OFF: = tests whether the left one starts with the
+// right one, and == compares them exactly. GROUP BY and ORDER BY accept
+// result columns by position or name. LIMIT and OFFSET take constants;
+// the rows skipped by OFFSET come before those TOP and LIMIT count.
+//
+// Package sqldriver runs its statements over the same parser and
+// expressions, so a condition selects the same records in both.
+//
+// Result columns are named after the fields they show, the names given
+// with AS, or as Visual FoxPro names them: CNT, SUM_AMOUNT, EXP_3 and so
+// on. Grouped rows come in the order of their GROUP BY values.
+//
+// Tables are joined with nested loops in the order they are listed.
+// Conditions on the first table alone become its filter, which Rushmore
+// answers from its tags where it can (see foxi.Foxi.Explain). A joined
+// table is read through a tag when the join condition compares the tag's
+// key expression with a value from the tables before it, and otherwise
+// from the records matching its own conditions, read once. Deleted
+// records are left out, as with SET DELETED ON.
+//
+// A query moves, filters and selects tags on the tables it reads while
+// its rows are read; Rows.Close puts them back as they were. Neither a DB
+// nor its tables may be used from several goroutines at once.
+package sql
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"iter"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mkfoss/foxi"
+	"github.com/mkfoss/foxi/expr"
+	"github.com/mkfoss/foxi/internal/sqlparse"
+)
+
+// =========================================================================
+// DATABASE
+// =========================================================================
+
+// DB is a set of tables queries can read, by name: tables added with Add,
+// and the .dbf files of a directory, opened on first use.
+type DB struct {
+	dir    string
+	tables map[string]*table // By upper case name
+}
+
+// table is a table of a DB
+type table struct {
+	f     *foxi.Foxi
+	path  string // File the table was opened from, empty for added tables
+	owned bool   // Opened by the DB, which closes it
+}
+
+// NewDB returns a DB over the tables in a directory. dir may be empty
+// when every table is added with Add.
+func NewDB(dir string) *DB {
+	return &DB{dir: dir, tables: map[string]*table{}}
+}
+
+// Add makes an open table available to queries under a name. The DB does
+// not close it.
+func (db *DB) Add(name string, f *foxi.Foxi) error {
+	key := strings.ToUpper(name)
+	if _, ok := db.tables[key]; ok {
+		return fmt.Errorf("table %s already exists", name)
+	}
+	if !sqlparse.IsIdent(name) {
+		return fmt.Errorf("invalid table name %q", name)
+	}
+	db.tables[key] = &table{f: f}
+	return nil
+}
+
+// Table returns the table of that name, opening the .dbf file of the
+// directory whose name matches it without regard to case.
+func (db *DB) Table(name string) (*foxi.Foxi, error) {
+	t, err := db.table(name)
+	if err != nil {
+		return nil, err
+	}
+	return t.f, nil
+}
+
+func (db *DB) table(name string) (*table, error) {
+	key := strings.ToUpper(name)
+	if t, ok := db.tables[key]; ok {
+		return t, nil
+	}
+	if db.dir == "" {
+		return nil, fmt.Errorf("no such table: %s", name)
+	}
+	entries, err := os.ReadDir(db.dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", db.dir, err)
+	}
+	for _, entry := range entries {
+		fileName := entry.Name()
+		if entry.IsDir() || !strings.EqualFold(filepath.Ext(fileName), ".dbf") {
+			continue
+		}
+		if strings.EqualFold(strings.TrimSuffix(fileName, filepath.Ext(fileName)), name) {
+			path := filepath.Join(db.dir, fileName)
+			f := foxi.NewFoxi()
+			if err := f.Open(path); err != nil {
+				return nil, err
+			}
+			t := &table{f: f, path: path, owned: true}
+			db.tables[key] = t
+			return t, nil
+		}
+	}
+	return nil, fmt.Errorf("no such table: %s", name)
+}
+
+// Close closes the tables the DB opened. Added tables stay open.
+func (db *DB) Close() error {
+	var firstErr error
+	for key, t := range db.tables {
+		if t.owned {
+			if err := t.f.Close(); err != nil && firstErr == nil {
+				firstErr = err
+			}
+		}
+		delete(db.tables, key)
+	}
+	return firstErr
+}
+
+// Query runs a SELECT statement, replacing its ? placeholders with args,
+// and returns its rows. The statement may not have an INTO clause.
+func (db *DB) Query(query string, args ...any) (*Rows, error) {
+	q, err := db.prepare(query, args)
+	if err != nil {
+		return nil, err
+	}
+	if q.stmt.Into != "" {
+		q.restore()
+		return nil, fmt.Errorf("Query does not write tables; use Exec for SELECT ... INTO TABLE")
+	}
+	return newRows(q), nil
+}
+
+// Exec runs a SELECT ... INTO TABLE statement, writing its rows to a new
+// table, and returns the number of rows written. A bare name creates the
+// table in the directory of the DB and a path creates it there; an
+// existing file is overwritten. The new table is added to the DB under its
+// name and closed with it. When Exec fails, a table it would replace is
+// left as it was.
+func (db *DB) Exec(query string, args ...any) (int, error) {
+	q, err := db.prepare(query, args)
+	if err != nil {
+		return 0, err
+	}
+	if q.stmt.Into == "" {
+		q.restore()
+		return 0, fmt.Errorf("Exec needs SELECT ... INTO TABLE; use Query to read rows")
+	}
+
+	name := strings.TrimSuffix(filepath.Base(q.stmt.Into), filepath.Ext(q.stmt.Into))
+	for _, s := range q.sources {
+		if strings.EqualFold(s.name, name) {
+			q.restore()
+			return 0, fmt.Errorf("cannot write the result to %s, which the query reads", s.name)
+		}
+	}
+	if old, ok := db.tables[strings.ToUpper(name)]; ok && !old.owned {
+		q.restore()
+		return 0, fmt.Errorf("cannot replace table %s, which was added to the DB", name)
+	}
+
+	var rows [][]any
+	if err := q.run(func(values []any) bool {
+		rows = append(rows, values)
+		return true
+	}); err != nil {
+		return 0, err
+	}
+
+	path := q.stmt.Into
+	if !filepath.IsAbs(path) && !strings.ContainsAny(path, `/\`) {
+		path = filepath.Join(db.dir, path)
+	}
+	if filepath.Ext(path) == "" {
+		path += ".dbf"
+	}
+	fields, err := resultFields(q.outputs, rows)
+	if err != nil {
+		return 0, err
+	}
+
+	// The rows are written to a new file beside the table, which replaces
+	// it once every row is in, so a failure leaves no table behind
+	temp, err := writeTemp(path, fields, rows)
+	if err != nil {
+		return 0, err
+	}
+	key := strings.ToUpper(name)
+	if old, ok := db.tables[key]; ok {
+		delete(db.tables, key)
+		if err := old.f.Close(); err != nil {
+			removeTable(temp)
+			return 0, err
+		}
+	}
+	if err := renameTable(temp, path); err != nil {
+		removeTable(temp)
+		return 0, err
+	}
+	f := foxi.NewFoxi()
+	if err := f.Open(path); err != nil {
+		return 0, err
+	}
+	db.tables[key] = &table{f: f, path: path, owned: true}
+	return len(rows), nil
+}
+
+// prepare parses and compiles a statement against the tables of the DB
+func (db *DB) prepare(text string, args []any) (*query, error) {
+	parsed, placeholders, err := sqlparse.Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	stmt, ok := parsed.(*sqlparse.Select)
+	if !ok {
+		return nil, fmt.Errorf("only SELECT statements are supported")
+	}
+	params, err := sqlparse.Literals(args)
+	if err != nil {
+		return nil, err
+	}
+	if placeholders != len(args) {
+		return nil, fmt.Errorf("statement has %d placeholders, got %d values", placeholders, len(args))
+	}
+	q := &query{stmt: stmt, params: params}
+	for i, item := range stmt.From {
+		t, err := db.table(item.Table)
+		if err != nil {
+			q.restore()
+			return nil, err
+		}
+		alias := strings.ToUpper(item.Alias)
+		if alias == "" {
+			alias = strings.ToUpper(item.Table)
+		}
+		f := t.f
+		for _, s := range q.sources {
+			if s.alias == alias {
+				q.restore()
+				return nil, fmt.Errorf("alias %s is used twice", alias)
+			}
+			if s.f != t.f {
+				continue
+			}
+			// A table read twice needs a cursor of its own
+			if t.path == "" {
+				q.restore()
+				return nil, fmt.Errorf("table %s is read twice; open it again and add it under another name", item.Table)
+			}
+			f = foxi.NewFoxi()
+			if err := f.Open(t.path); err != nil {
+				q.restore()
+				return nil, err
+			}
+			q.handles = append(q.handles, f)
+			break
+		}
+		q.sources = append(q.sources, &source{
+			index: i, name: strings.ToUpper(item.Table), alias: alias,
+			f: f, join: item.Join, on: item.On,
+		})
+	}
+	if err := q.compile(); err != nil {
+		q.restore()
+		return nil, err
+	}
+	return q, nil
+}
+
+// =========================================================================
+// ROWS
+// =========================================================================
+
+// Column describes a column of a query result.
+type Column struct {
+	Name string
+	Type expr.Type
+	Len  int // Width of character columns and of numbers as stored
+	Dec  int // Decimal places
+}
+
+// Rows is the result of a query, read a row at a time. Rows are computed
+// as they are read, except when they have to be sorted or grouped first.
+type Rows struct {
+	columns []Column
+	next    func() ([]any, bool)
+	stop    func()
+	err     *error
+	values  []any
+}
+
+func newRows(q *query) *Rows {
+	columns := make([]Column, len(q.outputs))
+	for i, out := range q.outputs {
+		columns[i] = out.Column
+	}
+	var err error
+	next, stop := iter.Pull(func(yield func([]any) bool) {
+		err = q.run(yield)
+	})
+	return &Rows{columns: columns, next: next, stop: stop, err: &err}
+}
+
+// Columns returns the columns of the result.
+func (r *Rows) Columns() []Column {
+	return r.columns
+}
+
+// Next moves to the next row, returning false at the end of the result or
+// on an error, which Err then returns. The tables are put back as they
+// were after the last row.
+func (r *Rows) Next() bool {
+	values, ok := r.next()
+	if !ok {
+		r.values = nil
+		return false
+	}
+	r.values = values
+	return true
+}
+
+// Values returns the values of the current row, in the order of Columns:
+// strings for character columns, foxi.Decimal for numbers, int for
+// integers and counts, bool, time.Time for dates and datetimes, and nil
+// for null. Numeric and currency fields, and their sums, keep the digits
+// they are stored with; other numbers are rounded to the decimal places
+// of their column.
+func (r *Rows) Values() []any {
+	return r.values
+}
+
+// Err returns the error that ended the rows, if any.
+func (r *Rows) Err() error {
+	return *r.err
+}
+
+// Close stops reading the rows and puts the tables back as they were.
+func (r *Rows) Close() error {
+	r.stop()
+	r.values = nil
+	return nil
+}
+
+// =========================================================================
+// INTO TABLE
+// =========================================================================
+
+// resultFields returns the fields of a table holding the result. Columns
+// showing a field keep its type and size; character columns are as wide
+// as their longest value, and memo fields when longer than a character
+// field can be; other numbers are as wide as their widest value. Columns
+// with nulls are nullable.
+func resultFields(outputs []*output, rows [][]any) ([]foxi.FieldSpec, error) {
+	fields := make([]foxi.FieldSpec, len(outputs))
+	used := map[string]bool{}
+	for i, out := range outputs {
+		spec := foxi.FieldSpec{Name: fieldName(out.Name, used)}
+		width := 0
+		for _, row := range rows {
+			switch v := row[i].(type) {
+			case nil:
+				spec.Nullable = true
+			case string:
+				width = max(width, len(strings.TrimRight(v, " ")))
+			case foxi.Decimal:
+				width = max(width, len(v.String()))
+			case float64:
+				width = max(width, len(strconv.FormatFloat(v, 'f', min(out.Dec, 18), 64)))
+			}
+		}
+		if out.field != nil {
+			switch ft := out.field.Type(); ft {
+			case foxi.FTNumeric, foxi.FTFloat, foxi.FTCurrency, foxi.FTDouble, foxi.FTBlob, foxi.FTInteger:
+				spec.Type, spec.Size, spec.Decimals = ft, out.field.Size(), out.field.Decimals()
+				fields[i] = spec
+				continue
+			}
+		}
+		switch out.Type {
+		case expr.Character:
+			spec.Type, spec.Size = foxi.FTCharacter, uint8(min(max(out.Len, width, 1), 255))
+			if max(out.Len, width) > 254 {
+				spec.Type, spec.Size = foxi.FTMemo, 0
+			}
+		case expr.Integer:
+			spec.Type = foxi.FTInteger
+		case expr.Date:
+			spec.Type = foxi.FTDate
+		case expr.DateTime:
+			spec.Type = foxi.FTDateTime
+		case expr.Logical:
+			spec.Type = foxi.FTLogical
+		case expr.Null:
+			spec.Type, spec.Size, spec.Nullable = foxi.FTCharacter, 1, true
+		default:
+			if width > 20 {
+				return nil, fmt.Errorf("column %s has values wider than a numeric field holds", out.Name)
+			}
+			spec.Type, spec.Decimals = foxi.FTNumeric, uint8(min(out.Dec, 18))
+			spec.Size = uint8(max(width, 1))
+			if spec.Decimals > 0 && spec.Size < spec.Decimals+2 {
+				spec.Size = spec.Decimals + 2
+			}
+		}
+		fields[i] = spec
+	}
+	return fields, nil
+}
+
+// fieldName cuts a column name to the ten characters of a field name,
+// numbering it when another field has the same name
+func fieldName(name string, used map[string]bool) string {
+	if len(name) > 10 {
+		name = name[:10]
+	}
+	base := name
+	for n := 2; used[name]; n++ {
+		suffix := fmt.Sprintf("_%d", n)
+		name = base[:min(len(base), 10-len(suffix))] + suffix
+	}
+	used[name] = true
+	return name
+}
+
+// writeTemp writes the rows of a result to a new table in the directory of
+// path and returns the name of its file, removing it on failure
+func writeTemp(path string, fields []foxi.FieldSpec, rows [][]any) (string, error) {
+	file, err := os.CreateTemp(filepath.Dir(path), "sql*.dbf")
+	if err != nil {
+		return "", err
+	}
+	temp := file.Name()
+	file.Close()
+
+	f, err := foxi.Create(temp, foxi.Schema{Fields: fields}, &foxi.CreateOptions{Overwrite: true})
+	if err != nil {
+		removeTable(temp)
+		return "", err
+	}
+	for _, values := range rows {
+		if err = writeRow(f, values); err != nil {
+			break
+		}
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		removeTable(temp)
+		return "", err
+	}
+	return temp, nil
+}
+
+// memoFile returns the name of the memo file of a table
+func memoFile(path string) string {
+	return strings.TrimSuffix(path, filepath.Ext(path)) + ".fpt"
+}
+
+// renameTable moves a table and its memo file to path, replacing the
+// table there
+func renameTable(from, path string) error {
+	if err := os.Rename(from, path); err != nil {
+		return err
+	}
+	err := os.Rename(memoFile(from), memoFile(path))
+	if errors.Is(err, fs.ErrNotExist) {
+		// The memo file of the table replaced belongs to no table now
+		err = os.Remove(memoFile(path))
+		if errors.Is(err, fs.ErrNotExist) {
+			err = nil
+		}
+	}
+	return err
+}
+
+// removeTable removes a table and its memo file
+func removeTable(path string) {
+	os.Remove(path)
+	os.Remove(memoFile(path))
+}
+
+// writeRow appends a row of the result to a table
+func writeRow(f *foxi.Foxi, values []any) error {
+	if err := f.Append(); err != nil {
+		return err
+	}
+	for i, v := range values {
+		field := f.Fields().ByIndex(i)
+		var err error
+		switch v := v.(type) {
+		case nil:
+			err = field.SetNull()
+		case string:
+			err = field.SetString(strings.TrimRight(v, " "))
+		case foxi.Decimal:
+			err = field.SetDecimal(v)
+		case float64:
+			err = field.SetFloat(v)
+		case int:
+			err = field.SetInt(v)
+		case bool:
+			err = field.SetBool(v)
+		case time.Time:
+			err = field.SetTime(v)
+		default:
+			err = fmt.Errorf("cannot write %T", v)
+		}
+		if err != nil {
+			return fmt.Errorf("column %s: %w", field.Name(), err)
+		}
+	}
+	return f.Write()
+}
@@ -0,0 +1,454 @@
+// Package sqldriver registers a database/sql driver named "foxi" that
+// queries a directory of Visual FoxPro tables with SQL.
+//
+// The data source name is the path of a directory and every .dbf file in
+// it is a table named after the file, matched without regard to case:
+//
+//	db, err := sql.Open("foxi", "/data/sales")
+//	rows, err := db.Query("SELECT name, amount FROM customer WHERE city = ? ORDER BY amount DESC LIMIT 10", "Oslo")
+//
+// The supported statements are
+//
+//	SELECT ... (as package github.com/mkfoss/foxi/sql runs it)
+//	INSERT INTO table [(col, ...)] VALUES (expr, ...)[, (expr, ...)]
+//	UPDATE table SET col = expr, ... [WHERE cond]
+//	DELETE FROM table [WHERE cond]
+//
+// Statements are parsed as package sql parses them, and SELECT, with its
+// joins, grouping and INTO TABLE, runs in package sql; use Exec for
+// SELECT ... INTO TABLE. Expressions are those of package expr together
+// with the SQL operators, so strings compare as in Visual FoxPro: = tests
+// whether the left string starts with the right one, as with SET EXACT
+// OFF, and == compares them exactly, trailing blanks included. Dates are
+// written {^2024-01-31}, or given as time.Time placeholder values.
+//
+// Deleted records are skipped and DELETE only marks records for deletion,
+// as in Visual FoxPro. The WHERE clause of UPDATE and DELETE becomes the
+// table's filter while the matching records are found, so Rushmore
+// answers it from the table's CDX tags where it can.
+//
+// Outside a transaction every statement opens the tables it needs and
+// closes them when it is done, and a statement changing several records
+// runs in a Foxi transaction of its own, so that it changes all of them or
+// none. A sql.Tx opens its tables in one foxi.Session and runs a single
+// session transaction over them, so Commit and Rollback keep or undo the
+// changes to every table together.
+package sqldriver
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/mkfoss/foxi"
+	"github.com/mkfoss/foxi/internal/sqlparse"
+)
+
+func init() {
+	sql.Register("foxi", &Driver{})
+}
+
+// Driver is the database/sql driver for DBF directories.
+type Driver struct{}
+
+// Open returns a connection to the directory named by dsn.
+func (d *Driver) Open(dsn string) (driver.Conn, error) {
+	info, err := os.Stat(dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", dsn, err)
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("%s is not a directory", dsn)
+	}
+	return &conn{dir: dsn}, nil
+}
+
+// =========================================================================
+// CONNECTION
+// =========================================================================
+
+// conn is a connection to a directory of tables
+type conn struct {
+	dir    string
+	tx     *tx // Open transaction, if any
+	closed bool
+}
+
+var (
+	_ driver.Conn               = (*conn)(nil)
+	_ driver.ConnBeginTx        = (*conn)(nil)
+	_ driver.ConnPrepareContext = (*conn)(nil)
+	_ driver.ExecerContext      = (*conn)(nil)
+	_ driver.QueryerContext     = (*conn)(nil)
+)
+
+// Prepare parses a statement.
+func (c *conn) Prepare(query string) (driver.Stmt, error) {
+	return c.PrepareContext(context.Background(), query)
+}
+
+// PrepareContext parses a statement.
+func (c *conn) PrepareContext(_ context.Context, query string) (driver.Stmt, error) {
+	if c.closed {
+		return nil, driver.ErrBadConn
+	}
+	parsed, numParams, err := sqlparse.Parse(query)
+	if err != nil {
+		return nil, err
+	}
+	return &stmt{conn: c, text: query, parsed: parsed, numParams: numParams}, nil
+}
+
+// Close closes the connection, rolling back an open transaction.
+func (c *conn) Close() error {
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+	if c.tx != nil {
+		return c.tx.Rollback()
+	}
+	return nil
+}
+
+// Begin starts a transaction.
+func (c *conn) Begin() (driver.Tx, error) {
+	return c.BeginTx(context.Background(), driver.TxOptions{})
+}
+
+// BeginTx starts a transaction. Only the default isolation level is
+// supported.
+func (c *conn) BeginTx(_ context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	if c.closed {
+		return nil, driver.ErrBadConn
+	}
+	if c.tx != nil {
+		return nil, fmt.Errorf("transaction already active")
+	}
+	if sql.IsolationLevel(opts.Isolation) != sql.LevelDefault {
+		return nil, fmt.Errorf("unsupported isolation level %v", sql.IsolationLevel(opts.Isolation))
+	}
+	c.tx = &tx{conn: c, session: foxi.NewSession(), tables: map[string]*foxi.Foxi{}}
+	return c.tx, nil
+}
+
+// ExecContext runs an INSERT, UPDATE, DELETE or SELECT ... INTO TABLE
+// statement without preparing it first.
+func (c *conn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	s, err := c.PrepareContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	return s.(*stmt).ExecContext(ctx, args)
+}
+
+// QueryContext runs a SELECT statement without preparing it first.
+func (c *conn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	s, err := c.PrepareContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	return s.(*stmt).QueryContext(ctx, args)
+}
+
+// table returns the named table, opened for the current statement or
+// taken from the open transaction. release must be called when the
+// statement is done with the table.
+func (c *conn) table(name string) (f *foxi.Foxi, release func() error, err error) {
+	if c.tx != nil {
+		f, err := c.tx.table(name)
+		return f, func() error { return nil }, err
+	}
+	f, err = c.open(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	return f, f.Close, nil
+}
+
+// open opens the table stored in the directory's .dbf file of that name
+func (c *conn) open(name string) (*foxi.Foxi, error) {
+	path, err := c.path(name)
+	if err != nil {
+		return nil, err
+	}
+	f := foxi.NewFoxi()
+	if err := f.Open(path); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// path returns the path of the directory's .dbf file named after a table
+func (c *conn) path(name string) (string, error) {
+	entries, err := os.ReadDir(c.dir)
+	if err != nil {
+		return "", fmt.Errorf("failed to read %s: %w", c.dir, err)
+	}
+	for _, entry := range entries {
+		fileName := entry.Name()
+		if entry.IsDir() || !strings.EqualFold(filepath.Ext(fileName), ".dbf") {
+			continue
+		}
+		if strings.EqualFold(strings.TrimSuffix(fileName, filepath.Ext(fileName)), name) {
+			return filepath.Join(c.dir, fileName), nil
+		}
+	}
+	return "", fmt.Errorf("no such table: %s", name)
+}
+
+// atomic runs a statement's changes to a table in a Foxi transaction of
+// their own, unless the statement is part of a sql.Tx, committing them
+// if change succeeds and rolling them back otherwise
+func (c *conn) atomic(f *foxi.Foxi, change func() error) error {
+	if c.tx != nil {
+		return change()
+	}
+	ftx, err := f.Begin()
+	if err != nil {
+		return err
+	}
+	if err := change(); err != nil {
+		ftx.Rollback()
+		return err
+	}
+	if err := ftx.Commit(); err != nil {
+		ftx.Rollback()
+		return err
+	}
+	return nil
+}
+
+// =========================================================================
+// TRANSACTION
+// =========================================================================
+
+// tx is a database/sql transaction. Tables are opened on first use in the
+// transaction's session, and the session transaction, started with the
+// first table, covers every one of them until Commit or Rollback.
+type tx struct {
+	conn    *conn
+	session *foxi.Session
+	ftx     *foxi.Tx              // Session transaction, nil until a table is used
+	tables  map[string]*foxi.Foxi // By upper case table name
+}
+
+func (t *tx) table(name string) (*foxi.Foxi, error) {
+	key := strings.ToUpper(name)
+	if f, ok := t.tables[key]; ok {
+		return f, nil
+	}
+	path, err := t.conn.path(name)
+	if err != nil {
+		return nil, err
+	}
+	f, err := t.session.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	if t.ftx == nil {
+		if t.ftx, err = t.session.Begin(); err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+	t.tables[key] = f
+	return f, nil
+}
+
+// Commit commits the changes to every table used and closes them. If the
+// commit fails, the changes are rolled back.
+func (t *tx) Commit() error {
+	return t.finish((*foxi.Tx).Commit)
+}
+
+// Rollback rolls back the changes to every table used and closes them.
+func (t *tx) Rollback() error {
+	return t.finish((*foxi.Tx).Rollback)
+}
+
+// finish ends the session transaction and closes the session, which rolls
+// back a transaction that failed to commit
+func (t *tx) finish(end func(*foxi.Tx) error) error {
+	if t.conn.tx != t {
+		return sql.ErrTxDone
+	}
+	t.conn.tx = nil
+
+	var err error
+	if t.ftx != nil {
+		err = end(t.ftx)
+	}
+	if closeErr := t.session.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// =========================================================================
+// STATEMENT
+// =========================================================================
+
+// stmt is a parsed statement bound to a connection
+type stmt struct {
+	conn      *conn
+	text      string
+	parsed    sqlparse.Statement
+	numParams int
+}
+
+var (
+	_ driver.Stmt             = (*stmt)(nil)
+	_ driver.StmtExecContext  = (*stmt)(nil)
+	_ driver.StmtQueryContext = (*stmt)(nil)
+)
+
+// Close releases the statement. Statements hold no resources.
+func (s *stmt) Close() error {
+	return nil
+}
+
+// NumInput returns the number of ? placeholders.
+func (s *stmt) NumInput() int {
+	return s.numParams
+}
+
+// Exec runs an INSERT, UPDATE, DELETE or SELECT ... INTO TABLE statement.
+func (s *stmt) Exec(args []driver.Value) (driver.Result, error) {
+	return s.ExecContext(context.Background(), namedValues(args))
+}
+
+// Query runs a SELECT statement.
+func (s *stmt) Query(args []driver.Value) (driver.Rows, error) {
+	return s.QueryContext(context.Background(), namedValues(args))
+}
+
+// ExecContext runs an INSERT, UPDATE, DELETE or SELECT ... INTO TABLE
+// statement.
+func (s *stmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	values, err := s.bind(ctx, args)
+	if err != nil {
+		return nil, err
+	}
+	if parsed, ok := s.parsed.(*sqlparse.Select); ok {
+		return s.conn.selectInto(s.text, parsed, values)
+	}
+	params, err := sqlparse.Literals(values)
+	if err != nil {
+		return nil, err
+	}
+	switch parsed := s.parsed.(type) {
+	case *sqlparse.Insert:
+		return s.conn.insert(parsed, params)
+	case *sqlparse.Update:
+		return s.conn.update(parsed, params)
+	case *sqlparse.Delete:
+		return s.conn.delete(parsed, params)
+	}
+	return nil, fmt.Errorf("unsupported statement %T", s.parsed)
+}
+
+// QueryContext runs a SELECT statement.
+func (s *stmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	values, err := s.bind(ctx, args)
+	if err != nil {
+		return nil, err
+	}
+	parsed, ok := s.parsed.(*sqlparse.Select)
+	if !ok {
+		return nil, fmt.Errorf("use Exec for INSERT, UPDATE and DELETE statements")
+	}
+	return s.conn.query(s.text, parsed, values)
+}
+
+// bind checks the arguments and orders them by placeholder position
+func (s *stmt) bind(ctx context.Context, args []driver.NamedValue) ([]any, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if s.conn.closed {
+		return nil, driver.ErrBadConn
+	}
+	if len(args) != s.numParams {
+		return nil, fmt.Errorf("expected %d arguments, got %d", s.numParams, len(args))
+	}
+	values := make([]any, len(args))
+	for _, arg := range args {
+		if arg.Name != "" {
+			return nil, fmt.Errorf("named argument %s is not supported", arg.Name)
+		}
+		values[arg.Ordinal-1] = arg.Value
+	}
+	return values, nil
+}
+
+func namedValues(args []driver.Value) []driver.NamedValue {
+	named := make([]driver.NamedValue, len(args))
+	for i, arg := range args {
+		named[i] = driver.NamedValue{Ordinal: i + 1, Value: arg}
+	}
+	return named
+}
+
+// =========================================================================
+// RESULTS
+// =========================================================================
+
+// result reports the outcome of an INSERT, UPDATE or DELETE
+type result struct {
+	lastInsertID int64 // Record number of the last appended record
+	rowsAffected int64
+}
+
+// LastInsertId returns the record number of the last record inserted.
+func (r result) LastInsertId() (int64, error) {
+	return r.lastInsertID, nil
+}
+
+// RowsAffected returns the number of records inserted, changed or deleted.
+func (r result) RowsAffected() (int64, error) {
+	return r.rowsAffected, nil
+}
+
+// rows is the materialized result of a SELECT
+type rows struct {
+	columns   []string
+	typeNames []string // Type letters of the columns
+	data      [][]driver.Value
+	pos       int
+}
+
+var _ driver.RowsColumnTypeDatabaseTypeName = (*rows)(nil)
+
+// Columns returns the names of the result columns.
+func (r *rows) Columns() []string {
+	return r.columns
+}
+
+// ColumnTypeDatabaseTypeName returns the type letter of a column as
+// package expr names it: "C", "N", "I", "D", "T", "L", or "X" for a
+// column that is always NULL.
+func (r *rows) ColumnTypeDatabaseTypeName(index int) string {
+	return r.typeNames[index]
+}
+
+// Close releases the result rows.
+func (r *rows) Close() error {
+	r.data = nil
+	return nil
+}
+
+// Next copies the next row into dest.
+func (r *rows) Next(dest []driver.Value) error {
+	if r.pos >= len(r.data) {
+		return io.EOF
+	}
+	copy(dest, r.data[r.pos])
+	r.pos++
+	return nil
+}
@@ -0,0 +1,176 @@
+package sqldriver
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mkfoss/foxi"
+	"github.com/mkfoss/foxi/expr"
+	"github.com/mkfoss/foxi/internal/hook"
+	"github.com/mkfoss/foxi/internal/sqlparse"
+)
+
+// compile writes an expression of a statement on a table in xBase syntax,
+// with the placeholder values given as xBase constants, and compiles it
+// against the table with package expr. Columns may be qualified with the
+// name of the table.
+func compile(f *foxi.Foxi, table string, n sqlparse.Node, params []string) (*expr.Program, error) {
+	var err error
+	sqlparse.Walk(n, func(n sqlparse.Node) bool {
+		if c, ok := n.(*sqlparse.Column); ok && c.Alias != "" && !strings.EqualFold(c.Alias, table) {
+			err = fmt.Errorf("unknown table alias %s", c.Alias)
+		}
+		return err == nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	w := sqlparse.Writer{
+		Params: params,
+		Column: func(c *sqlparse.Column) string { return strings.ToUpper(c.Name) },
+	}
+	return foxi.Compile(f, w.Write(n))
+}
+
+// firstColumn returns the first column an expression reads, nil when it
+// reads none
+func firstColumn(n sqlparse.Node) *sqlparse.Column {
+	var column *sqlparse.Column
+	sqlparse.Walk(n, func(n sqlparse.Node) bool {
+		if c, ok := n.(*sqlparse.Column); ok {
+			column = c
+		}
+		return column == nil
+	})
+	return column
+}
+
+// evalField evaluates a value for a field and converts it with fieldValue.
+// Character values lose the blanks that pad them, except for memo fields,
+// which store them.
+func evalField(field foxi.Field, prog *expr.Program) (any, error) {
+	value, err := prog.Eval()
+	if err != nil {
+		return nil, err
+	}
+	if s, ok := value.(string); ok && field.Type() != foxi.FTMemo {
+		value = strings.TrimRight(s, " ")
+	}
+	return fieldValue(field, value)
+}
+
+// fieldValue converts a value of package expr to the form assignValue
+// stores in a field, checking that it fits: numbers become a foxi.Decimal
+// that does not overflow the field, dates given as text become time.Time.
+// Converting every value of a row first means a bad value is reported
+// before the record is touched.
+func fieldValue(field foxi.Field, value any) (any, error) {
+	if value == nil {
+		if !field.IsNullable() {
+			return nil, fmt.Errorf("column %s does not accept NULL", field.Name())
+		}
+		return nil, nil
+	}
+
+	mismatch := func() error {
+		return fmt.Errorf("cannot assign %v to %s column %s", value, field.Type().Name(), field.Name())
+	}
+	switch field.Type() {
+	case foxi.FTNumeric, foxi.FTFloat, foxi.FTInteger, foxi.FTCurrency, foxi.FTDouble, foxi.FTBlob:
+		d, err := toDecimal(value)
+		if err != nil {
+			return nil, fmt.Errorf("column %s: %w", field.Name(), err)
+		}
+		if d == nil {
+			return nil, mismatch()
+		}
+		if err := hook.CheckDecimal(field, *d); err != nil {
+			return nil, err
+		}
+		return *d, nil
+	case foxi.FTLogical:
+		if _, ok := value.(bool); !ok {
+			return nil, mismatch()
+		}
+		return value, nil
+	case foxi.FTDate, foxi.FTDateTime:
+		switch v := value.(type) {
+		case time.Time:
+			return v, nil
+		case string:
+			t, err := parseTime(v)
+			if err != nil {
+				return nil, fmt.Errorf("column %s: %w", field.Name(), err)
+			}
+			return t, nil
+		}
+		return nil, mismatch()
+	}
+
+	switch v := value.(type) {
+	case string:
+		return v, nil
+	case []byte:
+		return string(v), nil
+	}
+	return nil, mismatch()
+}
+
+// toDecimal converts a number, or text holding one, to a decimal. It
+// returns nil for values that are not numbers.
+func toDecimal(value any) (*foxi.Decimal, error) {
+	var d foxi.Decimal
+	switch v := value.(type) {
+	case int64:
+		d = foxi.NewDecimal(v, 0)
+	case float64:
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return nil, fmt.Errorf("%v is not a number", v)
+		}
+		d = foxi.MustParseDecimal(strconv.FormatFloat(v, 'f', -1, 64))
+	case string:
+		text := strings.TrimSpace(v)
+		var err error
+		if d, err = foxi.ParseDecimal(text); err != nil {
+			x, floatErr := strconv.ParseFloat(text, 64)
+			if floatErr != nil {
+				return nil, fmt.Errorf("invalid number %q", v)
+			}
+			return toDecimal(x)
+		}
+	default:
+		return nil, nil
+	}
+	return &d, nil
+}
+
+// assignValue stores a value converted by fieldValue in a field of the
+// record buffer
+func assignValue(field foxi.Field, value any) error {
+	switch v := value.(type) {
+	case nil:
+		return field.SetNull()
+	case foxi.Decimal:
+		return field.SetDecimal(v)
+	case bool:
+		return field.SetBool(v)
+	case time.Time:
+		return field.SetTime(v)
+	case string:
+		return field.SetString(v)
+	}
+	return fmt.Errorf("unsupported value %T for column %s", value, field.Name())
+}
+
+// parseTime reads a date or datetime given as text
+func parseTime(s string) (time.Time, error) {
+	for _, layout := range []string{"2006-01-02", "2006-01-02 15:04:05", time.RFC3339, "20060102"} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid date %q", s)
+}
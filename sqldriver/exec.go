@@ -0,0 +1,374 @@
+package sqldriver
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/mkfoss/foxi"
+	"github.com/mkfoss/foxi/expr"
+	"github.com/mkfoss/foxi/internal/sqlparse"
+	foxisql "github.com/mkfoss/foxi/sql"
+)
+
+// =========================================================================
+// SELECT
+// =========================================================================
+
+// query runs a SELECT with package sql, reading every row into memory
+func (c *conn) query(text string, s *sqlparse.Select, args []any) (_ *rows, err error) {
+	db, err := c.database(s)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if closeErr := db.Close(); err == nil {
+			err = closeErr
+		}
+	}()
+
+	found, err := db.Query(text, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer found.Close()
+
+	columns := found.Columns()
+	result := &rows{}
+	for _, column := range columns {
+		result.columns = append(result.columns, column.Name)
+		result.typeNames = append(result.typeNames, column.Type.String())
+	}
+	for found.Next() {
+		values := found.Values()
+		row := make([]driver.Value, len(values))
+		for i, value := range values {
+			row[i] = driverValue(columns[i], value)
+		}
+		result.data = append(result.data, row)
+	}
+	if err := found.Err(); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// selectInto runs a SELECT ... INTO TABLE with package sql
+func (c *conn) selectInto(text string, s *sqlparse.Select, args []any) (_ driver.Result, err error) {
+	if s.Into == "" {
+		return nil, fmt.Errorf("use Query for SELECT statements")
+	}
+	db, err := c.database(s)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if closeErr := db.Close(); err == nil {
+			err = closeErr
+		}
+	}()
+
+	n, err := db.Exec(text, args...)
+	if err != nil {
+		return nil, err
+	}
+	return result{rowsAffected: int64(n)}, nil
+}
+
+// database returns a sql.DB over the directory. Inside a transaction the
+// tables the SELECT reads are those of the transaction, so that it sees
+// the changes made in it.
+func (c *conn) database(s *sqlparse.Select) (*foxisql.DB, error) {
+	db := foxisql.NewDB(c.dir)
+	if c.tx == nil {
+		return db, nil
+	}
+	added := map[string]bool{}
+	for _, item := range s.From {
+		key := strings.ToUpper(item.Table)
+		if added[key] {
+			continue
+		}
+		f, err := c.tx.table(item.Table)
+		if err == nil {
+			err = db.Add(item.Table, f)
+		}
+		if err != nil {
+			db.Close()
+			return nil, err
+		}
+		added[key] = true
+	}
+	return db, nil
+}
+
+// driverValue converts a value of a result row to the form database/sql
+// expects: character values lose the blanks that pad them, numbers of
+// columns without decimals become int64 and other numbers float64, and
+// blank dates are NULL
+func driverValue(column foxisql.Column, value any) driver.Value {
+	switch v := value.(type) {
+	case string:
+		return strings.TrimRight(v, " ")
+	case int:
+		return int64(v)
+	case foxi.Decimal:
+		if n := v.Unscaled(); v.Scale() == 0 && n.IsInt64() {
+			return n.Int64()
+		}
+		f, _ := v.Rat().Float64()
+		return f
+	case float64:
+		if column.Dec == 0 && v == math.Trunc(v) && math.Abs(v) < 1<<53 {
+			return int64(v)
+		}
+	case time.Time:
+		if v.IsZero() {
+			return nil
+		}
+	}
+	return value
+}
+
+// =========================================================================
+// INSERT, UPDATE AND DELETE
+// =========================================================================
+
+// insert appends one record per VALUES row
+func (c *conn) insert(s *sqlparse.Insert, params []string) (_ driver.Result, err error) {
+	f, release, err := c.table(s.Table)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if closeErr := release(); err == nil {
+			err = closeErr
+		}
+	}()
+
+	var fields []foxi.Field
+	if len(s.Columns) == 0 {
+		fields = userFields(f)
+	} else {
+		for _, name := range s.Columns {
+			field := f.FieldByName(name)
+			if field == nil {
+				return nil, fmt.Errorf("no such column: %s", name)
+			}
+			fields = append(fields, field)
+		}
+	}
+
+	// Every value is converted and checked before the first record is added
+	rows := make([][]any, len(s.Rows))
+	for r, row := range s.Rows {
+		if len(row) != len(fields) {
+			return nil, fmt.Errorf("expected %d values, got %d", len(fields), len(row))
+		}
+		rows[r] = make([]any, len(row))
+		for i, n := range row {
+			if column := firstColumn(n); column != nil {
+				return nil, fmt.Errorf("column %s is not allowed in VALUES", column.Name)
+			}
+			prog, err := compile(f, s.Table, n, params)
+			if err != nil {
+				return nil, err
+			}
+			if rows[r][i], err = evalField(fields[i], prog); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	var res result
+	err = c.atomic(f, func() error {
+		for _, values := range rows {
+			if err := f.Append(); err != nil {
+				return err
+			}
+			for i, field := range fields {
+				if err := assignValue(field, values[i]); err != nil {
+					return err
+				}
+			}
+			if err := f.Write(); err != nil {
+				return err
+			}
+			res.lastInsertID = int64(f.Position())
+			res.rowsAffected++
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// update changes the matching records
+func (c *conn) update(s *sqlparse.Update, params []string) (_ driver.Result, err error) {
+	f, release, err := c.table(s.Table)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if closeErr := release(); err == nil {
+			err = closeErr
+		}
+	}()
+
+	fields := make([]foxi.Field, len(s.Set))
+	progs := make([]*expr.Program, len(s.Set))
+	for i, set := range s.Set {
+		if fields[i] = f.FieldByName(set.Column); fields[i] == nil {
+			return nil, fmt.Errorf("no such column: %s", set.Column)
+		}
+		if progs[i], err = compile(f, s.Table, set.Value, params); err != nil {
+			return nil, err
+		}
+	}
+
+	recNos, err := matching(f, s.Table, s.Where, params)
+	if err != nil {
+		return nil, err
+	}
+
+	var res result
+	values := make([]any, len(s.Set))
+	err = c.atomic(f, func() error {
+		for _, recNo := range recNos {
+			if err := f.Goto(recNo); err != nil {
+				return err
+			}
+			// Every value sees the record as it was before the update, and
+			// is checked before the first field changes
+			for i, prog := range progs {
+				var err error
+				if values[i], err = evalField(fields[i], prog); err != nil {
+					return err
+				}
+			}
+			for i, field := range fields {
+				if err := assignValue(field, values[i]); err != nil {
+					return err
+				}
+			}
+			if err := f.Write(); err != nil {
+				return err
+			}
+			res.rowsAffected++
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// delete marks the matching records for deletion
+func (c *conn) delete(s *sqlparse.Delete, params []string) (_ driver.Result, err error) {
+	f, release, err := c.table(s.Table)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if closeErr := release(); err == nil {
+			err = closeErr
+		}
+	}()
+
+	recNos, err := matching(f, s.Table, s.Where, params)
+	if err != nil {
+		return nil, err
+	}
+
+	var res result
+	err = c.atomic(f, func() error {
+		for _, recNo := range recNos {
+			if err := f.Goto(recNo); err != nil {
+				return err
+			}
+			if err := f.Delete(); err != nil {
+				return err
+			}
+			if err := f.Write(); err != nil {
+				return err
+			}
+			res.rowsAffected++
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// userFields returns the fields of a table in record order, leaving out
+// system fields such as _NullFlags
+func userFields(f *foxi.Foxi) []foxi.Field {
+	var fields []foxi.Field
+	for i := 0; i < f.FieldCount(); i++ {
+		if field := f.Fields().ByIndex(i); !field.IsSystem() {
+			fields = append(fields, field)
+		}
+	}
+	return fields
+}
+
+// =========================================================================
+// RECORD SELECTION
+// =========================================================================
+
+// matching returns the numbers of the records that are not deleted and
+// satisfy where, in record order. where becomes the filter of the table
+// while it is read, so that Rushmore answers it from the table's tags
+// where it can; the filter and selected tag are put back afterwards.
+func matching(f *foxi.Foxi, table string, where sqlparse.Node, params []string) (recNos []int, err error) {
+	filter := ""
+	if where != nil {
+		prog, err := compile(f, table, where, params)
+		if err != nil {
+			return nil, err
+		}
+		switch prog.Type() {
+		case expr.Null:
+			// NULL matches no record
+			return nil, nil
+		case expr.Logical:
+			filter = prog.Source()
+		default:
+			return nil, fmt.Errorf("WHERE needs a logical expression, not %s", prog.Source())
+		}
+	}
+
+	savedFilter, savedTag := f.Filter(), f.Indexes().SelectedTag()
+	defer func() {
+		restoreErr := f.SetFilter(savedFilter)
+		if tagErr := f.Indexes().SelectTag(savedTag); restoreErr == nil {
+			restoreErr = tagErr
+		}
+		if err == nil {
+			err = restoreErr
+		}
+	}()
+	if err := f.SetFilter(filter); err != nil {
+		return nil, err
+	}
+	if err := f.Indexes().SelectTag(nil); err != nil {
+		return nil, err
+	}
+
+	for err := f.First(); !f.EOF(); err = f.Next() {
+		if err != nil {
+			return nil, err
+		}
+		if !f.Deleted() {
+			recNos = append(recNos, f.Position())
+		}
+	}
+	return recNos, nil
+}
@@ -0,0 +1,231 @@
+package tests
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/mkfoss/foxi"
+)
+
+// createCodepageTable creates a table marked with a code page, with a
+// character field tagged by NAME and a memo field
+func createCodepageTable(t *testing.T, cp foxi.Codepage, size uint8) (*foxi.Foxi, string) {
+	t.Helper()
+	return createTable(t, "codepage", foxi.Schema{
+		Fields: []foxi.FieldSpec{
+			{Name: "NAME", Type: foxi.FTCharacter, Size: size},
+			{Name: "NOTES", Type: foxi.FTMemo},
+		},
+		Tags: []foxi.TagSpec{{Name: "name", Expression: "NAME"}},
+	}, &foxi.CreateOptions{Codepage: cp})
+}
+
+func TestCodepageRoundTrip(t *testing.T) {
+	tests := []struct {
+		cp    foxi.Codepage
+		names []string
+	}{
+		{foxi.Codepage1251, []string{"Привет", "Ёжик", "Апельсин"}},
+		{foxi.Codepage1250, []string{"Žluťoučký", "kůň", "Łódź"}},
+		{foxi.Codepage1253, []string{"Καλημέρα", "Αθήνα"}},
+		{foxi.Codepage866, []string{"Москва", "Ёлка"}},
+		{foxi.Codepage850, []string{"Ærøskøbing", "Müller"}},
+		{foxi.Codepage932, []string{"日本語", "ｶﾀｶﾅ", "東京"}},
+		{foxi.Codepage936, []string{"中文", "北京"}},
+		{foxi.Codepage949, []string{"한국어", "서울"}},
+		{foxi.Codepage950, []string{"臺北", "繁體"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.cp.String(), func(t *testing.T) {
+			f, path := createCodepageTable(t, tt.cp, 20)
+			for _, name := range tt.names {
+				f.MustAppend()
+				f.FieldByName("name").MustSetString(name)
+				f.FieldByName("notes").MustSetString("memo " + name)
+				f.MustWrite()
+			}
+			f.Close()
+
+			f = foxi.NewFoxi()
+			f.MustOpen(path)
+			defer f.Close()
+			header := f.Header()
+			if got := header.Codepage(); got != tt.cp {
+				t.Errorf("header codepage = %v, want %v", got, tt.cp)
+			}
+			if got := f.Codepage(); got != tt.cp {
+				t.Errorf("Codepage = %v, want %v", got, tt.cp)
+			}
+
+			for i, name := range tt.names {
+				f.MustGoto(i + 1)
+				if got := strings.TrimRight(f.FieldByName("name").MustAsString(), " "); got != name {
+					t.Errorf("record %d NAME = %q, want %q", i+1, got, name)
+				}
+				if got := f.FieldByName("notes").MustAsString(); got != "memo "+name {
+					t.Errorf("record %d NOTES = %q, want %q", i+1, got, "memo "+name)
+				}
+			}
+
+			// Seeks encode the value to match the stored keys
+			tag := f.Indexes().TagByName("name")
+			for i, name := range tt.names {
+				result, err := tag.SeekString(name)
+				if err != nil || result != foxi.SeekSuccess || f.Position() != i+1 {
+					t.Errorf("SeekString(%q) = %v, %v on record %d, want record %d", name, result, err, f.Position(), i+1)
+				}
+			}
+			for rec, err := range tag.Prefix(tt.names[0]) {
+				if err != nil {
+					t.Fatalf("Prefix failed: %v", err)
+				}
+				if rec.Number() != 1 {
+					t.Errorf("Prefix(%q) visited record %d, want 1", tt.names[0], rec.Number())
+				}
+			}
+		})
+	}
+}
+
+func TestCodepageStoredBytes(t *testing.T) {
+	f, _ := createCodepageTable(t, foxi.Codepage1251, 10)
+	defer f.Close()
+
+	f.MustAppend()
+	f.FieldByName("name").MustSetString("Привет")
+	f.MustWrite()
+
+	// Without translation the bytes are read as they are stored
+	f.MustSetCodepage(foxi.CodepageNone)
+	if got, want := f.FieldByName("name").MustAsString(), "\xcf\xf0\xe8\xe2\xe5\xf2    "; got != want {
+		t.Errorf("raw NAME = %q, want %q", got, want)
+	}
+	header := f.Header()
+	if got := header.Codepage(); got != foxi.Codepage1251 {
+		t.Errorf("header codepage = %v after override, want %v", got, foxi.Codepage1251)
+	}
+}
+
+func TestCodepageOverride(t *testing.T) {
+	// A table written in code page 1251 but marked 1252
+	f, path := createCodepageTable(t, foxi.Codepage1252, 10)
+	f.MustSetCodepage(foxi.Codepage1251)
+	f.MustAppend()
+	f.FieldByName("name").MustSetString("Привет")
+	f.MustWrite()
+	f.Close()
+
+	f = foxi.NewFoxi()
+	f.MustOpen(path)
+	defer f.Close()
+	f.MustGoto(1)
+	if got := strings.TrimSpace(f.FieldByName("name").MustAsString()); got != "Ïðèâåò" {
+		t.Errorf("NAME read as 1252 = %q, want %q", got, "Ïðèâåò")
+	}
+
+	// The override applies to the open table and to later opens
+	f.MustSetCodepage(foxi.Codepage1251)
+	if got := strings.TrimSpace(f.FieldByName("name").MustAsString()); got != "Привет" {
+		t.Errorf("NAME read as 1251 = %q, want %q", got, "Привет")
+	}
+	f.Close()
+	f.MustOpen(path)
+	if got := f.Codepage(); got != foxi.Codepage1251 {
+		t.Errorf("Codepage after reopening = %v, want %v", got, foxi.Codepage1251)
+	}
+}
+
+func TestCodepageErrors(t *testing.T) {
+	f, _ := createCodepageTable(t, foxi.Codepage1252, 5)
+	defer f.Close()
+	f.MustAppend()
+
+	if err := f.FieldByName("name").SetString("Привет"); err == nil {
+		t.Error("expected error for characters missing from code page 1252")
+	}
+	if _, err := f.Indexes().TagByName("name").SeekString("日本"); err == nil {
+		t.Error("expected error seeking characters missing from code page 1252")
+	}
+	if err := f.SetCodepage(foxi.Codepage(0xEE)); err == nil {
+		t.Error("expected error for an unknown code page")
+	}
+
+	// Text longer than the field once encoded fails and leaves the field
+	// as it was
+	name := f.FieldByName("name")
+	if err := name.SetString("toolongname"); err == nil {
+		t.Error("expected error for a value longer than the field")
+	}
+	f.MustSetCodepage(foxi.Codepage932)
+	name.MustSetString("日本")
+	for _, value := range []string{"日本語", "привет"} {
+		if err := name.SetString(value); err == nil {
+			t.Errorf("expected error for %q, more bytes than the field holds in code page 932", value)
+		}
+	}
+	if got := name.MustAsString(); got != "日本 " {
+		t.Errorf("NAME = %q, want %q", got, "日本 ")
+	}
+}
+
+func TestCodepageString(t *testing.T) {
+	tests := []struct {
+		cp     foxi.Codepage
+		number int
+		want   string
+	}{
+		{foxi.Codepage1252, 1252, "Windows ANSI (1252)"},
+		{foxi.Codepage437, 437, "U.S. MS-DOS (437)"},
+		{foxi.Codepage932, 932, "Japanese Windows (932)"},
+		{foxi.CodepageNone, 0, "No Codepage"},
+		{foxi.Codepage(0xEE), 0, "Unknown Codepage"},
+	}
+	for _, tt := range tests {
+		if got := tt.cp.String(); got != tt.want {
+			t.Errorf("String(0x%02X) = %q, want %q", byte(tt.cp), got, tt.want)
+		}
+		if got := tt.cp.Number(); got != tt.number {
+			t.Errorf("Number(0x%02X) = %d, want %d", byte(tt.cp), got, tt.number)
+		}
+	}
+}
+
+func TestCodepageUpperTag(t *testing.T) {
+	tests := []struct {
+		cp    foxi.Codepage
+		names []string
+		seek  string
+	}{
+		{foxi.Codepage1251, []string{"груша", "яблоко", "апельсин"}, "ЯБЛ"},
+		{foxi.Codepage866, []string{"мир", "привет", "ёлка"}, "ПРИ"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.cp.String(), func(t *testing.T) {
+			f, _ := createCodepageTable(t, tt.cp, 20)
+			defer f.Close()
+			for _, name := range tt.names {
+				f.MustAppend()
+				f.FieldByName("name").MustSetString(name)
+				f.MustWrite()
+			}
+			f.Indexes().MustCreateTag("upper", "UPPER(NAME)", "", false, false)
+
+			check := func(when string) {
+				t.Helper()
+				tag := f.Indexes().TagByName("upper")
+				if result, err := tag.SeekString(tt.seek); err != nil || result != foxi.SeekSuccess || f.Position() != 2 {
+					t.Errorf("%s: SeekString(%q) = %v, %v on record %d, want record 2", when, tt.seek, result, err, f.Position())
+				}
+				// The stored key is the one Compile builds
+				want, err := foxi.MustCompile(f, "UPPER(NAME)").Key()
+				if got := tag.CurrentKey(); err != nil || got != string(want) {
+					t.Errorf("%s: key %q, want %q", when, got, want)
+				}
+			}
+			check("after CreateTag")
+			f.Indexes().MustReindex()
+			check("after Reindex")
+		})
+	}
+}
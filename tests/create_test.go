@@ -0,0 +1,492 @@
+package tests
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mkfoss/foxi"
+	"github.com/mkfoss/foxi/expr"
+	pkg "github.com/mkfoss/foxi/pkg/gocore"
+)
+
+func createTestSchema() foxi.Schema {
+	return foxi.Schema{
+		Fields: []foxi.FieldSpec{
+			{Name: "NAME", Type: foxi.FTCharacter, Size: 20},
+			{Name: "CODE", Type: foxi.FTCharacter, Size: 6, Binary: true},
+			{Name: "AMOUNT", Type: foxi.FTNumeric, Size: 10, Decimals: 2},
+			{Name: "WEIGHT", Type: foxi.FTFloat, Size: 12, Decimals: 3},
+			{Name: "BORN", Type: foxi.FTDate},
+			{Name: "STAMP", Type: foxi.FTDateTime},
+			{Name: "ACTIVE", Type: foxi.FTLogical},
+			{Name: "QTY", Type: foxi.FTInteger},
+			{Name: "PRICE", Type: foxi.FTCurrency},
+			{Name: "RATIO", Type: foxi.FTDouble, Decimals: 2},
+			{Name: "NOTES", Type: foxi.FTMemo},
+			{Name: "PICTURE", Type: foxi.FTGeneral},
+			{Name: "RAW", Type: foxi.FTVarBinary, Size: 16},
+			{Name: "TITLE", Type: foxi.FTVarchar, Size: 30},
+			{Name: "NICK", Type: foxi.FTCharacter, Size: 10, Nullable: true},
+		},
+	}
+}
+
+func TestCreateAllFieldTypes(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "all.dbf")
+
+	f, err := foxi.Create(path, createTestSchema(), nil)
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	defer f.Close()
+
+	if !f.Active() {
+		t.Fatal("created table should be open")
+	}
+
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read table: %v", err)
+	}
+	if raw[0] != 0x32 {
+		t.Errorf("version byte = 0x%02X, want 0x32", raw[0])
+	}
+	if raw[28]&0x02 == 0 {
+		t.Error("memo flag not set in table header")
+	}
+	if raw[29] != 0x03 {
+		t.Errorf("codepage mark = 0x%02X, want 0x03", raw[29])
+	}
+	if _, err := os.Stat(filepath.Join(filepath.Dir(path), "all.fpt")); err != nil {
+		t.Errorf("memo file not created: %v", err)
+	}
+
+	expected := []struct {
+		name     string
+		typ      foxi.FieldType
+		size     uint8
+		decimals uint8
+		nullable bool
+		binary   bool
+	}{
+		{"NAME", foxi.FTCharacter, 20, 0, false, false},
+		{"CODE", foxi.FTCharacter, 6, 0, false, true},
+		{"AMOUNT", foxi.FTNumeric, 10, 2, false, false},
+		{"WEIGHT", foxi.FTFloat, 12, 3, false, false},
+		{"BORN", foxi.FTDate, 8, 0, false, false},
+		{"STAMP", foxi.FTDateTime, 8, 0, false, false},
+		{"ACTIVE", foxi.FTLogical, 1, 0, false, false},
+		{"QTY", foxi.FTInteger, 4, 0, false, false},
+		{"PRICE", foxi.FTCurrency, 8, 4, false, false},
+		{"RATIO", foxi.FTBlob, 8, 2, false, false},
+		{"NOTES", foxi.FTMemo, 4, 0, false, false},
+		{"PICTURE", foxi.FTGeneral, 4, 0, false, true},
+		{"RAW", foxi.FTVarBinary, 16, 0, false, true},
+		{"TITLE", foxi.FTVarchar, 30, 0, false, false},
+		{"NICK", foxi.FTCharacter, 10, 0, true, false},
+	}
+	for _, want := range expected {
+		field := f.FieldByName(want.name)
+		if field == nil {
+			t.Errorf("field %s missing", want.name)
+			continue
+		}
+		if field.Type() != want.typ {
+			t.Errorf("%s: type %v, want %v", want.name, field.Type(), want.typ)
+		}
+		if field.Size() != want.size || field.Decimals() != want.decimals {
+			t.Errorf("%s: size %d,%d, want %d,%d", want.name, field.Size(), field.Decimals(), want.size, want.decimals)
+		}
+		if field.IsNullable() != want.nullable {
+			t.Errorf("%s: nullable %v, want %v", want.name, field.IsNullable(), want.nullable)
+		}
+		if field.IsBinary() != want.binary {
+			t.Errorf("%s: binary %v, want %v", want.name, field.IsBinary(), want.binary)
+		}
+	}
+	if f.FieldByName("_NullFlags") == nil {
+		t.Error("_NullFlags field missing")
+	}
+
+	stamp := time.Date(2024, time.February, 29, 8, 15, 0, 0, time.UTC)
+	f.MustAppend()
+	f.FieldByName("name").MustSetString("Widget")
+	f.FieldByName("amount").MustSetFloat(12.5)
+	f.FieldByName("stamp").MustSetTime(stamp)
+	f.FieldByName("qty").MustSetInt(3)
+	f.FieldByName("notes").MustSetString("created by foxi")
+	f.FieldByName("nick").MustSetNull()
+	f.MustWrite()
+	f.Close()
+
+	f = foxi.NewFoxi()
+	f.MustOpen(path)
+	f.MustGoto(1)
+	if got := strings.TrimSpace(f.FieldByName("name").MustAsString()); got != "Widget" {
+		t.Errorf("NAME = %q, want %q", got, "Widget")
+	}
+	if got := f.FieldByName("amount").MustAsFloat(); got != 12.5 {
+		t.Errorf("AMOUNT = %v, want 12.5", got)
+	}
+	if got := f.FieldByName("stamp").MustAsTime(); !got.Equal(stamp) {
+		t.Errorf("STAMP = %v, want %v", got, stamp)
+	}
+	if got := f.FieldByName("qty").MustAsInt(); got != 3 {
+		t.Errorf("QTY = %d, want 3", got)
+	}
+	if got := f.FieldByName("notes").MustAsString(); got != "created by foxi" {
+		t.Errorf("NOTES = %q, want %q", got, "created by foxi")
+	}
+	if !f.FieldByName("nick").MustIsNull() {
+		t.Error("NICK should be null")
+	}
+}
+
+func TestCreateWithTags(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tagged.dbf")
+	schema := foxi.Schema{
+		Fields: []foxi.FieldSpec{
+			{Name: "NAME", Type: foxi.FTCharacter, Size: 20},
+			{Name: "QTY", Type: foxi.FTInteger},
+			{Name: "BORN", Type: foxi.FTDate},
+		},
+		Tags: []foxi.TagSpec{
+			{Name: "name", Expression: "UPPER(NAME)"},
+			{Name: "qty", Expression: "QTY", Unique: true, Descending: true},
+			{Name: "born", Expression: "DTOS(BORN)", Filter: "QTY > 0"},
+		},
+	}
+
+	f, err := foxi.Create(path, schema, nil)
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	defer f.Close()
+
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read table: %v", err)
+	}
+	if raw[28]&0x01 == 0 {
+		t.Error("production index flag not set in table header")
+	}
+	if _, err := os.Stat(filepath.Join(filepath.Dir(path), "tagged.cdx")); err != nil {
+		t.Fatalf("production index not created: %v", err)
+	}
+
+	indexes := f.Indexes()
+	if err := indexes.Load(); err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if indexes.Count() != 1 {
+		t.Fatalf("expected 1 index, got %d", indexes.Count())
+	}
+	if name := indexes.ByIndex(0).Name(); name != "tagged" {
+		t.Errorf("index name = %q, want %q", name, "tagged")
+	}
+
+	expected := []struct {
+		name, expr, filter string
+		keyLen             int
+		unique, descending bool
+	}{
+		{"NAME", "UPPER(NAME)", "", 20, false, false},
+		{"QTY", "QTY", "", 4, true, true},
+		{"BORN", "DTOS(BORN)", "QTY > 0", 8, false, false},
+	}
+	tags := indexes.Tags()
+	if len(tags) != len(expected) {
+		t.Fatalf("expected %d tags, got %d", len(expected), len(tags))
+	}
+	for _, want := range expected {
+		tag := indexes.TagByName(want.name)
+		if tag == nil {
+			t.Errorf("tag %s missing", want.name)
+			continue
+		}
+		if tag.Expression() != want.expr || tag.Filter() != want.filter {
+			t.Errorf("%s: expression %q filter %q, want %q %q", want.name, tag.Expression(), tag.Filter(), want.expr, want.filter)
+		}
+		if tag.KeyLength() != want.keyLen {
+			t.Errorf("%s: key length %d, want %d", want.name, tag.KeyLength(), want.keyLen)
+		}
+		if tag.IsUnique() != want.unique || tag.IsDescending() != want.descending {
+			t.Errorf("%s: unique %v descending %v, want %v %v", want.name, tag.IsUnique(), tag.IsDescending(), want.unique, want.descending)
+		}
+	}
+}
+
+func TestCreateIndexOrder(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "order.dbf")
+
+	f, err := foxi.Create(path, foxi.Schema{
+		Fields: []foxi.FieldSpec{
+			{Name: "NAME", Type: foxi.FTCharacter, Size: 12},
+			{Name: "QTY", Type: foxi.FTInteger},
+		},
+	}, nil)
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	names := []string{"pear", "Apple", "fig", "banana", "cherry"}
+	for i, name := range names {
+		f.MustAppend()
+		f.FieldByName("name").MustSetString(name)
+		f.FieldByName("qty").MustSetInt((i - 2) * 10)
+		f.MustWrite()
+	}
+	f.Close()
+
+	// Build the production index over the existing records
+	codeBase := &pkg.Code4{}
+	pkg.Code4Init(codeBase)
+	data := pkg.D4Open(codeBase, path)
+	if data == nil {
+		t.Fatal("failed to open table")
+	}
+	index := pkg.I4Create(data, "", []pkg.Tag4Info{
+		{Name: "NAME", Expression: "UPPER(NAME)"},
+		{Name: "QTY", Expression: "QTY", Descending: 1},
+		{Name: "POSITIVE", Expression: "QTY", Filter: "QTY > 0"},
+	})
+	if index == nil {
+		t.Fatalf("I4Create failed with error %d", codeBase.ErrorCode)
+	}
+	pkg.Code4InitUndo(codeBase)
+
+	f = foxi.NewFoxi()
+	f.MustOpen(path)
+	defer f.Close()
+	indexes := f.Indexes()
+
+	tagOrder := func(tagName string) []string {
+		tag := indexes.TagByName(tagName)
+		if tag == nil {
+			t.Fatalf("tag %s missing", tagName)
+		}
+		var got []string
+		if err := tag.First(); err != nil {
+			t.Fatalf("First failed: %v", err)
+		}
+		for !tag.EOF() {
+			got = append(got, strings.TrimSpace(f.FieldByName("name").MustAsString()))
+			if err := tag.Next(); err != nil {
+				break
+			}
+		}
+		return got
+	}
+
+	if got, want := strings.Join(tagOrder("name"), ","), "Apple,banana,cherry,fig,pear"; got != want {
+		t.Errorf("NAME order = %s, want %s", got, want)
+	}
+	if got, want := strings.Join(tagOrder("qty"), ","), "cherry,banana,fig,Apple,pear"; got != want {
+		t.Errorf("QTY order = %s, want %s", got, want)
+	}
+	if got, want := strings.Join(tagOrder("positive"), ","), "banana,cherry"; got != want {
+		t.Errorf("POSITIVE order = %s, want %s", got, want)
+	}
+
+	tag := indexes.TagByName("name")
+	result, err := tag.SeekString("CHE")
+	if err != nil || result != foxi.SeekSuccess {
+		t.Fatalf("SeekString = %v, %v", result, err)
+	}
+	if got := strings.TrimSpace(f.FieldByName("name").MustAsString()); got != "cherry" {
+		t.Errorf("seek landed on %q, want %q", got, "cherry")
+	}
+	if got := tag.CurrentKey(); !strings.HasPrefix(got, "CHERRY") {
+		t.Errorf("CurrentKey = %q, want CHERRY prefix", got)
+	}
+
+	result, err = indexes.TagByName("qty").SeekInt(10)
+	if err != nil || result != foxi.SeekSuccess {
+		t.Fatalf("SeekInt = %v, %v", result, err)
+	}
+	if got := strings.TrimSpace(f.FieldByName("name").MustAsString()); got != "banana" {
+		t.Errorf("seek landed on %q, want %q", got, "banana")
+	}
+}
+
+func TestCreateExistingTable(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "twice")
+	schema := foxi.Schema{Fields: []foxi.FieldSpec{{Name: "ID", Type: foxi.FTInteger}}}
+
+	f, err := foxi.Create(path, schema, nil)
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	f.MustAppend()
+	f.MustWrite()
+	f.Close()
+
+	if _, err := foxi.Create(path, schema, nil); err == nil {
+		t.Fatal("expected an error creating over an existing table")
+	}
+
+	f, err = foxi.Create(path, schema, &foxi.CreateOptions{Overwrite: true, Codepage: 0x01})
+	if err != nil {
+		t.Fatalf("Create with Overwrite failed: %v", err)
+	}
+	defer f.Close()
+	header := f.Header()
+	if count := header.RecordCount(); count != 0 {
+		t.Errorf("expected an empty table, got %d records", count)
+	}
+
+	raw, err := os.ReadFile(path + ".dbf")
+	if err != nil {
+		t.Fatalf("failed to read table: %v", err)
+	}
+	if raw[0] != 0x30 || raw[29] != 0x01 {
+		t.Errorf("header version 0x%02X codepage 0x%02X, want 0x30 0x01", raw[0], raw[29])
+	}
+}
+
+func TestCreateInvalidSchema(t *testing.T) {
+	dir := t.TempDir()
+	if err := expr.RegisterFunc("CREATE_REV", func(args []any) (any, error) {
+		return args[0], nil
+	}, []expr.Type{expr.Character}, expr.Character); err != nil {
+		t.Fatal(err)
+	}
+	logical := []foxi.FieldSpec{{Name: "A", Type: foxi.FTLogical}, {Name: "S", Type: foxi.FTCharacter, Size: 10}}
+	wide := make([]foxi.FieldSpec, 0, 260)
+	for i := range 260 {
+		wide = append(wide, foxi.FieldSpec{Name: fmt.Sprintf("F%d", i), Type: foxi.FTCharacter, Size: 254})
+	}
+	tests := map[string]struct {
+		schema foxi.Schema
+		want   string // Part of the error message
+	}{
+		"no fields":         {foxi.Schema{}, "no fields"},
+		"long name":         {foxi.Schema{Fields: []foxi.FieldSpec{{Name: "ABCDEFGHIJK", Type: foxi.FTLogical}}}, "invalid field name"},
+		"blank in name":     {foxi.Schema{Fields: []foxi.FieldSpec{{Name: "A B", Type: foxi.FTLogical}}}, `invalid field name "A B"`},
+		"digit first":       {foxi.Schema{Fields: []foxi.FieldSpec{{Name: "1A", Type: foxi.FTLogical}}}, "starting with a letter"},
+		"missing size":      {foxi.Schema{Fields: []foxi.FieldSpec{{Name: "NAME", Type: foxi.FTCharacter}}}, "1 to 254 bytes wide, not 0"},
+		"numeric too wide":  {foxi.Schema{Fields: []foxi.FieldSpec{{Name: "N", Type: foxi.FTNumeric, Size: 21}}}, "1 to 20 digits wide, not 21"},
+		"too many decimals": {foxi.Schema{Fields: []foxi.FieldSpec{{Name: "N", Type: foxi.FTNumeric, Size: 5, Decimals: 4}}}, "4 decimals do not fit a width of 5"},
+		"double decimals":   {foxi.Schema{Fields: []foxi.FieldSpec{{Name: "B", Type: foxi.FTDouble, Decimals: 19}}}, "at most 18 decimals"},
+		"record too long":   {foxi.Schema{Fields: wide}, "longer than the limit of 65500"},
+		"picture":           {foxi.Schema{Fields: []foxi.FieldSpec{{Name: "PIC", Type: foxi.FTPicture}}}, "cannot create"},
+		"duplicate name":    {foxi.Schema{Fields: []foxi.FieldSpec{{Name: "A", Type: foxi.FTLogical}, {Name: "a", Type: foxi.FTDate}}}, "duplicate field name: A"},
+		"bad tag": {
+			foxi.Schema{Fields: logical, Tags: []foxi.TagSpec{{Name: "A", Expression: "NOSUCHFIELD"}}},
+			"tag A: key expression NOSUCHFIELD",
+		},
+		"bad tag name": {
+			foxi.Schema{Fields: logical, Tags: []foxi.TagSpec{{Name: "A B", Expression: "A"}}},
+			`invalid tag name "A B"`,
+		},
+		"duplicate tag": {
+			foxi.Schema{Fields: logical, Tags: []foxi.TagSpec{{Name: "A", Expression: "A"}, {Name: "a", Expression: "S"}}},
+			"duplicate tag name: A",
+		},
+		"key without length": {
+			foxi.Schema{Fields: logical, Tags: []foxi.TagSpec{{Name: "T", Expression: "CREATE_REV(S)"}}},
+			"key expression CREATE_REV(S) has no fixed length; wrap it in PADR(CREATE_REV(S), n)",
+		},
+		"key too long": {
+			foxi.Schema{Fields: logical, Tags: []foxi.TagSpec{{Name: "T", Expression: "REPLICATE(S, 25)"}}},
+			"250 bytes long, longer than the limit of 240",
+		},
+		"filter not logical": {
+			foxi.Schema{Fields: logical, Tags: []foxi.TagSpec{{Name: "T", Expression: "S", Filter: "S"}}},
+			"FOR expression S is not logical",
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(dir, strings.ReplaceAll(name, " ", "_")+".dbf")
+			f, err := foxi.Create(path, tt.schema, nil)
+			if err == nil {
+				f.Close()
+				t.Fatal("expected an error")
+			}
+			if !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("error %q does not mention %q", err, tt.want)
+			}
+			if _, err := os.Stat(path); err == nil {
+				t.Error("table left behind after a failed create")
+			}
+		})
+	}
+}
+
+func TestDeprecatedEntryPoints(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "fruit.dbf")
+	f, err := foxi.Create(path, foxi.Schema{
+		Fields: []foxi.FieldSpec{
+			{Name: "NAME", Type: foxi.FTCharacter, Size: 12},
+			{Name: "QTY", Type: foxi.FTInteger},
+		},
+		Tags: []foxi.TagSpec{
+			{Name: "NAME", Expression: "NAME"},
+			{Name: "QTY", Expression: "QTY"},
+		},
+	}, nil)
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	for i, name := range []string{"pear", "apple", "pear", "fig"} {
+		f.MustAppend()
+		f.FieldByName("name").MustSetString(name)
+		f.FieldByName("qty").MustSetInt(5 + i%2)
+		f.MustWrite()
+	}
+	f.Close()
+
+	codeBase := &pkg.Code4{}
+	pkg.Code4Init(codeBase)
+	defer pkg.Code4InitUndo(codeBase)
+	data := pkg.D4Open(codeBase, path)
+	if data == nil {
+		t.Fatal("failed to open table")
+	}
+
+	name := pkg.D4Tag(data, "NAME")
+	if name == nil || pkg.I4FirstTag(name.Index) != name {
+		t.Fatal("I4FirstTag did not return the first tag")
+	}
+	if rc := pkg.D4GoPosition(data, 2); rc != pkg.ErrorNone || pkg.D4RecNo(data) != 2 {
+		t.Errorf("D4GoPosition(2) = %d at record %d", rc, pkg.D4RecNo(data))
+	}
+
+	pkg.D4TagSelect(data, name)
+	if rc := pkg.D4Seek(data, "pear"); rc != pkg.R4Success || pkg.D4RecNo(data) != 1 {
+		t.Fatalf("D4Seek = %d at record %d", rc, pkg.D4RecNo(data))
+	}
+	if rc := pkg.D4SeekNextN(data, "pearly", 4); rc != pkg.R4Success || pkg.D4RecNo(data) != 3 {
+		t.Errorf("D4SeekNextN = %d at record %d, want record 3", rc, pkg.D4RecNo(data))
+	}
+	if rc := pkg.D4SeekNextN(data, "pearly", 4); rc == pkg.R4Success {
+		t.Errorf("D4SeekNextN found a third pear at record %d", pkg.D4RecNo(data))
+	}
+
+	pkg.D4TagSelect(data, pkg.D4Tag(data, "QTY"))
+	var found []int32
+	for rc := pkg.D4SeekDouble(data, 6); rc == pkg.R4Success; rc = pkg.D4SeekNextDouble(data, 6) {
+		found = append(found, pkg.D4RecNo(data))
+	}
+	if len(found) != 2 || found[0] != 2 || found[1] != 4 {
+		t.Errorf("D4SeekNextDouble found records %v, want [2 4]", found)
+	}
+
+	// D4CreateData copies a tag into the production index of the new table
+	copied := pkg.D4CreateData(codeBase, filepath.Join(t.TempDir(), "copy.dbf"), []pkg.Field4Info{
+		{Name: "NAME", Type: 'C', Length: 12},
+	}, name)
+	if copied == nil {
+		t.Fatalf("D4CreateData failed: %d", codeBase.ErrorCode)
+	}
+	defer pkg.D4Close(copied)
+	if tag := pkg.D4Tag(copied, "NAME"); tag == nil || pkg.T4Expr(tag) != "NAME" || name.Index != tag.Index {
+		t.Error("D4CreateData did not create the tag")
+	}
+}
@@ -0,0 +1,193 @@
+package tests
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/mkfoss/foxi"
+)
+
+// cursorRecords is the number of records createCursorTable writes
+const cursorRecords = 300
+
+// createCursorTable creates ITEMS (NAME, N, NOTES) with a tag on NAME;
+// record i is named ITEM%03d in reverse order, with N = i and a note
+func createCursorTable(t *testing.T) *foxi.Foxi {
+	t.Helper()
+	rows := make([]row, 0, cursorRecords)
+	for i := 1; i <= cursorRecords; i++ {
+		rows = append(rows, row{
+			"NAME":  fmt.Sprintf("ITEM%03d", cursorRecords-i),
+			"N":     i,
+			"NOTES": fmt.Sprintf("note %d", i),
+		})
+	}
+	f, _ := createTable(t, "items", foxi.Schema{
+		Fields: []foxi.FieldSpec{
+			{Name: "NAME", Type: foxi.FTCharacter, Size: 10},
+			{Name: "N", Type: foxi.FTInteger},
+			{Name: "NOTES", Type: foxi.FTMemo},
+		},
+		Tags: []foxi.TagSpec{{Name: "name", Expression: "NAME"}},
+	}, nil, rows...)
+	t.Cleanup(func() { f.Close() })
+	return f
+}
+
+func TestCursor(t *testing.T) {
+	f := createCursorTable(t)
+	name := f.Indexes().TagByName("name")
+	f.Indexes().MustSelectTag(name)
+	f.MustGoto(10)
+
+	cur := f.MustCursor()
+	defer cur.Close()
+	if cur.Alias() != "ITEMS" || cur.Position() != 0 {
+		t.Errorf("new cursor has alias %q at record %d", cur.Alias(), cur.Position())
+	}
+	if tag := cur.Indexes().SelectedTag(); tag == nil || tag.Name() != "NAME" {
+		t.Fatal("the cursor did not select the table's tag")
+	}
+
+	// The cursor moves in tag order without moving the table
+	cur.MustFirst()
+	if cur.Position() != cursorRecords || f.Position() != 10 {
+		t.Errorf("First went to %d, table at %d", cur.Position(), f.Position())
+	}
+	if got := strings.TrimSpace(cur.FieldByName("notes").MustAsString()); got != fmt.Sprintf("note %d", cursorRecords) {
+		t.Errorf("NOTES = %q", got)
+	}
+	if cur.Indexes().TagByName("name").MustSeekString("ITEM100") != foxi.SeekSuccess || cur.Position() != cursorRecords-100 {
+		t.Errorf("Seek went to %d", cur.Position())
+	}
+	cur.MustNext()
+	if got := strings.TrimSpace(cur.FieldByName("name").MustAsString()); got != "ITEM101" {
+		t.Errorf("Next after Seek read %q", got)
+	}
+	cur.MustGoto(cursorRecords - 50)
+	cur.MustNext()
+	if got := strings.TrimSpace(cur.FieldByName("name").MustAsString()); got != "ITEM051" {
+		t.Errorf("Next after Goto read %q", got)
+	}
+
+	// A cursor only reads
+	readOnly := map[string]error{
+		"Append":    cur.Append(),
+		"Write":     cur.Write(),
+		"Delete":    cur.Delete(),
+		"SetString": cur.FieldByName("name").SetString("x"),
+		"SetInt":    cur.FieldByName("n").SetInt(1),
+		"LockFile":  cur.LockFile(),
+		"CreateTag": cur.Indexes().CreateTag("n", "N", "", false, false),
+	}
+	if _, err := cur.Begin(); !errors.Is(err, foxi.ErrReadOnly) {
+		t.Errorf("Begin gave %v", err)
+	}
+	for op, err := range readOnly {
+		if !errors.Is(err, foxi.ErrReadOnly) {
+			t.Errorf("%s gave %v, want ErrReadOnly", op, err)
+		}
+	}
+
+	// Changes written through the table are read by the cursor afterwards
+	f.MustGoto(cursorRecords - 100)
+	f.FieldByName("name").MustSetString("ZZZ")
+	f.MustWrite()
+	if cur.Indexes().TagByName("name").MustSeekString("ITEM100") == foxi.SeekSuccess {
+		t.Error("the cursor found a key changed by the table")
+	}
+	cur.MustLast()
+	if cur.Position() != cursorRecords-100 {
+		t.Errorf("Last went to %d, want %d", cur.Position(), cursorRecords-100)
+	}
+
+	// A closed cursor cannot be opened again
+	cur.Close()
+	if err := cur.Open(filepath.Join(t.TempDir(), "items.dbf")); !errors.Is(err, foxi.ErrReadOnly) {
+		t.Errorf("Open on a closed cursor gave %v", err)
+	}
+	if f.Position() != cursorRecords-100 || !f.Active() {
+		t.Error("closing the cursor disturbed the table")
+	}
+}
+
+func TestCursorConcurrent(t *testing.T) {
+	f := createCursorTable(t)
+	f.Indexes().MustSelectTag(f.Indexes().TagByName("name"))
+
+	const readers = 16
+	cursors := make([]*foxi.Foxi, readers)
+	for i := range cursors {
+		cursors[i] = f.MustCursor()
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, readers)
+	for i, cur := range cursors {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer cur.Close()
+			errs <- readCursor(cur, i)
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+// readCursor walks a cursor in tag order and seeks through it, checking
+// every record it reads
+func readCursor(cur *foxi.Foxi, reader int) error {
+	check := func() error {
+		n := cur.FieldByName("n").MustAsInt()
+		want := fmt.Sprintf("ITEM%03d", cursorRecords-n)
+		if got := strings.TrimSpace(cur.FieldByName("name").MustAsString()); got != want || n != cur.Position() {
+			return fmt.Errorf("reader %d: record %d has NAME %q and N %d", reader, cur.Position(), got, n)
+		}
+		if got := cur.FieldByName("notes").MustAsString(); got != fmt.Sprintf("note %d", n) {
+			return fmt.Errorf("reader %d: record %d has NOTES %q", reader, n, got)
+		}
+		return nil
+	}
+
+	for pass := 0; pass < 3; pass++ {
+		count, last := 0, ""
+		for _, err := range cur.Records() {
+			if err != nil {
+				return err
+			}
+			if err := check(); err != nil {
+				return err
+			}
+			name := cur.FieldByName("name").MustAsString()
+			if name < last {
+				return fmt.Errorf("reader %d: %q read after %q", reader, name, last)
+			}
+			count, last = count+1, name
+		}
+		if count != cursorRecords {
+			return fmt.Errorf("reader %d: walk read %d records", reader, count)
+		}
+
+		tag := cur.Indexes().TagByName("name")
+		for i := reader; i < cursorRecords; i += 7 {
+			key := fmt.Sprintf("ITEM%03d", i)
+			if result, err := tag.SeekString(key); err != nil || result != foxi.SeekSuccess {
+				return fmt.Errorf("reader %d: seek %s gave %v, %v", reader, key, result, err)
+			}
+			if err := check(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,180 @@
+package tests
+
+import (
+	"math/big"
+	"path/filepath"
+	"testing"
+
+	"github.com/mkfoss/foxi"
+)
+
+func TestDecimalParse(t *testing.T) {
+	tests := []struct {
+		in    string
+		want  string
+		scale int
+	}{
+		{"1234.50", "1234.50", 2},
+		{"  -0.05 ", "-0.05", 2},
+		{".5", "0.5", 1},
+		{"+42", "42", 0},
+		{"12345678901234567890.1234", "12345678901234567890.1234", 4},
+		{"-0", "0", 0},
+	}
+	for _, tt := range tests {
+		d, err := foxi.ParseDecimal(tt.in)
+		if err != nil {
+			t.Errorf("ParseDecimal(%q) failed: %v", tt.in, err)
+			continue
+		}
+		if got := d.String(); got != tt.want || d.Scale() != tt.scale {
+			t.Errorf("ParseDecimal(%q) = %s scale %d, want %s scale %d", tt.in, got, d.Scale(), tt.want, tt.scale)
+		}
+	}
+
+	for _, in := range []string{"", "-", "1.2.3", "1e5", "--1", "12a", "."} {
+		if _, err := foxi.ParseDecimal(in); err == nil {
+			t.Errorf("ParseDecimal(%q) expected error", in)
+		}
+	}
+}
+
+func TestDecimalRat(t *testing.T) {
+	d := foxi.MustParseDecimal("-12.375")
+	if got, want := d.Rat(), big.NewRat(-99, 8); got.Cmp(want) != 0 {
+		t.Errorf("Rat = %s, want %s", got.RatString(), want.RatString())
+	}
+
+	back, err := foxi.DecimalFromRat(big.NewRat(-99, 8), 4)
+	if err != nil || back.String() != "-12.3750" {
+		t.Errorf("DecimalFromRat(-99/8, 4) = %s, %v, want -12.3750", back, err)
+	}
+	if _, err := foxi.DecimalFromRat(big.NewRat(1, 3), 4); err == nil {
+		t.Error("expected error for 1/3 at scale 4")
+	}
+	if got := foxi.NewDecimal(5, 0).String(); got != "5" {
+		t.Errorf("NewDecimal(5, 0) = %s", got)
+	}
+	if got := foxi.NewDecimal(-5, 3).String(); got != "-0.005" {
+		t.Errorf("NewDecimal(-5, 3) = %s", got)
+	}
+	if got := (foxi.Decimal{}).String(); got != "0" {
+		t.Errorf("zero Decimal = %s", got)
+	}
+}
+
+func TestDecimalFields(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "money.dbf")
+	schema := foxi.Schema{
+		Fields: []foxi.FieldSpec{
+			{Name: "AMOUNT", Type: foxi.FTNumeric, Size: 20, Decimals: 4},
+			{Name: "PRICE", Type: foxi.FTNumeric, Size: 6, Decimals: 2},
+			{Name: "BALANCE", Type: foxi.FTCurrency},
+			{Name: "QTY", Type: foxi.FTInteger},
+			{Name: "NAME", Type: foxi.FTCharacter, Size: 5},
+		},
+	}
+	f, err := foxi.Create(path, schema, nil)
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+
+	f.MustAppend()
+	f.FieldByName("amount").MustSetDecimal(foxi.MustParseDecimal("12345678901234.5678"))
+	f.FieldByName("price").MustSetDecimal(foxi.MustParseDecimal("-0.125"))
+	f.FieldByName("balance").MustSetDecimal(foxi.MustParseDecimal("922337203685477.5807"))
+	f.FieldByName("qty").MustSetDecimal(foxi.MustParseDecimal("-42"))
+	f.MustWrite()
+	f.MustAppend()
+	f.MustWrite()
+	f.Close()
+
+	f = foxi.NewFoxi()
+	f.MustOpen(path)
+	defer f.Close()
+	f.MustGoto(1)
+
+	want := map[string]string{
+		"amount":  "12345678901234.5678",
+		"price":   "-0.13",
+		"balance": "922337203685477.5807",
+		"qty":     "-42",
+	}
+	for name, value := range want {
+		if got := f.FieldByName(name).MustAsDecimal().String(); got != value {
+			t.Errorf("%s = %s, want %s", name, got, value)
+		}
+	}
+	if got := f.FieldByName("price").MustAsString(); got != " -0.13" {
+		t.Errorf("PRICE stored as %q, want %q", got, " -0.13")
+	}
+
+	// Values too large for the field are errors, and leave it unchanged
+	tests := []struct {
+		name  string
+		value string
+	}{
+		{"price", "1000.00"},
+		{"balance", "922337203685477.5808"},
+		{"qty", "2147483648"},
+	}
+	for _, tt := range tests {
+		if err := f.FieldByName(tt.name).SetDecimal(foxi.MustParseDecimal(tt.value)); err == nil {
+			t.Errorf("SetDecimal(%s) on %s expected overflow error", tt.value, tt.name)
+		}
+		if got := f.FieldByName(tt.name).MustAsDecimal().String(); got != want[tt.name] {
+			t.Errorf("%s = %s after overflow, want %s", tt.name, got, want[tt.name])
+		}
+	}
+
+	// A fraction drops its leading zero to fit
+	f.FieldByName("price").MustSetDecimal(foxi.MustParseDecimal("-0.5"))
+	if got := f.FieldByName("price").MustAsString(); got != " -0.50" {
+		t.Errorf("PRICE stored as %q, want %q", got, " -0.50")
+	}
+	f.FieldByName("price").MustSetDecimal(foxi.MustParseDecimal("999.99"))
+	if got := f.FieldByName("price").MustAsString(); got != "999.99" {
+		t.Errorf("PRICE stored as %q, want %q", got, "999.99")
+	}
+
+	if _, err := f.FieldByName("name").AsDecimal(); err == nil {
+		t.Error("expected error reading a character field as a decimal")
+	}
+	if err := f.FieldByName("name").SetDecimal(foxi.NewDecimal(1, 0)); err == nil {
+		t.Error("expected error assigning a decimal to a character field")
+	}
+
+	// Blank numbers are zero with the field's scale
+	f.MustGoto(2)
+	if got := f.FieldByName("amount").MustAsDecimal(); got.Sign() != 0 || got.Scale() != 4 {
+		t.Errorf("blank AMOUNT = %s scale %d, want 0 scale 4", got, got.Scale())
+	}
+}
+
+func TestDecimalScan(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "scan.dbf")
+	schema := foxi.Schema{
+		Fields: []foxi.FieldSpec{
+			{Name: "TOTAL", Type: foxi.FTCurrency, Nullable: true},
+		},
+	}
+	f, err := foxi.Create(path, schema, nil)
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	defer f.Close()
+
+	type row struct {
+		Total *foxi.Decimal `dbf:"TOTAL,null"`
+	}
+	total := foxi.MustParseDecimal("0.1")
+	f.MustAppend()
+	f.MustPut(&row{Total: &total})
+	f.MustWrite()
+
+	var got row
+	f.MustScan(&got)
+	if got.Total == nil || got.Total.String() != "0.1000" {
+		t.Errorf("Total = %v, want 0.1000", got.Total)
+	}
+}
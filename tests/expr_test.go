@@ -0,0 +1,407 @@
+package tests
+
+import (
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mkfoss/foxi"
+	"github.com/mkfoss/foxi/expr"
+)
+
+// mapEnv is an expression environment over a map of field values
+type mapEnv struct {
+	alias   string
+	columns map[string]*expr.Column
+	recNo   int
+	deleted bool
+}
+
+// newMapEnv builds an environment with a field for each value
+func newMapEnv(values map[string]any) *mapEnv {
+	env := &mapEnv{alias: "PEOPLE", columns: map[string]*expr.Column{}, recNo: 7}
+	for name, v := range values {
+		column := &expr.Column{Name: name, Len: 10}
+		switch v := v.(type) {
+		case string:
+			column.Type, column.Len = expr.Character, len(v)
+		case float64:
+			column.Type, column.Dec = expr.Numeric, 2
+		case int:
+			column.Type, column.Len = expr.Integer, 4
+		case bool:
+			column.Type, column.Len = expr.Logical, 1
+		case time.Time:
+			column.Type, column.Len = expr.Date, 8
+		}
+		value := v
+		column.Get = func() (any, error) { return value, nil }
+		env.columns[name] = column
+	}
+	return env
+}
+
+func (e *mapEnv) Column(alias, name string) (*expr.Column, bool) {
+	if alias != "" && alias != e.alias {
+		return nil, false
+	}
+	column, ok := e.columns[name]
+	return column, ok
+}
+
+func (e *mapEnv) RecNo() int    { return e.recNo }
+func (e *mapEnv) Deleted() bool { return e.deleted }
+
+func testEnv() *mapEnv {
+	return newMapEnv(map[string]any{
+		"NAME":  "Smith     ",
+		"FIRST": "Anna  ",
+		"AGE":   42,
+		"PAY":   1234.5,
+		"HIRED": time.Date(2020, 2, 28, 0, 0, 0, 0, time.UTC),
+		"OK":    true,
+	})
+}
+
+func TestExprEval(t *testing.T) {
+	day := func(y, m, d int) time.Time { return time.Date(y, time.Month(m), d, 0, 0, 0, 0, time.UTC) }
+
+	tests := []struct {
+		source string
+		want   any
+	}{
+		// Arithmetic and precedence
+		{"1 + 2 * 3", 7.0},
+		{"(1 + 2) * 3", 9.0},
+		{"2 ^ 3 ^ 2", 64.0},
+		{"2 ** 3", 8.0},
+		{"-2 ^ 2", 4.0},
+		{"7 % 3", 1.0},
+		{"-7 % 3", 2.0},
+		{"7 % -3", -2.0},
+		{"AGE / 4", 10.5},
+		{"PAY * 2 - 1", 2468.0},
+		{"1.5e2 + .5", 150.5},
+
+		// Strings
+		{`"ab" + "cd"`, "abcd"},
+		{`"ab  " - "cd"`, "abcd  "},
+		{`NAME + FIRST`, "Smith     Anna  "},
+		{`"mit" $ NAME`, true},
+		{`"" $ NAME`, false},
+		{`NAME = "Smi"`, true},
+		{`"Smi" = NAME`, false},
+		{`NAME = ""`, true},
+		{`NAME == "Smith"`, false},
+		{`ALLTRIM(NAME) == "Smith"`, true},
+		{`NAME <> "Smithers"`, true},
+		{`NAME < "Smithers"`, true},
+		{`[it's] + 'a "quote"'`, `it's` + `a "quote"`},
+
+		// Dates and datetimes
+		{"HIRED + 1", day(2020, 2, 29)},
+		{"1 + HIRED", day(2020, 2, 29)},
+		{"HIRED - 28", day(2020, 1, 31)},
+		{"{^2020-03-01} - HIRED", 2.0},
+		{"HIRED < {^2020-03-01}", true},
+		{"{^2024-01-31 13:45:00} + 60", time.Date(2024, 1, 31, 13, 46, 0, 0, time.UTC)},
+		{"{^2024-01-31 1:00 PM} - {^2024-01-31 12:00}", 3600.0},
+		{"{} + 1", time.Time{}},
+		{"DTOS(HIRED)", "20200228"},
+		{"DTOS({})", "        "},
+
+		// Logical operators and null
+		{"OK .AND. AGE > 40", true},
+		{"!OK OR AGE > 40", true},
+		{"NOT OK", false},
+		{".NOT. .T. .OR. .F.", false},
+		{".NULL. .AND. .F.", false},
+		{".NULL. .OR. .T.", true},
+		{".NULL. .AND. .T.", nil},
+		{"AGE + .NULL.", nil},
+		{"AGE > .NULL.", nil},
+
+		// Functions
+		{`UPPER(FIRST)`, "ANNA  "},
+		{`LOWER(NAME)`, "smith     "},
+		{`LEFT(NAME, 3)`, "Smi"},
+		{`RIGHT(ALLTRIM(NAME), 2)`, "th"},
+		{`SUBSTR(NAME, 2, 3)`, "mit"},
+		{`SUBSTR(NAME, 4)`, "th     "},
+		{`SUBS(NAME, 1, 1) + ALLT(FIRST)`, "SAnna"},
+		{`STR(AGE)`, "        42"},
+		{`STR(PAY, 8, 2)`, " 1234.50"},
+		{`STR(PAY, 5, 2)`, " 1235"},
+		{`STR(PAY, 3)`, "***"},
+		{`VAL(" 12.5kg")`, 12.5},
+		{`VAL("abc")`, 0.0},
+		{`IIF(AGE > 40, "old", "young")`, "old"},
+		{`IIF(.NULL., 1, 2)`, 2.0},
+		{`IIF(.T., 1, 1 / 0)`, 1.0},
+		{`RECNO()`, 7.0},
+		{`DELETED()`, false},
+
+		// Qualified names
+		{`PEOPLE.AGE + PEOPLE->AGE`, 84.0},
+		{`people.age`, 42.0},
+	}
+
+	env := testEnv()
+	for _, tt := range tests {
+		prog, err := expr.Compile(tt.source, env)
+		if err != nil {
+			t.Errorf("Compile(%s) failed: %v", tt.source, err)
+			continue
+		}
+		got, err := prog.Eval()
+		if err != nil {
+			t.Errorf("Eval(%s) failed: %v", tt.source, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Eval(%s) = %#v, want %#v", tt.source, got, tt.want)
+		}
+	}
+}
+
+func TestExprTypes(t *testing.T) {
+	tests := []struct {
+		source string
+		typ    expr.Type
+		length int
+	}{
+		{`NAME`, expr.Character, 10},
+		{`NAME + FIRST`, expr.Character, 16},
+		{`UPPER(NAME)`, expr.Character, 10},
+		{`LEFT(NAME, 3)`, expr.Character, 3},
+		{`SUBSTR(NAME, 3, 4)`, expr.Character, 4},
+		{`DTOS(HIRED) + NAME`, expr.Character, 18},
+		{`STR(AGE, 5)`, expr.Character, 5},
+		{`AGE`, expr.Integer, 4},
+		{`AGE + 1`, expr.Numeric, 12},
+		{`HIRED + 1`, expr.Date, 8},
+		{`HIRED - HIRED`, expr.Numeric, 8},
+		{`AGE > 1`, expr.Logical, 1},
+	}
+	env := testEnv()
+	for _, tt := range tests {
+		prog := expr.MustCompile(tt.source, env)
+		if prog.Type() != tt.typ || prog.Len() != tt.length {
+			t.Errorf("%s: type %s length %d, want %s length %d", tt.source, prog.Type(), prog.Len(), tt.typ, tt.length)
+		}
+	}
+}
+
+func TestExprNumericWidth(t *testing.T) {
+	env := newMapEnv(map[string]any{"A": 0.0, "B": 0.0, "I": 0})
+	env.columns["A"].Len, env.columns["A"].Dec = 6, 2 // N(6,2)
+	env.columns["B"].Len, env.columns["B"].Dec = 8, 0 // N(8,0)
+	tests := []struct {
+		source   string
+		len, dec int
+	}{
+		{`A`, 6, 2},
+		{`A + A`, 7, 2},
+		{`A - B`, 12, 2},
+		{`A * A`, 11, 4},
+		{`A * B`, 14, 2},
+		{`B * B * B * B`, 20, 0},
+		{`A / B`, 20, 2},
+		{`A ^ 2`, 20, 2},
+		{`B % A`, 6, 2},
+		{`-A`, 7, 2},
+		{`I + I`, 12, 0},
+		{`I * 2`, 20, 0},
+	}
+	for _, tt := range tests {
+		prog := expr.MustCompile(tt.source, env)
+		if prog.Len() != tt.len || prog.Dec() != tt.dec {
+			t.Errorf("%s: N(%d,%d), want N(%d,%d)", tt.source, prog.Len(), prog.Dec(), tt.len, tt.dec)
+		}
+	}
+}
+
+func TestExprErrors(t *testing.T) {
+	tests := []struct {
+		source string
+		want   string
+	}{
+		{``, "empty expression"},
+		{`1 +`, "syntax error at end of expression"},
+		{`(1 + 2`, `expected ")"`},
+		{`"open`, "unterminated string"},
+		{`1 2`, "syntax error at offset 2"},
+		{`AGE @ 2`, "syntax error at offset 4"},
+		{`{01/02/2024}`, "ambiguous date"},
+		{`{^2024-02-30}`, "invalid date"},
+		{`NAME + 1`, "operator/operand type mismatch"},
+		{`AGE .AND. OK`, "operator/operand type mismatch"},
+		{`NAME > 1`, "operator/operand type mismatch"},
+		{`.NOT. NAME`, "operator/operand type mismatch"},
+		{`HIRED * 2`, "operator/operand type mismatch"},
+		{`IIF(OK, 1, "a")`, "operator/operand type mismatch"},
+		{`NOSUCH + 1`, "unknown field NOSUCH"},
+		{`OTHER.AGE`, "unknown field OTHER.AGE"},
+		{`NOSUCH(1)`, "unknown function NOSUCH"},
+		{`UPPER(AGE)`, "function UPPER: argument 1 must be character, not numeric"},
+		{`UPPER(NAME, 1)`, "function UPPER: takes 1 argument, got 2"},
+		{`SUBSTR(NAME)`, "function SUBSTR: takes 2 to 3 arguments, got 1"},
+		{`DTOS(NAME)`, "argument 1 must be date or datetime"},
+	}
+	env := testEnv()
+	for _, tt := range tests {
+		_, err := expr.Compile(tt.source, env)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("Compile(%q) error = %v, want %q", tt.source, err, tt.want)
+		}
+	}
+
+	// Evaluation errors
+	if _, err := expr.MustCompile("AGE / (AGE - 42)", env).Eval(); err == nil || !strings.Contains(err.Error(), "division by zero") {
+		t.Errorf("expected division by zero, got %v", err)
+	}
+	if _, err := expr.MustCompile("AGE + 1", env).EvalBool(); err == nil {
+		t.Error("expected error from EvalBool on a numeric expression")
+	}
+}
+
+func TestExprString(t *testing.T) {
+	tests := []struct {
+		source string
+		want   string
+	}{
+		{`upper( name )+first`, `UPPER(NAME) + FIRST`},
+		{`(1+2)*3`, `(1 + 2) * 3`},
+		{`1+(2*3)`, `1 + 2 * 3`},
+		{`1-(2-3)`, `1 - (2 - 3)`},
+		{`people.age # 3 and !ok or people->ok`, `PEOPLE.AGE <> 3 .AND. .NOT. OK .OR. PEOPLE.OK`},
+		{`.not.(ok.and.ok)`, `.NOT. (OK .AND. OK)`},
+		{`subs(name,2) = 'x"'`, `SUBSTR(NAME, 2) = 'x"'`},
+		{`hired >= {^2020-1-2}`, `HIRED >= {^2020-01-02}`},
+		{`-(-age)`, `--AGE`},
+	}
+	env := testEnv()
+	for _, tt := range tests {
+		prog, err := expr.Compile(tt.source, env)
+		if err != nil {
+			t.Errorf("Compile(%s) failed: %v", tt.source, err)
+			continue
+		}
+		if got := prog.String(); got != tt.want {
+			t.Errorf("String(%s) = %s, want %s", tt.source, got, tt.want)
+		}
+		// The canonical form compiles to the same canonical form
+		if again := expr.MustCompile(tt.want, env).String(); again != tt.want {
+			t.Errorf("String(%s) = %s, want %s", tt.want, again, tt.want)
+		}
+	}
+}
+
+func TestCompileTable(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "staff.dbf")
+	schema := foxi.Schema{
+		Fields: []foxi.FieldSpec{
+			{Name: "NAME", Type: foxi.FTCharacter, Size: 10},
+			{Name: "SALARY", Type: foxi.FTNumeric, Size: 9, Decimals: 2},
+			{Name: "HIRED", Type: foxi.FTDate},
+			{Name: "LEVEL", Type: foxi.FTInteger},
+			{Name: "BONUS", Type: foxi.FTCurrency, Nullable: true},
+		},
+		Tags: []foxi.TagSpec{
+			{Name: "name", Expression: "UPPER(NAME)"},
+			{Name: "salary", Expression: "SALARY"},
+			{Name: "hired", Expression: "HIRED"},
+			{Name: "level", Expression: "LEVEL"},
+			{Name: "hirename", Expression: "DTOS(HIRED)+NAME"},
+		},
+	}
+	f, err := foxi.Create(path, schema, nil)
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	defer f.Close()
+
+	staff := []struct {
+		name   string
+		salary float64
+		hired  time.Time
+		level  int
+	}{
+		{"Mike", 5200, time.Date(2019, 5, 1, 0, 0, 0, 0, time.UTC), 3},
+		{"alice", 7400.5, time.Date(2015, 1, 12, 0, 0, 0, 0, time.UTC), 5},
+		{"Bob", -10, time.Date(2022, 11, 30, 0, 0, 0, 0, time.UTC), -1},
+		{"carol", 0, time.Date(2001, 7, 4, 0, 0, 0, 0, time.UTC), 0},
+	}
+	for _, s := range staff {
+		f.MustAppend()
+		f.FieldByName("name").MustSetString(s.name)
+		f.FieldByName("salary").MustSetFloat(s.salary)
+		f.FieldByName("hired").MustSetTime(s.hired)
+		f.FieldByName("level").MustSetInt(s.level)
+		f.FieldByName("bonus").MustSetNull()
+		f.MustWrite()
+	}
+
+	// Keys built by a compiled expression match the keys of the tag
+	for _, spec := range schema.Tags {
+		prog := foxi.MustCompile(f, spec.Expression)
+		tag := f.Indexes().TagByName(spec.Name)
+		if tag == nil {
+			t.Fatalf("tag %s not found", spec.Name)
+		}
+		if prog.KeyLen() != tag.KeyLength() {
+			t.Errorf("%s: KeyLen = %d, tag key length %d", spec.Expression, prog.KeyLen(), tag.KeyLength())
+		}
+		f.Indexes().MustSelectTag(tag)
+		count := 0
+		for f.MustFirst(); !f.EOF(); f.MustNext() {
+			key, err := prog.Key()
+			if err != nil {
+				t.Fatalf("%s: Key failed: %v", spec.Expression, err)
+			}
+			if string(key) != tag.CurrentKey() {
+				t.Errorf("%s: record %d key %q, tag key %q", spec.Expression, f.Position(), key, tag.CurrentKey())
+			}
+			count++
+		}
+		if count != len(staff) {
+			t.Errorf("%s: visited %d records, want %d", spec.Expression, count, len(staff))
+		}
+		f.Indexes().SelectTag(nil)
+	}
+
+	// A compiled filter follows the current record
+	filter := foxi.MustCompile(f, `staff.SALARY > 1000 .AND. HIRED < {^2020-01-01}`)
+	var matched []string
+	for f.MustFirst(); !f.EOF(); f.MustNext() {
+		ok, err := filter.EvalBool()
+		if err != nil {
+			t.Fatalf("EvalBool failed: %v", err)
+		}
+		if ok {
+			matched = append(matched, strings.TrimSpace(f.FieldByName("name").MustAsString()))
+		}
+	}
+	if want := []string{"Mike", "alice"}; !reflect.DeepEqual(matched, want) {
+		t.Errorf("filter matched %v, want %v", matched, want)
+	}
+
+	// A null field makes the result null
+	bonus := foxi.MustCompile(f, `BONUS + 1`)
+	if v, err := bonus.Eval(); err != nil || v != nil {
+		t.Errorf("BONUS + 1 = %v, %v; want nil", v, err)
+	}
+
+	if _, err := foxi.Compile(f, `OTHER.SALARY`); err == nil {
+		t.Error("expected an error for a field of another alias")
+	}
+	if _, err := foxi.Compile(f, `_NULLFLAGS`); err == nil {
+		t.Error("expected an error for the system field")
+	}
+	f.Close()
+	if _, err := foxi.Compile(f, `SALARY`); err == nil {
+		t.Error("expected an error compiling against a closed table")
+	}
+}
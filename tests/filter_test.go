@@ -0,0 +1,264 @@
+package tests
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/mkfoss/foxi"
+	"github.com/mkfoss/foxi/expr"
+)
+
+var filterNames = []string{"Smith", "adams", "smythe", "Baker", "SMALL", "jones", "Smart", "carter", "turner"}
+
+// forwards returns the names from First to EOF
+func forwards(t *testing.T, f *foxi.Foxi) []string {
+	t.Helper()
+	var names []string
+	for f.MustFirst(); !f.EOF(); f.MustNext() {
+		names = append(names, strings.TrimSpace(f.FieldByName("name").MustAsString()))
+	}
+	return names
+}
+
+// backwards returns the names from Last to BOF
+func backwards(t *testing.T, f *foxi.Foxi) []string {
+	t.Helper()
+	var names []string
+	for f.MustLast(); !f.BOF(); f.MustPrevious() {
+		names = append(names, strings.TrimSpace(f.FieldByName("name").MustAsString()))
+	}
+	return names
+}
+
+// iterated returns the names Records visits
+func iterated(t *testing.T, f *foxi.Foxi) []string {
+	t.Helper()
+	var names []string
+	for rec, err := range f.Records() {
+		if err != nil {
+			t.Fatalf("Records failed: %v", err)
+		}
+		names = append(names, strings.TrimSpace(rec.Field("name").MustAsString()))
+	}
+	return names
+}
+
+func TestSetFilter(t *testing.T) {
+	f, _ := createTagTable(t, filterNames...)
+	defer f.Close()
+	f.Indexes().MustCreateTag("name", "UPPER(NAME)", "", false, false)
+	f.Indexes().SelectTag(nil)
+
+	f.MustSetFilter("AMOUNT % 2 = 0")
+	if got := f.Filter(); got != "AMOUNT % 2 = 0" {
+		t.Errorf("Filter = %q", got)
+	}
+
+	// Record order
+	want := []string{"adams", "Baker", "jones", "carter"}
+	if got := forwards(t, f); !reflect.DeepEqual(got, want) {
+		t.Errorf("record order = %v, want %v", got, want)
+	}
+	if got := backwards(t, f); !reflect.DeepEqual(got, []string{"carter", "jones", "Baker", "adams"}) {
+		t.Errorf("reverse record order = %v", got)
+	}
+	if got := iterated(t, f); !reflect.DeepEqual(got, want) {
+		t.Errorf("Records = %v, want %v", got, want)
+	}
+
+	// Skip counts matching records only
+	f.MustFirst()
+	f.MustSkip(2)
+	if f.Position() != 6 {
+		t.Errorf("Skip(2) from record 2 reached record %d, want 6", f.Position())
+	}
+	f.MustSkip(-1)
+	if f.Position() != 4 {
+		t.Errorf("Skip(-1) from record 6 reached record %d, want 4", f.Position())
+	}
+	f.MustSkip(5)
+	if !f.EOF() {
+		t.Errorf("Skip(5) from record 4 should reach EOF, at record %d", f.Position())
+	}
+	f.MustSkip(-1)
+	if f.EOF() || f.Position() != 8 {
+		t.Errorf("Skip(-1) from EOF reached record %d, want 8", f.Position())
+	}
+
+	// Goto ignores the filter, moving on from there does not
+	f.MustGoto(3)
+	if f.Position() != 3 {
+		t.Errorf("Goto(3) reached record %d", f.Position())
+	}
+	f.MustNext()
+	if f.Position() != 4 {
+		t.Errorf("Next from record 3 reached record %d, want 4", f.Position())
+	}
+
+	// Tag order
+	f.Indexes().MustSelectTag(f.Indexes().TagByName("name"))
+	want = []string{"adams", "Baker", "carter", "jones"}
+	if got := forwards(t, f); !reflect.DeepEqual(got, want) {
+		t.Errorf("tag order = %v, want %v", got, want)
+	}
+	if got := backwards(t, f); !reflect.DeepEqual(got, []string{"jones", "carter", "Baker", "adams"}) {
+		t.Errorf("reverse tag order = %v", got)
+	}
+	if got := iterated(t, f); !reflect.DeepEqual(got, want) {
+		t.Errorf("Records in tag order = %v, want %v", got, want)
+	}
+	var ranged []int
+	for rec, err := range f.Indexes().TagByName("name").Range("B", "J") {
+		if err != nil {
+			t.Fatal(err)
+		}
+		ranged = append(ranged, rec.Number())
+	}
+	if !reflect.DeepEqual(ranged, []int{4, 8, 6}) {
+		t.Errorf("Range(B, J) = %v, want [4 8 6]", ranged)
+	}
+
+	// The filter sees changes made after it was set
+	f.MustGoto(1)
+	f.FieldByName("amount").MustSetInt(10)
+	f.MustWrite()
+	if got := forwards(t, f); !reflect.DeepEqual(got, []string{"adams", "Baker", "carter", "jones", "Smith"}) {
+		t.Errorf("tag order after a change = %v", got)
+	}
+
+	// A filter that matches nothing
+	f.MustSetFilter("AMOUNT > 100")
+	if f.MustFirst(); !f.EOF() {
+		t.Errorf("First with no match at record %d, want EOF", f.Position())
+	}
+	if f.MustLast(); !f.BOF() {
+		t.Errorf("Last with no match at record %d, want BOF", f.Position())
+	}
+
+	// Clearing
+	f.MustSetFilter("")
+	if f.Filter() != "" || len(forwards(t, f)) != len(filterNames) {
+		t.Errorf("SetFilter(\"\") did not clear the filter")
+	}
+	f.MustSetFilter("AMOUNT > 100")
+	f.ClearFilter()
+	if len(forwards(t, f)) != len(filterNames) {
+		t.Errorf("ClearFilter did not clear the filter")
+	}
+}
+
+func TestSetFilterErrors(t *testing.T) {
+	f, path := createTagTable(t, filterNames...)
+	defer f.Close()
+
+	tests := []struct {
+		source string
+		want   string
+	}{
+		{"NAME", "is not a logical expression"},
+		{"AMOUNT >", "syntax error"},
+		{"NOSUCH = 1", "unknown field NOSUCH"},
+	}
+	for _, tt := range tests {
+		if err := f.SetFilter(tt.source); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("SetFilter(%q) error = %v, want %q", tt.source, err, tt.want)
+		}
+	}
+	if f.Filter() != "" {
+		t.Errorf("a failed SetFilter left filter %q", f.Filter())
+	}
+
+	// Closing the table clears its filter
+	f.MustSetFilter("AMOUNT > 100")
+	f.Close()
+	f.MustOpen(path)
+	if f.Filter() != "" || len(forwards(t, f)) != len(filterNames) {
+		t.Errorf("filter %q survived Close", f.Filter())
+	}
+}
+
+// TestSetFilterPrefix checks that a filter on a constant prefix of the
+// selected tag's key only reads the records with that prefix
+func TestSetFilterPrefix(t *testing.T) {
+	f, _ := createTagTable(t, filterNames...)
+	defer f.Close()
+	f.Indexes().MustCreateTag("name", "UPPER(NAME)", "", false, false)
+	f.Indexes().MustCreateTag("amount", "STR(AMOUNT, 5)", "", false, false)
+
+	reads := 0
+	if err := expr.RegisterFunc("FILTER_READ", func([]any) (any, error) {
+		reads++
+		return true, nil
+	}, nil, expr.Logical); err != nil {
+		t.Fatal(err)
+	}
+	f.MustSetFilter(`FILTER_READ() .AND. upper(name) = "SM" .AND. AMOUNT <> 7`)
+
+	tests := []struct {
+		tag   string
+		want  []string
+		reads int
+	}{
+		{"name", []string{"SMALL", "Smith", "smythe"}, 4}, // Smart fails the filter
+		{"amount", []string{"Smith", "smythe", "SMALL"}, 9},
+		{"", []string{"Smith", "smythe", "SMALL"}, 9},
+	}
+	for _, tt := range tests {
+		if tt.tag == "" {
+			f.Indexes().SelectTag(nil)
+		} else {
+			f.Indexes().MustSelectTag(f.Indexes().TagByName(tt.tag))
+		}
+		reads = 0
+		if got := forwards(t, f); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("tag %q: order = %v, want %v", tt.tag, got, tt.want)
+		}
+		if reads > tt.reads {
+			t.Errorf("tag %q: %d records read, want at most %d", tt.tag, reads, tt.reads)
+		}
+	}
+
+	f.Indexes().MustSelectTag(f.Indexes().TagByName("name"))
+	reads = 0
+	if got := backwards(t, f); !reflect.DeepEqual(got, []string{"smythe", "Smith", "SMALL"}) {
+		t.Errorf("reverse order = %v", got)
+	}
+	if reads > 4 {
+		t.Errorf("%d records read backwards, want at most 4", reads)
+	}
+
+	// Moving on from records outside the prefix enters it
+	f.MustGoto(2) // adams
+	f.MustNext()
+	if f.Position() != 5 {
+		t.Errorf("Next from adams reached record %d, want 5 (SMALL)", f.Position())
+	}
+	f.MustGoto(9) // turner
+	f.MustPrevious()
+	if f.Position() != 3 {
+		t.Errorf("Previous from turner reached record %d, want 3 (smythe)", f.Position())
+	}
+	f.MustGoto(6) // jones
+	f.MustSkip(-1)
+	if !f.BOF() {
+		t.Errorf("Skip(-1) from jones reached record %d, want BOF", f.Position())
+	}
+	f.MustGoto(3) // smythe
+	f.MustNext()
+	if !f.EOF() {
+		t.Errorf("Next from smythe reached record %d, want EOF", f.Position())
+	}
+
+	// Iterators stay within the prefix too
+	var prefixed []int
+	for rec, err := range f.Indexes().TagByName("name").Prefix("S") {
+		if err != nil {
+			t.Fatal(err)
+		}
+		prefixed = append(prefixed, rec.Number())
+	}
+	if !reflect.DeepEqual(prefixed, []int{5, 1, 3}) {
+		t.Errorf("Prefix(S) = %v, want [5 1 3]", prefixed)
+	}
+}
@@ -0,0 +1,497 @@
+package tests
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mkfoss/foxi"
+	"github.com/mkfoss/foxi/expr"
+)
+
+// sampleDir holds the tables and indexes shipped with the CodeBase sources
+var sampleDir = filepath.Join("..", "pkg", "cgocore", "mkfdbflib", "data")
+
+// staleSampleTags are sample tags whose keys no longer match their tables:
+// the records were edited without the index being updated, or hold
+// numbers Visual FoxPro would not write
+var staleSampleTags = map[string]bool{
+	"enroll.ENR_MARK":    true, // MARK holds "0   . "
+	"example.ID":         true, // record 4 changed after indexing
+	"example.NOTDELETED": true, // a deleted record recalled after indexing
+	"invent.ITEM":        true, // ITEM widened after indexing
+}
+
+// copySample copies a sample table with its index and memo file to a
+// temporary directory
+func copySample(t *testing.T, name string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for _, ext := range []string{".dbf", ".cdx", ".fpt"} {
+		src, err := os.Open(filepath.Join(sampleDir, name+ext))
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		dst, err := os.Create(filepath.Join(dir, name+ext))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.Copy(dst, src); err != nil {
+			t.Fatal(err)
+		}
+		src.Close()
+		dst.Close()
+	}
+	return filepath.Join(dir, name+".dbf")
+}
+
+// tagKeys returns the record numbers and keys of a tag in tag order
+func tagKeys(t *testing.T, f *foxi.Foxi, tag foxi.Tag) ([]int, []string) {
+	t.Helper()
+	f.Indexes().MustSelectTag(tag)
+	defer f.Indexes().SelectTag(nil)
+
+	var records []int
+	var keys []string
+	for f.MustFirst(); !f.EOF(); f.MustNext() {
+		records = append(records, f.Position())
+		keys = append(keys, tag.CurrentKey())
+	}
+	return records, keys
+}
+
+// TestSampleIndexKeys checks the keys built by compiled expressions and by
+// reindexing against the keys Visual FoxPro stored in the sample indexes
+func TestSampleIndexKeys(t *testing.T) {
+	cdxs, err := filepath.Glob(filepath.Join(sampleDir, "*.cdx"))
+	if err != nil || len(cdxs) == 0 {
+		t.Fatalf("no sample indexes found: %v", err)
+	}
+
+	checked := 0
+	for _, cdx := range cdxs {
+		name := strings.TrimSuffix(filepath.Base(cdx), ".cdx")
+		if _, err := os.Stat(filepath.Join(sampleDir, name+".dbf")); err != nil {
+			continue // an index without its table
+		}
+
+		f := foxi.NewFoxi()
+		f.MustOpen(copySample(t, name))
+		f.Indexes().MustLoad()
+
+		stored := map[string][]string{}
+		for _, tag := range f.Indexes().Tags() {
+			id := name + "." + tag.Name()
+			prog, err := foxi.Compile(f, tag.Expression())
+			if err != nil {
+				// test.cdx belongs to another version of its table
+				if name != "test" {
+					t.Errorf("%s: Compile(%s) failed: %v", id, tag.Expression(), err)
+				}
+				continue
+			}
+			if staleSampleTags[id] {
+				continue
+			}
+			if prog.KeyLen() != tag.KeyLength() {
+				t.Errorf("%s: KeyLen = %d, stored %d", id, prog.KeyLen(), tag.KeyLength())
+				continue
+			}
+
+			records, keys := tagKeys(t, f, tag)
+			for i, record := range records {
+				f.MustGoto(record)
+				key, err := prog.Key()
+				if err != nil {
+					t.Errorf("%s: record %d: Key failed: %v", id, record, err)
+				} else if string(key) != keys[i] {
+					t.Errorf("%s: record %d key %q, stored %q", id, record, key, keys[i])
+				}
+			}
+			stored[tag.Name()] = keys
+			checked++
+		}
+
+		// Reindexing computes the same keys as Visual FoxPro did
+		f.Indexes().MustReindex()
+		for _, tag := range f.Indexes().Tags() {
+			want, ok := stored[tag.Name()]
+			if !ok {
+				continue
+			}
+			if _, got := tagKeys(t, f, tag); !reflect.DeepEqual(got, want) {
+				t.Errorf("%s.%s: keys after Reindex %q, stored %q", name, tag.Name(), got, want)
+			}
+		}
+		f.Close()
+	}
+	if checked < 40 {
+		t.Errorf("checked %d sample tags, want at least 40", checked)
+	}
+}
+
+// TestFunctionTags indexes a table on expressions with the functions found
+// in real tag and filter expressions, and checks the stored keys and the
+// order and seeks they give
+func TestFunctionTags(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "orders.dbf")
+	schema := foxi.Schema{
+		Fields: []foxi.FieldSpec{
+			{Name: "CUSTOMER", Type: foxi.FTCharacter, Size: 12},
+			{Name: "CODE", Type: foxi.FTCharacter, Size: 6},
+			{Name: "QTY", Type: foxi.FTInteger},
+			{Name: "PRICE", Type: foxi.FTNumeric, Size: 8, Decimals: 2},
+			{Name: "ORDERED", Type: foxi.FTDate},
+			{Name: "SHIPPED", Type: foxi.FTDate, Nullable: true},
+		},
+		Tags: []foxi.TagSpec{
+			{Name: "cust", Expression: "UPPER(PADR(ALLTRIM(CUSTOMER), 12))"},
+			{Name: "code", Expression: "PADL(ALLTRIM(CODE), 6, '0')"},
+			{Name: "qty", Expression: "BINTOC(QTY)"},
+			{Name: "month", Expression: "STR(YEAR(ORDERED), 4) + STR(MONTH(ORDERED), 2)"},
+			{Name: "ordered", Expression: "DTOC(ORDERED, 1) + TRANSFORM(PRICE, '99999.99')"},
+			{Name: "shipped", Expression: "NVL(SHIPPED, {})"},
+			{Name: "open", Expression: "CUSTOMER", Filter: "ISNULL(SHIPPED) .OR. EMPTY(SHIPPED)"},
+			{Name: "xlate", Expression: "SYS(15, 'ABCDEFGHIJKLMNOPQRSTUVWXYZ', CODE)"},
+			{Name: "big", Expression: "IIF(PRICE >= 100, 'B', 'S') + SUBSTR(CODE, 2, 3)", Filter: "!DELETED()"},
+		},
+	}
+	f, err := foxi.Create(path, schema, nil)
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	defer f.Close()
+
+	day := func(y, m, d int) time.Time { return time.Date(y, time.Month(m), d, 0, 0, 0, 0, time.UTC) }
+	rows := []struct {
+		customer, code string
+		qty            int
+		price          float64
+		ordered        time.Time
+		shipped        time.Time
+		null           bool
+	}{
+		{"  smith", "42", 7, 99.5, day(2023, 11, 5), day(2023, 11, 9), false},
+		{"Jones", "A17", -3, 120, day(2024, 2, 29), time.Time{}, true},
+		{"adams ", "9", 250, 5.25, day(2023, 1, 31), time.Time{}, false},
+		{"Baker", "b5", 0, 1000, day(2024, 2, 1), day(2024, 2, 2), false},
+	}
+	for _, r := range rows {
+		f.MustAppend()
+		f.FieldByName("customer").MustSetString(r.customer)
+		f.FieldByName("code").MustSetString(r.code)
+		f.FieldByName("qty").MustSetInt(r.qty)
+		f.FieldByName("price").MustSetFloat(r.price)
+		f.FieldByName("ordered").MustSetTime(r.ordered)
+		if r.null {
+			f.FieldByName("shipped").MustSetNull()
+		} else {
+			f.FieldByName("shipped").MustSetTime(r.shipped)
+		}
+		f.MustWrite()
+	}
+
+	// Keys stored while appending match the keys of the compiled expressions
+	for _, spec := range schema.Tags {
+		tag := f.Indexes().TagByName(spec.Name)
+		prog := foxi.MustCompile(f, spec.Expression)
+		if prog.KeyLen() != tag.KeyLength() {
+			t.Errorf("%s: KeyLen = %d, tag %d", spec.Name, prog.KeyLen(), tag.KeyLength())
+		}
+		records, keys := tagKeys(t, f, tag)
+		for i, record := range records {
+			f.MustGoto(record)
+			if key, err := prog.Key(); err != nil || string(key) != keys[i] {
+				t.Errorf("%s: record %d key %q (%v), tag %q", spec.Name, record, key, err, keys[i])
+			}
+		}
+	}
+
+	order := func(tagName string) []int {
+		records, _ := tagKeys(t, f, f.Indexes().TagByName(tagName))
+		return records
+	}
+	tests := []struct {
+		tag  string
+		want []int
+	}{
+		{"cust", []int{3, 4, 2, 1}},    // ADAMS, BAKER, JONES, SMITH
+		{"code", []int{3, 1, 4, 2}},    // 000009, 000042, 0000b5, 000A17 by byte
+		{"qty", []int{2, 4, 1, 3}},     // -3, 0, 7, 250
+		{"month", []int{3, 1, 2, 4}},   // 2023 1, 2023 11, 2024 2 twice
+		{"ordered", []int{3, 1, 4, 2}}, // by date
+		{"shipped", []int{2, 3, 1, 4}}, // null and blank first
+		{"open", []int{2, 3}},          // Jones, adams; the others are shipped
+		{"big", []int{2, 4, 3, 1}},     // BA17, Bb5, S, S42
+	}
+	for _, tt := range tests {
+		if got := order(tt.tag); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s order = %v, want %v", tt.tag, got, tt.want)
+		}
+	}
+
+	// Keys of known values
+	qty := f.Indexes().TagByName("qty")
+	f.Indexes().MustSelectTag(qty)
+	minusThree, err := foxi.MustCompile(f, "BINTOC(-3)").Eval()
+	if err != nil {
+		t.Fatalf("Eval(BINTOC(-3)) failed: %v", err)
+	}
+	if result := qty.MustSeekString(minusThree.(string)); result != foxi.SeekSuccess || f.Position() != 2 {
+		t.Errorf("seek BINTOC(-3) = %v at record %d, want record 2", result, f.Position())
+	}
+	code := f.Indexes().TagByName("code")
+	f.Indexes().MustSelectTag(code)
+	if result := code.MustSeekString("000009"); result != foxi.SeekSuccess || f.Position() != 3 {
+		t.Errorf("seek 000009 = %v at record %d, want record 3", result, f.Position())
+	}
+	// The table only translates the control characters 1 to 26
+	xlate := f.Indexes().TagByName("xlate")
+	f.Indexes().MustSelectTag(xlate)
+	if result := xlate.MustSeekString("b5"); result != foxi.SeekSuccess || f.Position() != 4 {
+		t.Errorf("seek b5 = %v at record %d, want record 4", result, f.Position())
+	}
+	f.Indexes().SelectTag(nil)
+}
+
+func TestExprFunctions(t *testing.T) {
+	day := func(y, m, d int) time.Time { return time.Date(y, time.Month(m), d, 0, 0, 0, 0, time.UTC) }
+	stamp := func(y, m, d, h, mi, s int) time.Time { return time.Date(y, time.Month(m), d, h, mi, s, 0, time.UTC) }
+
+	tests := []struct {
+		source string
+		want   any
+	}{
+		// Padding and building strings
+		{`PADL("ab", 5)`, "   ab"},
+		{`PADR("ab", 5, "*")`, "ab***"},
+		{`PADC("ab", 5, "-")`, "-ab--"},
+		{`PADL("abcdef", 3)`, "abc"},
+		{`PADL(AGE, 5, "0")`, "00042"},
+		{`PADR(HIRED, 10)`, "02/28/20  "},
+		{`SPACE(3) + "x"`, "   x"},
+		{`REPLICATE("ab", 3)`, "ababab"},
+		{`STUFF("abcdef", 2, 3, "XY")`, "aXYef"},
+		{`STRTRAN("a-b-c", "-", "+")`, "a+b+c"},
+		{`STRTRAN("a-b-c-d", "-", "+", 2, 1)`, "a-b+c-d"},
+		{`STRTRAN("aAa", "a", "x", 1, -1, 1)`, "xxx"},
+		{`STRTRAN("a-b", "-")`, "ab"},
+		{`CHRTRAN("abcabc", "ab", "X")`, "XcXc"},
+		{`PROPER("hello wORLD")`, "Hello World"},
+		{`CHR(65) + CHR(233)`, "Aé"},
+
+		// Searching and testing strings
+		{`AT("b", "abcabc")`, 2.0},
+		{`AT("b", "abcabc", 2)`, 5.0},
+		{`AT("x", "abc")`, 0.0},
+		{`ATC("B", "abc")`, 2.0},
+		{`RAT("b", "abcabc")`, 5.0},
+		{`OCCURS("a", "banana")`, 3.0},
+		{`LEN(NAME)`, 10.0},
+		{`LEN(ALLTRIM(NAME))`, 5.0},
+		{`ASC("A")`, 65.0},
+		{`ASC("é")`, 233.0},
+		{`ISALPHA("a1")`, true},
+		{`ISDIGIT("a1")`, false},
+		{`ISUPPER(NAME)`, true},
+		{`ISLOWER(NAME)`, false},
+		{`LIKE("Sm*", ALLTRIM(NAME))`, true},
+		{`LIKE("S?ith", "Smith")`, true},
+		{`LIKE("S?ith", "Smiith")`, false},
+
+		// Numbers
+		{`ABS(-2.5)`, 2.5},
+		{`INT(-2.7)`, -2.0},
+		{`CEILING(2.1)`, 3.0},
+		{`FLOOR(-2.1)`, -3.0},
+		{`ROUND(2.345, 2)`, 2.35},
+		{`ROUND(-2.5, 0)`, -3.0},
+		{`ROUND(1234, -2)`, 1200.0},
+		{`MOD(-7, 3)`, 2.0},
+		{`SIGN(-4)`, -1.0},
+		{`SQRT(16)`, 4.0},
+		{`LOG10(1000)`, 3.0},
+		{`MAX(1, AGE, 7)`, 42.0},
+		{`MIN("b", "a", "c")`, "a"},
+		{`MAX(HIRED, {^2021-01-01})`, day(2021, 1, 1)},
+
+		// Binary keys
+		{`BINTOC(0)`, "\u0080\x00\x00\x00"},
+		{`BINTOC(-1)`, "\u007fÿÿÿ"},
+		{`BINTOC(1, 2)`, "\u0080\x01"},
+		{`BINTOC(1, "1")`, "\u0081"},
+		{`BINTOC(1, "RS")`, "\x01\x00\x00\x00"},
+		{`CTOBIN(BINTOC(-123456))`, -123456.0},
+		{`CTOBIN(BINTOC(-5, 2))`, -5.0},
+		{`CTOBIN(BINTOC(2.5, "B"), "B")`, 2.5},
+		{`CTOBIN(BINTOC(-2.5, "F"), "F")`, -2.5},
+		{`BINTOC(-1) < BINTOC(0) .AND. BINTOC(0) < BINTOC(300)`, true},
+
+		// TRANSFORM
+		{`TRANSFORM(PAY)`, "1234.5"},
+		{`TRANSFORM(PAY, "99,999.99")`, " 1,234.50"},
+		{`TRANSFORM(12, "99,999")`, "    12"},
+		{`TRANSFORM(-12.5, "999.9")`, "-12.5"},
+		{`TRANSFORM(123456, "9999")`, "****"},
+		{`TRANSFORM(42, "@L 99999")`, "00042"},
+		{`TRANSFORM(0, "@Z 999")`, "   "},
+		{`TRANSFORM("5551234567", "@R (999) 999-9999")`, "(555) 123-4567"},
+		{`TRANSFORM("abc", "@!")`, "ABC"},
+		{`TRANSFORM("abc", "!XX")`, "Abc"},
+		{`TRANSFORM(OK)`, ".T."},
+		{`TRANSFORM(HIRED)`, "02/28/20"},
+
+		// Dates and times
+		{`DTOC(HIRED)`, "02/28/20"},
+		{`DTOC(HIRED, 1)`, "20200228"},
+		{`DTOC({})`, "  /  /  "},
+		{`CTOD("02/29/2024")`, day(2024, 2, 29)},
+		{`CTOD("12/31/99")`, day(1999, 12, 31)},
+		{`CTOD("03/15/24")`, day(2024, 3, 15)},
+		{`CTOD("01/01/50")`, day(1950, 1, 1)},
+		{`CTOD("12/31/49")`, day(2049, 12, 31)},
+		{`CTOD(DTOC(HIRED)) = HIRED`, true},
+		{`CTOD(DTOC({^2024-03-01})) = {^2024-03-01}`, true},
+		{`CTOT(TTOC({^2024-01-31 13:45:10})) = {^2024-01-31 13:45:10}`, true},
+		{`CTOD("^2024-03-01")`, day(2024, 3, 1)},
+		{`CTOD("02/30/2024")`, time.Time{}},
+		{`CTOT("01/31/2024 01:45:10 PM")`, stamp(2024, 1, 31, 13, 45, 10)},
+		{`CTOT("2024-01-31T13:45:10")`, stamp(2024, 1, 31, 13, 45, 10)},
+		{`CTOT("01/31/2024 12:00 AM")`, stamp(2024, 1, 31, 0, 0, 0)},
+		{`TTOC({^2024-01-31 13:45:10})`, "01/31/24 01:45:10 PM"},
+		{`TTOC({^2024-01-31 13:45:10}, 1)`, "20240131134510"},
+		{`TTOC({^2024-01-31 13:45:10}, 2)`, "01:45:10 PM"},
+		{`TTOC({^2024-01-31 13:45:10}, 3)`, "2024-01-31T13:45:10"},
+		{`DTOT(HIRED)`, stamp(2020, 2, 28, 0, 0, 0)},
+		{`TTOD({^2024-01-31 13:45:10})`, day(2024, 1, 31)},
+		{`YEAR(HIRED)`, 2020.0},
+		{`MONTH(HIRED)`, 2.0},
+		{`DAY(HIRED)`, 28.0},
+		{`YEAR({})`, 0.0},
+		{`QUARTER({^2024-08-01})`, 3.0},
+		{`HOUR({^2024-01-31 13:45:10})`, 13.0},
+		{`MINUTE({^2024-01-31 13:45:10})`, 45.0},
+		{`SEC({^2024-01-31 13:45:10})`, 10.0},
+		{`DOW(HIRED)`, 6.0},
+		{`DOW(HIRED, 2)`, 5.0},
+		{`CDOW(HIRED)`, "Friday"},
+		{`CMONTH(HIRED)`, "February"},
+		{`GOMONTH({^2024-01-31}, 1)`, day(2024, 2, 29)},
+		{`GOMONTH({^2024-03-31}, -13)`, day(2023, 2, 28)},
+		{`GOMONTH({^2024-01-31 10:00}, 2)`, stamp(2024, 3, 31, 10, 0, 0)},
+		{`DATE(2024, 2, 29)`, day(2024, 2, 29)},
+		{`DATETIME(2024, 2, 29, 8, 30)`, stamp(2024, 2, 29, 8, 30, 0)},
+
+		// Logic, nulls and lists
+		{`EMPTY(SPACE(3))`, true},
+		{`EMPTY(0)`, true},
+		{`EMPTY({})`, true},
+		{`EMPTY(.NULL.)`, false},
+		{`EMPTY(NAME)`, false},
+		{`ISNULL(.NULL.)`, true},
+		{`ISNULL(AGE)`, false},
+		{`NVL(.NULL., 5)`, 5.0},
+		{`NVL(AGE, 5)`, 42.0},
+		{`EVL(0, 5)`, 5.0},
+		{`EVL("", "none")`, "none"},
+		{`INLIST(AGE, 1, 42, 3)`, true},
+		{`INLIST(NAME, "Jones", "Smith")`, true},
+		{`INLIST(HIRED, {^2020-02-28})`, true},
+		{`BETWEEN(AGE, 40, 50)`, true},
+		{`BETWEEN(AGE, 43, 50)`, false},
+		{`ICASE(AGE < 18, "minor", AGE < 65, "adult", "senior")`, "adult"},
+		{`ICASE(AGE < 18, "minor")`, nil},
+		{`SYS(15, "BCD", CHR(1) + CHR(3) + "x")`, "BDx"},
+	}
+
+	env := testEnv()
+	for _, tt := range tests {
+		prog, err := expr.Compile(tt.source, env)
+		if err != nil {
+			t.Errorf("Compile(%s) failed: %v", tt.source, err)
+			continue
+		}
+		got, err := prog.Eval()
+		if err != nil {
+			t.Errorf("Eval(%s) failed: %v", tt.source, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Eval(%s) = %#v, want %#v", tt.source, got, tt.want)
+		}
+	}
+}
+
+func TestExprFunctionTypes(t *testing.T) {
+	tests := []struct {
+		source string
+		typ    expr.Type
+		length int
+	}{
+		{`PADL(NAME, 15)`, expr.Character, 15},
+		{`PADR(AGE, 6)`, expr.Character, 6},
+		{`SPACE(4)`, expr.Character, 4},
+		{`REPLICATE(FIRST, 2)`, expr.Character, 12},
+		{`BINTOC(AGE)`, expr.Character, 4},
+		{`BINTOC(AGE, 2)`, expr.Character, 2},
+		{`BINTOC(AGE, "B")`, expr.Character, 8},
+		{`DTOC(HIRED)`, expr.Character, 8},
+		{`TTOC(DTOT(HIRED), 1)`, expr.Character, 14},
+		{`TRANSFORM(PAY, "999,999.99")`, expr.Character, 10},
+		{`CDOW(HIRED)`, expr.Character, 9},
+		{`SYS(15, "AB", NAME)`, expr.Character, 10},
+		{`NVL(NAME, FIRST)`, expr.Character, 10},
+		{`GOMONTH(HIRED, 1)`, expr.Date, 8},
+		{`YEAR(HIRED)`, expr.Numeric, 4},
+		{`EMPTY(NAME)`, expr.Logical, 1},
+	}
+	env := testEnv()
+	for _, tt := range tests {
+		prog := expr.MustCompile(tt.source, env)
+		if prog.Type() != tt.typ || prog.Len() != tt.length {
+			t.Errorf("%s: type %s length %d, want %s length %d", tt.source, prog.Type(), prog.Len(), tt.typ, tt.length)
+		}
+	}
+}
+
+func TestExprFunctionErrors(t *testing.T) {
+	tests := []struct {
+		source string
+		want   string
+	}{
+		{`PADL(NAME)`, "takes 2 to 3 arguments"},
+		{`PADL(NAME, "x")`, "argument 2 must be numeric"},
+		{`NVL(NAME, 1)`, "operator/operand type mismatch"},
+		{`MAX(NAME, AGE)`, "operator/operand type mismatch"},
+		{`INLIST(AGE, "a")`, "operator/operand type mismatch"},
+		{`ICASE(NAME, 1)`, "argument 1 must be logical"},
+		{`SYS(2007, NAME)`, "SYS(2007) is not supported"},
+		{`SYS(AGE)`, "argument 1 must be a numeric constant"},
+		{`SYS(15, NAME)`, "SYS(15) takes 3 arguments"},
+		{`BINTOC(AGE, 3)`, "size 3 is not 1, 2, 4 or 8"},
+		{`BINTOC(AGE, AGE)`, "argument 2 must be a constant"},
+		{`TTOC(DTOT(HIRED), 9)`, "format 9 is not supported"},
+		{`DATE(2024)`, "takes no arguments or at least 3"},
+		{`YEAR(NAME)`, "argument 1 must be date or datetime"},
+		{`HOUR(HIRED)`, "argument 1 must be datetime"},
+	}
+	env := testEnv()
+	for _, tt := range tests {
+		_, err := expr.Compile(tt.source, env)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("Compile(%q) error = %v, want %q", tt.source, err, tt.want)
+		}
+	}
+
+	for _, source := range []string{`BINTOC(300, 1)`, `SQRT(-1)`, `LOG(0)`, `MOD(AGE, 0)`, `CHR(256)`, `DATE(2024, 2, 30)`} {
+		if _, err := expr.MustCompile(source, env).Eval(); err == nil {
+			t.Errorf("Eval(%s) should fail", source)
+		}
+	}
+}
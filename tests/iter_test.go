@@ -0,0 +1,220 @@
+package tests
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/mkfoss/foxi"
+)
+
+var iterNames = []string{"MIKE", "ALICE", "BOB", "CAROL", "BOBBY", "DAVE", "ANNA", "BO", "EVE", "ALICE"}
+
+// createIterTable creates a table holding iterNames with AMOUNT set to
+// ten times the record number, tagged on both and descending on AMOUNT
+func createIterTable(t *testing.T) *foxi.Foxi {
+	t.Helper()
+	rows := make([]row, 0, len(iterNames))
+	for i, name := range iterNames {
+		rows = append(rows, row{"NAME": name, "AMOUNT": (i + 1) * 10})
+	}
+	f, _ := createTable(t, "iter", foxi.Schema{
+		Fields: []foxi.FieldSpec{
+			{Name: "NAME", Type: foxi.FTCharacter, Size: 10},
+			{Name: "AMOUNT", Type: foxi.FTNumeric, Size: 6},
+		},
+		Tags: []foxi.TagSpec{
+			{Name: "name", Expression: "NAME"},
+			{Name: "amount", Expression: "AMOUNT"},
+			{Name: "amountd", Expression: "AMOUNT", Descending: true},
+		},
+	}, nil, rows...)
+	return f
+}
+
+// collect runs an iterator and lists the NAME and record number of each
+// record
+func collect(t *testing.T, seq func(func(foxi.Record, error) bool)) []string {
+	t.Helper()
+
+	var got []string
+	for rec, err := range seq {
+		if err != nil {
+			t.Fatalf("iteration failed: %v", err)
+		}
+		name := strings.TrimSpace(rec.Field("name").MustAsString())
+		got = append(got, fmt.Sprintf("%s/%d", name, rec.Number()))
+	}
+	return got
+}
+
+// expectNames lists the records whose NAME passes keep, sorted by name
+// and record number
+func expectNames(keep func(name string) bool, reverse bool) []string {
+	type entry struct {
+		name  string
+		recNo int
+	}
+	var entries []entry
+	for i, name := range iterNames {
+		if keep(name) {
+			entries = append(entries, entry{name, i + 1})
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].name != entries[j].name {
+			return entries[i].name < entries[j].name
+		}
+		return entries[i].recNo < entries[j].recNo
+	})
+	want := []string{}
+	for _, e := range entries {
+		want = append(want, fmt.Sprintf("%s/%d", e.name, e.recNo))
+	}
+	if reverse {
+		for i, j := 0, len(want)-1; i < j; i, j = i+1, j-1 {
+			want[i], want[j] = want[j], want[i]
+		}
+	}
+	return want
+}
+
+func equalOrEmpty(got, want []string) bool {
+	return len(got) == 0 && len(want) == 0 || reflect.DeepEqual(got, want)
+}
+
+func TestRecords(t *testing.T) {
+	f := createIterTable(t)
+	defer f.Close()
+
+	var want []string
+	for i, name := range iterNames {
+		want = append(want, fmt.Sprintf("%s/%d", name, i+1))
+	}
+	if got := collect(t, f.Records()); !reflect.DeepEqual(got, want) {
+		t.Errorf("Records = %v, want %v", got, want)
+	}
+
+	// The selected tag sets the order
+	f.Indexes().MustSelectTag(f.Indexes().TagByName("name"))
+	all := func(string) bool { return true }
+	if got, want := collect(t, f.Records()), expectNames(all, false); !reflect.DeepEqual(got, want) {
+		t.Errorf("Records by name = %v, want %v", got, want)
+	}
+	if got, want := collect(t, f.RecordsReverse()), expectNames(all, true); !reflect.DeepEqual(got, want) {
+		t.Errorf("RecordsReverse by name = %v, want %v", got, want)
+	}
+
+	// Stopping early leaves the table on the record reached
+	f.Indexes().MustSelectTag(nil)
+	count := 0
+	for rec, err := range f.Records() {
+		if err != nil {
+			t.Fatal(err)
+		}
+		if count++; count == 3 {
+			if rec.Number() != 3 || f.Position() != 3 {
+				t.Errorf("stopped on record %d, table on %d, want 3", rec.Number(), f.Position())
+			}
+			break
+		}
+	}
+}
+
+func TestTagRange(t *testing.T) {
+	f := createIterTable(t)
+	defer f.Close()
+	tag := f.Indexes().TagByName("name")
+
+	tests := []struct {
+		lo, hi interface{}
+		keep   func(string) bool
+	}{
+		{"B", "C", func(n string) bool { return n >= "B" && n < "D" }},
+		{"BOB", "CAROL", func(n string) bool { return n >= "BOB" && n <= "CAROL" }},
+		{"BOB", "BOB", func(n string) bool { return strings.HasPrefix(n, "BOB") }},
+		{"ALICE", "BZ", func(n string) bool { return n >= "ALICE" && n < "BZ" }},
+		{nil, "BO", func(n string) bool { return n < "BO" || strings.HasPrefix(n, "BO") }},
+		{"D", nil, func(n string) bool { return n >= "D" }},
+		{"CA", "CZ", func(n string) bool { return n >= "CA" && n < "CZ" }},
+		{"N", "Z", func(string) bool { return false }},
+		{"D", "B", func(string) bool { return false }},
+		{nil, nil, func(string) bool { return true }},
+	}
+	for _, tt := range tests {
+		if got, want := collect(t, tag.Range(tt.lo, tt.hi)), expectNames(tt.keep, false); !equalOrEmpty(got, want) {
+			t.Errorf("Range(%v, %v) = %v, want %v", tt.lo, tt.hi, got, want)
+		}
+		if got, want := collect(t, tag.RangeReverse(tt.lo, tt.hi)), expectNames(tt.keep, true); !equalOrEmpty(got, want) {
+			t.Errorf("RangeReverse(%v, %v) = %v, want %v", tt.lo, tt.hi, got, want)
+		}
+	}
+}
+
+func TestTagPrefix(t *testing.T) {
+	f := createIterTable(t)
+	defer f.Close()
+	tag := f.Indexes().TagByName("name")
+
+	for _, prefix := range []string{"BO", "A", "ALICE", "E", "EVE", "C", "Z", "AB", ""} {
+		keep := func(n string) bool { return strings.HasPrefix(n, prefix) }
+		if got, want := collect(t, tag.Prefix(prefix)), expectNames(keep, false); !equalOrEmpty(got, want) {
+			t.Errorf("Prefix(%q) = %v, want %v", prefix, got, want)
+		}
+		if got, want := collect(t, tag.PrefixReverse(prefix)), expectNames(keep, true); !equalOrEmpty(got, want) {
+			t.Errorf("PrefixReverse(%q) = %v, want %v", prefix, got, want)
+		}
+	}
+}
+
+func TestTagRangeNumeric(t *testing.T) {
+	f := createIterTable(t)
+	defer f.Close()
+
+	amounts := func(seq func(func(foxi.Record, error) bool)) []int {
+		var got []int
+		for rec, err := range seq {
+			if err != nil {
+				t.Fatalf("iteration failed: %v", err)
+			}
+			got = append(got, rec.Field("amount").MustAsInt())
+		}
+		return got
+	}
+
+	tag := f.Indexes().TagByName("amount")
+	if got, want := amounts(tag.Range(30, 60)), []int{30, 40, 50, 60}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Range(30, 60) = %v, want %v", got, want)
+	}
+	if got, want := amounts(tag.Range(25, 65)), []int{30, 40, 50, 60}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Range(25, 65) = %v, want %v", got, want)
+	}
+	if got, want := amounts(tag.RangeReverse(25, 60)), []int{60, 50, 40, 30}; !reflect.DeepEqual(got, want) {
+		t.Errorf("RangeReverse(25, 60) = %v, want %v", got, want)
+	}
+	if got, want := amounts(tag.RangeReverse(85, nil)), []int{100, 90}; !reflect.DeepEqual(got, want) {
+		t.Errorf("RangeReverse(85, nil) = %v, want %v", got, want)
+	}
+
+	// A descending tag walks from high to low, so lo is the larger value
+	desc := f.Indexes().TagByName("amountd")
+	if got, want := amounts(desc.Range(60, 30)), []int{60, 50, 40, 30}; !reflect.DeepEqual(got, want) {
+		t.Errorf("descending Range(60, 30) = %v, want %v", got, want)
+	}
+	if got, want := amounts(desc.RangeReverse(60, 30)), []int{30, 40, 50, 60}; !reflect.DeepEqual(got, want) {
+		t.Errorf("descending RangeReverse(60, 30) = %v, want %v", got, want)
+	}
+}
+
+func TestRecordsError(t *testing.T) {
+	f := foxi.NewFoxi()
+	var err error
+	for _, err = range f.Records() {
+		break
+	}
+	if err == nil {
+		t.Error("expected error iterating a closed table")
+	}
+}
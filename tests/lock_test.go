@@ -0,0 +1,522 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd || dragonfly
+
+package tests
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/mkfoss/foxi"
+	pkg "github.com/mkfoss/foxi/pkg/gocore"
+)
+
+// Visual FoxPro lock offsets
+const (
+	vfpLockPos    = 0x7FFFFFFE
+	vfpLockPosOld = 0x40000000
+)
+
+// TestLockHelperProcess is not a real test: it is the second process of the
+// locking tests. It opens the table named by FOXI_LOCK_TABLE, performs
+// FOXI_LOCK_ACTION, reports on stdout and holds its locks until stdin closes.
+func TestLockHelperProcess(t *testing.T) {
+	path := os.Getenv("FOXI_LOCK_TABLE")
+	if path == "" {
+		t.Skip("helper process for the locking tests")
+	}
+
+	f := foxi.NewFoxi()
+	f.MustOpen(path)
+	action := os.Getenv("FOXI_LOCK_ACTION")
+	switch {
+	case strings.HasPrefix(action, "record:"):
+		recNo, _ := strconv.Atoi(strings.TrimPrefix(action, "record:"))
+		f.MustLockRecord(recNo)
+	case action == "append":
+		f.MustLockAppend()
+	case action == "file":
+		f.MustLockFile()
+	case action == "add":
+		f.MustAppend()
+		f.FieldByName("name").MustSetString("helper")
+		f.MustWrite()
+	case strings.HasPrefix(action, "probe:"):
+		recNo, _ := strconv.Atoi(strings.TrimPrefix(action, "probe:"))
+		f.SetLockRetry(1, 0)
+		if err := f.LockRecord(recNo); err != nil {
+			fmt.Println("locked")
+			os.Exit(0)
+		}
+	}
+	fmt.Println("ready")
+
+	io.Copy(io.Discard, os.Stdin)
+	f.Close()
+	os.Exit(0)
+}
+
+// startLockHelper runs TestLockHelperProcess in a separate process and
+// waits until it has performed its action. The returned function makes
+// the helper release its locks and exit.
+func startLockHelper(t *testing.T, path string, action string) (int, func()) {
+	t.Helper()
+
+	cmd := exec.Command(os.Args[0], "-test.run=^TestLockHelperProcess$")
+	cmd.Env = append(os.Environ(), "FOXI_LOCK_TABLE="+path, "FOXI_LOCK_ACTION="+action)
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		t.Fatalf("failed to create stdin pipe: %v", err)
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatalf("failed to create stdout pipe: %v", err)
+	}
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("failed to start helper: %v", err)
+	}
+
+	line, err := bufio.NewReader(stdout).ReadString('\n')
+	if err != nil || strings.TrimSpace(line) != "ready" {
+		stdin.Close()
+		cmd.Wait()
+		t.Fatalf("helper failed (%q): %v", line, err)
+	}
+
+	var once sync.Once
+	release := func() {
+		once.Do(func() {
+			stdin.Close()
+			if err := cmd.Wait(); err != nil {
+				t.Errorf("helper exited with error: %v", err)
+			}
+		})
+	}
+	t.Cleanup(release)
+	return cmd.Process.Pid, release
+}
+
+// probeLock reports whether another process can lock a record of a table
+func probeLock(t *testing.T, path string, recNo int) bool {
+	t.Helper()
+
+	cmd := exec.Command(os.Args[0], "-test.run=^TestLockHelperProcess$")
+	cmd.Env = append(os.Environ(), "FOXI_LOCK_TABLE="+path, fmt.Sprintf("FOXI_LOCK_ACTION=probe:%d", recNo))
+	out, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("helper failed: %v", err)
+	}
+	return strings.TrimSpace(string(out)) == "ready"
+}
+
+// lockOwner returns the process holding a write lock on a byte range of a
+// file, 0 if the range is not locked by another process
+func lockOwner(t *testing.T, path string, start int64, length int64) int {
+	t.Helper()
+
+	file, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatalf("failed to open %s: %v", path, err)
+	}
+	defer file.Close()
+
+	lock := syscall.Flock_t{Type: syscall.F_WRLCK, Start: start, Len: length}
+	if err := syscall.FcntlFlock(file.Fd(), syscall.F_GETLK, &lock); err != nil {
+		t.Fatalf("F_GETLK failed: %v", err)
+	}
+	if lock.Type == syscall.F_UNLCK {
+		return 0
+	}
+	return int(lock.Pid)
+}
+
+func createLockTable(t *testing.T) string {
+	t.Helper()
+	f, path := createTable(t, "locks", foxi.Schema{
+		Fields: []foxi.FieldSpec{
+			{Name: "NAME", Type: foxi.FTCharacter, Size: 20},
+		},
+	}, nil, row{"NAME": "alpha"}, row{"NAME": "beta"})
+	f.Close()
+	return path
+}
+
+func TestLockRecordAcrossProcesses(t *testing.T) {
+	path := createLockTable(t)
+	pid, release := startLockHelper(t, path, "record:2")
+
+	if owner := lockOwner(t, path, vfpLockPos-2, 1); owner != pid {
+		t.Errorf("record 2 lock byte owned by %d, want helper %d", owner, pid)
+	}
+
+	f := foxi.NewFoxi()
+	f.MustOpen(path)
+	defer f.Close()
+	f.SetLockRetry(1, 0)
+
+	if err := f.LockRecord(2); !errors.Is(err, foxi.ErrLocked) {
+		t.Errorf("LockRecord(2) = %v, want ErrLocked", err)
+	}
+	if err := f.LockFile(); !errors.Is(err, foxi.ErrLocked) {
+		t.Errorf("LockFile = %v, want ErrLocked", err)
+	}
+	if err := f.LockRecord(1); err != nil {
+		t.Errorf("LockRecord(1) failed: %v", err)
+	}
+	if err := f.LockAppend(); err != nil {
+		t.Errorf("LockAppend failed: %v", err)
+	}
+	f.MustUnlock()
+
+	release()
+	if err := f.LockRecord(2); err != nil {
+		t.Errorf("LockRecord(2) after release failed: %v", err)
+	}
+	if err := f.LockFile(); err != nil {
+		t.Errorf("LockFile after release failed: %v", err)
+	}
+}
+
+func TestLockFileAcrossProcesses(t *testing.T) {
+	path := createLockTable(t)
+	pid, _ := startLockHelper(t, path, "file")
+
+	if owner := lockOwner(t, path, vfpLockPosOld, vfpLockPos-vfpLockPosOld+1); owner != pid {
+		t.Errorf("file lock range owned by %d, want helper %d", owner, pid)
+	}
+
+	f := foxi.NewFoxi()
+	f.MustOpen(path)
+	defer f.Close()
+	f.SetLockRetry(2, 10*time.Millisecond)
+
+	if err := f.LockRecord(1); !errors.Is(err, foxi.ErrLocked) {
+		t.Errorf("LockRecord(1) = %v, want ErrLocked", err)
+	}
+	if err := f.LockAppend(); !errors.Is(err, foxi.ErrLocked) {
+		t.Errorf("LockAppend = %v, want ErrLocked", err)
+	}
+	if err := f.Append(); !errors.Is(err, foxi.ErrLocked) {
+		t.Errorf("Append = %v, want ErrLocked", err)
+	}
+}
+
+func TestLockRetryWaitsForRelease(t *testing.T) {
+	path := createLockTable(t)
+	_, release := startLockHelper(t, path, "append")
+
+	f := foxi.NewFoxi()
+	f.MustOpen(path)
+	defer f.Close()
+	f.SetLockRetry(-1, 10*time.Millisecond)
+
+	time.AfterFunc(200*time.Millisecond, release)
+	start := time.Now()
+	if err := f.LockAppend(); err != nil {
+		t.Fatalf("LockAppend failed: %v", err)
+	}
+	if waited := time.Since(start); waited < 150*time.Millisecond {
+		t.Errorf("lock granted after %v, before the helper released it", waited)
+	}
+}
+
+func TestAppendSeesOtherProcess(t *testing.T) {
+	path := createLockTable(t)
+
+	f := foxi.NewFoxi()
+	f.MustOpen(path)
+	defer f.Close()
+
+	// The helper appends after this process has read the header
+	_, release := startLockHelper(t, path, "add")
+	release()
+
+	f.MustAppend()
+	f.FieldByName("name").MustSetString("parent")
+	f.MustWrite()
+	f.Close()
+
+	f = foxi.NewFoxi()
+	f.MustOpen(path)
+	header := f.Header()
+	if count := header.RecordCount(); count != 4 {
+		t.Fatalf("expected 4 records, got %d", count)
+	}
+	for recNo, want := range map[int]string{3: "helper", 4: "parent"} {
+		f.MustGoto(recNo)
+		if got := strings.TrimSpace(f.FieldByName("name").MustAsString()); got != want {
+			t.Errorf("record %d NAME = %q, want %q", recNo, got, want)
+		}
+	}
+}
+
+func TestLockAcrossSessions(t *testing.T) {
+	path := createLockTable(t)
+
+	s1, s2 := foxi.NewSession(), foxi.NewSession()
+	defer s1.Close()
+	defer s2.Close()
+	s1.SetLockRetry(1, 0)
+	s2.SetLockRetry(1, 0)
+	f1 := s1.MustOpen(path)
+	// The same file under another path is the same table
+	f2 := s2.MustOpen(filepath.Dir(path) + "/./" + filepath.Base(path))
+
+	if err := f1.LockRecord(1); err != nil {
+		t.Fatalf("LockRecord(1) failed: %v", err)
+	}
+	if err := f2.LockRecord(1); !errors.Is(err, foxi.ErrLocked) {
+		t.Errorf("the other session's LockRecord(1) = %v, want ErrLocked", err)
+	}
+	if err := f2.LockFile(); !errors.Is(err, foxi.ErrLocked) {
+		t.Errorf("the other session's LockFile = %v, want ErrLocked", err)
+	}
+	if err := f2.LockRecord(2); err != nil {
+		t.Errorf("the other session's LockRecord(2) failed: %v", err)
+	}
+	if err := f1.LockAppend(); err != nil {
+		t.Errorf("LockAppend failed: %v", err)
+	}
+	if err := f2.Append(); !errors.Is(err, foxi.ErrLocked) {
+		t.Errorf("the other session's Append = %v, want ErrLocked", err)
+	}
+
+	// Unlocking in one session leaves the other's locks alone
+	f2.MustUnlock()
+	if err := f1.LockRecord(2); err != nil {
+		t.Errorf("LockRecord(2) after the other session unlocked failed: %v", err)
+	}
+	if err := f2.LockRecord(1); !errors.Is(err, foxi.ErrLocked) {
+		t.Errorf("Unlock in the other session released record 1: %v", err)
+	}
+
+	// The retries set through one table are those of its whole session
+	s2.SetLockRetry(50, 20*time.Millisecond)
+	f2.SetLockRetry(1, 0)
+	again := s2.MustOpenAs(path, "again")
+	start := time.Now()
+	if err := again.LockRecord(1); !errors.Is(err, foxi.ErrLocked) {
+		t.Errorf("LockRecord(1) through another table of the session = %v, want ErrLocked", err)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("LockRecord(1) retried for %v after SetLockRetry(1, 0)", elapsed)
+	}
+
+	// Closing the other session's handle keeps the locks of this one
+	// from other processes
+	s2.Close()
+	if probeLock(t, path, 1) {
+		t.Error("another process locked record 1 after the other session closed")
+	}
+	f1.MustUnlock()
+	if !probeLock(t, path, 1) {
+		t.Error("another process could not lock record 1 after Unlock")
+	}
+}
+
+func TestSessionLocks(t *testing.T) {
+	path := createLockTable(t)
+
+	s1, s2 := foxi.NewSession(), foxi.NewSession()
+	defer s1.Close()
+	defer s2.Close()
+	f1 := s1.MustOpenAs(path, "l")
+	f2 := s2.MustOpen(path)
+
+	if locks := s1.Locks(); len(locks) != 0 {
+		t.Errorf("Locks before locking = %v", locks)
+	}
+	f1.MustLockRecord(2)
+	f1.MustLockRecord(1)
+	f1.MustLockAppend()
+	want := []foxi.Lock{
+		{Table: "L", Kind: foxi.AppendLock},
+		{Table: "L", Kind: foxi.RecordLock, Record: 1},
+		{Table: "L", Kind: foxi.RecordLock, Record: 2},
+	}
+	if locks := s1.Locks(); !slices.Equal(locks, want) {
+		t.Errorf("Locks = %v, want %v", locks, want)
+	}
+	if locks := s2.Locks(); len(locks) != 0 {
+		t.Errorf("the other session reports locks %v", locks)
+	}
+
+	// The file lock takes in the record locks
+	f1.MustUnlock()
+	f1.MustLockFile()
+	want = []foxi.Lock{{Table: "L", Kind: foxi.FileLock}}
+	if locks := s1.Locks(); !slices.Equal(locks, want) {
+		t.Errorf("Locks under the file lock = %v, want %v", locks, want)
+	}
+
+	f1.MustUnlock()
+	f2.MustLockRecord(1)
+	if locks := s1.Locks(); len(locks) != 0 {
+		t.Errorf("Locks after Unlock = %v", locks)
+	}
+	want = []foxi.Lock{{Table: "LOCKS", Kind: foxi.RecordLock, Record: 1}}
+	if locks := s2.Locks(); !slices.Equal(locks, want) {
+		t.Errorf("the other session's Locks = %v, want %v", locks, want)
+	}
+}
+
+func TestCode4Locks(t *testing.T) {
+	path := createLockTable(t)
+
+	open := func() (*pkg.Code4, *pkg.Data4) {
+		codeBase := &pkg.Code4{}
+		pkg.Code4Init(codeBase)
+		codeBase.LockAttempts = 1
+		data := pkg.D4Open(codeBase, path)
+		if data == nil {
+			t.Fatalf("D4Open failed: %d", codeBase.ErrorCode)
+		}
+		t.Cleanup(func() { pkg.Code4InitUndo(codeBase) })
+		return codeBase, data
+	}
+	cb1, data1 := open()
+	cb2, data2 := open()
+
+	if rc := pkg.D4LockRecord(data1, 2); rc != pkg.ErrorNone {
+		t.Fatalf("D4LockRecord failed: %d", rc)
+	}
+	if rc := pkg.D4LockRecord(data2, 2); rc != pkg.R4Locked {
+		t.Errorf("D4LockRecord through the other CODE4 = %d, want R4Locked", rc)
+	}
+	if !pkg.D4LockTest(data1, 2) || pkg.D4LockTest(data2, 2) {
+		t.Error("D4LockTest reported the lock for the wrong CODE4")
+	}
+
+	status := pkg.Code4Locks(cb1).Status()
+	if len(status) != 1 {
+		t.Fatalf("first CODE4 reports %d locks, want 1", len(status))
+	}
+	for _, lock := range status {
+		if lock.LockType != pkg.LockRecord || lock.StartPos != vfpLockPos-2 || lock.Length != 1 {
+			t.Errorf("reported lock %+v", lock)
+		}
+	}
+	if status := pkg.Code4Locks(cb2).Status(); len(status) != 0 {
+		t.Errorf("second CODE4 reports locks %v", status)
+	}
+
+	pkg.D4Unlock(data1)
+	if len(pkg.Code4Locks(cb1).Status()) != 0 {
+		t.Error("D4Unlock left the lock reported")
+	}
+	if rc := pkg.D4LockRecord(data2, 2); rc != pkg.ErrorNone {
+		t.Errorf("D4LockRecord after the other CODE4 unlocked = %d", rc)
+	}
+}
+
+func TestDeprecatedLockEntryPoints(t *testing.T) {
+	path := createLockTable(t)
+
+	open := func() (*pkg.Code4, *pkg.Data4) {
+		codeBase := &pkg.Code4{}
+		pkg.Code4Init(codeBase)
+		codeBase.LockAttempts = 1
+		data := pkg.D4Open(codeBase, path)
+		if data == nil {
+			t.Fatalf("D4Open failed: %d", codeBase.ErrorCode)
+		}
+		t.Cleanup(func() { pkg.Code4InitUndo(codeBase) })
+		return codeBase, data
+	}
+	cb1, data1 := open()
+	_, data2 := open()
+
+	// D4Lock locks the current record
+	if rc := pkg.D4Go(data1, 2); rc != pkg.ErrorNone {
+		t.Fatalf("D4Go failed: %d", rc)
+	}
+	if rc := pkg.D4Lock(data1); rc != pkg.ErrorNone {
+		t.Fatalf("D4Lock failed: %d", rc)
+	}
+	if !pkg.D4LockTest(data1, 2) {
+		t.Error("D4Lock did not lock the current record")
+	}
+	if rc := pkg.D4UnlockAll(data1); rc != pkg.ErrorNone || pkg.D4LockTest(data1, 2) {
+		t.Errorf("D4UnlockAll = %d, record still locked: %v", rc, pkg.D4LockTest(data1, 2))
+	}
+
+	// The manager's old range and file methods
+	locks := pkg.Code4Locks(cb1)
+	file := &data1.DataFile.File
+	if rc := locks.LockRange(file, vfpLockPos-3, 1); rc != pkg.ErrorNone {
+		t.Fatalf("LockRange failed: %d", rc)
+	}
+	if rc := pkg.D4LockRecord(data2, 3); rc != pkg.R4Locked {
+		t.Errorf("D4LockRecord under LockRange = %d, want R4Locked", rc)
+	}
+	if rc := locks.UnlockRange(file, vfpLockPos-3, 1); rc != pkg.ErrorNone {
+		t.Errorf("UnlockRange failed: %d", rc)
+	}
+	if rc := locks.LockFile(file); rc != pkg.ErrorNone {
+		t.Fatalf("LockFile failed: %d", rc)
+	}
+	if !pkg.D4LockTestFile(data1) {
+		t.Error("LockFile did not take the file lock")
+	}
+	if rc := locks.UnlockFile(file); rc != pkg.ErrorNone || pkg.D4LockTestFile(data1) {
+		t.Errorf("UnlockFile = %d, file still locked: %v", rc, pkg.D4LockTestFile(data1))
+	}
+	if rc := pkg.D4LockRecord(data2, 3); rc != pkg.ErrorNone {
+		t.Errorf("D4LockRecord after the old unlocks = %d", rc)
+	}
+
+	// GetLockStatus reports the locks of every CODE4
+	if rc := pkg.D4LockRecord(data1, 2); rc != pkg.ErrorNone {
+		t.Fatalf("D4LockRecord failed: %d", rc)
+	}
+	status := pkg.GetLockStatus()
+	for _, recNo := range []int64{2, 3} {
+		key := fmt.Sprintf("%s:%d", file.Name, vfpLockPos-recNo)
+		if lock := status[key]; lock == nil || lock.LockType != pkg.LockRecord || lock.Length != 1 {
+			t.Errorf("GetLockStatus()[%s] = %+v", key, lock)
+		}
+	}
+	pkg.D4Unlock(data1)
+	pkg.D4Unlock(data2)
+	if status := pkg.GetLockStatus(); status[fmt.Sprintf("%s:%d", file.Name, vfpLockPos-2)] != nil {
+		t.Error("GetLockStatus reports a released lock")
+	}
+
+	// A zero-value manager can lock
+	var zero pkg.LockManager
+	if rc := zero.LockRange(&data2.DataFile.File, vfpLockPos-4, 1); rc != pkg.ErrorNone {
+		t.Errorf("LockRange on a zero LockManager = %d", rc)
+	}
+	zero.UnlockAll(&data2.DataFile.File)
+}
+
+func TestSetLockTimeout(t *testing.T) {
+	t.Cleanup(func() { pkg.SetLockTimeout(-1) })
+
+	pkg.SetLockTimeout(time.Second)
+	codeBase := &pkg.Code4{}
+	pkg.Code4Init(codeBase)
+	defer pkg.Code4InitUndo(codeBase)
+	if codeBase.LockAttempts != 11 || codeBase.LockDelay != 10 {
+		t.Errorf("after SetLockTimeout(1s) LockAttempts = %d, LockDelay = %d", codeBase.LockAttempts, codeBase.LockDelay)
+	}
+
+	pkg.SetLockTimeout(-1)
+	other := &pkg.Code4{}
+	pkg.Code4Init(other)
+	defer pkg.Code4InitUndo(other)
+	if other.LockAttempts != pkg.Wait4Ever {
+		t.Errorf("after SetLockTimeout(-1) LockAttempts = %d, want Wait4Ever", other.LockAttempts)
+	}
+}
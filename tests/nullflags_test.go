@@ -0,0 +1,114 @@
+package tests
+
+import (
+	"testing"
+
+	"github.com/mkfoss/foxi"
+)
+
+// createNullTable creates a table with nullable and varchar fields, so it
+// has a _NullFlags field
+func createNullTable(t *testing.T) (*foxi.Foxi, string) {
+	t.Helper()
+	return createTable(t, "nulls", foxi.Schema{
+		Fields: []foxi.FieldSpec{
+			{Name: "NAME", Type: foxi.FTCharacter, Size: 10, Nullable: true},
+			{Name: "CODE", Type: foxi.FTVarchar, Size: 8, Nullable: true},
+			{Name: "TAG", Type: foxi.FTVarBinary, Size: 4},
+			{Name: "QTY", Type: foxi.FTNumeric, Size: 6, Nullable: true},
+			{Name: "PLAIN", Type: foxi.FTCharacter, Size: 5},
+		},
+	}, nil)
+}
+
+func TestNullFlags(t *testing.T) {
+	f, path := createNullTable(t)
+
+	f.MustAppend()
+	f.FieldByName("name").MustSetNull()
+	f.FieldByName("code").MustSetString("ab")
+	f.FieldByName("tag").MustSetString("xy")
+	f.FieldByName("qty").MustSetNull()
+	f.MustWrite()
+
+	// Blank values are not null
+	f.MustAppend()
+	f.FieldByName("name").MustSetString("")
+	f.FieldByName("code").MustSetNull()
+	f.FieldByName("qty").MustSetInt(0)
+	f.MustWrite()
+	f.Close()
+
+	f = foxi.NewFoxi()
+	f.MustOpen(path)
+	defer f.Close()
+
+	tests := []struct {
+		recNo int
+		nulls map[string]bool
+	}{
+		{1, map[string]bool{"name": true, "code": false, "tag": false, "qty": true, "plain": false}},
+		{2, map[string]bool{"name": false, "code": true, "tag": false, "qty": false, "plain": false}},
+	}
+	for _, tt := range tests {
+		f.MustGoto(tt.recNo)
+		for name, want := range tt.nulls {
+			field := f.FieldByName(name)
+			if got := field.MustIsNull(); got != want {
+				t.Errorf("record %d: %s IsNull = %v, want %v", tt.recNo, name, got, want)
+			}
+			if value := field.MustValue(); (value == nil) != want {
+				t.Errorf("record %d: %s Value = %#v, null %v", tt.recNo, name, value, want)
+			}
+		}
+	}
+
+	// The length bits of the varchar fields are kept apart from the null bits
+	f.MustGoto(1)
+	if got := f.FieldByName("code").MustValue(); got != "ab" {
+		t.Errorf("CODE = %#v, want %q", got, "ab")
+	}
+	if got := f.FieldByName("tag").MustValue(); string(got.([]byte)) != "xy" {
+		t.Errorf("TAG = %q, want %q", got, "xy")
+	}
+
+	// Assigning a value clears the null bit
+	f.FieldByName("name").MustSetString("back")
+	if f.FieldByName("name").MustIsNull() {
+		t.Error("NAME still null after assigning a value")
+	}
+	if f.FieldByName("qty").MustIsNull() != true {
+		t.Error("QTY lost its null bit when NAME was assigned")
+	}
+
+	if err := f.FieldByName("plain").SetNull(); err == nil {
+		t.Error("expected error setting a field that is not nullable to null")
+	}
+}
+
+func TestNullFlagsHidden(t *testing.T) {
+	f, _ := createNullTable(t)
+	defer f.Close()
+
+	fields := f.Fields()
+	if got := fields.Count(); got != 5 {
+		t.Errorf("Count = %d, want 5", got)
+	}
+	for i := 0; i < fields.Count(); i++ {
+		if field := fields.ByIndex(i); field.IsSystem() {
+			t.Errorf("ByIndex(%d) returned system field %s", i, field.Name())
+		}
+	}
+
+	// The system field is found when asked for by name
+	nullFlags := fields.ByName("_NullFlags")
+	if nullFlags == nil {
+		t.Fatal("ByName(_NullFlags) = nil")
+	}
+	if !nullFlags.IsSystem() {
+		t.Error("_NullFlags IsSystem = false")
+	}
+	if f.FieldByName("name").IsSystem() {
+		t.Error("NAME IsSystem = true")
+	}
+}
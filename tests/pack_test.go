@@ -0,0 +1,168 @@
+package tests
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/mkfoss/foxi"
+)
+
+// packNote is the memo of each record of the tables packed
+func packNote(name string) string {
+	return strings.Repeat(name+" ", 20)
+}
+
+func fileSize(t *testing.T, path string) int64 {
+	t.Helper()
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("failed to stat %s: %v", path, err)
+	}
+	return info.Size()
+}
+
+func TestPack(t *testing.T) {
+	f, path := createNamesTable(t, packNote, "alpha", "beta", "gamma", "delta", "epsilon")
+	defer f.Close()
+	fpt := strings.TrimSuffix(path, ".dbf") + ".fpt"
+
+	f.MustGoto(2)
+	f.MustDelete()
+	f.MustGoto(4)
+	f.MustDelete()
+	f.MustWrite()
+	sizeBefore := fileSize(t, fpt)
+
+	var calls [][2]int
+	f.MustPack(func(done, total int) {
+		calls = append(calls, [2]int{done, total})
+	})
+
+	header := f.Header()
+	if count := header.RecordCount(); count != 3 {
+		t.Fatalf("expected 3 records after pack, got %d", count)
+	}
+	if len(calls) == 0 {
+		t.Fatal("progress was not reported")
+	}
+	if last := calls[len(calls)-1]; last[0] != last[1] {
+		t.Errorf("last progress call %d/%d, want done == total", last[0], last[1])
+	}
+
+	for i, want := range []string{"alpha", "gamma", "epsilon"} {
+		f.MustGoto(i + 1)
+		if got := strings.TrimSpace(f.FieldByName("name").MustAsString()); got != want {
+			t.Errorf("record %d NAME = %q, want %q", i+1, got, want)
+		}
+		if got := f.FieldByName("notes").MustAsString(); got != strings.Repeat(want+" ", 20) {
+			t.Errorf("record %d NOTES = %q", i+1, got)
+		}
+		if f.Deleted() {
+			t.Errorf("record %d is still deleted", i+1)
+		}
+	}
+
+	if sizeAfter := fileSize(t, fpt); sizeAfter >= sizeBefore {
+		t.Errorf("memo file not compacted: %d bytes before, %d after", sizeBefore, sizeAfter)
+	}
+
+	tag := f.Indexes().TagByName("name")
+	if tag == nil {
+		t.Fatal("tag NAME missing")
+	}
+	if got := tag.MustSeekString("GAMMA"); got != foxi.SeekSuccess {
+		t.Errorf("seek GAMMA = %v, want %v", got, foxi.SeekSuccess)
+	}
+	if got := tag.RecordNumber(); got != 2 {
+		t.Errorf("GAMMA points at record %d, want 2", got)
+	}
+	if got := tag.MustSeekString("BETA"); got == foxi.SeekSuccess {
+		t.Error("index still holds a packed record")
+	}
+}
+
+func TestPackMemo(t *testing.T) {
+	f, path := createNamesTable(t, packNote, "alpha", "beta")
+	defer f.Close()
+	fpt := strings.TrimSuffix(path, ".dbf") + ".fpt"
+
+	// Growing memos leave their old blocks behind
+	for i := 1; i <= 5; i++ {
+		f.MustGoto(1)
+		f.FieldByName("notes").MustSetString(strings.Repeat("x", 100*i))
+		f.MustWrite()
+	}
+	sizeBefore := fileSize(t, fpt)
+
+	f.MustPackMemo(nil)
+
+	if sizeAfter := fileSize(t, fpt); sizeAfter >= sizeBefore {
+		t.Errorf("memo file not compacted: %d bytes before, %d after", sizeBefore, sizeAfter)
+	}
+	f.MustGoto(1)
+	if got := f.FieldByName("notes").MustAsString(); got != strings.Repeat("x", 500) {
+		t.Errorf("record 1 NOTES has %d bytes, want 500", len(got))
+	}
+	f.MustGoto(2)
+	if got := f.FieldByName("notes").MustAsString(); got != strings.Repeat("beta ", 20) {
+		t.Errorf("record 2 NOTES = %q", got)
+	}
+
+	// The table stays usable for writing
+	f.FieldByName("notes").MustSetString("after pack")
+	f.MustWrite()
+	f.Close()
+
+	f = foxi.NewFoxi()
+	f.MustOpen(path)
+	f.MustGoto(2)
+	if got := f.FieldByName("notes").MustAsString(); got != "after pack" {
+		t.Errorf("record 2 NOTES = %q, want %q", got, "after pack")
+	}
+}
+
+func TestZap(t *testing.T) {
+	f, path := createNamesTable(t, packNote, "alpha", "beta", "gamma")
+	defer f.Close()
+
+	f.MustZap()
+
+	header := f.Header()
+	if count := header.RecordCount(); count != 0 {
+		t.Fatalf("expected 0 records after zap, got %d", count)
+	}
+	if !f.EOF() {
+		t.Error("expected EOF after zap")
+	}
+	if size := fileSize(t, strings.TrimSuffix(path, ".dbf")+".fpt"); size > 512 {
+		t.Errorf("memo file not emptied, %d bytes", size)
+	}
+	if got := f.Indexes().TagByName("name").MustSeekString("ALPHA"); got == foxi.SeekSuccess {
+		t.Error("index still holds a zapped record")
+	}
+
+	f.MustAppend()
+	f.FieldByName("name").MustSetString("delta")
+	f.FieldByName("notes").MustSetString("new")
+	f.MustWrite()
+	f.MustGoto(1)
+	if got := f.FieldByName("notes").MustAsString(); got != "new" {
+		t.Errorf("NOTES = %q, want %q", got, "new")
+	}
+}
+
+func TestPackInsideTransaction(t *testing.T) {
+	f, _ := createNamesTable(t, packNote, "alpha")
+	defer f.Close()
+
+	tx := f.MustBegin()
+	defer tx.Rollback()
+
+	if err := f.Pack(nil); err == nil {
+		t.Error("expected error for Pack inside a transaction")
+	}
+	if err := f.Zap(); err == nil {
+		t.Error("expected error for Zap inside a transaction")
+	}
+}
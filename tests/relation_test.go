@@ -0,0 +1,265 @@
+package tests
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/mkfoss/foxi"
+)
+
+// createRelatedTables creates CUSTOMER, ORDERS and LINES tables: customers
+// 1 and 3 have two orders, customer 2 one and customer 4 none; orders 1,
+// 3 and 4 have lines
+func createRelatedTables(t *testing.T) (customers, orders, lines *foxi.Foxi) {
+	t.Helper()
+
+	create := func(name string, schema foxi.Schema, rows ...row) *foxi.Foxi {
+		f, _ := createTable(t, name, schema, nil, rows...)
+		t.Cleanup(func() { f.Close() })
+		return f
+	}
+	customers = create("customer", foxi.Schema{
+		Fields: []foxi.FieldSpec{{Name: "ID", Type: foxi.FTInteger}, {Name: "NAME", Type: foxi.FTCharacter, Size: 10}},
+		Tags:   []foxi.TagSpec{{Name: "id", Expression: "ID"}},
+	}, row{"ID": 1, "NAME": "Alice"}, row{"ID": 2, "NAME": "Bob"}, row{"ID": 3, "NAME": "Carol"}, row{"ID": 4, "NAME": "Dave"})
+	orders = create("orders", foxi.Schema{
+		Fields: []foxi.FieldSpec{{Name: "ID", Type: foxi.FTInteger}, {Name: "CUSTID", Type: foxi.FTInteger}, {Name: "AMOUNT", Type: foxi.FTNumeric, Size: 6}},
+		Tags:   []foxi.TagSpec{{Name: "custid", Expression: "CUSTID"}},
+	},
+		row{"ID": 1, "CUSTID": 1, "AMOUNT": 100}, row{"ID": 2, "CUSTID": 2, "AMOUNT": 20}, row{"ID": 3, "CUSTID": 1, "AMOUNT": 50},
+		row{"ID": 4, "CUSTID": 3, "AMOUNT": 75}, row{"ID": 5, "CUSTID": 3, "AMOUNT": 25})
+	lines = create("lines", foxi.Schema{
+		Fields: []foxi.FieldSpec{{Name: "ORDERID", Type: foxi.FTInteger}, {Name: "QTY", Type: foxi.FTInteger}},
+		Tags:   []foxi.TagSpec{{Name: "orderid", Expression: "ORDERID"}},
+	},
+		row{"ORDERID": 1, "QTY": 1}, row{"ORDERID": 1, "QTY": 5}, row{"ORDERID": 3, "QTY": 2},
+		row{"ORDERID": 4, "QTY": 3}, row{"ORDERID": 9, "QTY": 9})
+	return customers, orders, lines
+}
+
+// position returns the current record of a table, 0 at EOF
+func position(f *foxi.Foxi) int {
+	if f.EOF() || f.BOF() {
+		return 0
+	}
+	return f.Position()
+}
+
+func TestRelation(t *testing.T) {
+	customers, orders, _ := createRelatedTables(t)
+
+	rel := customers.MustSetRelation("ID", orders, orders.Indexes().TagByName("custid"))
+	if rel.Parent() != customers || rel.Child() != orders || rel.Expression() != "ID" || rel.Tag().Name() != "CUSTID" {
+		t.Errorf("relation reports %v into %v on %q", rel.Parent(), rel.Child(), rel.Expression())
+	}
+
+	var got [][2]int
+	for _, err := range customers.Records() {
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, [2]int{customers.Position(), position(orders)})
+	}
+	want := [][2]int{{1, 1}, {2, 2}, {3, 4}, {4, 0}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Records related %v, want %v", got, want)
+	}
+
+	customers.MustGoto(3)
+	if position(orders) != 4 {
+		t.Errorf("Goto(3) related order %d, want 4", position(orders))
+	}
+	customers.MustLast()
+	if !orders.EOF() {
+		t.Error("a customer without orders should leave ORDERS at EOF")
+	}
+	customers.MustNext()
+	if !orders.EOF() {
+		t.Error("CUSTOMER at EOF should leave ORDERS at EOF")
+	}
+
+	// The child's fields can be read through its alias
+	customers.MustFirst()
+	amount := foxi.MustCompile(customers, "ORDERS.AMOUNT * 2")
+	if v, _ := amount.Eval(); v != 200.0 {
+		t.Errorf("ORDERS.AMOUNT * 2 gave %v, want 200", v)
+	}
+
+	// Records that do not match the child's filter are passed over
+	orders.MustSetFilter("AMOUNT < 60")
+	customers.MustGoto(1)
+	if position(orders) != 3 {
+		t.Errorf("filtered relation found order %d, want 3", position(orders))
+	}
+	orders.ClearFilter()
+
+	// Moving with a tag needs Relate
+	customers.Indexes().MustSelectTag(customers.Indexes().TagByName("id"))
+	customers.Indexes().TagByName("id").MustSeekInt(2)
+	if err := customers.Relate(); err != nil {
+		t.Fatal(err)
+	}
+	if position(orders) != 2 {
+		t.Errorf("Relate found order %d, want 2", position(orders))
+	}
+
+	customers.ClearRelation(orders)
+	if len(customers.Relations()) != 0 {
+		t.Error("ClearRelation left the relation")
+	}
+	customers.MustGoto(3)
+	if position(orders) != 2 {
+		t.Error("the child moved after the relation was cleared")
+	}
+}
+
+func TestRelationRecordNumber(t *testing.T) {
+	customers, orders, _ := createRelatedTables(t)
+
+	// Without a tag the child goes to the record number
+	orders.MustSetRelation("CUSTID", customers, nil)
+	customers.Indexes().SelectTag(nil)
+	orders.MustGoto(5)
+	if customers.Position() != 3 {
+		t.Errorf("order 5 related customer %d, want 3", customers.Position())
+	}
+	orders.MustAppend()
+	orders.FieldByName("custid").MustSetInt(9)
+	orders.MustWrite()
+	orders.MustGoto(6)
+	if !customers.EOF() {
+		t.Error("a record number past the end should leave CUSTOMER at EOF")
+	}
+}
+
+func TestRelationErrors(t *testing.T) {
+	customers, orders, lines := createRelatedTables(t)
+	custid := orders.Indexes().TagByName("custid")
+
+	if _, err := customers.SetRelation("ID", customers, customers.Indexes().TagByName("id")); err == nil {
+		t.Error("relating a table to itself should fail")
+	}
+	if _, err := customers.SetRelation("NAME", orders, custid); err == nil {
+		t.Error("a character expression into a numeric tag should fail")
+	}
+	if _, err := customers.SetRelation("NAME", orders, nil); err == nil {
+		t.Error("a character expression into a record number should fail")
+	}
+	customers.MustSetRelation("ID", orders, custid)
+	if _, err := lines.SetRelation("ORDERID", orders, nil); err == nil {
+		t.Error("a second relation into the same child should fail")
+	}
+	orders.MustSetRelation("ID", lines, lines.Indexes().TagByName("orderid"))
+	if _, err := lines.SetRelation("ORDERID", customers, customers.Indexes().TagByName("id")); err == nil {
+		t.Error("a cycle of relations should fail")
+	}
+	if err := customers.SetSkip(lines); err == nil {
+		t.Error("SetSkip on a grandchild should fail")
+	}
+}
+
+// relatedRows walks CUSTOMER and returns the records of the three tables
+// at each row, 0 for EOF
+func relatedRows(t *testing.T, customers, orders, lines *foxi.Foxi, backwards bool) [][3]int {
+	t.Helper()
+	var rows [][3]int
+	start, step := customers.First, customers.Next
+	if backwards {
+		start, step = customers.Last, customers.Previous
+	}
+	for err := start(); !customers.EOF() && !customers.BOF(); err = step() {
+		if err != nil {
+			t.Fatal(err)
+		}
+		row := [3]int{customers.Position(), position(orders), position(lines)}
+		if backwards {
+			rows = append([][3]int{row}, rows...)
+		} else {
+			rows = append(rows, row)
+		}
+	}
+	return rows
+}
+
+func TestRelationSkip(t *testing.T) {
+	customers, orders, lines := createRelatedTables(t)
+	customers.MustSetRelation("ID", orders, orders.Indexes().TagByName("custid"))
+	orders.MustSetRelation("ID", lines, lines.Indexes().TagByName("orderid"))
+
+	// One-to-many into ORDERS: a row per order, and one for Dave
+	if err := customers.SetSkip(orders); err != nil {
+		t.Fatal(err)
+	}
+	want := [][3]int{{1, 1, 1}, {1, 3, 3}, {2, 2, 0}, {3, 4, 4}, {3, 5, 0}, {4, 0, 0}}
+	if got := relatedRows(t, customers, orders, lines, false); !reflect.DeepEqual(got, want) {
+		t.Errorf("one level forwards\ngot  %v\nwant %v", got, want)
+	}
+	if got := relatedRows(t, customers, orders, lines, true); !reflect.DeepEqual(got, want) {
+		t.Errorf("one level backwards\ngot  %v\nwant %v", got, want)
+	}
+
+	// And from ORDERS into LINES: a row per line
+	if err := orders.SetSkip(lines); err != nil {
+		t.Fatal(err)
+	}
+	want = [][3]int{{1, 1, 1}, {1, 1, 2}, {1, 3, 3}, {2, 2, 0}, {3, 4, 4}, {3, 5, 0}, {4, 0, 0}}
+	if got := relatedRows(t, customers, orders, lines, false); !reflect.DeepEqual(got, want) {
+		t.Errorf("two levels forwards\ngot  %v\nwant %v", got, want)
+	}
+	if got := relatedRows(t, customers, orders, lines, true); !reflect.DeepEqual(got, want) {
+		t.Errorf("two levels backwards\ngot  %v\nwant %v", got, want)
+	}
+
+	customers.MustFirst()
+	customers.MustSkip(3)
+	if row := [3]int{customers.Position(), position(orders), position(lines)}; row != [3]int{2, 2, 0} {
+		t.Errorf("Skip(3) reached %v, want [2 2 0]", row)
+	}
+
+	// Without SetSkip each customer is visited once
+	customers.SetSkip()
+	if got := relatedRows(t, customers, orders, lines, false); len(got) != 4 {
+		t.Errorf("one-to-one walk visited %v", got)
+	}
+}
+
+func TestWalkRelated(t *testing.T) {
+	customers, orders, lines := createRelatedTables(t)
+	customers.MustSetRelation("ID", orders, orders.Indexes().TagByName("custid"))
+	orders.MustSetRelation("ID", lines, lines.Indexes().TagByName("orderid"))
+
+	walk := func(condition string) [][3]int {
+		var rows [][3]int
+		for rec, err := range customers.WalkRelated(condition) {
+			if err != nil {
+				t.Fatalf("%s: %v", condition, err)
+			}
+			rows = append(rows, [3]int{rec.Number(), position(orders), position(lines)})
+		}
+		return rows
+	}
+
+	want := [][3]int{{1, 1, 1}, {1, 1, 2}, {1, 3, 3}, {2, 2, 0}, {3, 4, 4}, {3, 5, 0}, {4, 0, 0}}
+	if got := walk(""); !reflect.DeepEqual(got, want) {
+		t.Errorf("walk\ngot  %v\nwant %v", got, want)
+	}
+	want = [][3]int{{1, 1, 2}, {3, 4, 4}}
+	if got := walk("LINES.QTY > 2"); !reflect.DeepEqual(got, want) {
+		t.Errorf("walk with LINES.QTY > 2\ngot  %v\nwant %v", got, want)
+	}
+
+	// The child filter and the parent filter both apply
+	customers.MustSetFilter(`NAME = "Carol"`)
+	orders.MustSetFilter("AMOUNT > 50")
+	want = [][3]int{{3, 4, 4}}
+	if got := walk(""); !reflect.DeepEqual(got, want) {
+		t.Errorf("filtered walk\ngot  %v\nwant %v", got, want)
+	}
+
+	for _, err := range customers.WalkRelated("LINES.QTY") {
+		if err == nil {
+			t.Error("a condition that is not logical should fail")
+		}
+		break
+	}
+}
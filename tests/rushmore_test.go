@@ -0,0 +1,232 @@
+package tests
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mkfoss/foxi"
+	"github.com/mkfoss/foxi/expr"
+)
+
+// createOrderTable creates a table of 200 orders with tags on most of
+// its fields
+func createOrderTable(t *testing.T) *foxi.Foxi {
+	t.Helper()
+	states := []string{"CA", "NY", "TX", "WA", "OR"}
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	rows := make([]row, 0, 200)
+	for i := 0; i < 200; i++ {
+		rows = append(rows, row{
+			"ID":     i + 1,
+			"NAME":   string(rune('a'+i%7)) + string(rune('A'+i%5)) + "x",
+			"STATE":  states[i*i%5],
+			"QTY":    i * 37 % 101,
+			"PRICE":  float64(i*13%97) + 0.5,
+			"DUE":    start.AddDate(0, 0, i),
+			"ACTIVE": i%3 != 0,
+		})
+	}
+	f, _ := createTable(t, "orders", foxi.Schema{
+		Fields: []foxi.FieldSpec{
+			{Name: "ID", Type: foxi.FTInteger},
+			{Name: "NAME", Type: foxi.FTCharacter, Size: 10},
+			{Name: "STATE", Type: foxi.FTCharacter, Size: 2},
+			{Name: "QTY", Type: foxi.FTNumeric, Size: 5},
+			{Name: "PRICE", Type: foxi.FTNumeric, Size: 8, Decimals: 2},
+			{Name: "DUE", Type: foxi.FTDate},
+			{Name: "ACTIVE", Type: foxi.FTLogical},
+		},
+		Tags: []foxi.TagSpec{
+			{Name: "id", Expression: "ID"},
+			{Name: "name", Expression: "UPPER(NAME)"},
+			{Name: "state", Expression: "STATE"},
+			{Name: "qty", Expression: "QTY"},
+			{Name: "due", Expression: "DUE"},
+			{Name: "active", Expression: "ACTIVE"},
+			{Name: "price", Expression: "PRICE", Descending: true}, // not used
+			{Name: "big", Expression: "QTY", Filter: "QTY > 90"},   // not used
+		},
+	}, nil, rows...)
+	return f
+}
+
+// unfiltered returns the records a filter matches, evaluated record by
+// record without a filter set
+func unfiltered(t *testing.T, f *foxi.Foxi, source string) []int {
+	t.Helper()
+	prog := foxi.MustCompile(f, source)
+	f.ClearFilter()
+	f.Indexes().SelectTag(nil)
+	var records []int
+	for f.MustFirst(); !f.EOF(); f.MustNext() {
+		ok, err := prog.EvalBool()
+		if err != nil {
+			t.Fatalf("%s: %v", source, err)
+		}
+		if ok {
+			records = append(records, f.Position())
+		}
+	}
+	return records
+}
+
+// visited returns the records from First to EOF
+func visited(f *foxi.Foxi) []int {
+	var records []int
+	for f.MustFirst(); !f.EOF(); f.MustNext() {
+		records = append(records, f.Position())
+	}
+	return records
+}
+
+// reversed returns the records from Last to BOF, in forward order
+func reversed(f *foxi.Foxi) []int {
+	var records []int
+	for f.MustLast(); !f.BOF(); f.MustPrevious() {
+		records = append([]int{f.Position()}, records...)
+	}
+	return records
+}
+
+func TestRushmore(t *testing.T) {
+	f := createOrderTable(t)
+	defer f.Close()
+
+	tests := []struct {
+		filter   string
+		level    foxi.Optimization
+		tags     []string
+		residual string
+	}{
+		{`STATE = "CA"`, foxi.OptimizeFull, []string{"STATE"}, ""},
+		{`STATE = "CA" .AND. QTY > 50`, foxi.OptimizeFull, []string{"STATE", "QTY"}, ""},
+		{`STATE = "CA" .OR. STATE = "NY"`, foxi.OptimizeFull, []string{"STATE"}, ""},
+		{`STATE <> "CA" .AND. .NOT. ACTIVE`, foxi.OptimizeFull, []string{"STATE", "ACTIVE"}, ""},
+		{`DUE >= {^2024-03-01} .AND. DUE < {^2024-04-01}`, foxi.OptimizeFull, []string{"DUE"}, ""},
+		{`ID <= 20 .OR. QTY = 7`, foxi.OptimizeFull, []string{"ID", "QTY"}, ""},
+		{`10 >= QTY .AND. -1 < QTY`, foxi.OptimizeFull, []string{"QTY"}, ""},
+		{`upper(name) = "B"`, foxi.OptimizeFull, []string{"NAME"}, ""},
+		{`ID > 195 .OR. ID < 3`, foxi.OptimizeFull, []string{"ID"}, ""},
+		{`QTY = -3`, foxi.OptimizeFull, []string{"QTY"}, ""},
+		{`STATE = "CA" .AND. PRICE > 40`, foxi.OptimizePartial, []string{"STATE"}, "PRICE > 40"},
+		{`UPPER(NAME) == "BCX"`, foxi.OptimizePartial, []string{"NAME"}, `UPPER(NAME) == "BCX"`},
+		{`STATE = "TX" .AND. (QTY < 10 .OR. PRICE > 90)`, foxi.OptimizePartial, []string{"STATE"}, "(QTY < 10 .OR. PRICE > 90)"},
+		{`.NOT. (STATE = "CA" .AND. PRICE > 40)`, foxi.OptimizeNone, nil, `.NOT. (STATE = "CA" .AND. PRICE > 40)`},
+		{`PRICE > 40`, foxi.OptimizeNone, nil, "PRICE > 40"},
+		{`STATE = "CA" .OR. PRICE > 40`, foxi.OptimizeNone, nil, `STATE = "CA" .OR. PRICE > 40`},
+		{`ID = 2.5`, foxi.OptimizeNone, nil, "ID = 2.5"},
+		{`QTY + 0 = 7`, foxi.OptimizeNone, nil, "QTY + 0 = 7"},
+	}
+	for _, tt := range tests {
+		want := unfiltered(t, f, tt.filter)
+		f.MustSetFilter(tt.filter)
+
+		plan := f.MustExplain()
+		if plan.Optimization != tt.level || !reflect.DeepEqual(plan.Tags(), tt.tags) || plan.Residual != tt.residual {
+			t.Errorf("%s: plan %s with tags %v residual %q, want %s with %v residual %q",
+				tt.filter, plan.Optimization, plan.Tags(), plan.Residual, tt.level, tt.tags, tt.residual)
+		}
+		if tt.level == foxi.OptimizeFull && plan.Candidates != len(want) {
+			t.Errorf("%s: %d candidates, want %d", tt.filter, plan.Candidates, len(want))
+		}
+
+		if got := visited(f); !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: visited %v, want %v", tt.filter, got, want)
+		}
+		if got := reversed(f); !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: visited backwards %v, want %v", tt.filter, got, want)
+		}
+
+		// In tag order the same records are visited
+		f.Indexes().MustSelectTag(f.Indexes().TagByName("price"))
+		if got := visited(f); len(got) != len(want) {
+			t.Errorf("%s: %d records in tag order, want %d", tt.filter, len(got), len(want))
+		}
+		f.Indexes().SelectTag(nil)
+	}
+}
+
+// TestRushmoreReads checks that only the candidates are read and that
+// records changed after planning are tested in full
+func TestRushmoreReads(t *testing.T) {
+	f := createOrderTable(t)
+	defer f.Close()
+
+	reads := 0
+	if err := expr.RegisterFunc("RUSHMORE_READ", func([]any) (any, error) {
+		reads++
+		return true, nil
+	}, nil, expr.Logical); err != nil {
+		t.Fatal(err)
+	}
+
+	const filter = `STATE = "CA" .AND. QTY < 50 .AND. RUSHMORE_READ()`
+	want := unfiltered(t, f, `STATE = "CA" .AND. QTY < 50`)
+	f.MustSetFilter(filter)
+
+	plan := f.MustExplain()
+	if plan.Optimization != foxi.OptimizePartial || plan.Candidates != len(want) || plan.Records != 200 {
+		t.Fatalf("plan %s with %d of %d candidates, want partial with %d of 200", plan.Optimization, plan.Candidates, plan.Records, len(want))
+	}
+	text := plan.String()
+	for _, line := range []string{
+		"Rushmore optimization level for filter " + filter + ": partial",
+		`Using index tag STATE for STATE = "CA": 40 records`,
+		"Using index tag QTY for QTY < 50: ",
+		"Testing RUSHMORE_READ() on ",
+	} {
+		if !strings.Contains(text, line) {
+			t.Errorf("plan text lacks %q:\n%s", line, text)
+		}
+	}
+
+	reads = 0
+	if got := visited(f); !reflect.DeepEqual(got, want) {
+		t.Errorf("visited %v, want %v", got, want)
+	}
+	if reads != len(want) {
+		t.Errorf("%d records read forwards, want %d", reads, len(want))
+	}
+	reads = 0
+	if got := reversed(f); !reflect.DeepEqual(got, want) {
+		t.Errorf("visited backwards %v, want %v", got, want)
+	}
+	if reads != len(want) {
+		t.Errorf("%d records read backwards, want %d", reads, len(want))
+	}
+
+	// A record written after planning is tested with the whole filter
+	f.MustFirst()
+	f.MustGoto(2)
+	f.FieldByName("state").MustSetString("CA")
+	f.FieldByName("qty").MustSetInt(1)
+	f.MustWrite()
+	f.MustGoto(1)
+	f.MustNext()
+	if f.Position() != 2 {
+		t.Errorf("Next after rewriting record 2 reached record %d, want 2", f.Position())
+	}
+
+	// As is an appended one
+	f.MustAppend()
+	f.FieldByName("state").MustSetString("CA")
+	f.FieldByName("qty").MustSetInt(3)
+	f.MustWrite()
+	f.MustGoto(want[len(want)-1])
+	if f.MustNext(); f.Position() != 201 {
+		t.Errorf("Next from the last match reached record %d, want 201", f.Position())
+	}
+
+	// Explain needs a filter and leaves the table where it was
+	f.MustGoto(7)
+	f.MustExplain()
+	if f.Position() != 7 {
+		t.Errorf("Explain moved to record %d", f.Position())
+	}
+	f.ClearFilter()
+	if _, err := f.Explain(); err == nil {
+		t.Error("Explain without a filter should fail")
+	}
+}
@@ -0,0 +1,195 @@
+package tests
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mkfoss/foxi"
+)
+
+type scanBase struct {
+	ID int `dbf:"ID"`
+}
+
+type scanCustomer struct {
+	scanBase
+	Name    string     `dbf:"NAME,trim"`
+	Raw     string     `dbf:"NAME"`
+	Amount  float64    // Mapped by name
+	Visits  *uint16    `dbf:"VISITS"`
+	Active  bool       `dbf:"ACTIVE"`
+	Joined  time.Time  `dbf:"JOINED"`
+	Closed  *time.Time `dbf:"CLOSED,null"`
+	Notes   string     `dbf:"NOTES,memo"`
+	Comment string     // Untagged memo field, skipped
+	Missing string     // Untagged and not in the table, skipped
+	Ignored string     `dbf:"-"`
+	secret  string     //nolint:unused // Unexported, skipped
+}
+
+// createScanTable creates a table with one field per scanCustomer member
+func createScanTable(t *testing.T) *foxi.Foxi {
+	t.Helper()
+	f, _ := createTable(t, "scan", foxi.Schema{
+		Fields: []foxi.FieldSpec{
+			{Name: "ID", Type: foxi.FTInteger},
+			{Name: "NAME", Type: foxi.FTCharacter, Size: 12},
+			{Name: "AMOUNT", Type: foxi.FTNumeric, Size: 10, Decimals: 2},
+			{Name: "VISITS", Type: foxi.FTNumeric, Size: 5},
+			{Name: "ACTIVE", Type: foxi.FTLogical},
+			{Name: "JOINED", Type: foxi.FTDate},
+			{Name: "CLOSED", Type: foxi.FTDateTime, Nullable: true},
+			{Name: "NOTES", Type: foxi.FTMemo},
+			{Name: "COMMENT", Type: foxi.FTMemo},
+		},
+	}, nil)
+	return f
+}
+
+func TestScanPut(t *testing.T) {
+	f := createScanTable(t)
+	defer f.Close()
+
+	visits := uint16(7)
+	closed := time.Date(2024, 3, 4, 10, 30, 0, 0, time.UTC)
+	in := scanCustomer{
+		scanBase: scanBase{ID: 42},
+		Name:     "Alice",
+		Raw:      "Alice", // Put writes NAME twice
+		Amount:   123.45,
+		Visits:   &visits,
+		Active:   true,
+		Joined:   time.Date(2020, 1, 2, 0, 0, 0, 0, time.UTC),
+		Closed:   &closed,
+		Notes:    "long notes",
+		Comment:  "not written",
+	}
+	f.MustAppend()
+	f.MustPut(&in)
+	f.MustWrite()
+
+	// The second record has a null CLOSED and a blank JOINED
+	f.MustAppend()
+	f.MustPut(scanCustomer{Name: "Bob", Raw: "Bob"})
+	f.MustWrite()
+
+	var out scanCustomer
+	f.MustGoto(1)
+	f.MustScan(&out)
+	switch {
+	case out.ID != 42:
+		t.Errorf("ID = %d, want 42", out.ID)
+	case out.Name != "Alice":
+		t.Errorf("Name = %q, want %q", out.Name, "Alice")
+	case out.Raw != "Alice       ":
+		t.Errorf("Raw = %q, want the padded value", out.Raw)
+	case out.Amount != 123.45:
+		t.Errorf("Amount = %v, want 123.45", out.Amount)
+	case out.Visits == nil || *out.Visits != 7:
+		t.Errorf("Visits = %v, want 7", out.Visits)
+	case !out.Active:
+		t.Error("Active = false, want true")
+	case !out.Joined.Equal(in.Joined):
+		t.Errorf("Joined = %v, want %v", out.Joined, in.Joined)
+	case out.Closed == nil || !out.Closed.Equal(closed):
+		t.Errorf("Closed = %v, want %v", out.Closed, closed)
+	case out.Notes != "long notes":
+		t.Errorf("Notes = %q, want %q", out.Notes, "long notes")
+	case out.Comment != "":
+		t.Errorf("Comment = %q, want it skipped", out.Comment)
+	}
+	if got := f.FieldByName("comment").MustAsString(); strings.TrimSpace(got) != "" {
+		t.Errorf("COMMENT = %q, want it left blank by Put", got)
+	}
+
+	// Scanning reuses the struct, resetting the pointer for null
+	f.MustGoto(2)
+	f.MustScan(&out)
+	if out.Name != "Bob" || out.ID != 0 {
+		t.Errorf("got %q/%d, want Bob/0", out.Name, out.ID)
+	}
+	if out.Closed != nil {
+		t.Errorf("Closed = %v, want nil", out.Closed)
+	}
+	if !out.Joined.IsZero() {
+		t.Errorf("Joined = %v, want the zero time", out.Joined)
+	}
+}
+
+func TestScanErrors(t *testing.T) {
+	f := createScanTable(t)
+	defer f.Close()
+	f.MustAppend()
+
+	var customer scanCustomer
+	if err := f.Scan(customer); err == nil {
+		t.Error("expected error scanning into a non-pointer")
+	}
+
+	var missing struct {
+		Name string `dbf:"NOSUCH"`
+	}
+	if err := f.Scan(&missing); err == nil {
+		t.Error("expected error for a tagged field missing from the table")
+	}
+
+	var memo struct {
+		Notes string `dbf:"NOTES"`
+	}
+	if err := f.Scan(&memo); err == nil {
+		t.Error("expected error for a memo field without the memo option")
+	}
+
+	var notPointer struct {
+		Closed time.Time `dbf:"CLOSED,null"`
+	}
+	if err := f.Scan(&notPointer); err == nil {
+		t.Error("expected error for the null option on a non-pointer")
+	}
+
+	var badOption struct {
+		Name string `dbf:"NAME,upper"`
+	}
+	if err := f.Put(badOption); err == nil {
+		t.Error("expected error for an unknown option")
+	}
+
+	var unsupported struct {
+		Name []string `dbf:"NAME"`
+	}
+	if err := f.Put(&unsupported); err == nil {
+		t.Error("expected error for an unsupported field type")
+	}
+}
+
+func BenchmarkScan(b *testing.B) {
+	path := filepath.Join(b.TempDir(), "bench.dbf")
+	f, err := foxi.Create(path, foxi.Schema{Fields: []foxi.FieldSpec{
+		{Name: "ID", Type: foxi.FTInteger},
+		{Name: "NAME", Type: foxi.FTCharacter, Size: 20},
+		{Name: "AMOUNT", Type: foxi.FTNumeric, Size: 10, Decimals: 2},
+	}}, nil)
+	if err != nil {
+		b.Fatalf("Create failed: %v", err)
+	}
+	defer f.Close()
+	f.MustAppend()
+	f.MustPut(struct {
+		ID     int
+		Name   string
+		Amount float64
+	}{1, "name", 2.5})
+	f.MustWrite()
+
+	var row struct {
+		ID     int     `dbf:"ID"`
+		Name   string  `dbf:"NAME,trim"`
+		Amount float64 `dbf:"AMOUNT"`
+	}
+	b.ReportAllocs()
+	for b.Loop() {
+		f.MustScan(&row)
+	}
+}
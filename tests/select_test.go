@@ -0,0 +1,497 @@
+package tests
+
+import (
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mkfoss/foxi"
+	"github.com/mkfoss/foxi/expr"
+	"github.com/mkfoss/foxi/sql"
+)
+
+// openSelectDB creates CUSTOMER and ORDERS tables in a new directory and
+// returns a DB over it. Order 6 is deleted and order 7 has no customer.
+func openSelectDB(t *testing.T) (*sql.DB, string) {
+	t.Helper()
+
+	dir := t.TempDir()
+	customers, err := foxi.Create(filepath.Join(dir, "customer.dbf"), foxi.Schema{
+		Fields: []foxi.FieldSpec{
+			{Name: "ID", Type: foxi.FTInteger},
+			{Name: "NAME", Type: foxi.FTCharacter, Size: 10},
+			{Name: "CITY", Type: foxi.FTCharacter, Size: 10},
+		},
+		Tags: []foxi.TagSpec{
+			{Name: "id", Expression: "ID"},
+			{Name: "city", Expression: "CITY"},
+		},
+	}, nil)
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	for i, c := range []struct{ name, city string }{
+		{"Alice", "Oslo"}, {"Bob", "Bergen"}, {"Carol", "Oslo"}, {"Dave", "Tromso"},
+	} {
+		customers.MustAppend()
+		customers.FieldByName("id").MustSetInt(i + 1)
+		customers.FieldByName("name").MustSetString(c.name)
+		customers.FieldByName("city").MustSetString(c.city)
+		customers.MustWrite()
+	}
+	customers.Close()
+
+	orders, err := foxi.Create(filepath.Join(dir, "Orders.dbf"), foxi.Schema{
+		Fields: []foxi.FieldSpec{
+			{Name: "ID", Type: foxi.FTInteger},
+			{Name: "CUSTID", Type: foxi.FTInteger},
+			{Name: "AMOUNT", Type: foxi.FTNumeric, Size: 8, Decimals: 2},
+			{Name: "ODATE", Type: foxi.FTDate},
+		},
+		Tags: []foxi.TagSpec{{Name: "custid", Expression: "CUSTID"}},
+	}, nil)
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	start := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	for i, o := range []struct {
+		custid int
+		amount float64
+	}{{1, 100}, {1, 50.5}, {2, 20}, {3, 75}, {3, 25}, {2, 10}, {9, 5}} {
+		orders.MustAppend()
+		orders.FieldByName("id").MustSetInt(i + 1)
+		orders.FieldByName("custid").MustSetInt(o.custid)
+		orders.FieldByName("amount").MustSetFloat(o.amount)
+		orders.FieldByName("odate").MustSetTime(start.AddDate(0, 0, i))
+		orders.MustWrite()
+	}
+	orders.MustGoto(6)
+	orders.MustDelete()
+	orders.Close()
+
+	db := sql.NewDB(dir)
+	t.Cleanup(func() { db.Close() })
+	return db, dir
+}
+
+// selectRows runs a query and returns its rows with their values joined
+// by |: strings without trailing blanks, numbers with the decimal places
+// of their column, dates as YYYY-MM-DD and nulls as NULL
+func selectRows(t *testing.T, db *sql.DB, query string, args ...any) []string {
+	t.Helper()
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		t.Fatalf("%s: %v", query, err)
+	}
+	defer rows.Close()
+
+	var result []string
+	for rows.Next() {
+		var parts []string
+		for _, v := range rows.Values() {
+			switch v := v.(type) {
+			case nil:
+				parts = append(parts, "NULL")
+			case string:
+				parts = append(parts, strings.TrimRight(v, " "))
+			case float64:
+				parts = append(parts, strconv.FormatFloat(v, 'f', -1, 64))
+			case foxi.Decimal:
+				parts = append(parts, v.Rat().FloatString(v.Scale()))
+			case time.Time:
+				parts = append(parts, v.Format("2006-01-02"))
+			default:
+				parts = append(parts, fmt.Sprint(v))
+			}
+		}
+		result = append(result, strings.Join(parts, "|"))
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatalf("%s: %v", query, err)
+	}
+	return result
+}
+
+func TestSelect(t *testing.T) {
+	db, _ := openSelectDB(t)
+
+	tests := []struct {
+		query string
+		args  []any
+		want  []string
+	}{
+		{`SELECT name, city FROM customer WHERE city = 'Oslo'`, nil,
+			[]string{"Alice|Oslo", "Carol|Oslo"}},
+		{`SELECT * FROM orders WHERE id < 3`, nil,
+			[]string{"1|1|100.00|2024-05-01", "2|1|50.50|2024-05-02"}},
+		{`SELECT name FROM customer WHERE name LIKE 'C%' OR id IN (1, 2) ORDER BY name DESC`, nil,
+			[]string{"Carol", "Bob", "Alice"}},
+		{`SELECT id FROM orders WHERE amount BETWEEN ? AND ? ORDER BY 1`, []any{20, 75},
+			[]string{"2", "3", "4", "5"}},
+		{`SELECT id FROM orders WHERE odate >= ? AND NOT amount > 50`, []any{time.Date(2024, 5, 3, 0, 0, 0, 0, time.UTC)},
+			[]string{"3", "5", "7"}},
+		{`SELECT name FROM customer WHERE name = ?`, []any{"Da"},
+			[]string{"Dave"}},
+		{`SELECT DISTINCT city FROM customer ORDER BY city`, nil,
+			[]string{"Bergen", "Oslo", "Tromso"}},
+		{`SELECT TOP 2 id, amount * 2 AS double FROM orders ORDER BY amount DESC`, nil,
+			[]string{"1|200.00", "4|150.00"}},
+		{`SELECT UPPER(LEFT(name, 3)) FROM customer WHERE id = 2`, nil,
+			[]string{"BOB"}},
+		{`SELECT id FROM orders ORDER BY odate DESC, id`, nil,
+			[]string{"7", "5", "4", "3", "2", "1"}},
+		{`SELECT id FROM orders ORDER BY id LIMIT 2 OFFSET 1`, nil,
+			[]string{"2", "3"}},
+		{`SELECT TOP 3 id FROM orders ORDER BY id LIMIT 5 OFFSET 4`, nil,
+			[]string{"5", "7"}},
+		{`SELECT id FROM orders WHERE custid = ? LIMIT ?`, []any{1, 0}, nil},
+		{"SELECT RTRIM(name) || '''s ' || city FROM customer WHERE `id` = 2", nil,
+			[]string{"Bob's Bergen"}},
+
+		// Joins
+		{`SELECT c.name, o.amount FROM customer c JOIN orders o ON o.custid = c.id ORDER BY o.id`, nil,
+			[]string{"Alice|100.00", "Alice|50.50", "Bob|20.00", "Carol|75.00", "Carol|25.00"}},
+		{`SELECT c.name, o.id FROM orders o, customer c WHERE c.id = o.custid AND o.amount > 60`, nil,
+			[]string{"Alice|1", "Carol|4"}},
+		{`SELECT c.name, o.amount FROM customer c LEFT JOIN orders o ON o.custid = c.id WHERE c.id > 2`, nil,
+			[]string{"Carol|75.00", "Carol|25.00", "Dave|NULL"}},
+		{`SELECT c.name FROM customer c LEFT OUTER JOIN orders o ON o.custid = c.id WHERE o.id IS NULL`, nil,
+			[]string{"Dave"}},
+		{`SELECT c.name, o.id FROM customer c LEFT JOIN orders o ON o.custid = c.id AND o.amount > 60`, nil,
+			[]string{"Alice|1", "Bob|NULL", "Carol|4", "Dave|NULL"}},
+		{`SELECT a.name, b.name FROM customer a JOIN customer b ON b.city = a.city AND b.id > a.id`, nil,
+			[]string{"Alice|Carol"}},
+
+		// Aggregates
+		{`SELECT COUNT(*), SUM(amount), MAX(amount), MIN(odate) FROM orders`, nil,
+			[]string{"6|275.50|100.00|2024-05-01"}},
+		{`SELECT COUNT(*), SUM(amount) FROM orders WHERE amount > 1000`, nil,
+			[]string{"0|NULL"}},
+		{`SELECT COUNT(DISTINCT custid) FROM orders`, nil,
+			[]string{"4"}},
+		{`SELECT c.city, COUNT(*), SUM(o.amount) AS total FROM customer c JOIN orders o ON o.custid = c.id
+			GROUP BY c.city HAVING SUM(o.amount) > 15 ORDER BY total DESC`, nil,
+			[]string{"Oslo|4|250.50", "Bergen|1|20.00"}},
+		{`SELECT custid, COUNT(*) FROM orders GROUP BY custid`, nil,
+			[]string{"1|2", "2|1", "3|2", "9|1"}},
+		{`SELECT c.name, COUNT(o.id) AS n FROM customer c LEFT JOIN orders o ON o.custid = c.id GROUP BY 1 ORDER BY n DESC, 1`, nil,
+			[]string{"Alice|2", "Carol|2", "Bob|1", "Dave|0"}},
+	}
+	for _, tt := range tests {
+		got := selectRows(t, db, tt.query, tt.args...)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s\ngot  %q\nwant %q", tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestSelectColumns(t *testing.T) {
+	db, _ := openSelectDB(t)
+
+	rows, err := db.Query(`SELECT c.id, o.id, c.name, COUNT(*), SUM(o.amount), AVG(o.amount) AS mean, o.id + 1
+		FROM customer c JOIN orders o ON o.custid = c.id GROUP BY c.id, o.id, c.name`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+
+	var names []string
+	for _, col := range rows.Columns() {
+		names = append(names, col.Name)
+	}
+	want := []string{"ID_A", "ID_B", "NAME", "CNT", "SUM_AMOUNT", "MEAN", "EXP_7"}
+	if !reflect.DeepEqual(names, want) {
+		t.Errorf("columns %v, want %v", names, want)
+	}
+	if col := rows.Columns()[2]; col.Type != expr.Character || col.Len != 10 {
+		t.Errorf("NAME is %s(%d), want C(10)", col.Type, col.Len)
+	}
+	if col := rows.Columns()[3]; col.Type != expr.Integer {
+		t.Errorf("CNT is %s, want I", col.Type)
+	}
+	if !rows.Next() {
+		t.Fatal("no rows")
+	}
+	if id, ok := rows.Values()[0].(int); !ok || id != 1 {
+		t.Errorf("first ID is %#v, want int 1", rows.Values()[0])
+	}
+}
+
+// TestSelectSeek checks that a joined table is read through a tag on the
+// join condition
+func TestSelectSeek(t *testing.T) {
+	db, _ := openSelectDB(t)
+
+	reads := 0
+	if err := expr.RegisterFunc("SELECT_READ", func([]any) (any, error) {
+		reads++
+		return true, nil
+	}, []expr.Type{expr.Integer}, expr.Logical); err != nil {
+		t.Fatal(err)
+	}
+
+	got := selectRows(t, db, `SELECT o.id FROM customer c JOIN orders o ON o.custid = c.id WHERE SELECT_READ(o.id)`)
+	if len(got) != 5 {
+		t.Errorf("got %d rows, want 5", len(got))
+	}
+	if reads != 5 {
+		t.Errorf("%d orders read, want the 5 of the customers", reads)
+	}
+
+	// Without a tag on the condition the orders matching their own
+	// conditions are read once, and not again for every customer
+	reads = 0
+	got = selectRows(t, db, `SELECT o.id FROM customer c JOIN orders o ON o.id = c.id WHERE SELECT_READ(o.id)`)
+	if len(got) != 4 {
+		t.Errorf("got %d rows, want 4", len(got))
+	}
+	if reads != 7 {
+		t.Errorf("%d orders read, want 7", reads)
+	}
+}
+
+func TestSelectTableState(t *testing.T) {
+	db, _ := openSelectDB(t)
+
+	f, err := db.Table("CUSTOMER")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.MustSetFilter(`CITY = "Oslo"`)
+	f.Indexes().MustSelectTag(f.Indexes().TagByName("city"))
+	f.MustGoto(3)
+
+	// The query sees every record and leaves the table as it was, even
+	// when its rows are not all read
+	if got := selectRows(t, db, `SELECT COUNT(*) FROM customer`); !reflect.DeepEqual(got, []string{"4"}) {
+		t.Errorf("COUNT(*) gave %v, want 4", got)
+	}
+	rows, err := db.Query(`SELECT name FROM customer c JOIN orders o ON o.custid = c.id`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows.Next()
+	rows.Close()
+	if f.Filter() != `CITY = "Oslo"` || f.Indexes().SelectedTag() == nil || f.Indexes().SelectedTag().Name() != "CITY" || f.Position() != 3 {
+		t.Errorf("table left with filter %q, tag %v at record %d", f.Filter(), f.Indexes().SelectedTag(), f.Position())
+	}
+}
+
+func TestSelectIntoTable(t *testing.T) {
+	db, dir := openSelectDB(t)
+
+	n, err := db.Exec(`SELECT c.name, SUM(o.amount) AS total, MAX(o.odate) AS last, COUNT(*)
+		FROM customer c LEFT JOIN orders o ON o.custid = c.id GROUP BY 1 INTO TABLE totals`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 4 {
+		t.Errorf("wrote %d rows, want 4", n)
+	}
+	got := selectRows(t, db, `SELECT * FROM totals ORDER BY total`)
+	want := []string{"Dave|NULL|NULL|1", "Bob|20.00|2024-05-03|1", "Carol|100.00|2024-05-05|2", "Alice|150.50|2024-05-02|2"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("totals holds %q, want %q", got, want)
+	}
+
+	// The table is a DBF in the directory
+	f := foxi.NewFoxi()
+	if err := f.Open(filepath.Join(dir, "totals.dbf")); err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	for _, tt := range []struct {
+		name     string
+		typ      foxi.FieldType
+		nullable bool
+	}{
+		{"NAME", foxi.FTCharacter, false},
+		{"TOTAL", foxi.FTNumeric, true},
+		{"LAST", foxi.FTDate, true},
+		{"CNT", foxi.FTInteger, false},
+	} {
+		field := f.FieldByName(tt.name)
+		if field == nil || field.Type() != tt.typ || field.IsNullable() != tt.nullable {
+			t.Errorf("field %s missing or of the wrong type", tt.name)
+		}
+	}
+
+	// Writing it again replaces it
+	if n, err := db.Exec(`SELECT name FROM customer WHERE city = ? INTO TABLE totals`, "Oslo"); err != nil || n != 2 {
+		t.Errorf("Exec gave %d, %v, want 2 rows", n, err)
+	}
+	if got := selectRows(t, db, `SELECT COUNT(*) FROM totals`); !reflect.DeepEqual(got, []string{"2"}) {
+		t.Errorf("COUNT(*) gave %v, want 2", got)
+	}
+}
+
+// openDecimalDB creates table T with a currency field Y holding the
+// largest currency value, a N(6,2) field A and a double field B, and
+// returns a DB over its directory
+func openDecimalDB(t *testing.T) (*sql.DB, string) {
+	t.Helper()
+
+	dir := t.TempDir()
+	f, err := foxi.Create(filepath.Join(dir, "t.dbf"), foxi.Schema{
+		Fields: []foxi.FieldSpec{
+			{Name: "Y", Type: foxi.FTCurrency},
+			{Name: "A", Type: foxi.FTNumeric, Size: 6, Decimals: 2},
+			{Name: "B", Type: foxi.FTDouble, Decimals: 2},
+		},
+	}, nil)
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	f.MustAppend()
+	f.FieldByName("y").MustSetDecimal(foxi.MustParseDecimal("922337203685.4775"))
+	f.FieldByName("a").MustSetFloat(999.99)
+	f.FieldByName("b").MustSetFloat(1.5)
+	f.MustWrite()
+	f.Close()
+
+	db := sql.NewDB(dir)
+	t.Cleanup(func() { db.Close() })
+	return db, dir
+}
+
+func TestSelectDecimals(t *testing.T) {
+	db, _ := openDecimalDB(t)
+
+	rows, err := db.Query(`SELECT y, SUM(y) AS total, AVG(a) AS mean, a / 3 AS third FROM t`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		t.Fatalf("no row: %v", rows.Err())
+	}
+	want := []string{"922337203685.4775", "922337203685.4775", "999.9900", "333.33"}
+	for i, v := range rows.Values() {
+		d, ok := v.(foxi.Decimal)
+		if !ok || d.String() != want[i] {
+			t.Errorf("column %s = %#v, want foxi.Decimal %s", rows.Columns()[i].Name, v, want[i])
+		}
+	}
+}
+
+func TestSelectIntoFieldTypes(t *testing.T) {
+	db, dir := openDecimalDB(t)
+
+	if _, err := db.Exec(`SELECT y, b, a + a AS s, a * a AS p FROM t INTO TABLE o3`); err != nil {
+		t.Fatal(err)
+	}
+	out := foxi.NewFoxi()
+	if err := out.Open(filepath.Join(dir, "o3.dbf")); err != nil {
+		t.Fatal(err)
+	}
+	defer out.Close()
+	out.MustFirst()
+	for _, tt := range []struct {
+		name  string
+		typ   foxi.FieldType
+		value string
+	}{
+		{"Y", foxi.FTCurrency, "922337203685.4775"},
+		{"B", foxi.FTBlob, "1.5"}, // Double fields read back as type B
+		{"S", foxi.FTNumeric, "1999.98"},
+		{"P", foxi.FTNumeric, "999980.0001"},
+	} {
+		field := out.FieldByName(tt.name)
+		if field == nil || field.Type() != tt.typ {
+			t.Errorf("field %s missing or not of type %v", tt.name, tt.typ)
+			continue
+		}
+		if d, err := field.AsDecimal(); err != nil || d.String() != tt.value {
+			t.Errorf("field %s holds %v, %v, want %s", tt.name, d, err, tt.value)
+		}
+	}
+
+	// A failed Exec leaves the table it would replace as it was
+	if _, err := db.Exec(`SELECT a FROM t INTO TABLE o1`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(`SELECT a * 1e20 AS a FROM t INTO TABLE o1`); err == nil {
+		t.Error("Exec of values wider than a numeric field should fail")
+	}
+	if got := selectRows(t, db, `SELECT * FROM o1`); !reflect.DeepEqual(got, []string{"999.99"}) {
+		t.Errorf("o1 holds %q after a failed Exec", got)
+	}
+	if _, err := db.Exec(`SELECT a * 1e20 AS a FROM t INTO TABLE o2`); err == nil {
+		t.Error("Exec of values wider than a numeric field should fail")
+	}
+	if _, err := db.Table("o2"); err == nil {
+		t.Error("a failed Exec left table o2 behind")
+	}
+	entries, _ := filepath.Glob(filepath.Join(dir, "sql*"))
+	if len(entries) != 0 {
+		t.Errorf("a failed Exec left files %v", entries)
+	}
+}
+
+func TestSelectAddedTable(t *testing.T) {
+	f := createOrderTable(t)
+	defer f.Close()
+
+	db := sql.NewDB("")
+	defer db.Close()
+	if err := db.Add("ord", f); err != nil {
+		t.Fatal(err)
+	}
+	got := selectRows(t, db, `SELECT state, COUNT(*) FROM ord WHERE active AND qty < 50 GROUP BY state`)
+	want := unfiltered(t, f, `ACTIVE .AND. QTY < 50`)
+	total := 0
+	for _, row := range got {
+		n, _ := strconv.Atoi(row[strings.Index(row, "|")+1:])
+		total += n
+	}
+	if total != len(want) {
+		t.Errorf("groups %v count %d records, want %d", got, total, len(want))
+	}
+
+	if _, err := db.Query(`SELECT a.id FROM ord a, ord b`); err == nil {
+		t.Error("reading an added table twice should fail")
+	}
+	if f.Active() == false {
+		t.Error("Close closed an added table")
+	}
+}
+
+func TestSelectErrors(t *testing.T) {
+	db, _ := openSelectDB(t)
+
+	for _, query := range []string{
+		`SELECT nothing FROM customer`,
+		`SELECT x.name FROM customer c`,
+		`SELECT id FROM customer c JOIN orders o ON o.custid = c.id`,
+		`SELECT name FROM missing`,
+		`SELECT c.name FROM orders o RIGHT JOIN customer c ON o.custid = c.id`,
+		`SELECT name FROM customer WHERE id IN (SELECT custid FROM orders)`,
+		`SELECT name FROM customer WHERE COUNT(*) > 1`,
+		`SELECT SUM(name) FROM customer`,
+		`SELECT name FROM customer WHERE name`,
+		`SELECT name FROM customer WHERE id = ?`,
+		`SELECT name FROM customer c, orders c`,
+		`SELECT name FROM customer INTO TABLE out`,
+		`SELECT name FROM customer ORDER`,
+		`SELECT name FROM customer LIMIT -1`,
+		`SELECT name FROM customer LIMIT id`,
+		`SELECT name FROM customer LIMIT 1.5`,
+		`DELETE FROM customer`,
+	} {
+		if rows, err := db.Query(query); err == nil {
+			rows.Close()
+			t.Errorf("%s: expected an error", query)
+		}
+	}
+	if _, err := db.Exec(`SELECT name FROM customer`); err == nil {
+		t.Error("Exec without INTO TABLE should fail")
+	}
+	if _, err := db.Exec(`SELECT name FROM customer INTO TABLE customer`); err == nil {
+		t.Error("writing the result over a table the query reads should fail")
+	}
+}
@@ -0,0 +1,251 @@
+package tests
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mkfoss/foxi"
+)
+
+// createSessionTables creates PEOPLE (NAME, BORN) and SCORES (NAME, SCORE)
+// in a session, with two people and a score for each
+func createSessionTables(t *testing.T, s *foxi.Session) (dir string, people, scores *foxi.Foxi) {
+	t.Helper()
+	dir = t.TempDir()
+
+	people, err := s.Create(filepath.Join(dir, "people"), foxi.Schema{
+		Fields: []foxi.FieldSpec{{Name: "NAME", Type: foxi.FTCharacter, Size: 10}, {Name: "BORN", Type: foxi.FTDate}},
+	}, nil)
+	if err != nil {
+		t.Fatalf("Create people failed: %v", err)
+	}
+	scores, err = s.Create(filepath.Join(dir, "scores"), foxi.Schema{
+		Fields: []foxi.FieldSpec{{Name: "NAME", Type: foxi.FTCharacter, Size: 10}, {Name: "SCORE", Type: foxi.FTInteger}},
+		Tags:   []foxi.TagSpec{{Name: "name", Expression: "NAME"}},
+	}, nil)
+	if err != nil {
+		t.Fatalf("Create scores failed: %v", err)
+	}
+
+	for i, name := range []string{"ann", "bob"} {
+		people.MustAppend()
+		people.FieldByName("name").MustSetString(name)
+		people.FieldByName("born").MustSetTime(time.Date(1990+i, 3, 15, 0, 0, 0, 0, time.UTC))
+		people.MustWrite()
+		scores.MustAppend()
+		scores.FieldByName("name").MustSetString(name)
+		scores.FieldByName("score").MustSetInt(10 * (i + 1))
+		scores.MustWrite()
+	}
+	return dir, people, scores
+}
+
+func TestSession(t *testing.T) {
+	s := foxi.NewSession()
+	defer s.Close()
+	dir, people, scores := createSessionTables(t, s)
+
+	if s.Table("people") != people || s.Table("SCORES") != scores || s.Table("other") != nil {
+		t.Error("Table did not find the tables by alias")
+	}
+	if tables := s.Tables(); len(tables) != 2 || tables[0] != people || tables[1] != scores {
+		t.Errorf("Tables = %v", tables)
+	}
+	if people.Session() != s {
+		t.Error("Session did not return the table's session")
+	}
+
+	// A second alias opens the same table again
+	if _, err := s.Open(filepath.Join(dir, "scores.dbf")); err == nil {
+		t.Error("opening a table under an alias in use should fail")
+	}
+	if _, err := s.OpenAs(filepath.Join(dir, "scores.dbf"), "2nd"); err == nil {
+		t.Error("an alias starting with a digit should fail")
+	}
+	best := s.MustOpenAs(filepath.Join(dir, "scores.dbf"), "best")
+	if best.Alias() != "BEST" || s.Table("Best") != best {
+		t.Errorf("OpenAs gave alias %q", best.Alias())
+	}
+
+	// Expressions read the other tables of the session through their alias
+	people.MustGoto(2)
+	best.MustGoto(2)
+	prog := foxi.MustCompile(people, "TRIM(NAME) + STR(BEST.SCORE, 3)")
+	if v, _ := prog.Eval(); v != "bob 20" {
+		t.Errorf("cross-table expression gave %q", v)
+	}
+	best.MustSkip(1)
+	if v, _ := foxi.MustCompile(people, "BEST.SCORE").Eval(); v != nil {
+		t.Errorf("a table at EOF should read as null, got %v", v)
+	}
+
+	// A closed table is no longer found, and its alias is free again
+	best.Close()
+	if s.Table("best") != nil {
+		t.Error("a closed table was still found")
+	}
+	if _, err := s.OpenAs(filepath.Join(dir, "people.dbf"), "best"); err != nil {
+		t.Errorf("reusing the alias of a closed table failed: %v", err)
+	}
+
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if people.Active() || scores.Active() {
+		t.Error("Close left tables open")
+	}
+	if _, err := s.Open(filepath.Join(dir, "people.dbf")); err == nil {
+		t.Error("opening a table in a closed session should fail")
+	}
+}
+
+func TestSessionTransaction(t *testing.T) {
+	s := foxi.NewSession()
+	defer s.Close()
+	dir, people, scores := createSessionTables(t, s)
+
+	if _, err := foxi.NewSession().Begin(); err == nil {
+		t.Error("Begin without tables should fail")
+	}
+
+	// Rollback undoes the changes to both tables
+	tx := s.MustBegin()
+	people.MustGoto(1)
+	people.FieldByName("name").MustSetString("changed")
+	people.MustWrite()
+	scores.MustAppend()
+	scores.FieldByName("name").MustSetString("cid")
+	scores.MustWrite()
+	if err := people.Close(); err == nil {
+		t.Error("closing a session table inside a transaction should fail")
+	}
+	if _, err := people.Begin(); err == nil {
+		t.Error("a second transaction in the session should fail")
+	}
+	tx.MustRollback()
+
+	people.MustGoto(1)
+	if got := strings.TrimSpace(people.FieldByName("name").MustAsString()); got != "ann" {
+		t.Errorf("NAME after rollback = %q, want ann", got)
+	}
+	header := scores.Header()
+	if count := header.RecordCount(); count != 2 {
+		t.Errorf("SCORES has %d records after rollback, want 2", count)
+	}
+	if got := scores.Indexes().TagByName("name").MustSeekString("cid"); got == foxi.SeekSuccess {
+		t.Error("the rolled back key is still in the tag")
+	}
+
+	// Commit keeps the changes to both tables, the one begun on a table too
+	tx = people.MustBegin()
+	people.MustGoto(2)
+	people.MustDelete()
+	scores.MustGoto(2)
+	scores.FieldByName("score").MustSetInt(99)
+	scores.MustWrite()
+	tx.MustCommit()
+	s.Close()
+
+	s = foxi.NewSession()
+	defer s.Close()
+	people = s.MustOpen(filepath.Join(dir, "people.dbf"))
+	scores = s.MustOpen(filepath.Join(dir, "scores.dbf"))
+	people.MustGoto(2)
+	scores.MustGoto(2)
+	if !people.Deleted() || scores.FieldByName("score").MustAsInt() != 99 {
+		t.Error("the committed changes were not kept")
+	}
+
+	// Closing the session rolls back its transaction
+	s.MustBegin()
+	scores.FieldByName("score").MustSetInt(1)
+	scores.MustWrite()
+	s.Close()
+	s = foxi.NewSession()
+	scores = s.MustOpen(filepath.Join(dir, "scores.dbf"))
+	scores.MustGoto(2)
+	if got := scores.FieldByName("score").MustAsInt(); got != 99 {
+		t.Errorf("SCORE after closing the session = %d, want 99", got)
+	}
+}
+
+func TestSessionDateFormat(t *testing.T) {
+	s := foxi.NewSession()
+	defer s.Close()
+	_, people, _ := createSessionTables(t, s)
+	people.MustGoto(1)
+
+	eval := func(source string) any {
+		t.Helper()
+		v, err := foxi.MustCompile(people, source).Eval()
+		if err != nil {
+			t.Fatalf("%s: %v", source, err)
+		}
+		return v
+	}
+
+	if got := eval("DTOC(BORN)"); got != "03/15/90" {
+		t.Errorf("default DTOC = %q", got)
+	}
+	if err := s.SetDateFormat("dd.mm.ccyy"); err != nil {
+		t.Fatal(err)
+	}
+	if s.DateFormat() != "DD.MM.CCYY" {
+		t.Errorf("DateFormat = %q", s.DateFormat())
+	}
+	tests := []struct {
+		source string
+		want   any
+	}{
+		{"DTOC(BORN)", "15.03.1990"},
+		{"DTOC({})", "  .  .    "},
+		{"LEN(DTOC(BORN))", 10.0},
+		{"TTOC(DTOT(BORN))", "15.03.1990 12:00:00 AM"},
+		{"TRANSFORM(BORN)", "15.03.1990"},
+		{`CTOD("15.03.1990") = BORN`, true},
+		{`CTOD("15.03.90") = BORN`, true},
+		{`CTOD("^1990-03-15") = BORN`, true},
+	}
+	for _, tt := range tests {
+		if got := eval(tt.source); got != tt.want {
+			t.Errorf("%s = %v, want %v", tt.source, got, tt.want)
+		}
+	}
+
+	if err := s.SetDateFormat("CCYY-MM-DD"); err != nil {
+		t.Fatal(err)
+	}
+	if got := eval("DTOC(BORN)"); got != "1990-03-15" {
+		t.Errorf("CCYY-MM-DD DTOC = %q", got)
+	}
+
+	for _, format := range []string{"MM/DD", "MM/DD/YY/YY", "MM/DD/XX", ""} {
+		if err := s.SetDateFormat(format); err == nil {
+			t.Errorf("SetDateFormat(%q) should fail", format)
+		}
+	}
+}
+
+func TestSessionSettings(t *testing.T) {
+	s := foxi.NewSession()
+	defer s.Close()
+	if err := s.SetCodepage(foxi.Codepage1251); err != nil {
+		t.Fatal(err)
+	}
+	_, people, _ := createSessionTables(t, s)
+	header := people.Header()
+	if header.Codepage() != foxi.Codepage1251 || people.Codepage() != foxi.Codepage1251 {
+		t.Errorf("Create marked code page %v", header.Codepage())
+	}
+	if err := s.SetCodepage(foxi.CodepageNone); err != nil {
+		t.Fatal(err)
+	}
+	if people.Codepage() != foxi.CodepageNone {
+		t.Error("SetCodepage did not reach the open tables")
+	}
+	if err := s.SetCodepage(foxi.Codepage(0xEE)); err == nil {
+		t.Error("an unknown code page should fail")
+	}
+}
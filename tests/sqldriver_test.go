@@ -0,0 +1,434 @@
+package tests
+
+import (
+	"database/sql"
+	"fmt"
+	"math"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/mkfoss/foxi"
+	foxisql "github.com/mkfoss/foxi/sql"
+	_ "github.com/mkfoss/foxi/sqldriver"
+)
+
+// openSQLDir creates a CUSTOMER table with tags on CITY and VISITS in a
+// new directory and opens the directory with the foxi SQL driver
+func openSQLDir(t *testing.T) (*sql.DB, string) {
+	t.Helper()
+
+	dir := t.TempDir()
+	schema := foxi.Schema{
+		Fields: []foxi.FieldSpec{
+			{Name: "NAME", Type: foxi.FTCharacter, Size: 20},
+			{Name: "CITY", Type: foxi.FTCharacter, Size: 15},
+			{Name: "AMOUNT", Type: foxi.FTNumeric, Size: 8, Decimals: 2},
+			{Name: "VISITS", Type: foxi.FTNumeric, Size: 5},
+			{Name: "ACTIVE", Type: foxi.FTLogical},
+		},
+		Tags: []foxi.TagSpec{
+			{Name: "city", Expression: "CITY"},
+			{Name: "visits", Expression: "VISITS"},
+		},
+	}
+	f, err := foxi.Create(filepath.Join(dir, "Customer.dbf"), schema, nil)
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	f.Close()
+
+	db, err := sql.Open("foxi", dir)
+	if err != nil {
+		t.Fatalf("sql.Open failed: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	_, err = db.Exec(`INSERT INTO customer (name, city, amount, visits, active) VALUES
+		('Alice', 'Oslo', 120.50, 3, TRUE),
+		('Bob', 'Bergen', 80, 1, FALSE),
+		('Carol', 'Oslo', 15.25, 7, TRUE),
+		('Dave', 'Oslo East', 300, 2, TRUE),
+		('Eve', 'Trondheim', 42, 3, FALSE)`)
+	if err != nil {
+		t.Fatalf("INSERT failed: %v", err)
+	}
+	return db, dir
+}
+
+// queryStrings runs a query returning one column and collects its values
+func queryStrings(t *testing.T, db interface {
+	Query(string, ...any) (*sql.Rows, error)
+}, query string, args ...any) []string {
+	t.Helper()
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		t.Fatalf("%s: %v", query, err)
+	}
+	defer rows.Close()
+
+	var values []string
+	for rows.Next() {
+		var value any
+		if err := rows.Scan(&value); err != nil {
+			t.Fatalf("Scan failed: %v", err)
+		}
+		values = append(values, fmt.Sprint(value))
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatalf("%s: %v", query, err)
+	}
+	return values
+}
+
+func TestSQLSelect(t *testing.T) {
+	db, _ := openSQLDir(t)
+
+	tests := []struct {
+		query string
+		args  []any
+		want  []string
+	}{
+		{"SELECT name FROM customer", nil, []string{"Alice", "Bob", "Carol", "Dave", "Eve"}},
+		{"SELECT name FROM customer WHERE city = 'Oslo'", nil, []string{"Alice", "Carol", "Dave"}},
+		{"SELECT name FROM customer WHERE RTRIM(city) == 'Oslo'", nil, []string{"Alice", "Carol"}},
+		{"SELECT name FROM customer WHERE RTRIM(city) == ? AND amount > ?", []any{"Oslo", 100}, []string{"Alice"}},
+		{"SELECT name FROM customer WHERE visits = 3", nil, []string{"Alice", "Eve"}},
+		{"SELECT name FROM customer WHERE city LIKE 'Os%' ORDER BY amount DESC", nil, []string{"Dave", "Alice", "Carol"}},
+		{"SELECT name FROM customer WHERE NOT active OR visits IN (7)", nil, []string{"Bob", "Carol", "Eve"}},
+		{"SELECT name FROM customer WHERE amount BETWEEN 40 AND 130 ORDER BY name", nil, []string{"Alice", "Bob", "Eve"}},
+		{"SELECT name FROM customer ORDER BY visits, name DESC LIMIT 3 OFFSET 1", nil, []string{"Dave", "Eve", "Alice"}},
+		{"SELECT UPPER(name) AS n FROM customer WHERE visits * 2 > 5 ORDER BY n", nil, []string{"ALICE", "CAROL", "EVE"}},
+		{"SELECT amount FROM customer WHERE name = 'Carol'", nil, []string{"15.25"}},
+		{"SELECT visits FROM customer WHERE name = 'Bob'", nil, []string{"1"}},
+		{"SELECT RTRIM(name) || '/' || city FROM customer WHERE city = 'Bergen'", nil, []string{"Bob/Bergen"}},
+		{"SELECT LENGTH(name) FROM customer WHERE name = 'Carol'", nil, []string{"5"}},
+		{"SELECT `name` FROM customer WHERE name = 'Dave''s'", nil, nil},
+	}
+
+	for _, tt := range tests {
+		if got := queryStrings(t, db, tt.query, tt.args...); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s = %v, want %v", tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestSQLSelectStar(t *testing.T) {
+	db, _ := openSQLDir(t)
+
+	rows, err := db.Query("SELECT * FROM customer WHERE name = 'Alice'")
+	if err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+	defer rows.Close()
+
+	columns, _ := rows.Columns()
+	if want := []string{"NAME", "CITY", "AMOUNT", "VISITS", "ACTIVE"}; !reflect.DeepEqual(columns, want) {
+		t.Errorf("columns = %v, want %v", columns, want)
+	}
+	types, _ := rows.ColumnTypes()
+	if got := types[2].DatabaseTypeName(); got != "N" {
+		t.Errorf("AMOUNT type = %q, want %q", got, "N")
+	}
+
+	if !rows.Next() {
+		t.Fatal("expected a row")
+	}
+	var name, city string
+	var amount float64
+	var visits int
+	var active bool
+	if err := rows.Scan(&name, &city, &amount, &visits, &active); err != nil {
+		t.Fatalf("Scan failed: %v", err)
+	}
+	if name != "Alice" || city != "Oslo" || amount != 120.5 || visits != 3 || !active {
+		t.Errorf("got %q %q %v %v %v", name, city, amount, visits, active)
+	}
+}
+
+func TestSQLUpdateDelete(t *testing.T) {
+	db, dir := openSQLDir(t)
+
+	res, err := db.Exec("UPDATE customer SET amount = amount + 10, visits = visits + 1 WHERE RTRIM(city) == ?", "Oslo")
+	if err != nil {
+		t.Fatalf("UPDATE failed: %v", err)
+	}
+	if n, _ := res.RowsAffected(); n != 2 {
+		t.Errorf("UPDATE affected %d rows, want 2", n)
+	}
+	if got := queryStrings(t, db, "SELECT amount FROM customer WHERE visits = 8"); !reflect.DeepEqual(got, []string{"25.25"}) {
+		t.Errorf("updated amount = %v, want [25.25]", got)
+	}
+
+	// The update moved Alice's key in the VISITS tag
+	if got := queryStrings(t, db, "SELECT name FROM customer WHERE visits = 4"); !reflect.DeepEqual(got, []string{"Alice"}) {
+		t.Errorf("visits = 4 gives %v, want [Alice]", got)
+	}
+
+	res, err = db.Exec("DELETE FROM customer WHERE active = FALSE")
+	if err != nil {
+		t.Fatalf("DELETE failed: %v", err)
+	}
+	if n, _ := res.RowsAffected(); n != 2 {
+		t.Errorf("DELETE affected %d rows, want 2", n)
+	}
+	if got := queryStrings(t, db, "SELECT name FROM customer"); !reflect.DeepEqual(got, []string{"Alice", "Carol", "Dave"}) {
+		t.Errorf("remaining rows = %v", got)
+	}
+
+	// DELETE only marks the records
+	f := foxi.NewFoxi()
+	f.MustOpen(filepath.Join(dir, "Customer.dbf"))
+	defer f.Close()
+	header := f.Header()
+	if count := header.RecordCount(); count != 5 {
+		t.Errorf("record count = %d, want 5", count)
+	}
+	f.MustGoto(2)
+	if !f.Deleted() {
+		t.Error("record 2 is not marked deleted")
+	}
+}
+
+func TestSQLInsertResult(t *testing.T) {
+	db, _ := openSQLDir(t)
+
+	res, err := db.Exec("INSERT INTO CUSTOMER VALUES (?, ?, ?, ?, ?)", "Frank", "Oslo", 9.99, 1, true)
+	if err != nil {
+		t.Fatalf("INSERT failed: %v", err)
+	}
+	if id, _ := res.LastInsertId(); id != 6 {
+		t.Errorf("LastInsertId = %d, want 6", id)
+	}
+	if got := queryStrings(t, db, "SELECT name FROM customer WHERE RTRIM(city) == 'Oslo'"); !reflect.DeepEqual(got, []string{"Alice", "Carol", "Frank"}) {
+		t.Errorf("Oslo customers = %v", got)
+	}
+
+	if _, err := db.Exec("INSERT INTO customer (name, nosuch) VALUES ('x', 1)"); err == nil {
+		t.Error("expected error for unknown column")
+	}
+	if _, err := db.Query("SELECT name FROM nosuch"); err == nil {
+		t.Error("expected error for unknown table")
+	}
+	if _, err := db.Query("SELECT name FROM customer WHERE"); err == nil {
+		t.Error("expected syntax error")
+	}
+}
+
+func TestSQLTransaction(t *testing.T) {
+	db, _ := openSQLDir(t)
+
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatalf("Begin failed: %v", err)
+	}
+	if _, err := tx.Exec("UPDATE customer SET city = 'Bergen' WHERE name = 'Alice'"); err != nil {
+		t.Fatalf("UPDATE failed: %v", err)
+	}
+	if _, err := tx.Exec("DELETE FROM customer WHERE name = 'Bob'"); err != nil {
+		t.Fatalf("DELETE failed: %v", err)
+	}
+	if got := queryStrings(t, tx, "SELECT name FROM customer WHERE city = 'Bergen'"); !reflect.DeepEqual(got, []string{"Alice"}) {
+		t.Errorf("inside transaction Bergen = %v, want [Alice]", got)
+	}
+	if err := tx.Rollback(); err != nil {
+		t.Fatalf("Rollback failed: %v", err)
+	}
+	if got := queryStrings(t, db, "SELECT name FROM customer WHERE city = 'Bergen'"); !reflect.DeepEqual(got, []string{"Bob"}) {
+		t.Errorf("after rollback Bergen = %v, want [Bob]", got)
+	}
+
+	tx, err = db.Begin()
+	if err != nil {
+		t.Fatalf("Begin failed: %v", err)
+	}
+	if _, err := tx.Exec("INSERT INTO customer (name, city) VALUES ('Gina', 'Bergen')"); err != nil {
+		t.Fatalf("INSERT failed: %v", err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("Commit failed: %v", err)
+	}
+	if got := queryStrings(t, db, "SELECT name FROM customer WHERE city = 'Bergen'"); !reflect.DeepEqual(got, []string{"Bob", "Gina"}) {
+		t.Errorf("after commit Bergen = %v, want [Bob Gina]", got)
+	}
+}
+
+// createSQLTable creates an empty table in a directory opened with the
+// foxi SQL driver
+func createSQLTable(t *testing.T, dir, name string, fields ...foxi.FieldSpec) {
+	t.Helper()
+
+	f, err := foxi.Create(filepath.Join(dir, name+".dbf"), foxi.Schema{Fields: fields}, nil)
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	f.Close()
+}
+
+func TestSQLInsertRejectsBadValues(t *testing.T) {
+	db, dir := openSQLDir(t)
+	createSQLTable(t, dir, "PRICE",
+		foxi.FieldSpec{Name: "ITEM", Type: foxi.FTCharacter, Size: 10},
+		foxi.FieldSpec{Name: "PRICE", Type: foxi.FTNumeric, Size: 10, Decimals: 2},
+	)
+
+	if _, err := db.Exec("INSERT INTO price VALUES ('fits', 1234567.12)"); err != nil {
+		t.Fatalf("INSERT failed: %v", err)
+	}
+
+	bad := []struct {
+		name  string
+		query string
+		args  []any
+	}{
+		{"overflow", "INSERT INTO price VALUES ('big', 123456789.123)", nil},
+		{"overflow in a later row", "INSERT INTO price VALUES ('a', 1), ('b', 2), ('big', 123456789.123)", nil},
+		{"NaN", "INSERT INTO price VALUES ('a', 1), ('nan', ?)", []any{math.NaN()}},
+		{"infinity", "INSERT INTO price VALUES (?, ?)", []any{"inf", math.Inf(1)}},
+		{"text", "INSERT INTO price VALUES ('a', 1), ('text', 'abc')", nil},
+		{"logical", "INSERT INTO price VALUES ('a', 1), ('bool', TRUE)", nil},
+	}
+	for _, tc := range bad {
+		if _, err := db.Exec(tc.query, tc.args...); err == nil {
+			t.Errorf("%s: INSERT succeeded", tc.name)
+		}
+	}
+	if got := queryStrings(t, db, "SELECT item FROM price"); !reflect.DeepEqual(got, []string{"fits"}) {
+		t.Errorf("after the failed inserts PRICE holds %v, want [fits]", got)
+	}
+
+	if _, err := db.Exec("UPDATE customer SET amount = amount * 1000"); err == nil {
+		t.Error("UPDATE overflowing one record succeeded")
+	}
+	if got := queryStrings(t, db, "SELECT amount FROM customer WHERE name = 'Alice'"); !reflect.DeepEqual(got, []string{"120.5"}) {
+		t.Errorf("after the failed UPDATE Alice's amount = %v, want [120.5]", got)
+	}
+}
+
+func TestSQLTransactionSpansTables(t *testing.T) {
+	db, dir := openSQLDir(t)
+	createSQLTable(t, dir, "ORDERS",
+		foxi.FieldSpec{Name: "CUSTOMER", Type: foxi.FTCharacter, Size: 20},
+		foxi.FieldSpec{Name: "TOTAL", Type: foxi.FTNumeric, Size: 8, Decimals: 2},
+	)
+
+	run := func(finish func(*sql.Tx) error) {
+		t.Helper()
+		tx, err := db.Begin()
+		if err != nil {
+			t.Fatalf("Begin failed: %v", err)
+		}
+		if _, err := tx.Exec("INSERT INTO orders VALUES ('Alice', 10)"); err != nil {
+			t.Fatalf("INSERT failed: %v", err)
+		}
+		if _, err := tx.Exec("UPDATE customer SET visits = visits + 1 WHERE name = 'Alice'"); err != nil {
+			t.Fatalf("UPDATE failed: %v", err)
+		}
+		if err := finish(tx); err != nil {
+			t.Fatalf("finishing the transaction failed: %v", err)
+		}
+	}
+
+	run((*sql.Tx).Rollback)
+	if got := queryStrings(t, db, "SELECT customer FROM orders"); len(got) != 0 {
+		t.Errorf("after rollback ORDERS holds %v", got)
+	}
+	if got := queryStrings(t, db, "SELECT visits FROM customer WHERE name = 'Alice'"); !reflect.DeepEqual(got, []string{"3"}) {
+		t.Errorf("after rollback Alice's visits = %v, want [3]", got)
+	}
+
+	run((*sql.Tx).Commit)
+	if got := queryStrings(t, db, "SELECT customer FROM orders"); !reflect.DeepEqual(got, []string{"Alice"}) {
+		t.Errorf("after commit ORDERS holds %v, want [Alice]", got)
+	}
+	if got := queryStrings(t, db, "SELECT visits FROM customer WHERE name = 'Alice'"); !reflect.DeepEqual(got, []string{"4"}) {
+		t.Errorf("after commit Alice's visits = %v, want [4]", got)
+	}
+}
+
+func TestSQLMatchesSelect(t *testing.T) {
+	db, dir := openSQLDir(t)
+	direct := foxisql.NewDB(dir)
+	defer direct.Close()
+
+	// The driver and package sql share one parser and evaluate with
+	// package expr, so a condition selects the same records in both
+	for _, where := range []string{
+		"city = 'Oslo'",
+		"city = 'Os'",
+		"city == 'Oslo'",
+		"RTRIM(city) == 'Oslo'",
+		"city <> 'Oslo'",
+		"name >= 'C' AND NOT active",
+		"city LIKE '%o' OR visits IN (1, 7)",
+		"UPPER(name) = 'AL'",
+	} {
+		query := "SELECT name FROM customer WHERE " + where + " ORDER BY name"
+		got := queryStrings(t, db, query)
+
+		rows, err := direct.Query(query)
+		if err != nil {
+			t.Fatalf("%s: %v", query, err)
+		}
+		var want []string
+		for rows.Next() {
+			want = append(want, strings.TrimRight(rows.Values()[0].(string), " "))
+		}
+		rows.Close()
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: driver gives %v, package sql %v", where, got, want)
+		}
+	}
+
+	// UPDATE and DELETE match records the same way
+	res, err := db.Exec("DELETE FROM customer WHERE city = 'Oslo'")
+	if err != nil {
+		t.Fatalf("DELETE failed: %v", err)
+	}
+	if n, _ := res.RowsAffected(); n != 3 {
+		t.Errorf("DELETE WHERE city = 'Oslo' affected %d rows, want 3", n)
+	}
+}
+
+func TestSQLJoinsAndSelectInto(t *testing.T) {
+	db, dir := openSQLDir(t)
+	createSQLTable(t, dir, "ORDERS",
+		foxi.FieldSpec{Name: "CUSTOMER", Type: foxi.FTCharacter, Size: 20},
+		foxi.FieldSpec{Name: "TOTAL", Type: foxi.FTNumeric, Size: 8, Decimals: 2},
+	)
+	if _, err := db.Exec("INSERT INTO orders VALUES ('Alice', 10), ('Alice', 5.5), ('Carol', 7)"); err != nil {
+		t.Fatalf("INSERT failed: %v", err)
+	}
+
+	rows, err := db.Query(`SELECT c.name, SUM(o.total) AS spent FROM customer c
+		JOIN orders o ON o.customer == c.name GROUP BY c.name ORDER BY spent DESC`)
+	if err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+	var got []string
+	for rows.Next() {
+		var name string
+		var spent float64
+		if err := rows.Scan(&name, &spent); err != nil {
+			t.Fatalf("Scan failed: %v", err)
+		}
+		got = append(got, fmt.Sprintf("%s %v", name, spent))
+	}
+	rows.Close()
+	if want := []string{"Alice 15.5", "Carol 7"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("join = %v, want %v", got, want)
+	}
+
+	res, err := db.Exec("SELECT name, city FROM customer WHERE visits > ? INTO TABLE regulars", 2)
+	if err != nil {
+		t.Fatalf("SELECT INTO failed: %v", err)
+	}
+	if n, _ := res.RowsAffected(); n != 3 {
+		t.Errorf("SELECT INTO wrote %d rows, want 3", n)
+	}
+	if got := queryStrings(t, db, "SELECT name FROM regulars"); !reflect.DeepEqual(got, []string{"Alice", "Carol", "Eve"}) {
+		t.Errorf("REGULARS holds %v", got)
+	}
+}
@@ -0,0 +1,73 @@
+package tests
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/mkfoss/foxi"
+)
+
+// row holds the values of a record appended by createTable, by field name
+type row map[string]any
+
+// createTable creates the table name in a new temporary directory,
+// appends one record per row and returns the open table with its path
+func createTable(t *testing.T, name string, schema foxi.Schema, opts *foxi.CreateOptions, rows ...row) (*foxi.Foxi, string) {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), name+".dbf")
+	f, err := foxi.Create(path, schema, opts)
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	for _, r := range rows {
+		f.MustAppend()
+		for field, value := range r {
+			setField(t, f.FieldByName(field), value)
+		}
+		f.MustWrite()
+	}
+	return f, path
+}
+
+// setField assigns a value with the setter of its Go type
+func setField(t *testing.T, field foxi.Field, value any) {
+	t.Helper()
+
+	switch v := value.(type) {
+	case string:
+		field.MustSetString(v)
+	case int:
+		field.MustSetInt(v)
+	case float64:
+		field.MustSetFloat(v)
+	case bool:
+		field.MustSetBool(v)
+	case time.Time:
+		field.MustSetTime(v)
+	default:
+		t.Fatalf("cannot set %s to %T", field.Name(), value)
+	}
+}
+
+// createNamesTable creates a table with a memo field and a NAME tag
+// holding one record per name, its memo made from the name by note
+func createNamesTable(t *testing.T, note func(name string) string, names ...string) (*foxi.Foxi, string) {
+	t.Helper()
+
+	schema := foxi.Schema{
+		Fields: []foxi.FieldSpec{
+			{Name: "NAME", Type: foxi.FTCharacter, Size: 20},
+			{Name: "NOTES", Type: foxi.FTMemo},
+		},
+		Tags: []foxi.TagSpec{
+			{Name: "name", Expression: "UPPER(NAME)"},
+		},
+	}
+	rows := make([]row, 0, len(names))
+	for _, name := range names {
+		rows = append(rows, row{"NAME": name, "NOTES": note(name)})
+	}
+	return createTable(t, "names", schema, nil, rows...)
+}
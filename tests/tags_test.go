@@ -0,0 +1,339 @@
+package tests
+
+import (
+	"math/rand"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/mkfoss/foxi"
+)
+
+// createTagTable creates a table without an index holding one record per
+// name, with AMOUNT set to the position of the name
+func createTagTable(t *testing.T, names ...string) (*foxi.Foxi, string) {
+	t.Helper()
+	rows := make([]row, 0, len(names))
+	for i, name := range names {
+		rows = append(rows, row{"NAME": name, "AMOUNT": i + 1})
+	}
+	return createTable(t, "tags", foxi.Schema{
+		Fields: []foxi.FieldSpec{
+			{Name: "NAME", Type: foxi.FTCharacter, Size: 20},
+			{Name: "AMOUNT", Type: foxi.FTNumeric, Size: 5},
+		},
+	}, nil, rows...)
+}
+
+// tagOrder returns the names of the records in the order of a tag
+func tagOrder(t *testing.T, f *foxi.Foxi, tagName string) []string {
+	t.Helper()
+
+	tag := f.Indexes().TagByName(tagName)
+	if tag == nil {
+		t.Fatalf("tag %s missing", tagName)
+	}
+	f.Indexes().MustSelectTag(tag)
+	defer f.Indexes().SelectTag(nil)
+
+	var names []string
+	for f.MustFirst(); !f.EOF(); f.MustNext() {
+		names = append(names, strings.TrimSpace(f.FieldByName("name").MustAsString()))
+	}
+	return names
+}
+
+func TestCreateTag(t *testing.T) {
+	f, path := createTagTable(t, "delta", "alpha", "charlie", "bravo", "alpha")
+	defer f.Close()
+	cdx := strings.TrimSuffix(path, ".dbf") + ".cdx"
+
+	indexes := f.Indexes()
+	indexes.MustCreateTag("name", "UPPER(NAME)", "", false, false)
+	if _, err := os.Stat(cdx); err != nil {
+		t.Fatalf("production index not created: %v", err)
+	}
+	indexes.MustCreateTag("big", "AMOUNT", "AMOUNT > 2", false, true)
+	indexes.MustCreateTag("uname", "NAME", "", true, false)
+
+	if got := indexes.Count(); got != 1 {
+		t.Fatalf("expected 1 index, got %d", got)
+	}
+	if got := indexes.ByIndex(0).TagCount(); got != 3 {
+		t.Fatalf("expected 3 tags, got %d", got)
+	}
+
+	if got, want := tagOrder(t, f, "name"), []string{"alpha", "alpha", "bravo", "charlie", "delta"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("NAME order = %v, want %v", got, want)
+	}
+	if got, want := tagOrder(t, f, "big"), []string{"alpha", "bravo", "charlie"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("BIG order = %v, want %v", got, want)
+	}
+	if got, want := tagOrder(t, f, "uname"), []string{"alpha", "bravo", "charlie", "delta"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("UNAME order = %v, want %v", got, want)
+	}
+
+	tag := indexes.TagByName("big")
+	if !tag.IsDescending() || tag.Filter() != "AMOUNT > 2" {
+		t.Errorf("BIG descending=%v filter=%q", tag.IsDescending(), tag.Filter())
+	}
+	if !indexes.TagByName("uname").IsUnique() {
+		t.Error("UNAME is not unique")
+	}
+
+	if err := indexes.CreateTag("name", "NAME", "", false, false); err == nil {
+		t.Error("expected error for a duplicate tag name")
+	}
+	if err := indexes.CreateTag("bad", "NOSUCHFIELD", "", false, false); err == nil {
+		t.Error("expected error for an invalid expression")
+	}
+	if err := indexes.CreateTag("bad", "NAME", "AMOUNT", false, false); err == nil || !strings.Contains(err.Error(), "is not logical") {
+		t.Errorf("CreateTag with a FOR expression that is not logical = %v", err)
+	}
+	if err := indexes.CreateTag("bad", "REPLICATE(NAME, 30)", "", false, false); err == nil || !strings.Contains(err.Error(), "longer than the limit of 240") {
+		t.Errorf("CreateTag with a key too long = %v", err)
+	}
+
+	// The production index is opened together with the table
+	f.Close()
+	f = foxi.NewFoxi()
+	f.MustOpen(path)
+	if got := len(f.Indexes().Tags()); got != 3 {
+		t.Fatalf("expected 3 tags after reopen, got %d", got)
+	}
+	if got := f.Indexes().TagByName("name").MustSeekString("CHARLIE"); got != foxi.SeekSuccess {
+		t.Errorf("seek CHARLIE = %v, want %v", got, foxi.SeekSuccess)
+	}
+}
+
+func TestDropTag(t *testing.T) {
+	f, path := createTagTable(t, "bravo", "alpha")
+	defer f.Close()
+	cdx := strings.TrimSuffix(path, ".dbf") + ".cdx"
+
+	indexes := f.Indexes()
+	indexes.MustCreateTag("name", "NAME", "", false, false)
+	indexes.MustCreateTag("amount", "AMOUNT", "", false, false)
+	indexes.MustSelectTag(indexes.TagByName("amount"))
+
+	indexes.MustDropTag("amount")
+	if indexes.TagByName("amount") != nil {
+		t.Error("tag AMOUNT still listed")
+	}
+	if indexes.SelectedTag() != nil {
+		t.Error("dropped tag is still selected")
+	}
+	if got, want := tagOrder(t, f, "name"), []string{"alpha", "bravo"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("NAME order = %v, want %v", got, want)
+	}
+	if err := indexes.DropTag("amount"); err == nil {
+		t.Error("expected error for a missing tag")
+	}
+
+	// Dropping the last tag removes the index file
+	indexes.MustDropTag("name")
+	if _, err := os.Stat(cdx); !os.IsNotExist(err) {
+		t.Errorf("index file not removed: %v", err)
+	}
+	f.Close()
+
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read table: %v", err)
+	}
+	if raw[28]&0x01 != 0 {
+		t.Error("production index flag still set in the table header")
+	}
+
+	f = foxi.NewFoxi()
+	f.MustOpen(path)
+	if got := len(f.Indexes().List()); got != 0 {
+		t.Errorf("expected no index after reopen, got %d", got)
+	}
+	header := f.Header()
+	if count := header.RecordCount(); count != 2 {
+		t.Errorf("expected 2 records, got %d", count)
+	}
+}
+
+func TestReindex(t *testing.T) {
+	f, _ := createTagTable(t, "bravo", "alpha")
+	defer f.Close()
+
+	indexes := f.Indexes()
+	indexes.MustCreateTag("name", "NAME", "", false, false)
+	indexes.MustReindex()
+	if got, want := tagOrder(t, f, "name"), []string{"alpha", "bravo"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("NAME order after Indexes.Reindex = %v, want %v", got, want)
+	}
+
+	f.MustAppend()
+	f.FieldByName("name").MustSetString("aardvark")
+	f.MustWrite()
+
+	if err := indexes.ByIndex(0).Reindex(); err != nil {
+		t.Fatalf("Index.Reindex failed: %v", err)
+	}
+	if got, want := tagOrder(t, f, "name"), []string{"aardvark", "alpha", "bravo"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("NAME order after Index.Reindex = %v, want %v", got, want)
+	}
+}
+
+func TestTagChangesInsideTransaction(t *testing.T) {
+	f, _ := createTagTable(t, "alpha")
+	defer f.Close()
+	f.Indexes().MustCreateTag("name", "NAME", "", false, false)
+
+	tx := f.MustBegin()
+	defer tx.Rollback()
+
+	if err := f.Indexes().CreateTag("amount", "AMOUNT", "", false, false); err == nil {
+		t.Error("expected error for CreateTag inside a transaction")
+	}
+	if err := f.Indexes().DropTag("name"); err == nil {
+		t.Error("expected error for DropTag inside a transaction")
+	}
+}
+
+// tagRecords returns the record numbers in the order of a tag
+func tagRecords(t *testing.T, f *foxi.Foxi, tagName string) []int {
+	t.Helper()
+
+	tag := f.Indexes().TagByName(tagName)
+	if tag == nil {
+		t.Fatalf("tag %s missing", tagName)
+	}
+	f.Indexes().MustSelectTag(tag)
+	defer f.Indexes().SelectTag(nil)
+
+	var recNos []int
+	for f.MustFirst(); !f.EOF(); f.MustNext() {
+		recNos = append(recNos, f.Position())
+	}
+	return recNos
+}
+
+func TestTagsFollowWrites(t *testing.T) {
+	f, path := createTagTable(t)
+	defer f.Close()
+
+	indexes := f.Indexes()
+	indexes.MustCreateTag("name", "UPPER(NAME)", "", false, false)
+	indexes.MustCreateTag("active", "NAME", "!DELETED()", false, false)
+	indexes.MustCreateTag("amount", "AMOUNT", "", false, true)
+
+	type record struct {
+		name    string
+		amount  int
+		deleted bool
+	}
+	rng := rand.New(rand.NewSource(1))
+	randomName := func() string {
+		name := make([]byte, 8+rng.Intn(12))
+		for i := range name {
+			name[i] = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ"[rng.Intn(52)]
+		}
+		return string(name)
+	}
+
+	// Enough records for the tags to grow several levels deep
+	records := []record{{}}
+	for recNo := 1; recNo <= 3000; recNo++ {
+		rec := record{name: randomName(), amount: recNo}
+		f.MustAppend()
+		f.FieldByName("name").MustSetString(rec.name)
+		f.FieldByName("amount").MustSetInt(rec.amount)
+		f.MustWrite()
+		records = append(records, rec)
+	}
+
+	// Edit indexed fields, delete and recall
+	for recNo := 1; recNo < len(records); recNo++ {
+		rec := &records[recNo]
+		f.MustGoto(recNo)
+		if recNo%7 == 0 {
+			rec.name = randomName()
+			rec.amount = 90000 - recNo
+			f.FieldByName("name").MustSetString(rec.name)
+			f.FieldByName("amount").MustSetInt(rec.amount)
+		}
+		if recNo%5 == 0 {
+			rec.deleted = true
+			f.MustDelete()
+		}
+		if recNo%15 == 0 {
+			rec.deleted = false
+			f.MustRecall()
+		}
+		f.MustWrite()
+	}
+
+	expected := func(include func(record) bool, less func(a, b record) bool) []int {
+		var recNos []int
+		for recNo := 1; recNo < len(records); recNo++ {
+			if include(records[recNo]) {
+				recNos = append(recNos, recNo)
+			}
+		}
+		sort.SliceStable(recNos, func(i, j int) bool {
+			return less(records[recNos[i]], records[recNos[j]])
+		})
+		return recNos
+	}
+	all := func(record) bool { return true }
+	want := map[string][]int{
+		"name": expected(all, func(a, b record) bool {
+			return strings.ToUpper(a.name) < strings.ToUpper(b.name)
+		}),
+		"active": expected(func(r record) bool { return !r.deleted }, func(a, b record) bool {
+			return a.name < b.name
+		}),
+		"amount": expected(all, func(a, b record) bool { return a.amount > b.amount }),
+	}
+
+	check := func(when string) {
+		t.Helper()
+		for tagName, recNos := range want {
+			if got := tagRecords(t, f, tagName); !reflect.DeepEqual(got, recNos) {
+				t.Errorf("%s: tag %s holds %d entries out of order, want %d", when, tagName, len(got), len(recNos))
+			}
+		}
+	}
+	check("after writes")
+
+	tag := indexes.TagByName("name")
+	if got := tag.MustSeekString(strings.ToUpper(records[1234].name)); got != foxi.SeekSuccess {
+		t.Errorf("seek record 1234 = %v, want %v", got, foxi.SeekSuccess)
+	}
+
+	indexes.MustReindex()
+	check("after reindex")
+
+	f.Close()
+	f = foxi.NewFoxi()
+	f.MustOpen(path)
+	check("after reopen")
+}
+
+func TestUniqueTagFollowsWrites(t *testing.T) {
+	f, _ := createTagTable(t, "alpha", "bravo")
+	defer f.Close()
+	f.Indexes().MustCreateTag("uname", "NAME", "", true, false)
+
+	// A duplicate key is left out and the first record keeps it
+	f.MustAppend()
+	f.FieldByName("name").MustSetString("alpha")
+	f.MustWrite()
+	if got, want := tagRecords(t, f, "uname"), []int{1, 2}; !reflect.DeepEqual(got, want) {
+		t.Errorf("UNAME records = %v, want %v", got, want)
+	}
+
+	f.MustGoto(3)
+	f.FieldByName("name").MustSetString("charlie")
+	f.MustWrite()
+	if got, want := tagRecords(t, f, "uname"), []int{1, 2, 3}; !reflect.DeepEqual(got, want) {
+		t.Errorf("UNAME records = %v, want %v", got, want)
+	}
+}
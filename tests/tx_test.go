@@ -0,0 +1,349 @@
+package tests
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/mkfoss/foxi"
+	pkg "github.com/mkfoss/foxi/pkg/gocore"
+)
+
+// txNote is the memo of each record of the tables changed in transactions
+func txNote(name string) string {
+	return "note for " + name
+}
+
+func TestTxRollback(t *testing.T) {
+	f, path := createNamesTable(t, txNote, "alpha", "beta")
+
+	tx := f.MustBegin()
+	f.MustGoto(1)
+	f.FieldByName("name").MustSetString("changed")
+	f.FieldByName("notes").MustSetString("a rewritten memo that is long enough to need a different block in the memo file")
+	f.MustWrite()
+	f.MustGoto(2)
+	f.MustDelete()
+	f.MustAppend()
+	f.FieldByName("name").MustSetString("gamma")
+	f.MustWrite()
+	tx.MustRollback()
+	f.Close()
+
+	f = foxi.NewFoxi()
+	f.MustOpen(path)
+	defer f.Close()
+
+	header := f.Header()
+	if count := header.RecordCount(); count != 2 {
+		t.Fatalf("expected 2 records after rollback, got %d", count)
+	}
+	f.MustGoto(1)
+	if got := strings.TrimSpace(f.FieldByName("name").MustAsString()); got != "alpha" {
+		t.Errorf("NAME = %q, want %q", got, "alpha")
+	}
+	if got := f.FieldByName("notes").MustAsString(); got != "note for alpha" {
+		t.Errorf("NOTES = %q, want %q", got, "note for alpha")
+	}
+	f.MustGoto(2)
+	if f.Deleted() {
+		t.Error("record 2 should not be deleted after rollback")
+	}
+
+	tag := f.Indexes().TagByName("name")
+	if tag == nil {
+		t.Fatal("tag NAME missing")
+	}
+	if got := tag.MustSeekString("ALPHA"); got != foxi.SeekSuccess {
+		t.Errorf("seek ALPHA = %v, want %v", got, foxi.SeekSuccess)
+	}
+	for _, name := range []string{"CHANGED", "GAMMA"} {
+		if got := tag.MustSeekString(name); got == foxi.SeekSuccess {
+			t.Errorf("seek %q found a key that was rolled back", name)
+		}
+	}
+}
+
+func TestTxCommit(t *testing.T) {
+	f, path := createNamesTable(t, txNote, "alpha")
+
+	tx := f.MustBegin()
+	f.MustAppend()
+	f.FieldByName("name").MustSetString("beta")
+	f.MustGoto(1)
+	f.MustDelete()
+	tx.MustCommit()
+	f.Close()
+
+	f = foxi.NewFoxi()
+	f.MustOpen(path)
+	defer f.Close()
+
+	header := f.Header()
+	if count := header.RecordCount(); count != 2 {
+		t.Fatalf("expected 2 records after commit, got %d", count)
+	}
+	f.MustGoto(1)
+	if !f.Deleted() {
+		t.Error("record 1 should be deleted after commit")
+	}
+	f.MustGoto(2)
+	if got := strings.TrimSpace(f.FieldByName("name").MustAsString()); got != "beta" {
+		t.Errorf("NAME = %q, want %q", got, "beta")
+	}
+}
+
+func TestTxErrors(t *testing.T) {
+	f, _ := createNamesTable(t, txNote, "alpha")
+	defer f.Close()
+
+	tx, err := f.Begin()
+	if err != nil {
+		t.Fatalf("Begin failed: %v", err)
+	}
+	if _, err := f.Begin(); err == nil {
+		t.Error("expected error for nested Begin")
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("Commit failed: %v", err)
+	}
+	if err := tx.Commit(); err == nil {
+		t.Error("expected error for Commit after Commit")
+	}
+	if err := tx.Rollback(); err == nil {
+		t.Error("expected error for Rollback after Commit")
+	}
+
+	closed := foxi.NewFoxi()
+	if _, err := closed.Begin(); err == nil {
+		t.Error("expected error for Begin on closed table")
+	}
+}
+
+func TestTxRollbackOnClose(t *testing.T) {
+	f, path := createNamesTable(t, txNote, "alpha")
+
+	f.MustBegin()
+	f.MustAppend()
+	f.FieldByName("name").MustSetString("beta")
+	f.MustWrite()
+	f.Close()
+
+	f = foxi.NewFoxi()
+	f.MustOpen(path)
+	defer f.Close()
+
+	header := f.Header()
+	if count := header.RecordCount(); count != 1 {
+		t.Errorf("expected 1 record after closing with open transaction, got %d", count)
+	}
+}
+
+// copyTableFiles copies every file of a table to dir, giving the state a
+// crash at this point would leave on disk
+func copyTableFiles(t *testing.T, path string, dir string) string {
+	t.Helper()
+
+	base := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	matches, err := filepath.Glob(filepath.Join(filepath.Dir(path), base+".*"))
+	if err != nil {
+		t.Fatalf("failed to list table files: %v", err)
+	}
+	for _, match := range matches {
+		raw, err := os.ReadFile(match)
+		if err != nil {
+			t.Fatalf("failed to read %s: %v", match, err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, filepath.Base(match)), raw, 0o644); err != nil {
+			t.Fatalf("failed to copy %s: %v", match, err)
+		}
+	}
+	return filepath.Join(dir, filepath.Base(path))
+}
+
+func TestTxCrashRecovery(t *testing.T) {
+	f, path := createNamesTable(t, txNote, "alpha", "beta")
+	defer f.Close()
+
+	tx := f.MustBegin()
+	f.MustGoto(1)
+	f.FieldByName("name").MustSetString("changed")
+	f.MustWrite()
+	f.MustGoto(2)
+	f.MustDelete()
+	f.MustWrite()
+	f.MustAppend()
+	f.FieldByName("name").MustSetString("gamma")
+	f.MustWrite()
+
+	journal := strings.TrimSuffix(path, filepath.Ext(path)) + ".jnl"
+	if _, err := os.Stat(journal); err != nil {
+		t.Fatalf("journal not written during transaction: %v", err)
+	}
+	crashed := copyTableFiles(t, path, t.TempDir())
+	tx.MustCommit()
+
+	if _, err := os.Stat(journal); !os.IsNotExist(err) {
+		t.Errorf("journal left behind after commit: %v", err)
+	}
+
+	g := foxi.NewFoxi()
+	g.MustOpen(crashed)
+	defer g.Close()
+
+	header := g.Header()
+	if count := header.RecordCount(); count != 2 {
+		t.Fatalf("expected 2 records after recovery, got %d", count)
+	}
+	g.MustGoto(1)
+	if got := strings.TrimSpace(g.FieldByName("name").MustAsString()); got != "alpha" {
+		t.Errorf("NAME = %q, want %q", got, "alpha")
+	}
+	g.MustGoto(2)
+	if g.Deleted() {
+		t.Error("record 2 should not be deleted after recovery")
+	}
+	if _, err := os.Stat(strings.TrimSuffix(crashed, filepath.Ext(crashed)) + ".jnl"); !os.IsNotExist(err) {
+		t.Errorf("journal left behind after recovery: %v", err)
+	}
+
+	tag := g.Indexes().TagByName("name")
+	if tag == nil {
+		t.Fatal("tag NAME missing")
+	}
+	if got := tag.MustSeekString("CHANGED"); got == foxi.SeekSuccess {
+		t.Error("index still holds a key that was rolled back")
+	}
+}
+
+func TestTxCommittedSurvivesReopen(t *testing.T) {
+	f, path := createNamesTable(t, txNote, "alpha")
+
+	tx := f.MustBegin()
+	f.MustAppend()
+	f.FieldByName("name").MustSetString("beta")
+	f.MustWrite()
+	tx.MustCommit()
+
+	// Reopen from a copy, as after a kill right after the commit
+	copied := copyTableFiles(t, path, t.TempDir())
+	f.Close()
+
+	g := foxi.NewFoxi()
+	g.MustOpen(copied)
+	defer g.Close()
+
+	header := g.Header()
+	if count := header.RecordCount(); count != 2 {
+		t.Errorf("expected 2 records after commit, got %d", count)
+	}
+}
+
+func TestTxRollbackRestoresTags(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tags.dbf")
+	f, err := foxi.Create(path, foxi.Schema{
+		Fields: []foxi.FieldSpec{
+			{Name: "NAME", Type: foxi.FTCharacter, Size: 10},
+			{Name: "QTY", Type: foxi.FTInteger},
+		},
+		Tags: []foxi.TagSpec{
+			{Name: "name", Expression: "NAME", Unique: true},
+			{Name: "qty", Expression: "QTY", Descending: true},
+			{Name: "big", Expression: "NAME", Filter: "QTY > 10"},
+		},
+	}, nil)
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	defer f.Close()
+	for i, name := range []string{"pear", "apple", "fig", "apple", "kiwi"} {
+		f.MustAppend()
+		f.FieldByName("name").MustSetString(name)
+		f.FieldByName("qty").MustSetInt(i * 5)
+		f.MustWrite()
+	}
+
+	tags := []string{"name", "qty", "big"}
+	before := map[string][]int{}
+	for _, tag := range tags {
+		before[tag] = tagRecords(t, f, tag)
+	}
+
+	tx := f.MustBegin()
+	// Take the unique key of record 2 away, so record 4 would hold it
+	f.MustGoto(2)
+	f.FieldByName("name").MustSetString("zucchini")
+	f.FieldByName("qty").MustSetInt(99)
+	f.MustWrite()
+	f.MustGoto(4)
+	f.FieldByName("name").MustSetString("fig")
+	f.MustWrite()
+	f.FieldByName("name").MustSetString("apple")
+	f.FieldByName("qty").MustSetInt(1)
+	f.MustWrite()
+	f.MustGoto(5)
+	f.MustDelete()
+	f.MustAppend()
+	f.FieldByName("name").MustSetString("banana")
+	f.FieldByName("qty").MustSetInt(50)
+	f.MustWrite()
+	tx.MustRollback()
+
+	for _, tag := range tags {
+		if got := tagRecords(t, f, tag); !slices.Equal(got, before[tag]) {
+			t.Errorf("tag %s after rollback = %v, want %v", tag, got, before[tag])
+		}
+	}
+}
+
+func TestTxCommitFailureKeepsTransaction(t *testing.T) {
+	f, path := createNamesTable(t, txNote, "alpha")
+	f.Close()
+
+	codeBase := &pkg.Code4{}
+	pkg.Code4Init(codeBase)
+	defer pkg.Code4InitUndo(codeBase)
+	data := pkg.D4Open(codeBase, path)
+	if data == nil {
+		t.Fatalf("D4Open failed: %d", codeBase.ErrorCode)
+	}
+
+	pkg.Code4TransInit(codeBase)
+	pkg.D4Go(data, 1)
+	pkg.F4Assign(pkg.D4Field(data, "NAME"), "changed")
+	if rc := pkg.D4Write(data); rc != pkg.ErrorNone {
+		t.Fatalf("D4Write failed: %d", rc)
+	}
+	pkg.F4Assign(pkg.D4Field(data, "NAME"), "pending")
+
+	// The pending change cannot be written through a read-only handle
+	handle := data.DataFile.File.Handle
+	readOnly, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer readOnly.Close()
+	data.DataFile.File.Handle = readOnly
+	if rc := pkg.Code4TransCommit(codeBase); rc == pkg.ErrorNone {
+		t.Fatal("commit succeeded without writing the pending change")
+	}
+	data.DataFile.File.Handle = handle
+
+	if !pkg.Code4TransActive(codeBase) {
+		t.Fatal("failed commit ended the transaction")
+	}
+	journal := strings.TrimSuffix(path, filepath.Ext(path)) + ".jnl"
+	if raw, err := os.ReadFile(journal); err != nil || len(raw) < 5 || raw[4] != 0 {
+		t.Fatalf("journal not left uncommitted: %v", err)
+	}
+
+	if rc := pkg.Code4TransRollback(codeBase); rc != pkg.ErrorNone {
+		t.Fatalf("rollback after a failed commit = %d", rc)
+	}
+	pkg.D4Go(data, 1)
+	if got := strings.TrimSpace(pkg.F4Str(pkg.D4Field(data, "NAME"))); got != "alpha" {
+		t.Errorf("NAME = %q after rollback, want %q", got, "alpha")
+	}
+}
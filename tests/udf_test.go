@@ -0,0 +1,241 @@
+package tests
+
+import (
+	"errors"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mkfoss/foxi"
+	"github.com/mkfoss/foxi/expr"
+)
+
+// initials returns the first letter of each word, upper case
+func initials(args []any) (any, error) {
+	var b strings.Builder
+	for _, word := range strings.Fields(args[0].(string)) {
+		b.WriteString(strings.ToUpper(word[:1]))
+	}
+	return b.String(), nil
+}
+
+func TestRegisterFunc(t *testing.T) {
+	calls := 0
+	must := func(err error) {
+		t.Helper()
+		if err != nil {
+			t.Fatalf("RegisterFunc failed: %v", err)
+		}
+	}
+	must(expr.RegisterFunc("udf_initials", initials, []expr.Type{expr.Character}, expr.Character))
+	must(expr.RegisterFunc("UDF_SCALE", func(args []any) (any, error) {
+		calls++
+		return args[0].(float64) * args[1].(float64), nil
+	}, []expr.Type{expr.Numeric, expr.Integer}, expr.Numeric))
+	must(expr.RegisterFunc("UDF_WEEKEND", func(args []any) (any, error) {
+		day := args[0].(time.Time).Weekday()
+		return day == time.Saturday || day == time.Sunday, nil
+	}, []expr.Type{expr.Date}, expr.Logical))
+	must(expr.RegisterFunc("UDF_COUNT", func(args []any) (any, error) {
+		return len(args[0].(string)), nil // any Go number will do
+	}, []expr.Type{expr.Character}, expr.Integer))
+	must(expr.RegisterFunc("UDF_TODAY", func([]any) (any, error) {
+		return time.Date(2024, 5, 17, 15, 30, 0, 0, time.UTC), nil
+	}, nil, expr.Date))
+	must(expr.RegisterFunc("UDF_FAIL", func([]any) (any, error) {
+		return nil, errors.New("no such customer")
+	}, nil, expr.Character))
+	must(expr.RegisterFunc("UDF_WRONG", func([]any) (any, error) {
+		return 42, nil
+	}, nil, expr.Character))
+
+	env := testEnv()
+	env.columns["BONUS"] = &expr.Column{Name: "BONUS", Type: expr.Numeric, Len: 10, Get: func() (any, error) { return nil, nil }}
+
+	tests := []struct {
+		source string
+		want   any
+	}{
+		{`UDF_INITIALS("mary ann smith")`, "MAS"},
+		{`udf_initials(FIRST + NAME) + "."`, "AS."},
+		{`UDF_SCALE(PAY, 2)`, 2469.0},
+		{`UDF_SCALE(AGE, 0.5) = 21`, true},
+		{`UDF_WEEKEND(HIRED)`, false},
+		{`UDF_WEEKEND(HIRED + 1)`, true},
+		{`UDF_COUNT(NAME) + 1`, 11.0},
+		{`UDF_TODAY()`, time.Date(2024, 5, 17, 0, 0, 0, 0, time.UTC)},
+		{`IIF(OK, UDF_INITIALS(NAME), "")`, "S"},
+		{`UDF_SCAL(PAY, 1)`, 1234.5}, // names abbreviate like built-in ones
+	}
+	for _, tt := range tests {
+		prog, err := expr.Compile(tt.source, env)
+		if err != nil {
+			t.Errorf("Compile(%s) failed: %v", tt.source, err)
+			continue
+		}
+		if got, err := prog.Eval(); err != nil || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Eval(%s) = %#v, %v, want %#v", tt.source, got, err, tt.want)
+		}
+	}
+
+	// A null argument gives null without calling the function
+	calls = 0
+	if got, err := expr.MustCompile(`UDF_SCALE(BONUS, 2)`, env).Eval(); err != nil || got != nil || calls != 0 {
+		t.Errorf("UDF_SCALE(BONUS, 2) = %v, %v after %d calls, want nil without a call", got, err, calls)
+	}
+
+	// Types are checked at compile time
+	types := []struct {
+		source string
+		typ    expr.Type
+		length int
+	}{
+		{`UDF_INITIALS(NAME)`, expr.Character, 0},
+		{`PADR(UDF_INITIALS(NAME), 4)`, expr.Character, 4},
+		{`UDF_SCALE(PAY, 2)`, expr.Numeric, 20},
+		{`UDF_COUNT(NAME)`, expr.Integer, 10},
+		{`UDF_WEEKEND(HIRED)`, expr.Logical, 1},
+	}
+	for _, tt := range types {
+		prog := expr.MustCompile(tt.source, env)
+		if prog.Type() != tt.typ || prog.Len() != tt.length {
+			t.Errorf("%s: type %s length %d, want %s length %d", tt.source, prog.Type(), prog.Len(), tt.typ, tt.length)
+		}
+	}
+
+	compileErrors := []struct {
+		source string
+		want   string
+	}{
+		{`UDF_INITIALS()`, "function UDF_INITIALS: takes 1 argument, got 0"},
+		{`UDF_INITIALS(NAME, FIRST)`, "function UDF_INITIALS: takes 1 argument, got 2"},
+		{`UDF_INITIALS(AGE)`, "function UDF_INITIALS: argument 1 must be character, not numeric"},
+		{`UDF_SCALE(PAY, "2")`, "function UDF_SCALE: argument 2 must be numeric, not character"},
+		{`UDF_WEEKEND(NAME)`, "function UDF_WEEKEND: argument 1 must be date, not character"},
+		{`UDF_INITIALS(NAME) + 1`, "operator/operand type mismatch"},
+	}
+	for _, tt := range compileErrors {
+		if _, err := expr.Compile(tt.source, env); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("Compile(%s) error = %v, want %q", tt.source, err, tt.want)
+		}
+	}
+
+	evalErrors := []struct {
+		source string
+		want   string
+	}{
+		{`UDF_FAIL()`, "UDF_FAIL: no such customer"},
+		{`UDF_WRONG()`, "UDF_WRONG: cannot use int as a C value"},
+	}
+	for _, tt := range evalErrors {
+		if _, err := expr.MustCompile(tt.source, env).Eval(); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("Eval(%s) error = %v, want %q", tt.source, err, tt.want)
+		}
+	}
+
+	registerErrors := []struct {
+		name     string
+		fn       expr.Func
+		argTypes []expr.Type
+		result   expr.Type
+		want     string
+	}{
+		{"UPPER", initials, []expr.Type{expr.Character}, expr.Character, "function UPPER is built in"},
+		{"my key", initials, nil, expr.Character, "invalid function name"},
+		{"1KEY", initials, nil, expr.Character, "invalid function name"},
+		{"AND", initials, nil, expr.Character, "invalid function name"},
+		{"UDF_NIL", nil, nil, expr.Character, "nil function"},
+		{"UDF_NULL", initials, nil, expr.Null, "invalid result type X"},
+		{"UDF_ARG", initials, []expr.Type{expr.Character, 'Q'}, expr.Character, "argument 2 has invalid type Q"},
+	}
+	for _, tt := range registerErrors {
+		if err := expr.RegisterFunc(tt.name, tt.fn, tt.argTypes, tt.result); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("RegisterFunc(%s) error = %v, want %q", tt.name, err, tt.want)
+		}
+	}
+
+	// Registering again replaces the function for later compiles
+	before := expr.MustCompile(`UDF_INITIALS("a b")`, env)
+	must(expr.RegisterFunc("UDF_INITIALS", func(args []any) (any, error) {
+		return strings.ToLower(args[0].(string)), nil
+	}, []expr.Type{expr.Character}, expr.Character))
+	after := expr.MustCompile(`UDF_INITIALS("A B")`, env)
+	if got, _ := before.Eval(); got != "AB" {
+		t.Errorf("earlier program = %v, want AB", got)
+	}
+	if got, _ := after.Eval(); got != "a b" {
+		t.Errorf("later program = %v, want a b", got)
+	}
+}
+
+// TestRegisterFuncIndex indexes a table on registered functions, which the
+// pure Go backend evaluates for tag keys and filters like any expression
+func TestRegisterFuncIndex(t *testing.T) {
+	if err := expr.RegisterFunc("TAG_INITIALS", initials, []expr.Type{expr.Character}, expr.Character); err != nil {
+		t.Fatal(err)
+	}
+	if err := expr.RegisterFunc("TAG_VIP", func(args []any) (any, error) {
+		return args[0].(float64) >= 1000, nil
+	}, []expr.Type{expr.Numeric}, expr.Logical); err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(t.TempDir(), "clients.dbf")
+	f, err := foxi.Create(path, foxi.Schema{
+		Fields: []foxi.FieldSpec{
+			{Name: "CUSTOMER", Type: foxi.FTCharacter, Size: 20},
+			{Name: "SPEND", Type: foxi.FTNumeric, Size: 10, Decimals: 2},
+		},
+		Tags: []foxi.TagSpec{
+			{Name: "initials", Expression: "PADR(TAG_INITIALS(CUSTOMER), 4)"},
+			{Name: "vip", Expression: "CUSTOMER", Filter: "TAG_VIP(SPEND)"},
+		},
+	}, nil)
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	defer f.Close()
+
+	rows := []struct {
+		customer string
+		spend    float64
+	}{
+		{"zoe quinn", 1500},
+		{"Ada Lovelace King", 20},
+		{"bob", 1000},
+		{"Carl Friedrich Gauss", 999.99},
+	}
+	for _, r := range rows {
+		f.MustAppend()
+		f.FieldByName("customer").MustSetString(r.customer)
+		f.FieldByName("spend").MustSetFloat(r.spend)
+		f.MustWrite()
+	}
+
+	check := func(when string) {
+		t.Helper()
+		tag := f.Indexes().TagByName("initials")
+		records, keys := tagKeys(t, f, tag)
+		if want := []int{2, 3, 4, 1}; !reflect.DeepEqual(records, want) {
+			t.Errorf("%s: initials order = %v, want %v", when, records, want)
+		}
+		if want := []string{"ALK ", "B   ", "CFG ", "ZQ  "}; !reflect.DeepEqual(keys, want) {
+			t.Errorf("%s: initials keys = %q, want %q", when, keys, want)
+		}
+		if records, _ := tagKeys(t, f, f.Indexes().TagByName("vip")); !reflect.DeepEqual(records, []int{3, 1}) {
+			t.Errorf("%s: vip records = %v, want [3 1]", when, records)
+		}
+	}
+	check("after appending")
+	f.Indexes().MustReindex()
+	check("after reindexing")
+
+	// A character result without a fixed length cannot be a key
+	err = f.Indexes().CreateTag("bad", "TAG_INITIALS(CUSTOMER)", "", false, false)
+	want := "tag bad: key expression TAG_INITIALS(CUSTOMER) has no fixed length; wrap it in PADR(TAG_INITIALS(CUSTOMER), n)"
+	if err == nil || err.Error() != want {
+		t.Errorf("CreateTag on a result without a length = %v, want %q", err, want)
+	}
+}
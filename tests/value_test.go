@@ -0,0 +1,130 @@
+package tests
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/mkfoss/foxi"
+)
+
+// createValueTable creates a table with a field of every type Create
+// supports
+func createValueTable(t *testing.T) (*foxi.Foxi, string) {
+	t.Helper()
+	return createTable(t, "values", foxi.Schema{
+		Fields: []foxi.FieldSpec{
+			{Name: "CHAR", Type: foxi.FTCharacter, Size: 8},
+			{Name: "VCHAR", Type: foxi.FTVarchar, Size: 10},
+			{Name: "VBIN", Type: foxi.FTVarBinary, Size: 6},
+			{Name: "NUM", Type: foxi.FTNumeric, Size: 8, Decimals: 2},
+			{Name: "FLT", Type: foxi.FTFloat, Size: 10, Decimals: 3},
+			{Name: "DATE", Type: foxi.FTDate},
+			{Name: "STAMP", Type: foxi.FTDateTime},
+			{Name: "FLAG", Type: foxi.FTLogical},
+			{Name: "INT", Type: foxi.FTInteger},
+			{Name: "MONEY", Type: foxi.FTCurrency},
+			{Name: "DBL", Type: foxi.FTDouble, Decimals: 4},
+			{Name: "NOTES", Type: foxi.FTMemo},
+			{Name: "OLE", Type: foxi.FTGeneral},
+			{Name: "DATA", Type: foxi.FTTimestamp}, // W, a blob
+		},
+	}, nil)
+}
+
+func TestValueTypes(t *testing.T) {
+	f, path := createValueTable(t)
+
+	stamp := time.Date(2024, 2, 29, 13, 45, 30, 250*int(time.Millisecond), time.UTC)
+	f.MustAppend()
+	f.FieldByName("char").MustSetString("abc")
+	f.FieldByName("vchar").MustSetString("short  ")
+	f.FieldByName("vbin").MustSetString("\x00\x01\x02")
+	f.FieldByName("num").MustSetFloat(-12.5)
+	f.FieldByName("flt").MustSetFloat(3.125)
+	f.FieldByName("date").MustSetTime(time.Date(2023, 12, 31, 0, 0, 0, 0, time.UTC))
+	f.FieldByName("stamp").MustSetTime(stamp)
+	f.FieldByName("flag").MustSetBool(true)
+	f.FieldByName("int").MustSetInt(-70000)
+	f.FieldByName("money").MustSetFloat(1234.5678)
+	f.FieldByName("dbl").MustSetFloat(2.718281828)
+	f.FieldByName("notes").MustSetString("memo text")
+	f.FieldByName("ole").MustSetString("\x01OLE")
+	f.FieldByName("data").MustSetString("\xff\x00blob")
+	f.MustWrite()
+
+	// A value as wide as the varchar field has no length byte
+	f.MustAppend()
+	f.FieldByName("vchar").MustSetString("0123456789")
+	f.FieldByName("vbin").MustSetString("abcdef")
+	f.MustWrite()
+
+	f.MustAppend()
+	f.MustWrite()
+	f.Close()
+
+	f = foxi.NewFoxi()
+	f.MustOpen(path)
+	defer f.Close()
+	f.MustGoto(1)
+
+	want := map[string]interface{}{
+		"char":  "abc     ",
+		"vchar": "short  ",
+		"vbin":  []byte{0, 1, 2},
+		"num":   -12.5,
+		"flt":   3.125,
+		"date":  time.Date(2023, 12, 31, 0, 0, 0, 0, time.UTC),
+		"stamp": stamp,
+		"flag":  true,
+		"int":   int32(-70000),
+		"money": 1234.5678,
+		"dbl":   2.718281828,
+		"notes": "memo text",
+		"ole":   []byte("\x01OLE"),
+		"data":  []byte("\xff\x00blob"),
+	}
+	for name, value := range want {
+		if got := f.FieldByName(name).MustValue(); !reflect.DeepEqual(got, value) {
+			t.Errorf("%s = %#v (%T), want %#v (%T)", name, got, got, value, value)
+		}
+	}
+	if got := f.FieldByName("stamp").MustAsTime(); !got.Equal(stamp) {
+		t.Errorf("AsTime = %v, want %v", got, stamp)
+	}
+	if got := f.FieldByName("vchar").MustAsString(); got != "short  " {
+		t.Errorf("varchar AsString = %q, want %q", got, "short  ")
+	}
+
+	f.MustGoto(2)
+	if got := f.FieldByName("vchar").MustValue(); got != "0123456789" {
+		t.Errorf("full varchar = %#v, want %q", got, "0123456789")
+	}
+	if got := f.FieldByName("vbin").MustValue(); !reflect.DeepEqual(got, []byte("abcdef")) {
+		t.Errorf("full varbinary = %#v, want %q", got, "abcdef")
+	}
+
+	// Blank varchars are empty, blank dates and datetimes the zero time and
+	// blank numbers zero
+	f.MustGoto(3)
+	if got := f.FieldByName("vchar").MustValue(); got != "" {
+		t.Errorf("blank varchar = %#v, want empty", got)
+	}
+	if got := f.FieldByName("vbin").MustValue(); !reflect.DeepEqual(got, []byte{}) {
+		t.Errorf("blank varbinary = %#v, want empty", got)
+	}
+	for _, name := range []string{"date", "stamp"} {
+		if got := f.FieldByName(name).MustValue(); !got.(time.Time).IsZero() {
+			t.Errorf("blank %s = %v, want the zero time", name, got)
+		}
+		if got, err := f.FieldByName(name).AsTime(); err != nil || !got.IsZero() {
+			t.Errorf("blank %s AsTime = %v, %v, want the zero time", name, got, err)
+		}
+	}
+	if got := f.FieldByName("num").MustValue(); got != 0.0 {
+		t.Errorf("blank num = %#v, want 0", got)
+	}
+	if got := f.FieldByName("notes").MustValue(); got != "" {
+		t.Errorf("blank notes = %#v, want empty", got)
+	}
+}
@@ -0,0 +1,449 @@
+package tests
+
+import (
+	"encoding/binary"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mkfoss/foxi"
+	pkg "github.com/mkfoss/foxi/pkg/gocore"
+)
+
+// vfpField describes a field used to build a test table by hand
+type vfpField struct {
+	name     string
+	typ      byte
+	size     uint8
+	decimals uint8
+	flags    byte
+}
+
+// createVFPTable writes an empty Visual FoxPro table (and an FPT when the
+// table has memo fields) to dir and returns the path of the DBF file.
+func createVFPTable(t *testing.T, dir string, name string, fields []vfpField) string {
+	t.Helper()
+
+	nullable := 0
+	hasMemo := false
+	for _, fd := range fields {
+		if fd.flags&0x02 != 0 {
+			nullable++
+		}
+		if fd.typ == 'M' {
+			hasMemo = true
+		}
+	}
+	if nullable > 0 {
+		fields = append(fields, vfpField{name: "_NullFlags", typ: '0', size: uint8((nullable + 7) / 8), flags: 0x05})
+	}
+
+	recordLen := 1
+	for _, fd := range fields {
+		recordLen += int(fd.size)
+	}
+	headerLen := 32 + 32*len(fields) + 1 + 263
+
+	buf := make([]byte, headerLen+1)
+	buf[0] = 0x30
+	buf[1], buf[2], buf[3] = 125, 1, 1
+	binary.LittleEndian.PutUint16(buf[8:], uint16(headerLen))
+	binary.LittleEndian.PutUint16(buf[10:], uint16(recordLen))
+	if hasMemo {
+		buf[28] = 0x02
+	}
+	buf[29] = 0x03
+
+	offset := 1
+	for i, fd := range fields {
+		desc := buf[32+32*i : 64+32*i]
+		copy(desc[0:11], fd.name)
+		desc[11] = fd.typ
+		binary.LittleEndian.PutUint32(desc[12:], uint32(offset))
+		desc[16] = fd.size
+		desc[17] = fd.decimals
+		desc[18] = fd.flags
+		offset += int(fd.size)
+	}
+	buf[32+32*len(fields)] = 0x0D
+	buf[headerLen] = 0x1A
+
+	path := filepath.Join(dir, name+".dbf")
+	if err := os.WriteFile(path, buf, 0o644); err != nil {
+		t.Fatalf("failed to write table: %v", err)
+	}
+
+	if hasMemo {
+		memo := make([]byte, 512)
+		binary.BigEndian.PutUint32(memo[0:], 8)
+		binary.BigEndian.PutUint16(memo[6:], 64)
+		if err := os.WriteFile(filepath.Join(dir, name+".fpt"), memo, 0o644); err != nil {
+			t.Fatalf("failed to write memo file: %v", err)
+		}
+	}
+
+	return path
+}
+
+func writeTestFields() []vfpField {
+	return []vfpField{
+		{name: "NAME", typ: 'C', size: 20},
+		{name: "AMOUNT", typ: 'N', size: 10, decimals: 2},
+		{name: "BORN", typ: 'D', size: 8},
+		{name: "ACTIVE", typ: 'L', size: 1},
+		{name: "QTY", typ: 'I', size: 4, flags: 0x04},
+		{name: "PRICE", typ: 'Y', size: 8, decimals: 4, flags: 0x04},
+		{name: "RATIO", typ: 'B', size: 8, decimals: 2, flags: 0x04},
+		{name: "STAMP", typ: 'T', size: 8, flags: 0x04},
+		{name: "NOTES", typ: 'M', size: 4, flags: 0x04},
+		{name: "NICK", typ: 'C', size: 10, flags: 0x02},
+	}
+}
+
+func TestAppendAndReadBack(t *testing.T) {
+	path := createVFPTable(t, t.TempDir(), "people", writeTestFields())
+
+	born := time.Date(1984, time.March, 7, 0, 0, 0, 0, time.UTC)
+	stamp := time.Date(2024, time.July, 1, 13, 45, 30, 0, time.UTC)
+
+	f := foxi.NewFoxi()
+	if err := f.Open(path); err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+
+	if err := f.Append(); err != nil {
+		t.Fatalf("Append failed: %v", err)
+	}
+	if f.Position() != 1 {
+		t.Errorf("expected position 1 after append, got %d", f.Position())
+	}
+
+	f.FieldByName("name").MustSetString("Alice")
+	f.FieldByName("amount").MustSetFloat(1234.5)
+	f.FieldByName("born").MustSetTime(born)
+	f.FieldByName("active").MustSetBool(true)
+	f.FieldByName("qty").MustSetInt(42)
+	f.FieldByName("price").MustSetFloat(19.99)
+	f.FieldByName("ratio").MustSetFloat(0.125)
+	f.FieldByName("stamp").MustSetTime(stamp)
+	f.FieldByName("notes").MustSetString("first memo entry")
+	f.FieldByName("nick").MustSetNull()
+	f.MustWrite()
+
+	f.MustAppend()
+	f.FieldByName("name").MustSetString("Bob")
+	f.FieldByName("qty").MustSetInt(-7)
+	f.FieldByName("nick").MustSetString("bobby")
+	f.FieldByName("notes").MustSetString("second")
+	f.MustWrite()
+
+	if err := f.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	f = foxi.NewFoxi()
+	f.MustOpen(path)
+	defer f.Close()
+
+	header := f.Header()
+	if count := header.RecordCount(); count != 2 {
+		t.Fatalf("expected 2 records, got %d", count)
+	}
+
+	f.MustGoto(1)
+	if got := strings.TrimSpace(f.FieldByName("name").MustAsString()); got != "Alice" {
+		t.Errorf("NAME = %q, want %q", got, "Alice")
+	}
+	if got := f.FieldByName("amount").MustAsFloat(); got != 1234.5 {
+		t.Errorf("AMOUNT = %v, want 1234.5", got)
+	}
+	if got := f.FieldByName("born").MustAsTime(); !got.Equal(born) {
+		t.Errorf("BORN = %v, want %v", got, born)
+	}
+	if !f.FieldByName("active").MustAsBool() {
+		t.Error("ACTIVE should be true")
+	}
+	if got := f.FieldByName("qty").MustAsInt(); got != 42 {
+		t.Errorf("QTY = %d, want 42", got)
+	}
+	if got := f.FieldByName("price").MustAsFloat(); got != 19.99 {
+		t.Errorf("PRICE = %v, want 19.99", got)
+	}
+	if got := f.FieldByName("ratio").MustAsFloat(); got != 0.125 {
+		t.Errorf("RATIO = %v, want 0.125", got)
+	}
+	if got := f.FieldByName("stamp").MustAsTime(); !got.Equal(stamp) {
+		t.Errorf("STAMP = %v, want %v", got, stamp)
+	}
+	if got := f.FieldByName("notes").MustAsString(); got != "first memo entry" {
+		t.Errorf("NOTES = %q, want %q", got, "first memo entry")
+	}
+	if !f.FieldByName("nick").MustIsNull() {
+		t.Error("NICK should be null")
+	}
+
+	f.MustGoto(2)
+	if got := strings.TrimSpace(f.FieldByName("name").MustAsString()); got != "Bob" {
+		t.Errorf("NAME = %q, want %q", got, "Bob")
+	}
+	if got := f.FieldByName("qty").MustAsInt(); got != -7 {
+		t.Errorf("QTY = %d, want -7", got)
+	}
+	if f.FieldByName("nick").MustIsNull() {
+		t.Error("NICK should not be null")
+	}
+	if got := strings.TrimSpace(f.FieldByName("nick").MustAsString()); got != "bobby" {
+		t.Errorf("NICK = %q, want %q", got, "bobby")
+	}
+	if got := f.FieldByName("notes").MustAsString(); got != "second" {
+		t.Errorf("NOTES = %q, want %q", got, "second")
+	}
+}
+
+func TestUpdateExistingRecord(t *testing.T) {
+	path := createVFPTable(t, t.TempDir(), "update", writeTestFields())
+
+	f := foxi.NewFoxi()
+	f.MustOpen(path)
+	f.MustAppend()
+	f.FieldByName("name").MustSetString("before")
+	f.FieldByName("notes").MustSetString("short")
+	f.MustWrite()
+
+	f.MustFirst()
+	f.FieldByName("name").MustSetString("after")
+	f.FieldByName("notes").MustSetString("a much longer memo that no longer fits in the original block size of sixty four bytes")
+	// Moving off the record flushes pending changes, as in CodeBase
+	f.MustGoto(1)
+	f.Close()
+
+	f = foxi.NewFoxi()
+	f.MustOpen(path)
+	defer f.Close()
+	f.MustFirst()
+
+	if got := strings.TrimSpace(f.FieldByName("name").MustAsString()); got != "after" {
+		t.Errorf("NAME = %q, want %q", got, "after")
+	}
+	if got := f.FieldByName("notes").MustAsString(); len(got) < 64 {
+		t.Errorf("NOTES was not rewritten, got %q", got)
+	}
+	header := f.Header()
+	if count := header.RecordCount(); count != 1 {
+		t.Errorf("expected 1 record, got %d", count)
+	}
+}
+
+func TestSetterTypeErrors(t *testing.T) {
+	path := createVFPTable(t, t.TempDir(), "errors", writeTestFields())
+
+	f := foxi.NewFoxi()
+	f.MustOpen(path)
+	defer f.Close()
+	f.MustAppend()
+
+	if err := f.FieldByName("active").SetTime(time.Now()); err == nil {
+		t.Error("SetTime on a logical field should fail")
+	}
+	if err := f.FieldByName("born").SetBool(true); err == nil {
+		t.Error("SetBool on a date field should fail")
+	}
+	if err := f.FieldByName("name").SetNull(); err == nil {
+		t.Error("SetNull on a non-nullable field should fail")
+	}
+	if err := f.FieldByName("qty").SetInt(1 << 40); err == nil {
+		t.Error("SetInt outside the int32 range should fail")
+	}
+}
+
+func TestSetterRejectsBadValues(t *testing.T) {
+	path := createVFPTable(t, t.TempDir(), "bad", []vfpField{
+		{name: "PAY", typ: 'N', size: 5, decimals: 2},
+		{name: "RATE", typ: 'F', size: 6, decimals: 1},
+		{name: "BORN", typ: 'D', size: 8},
+		{name: "ACTIVE", typ: 'L', size: 1},
+	})
+
+	f := foxi.NewFoxi()
+	f.MustOpen(path)
+	defer f.Close()
+	f.MustAppend()
+
+	pay, rate := f.FieldByName("pay"), f.FieldByName("rate")
+	born, active := f.FieldByName("born"), f.FieldByName("active")
+	pay.MustSetFloat(12.5)
+	rate.MustSetFloat(-3.5)
+	born.MustSetString("2024-02-29")
+	active.MustSetBool(true)
+
+	bad := map[string]error{
+		"SetFloat(12345.67)":    pay.SetFloat(12345.67),
+		"SetFloat(99.999)":      pay.SetFloat(99.999),
+		"SetInt(100)":           pay.SetInt(100),
+		"SetFloat(NaN)":         pay.SetFloat(math.NaN()),
+		"SetFloat(+Inf)":        rate.SetFloat(math.Inf(1)),
+		"SetFloat(-Inf)":        rate.SetFloat(math.Inf(-1)),
+		"SetString(abc)":        pay.SetString("abc"),
+		"SetString(NaN)":        rate.SetString("NaN"),
+		"SetString(123456)":     rate.SetString("123456"),
+		"SetString(garbage)":    born.SetString("garbage"),
+		"SetString(2024-02-30)": born.SetString("2024-02-30"),
+		"SetString(xyz)":        active.SetString("xyz"),
+	}
+	for op, err := range bad {
+		if err == nil {
+			t.Errorf("%s succeeded", op)
+		}
+	}
+
+	// A rejected value leaves the field as it was
+	f.MustWrite()
+	f.MustGoto(1)
+	if got := pay.MustAsString(); got != "12.50" {
+		t.Errorf("PAY = %q, want %q", got, "12.50")
+	}
+	if got := strings.TrimSpace(rate.MustAsString()); got != "-3.5" {
+		t.Errorf("RATE = %q, want -3.5", got)
+	}
+	if got := born.MustAsTime(); !got.Equal(time.Date(2024, time.February, 29, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("BORN = %v", got)
+	}
+	if !active.MustAsBool() {
+		t.Error("ACTIVE was changed")
+	}
+
+	// Values that fit are rounded to the field's decimals
+	pay.MustSetFloat(99.994)
+	rate.MustSetString("1e3")
+	if got := pay.MustAsFloat(); got != 99.99 {
+		t.Errorf("PAY = %v, want 99.99", got)
+	}
+	if got := rate.MustAsFloat(); got != 1000 {
+		t.Errorf("RATE = %v, want 1000", got)
+	}
+	pay.MustSetString("")
+	if got := pay.MustAsString(); strings.TrimSpace(got) != "" {
+		t.Errorf("blank PAY = %q", got)
+	}
+}
+
+func TestWriteWithoutRecord(t *testing.T) {
+	f := foxi.NewFoxi()
+	if err := f.Write(); err == nil {
+		t.Error("Write on a closed table should fail")
+	}
+	if err := f.Append(); err == nil {
+		t.Error("Append on a closed table should fail")
+	}
+}
+
+func TestF4AssignRejectsBadValues(t *testing.T) {
+	path := createVFPTable(t, t.TempDir(), "assign", []vfpField{
+		{name: "PAY", typ: 'N', size: 5, decimals: 2},
+		{name: "BORN", typ: 'D', size: 8},
+		{name: "ACTIVE", typ: 'L', size: 1},
+		{name: "NAME", typ: 'C', size: 5},
+	})
+
+	codeBase := &pkg.Code4{}
+	pkg.Code4Init(codeBase)
+	defer pkg.Code4InitUndo(codeBase)
+	data := pkg.D4Open(codeBase, path)
+	if data == nil {
+		t.Fatalf("D4Open failed: %d", codeBase.ErrorCode)
+	}
+	if rc := pkg.D4AppendStart(data, 0); rc != pkg.ErrorNone {
+		t.Fatalf("D4AppendStart failed: %d", rc)
+	}
+
+	pay, born, active := pkg.D4Field(data, "PAY"), pkg.D4Field(data, "BORN"), pkg.D4Field(data, "ACTIVE")
+	name := pkg.D4Field(data, "NAME")
+	pkg.F4Assign(pay, "1.5")
+	pkg.F4Assign(born, "20240229")
+	pkg.F4Assign(active, "T")
+	pkg.F4Assign(name, "short")
+
+	cases := []struct {
+		field *pkg.Field4
+		value string
+	}{
+		{pay, "abc"}, {pay, "12345.67"}, {pay, "99.999"}, {pay, "NaN"}, {pay, "-Inf"},
+		{born, "garbage"}, {born, "2024022x"}, {born, "20240230"},
+		{active, "xyz"},
+		{name, "toolongname"}, {name, "short!"},
+	}
+	for _, c := range cases {
+		if rc := pkg.F4Assign(c.field, c.value); rc != pkg.ErrorData {
+			t.Errorf("F4Assign(%s, %q) = %d, want ErrorData", pkg.F4Name(c.field), c.value, rc)
+		}
+	}
+	for _, value := range []float64{math.NaN(), math.Inf(1), 1e6} {
+		if rc := pkg.F4AssignDouble(pay, value); rc != pkg.ErrorData {
+			t.Errorf("F4AssignDouble(%v) = %d, want ErrorData", value, rc)
+		}
+	}
+
+	if got := pkg.F4Str(pay); got != " 1.50" {
+		t.Errorf("PAY = %q, want %q", got, " 1.50")
+	}
+	if got := pkg.F4Str(born); got != "20240229" {
+		t.Errorf("BORN = %q, want %q", got, "20240229")
+	}
+	if !pkg.F4True(active) {
+		t.Error("ACTIVE was changed")
+	}
+	if got := pkg.F4Str(name); got != "short" {
+		t.Errorf("NAME = %q, want %q", got, "short")
+	}
+
+	// Blanks past the width are the field's padding
+	if rc := pkg.F4Assign(name, "abc    "); rc != pkg.ErrorNone {
+		t.Errorf("F4Assign with trailing blanks = %d, want ErrorNone", rc)
+	}
+	if got := pkg.F4Str(name); got != "abc  " {
+		t.Errorf("NAME = %q, want %q", got, "abc  ")
+	}
+}
+
+func TestF4AssignDoubleCurrencyOutOfRange(t *testing.T) {
+	path := createVFPTable(t, t.TempDir(), "money", []vfpField{
+		{name: "PRICE", typ: 'Y', size: 8, decimals: 4, flags: 0x02},
+	})
+
+	codeBase := &pkg.Code4{}
+	pkg.Code4Init(codeBase)
+	defer pkg.Code4InitUndo(codeBase)
+	data := pkg.D4Open(codeBase, path)
+	if data == nil {
+		t.Fatalf("D4Open failed: %d", codeBase.ErrorCode)
+	}
+	if rc := pkg.D4AppendStart(data, 0); rc != pkg.ErrorNone {
+		t.Fatalf("D4AppendStart failed: %d", rc)
+	}
+	price := pkg.D4Field(data, "PRICE")
+	pkg.F4AssignNull(price)
+	if rc := pkg.D4Append(data); rc != pkg.ErrorNone {
+		t.Fatalf("D4Append failed: %d", rc)
+	}
+
+	// A value out of range fails without touching the field or the record
+	if rc := pkg.F4AssignDouble(price, 1e300); rc != pkg.ErrorData {
+		t.Errorf("F4AssignDouble(1e300) = %d, want ErrorData", rc)
+	}
+	if !pkg.F4Null(price) {
+		t.Error("PRICE is no longer null")
+	}
+	if pkg.D4Changed(data) {
+		t.Error("record marked changed by a failed assignment")
+	}
+
+	if rc := pkg.F4AssignDouble(price, 12.5); rc != pkg.ErrorNone {
+		t.Errorf("F4AssignDouble(12.5) = %d, want ErrorNone", rc)
+	}
+	if pkg.F4Null(price) || !pkg.D4Changed(data) {
+		t.Error("assigned PRICE should be non-null and the record changed")
+	}
+}
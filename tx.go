@@ -0,0 +1,93 @@
+package foxi
+
+import "fmt"
+
+// Tx is a transaction started with Foxi.Begin.
+//
+// Every append, field change, delete and recall made through the Foxi
+// while the transaction is open is either kept as a whole by Commit or
+// undone as a whole by Rollback, including the matching entries in the
+// table's CDX tags. A Tx started with Session.Begin covers every table of
+// the session the same way. A Tx must not be used after Commit or
+// Rollback.
+//
+// Before-images of changed records are journalled beside the table, so a
+// transaction cut short by a crash is rolled back when the table is next
+// opened.
+type Tx struct {
+	f       *Foxi
+	session *Session // Set for a transaction started with Session.Begin
+	done    bool
+}
+
+// Begin starts a transaction on the table.
+//
+// Pending changes to the current record are written before the
+// transaction starts so they are not part of it. Only one transaction
+// can be open at a time; closing the table rolls back an open
+// transaction. The transaction of a table opened in a Session is the
+// session's, as if started with Session.Begin.
+func (f *Foxi) Begin() (*Tx, error) {
+	if err := f.impl.Begin(); err != nil {
+		return nil, err
+	}
+	return &Tx{f: f, session: f.session}, nil
+}
+
+// Commit makes the changes made during the transaction permanent.
+// Pending changes to the current record are written first. If they
+// cannot be written or the tables cannot be flushed, Commit returns the
+// error and the transaction stays open, to be rolled back.
+func (tx *Tx) Commit() error {
+	if tx.done {
+		return fmt.Errorf("transaction already finished")
+	}
+	if err := tx.f.impl.Commit(); err != nil {
+		return err
+	}
+	tx.done = true
+	return nil
+}
+
+// Rollback undoes every change made during the transaction, including
+// pending changes to the current record, and re-reads the current record.
+func (tx *Tx) Rollback() error {
+	if tx.done {
+		return fmt.Errorf("transaction already finished")
+	}
+	tx.done = true
+	if tx.session != nil {
+		for _, f := range tx.session.Tables() {
+			f.impl.recordFilter().dropPlan()
+		}
+	} else {
+		tx.f.impl.recordFilter().dropPlan()
+	}
+	return tx.f.impl.Rollback()
+}
+
+// MustBegin starts a transaction on the table.
+// Panics if the operation fails.
+func (f *Foxi) MustBegin() *Tx {
+	tx, err := f.Begin()
+	if err != nil {
+		panic(err)
+	}
+	return tx
+}
+
+// MustCommit makes the changes made during the transaction permanent.
+// Panics if the operation fails.
+func (tx *Tx) MustCommit() {
+	if err := tx.Commit(); err != nil {
+		panic(err)
+	}
+}
+
+// MustRollback undoes every change made during the transaction.
+// Panics if the operation fails.
+func (tx *Tx) MustRollback() {
+	if err := tx.Rollback(); err != nil {
+		panic(err)
+	}
+}
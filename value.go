@@ -0,0 +1,157 @@
+package foxi
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Both backends hand the stored form of a field to storedValue, so values
+// are decoded the same way whichever backend is compiled in.
+
+// storedField gives the shared decoding access to the stored form of a
+// field of the current record
+type storedField interface {
+	Field
+
+	// stored returns the bytes of the field in the record, varchar and
+	// varbinary values cut to their length
+	stored() []byte
+
+	// memo returns the contents of a memo, general, picture or blob field
+	memo() []byte
+
+	// textCodec returns the code page translation of the table
+	textCodec() *textCodec
+}
+
+// julianUnixEpoch is the Julian day number of 1970-01-01
+const julianUnixEpoch = 2440588
+
+// storedValue decodes a field to the value Field.Value returns
+func storedValue(f storedField) (interface{}, error) {
+	if f.IsNullable() {
+		if null, err := f.IsNull(); err != nil || null {
+			return nil, err
+		}
+	}
+
+	switch f.Type() {
+	case FTCharacter, FTVarchar:
+		return storedText(f, f.stored()), nil
+	case FTMemo:
+		return storedText(f, f.memo()), nil
+	case FTVarBinary:
+		return bytes.Clone(f.stored()), nil
+	case FTGeneral, FTPicture, FTTimestamp:
+		return bytes.Clone(f.memo()), nil
+	case FTLogical:
+		raw := f.stored()
+		return len(raw) > 0 && strings.IndexByte("TtYy", raw[0]) >= 0, nil
+	case FTDate:
+		return storedDate(f, f.stored())
+	case FTDateTime:
+		return storedDateTime(f, f.stored())
+	case FTInteger:
+		raw := f.stored()
+		if len(raw) != 4 {
+			n, err := storedNumber(f, raw)
+			return int32(n), err
+		}
+		return int32(binary.LittleEndian.Uint32(raw)), nil
+	case FTCurrency:
+		raw := f.stored()
+		if len(raw) != 8 {
+			return storedNumber(f, raw)
+		}
+		return float64(int64(binary.LittleEndian.Uint64(raw))) / 10000, nil
+	case FTBlob, FTDouble:
+		raw := f.stored()
+		if len(raw) != 8 {
+			return storedNumber(f, raw)
+		}
+		return math.Float64frombits(binary.LittleEndian.Uint64(raw)), nil
+	case FTNumeric, FTFloat:
+		return storedNumber(f, f.stored())
+	default:
+		return string(f.stored()), nil
+	}
+}
+
+// storedText decodes the text of a character or memo field from the
+// table's code page
+func storedText(f storedField, raw []byte) string {
+	if f.IsBinary() {
+		return string(raw)
+	}
+	return f.textCodec().decode(string(raw))
+}
+
+// storedNumber parses a number stored as text; a blank number is zero
+func storedNumber(f Field, raw []byte) (float64, error) {
+	text := strings.TrimSpace(string(raw))
+	if text == "" {
+		return 0, nil
+	}
+	n, err := strconv.ParseFloat(text, 64)
+	if err != nil {
+		return 0, fmt.Errorf("field %s: invalid number %q", f.Name(), text)
+	}
+	return n, nil
+}
+
+// storedDate parses a CCYYMMDD date; a blank date is the zero time
+func storedDate(f Field, raw []byte) (time.Time, error) {
+	text := strings.TrimSpace(string(raw))
+	if text == "" || strings.Trim(text, "0") == "" {
+		return time.Time{}, nil
+	}
+	t, err := time.Parse("20060102", text)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("field %s: invalid date %q", f.Name(), text)
+	}
+	return t, nil
+}
+
+// storedDateTime decodes a Julian day followed by milliseconds since
+// midnight; a blank datetime is the zero time
+func storedDateTime(f Field, raw []byte) (time.Time, error) {
+	if len(raw) != 8 {
+		return time.Time{}, fmt.Errorf("field %s: invalid datetime of %d bytes", f.Name(), len(raw))
+	}
+	julian := int64(int32(binary.LittleEndian.Uint32(raw[0:4])))
+	millis := int64(int32(binary.LittleEndian.Uint32(raw[4:8])))
+	if julian <= 0 {
+		return time.Time{}, nil
+	}
+	seconds := (julian - julianUnixEpoch) * 86400
+	return time.Unix(seconds, 0).UTC().Add(time.Duration(millis) * time.Millisecond), nil
+}
+
+// storedTime decodes a field for Field.AsTime. Fields other than datetime
+// fields are read as CCYYMMDD dates.
+func storedTime(f storedField) (time.Time, error) {
+	if f.Type() == FTDateTime {
+		return storedDateTime(f, f.stored())
+	}
+	return storedDate(f, f.stored())
+}
+
+// storedString returns the text of a field for Field.AsString: the
+// decoded text of character and memo fields, the contents of binary memo
+// fields and the stored bytes of the other types
+func storedString(f storedField) string {
+	switch f.Type() {
+	case FTCharacter, FTVarchar:
+		return storedText(f, f.stored())
+	case FTMemo:
+		return storedText(f, f.memo())
+	case FTGeneral, FTPicture, FTTimestamp:
+		return string(f.memo())
+	}
+	return string(f.stored())
+}